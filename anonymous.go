@@ -0,0 +1,23 @@
+package jose
+
+import (
+	"errors"
+
+	"github.com/auth0-community/go-auth0"
+)
+
+// AnonymousRole is the role of the requests without token accepted by the endpoints with optional
+// authentication
+const AnonymousRole = "anonymous"
+
+// AnonymousClaims returns the claims of the requests without token when the authentication is optional.
+// They are empty, except for the anonymous role in the roles claim, so the roles required by the endpoint
+// decide if the anonymous requests are authorized. The rolesPath is the RolesPath of the config, computed
+// once by the caller. It returns false if the error is not caused by a missing token or the authentication
+// is not optional, as the invalid tokens are always rejected.
+func AnonymousClaims(scfg *SignatureConfig, rolesPath ClaimPath, err error) (map[string]interface{}, bool) {
+	if !scfg.AuthOptional || !errors.Is(err, auth0.ErrTokenNotFound) {
+		return nil, false
+	}
+	return withClaim(map[string]interface{}{}, rolesPath, []interface{}{AnonymousRole}), true
+}
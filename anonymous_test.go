@@ -0,0 +1,34 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+)
+
+func TestAnonymousClaims(t *testing.T) {
+	scfg := &SignatureConfig{AuthOptional: true, RolesKey: "realm.roles", RolesKeyIsNested: true}
+
+	claims, ok := AnonymousClaims(scfg, scfg.RolesPath(), fmt.Errorf("extracting: %w", auth0.ErrTokenNotFound))
+	if !ok {
+		t.Error("the missing tokens should be anonymous")
+		return
+	}
+	expected := map[string]interface{}{"realm": map[string]interface{}{"roles": []interface{}{AnonymousRole}}}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	if _, ok := AnonymousClaims(scfg, scfg.RolesPath(), errors.New("invalid signature")); ok {
+		t.Error("the invalid tokens should not be anonymous")
+	}
+	if _, ok := AnonymousClaims(scfg, scfg.RolesPath(), nil); ok {
+		t.Error("the valid tokens should not be anonymous")
+	}
+	if _, ok := AnonymousClaims(&SignatureConfig{RolesKey: "roles"}, NewClaimPath("roles", false), auth0.ErrTokenNotFound); ok {
+		t.Error("the missing tokens should not be anonymous when the authentication is required")
+	}
+}
@@ -0,0 +1,121 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/auth0-community/go-auth0"
+)
+
+const defaultAPIKeyHeader = "X-API-Key"
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyConfig  = errors.New("invalid API key config")
+)
+
+// APIKeyConfig enables the API keys as a fallback of the tokens, easing the migration of the legacy
+// consumers. The requests without token sending a known key get the claims of the key, and they go
+// through the same checks and propagation as the tokens.
+type APIKeyConfig struct {
+	// Header is the header carrying the key. Defaults to X-API-Key
+	Header string `json:"header,omitempty"`
+	// QueryParam is the query param carrying the key, if the header is not set
+	QueryParam string   `json:"query_param,omitempty"`
+	Keys       []APIKey `json:"keys"`
+}
+
+// APIKey is an accepted key and the synthetic claims of its requests
+type APIKey struct {
+	// Key is the key. It accepts the references of the config values, as "${API_KEY}"
+	Key string `json:"key,omitempty"`
+	// KeySHA256 is the hex encoded SHA-256 digest of the key, so the key is not in the config
+	KeySHA256 string                 `json:"key_sha256,omitempty"`
+	Claims    map[string]interface{} `json:"claims"`
+}
+
+// APIKeyAuthenticator resolves the claims of the API keys. A nil APIKeyAuthenticator does nothing.
+type APIKeyAuthenticator struct {
+	header     string
+	queryParam string
+	claims     map[[sha256.Size]byte]map[string]interface{}
+}
+
+// NewAPIKeyAuthenticator returns the APIKeyAuthenticator of the config, or nil if there is no config
+func NewAPIKeyAuthenticator(cfg *APIKeyConfig) (*APIKeyAuthenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrAPIKeyConfig)
+	}
+	a := &APIKeyAuthenticator{
+		header:     cfg.Header,
+		queryParam: cfg.QueryParam,
+		claims:     make(map[[sha256.Size]byte]map[string]interface{}, len(cfg.Keys)),
+	}
+	if a.header == "" {
+		a.header = defaultAPIKeyHeader
+	}
+	for i, k := range cfg.Keys {
+		var digest [sha256.Size]byte
+		switch {
+		case k.Key != "" && k.KeySHA256 != "":
+			return nil, fmt.Errorf("%w: key #%d defines both the key and its digest", ErrAPIKeyConfig, i)
+		case k.Key != "":
+			digest = sha256.Sum256([]byte(k.Key))
+		case k.KeySHA256 != "":
+			b, err := hex.DecodeString(k.KeySHA256)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%w: key #%d has an invalid digest", ErrAPIKeyConfig, i)
+			}
+			copy(digest[:], b)
+		default:
+			return nil, fmt.Errorf("%w: key #%d is empty", ErrAPIKeyConfig, i)
+		}
+		a.claims[digest] = k.Claims
+	}
+	return a, nil
+}
+
+// Authenticate returns a copy of the claims of the key sent with the request. The key is removed from the
+// request, so it does not reach the backends. It returns auth0.ErrTokenNotFound if the request has no key.
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (map[string]interface{}, error) {
+	key := strings.TrimSpace(r.Header.Get(a.header))
+	if key != "" {
+		r.Header.Del(a.header)
+	} else if a.queryParam != "" {
+		q := r.URL.Query()
+		key = q.Get(a.queryParam)
+		if key != "" {
+			q.Del(a.queryParam)
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+	if key == "" {
+		return nil, auth0.ErrTokenNotFound
+	}
+
+	claims, ok := a.claims[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	res := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// Fallback authenticates the API key of the requests without token. The result of the token validation
+// is returned as it is if there is a token or the fallback is disabled.
+func (a *APIKeyAuthenticator) Fallback(r *http.Request, claims map[string]interface{}, err error) (map[string]interface{}, error) {
+	if a == nil || !errors.Is(err, auth0.ErrTokenNotFound) {
+		return claims, err
+	}
+	return a.Authenticate(r)
+}
@@ -0,0 +1,88 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+)
+
+func TestAPIKeyAuthenticator_Fallback(t *testing.T) {
+	digest := sha256.Sum256([]byte("legacy-key"))
+	a, err := NewAPIKeyAuthenticator(&APIKeyConfig{
+		QueryParam: "api_key",
+		Keys: []APIKey{
+			{Key: "secret-key", Claims: map[string]interface{}{"sub": "billing", "roles": []interface{}{"admin"}}},
+			{KeySHA256: hex.EncodeToString(digest[:]), Claims: map[string]interface{}{"sub": "legacy"}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := httptest.NewRequest("GET", "/?api_key=legacy-key&page=2", nil)
+	claims, err := a.Fallback(req, nil, auth0.ErrTokenNotFound)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(claims, map[string]interface{}{"sub": "legacy"}) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if q := req.URL.RawQuery; q != "page=2" {
+		t.Errorf("the key should be removed from the query: %s", q)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "secret-key")
+	claims, err = a.Fallback(req, nil, auth0.ErrTokenNotFound)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if claims["sub"] != "billing" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if req.Header.Get("X-API-Key") != "" {
+		t.Error("the key should be removed from the headers")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "unknown")
+	if _, err := a.Fallback(req, nil, auth0.ErrTokenNotFound); err != ErrInvalidAPIKey {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	if _, err := a.Fallback(req, nil, auth0.ErrTokenNotFound); err != auth0.ErrTokenNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tokenErr := errors.New("invalid signature")
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "secret-key")
+	if _, err := a.Fallback(req, nil, tokenErr); err != tokenErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewAPIKeyAuthenticator(t *testing.T) {
+	if a, err := NewAPIKeyAuthenticator(nil); a != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", a, err)
+	}
+	for i, cfg := range []*APIKeyConfig{
+		{},
+		{Keys: []APIKey{{}}},
+		{Keys: []APIKey{{Key: "a", KeySHA256: "b"}}},
+		{Keys: []APIKey{{KeySHA256: "abcd"}}},
+	} {
+		if _, err := NewAPIKeyAuthenticator(cfg); !errors.Is(err, ErrAPIKeyConfig) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	appleAudience = "https://appleid.apple.com"
+
+	// MaxAppleClientSecretLifetime is the longest lifetime of the client secrets accepted by Apple
+	MaxAppleClientSecretLifetime = 15777000 * time.Second
+
+	defaultAppleClientSecretLifetime = 24 * time.Hour
+	defaultAppleClientSecretHeader   = "X-Apple-Client-Secret"
+)
+
+var ErrInvalidAppleClientSecret = errors.New("invalid apple client secret config")
+
+// AppleClientSecretConfig enables the generation of the client secrets required by the Sign in with Apple
+// token endpoint: ES256 JWTs issued by the team, for the client, signed with a key of the team. The secret
+// is regenerated at the half of its lifetime and it is sent to the backends in a header, so they can
+// redeem the authorization codes without the private key.
+type AppleClientSecretConfig struct {
+	// TeamID is the Apple developer team, used as issuer
+	TeamID string `json:"team_id"`
+	// ClientID is the Services ID (or the bundle ID) of the app, used as subject
+	ClientID string `json:"client_id"`
+	// KeyID is the ID of the Sign in with Apple key
+	KeyID string `json:"key_id"`
+	// PrivateKey is the PKCS #8 PEM encoded key downloaded from Apple. It accepts the references of the
+	// config values, as "@/etc/krakend/AuthKey_ABC123DEFG.p8"
+	PrivateKey string `json:"private_key"`
+	// ExpiresIn is the lifetime of the secrets, in seconds or as "24h". Defaults to 24h and it can not
+	// exceed 6 months.
+	ExpiresIn Seconds `json:"expires_in,omitempty"`
+	// Header is the header carrying the secret to the backends. Defaults to X-Apple-Client-Secret
+	Header string `json:"header,omitempty"`
+}
+
+// AppleClientSecret generates and caches the client secrets of an Apple app. A nil AppleClientSecret does
+// nothing.
+type AppleClientSecret struct {
+	mu        sync.Mutex
+	teamID    string
+	clientID  string
+	header    string
+	expiresIn time.Duration
+	sign      Signer
+	secret    string
+	next      time.Time
+}
+
+// NewAppleClientSecret returns the AppleClientSecret of the config, or nil if there is no config
+func NewAppleClientSecret(cfg *AppleClientSecretConfig) (*AppleClientSecret, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.TeamID == "" || cfg.ClientID == "" || cfg.KeyID == "" {
+		return nil, fmt.Errorf("%w: the team_id, the client_id and the key_id are required", ErrInvalidAppleClientSecret)
+	}
+	expiresIn := cfg.ExpiresIn.Duration()
+	if expiresIn == 0 {
+		expiresIn = defaultAppleClientSecretLifetime
+	}
+	if expiresIn > MaxAppleClientSecretLifetime {
+		return nil, fmt.Errorf("%w: the expires_in must be up to 6 months", ErrInvalidAppleClientSecret)
+	}
+
+	block, _ := pem.Decode([]byte(cfg.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%w: the private_key is not PEM encoded", ErrInvalidAppleClientSecret)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAppleClientSecret, err.Error())
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("%w: the private_key is not an EC key", ErrInvalidAppleClientSecret)
+	}
+	sign, err := newKeySigner(&SignerConfig{Alg: string(jose.ES256)}, jose.JSONWebKey{Key: key, KeyID: cfg.KeyID}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AppleClientSecret{
+		teamID:    cfg.TeamID,
+		clientID:  cfg.ClientID,
+		header:    cfg.Header,
+		expiresIn: expiresIn,
+		sign:      instrumentedSigner(sign, DefaultMetrics),
+	}
+	if s.header == "" {
+		s.header = defaultAppleClientSecretHeader
+	}
+	return s, nil
+}
+
+// Secret returns the current client secret, generating a new one if the current one has consumed the half
+// of its lifetime
+func (s *AppleClientSecret) Secret() (string, error) {
+	return s.secretAt(time.Now())
+}
+
+func (s *AppleClientSecret) secretAt(now time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secret != "" && now.Before(s.next) {
+		return s.secret, nil
+	}
+
+	secret, err := s.sign(map[string]interface{}{
+		"iss": s.teamID,
+		"sub": s.clientID,
+		"aud": appleAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(s.expiresIn).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	s.secret = secret
+	s.next = now.Add(s.expiresIn / 2)
+	return secret, nil
+}
+
+// Propagate sets the client secret header of the request, replacing the one sent by the client
+func (s *AppleClientSecret) Propagate(r *http.Request) error {
+	if s == nil {
+		return nil
+	}
+	r.Header.Del(s.header)
+	secret, err := s.Secret()
+	if err != nil {
+		return err
+	}
+	r.Header.Set(s.header, secret)
+	return nil
+}
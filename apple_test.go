@@ -0,0 +1,99 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestAppleClientSecret(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cfg := &AppleClientSecretConfig{
+		TeamID:     "TEAM123456",
+		ClientID:   "com.example.web",
+		KeyID:      "ABC123DEFG",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ExpiresIn:  Seconds(3600),
+	}
+	s, err := NewAppleClientSecret(cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Now()
+	secret, err := s.secretAt(now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	token, err := jwt.ParseSigned(secret)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if h := token.Headers[0]; h.KeyID != "ABC123DEFG" || h.Algorithm != string(jose.ES256) {
+		t.Errorf("unexpected headers: %+v", h)
+	}
+	claims := jwt.Claims{}
+	if err := token.Claims(&key.PublicKey, &claims); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   "TEAM123456",
+		Subject:  "com.example.web",
+		Audience: jwt.Audience{"https://appleid.apple.com"},
+		Time:     now,
+	}, 0); err != nil {
+		t.Error(err)
+	}
+	if claims.Expiry.Time().Sub(claims.IssuedAt.Time()) != time.Hour {
+		t.Errorf("unexpected lifetime: %v", claims.Expiry.Time().Sub(claims.IssuedAt.Time()))
+	}
+
+	if cached, _ := s.secretAt(now.Add(29 * time.Minute)); cached != secret {
+		t.Error("the secret should be reused")
+	}
+	if renewed, _ := s.secretAt(now.Add(31 * time.Minute)); renewed == secret {
+		t.Error("the secret should be renewed")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Apple-Client-Secret", "forged")
+	if err := s.Propagate(req); err != nil {
+		t.Error(err)
+	}
+	if v := req.Header.Get("X-Apple-Client-Secret"); v == "forged" || v == "" {
+		t.Errorf("unexpected header: %q", v)
+	}
+
+	for _, c := range []AppleClientSecretConfig{
+		{ClientID: cfg.ClientID, KeyID: cfg.KeyID, PrivateKey: cfg.PrivateKey},
+		{TeamID: cfg.TeamID, ClientID: cfg.ClientID, KeyID: cfg.KeyID, PrivateKey: cfg.PrivateKey, ExpiresIn: Seconds(200 * 24 * 3600)},
+		{TeamID: cfg.TeamID, ClientID: cfg.ClientID, KeyID: cfg.KeyID, PrivateKey: "not a key"},
+	} {
+		c := c
+		if _, err := NewAppleClientSecret(&c); !errors.Is(err, ErrInvalidAppleClientSecret) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
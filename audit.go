@@ -0,0 +1,307 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditSinkStdout = "stdout"
+	AuditSinkFile   = "file"
+	AuditSinkSyslog = "syslog"
+
+	AuditDecisionAccept = "accept"
+	AuditDecisionReject = "reject"
+)
+
+var (
+	ErrUnknownAuditSink = errors.New("unknown audit sink")
+	ErrNoAuditPath      = errors.New("the file audit sink requires a path")
+)
+
+// AuditConfig enables the audit log of the token decisions of an endpoint
+type AuditConfig struct {
+	Sink string `json:"sink"`
+	// Path is the file used by the file sink
+	Path string `json:"path,omitempty"`
+	// Network, Address and Tag configure the syslog sink. An empty address uses the local syslog daemon
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	// Options are passed to the sinks registered with RegisterAuditSink
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// AuditEvent records an accept or reject decision
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Endpoint  string    `json:"endpoint"`
+	Method    string    `json:"method"`
+	Decision  string    `json:"decision"`
+	Rule      string    `json:"rule"`
+	Reason    string    `json:"reason,omitempty"`
+	Status    int       `json:"status"`
+	Subject   string    `json:"sub,omitempty"`
+	Issuer    string    `json:"iss,omitempty"`
+	JTIHash   string    `json:"jti_hash,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	KeyID     string    `json:"kid,omitempty"`
+	Alg       string    `json:"alg,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	// DryRun marks the rejections not enforced because of the log_only enforcement mode
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// AuditSink stores the audit events
+type AuditSink interface {
+	Record(AuditEvent) error
+}
+
+// AuditSinkFactory creates an AuditSink from its config
+type AuditSinkFactory func(*AuditConfig) (AuditSink, error)
+
+var (
+	auditSinks = map[string]AuditSinkFactory{
+		AuditSinkStdout: func(_ *AuditConfig) (AuditSink, error) { return NewWriterAuditSink(os.Stdout), nil },
+		AuditSinkFile:   newFileAuditSink,
+	}
+	auditSinksMu sync.RWMutex
+)
+
+// RegisterAuditSink adds a sink (as a Kafka producer) to the ones available in the audit config
+func RegisterAuditSink(name string, f AuditSinkFactory) {
+	auditSinksMu.Lock()
+	auditSinks[name] = f
+	auditSinksMu.Unlock()
+}
+
+// NewAuditSink creates the sink defined by the config
+func NewAuditSink(cfg *AuditConfig) (AuditSink, error) {
+	auditSinksMu.RLock()
+	f, ok := auditSinks[cfg.Sink]
+	auditSinksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAuditSink, cfg.Sink)
+	}
+	return f(cfg)
+}
+
+// WriterAuditSink writes the events as JSON lines
+type WriterAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterAuditSink returns a WriterAuditSink using the given writer
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// Record implements the AuditSink interface
+func (s *WriterAuditSink) Record(e AuditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(b)
+	return err
+}
+
+var (
+	fileAuditSinks   = map[string]*WriterAuditSink{}
+	fileAuditSinksMu sync.Mutex
+)
+
+// newFileAuditSink returns a sink appending to the file. Endpoints sharing the path share the sink.
+func newFileAuditSink(cfg *AuditConfig) (AuditSink, error) {
+	if cfg.Path == "" {
+		return nil, ErrNoAuditPath
+	}
+
+	fileAuditSinksMu.Lock()
+	defer fileAuditSinksMu.Unlock()
+
+	if s, ok := fileAuditSinks[cfg.Path]; ok {
+		return s, nil
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := NewWriterAuditSink(f)
+	fileAuditSinks[cfg.Path] = s
+	return s, nil
+}
+
+// Auditor records the decisions of the validator of an endpoint. A nil Auditor records nothing.
+type Auditor struct {
+	sink      AuditSink
+	endpoint  string
+	cookieKey string
+	logOnly   bool
+	redactor  *Redactor
+	tenant    *TenantResolver
+}
+
+// NewAuditor returns an Auditor for the endpoint, or nil if the audit is not enabled
+func NewAuditor(endpoint string, signatureConfig *SignatureConfig) (*Auditor, error) {
+	auditCfg := signatureConfig.Audit
+	if auditCfg == nil && signatureConfig.FAPI != nil {
+		// the FAPI profile requires the audit of all the rejections
+		auditCfg = &AuditConfig{Sink: AuditSinkStdout}
+	}
+	if auditCfg == nil {
+		return nil, nil
+	}
+	sink, err := NewAuditSink(auditCfg)
+	if err != nil {
+		return nil, err
+	}
+	redactor, err := NewRedactor(signatureConfig.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := NewTenantResolver(signatureConfig.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	cookieKey := signatureConfig.CookieKey
+	if cookieKey == "" {
+		cookieKey = "access_token"
+	}
+	return &Auditor{
+		sink:      sink,
+		endpoint:  endpoint,
+		cookieKey: cookieKey,
+		logOnly:   signatureConfig.LogOnly(),
+		redactor:  redactor,
+		tenant:    tenant,
+	}, nil
+}
+
+// Record sends the decision to the sink. A nil error means the request has been accepted.
+func (a *Auditor) Record(r *http.Request, start time.Time, claims map[string]interface{}, authErr *AuthError) error {
+	if a == nil {
+		return nil
+	}
+
+	now := time.Now()
+	e := AuditEvent{
+		Time:      now.UTC(),
+		Endpoint:  a.endpoint,
+		Method:    r.Method,
+		Decision:  AuditDecisionAccept,
+		Rule:      "all",
+		Status:    http.StatusOK,
+		LatencyMs: float64(now.Sub(start).Microseconds()) / 1000,
+	}
+	if authErr != nil {
+		e.Decision = AuditDecisionReject
+		e.Rule = auditRule(authErr.Reason)
+		e.Reason = authErr.Reason
+		e.Status = authErr.Status
+		e.DryRun = a.logOnly
+	}
+
+	e.Tenant, _ = a.tenant.Tenant(claims)
+	claims = a.redactor.Redact(claims)
+	e.Subject, _ = claims["sub"].(string)
+	e.Issuer, _ = claims["iss"].(string)
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		sum := sha256.Sum256([]byte(jti))
+		e.JTIHash = hex.EncodeToString(sum[:])
+	}
+	_, token := requestToken(r, a.cookieKey)
+	e.KeyID, e.Alg = token.KeyID, token.Alg
+
+	return a.sink.Record(e)
+}
+
+func auditRule(reason string) string {
+	switch reason {
+	case ReasonInsufficientRole:
+		return "roles"
+	case ReasonInsufficientScope:
+		return "scopes"
+	case ReasonClaimMismatch:
+		return "claims"
+	case ReasonRejected:
+		return "rejecter"
+	case ReasonPayloadSignature:
+		return "payload_signature"
+	case ReasonTenantMismatch:
+		return "tenant"
+	case ReasonDenied:
+		return "deny"
+	case ReasonOutsideSchedule:
+		return "schedule"
+	case ReasonClientNotAllowed:
+		return "clients"
+	case ReasonInsufficientAuth:
+		return "step_up"
+	case ReasonInvalidNonce:
+		return "nonce"
+	case ReasonClaimChanged:
+		return "history"
+	case ReasonEnrichment:
+		return "enrichment"
+	case ReasonUserInfo:
+		return "userinfo"
+	case ReasonInvalidTokenType, ReasonInvalidProfile:
+		return "token_profile"
+	case ReasonSenderConstraint, ReasonInvalidDPoPProof, ReasonLifetimeExceeded, ReasonMissingClaims:
+		return "fapi"
+	}
+	return "token"
+}
+
+// tokenMetadata returns the kid and the alg of the token, without verifying it. The alg of the PASETO
+// tokens is their version and purpose.
+func tokenMetadata(token string) (string, string) {
+	if token == "" {
+		return "", ""
+	}
+
+	if strings.HasPrefix(token, "v2.") || strings.HasPrefix(token, "v4.") {
+		parts := strings.Split(token, ".")
+		if len(parts) < 3 {
+			return "", ""
+		}
+		footer := pasetoFooter{}
+		if len(parts) == 4 {
+			if b, err := base64.RawURLEncoding.DecodeString(parts[3]); err == nil {
+				json.Unmarshal(b, &footer)
+			}
+		}
+		return footer.KeyID, parts[0] + "." + parts[1]
+	}
+
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", ""
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return "", ""
+	}
+	header := struct {
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+	}{}
+	json.Unmarshal(b, &header)
+	return header.Kid, header.Alg
+}
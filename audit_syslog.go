@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package jose
+
+import (
+	"log/syslog"
+)
+
+func init() {
+	auditSinks[AuditSinkSyslog] = newSyslogAuditSink
+}
+
+func newSyslogAuditSink(cfg *AuditConfig) (AuditSink, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "krakend-jose"
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterAuditSink(w), nil
+}
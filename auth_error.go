@@ -0,0 +1,341 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Error codes defined by RFC 6750, section 3.1
+const (
+	ErrorCodeInvalidRequest    = "invalid_request"
+	ErrorCodeInvalidToken      = "invalid_token"
+	ErrorCodeInsufficientScope = "insufficient_scope"
+)
+
+// Reasons reported in the JSON body of the error responses
+const (
+	ReasonMissing           = "missing"
+	ReasonMalformed         = "malformed"
+	ReasonExpired           = "expired"
+	ReasonNotYetValid       = "not_yet_valid"
+	ReasonInvalidAudience   = "invalid_audience"
+	ReasonInvalidIssuer     = "invalid_issuer"
+	ReasonInvalidSignature  = "invalid_signature"
+	ReasonUnknownKey        = "unknown_key"
+	ReasonInvalid           = "invalid"
+	ReasonRejected          = "rejected"
+	ReasonInsufficientRole  = "insufficient_role"
+	ReasonInsufficientScope = "insufficient_scope"
+	ReasonClaimMismatch     = "claim_mismatch"
+	ReasonParamMismatch     = "param_mismatch"
+	ReasonPayloadSignature  = "invalid_payload_signature"
+	ReasonInvalidBody       = "invalid_body"
+	ReasonBodyConflict      = "body_conflict"
+	ReasonTenantMismatch    = "tenant_mismatch"
+	ReasonTokenExchange     = "token_exchange_failed"
+	ReasonSenderConstraint  = "sender_constraint"
+	ReasonInvalidDPoPProof  = "invalid_dpop_proof"
+	ReasonLifetimeExceeded  = "lifetime_exceeded"
+	ReasonMissingClaims     = "missing_claims"
+	ReasonDenied            = "denied"
+	ReasonOutsideSchedule   = "outside_schedule"
+	ReasonInsufficientAuth  = "insufficient_user_authentication"
+	ReasonInvalidNonce      = "invalid_nonce"
+	ReasonClaimChanged      = "claim_changed"
+	ReasonEnrichment        = "enrichment_failed"
+	ReasonUserInfo          = "userinfo_failed"
+	ReasonInvalidTokenType  = "invalid_token_type"
+	ReasonInvalidProfile    = "invalid_token_profile"
+	ReasonLimitsExceeded    = "limits_exceeded"
+	ReasonClientNotAllowed  = "client_not_allowed"
+)
+
+var (
+	// ErrTokenExpired matches, with errors.Is, the errors of the expired tokens and their AuthError
+	ErrTokenExpired = jwt.ErrExpired
+	// ErrInsufficientScope matches, with errors.Is, the AuthError of the tokens without the required scopes
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// ErrorResponseConfig customizes the responses of the rejected requests
+type ErrorResponseConfig struct {
+	Realm string `json:"realm,omitempty"`
+	// JSONBody adds a JSON body with the error, the reason and the description
+	JSONBody bool `json:"json_body,omitempty"`
+	// Describe adds the error_description attribute to the WWW-Authenticate header
+	Describe bool `json:"describe,omitempty"`
+}
+
+// AuthError describes why a request has been rejected
+type AuthError struct {
+	Status      int      `json:"-"`
+	Code        string   `json:"error,omitempty"`
+	Reason      string   `json:"reason"`
+	Description string   `json:"error_description,omitempty"`
+	Scope       []string `json:"scope,omitempty"`
+	// ACRValues and MaxAge are the authentication requirements of the step up challenges
+	ACRValues []string `json:"acr_values,omitempty"`
+	MaxAge    int64    `json:"max_age,omitempty"`
+
+	err error
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason + ": " + e.Description
+}
+
+// Unwrap returns the error the AuthError has been built from, so errors.Is and errors.As can match it
+func (e *AuthError) Unwrap() error {
+	return e.err
+}
+
+// NewTokenError classifies the error returned by the token validation
+func NewTokenError(err error) *AuthError {
+	res := &AuthError{
+		Status:      http.StatusUnauthorized,
+		Code:        ErrorCodeInvalidToken,
+		Reason:      ReasonInvalid,
+		Description: "the token is not valid",
+		err:         err,
+	}
+
+	switch {
+	case errors.Is(err, auth0.ErrTokenNotFound):
+		// RFC 6750, section 3.1: no error code when the request lacks any authentication information
+		res.Code = ""
+		res.Reason = ReasonMissing
+		res.Description = "the token is missing"
+	case errors.Is(err, jwt.ErrExpired):
+		res.Reason = ReasonExpired
+		res.Description = "the token is expired"
+	case errors.Is(err, jwt.ErrNotValidYet), errors.Is(err, jwt.ErrIssuedInTheFuture):
+		res.Reason = ReasonNotYetValid
+		res.Description = "the token is not valid yet"
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		res.Reason = ReasonInvalidAudience
+		res.Description = "the token audience is not accepted"
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		res.Reason = ReasonInvalidIssuer
+		res.Description = "the token issuer is not accepted"
+	case errors.Is(err, jose.ErrCryptoFailure), errors.Is(err, ErrPasetoInvalid):
+		res.Reason = ReasonInvalidSignature
+		res.Description = "the token signature is not valid"
+	case errors.Is(err, ErrInvalidDPoPProof):
+		res.Code = ErrorCodeInvalidDPoPProof
+		res.Reason = ReasonInvalidDPoPProof
+		res.Description = "the DPoP proof is not valid"
+	case errors.Is(err, ErrSenderConstraint):
+		res.Reason = ReasonSenderConstraint
+		res.Description = "the token is not bound to the sender"
+	case errors.Is(err, ErrFAPILifetime):
+		res.Reason = ReasonLifetimeExceeded
+		res.Description = "the token lifetime is too long"
+	case errors.Is(err, ErrNonceMissing), errors.Is(err, ErrNonceMismatch):
+		res.Reason = ReasonInvalidNonce
+		res.Description = "the token nonce does not match the request"
+	case errors.Is(err, ErrInvalidTokenType):
+		res.Reason = ReasonInvalidTokenType
+		res.Description = "the token type is not accepted"
+	case errors.Is(err, ErrTokenProfile):
+		res.Reason = ReasonInvalidProfile
+		res.Description = "the token does not comply with the access token profile"
+	case errors.Is(err, ErrFAPIClaims):
+		res.Reason = ReasonMissingClaims
+		res.Description = "the token lacks the required claims"
+	case errors.Is(err, ErrClaimsLimitExceeded):
+		res.Reason = ReasonLimitsExceeded
+		res.Description = "the token exceeds the size limits"
+	case errors.Is(err, auth0.ErrNoKeyFound), errors.Is(err, ErrKeyNotFound):
+		res.Reason = ReasonUnknownKey
+		res.Description = "the token key is unknown"
+	case errors.Is(err, auth0.ErrNoJWTHeaders), errors.Is(err, auth0.ErrInvalidAlgorithm),
+		errors.Is(err, ErrPasetoMalformed), errors.Is(err, ErrPasetoUnsupported), errors.Is(err, ErrBiscuitMalformed),
+		strings.HasPrefix(err.Error(), "square/go-jose: compact"), strings.HasPrefix(err.Error(), "illegal base64"):
+		res.Reason = ReasonMalformed
+		res.Description = "the token is malformed"
+	}
+	return res
+}
+
+// NewForbiddenError returns the error for the valid tokens without the required roles, scopes or claims.
+// The given scopes (the missing ones) are reported in the WWW-Authenticate header. As the scope attribute
+// of RFC 6750 is reserved for the OAuth scopes, they are dropped for the rest of the reasons.
+func NewForbiddenError(reason string, scope ...string) *AuthError {
+	res := &AuthError{
+		Status: http.StatusForbidden,
+		Code:   ErrorCodeInsufficientScope,
+		Reason: reason,
+	}
+	switch reason {
+	case ReasonInsufficientScope:
+		res.Description = "the token does not have the required scopes"
+		res.Scope = scope
+		res.err = ErrInsufficientScope
+	case ReasonInsufficientRole:
+		res.Description = "the token does not have the required roles"
+	case ReasonParamMismatch:
+		res.Description = "the token does not grant access to the requested resource"
+	case ReasonTenantMismatch:
+		res.Description = "the token tenant is not allowed"
+	case ReasonDenied:
+		res.Description = "the token is denied access to the resource"
+	case ReasonOutsideSchedule:
+		res.Description = "the token does not grant access at this time"
+	case ReasonClientNotAllowed:
+		res.Description = "the client of the token is not allowed"
+	case ReasonClaimChanged:
+		res.Description = "the token claims changed since the last request of the subject"
+	default:
+		res.Description = "the token does not have the required claims"
+	}
+	return res
+}
+
+// NewRejectedError returns the error for the tokens rejected by the Rejecter
+func NewRejectedError() *AuthError {
+	return &AuthError{
+		Status:      http.StatusUnauthorized,
+		Code:        ErrorCodeInvalidToken,
+		Reason:      ReasonRejected,
+		Description: "the token has been revoked",
+	}
+}
+
+// NewPayloadSignatureError returns the error for the requests without a valid detached signature of the body
+func NewPayloadSignatureError() *AuthError {
+	return &AuthError{
+		Status:      http.StatusUnauthorized,
+		Code:        ErrorCodeInvalidRequest,
+		Reason:      ReasonPayloadSignature,
+		Description: "the payload signature is not valid",
+	}
+}
+
+// NewBodyError returns the error for the requests with a body where the claims can not be injected
+func NewBodyError(reason string) *AuthError {
+	res := &AuthError{
+		Status: http.StatusBadRequest,
+		Code:   ErrorCodeInvalidRequest,
+		Reason: reason,
+	}
+	if reason == ReasonBodyConflict {
+		res.Description = "the request body contains fields owned by the token"
+	} else {
+		res.Description = "the request body is not a JSON object"
+	}
+	return res
+}
+
+// NewTokenExchangeError returns the error for the tokens that can not be exchanged for the backend ones.
+// The tokens refused by the IdP are reported as invalid, and the failures of the IdP as a bad gateway.
+func NewTokenExchangeError(err error) *AuthError {
+	if errors.Is(err, ErrTokenEndpoint) {
+		return &AuthError{
+			Status:      http.StatusUnauthorized,
+			Code:        ErrorCodeInvalidToken,
+			Reason:      ReasonTokenExchange,
+			Description: "the token can not be exchanged for the backend",
+		}
+	}
+	return &AuthError{
+		Status:      http.StatusBadGateway,
+		Reason:      ReasonTokenExchange,
+		Description: "the token exchange is not available",
+	}
+}
+
+// NewEnrichmentError returns the error for the requests whose claims can not be enriched, because the
+// source of the attributes is not available
+func NewEnrichmentError() *AuthError {
+	return &AuthError{
+		Status:      http.StatusBadGateway,
+		Reason:      ReasonEnrichment,
+		Description: "the attributes of the subject are not available",
+	}
+}
+
+// NewUserInfoError returns the error for the tokens whose userinfo can not be fetched. The tokens refused
+// by the userinfo endpoint (or with the userinfo of another subject) are reported as invalid, and the
+// failures of the endpoint as a bad gateway.
+func NewUserInfoError(err error) *AuthError {
+	if errors.Is(err, ErrUserInfoRejected) || errors.Is(err, ErrUserInfoSubject) {
+		return &AuthError{
+			Status:      http.StatusUnauthorized,
+			Code:        ErrorCodeInvalidToken,
+			Reason:      ReasonUserInfo,
+			Description: "the userinfo of the token is not available",
+		}
+	}
+	return &AuthError{
+		Status:      http.StatusBadGateway,
+		Reason:      ReasonUserInfo,
+		Description: "the userinfo endpoint is not available",
+	}
+}
+
+// ErrorRenderer writes the responses of the rejected requests
+type ErrorRenderer struct {
+	cfg ErrorResponseConfig
+}
+
+// NewErrorRenderer creates an ErrorRenderer. A nil config renders the status code and the
+// WWW-Authenticate header, without descriptions nor body.
+func NewErrorRenderer(cfg *ErrorResponseConfig) *ErrorRenderer {
+	r := &ErrorRenderer{}
+	if cfg != nil {
+		r.cfg = *cfg
+	}
+	return r
+}
+
+// WWWAuthenticate returns the value of the WWW-Authenticate header for the error (RFC 6750, section 3)
+func (r *ErrorRenderer) WWWAuthenticate(e *AuthError) string {
+	params := []string{}
+	if r.cfg.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", r.cfg.Realm))
+	}
+	if e.Code != "" {
+		params = append(params, fmt.Sprintf("error=%q", e.Code))
+		if r.cfg.Describe && e.Description != "" {
+			params = append(params, fmt.Sprintf("error_description=%q", e.Description))
+		}
+	}
+	if len(e.Scope) > 0 {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(e.Scope, " ")))
+	}
+	if len(e.ACRValues) > 0 {
+		params = append(params, fmt.Sprintf("acr_values=%q", strings.Join(e.ACRValues, " ")))
+	}
+	if e.MaxAge > 0 {
+		params = append(params, fmt.Sprintf("max_age=%d", e.MaxAge))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// RendersBody returns true if the error responses have a JSON body
+func (r *ErrorRenderer) RendersBody() bool {
+	return r.cfg.JSONBody
+}
+
+// Render writes the headers, the status code and, if enabled, the body of the error response
+func (r *ErrorRenderer) Render(w http.ResponseWriter, e *AuthError) {
+	w.Header().Set("WWW-Authenticate", r.WWWAuthenticate(e))
+	if !r.cfg.JSONBody {
+		w.WriteHeader(e.Status)
+		return
+	}
+	body, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
@@ -0,0 +1,105 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestNewTokenError(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		code   string
+		reason string
+	}{
+		{err: auth0.ErrTokenNotFound, reason: ReasonMissing},
+		{err: jwt.ErrExpired, code: ErrorCodeInvalidToken, reason: ReasonExpired},
+		{err: fmt.Errorf("wrapped: %w", jwt.ErrNotValidYet), code: ErrorCodeInvalidToken, reason: ReasonNotYetValid},
+		{err: jwt.ErrInvalidAudience, code: ErrorCodeInvalidToken, reason: ReasonInvalidAudience},
+		{err: jwt.ErrInvalidIssuer, code: ErrorCodeInvalidToken, reason: ReasonInvalidIssuer},
+		{err: jose.ErrCryptoFailure, code: ErrorCodeInvalidToken, reason: ReasonInvalidSignature},
+		{err: auth0.ErrNoKeyFound, code: ErrorCodeInvalidToken, reason: ReasonUnknownKey},
+		{err: ErrKeyNotFound, code: ErrorCodeInvalidToken, reason: ReasonUnknownKey},
+		{err: &KeyNotFoundError{KeyID: "unknown"}, code: ErrorCodeInvalidToken, reason: ReasonUnknownKey},
+		{err: ErrPasetoMalformed, code: ErrorCodeInvalidToken, reason: ReasonMalformed},
+		{err: errors.New("square/go-jose: compact JWS format must have three parts"), code: ErrorCodeInvalidToken, reason: ReasonMalformed},
+		{err: errors.New("something else"), code: ErrorCodeInvalidToken, reason: ReasonInvalid},
+	} {
+		e := NewTokenError(tc.err)
+		if e.Status != http.StatusUnauthorized {
+			t.Errorf("%v: unexpected status: %d", tc.err, e.Status)
+		}
+		if e.Code != tc.code {
+			t.Errorf("%v: unexpected code: %s", tc.err, e.Code)
+		}
+		if e.Reason != tc.reason {
+			t.Errorf("%v: unexpected reason: %s", tc.err, e.Reason)
+		}
+		if !errors.Is(e, tc.err) {
+			t.Errorf("%v: the error is not wrapped", tc.err)
+		}
+	}
+}
+
+func TestAuthError_is(t *testing.T) {
+	var err error = NewTokenError(fmt.Errorf("validating: %w", jwt.ErrExpired))
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("%v: expired token expected", err)
+	}
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.Reason != ReasonExpired {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = NewForbiddenError(ReasonInsufficientScope, "write")
+	if !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("%v: insufficient scope expected", err)
+	}
+	if err = NewForbiddenError(ReasonInsufficientRole); errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("%v: unexpected insufficient scope", err)
+	}
+	if h := NewErrorRenderer(nil).WWWAuthenticate(NewForbiddenError(ReasonInsufficientRole, "admin")); strings.Contains(h, "scope=") {
+		t.Errorf("the roles should not be reported as scopes: %s", h)
+	}
+}
+
+func TestErrorRenderer(t *testing.T) {
+	e := NewForbiddenError(ReasonInsufficientScope, "read", "write")
+
+	r := NewErrorRenderer(nil)
+	if h := r.WWWAuthenticate(e); h != `Bearer error="insufficient_scope", scope="read write"` {
+		t.Errorf("unexpected header: %s", h)
+	}
+	if h := r.WWWAuthenticate(NewTokenError(auth0.ErrTokenNotFound)); h != "Bearer" {
+		t.Errorf("unexpected header: %s", h)
+	}
+
+	w := httptest.NewRecorder()
+	r.Render(w, e)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	r = NewErrorRenderer(&ErrorResponseConfig{Realm: "api", JSONBody: true, Describe: true})
+	w = httptest.NewRecorder()
+	r.Render(w, e)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if h := w.Header().Get("WWW-Authenticate"); h != `Bearer realm="api", error="insufficient_scope", error_description="the token does not have the required scopes", scope="read write"` {
+		t.Errorf("unexpected header: %s", h)
+	}
+	if body := w.Body.String(); body != `{"error":"insufficient_scope","reason":"insufficient_scope","error_description":"the token does not have the required scopes","scope":["read","write"]}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
@@ -0,0 +1,211 @@
+package jose
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" // skipcq: GSC-G505
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/auth0-community/go-auth0"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	CredentialStoreHtpasswd = "htpasswd"
+
+	defaultBasicAuthUserClaim = "sub"
+)
+
+var (
+	ErrUnknownCredentialStore = errors.New("unknown credential store")
+	ErrNoHtpasswdPath         = errors.New("the htpasswd credential store requires a path")
+	ErrInvalidCredentials     = errors.New("invalid credentials")
+)
+
+// BasicAuthConfig enables the Basic credentials as a fallback of the tokens, for the legacy clients. The
+// requests without token sending valid credentials get synthetic claims, and they go through the same
+// checks and propagation as the tokens.
+type BasicAuthConfig struct {
+	// Store is the credential store verifying the passwords. Defaults to htpasswd
+	Store string `json:"store,omitempty"`
+	// Path is the file used by the htpasswd store. Only the bcrypt and {SHA} hashes are supported.
+	Path string `json:"path,omitempty"`
+	// UserClaim is the claim set to the user name. Defaults to sub
+	UserClaim string `json:"user_claim,omitempty"`
+	// Claims are added to the claims of all the users
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	// Users are the claims of every user, as their roles
+	Users map[string]map[string]interface{} `json:"users,omitempty"`
+	// Options are passed to the stores registered with RegisterCredentialStore
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// CredentialStore verifies the Basic credentials. It returns the claims of the user found by the store,
+// as the groups of a directory, or ErrInvalidCredentials.
+type CredentialStore interface {
+	Verify(ctx context.Context, user, password string) (map[string]interface{}, error)
+}
+
+// CredentialStoreFunc is an adapter to use functions as credential stores
+type CredentialStoreFunc func(ctx context.Context, user, password string) (map[string]interface{}, error)
+
+// Verify calls f(ctx, user, password)
+func (f CredentialStoreFunc) Verify(ctx context.Context, user, password string) (map[string]interface{}, error) {
+	return f(ctx, user, password)
+}
+
+// CredentialStoreFactory creates a CredentialStore from its config
+type CredentialStoreFactory func(*BasicAuthConfig) (CredentialStore, error)
+
+var (
+	credentialStores = map[string]CredentialStoreFactory{
+		CredentialStoreHtpasswd: newHtpasswdStore,
+	}
+	credentialStoresMu sync.RWMutex
+)
+
+// RegisterCredentialStore adds a store (as an LDAP directory or a callback) to the ones available in the
+// Basic auth config
+func RegisterCredentialStore(name string, f CredentialStoreFactory) {
+	credentialStoresMu.Lock()
+	credentialStores[name] = f
+	credentialStoresMu.Unlock()
+}
+
+// NewCredentialStore creates the store defined by the config
+func NewCredentialStore(cfg *BasicAuthConfig) (CredentialStore, error) {
+	name := cfg.Store
+	if name == "" {
+		name = CredentialStoreHtpasswd
+	}
+	credentialStoresMu.RLock()
+	f, ok := credentialStores[name]
+	credentialStoresMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCredentialStore, name)
+	}
+	return f(cfg)
+}
+
+// BasicAuthenticator synthesizes the claims of the requests with Basic credentials. A nil
+// BasicAuthenticator does nothing.
+type BasicAuthenticator struct {
+	store     CredentialStore
+	userClaim ClaimPath
+	claims    map[string]interface{}
+	users     map[string]map[string]interface{}
+}
+
+// NewBasicAuthenticator returns the BasicAuthenticator of the config, or nil if there is no config
+func NewBasicAuthenticator(cfg *BasicAuthConfig) (*BasicAuthenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	store, err := NewCredentialStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	userClaim := cfg.UserClaim
+	if userClaim == "" {
+		userClaim = defaultBasicAuthUserClaim
+	}
+	return &BasicAuthenticator{
+		store:     store,
+		userClaim: NewClaimPath(userClaim, true),
+		claims:    cfg.Claims,
+		users:     cfg.Users,
+	}, nil
+}
+
+// Authenticate verifies the Basic credentials of the request and returns the claims of the user: the
+// common claims, the ones of the user, the ones returned by the store and the user claim. The credentials
+// are removed from the request, so they do not reach the backends. It returns auth0.ErrTokenNotFound if
+// the request has no credentials.
+func (b *BasicAuthenticator) Authenticate(r *http.Request) (map[string]interface{}, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, auth0.ErrTokenNotFound
+	}
+	r.Header.Del("Authorization")
+
+	found, err := b.store.Verify(r.Context(), user, password)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	for _, src := range []map[string]interface{}{b.claims, b.users[user], found} {
+		for k, v := range src {
+			claims[k] = v
+		}
+	}
+	return withClaim(claims, b.userClaim, user), nil
+}
+
+// Fallback authenticates the Basic credentials of the requests without token. The result of the token
+// validation is returned as it is if there is a token or the fallback is disabled.
+func (b *BasicAuthenticator) Fallback(r *http.Request, claims map[string]interface{}, err error) (map[string]interface{}, error) {
+	if b == nil || !errors.Is(err, auth0.ErrTokenNotFound) {
+		return claims, err
+	}
+	return b.Authenticate(r)
+}
+
+// htpasswdStore verifies the credentials against the hashes of an htpasswd file
+type htpasswdStore map[string]string
+
+func newHtpasswdStore(cfg *BasicAuthConfig) (CredentialStore, error) {
+	if cfg.Path == "" {
+		return nil, ErrNoHtpasswdPath
+	}
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := htpasswdStore{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		s[parts[0]] = parts[1]
+	}
+	return s, scanner.Err()
+}
+
+// Verify implements the CredentialStore interface
+func (s htpasswdStore) Verify(_ context.Context, user, password string) (map[string]interface{}, error) {
+	hash, ok := s[user]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password)) // skipcq: GSC-G401
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(expected)) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+	default:
+		return nil, ErrInvalidCredentials
+	}
+	return nil, nil
+}
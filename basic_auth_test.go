@@ -0,0 +1,117 @@
+package jose
+
+import (
+	"context"
+	"crypto/sha1" // skipcq: GSC-G505
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthenticator_htpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sum := sha1.Sum([]byte("bob-secret")) // skipcq: GSC-G401
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# users\nalice:" + string(hash) + "\nbob:{SHA}" + base64.StdEncoding.EncodeToString(sum[:]) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	b, err := NewBasicAuthenticator(&BasicAuthConfig{
+		Path:   path,
+		Claims: map[string]interface{}{"iss": "legacy"},
+		Users:  map[string]map[string]interface{}{"alice": {"roles": []interface{}{"admin"}}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, tc := range []struct {
+		user, password string
+		claims         map[string]interface{}
+	}{
+		{user: "alice", password: "alice-secret", claims: map[string]interface{}{"iss": "legacy", "sub": "alice", "roles": []interface{}{"admin"}}},
+		{user: "bob", password: "bob-secret", claims: map[string]interface{}{"iss": "legacy", "sub": "bob"}},
+		{user: "alice", password: "bob-secret"},
+		{user: "carol", password: "carol-secret"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tc.user, tc.password)
+		claims, err := b.Fallback(req, nil, auth0.ErrTokenNotFound)
+		if tc.claims == nil {
+			if err != ErrInvalidCredentials {
+				t.Errorf("%s: unexpected error: %v", tc.user, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.user, err)
+			continue
+		}
+		if !reflect.DeepEqual(claims, tc.claims) {
+			t.Errorf("%s: unexpected claims: %v", tc.user, claims)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("%s: the credentials should be removed from the request", tc.user)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := b.Fallback(req, nil, auth0.ErrTokenNotFound); err != auth0.ErrTokenNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRegisterCredentialStore(t *testing.T) {
+	RegisterCredentialStore("test-directory", func(cfg *BasicAuthConfig) (CredentialStore, error) {
+		group, _ := cfg.Options["group"].(string)
+		return CredentialStoreFunc(func(_ context.Context, user, password string) (map[string]interface{}, error) {
+			if password != user+"-secret" {
+				return nil, ErrInvalidCredentials
+			}
+			return map[string]interface{}{"groups": []interface{}{group}}, nil
+		}), nil
+	})
+
+	b, err := NewBasicAuthenticator(&BasicAuthConfig{
+		Store:     "test-directory",
+		UserClaim: "user.name",
+		Options:   map[string]interface{}{"group": "legacy"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "alice-secret")
+	claims, err := b.Authenticate(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[string]interface{}{"groups": []interface{}{"legacy"}, "user": map[string]interface{}{"name": "alice"}}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	if _, err := NewBasicAuthenticator(&BasicAuthConfig{Store: "unknown"}); !errors.Is(err, ErrUnknownCredentialStore) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewBasicAuthenticator(&BasicAuthConfig{}); err != ErrNoHtpasswdPath {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
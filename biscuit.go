@@ -0,0 +1,167 @@
+package jose
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// TokenFormatBiscuit switches the validator to Biscuit tokens
+const TokenFormatBiscuit = "biscuit"
+
+var (
+	ErrNoBiscuitAuthorizer = errors.New("no biscuit authorizer registered")
+	ErrBiscuitMalformed    = errors.New("malformed biscuit token")
+)
+
+// BiscuitConfig defines how the Biscuit tokens are authorized. The root public key (Ed25519) is loaded from
+// the JWK source of the validator.
+type BiscuitConfig struct {
+	RootKeyID string `json:"root_kid"`
+	// Policies are the authorizer policies (Datalog) evaluated after the checks of every block
+	Policies []string `json:"policies,omitempty"`
+}
+
+// BiscuitFact is a fact of the authority block of an authorized token, as in user("1234") or
+// right("orders", "read")
+type BiscuitFact struct {
+	Name  string
+	Terms []interface{}
+}
+
+// BiscuitAuthorizer verifies the signature chain of a serialized Biscuit token with the root key, runs
+// the checks of every attenuation block and the configured policies, and returns the facts of the
+// authority block. The request is available for adding ambient facts (path, method...).
+//
+// The Biscuit wire format and the Datalog engine are not part of this package, so an implementation
+// (usually backed by a biscuit library) must be registered with RegisterBiscuitAuthorizer before the
+// handlers are created.
+type BiscuitAuthorizer interface {
+	Authorize(token []byte, rootKey jose.JSONWebKey, policies []string, r *http.Request) ([]BiscuitFact, error)
+}
+
+var (
+	biscuitAuthorizer   BiscuitAuthorizer
+	biscuitAuthorizerMu sync.RWMutex
+)
+
+// RegisterBiscuitAuthorizer sets the BiscuitAuthorizer used by the validators of the biscuit token format
+func RegisterBiscuitAuthorizer(a BiscuitAuthorizer) {
+	biscuitAuthorizerMu.Lock()
+	biscuitAuthorizer = a
+	biscuitAuthorizerMu.Unlock()
+}
+
+// BiscuitValidator validates the Biscuit tokens sent with the requests
+type BiscuitValidator struct {
+	authorizer BiscuitAuthorizer
+	rootKey    jose.JSONWebKey
+	policies   []string
+	cookieKey  string
+}
+
+// NewBiscuitValidator creates a BiscuitValidator using the registered authorizer and the root key from the
+// key material of the signature config
+func NewBiscuitValidator(signatureConfig *SignatureConfig) (*BiscuitValidator, error) {
+	biscuitAuthorizerMu.RLock()
+	authorizer := biscuitAuthorizer
+	biscuitAuthorizerMu.RUnlock()
+	if authorizer == nil {
+		return nil, ErrNoBiscuitAuthorizer
+	}
+
+	bcfg := signatureConfig.Biscuit
+	if bcfg == nil {
+		bcfg = &BiscuitConfig{}
+	}
+
+	cfg, err := newSecretProviderConfig(signatureConfig)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := SecretProvider(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sp.GetKey(bcfg.RootKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &BiscuitValidator{
+		authorizer: authorizer,
+		rootKey:    key.Public(),
+		policies:   bcfg.Policies,
+		cookieKey:  signatureConfig.CookieKey,
+	}
+	if v.cookieKey == "" {
+		v.cookieKey = "access_token"
+	}
+	return v, nil
+}
+
+// ValidateRequest extracts the token from the Authorization header (or the cookie), authorizes it and
+// returns the facts of the authority block as claims
+func (v *BiscuitValidator) ValidateRequest(r *http.Request) (map[string]interface{}, error) {
+	_, token := requestToken(r, v.cookieKey)
+	if token.Raw == "" {
+		return nil, auth0.ErrTokenNotFound
+	}
+
+	raw, err := base64.URLEncoding.DecodeString(token.Raw)
+	if err != nil {
+		if raw, err = base64.RawURLEncoding.DecodeString(token.Raw); err != nil {
+			return nil, ErrBiscuitMalformed
+		}
+	}
+
+	facts, err := v.authorizer.Authorize(raw, v.rootKey, v.policies, r)
+	if err != nil {
+		return nil, err
+	}
+	return BiscuitFactsToClaims(facts), nil
+}
+
+// BiscuitFactsToClaims maps the facts into a claims map, using the fact names as keys. Facts with a single
+// term are stored as values. When the same name appears several times with string terms, the values are
+// joined with spaces, so facts like role("a") or scope("b") work with the role and scope matchers. Facts
+// with several terms are stored as lists of terms.
+func BiscuitFactsToClaims(facts []BiscuitFact) map[string]interface{} {
+	grouped := map[string][]interface{}{}
+	for _, f := range facts {
+		var v interface{}
+		if len(f.Terms) == 1 {
+			v = f.Terms[0]
+		} else {
+			v = f.Terms
+		}
+		grouped[f.Name] = append(grouped[f.Name], v)
+	}
+
+	claims := make(map[string]interface{}, len(grouped))
+	for name, values := range grouped {
+		if len(values) == 1 {
+			claims[name] = values[0]
+			continue
+		}
+		strs := make([]string, 0, len(values))
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				break
+			}
+			strs = append(strs, s)
+		}
+		if len(strs) == len(values) {
+			claims[name] = strings.Join(strs, " ")
+			continue
+		}
+		claims[name] = values
+	}
+	return claims
+}
@@ -0,0 +1,115 @@
+package jose
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+type fakeBiscuitAuthorizer struct {
+	token []byte
+	facts []BiscuitFact
+}
+
+func (f fakeBiscuitAuthorizer) Authorize(token []byte, rootKey jose.JSONWebKey, _ []string, _ *http.Request) ([]BiscuitFact, error) {
+	if !rootKey.IsPublic() {
+		return nil, errors.New("the root key should be public")
+	}
+	if !bytes.Equal(token, f.token) {
+		return nil, errors.New("unauthorized")
+	}
+	return f.facts, nil
+}
+
+func TestBiscuitValidator(t *testing.T) {
+	RegisterBiscuitAuthorizer(nil)
+	if _, err := NewBiscuitValidator(&SignatureConfig{}); err != ErrNoBiscuitAuthorizer {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	b, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: edKey, KeyID: "root"}}})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	token := []byte("serialized biscuit")
+	RegisterBiscuitAuthorizer(fakeBiscuitAuthorizer{
+		token: token,
+		facts: []BiscuitFact{
+			{Name: "user", Terms: []interface{}{"1234"}},
+			{Name: "role", Terms: []interface{}{"admin"}},
+			{Name: "role", Terms: []interface{}{"user"}},
+		},
+	})
+	defer RegisterBiscuitAuthorizer(nil)
+
+	validator, err := NewClaimsValidator(&SignatureConfig{
+		URI:         path,
+		LocalPath:   path,
+		TokenFormat: TokenFormatBiscuit,
+		Biscuit:     &BiscuitConfig{RootKeyID: "root"},
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+base64.URLEncoding.EncodeToString(token))
+	claims, err := validator(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if claims["user"] != "1234" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if !CanAccess("role", claims, []string{"user"}) {
+		t.Errorf("unexpected roles: %v", claims["role"])
+	}
+
+	req.Header.Set("Authorization", "Bearer "+base64.URLEncoding.EncodeToString([]byte("other")))
+	if _, err := validator(req); err == nil {
+		t.Error("error expected")
+	}
+
+	req.Header.Set("Authorization", "Bearer !!")
+	if _, err := validator(req); err != ErrBiscuitMalformed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBiscuitFactsToClaims(t *testing.T) {
+	claims := BiscuitFactsToClaims([]BiscuitFact{
+		{Name: "scope", Terms: []interface{}{"read"}},
+		{Name: "scope", Terms: []interface{}{"write"}},
+		{Name: "right", Terms: []interface{}{"orders", "read"}},
+		{Name: "right", Terms: []interface{}{"orders", "write"}},
+		{Name: "level", Terms: []interface{}{int64(3)}},
+	})
+
+	if claims["scope"] != "read write" {
+		t.Errorf("unexpected scope: %v", claims["scope"])
+	}
+	if !ScopesAllMatcher("scope", claims, []string{"read", "write"}) {
+		t.Error("the scopes should match")
+	}
+	if rights, ok := claims["right"].([]interface{}); !ok || len(rights) != 2 {
+		t.Errorf("unexpected rights: %v", claims["right"])
+	}
+	if claims["level"] != int64(3) {
+		t.Errorf("unexpected level: %v", claims["level"])
+	}
+}
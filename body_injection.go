@@ -0,0 +1,181 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Conflict policies of the claims injected into the request body
+const (
+	BodyConflictOverwrite = "overwrite"
+	BodyConflictReject    = "reject"
+	BodyConflictKeep      = "keep"
+)
+
+var ErrUnknownBodyConflictPolicy = errors.New("unknown body conflict policy")
+
+// BodyClaim injects a claim into a field of the JSON body of the request sent to the backends
+type BodyClaim struct {
+	// Claim is the name of the claim. Dots access nested claims.
+	Claim string `json:"claim"`
+	// Field is the name of the field of the body. Dots access nested objects.
+	Field string `json:"field"`
+	// OnConflict defines what to do when the body already contains the field with a different value:
+	// overwrite it (the default), reject the request or keep the value sent by the client. When the claim
+	// is missing, overwrite removes the field from the body.
+	OnConflict string `json:"on_conflict,omitempty"`
+}
+
+type bodyClaim struct {
+	claim      ClaimPath
+	field      []string
+	onConflict string
+}
+
+// BodyInjector merges claims into the JSON body of the requests, so the clients can not spoof the
+// identity fields of the payloads
+type BodyInjector struct {
+	rules []bodyClaim
+}
+
+// NewBodyInjector returns the BodyInjector for the rules, or nil if there are none
+func NewBodyInjector(rules []BodyClaim) (*BodyInjector, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	b := &BodyInjector{rules: make([]bodyClaim, len(rules))}
+	for i, r := range rules {
+		switch r.OnConflict {
+		case "":
+			r.OnConflict = BodyConflictOverwrite
+		case BodyConflictOverwrite, BodyConflictReject, BodyConflictKeep:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownBodyConflictPolicy, r.OnConflict)
+		}
+		b.rules[i] = bodyClaim{
+			claim:      NewClaimPath(r.Claim, true),
+			field:      strings.Split(r.Field, "."),
+			onConflict: r.OnConflict,
+		}
+	}
+	return b, nil
+}
+
+// Inject merges the claims into the body of the request. Requests without body are only modified if their
+// method usually carries one. It returns an AuthError if the body is not a JSON object or if a conflict
+// must be rejected. A nil BodyInjector does nothing.
+func (b *BodyInjector) Inject(r *http.Request, claims map[string]interface{}) *AuthError {
+	if b == nil {
+		return nil
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			return NewBodyError(ReasonInvalidBody)
+		}
+	}
+
+	var data []byte
+	if r.Body != nil {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return NewBodyError(ReasonInvalidBody)
+		}
+	}
+
+	body := map[string]interface{}{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			return nil
+		}
+	} else {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&body); err != nil || body == nil {
+			return NewBodyError(ReasonInvalidBody)
+		}
+	}
+
+	for _, rule := range b.rules {
+		value, hasClaim := rule.claim.Lookup(claims)
+		current, hasField := lookupField(body, rule.field)
+		if hasField && (!hasClaim || !sameJSON(current, value)) {
+			switch rule.onConflict {
+			case BodyConflictReject:
+				return NewBodyError(ReasonBodyConflict)
+			case BodyConflictKeep:
+				continue
+			}
+		}
+		if hasClaim {
+			setField(body, rule.field, value)
+		} else if hasField {
+			deleteField(body, rule.field)
+		}
+	}
+
+	data, _ = json.Marshal(body)
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+func lookupField(body map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = body
+	for _, k := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func setField(body map[string]interface{}, path []string, value interface{}) {
+	m := body
+	for _, k := range path[:len(path)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[k] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+func deleteField(body map[string]interface{}, path []string) {
+	m := body
+	for _, k := range path[:len(path)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = next
+	}
+	delete(m, path[len(path)-1])
+}
+
+func sameJSON(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	return err == nil && bytes.Equal(ja, jb)
+}
@@ -0,0 +1,136 @@
+package jose
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyInjector_Inject(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":    "user-1",
+		"tenant": map[string]interface{}{"id": float64(42)},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		rules       []BodyClaim
+		expected    string
+		reason      string
+	}{
+		{
+			name:     "add",
+			body:     `{"amount":10}`,
+			rules:    []BodyClaim{{Claim: "sub", Field: "user_id"}, {Claim: "tenant.id", Field: "meta.tenant"}},
+			expected: `{"amount":10,"meta":{"tenant":42},"user_id":"user-1"}`,
+		},
+		{
+			name:     "overwrite",
+			body:     `{"user_id":"user-2"}`,
+			rules:    []BodyClaim{{Claim: "sub", Field: "user_id"}},
+			expected: `{"user_id":"user-1"}`,
+		},
+		{
+			name:     "remove the spoofed field",
+			body:     `{"user_id":"user-2","role":"admin"}`,
+			rules:    []BodyClaim{{Claim: "role", Field: "role"}},
+			expected: `{"user_id":"user-2"}`,
+		},
+		{
+			name:     "keep",
+			body:     `{"user_id":"user-2"}`,
+			rules:    []BodyClaim{{Claim: "sub", Field: "user_id", OnConflict: BodyConflictKeep}},
+			expected: `{"user_id":"user-2"}`,
+		},
+		{
+			name:     "reject without conflict",
+			body:     `{"meta":{"tenant":42}}`,
+			rules:    []BodyClaim{{Claim: "tenant.id", Field: "meta.tenant", OnConflict: BodyConflictReject}},
+			expected: `{"meta":{"tenant":42}}`,
+		},
+		{
+			name:   "reject",
+			body:   `{"user_id":"user-2"}`,
+			rules:  []BodyClaim{{Claim: "sub", Field: "user_id", OnConflict: BodyConflictReject}},
+			reason: ReasonBodyConflict,
+		},
+		{
+			name:     "empty body",
+			rules:    []BodyClaim{{Claim: "sub", Field: "user_id"}},
+			expected: `{"user_id":"user-1"}`,
+		},
+		{
+			name:   "get without body",
+			method: http.MethodGet,
+			rules:  []BodyClaim{{Claim: "sub", Field: "user_id"}},
+		},
+		{
+			name:   "not an object",
+			body:   `[1,2]`,
+			rules:  []BodyClaim{{Claim: "sub", Field: "user_id"}},
+			reason: ReasonInvalidBody,
+		},
+		{
+			name:        "not json",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `user_id=user-2`,
+			rules:       []BodyClaim{{Claim: "sub", Field: "user_id"}},
+			reason:      ReasonInvalidBody,
+		},
+	} {
+		b, err := NewBodyInjector(tc.rules)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		method := tc.method
+		if method == "" {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, "/", strings.NewReader(tc.body))
+		contentType := tc.contentType
+		if contentType == "" && tc.body != "" {
+			contentType = "application/json; charset=utf-8"
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		authErr := b.Inject(req, claims)
+		if tc.reason != "" {
+			if authErr == nil || authErr.Reason != tc.reason || authErr.Status != http.StatusBadRequest {
+				t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+			}
+			continue
+		}
+		if authErr != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+			continue
+		}
+		body, _ := io.ReadAll(req.Body)
+		if string(body) != tc.expected {
+			t.Errorf("%s: unexpected body: %s", tc.name, body)
+		}
+		if tc.expected != "" && req.ContentLength != int64(len(tc.expected)) {
+			t.Errorf("%s: unexpected content length: %d", tc.name, req.ContentLength)
+		}
+	}
+}
+
+func TestNewBodyInjector(t *testing.T) {
+	if b, err := NewBodyInjector(nil); b != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", b, err)
+	}
+	if b := (*BodyInjector)(nil); b.Inject(httptest.NewRequest(http.MethodPost, "/", http.NoBody), nil) != nil {
+		t.Error("a nil injector should accept all the requests")
+	}
+	if _, err := NewBodyInjector([]BodyClaim{{Claim: "sub", Field: "user_id", OnConflict: "merge"}}); !errors.Is(err, ErrUnknownBodyConflictPolicy) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
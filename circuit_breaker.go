@@ -0,0 +1,195 @@
+package jose
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+	defaultHalfOpenProbes   = 1
+)
+
+var ErrCircuitOpen = errors.New("the circuit breaker of the key set backend is open")
+
+// CircuitBreakerConfig configures the circuit breaker around the requests to the key set backend
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit. Defaults to 5
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// OpenDuration is the time the circuit stays open before letting probes through, as "30s". Defaults to 30s
+	OpenDuration string `json:"open_duration,omitempty"`
+	// HalfOpenProbes is the number of concurrent requests allowed while the circuit is half-open. Defaults to 1
+	HalfOpenProbes int `json:"half_open_probes,omitempty"`
+}
+
+// CircuitBreaker tracks the failures of a backend and stops calling it while it is failing
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	openFor   time.Duration
+	probes    int
+	state     string
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	now       func() time.Time
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker
+func NewCircuitBreaker(cfg *CircuitBreakerConfig) (*CircuitBreaker, error) {
+	openFor, err := parseTimeout(cfg.OpenDuration)
+	if err != nil {
+		return nil, err
+	}
+	cb := &CircuitBreaker{
+		threshold: cfg.FailureThreshold,
+		openFor:   openFor,
+		probes:    cfg.HalfOpenProbes,
+		state:     CircuitClosed,
+		now:       time.Now,
+	}
+	if cb.threshold <= 0 {
+		cb.threshold = defaultFailureThreshold
+	}
+	if cb.openFor <= 0 {
+		cb.openFor = defaultOpenDuration
+	}
+	if cb.probes <= 0 {
+		cb.probes = defaultHalfOpenProbes
+	}
+	return cb, nil
+}
+
+// Allow returns true if the backend can be called. Every allowed call must be followed by a call to
+// Success or Failure.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.openFor {
+		cb.state = CircuitHalfOpen
+		cb.inFlight = 0
+	}
+
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if cb.inFlight >= cb.probes {
+			return false
+		}
+		cb.inFlight++
+	}
+	return true
+}
+
+// Success records a successful call, closing the circuit
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+	cb.inFlight = 0
+	cb.mu.Unlock()
+}
+
+// Failure records a failed call. The circuit opens when the threshold is reached or when a probe fails.
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+		cb.inFlight = 0
+	}
+}
+
+// State returns the state of the circuit
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.openFor {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// breakerTransport protects the key set backend with a circuit breaker. When the backend is failing or
+// the circuit is open, the last key set received is served instead.
+type breakerTransport struct {
+	next http.RoundTripper
+	cb   *CircuitBreaker
+
+	mu          sync.RWMutex
+	lastGood    []byte
+	contentType string
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.cb.Allow() {
+		return t.fallback(req, ErrCircuitOpen)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.cb.Failure()
+		return t.fallback(req, err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		t.cb.Failure()
+		if res, _ := t.fallback(req, nil); res != nil {
+			resp.Body.Close()
+			return res, nil
+		}
+		return resp, nil
+	}
+	t.cb.Success()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.lastGood = body
+	t.contentType = resp.Header.Get("Content-Type")
+	t.mu.Unlock()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// fallback returns the last known good key set. If there is none, it returns the error, or a nil
+// response if the error is nil.
+func (t *breakerTransport) fallback(req *http.Request, err error) (*http.Response, error) {
+	t.mu.RLock()
+	body, contentType := t.lastGood, t.contentType
+	t.mu.RUnlock()
+
+	if body == nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
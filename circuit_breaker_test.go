@@ -0,0 +1,135 @@
+package jose
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb, err := NewCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: "1m"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	if !cb.Allow() {
+		t.Error("a closed circuit should allow the calls")
+	}
+	cb.Failure()
+	if s := cb.State(); s != CircuitClosed {
+		t.Errorf("unexpected state: %s", s)
+	}
+	cb.Failure()
+	if s := cb.State(); s != CircuitOpen {
+		t.Errorf("unexpected state: %s", s)
+	}
+	if cb.Allow() {
+		t.Error("an open circuit should not allow the calls")
+	}
+
+	now = now.Add(time.Minute)
+	if s := cb.State(); s != CircuitHalfOpen {
+		t.Errorf("unexpected state: %s", s)
+	}
+	if !cb.Allow() {
+		t.Error("a half-open circuit should allow a probe")
+	}
+	if cb.Allow() {
+		t.Error("a half-open circuit should allow a single probe")
+	}
+	cb.Failure()
+	if s := cb.State(); s != CircuitOpen {
+		t.Errorf("a failed probe should open the circuit: %s", s)
+	}
+
+	now = now.Add(time.Minute)
+	if !cb.Allow() {
+		t.Error("a half-open circuit should allow a probe")
+	}
+	cb.Success()
+	if s := cb.State(); s != CircuitClosed {
+		t.Errorf("a successful probe should close the circuit: %s", s)
+	}
+
+	if _, err := NewCircuitBreaker(&CircuitBreakerConfig{OpenDuration: "wrong"}); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestBreakerTransport(t *testing.T) {
+	var failing int32
+	var calls uint32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddUint32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	cb, _ := NewCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: "1m"})
+	client := &http.Client{Transport: &breakerTransport{next: http.DefaultTransport, cb: cb}}
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected status code without a fallback: %d", resp.StatusCode)
+	}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cb.Success()
+	atomic.StoreInt32(&failing, 0)
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 3; i++ {
+		resp, err = client.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != `{"keys":[]}` {
+			t.Errorf("unexpected fallback: %d %s", resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type: %s", ct)
+		}
+	}
+	if c := atomic.LoadUint32(&calls); c != 3 {
+		t.Errorf("the open circuit should not call the backend. calls: %d", c)
+	}
+}
+
+func TestSecretProvider_circuitBreaker(t *testing.T) {
+	if _, err := SecretProvider(SecretProviderConfig{
+		URI:            "http://example.com",
+		AllowInsecure:  true,
+		CircuitBreaker: &CircuitBreakerConfig{OpenDuration: "wrong"},
+	}, nil); err == nil {
+		t.Error("error expected")
+	}
+}
@@ -0,0 +1,78 @@
+package jose
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// The matchers compare the claims as strings, whatever the types emitted by the identity provider. The
+// scalar claims are coerced with claimString, and the arrays element by element, skipping the elements
+// that can not be compared (the nulls, the objects and the nested arrays). A claim of an unexpected type
+// never matches, and it never panics.
+
+// claimString returns the string representation of the scalar claims
+func claimString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// claimValues returns the strings of an array claim, or the fields of a space separated string claim
+func claimValues(v interface{}) []string {
+	switch v := v.(type) {
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := claimString(e); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	case string:
+		return strings.Fields(v)
+	default:
+		return nil
+	}
+}
+
+// claimContains returns true if the array claim has an element equal to any of the values, once coerced,
+// or the space separated string claim has a field equal to any of them. The other claims never match. It
+// does not allocate for the string elements.
+func claimContains(v interface{}, values []string) bool {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			s, ok := claimString(e)
+			if !ok {
+				continue
+			}
+			for _, value := range values {
+				if s == value {
+					return true
+				}
+			}
+		}
+		return false
+	case string:
+		for _, value := range values {
+			if hasField(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
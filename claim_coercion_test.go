@@ -0,0 +1,66 @@
+package jose
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClaimMatchers_mixedTypes(t *testing.T) {
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+		"roles": [null, {"name": "admin"}, ["nested"], 42, 1.5, true, "role_a"],
+		"scp": ["read", 7, {"a": 1}, "write"],
+		"empty": [],
+		"obj": {"a": [1, "b"]},
+		"acr": 2
+	}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		key      string
+		required []string
+		expected bool
+	}{
+		{key: "roles", required: []string{"role_a"}, expected: true},
+		{key: "roles", required: []string{"42"}, expected: true},
+		{key: "roles", required: []string{"1.5"}, expected: true},
+		{key: "roles", required: []string{"true"}, expected: true},
+		{key: "roles", required: []string{"admin"}},
+		{key: "roles", required: []string{"nested"}},
+		{key: "roles", required: []string{"<nil>"}},
+		{key: "empty", required: []string{""}},
+		{key: "obj", required: []string{"1"}},
+		{key: "acr", required: []string{"2"}},
+	} {
+		p := NewClaimPath(tc.key, true)
+		if res := CanAccessPath(p, claims, tc.required); res != tc.expected {
+			t.Errorf("%s %v: unexpected result: %v", tc.key, tc.required, res)
+		}
+		if res := CanAccessPathConstantTime(p, claims, tc.required); res != tc.expected {
+			t.Errorf("%s %v: unexpected result of the constant time matcher: %v", tc.key, tc.required, res)
+		}
+	}
+
+	if !ScopesAllMatcher("scp", claims, []string{"read", "write", "7"}) {
+		t.Error("the scopes array should match")
+	}
+	if ScopesAnyMatcher("obj", claims, []string{"a"}) || ScopesAnyMatcher("roles", claims, []string{"admin"}) {
+		t.Error("the objects should not match")
+	}
+	if missing := MissingScopes("scp", claims, []string{"read", "delete"}); len(missing) != 1 || missing[0] != "delete" {
+		t.Errorf("unexpected missing scopes: %v", missing)
+	}
+	if !CustomFieldsMatcher(claims, map[string]string{"roles": "42", "acr": "2"}) {
+		t.Error("the numbers should match by their text")
+	}
+
+	for _, key := range []string{"roles", "scp", "empty", "obj", "acr"} {
+		if s, ok := Claims(claims).Get(key); !ok {
+			t.Errorf("%s: missing claim %q", key, s)
+		}
+	}
+	if s, _ := Claims(claims).Get("roles"); s != `null,{"name":"admin"},nested,42,1.5,true,role_a` {
+		t.Errorf("unexpected normalized claim: %s", s)
+	}
+}
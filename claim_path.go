@@ -0,0 +1,444 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClaimPath is a claim name, or a dot separated path to a nested claim, split once when the validator is
+// created instead of on every request
+type ClaimPath struct {
+	name string
+	keys []string
+	// next is the path looked up when the claim is missing
+	next *ClaimPath
+}
+
+// NewClaimPath returns the path of the claim. If nested is false, or the name has no dots, the name is
+// used as a single key.
+func NewClaimPath(name string, nested bool) ClaimPath {
+	p := ClaimPath{name: name}
+	if nested && strings.Contains(name, ".") {
+		p.keys = strings.Split(name, ".")
+	}
+	return p
+}
+
+// NewClaimPaths returns a path looking up the claims in order, until one is found, so the same config
+// works with the tokens of the identity providers naming the claim differently, as scope and scp
+func NewClaimPaths(names []string, nested bool) ClaimPath {
+	if len(names) == 0 {
+		return NewClaimPath("", nested)
+	}
+	p := NewClaimPath(names[0], nested)
+	if len(names) > 1 {
+		next := NewClaimPaths(names[1:], nested)
+		p.next = &next
+	}
+	return p
+}
+
+// Name returns the name the path was created from
+func (p ClaimPath) Name() string {
+	return p.name
+}
+
+// Lookup returns the value of the claim, or the value of the first alternative claim found
+func (p ClaimPath) Lookup(claims map[string]interface{}) (interface{}, bool) {
+	v, ok := p.lookup(claims)
+	if !ok && p.next != nil {
+		return p.next.Lookup(claims)
+	}
+	return v, ok
+}
+
+func (p ClaimPath) lookup(claims map[string]interface{}) (interface{}, bool) {
+	if p.keys == nil {
+		v, ok := claims[p.name]
+		return v, ok
+	}
+
+	tmp := claims
+	last := len(p.keys) - 1
+	for _, key := range p.keys[:last] {
+		v, ok := tmp[key]
+		if !ok {
+			return nil, false
+		}
+		if tmp, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	v, ok := tmp[p.keys[last]]
+	return v, ok
+}
+
+// Get returns the value of the claim normalized as a string, as Claims.Get does
+func (p ClaimPath) Get(claims map[string]interface{}) (string, bool) {
+	v, ok := p.Lookup(claims)
+	if !ok {
+		return "", false
+	}
+	return normalizeClaim(v), true
+}
+
+// CanAccessPath returns true if the roles claim contains any of the required roles. The numeric and
+// boolean roles are compared by their text.
+func CanAccessPath(path ClaimPath, claims map[string]interface{}, required []string) bool {
+	if len(required) == 0 {
+		return true
+	}
+
+	tmp, ok := path.Lookup(claims)
+	if !ok {
+		return false
+	}
+	return claimContains(tmp, required)
+}
+
+// ScopesAllPathMatcher returns true if the (space separated) scopes claim contains all the required scopes
+func ScopesAllPathMatcher(path ClaimPath, claims map[string]interface{}, requiredScopes []string) bool {
+	if len(requiredScopes) == 0 {
+		return true
+	}
+
+	scopes, ok := scopesClaim(path, claims)
+	if !ok {
+		return false
+	}
+	for _, rScope := range requiredScopes {
+		if !hasField(scopes, rScope) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopesAnyPathMatcher returns true if the (space separated) scopes claim contains any of the required scopes
+func ScopesAnyPathMatcher(path ClaimPath, claims map[string]interface{}, requiredScopes []string) bool {
+	if len(requiredScopes) == 0 {
+		return true
+	}
+
+	scopes, ok := scopesClaim(path, claims)
+	if !ok {
+		return false
+	}
+	for _, rScope := range requiredScopes {
+		if hasField(scopes, rScope) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesMatcherAtLeast is the scopes_matcher requiring a minimum number of the scopes, set with the
+// scopes_min_count
+const ScopesMatcherAtLeast = "at_least"
+
+// ScopesAtLeastPathMatcher returns a matcher accepting the (space separated) scopes claims containing at
+// least n of the required scopes, as any 2 of 3 regional scopes. If n is not positive, or greater than the
+// number of required scopes, all of them are required.
+func ScopesAtLeastPathMatcher(n int) func(ClaimPath, map[string]interface{}, []string) bool {
+	return func(path ClaimPath, claims map[string]interface{}, requiredScopes []string) bool {
+		if len(requiredScopes) == 0 {
+			return true
+		}
+		min := n
+		if min <= 0 || min > len(requiredScopes) {
+			min = len(requiredScopes)
+		}
+
+		scopes, ok := scopesClaim(path, claims)
+		if !ok {
+			return false
+		}
+		found := 0
+		for _, rScope := range requiredScopes {
+			if hasField(scopes, rScope) {
+				found++
+				if found == min {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// ScopesDefaultPathMatcher accepts all the claims
+func ScopesDefaultPathMatcher(_ ClaimPath, _ map[string]interface{}, _ []string) bool {
+	return true
+}
+
+// MissingScopesPath returns the required scopes not present in the (space separated) scopes claim
+func MissingScopesPath(path ClaimPath, claims map[string]interface{}, requiredScopes []string) []string {
+	scopes, _ := scopesClaim(path, claims)
+
+	missing := []string{}
+	for _, rScope := range requiredScopes {
+		if !hasField(scopes, rScope) {
+			missing = append(missing, rScope)
+		}
+	}
+	return missing
+}
+
+func scopesClaim(path ClaimPath, claims map[string]interface{}) (string, bool) {
+	tmp, ok := path.Lookup(claims)
+	if !ok {
+		return "", false
+	}
+	if scopes, ok := tmp.([]interface{}); ok {
+		// the scopes emitted as an array, as the scp claim of some identity providers
+		return strings.Join(claimValues(scopes), " "), true
+	}
+	scopes, ok := tmp.(string)
+	return scopes, ok
+}
+
+// hasField returns true if field is one of the space separated fields of s. It behaves as looking for
+// the field in the result of strings.Split(s, " "), without allocating it.
+func hasField(s, field string) bool {
+	for {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			return s == field
+		}
+		if s[:i] == field {
+			return true
+		}
+		s = s[i+1:]
+	}
+}
+
+// Formats of the propagated claims
+const (
+	PropagationFormatRFC3339 = "rfc3339"
+	PropagationFormatJSON    = "json"
+)
+
+// Encodings of the propagated claims
+const (
+	PropagationEncodingNone      = "none"
+	PropagationEncodingBase64    = "base64"
+	PropagationEncodingBase64URL = "base64url"
+	PropagationEncodingURL       = "url"
+)
+
+var (
+	ErrUnknownPropagationOption = errors.New("unknown propagation option")
+
+	claimIndexPattern = regexp.MustCompile(`^(.+)\[(-?\d+)\]$`)
+)
+
+// HeadersPropagator copies claims to headers. The propagation config is parsed once, when it is created.
+type HeadersPropagator struct {
+	entries []propagationEntry
+}
+
+type propagationEntry struct {
+	path     ClaimPath
+	header   string
+	hash     bool
+	format   string
+	hasIndex bool
+	index    int
+	join     string
+	encoding string
+	// buffered is true if the hash or the encoding of the entry use a propagationBuffer
+	buffered bool
+}
+
+// NewHeadersPropagator parses the propagation config: a list of claim, header and, optionally, the options
+// of the entry. The claim can end with an index, as groups[0], to select an element of an array claim.
+// Negative indexes count from the end of the array. The options are:
+//   - a bool enabling the SHA1 hash of the value
+//   - format=rfc3339, rendering the numeric timestamps as RFC3339 dates
+//   - format=json, rendering the value as JSON
+//   - join=<delimiter>, joining the elements of the array claims with the delimiter instead of commas
+//   - encoding=base64, encoding=base64url (without padding) or encoding=url, encoding the value once
+//     rendered and, if enabled, hashed. Without encoding, the characters not allowed in the header values
+//     (the control characters, as the line breaks) are percent-encoded.
+//
+// The unknown options are ignored.
+func NewHeadersPropagator(propagationCfg [][]string) *HeadersPropagator {
+	p := &HeadersPropagator{entries: make([]propagationEntry, 0, len(propagationCfg))}
+	for _, tuple := range propagationCfg {
+		e, _ := newPropagationEntry(tuple)
+		p.entries = append(p.entries, e)
+	}
+	return p
+}
+
+// newPropagationEntry parses an entry of the propagation config, returning the error of the first
+// unknown option
+func newPropagationEntry(tuple []string) (propagationEntry, error) {
+	fromClaim := tuple[0]
+	e := propagationEntry{header: tuple[1], join: ","}
+	if m := claimIndexPattern.FindStringSubmatch(fromClaim); m != nil {
+		fromClaim = m[1]
+		e.hasIndex = true
+		e.index, _ = strconv.Atoi(m[2])
+	}
+	e.path = NewClaimPath(fromClaim, len(fromClaim) < 4 || fromClaim[:4] != "http")
+
+	var err error
+	for _, opt := range tuple[2:] {
+		if boolValue, perr := strconv.ParseBool(opt); perr == nil {
+			e.hash = boolValue
+			continue
+		}
+		switch {
+		case opt == "format="+PropagationFormatRFC3339:
+			e.format = PropagationFormatRFC3339
+		case opt == "format="+PropagationFormatJSON:
+			e.format = PropagationFormatJSON
+		case strings.HasPrefix(opt, "join="):
+			e.join = opt[len("join="):]
+		case opt == "encoding="+PropagationEncodingNone, opt == "encoding="+PropagationEncodingBase64,
+			opt == "encoding="+PropagationEncodingBase64URL, opt == "encoding="+PropagationEncodingURL:
+			e.encoding = opt[len("encoding="):]
+		default:
+			if err == nil {
+				err = fmt.Errorf("%w: %s", ErrUnknownPropagationOption, opt)
+			}
+		}
+	}
+	e.buffered = e.hash || e.encoding == PropagationEncodingBase64 || e.encoding == PropagationEncodingBase64URL
+	return e, err
+}
+
+// Propagate returns the headers to add to the request
+func (p *HeadersPropagator) Propagate(claims map[string]interface{}) map[string]string {
+	propagated := make(map[string]string, len(p.entries))
+	p.PropagateFunc(claims, func(header, value string) {
+		propagated[header] = value
+	})
+	return propagated
+}
+
+// PropagateFunc calls set with each header to add to the request, without allocating the map returned by
+// Propagate. It is safe for concurrent use.
+func (p *HeadersPropagator) PropagateFunc(claims map[string]interface{}, set func(header, value string)) {
+	var b *propagationBuffer
+	for i := range p.entries {
+		e := &p.entries[i]
+		v, ok := e.value(claims)
+		if !ok {
+			continue
+		}
+		if e.buffered && b == nil {
+			b = getPropagationBuffer()
+		}
+		if e.hash {
+			v = b.sha1Hex(v)
+		}
+		set(e.header, e.encode(v, b))
+	}
+	if b != nil {
+		putPropagationBuffer(b)
+	}
+}
+
+func (e *propagationEntry) value(claims map[string]interface{}) (string, bool) {
+	v, ok := e.path.Lookup(claims)
+	if !ok {
+		return "", false
+	}
+	if e.hasIndex {
+		elems, ok := v.([]interface{})
+		if !ok {
+			return "", false
+		}
+		i := e.index
+		if i < 0 {
+			i += len(elems)
+		}
+		if i < 0 || i >= len(elems) {
+			return "", false
+		}
+		v = elems[i]
+	}
+
+	switch e.format {
+	case PropagationFormatRFC3339:
+		if secs, ok := numericClaim(v); ok {
+			return time.Unix(secs, 0).UTC().Format(time.RFC3339), true
+		}
+	case PropagationFormatJSON:
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	}
+
+	if elems, ok := v.([]interface{}); ok {
+		values := make([]string, len(elems))
+		for i, elem := range elems {
+			values[i] = normalizeClaim(elem)
+		}
+		return strings.Join(values, e.join), true
+	}
+	return normalizeClaim(v), true
+}
+
+// encode returns the value with the encoding of the entry. The buffer is only used by the buffered entries.
+func (e *propagationEntry) encode(v string, b *propagationBuffer) string {
+	switch e.encoding {
+	case PropagationEncodingBase64:
+		return b.encode(base64.StdEncoding, v)
+	case PropagationEncodingBase64URL:
+		return b.encode(base64.RawURLEncoding, v)
+	case PropagationEncodingURL:
+		return url.PathEscape(v)
+	}
+	return escapeHeaderValue(v)
+}
+
+// escapeHeaderValue percent-encodes the control characters, except the horizontal tab, so the value can
+// not inject headers nor break the request
+func escapeHeaderValue(v string) string {
+	i := strings.IndexFunc(v, isHeaderControl)
+	if i < 0 {
+		return v
+	}
+	var b strings.Builder
+	b.WriteString(v[:i])
+	for j := i; j < len(v); j++ {
+		if c := v[j]; isHeaderControl(rune(c)) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHeaderControl(r rune) bool {
+	return (r < ' ' && r != '\t') || r == 0x7f
+}
+
+// numericClaim returns the integer value of the numeric claims
+func numericClaim(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		return int64(f), err == nil
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
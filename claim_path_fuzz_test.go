@@ -0,0 +1,86 @@
+//go:build go1.18
+// +build go1.18
+
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func FuzzClaimPath(f *testing.F) {
+	claims := `{"sub":"1234","a":{"b":{"c":"nested"},"roles":["x","y"]},"a.b":"flat","n":42}`
+	for _, path := range []string{"sub", "a.b.c", "a.b", "a.roles", "a..b", ".a", "a.", "", "n.x", "http://a.b/c"} {
+		f.Add(path, claims)
+	}
+	f.Add("a.b", `{"a":{"b":[]}}`)
+	f.Add("a", `{"a":null}`)
+
+	f.Fuzz(func(t *testing.T, path, data string) {
+		claims := map[string]interface{}{}
+		if json.Unmarshal([]byte(data), &claims) != nil {
+			return
+		}
+
+		v, ok := NewClaimPath(path, true).Lookup(claims)
+		key, parent := getNestedClaim(path, claims)
+		if ok {
+			if parent == nil || !reflect.DeepEqual(parent[key], v) {
+				t.Errorf("getNestedClaim(%q) disagrees with the lookup: %v", path, v)
+			}
+		} else if parent != nil && strings.Contains(path, ".") {
+			if _, found := parent[key]; found {
+				t.Errorf("getNestedClaim(%q) found a claim missed by the lookup", path)
+			}
+		}
+
+		if s, found := NewClaimPath(path, true).Get(claims); found != ok || (ok && s != normalizeClaim(v)) {
+			t.Errorf("unexpected value of %q: %q", path, s)
+		}
+		CanAccessPath(NewClaimPath(path, true), claims, []string{"x"})
+		ScopesAllPathMatcher(NewClaimPath(path, true), claims, []string{"x"})
+		ScopesAnyPathMatcher(NewClaimPath(path, true), claims, []string{"x"})
+		CustomFieldsMatcher(claims, map[string]string{path: "x|y"})
+	})
+}
+
+func FuzzClaims_Get(f *testing.F) {
+	for _, value := range []string{`"text"`, `42`, `1.5`, `1e300`, `12345678901234567890`, `true`, `null`,
+		`[]`, `["a",1,null]`, `[[1],{"a":2}]`, `{}`, `{"a":"b"}`} {
+		f.Add(value)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		var v, number interface{}
+		if json.Unmarshal([]byte(data), &v) != nil {
+			return
+		}
+		d := json.NewDecoder(bytes.NewReader([]byte(data)))
+		d.UseNumber()
+		if d.Decode(&number) != nil {
+			t.Fatalf("the value decodes without json.Number: %s", data)
+		}
+
+		for _, c := range []Claims{{"claim": v}, {"claim": number}} {
+			s, ok := c.Get("claim")
+			if !ok {
+				t.Errorf("the claim is missing: %s", data)
+			}
+			if str, isString := c["claim"].(string); isString && s != str {
+				t.Errorf("the string claim is modified: %q", s)
+			}
+			if n, isNumber := c["claim"].(json.Number); isNumber && s != n.String() {
+				t.Errorf("the number claim is modified: %q", s)
+			}
+			if s2, _ := c.String("claim"); s2 != s {
+				t.Errorf("Get and String disagree: %q %q", s, s2)
+			}
+			c.Strings("claim")
+			c.Int64("claim")
+			CustomFieldsMatcher(c, map[string]string{"claim": s})
+		}
+	})
+}
@@ -0,0 +1,413 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestClaimPath_Lookup(t *testing.T) {
+	claims := map[string]interface{}{
+		"a.b": "flat",
+		"a": map[string]interface{}{
+			"b": "nested",
+			"c": map[string]interface{}{"d": 42.0},
+		},
+		"e": "not a map",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		nested   bool
+		expected interface{}
+		found    bool
+	}{
+		{name: "a.b", expected: "flat", found: true},
+		{name: "a.b", nested: true, expected: "nested", found: true},
+		{name: "a.c.d", nested: true, expected: 42.0, found: true},
+		{name: "a.x.d", nested: true},
+		{name: "e.f", nested: true},
+		{name: "unknown"},
+	} {
+		p := NewClaimPath(tc.name, tc.nested)
+		v, ok := p.Lookup(claims)
+		if ok != tc.found || v != tc.expected {
+			t.Errorf("%s (nested: %v): unexpected result: %v %v", tc.name, tc.nested, v, ok)
+		}
+		if p.Name() != tc.name {
+			t.Errorf("unexpected name: %s", p.Name())
+		}
+	}
+
+	if v, ok := NewClaimPath("a.c.d", true).Get(claims); !ok || v != "42" {
+		t.Errorf("unexpected normalized value: %s", v)
+	}
+}
+
+func TestHasField(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		field    string
+		expected bool
+	}{
+		{s: "read write", field: "read", expected: true},
+		{s: "read write", field: "write", expected: true},
+		{s: "read write", field: "rea"},
+		{s: "read  write", field: "", expected: true},
+		{s: "read write", field: ""},
+		{s: "", field: "", expected: true},
+		{s: "", field: "read"},
+	} {
+		if res := hasField(tc.s, tc.field); res != tc.expected {
+			t.Errorf("%q %q: unexpected result: %v", tc.s, tc.field, res)
+		}
+	}
+}
+
+func TestScopesAtLeastPathMatcher(t *testing.T) {
+	p := NewClaimPath("scope", true)
+	claims := map[string]interface{}{"scope": "openid eu us"}
+	required := []string{"eu", "us", "apac"}
+
+	for _, tc := range []struct {
+		n        int
+		claims   map[string]interface{}
+		required []string
+		expected bool
+	}{
+		{n: 1, claims: claims, required: required, expected: true},
+		{n: 2, claims: claims, required: required, expected: true},
+		{n: 3, claims: claims, required: required},
+		{n: 0, claims: claims, required: required},
+		{n: 4, claims: claims, required: []string{"eu", "us"}, expected: true},
+		{n: 2, claims: map[string]interface{}{"scope": "eu eu"}, required: required},
+		{n: 2, claims: map[string]interface{}{"scope": []interface{}{"us", "apac"}}, required: required, expected: true},
+		{n: 1, claims: map[string]interface{}{}, required: required},
+		{n: 2, claims: map[string]interface{}{}, expected: true},
+	} {
+		if res := ScopesAtLeastPathMatcher(tc.n)(p, tc.claims, tc.required); res != tc.expected {
+			t.Errorf("%d %v %v: unexpected result: %v", tc.n, tc.claims, tc.required, res)
+		}
+	}
+}
+
+func TestHeadersPropagator(t *testing.T) {
+	p := NewHeadersPropagator([][]string{
+		{"sub", "x-sub"},
+		{"user.email", "x-email", "true"},
+		{"http://example.com/tenant", "x-tenant"},
+		{"unknown", "x-unknown"},
+	})
+	headers := p.Propagate(map[string]interface{}{
+		"sub":                       "1234",
+		"user":                      map[string]interface{}{"email": "john@example.com"},
+		"http://example.com/tenant": "acme",
+	})
+
+	if headers["x-sub"] != "1234" || headers["x-tenant"] != "acme" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	if headers["x-email"] != "5224cb6fdd5bbe463af1db8ee499e858fcb79f81" {
+		t.Errorf("unexpected hashed header: %s", headers["x-email"])
+	}
+	if _, ok := headers["x-unknown"]; ok {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+}
+
+func TestHeadersPropagator_format(t *testing.T) {
+	p := NewHeadersPropagator([][]string{
+		{"id", "x-id"},
+		{"ratio", "x-ratio"},
+		{"admin", "x-admin"},
+		{"iat", "x-iat", "format=rfc3339"},
+		{"sub", "x-sub", "format=rfc3339"},
+		{"profile", "x-profile", "format=json"},
+		{"iat", "x-iat-hash", "format=rfc3339", "true"},
+	})
+	headers := p.Propagate(map[string]interface{}{
+		"id":      json.Number("9007199254740993"),
+		"ratio":   0.25,
+		"admin":   true,
+		"iat":     json.Number("1651529725"),
+		"sub":     "1234",
+		"profile": map[string]interface{}{"name": "john"},
+	})
+
+	for header, expected := range map[string]string{
+		"x-id":       "9007199254740993",
+		"x-ratio":    "0.25",
+		"x-admin":    "true",
+		"x-iat":      "2022-05-02T22:15:25Z",
+		"x-sub":      "1234",
+		"x-profile":  `{"name":"john"}`,
+		"x-iat-hash": "8011e5bce4489e9bf3c384cef88dd8ff8e27d37e",
+	} {
+		if v := headers[header]; v != expected {
+			t.Errorf("%s: unexpected value: %s", header, v)
+		}
+	}
+}
+
+func TestHeadersPropagator_arrays(t *testing.T) {
+	p := NewHeadersPropagator([][]string{
+		{"groups", "x-groups"},
+		{"groups", "x-groups-pipe", "join=|"},
+		{"groups[0]", "x-first-group"},
+		{"groups[-1]", "x-last-group"},
+		{"groups[3]", "x-missing-group"},
+		{"sub[0]", "x-sub"},
+		{"user.ids[1]", "x-id"},
+		{"empty", "x-empty"},
+	})
+	headers := p.Propagate(map[string]interface{}{
+		"groups": []interface{}{"admin", "dev", "ops"},
+		"sub":    "1234",
+		"user":   map[string]interface{}{"ids": []interface{}{json.Number("1"), json.Number("9007199254740993")}},
+		"empty":  []interface{}{},
+	})
+
+	expected := map[string]string{
+		"x-groups":      "admin,dev,ops",
+		"x-groups-pipe": "admin|dev|ops",
+		"x-first-group": "admin",
+		"x-last-group":  "ops",
+		"x-id":          "9007199254740993",
+		"x-empty":       "",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+}
+
+func TestHeadersPropagator_encoding(t *testing.T) {
+	p := NewHeadersPropagator([][]string{
+		{"name", "x-name"},
+		{"name", "x-name-none", "encoding=none"},
+		{"name", "x-name-b64", "encoding=base64"},
+		{"name", "x-name-b64url", "encoding=base64url"},
+		{"name", "x-name-url", "encoding=url"},
+		{"groups", "x-groups", "join= ", "encoding=url"},
+		{"evil", "x-evil"},
+	})
+	headers := p.Propagate(map[string]interface{}{
+		"name":   "José?>",
+		"groups": []interface{}{"a b", "c"},
+		"evil":   "john\r\nX-Admin: true\tok\x7f",
+	})
+
+	expected := map[string]string{
+		"x-name":        "José?>",
+		"x-name-none":   "José?>",
+		"x-name-b64":    "Sm9zw6k/Pg==",
+		"x-name-b64url": "Sm9zw6k_Pg",
+		"x-name-url":    "Jos%C3%A9%3F%3E",
+		"x-groups":      "a%20b%20c",
+		"x-evil":        "john%0D%0AX-Admin: true\tok%7F",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	if _, err := newPropagationEntry([]string{"name", "x-name", "encoding=hex"}); !errors.Is(err, ErrUnknownPropagationOption) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewPropagationEntry(t *testing.T) {
+	if _, err := newPropagationEntry([]string{"sub", "x-sub", "false", "format=json"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := newPropagationEntry([]string{"sub", "x-sub", "format=xml"}); !errors.Is(err, ErrUnknownPropagationOption) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHeadersPropagator_PropagateFunc(t *testing.T) {
+	p := NewHeadersPropagator(benchmarkPropagationCfg)
+	expected := map[string]string{
+		"x-sub":      "1234567890",
+		"x-tenant":   "acme",
+		"x-roles":    "offline_access,uma_authorization,admin",
+		"x-sub-hash": "01b307acba4f54f55aafc33bb06bbbf6ca803e9a",
+		"x-scope":    "b3BlbmlkIHByb2ZpbGUgZW1haWwgcmVhZDp1c2VycyB3cml0ZTp1c2Vycw==",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				headers := map[string]string{}
+				p.PropagateFunc(benchmarkClaims, func(k, v string) { headers[k] = v })
+				if !reflect.DeepEqual(headers, expected) {
+					t.Errorf("unexpected headers: %v", headers)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCachedHeadersPropagator(t *testing.T) {
+	cfg := [][]string{{"sub", "x-sub"}}
+	p := cachedHeadersPropagator(cfg)
+	if cachedHeadersPropagator(cfg) != p {
+		t.Error("the plan should be reused")
+	}
+
+	cfg[0][1] = "x-user"
+	if headers := cachedHeadersPropagator(cfg).Propagate(benchmarkClaims); headers["x-user"] != "1234567890" {
+		t.Errorf("the plan should be replaced once the config is modified: %v", headers)
+	}
+}
+
+var benchmarkClaims = map[string]interface{}{
+	"sub":   "1234567890",
+	"scope": "openid profile email read:users write:users",
+	"realm_access": map[string]interface{}{
+		"roles": []interface{}{"offline_access", "uma_authorization", "admin"},
+	},
+	"user": map[string]interface{}{
+		"tenant": map[string]interface{}{"id": "acme"},
+	},
+}
+
+var benchmarkResult bool
+
+func BenchmarkCanAccessNested(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = CanAccessNested("realm_access.roles", benchmarkClaims, []string{"admin"})
+	}
+}
+
+func BenchmarkCanAccess(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = CanAccess("sub", benchmarkClaims, []string{"1234567890"})
+	}
+}
+
+func BenchmarkCanAccessPath(b *testing.B) {
+	p := NewClaimPath("realm_access.roles", true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = CanAccessPath(p, benchmarkClaims, []string{"admin"})
+	}
+}
+
+func BenchmarkScopesAllMatcher(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = ScopesAllMatcher("scope", benchmarkClaims, []string{"read:users", "write:users"})
+	}
+}
+
+func BenchmarkScopesAllPathMatcher(b *testing.B) {
+	p := NewClaimPath("scope", true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = ScopesAllPathMatcher(p, benchmarkClaims, []string{"read:users", "write:users"})
+	}
+}
+
+func BenchmarkScopesAnyMatcher(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = ScopesAnyMatcher("scope", benchmarkClaims, []string{"admin", "write:users"})
+	}
+}
+
+func BenchmarkCustomFieldsMatcher(b *testing.B) {
+	wanted := map[string]string{"user.tenant.id": "foo|acme", "realm_access.roles": "admin"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkResult = CustomFieldsMatcher(benchmarkClaims, wanted)
+	}
+}
+
+func BenchmarkClaims_Get(b *testing.B) {
+	c := Claims(benchmarkClaims)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, benchmarkResult = c.Get("sub")
+	}
+}
+
+func BenchmarkClaims_String(b *testing.B) {
+	c := Claims(benchmarkClaims)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, benchmarkResult = c.String("realm_access.roles")
+	}
+}
+
+func BenchmarkCalculateHeadersToPropagate(b *testing.B) {
+	cfg := [][]string{{"sub", "x-sub"}, {"user.tenant.id", "x-tenant"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CalculateHeadersToPropagate(cfg, benchmarkClaims)
+	}
+}
+
+func BenchmarkHeadersPropagator(b *testing.B) {
+	p := NewHeadersPropagator([][]string{{"sub", "x-sub"}, {"user.tenant.id", "x-tenant"}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Propagate(benchmarkClaims)
+	}
+}
+
+var benchmarkPropagationCfg = [][]string{
+	{"sub", "x-sub"},
+	{"user.tenant.id", "x-tenant"},
+	{"realm_access.roles", "x-roles"},
+	{"sub", "x-sub-hash", "true"},
+	{"scope", "x-scope", "encoding=base64"},
+}
+
+func BenchmarkCalculateHeadersToPropagate_options(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CalculateHeadersToPropagate(benchmarkPropagationCfg, benchmarkClaims)
+	}
+}
+
+func BenchmarkHeadersPropagator_options(b *testing.B) {
+	p := NewHeadersPropagator(benchmarkPropagationCfg)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Propagate(benchmarkClaims)
+	}
+}
+
+func BenchmarkHeadersPropagator_parallel(b *testing.B) {
+	p := NewHeadersPropagator(benchmarkPropagationCfg)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Propagate(benchmarkClaims)
+		}
+	})
+}
+
+func BenchmarkHeadersPropagator_func(b *testing.B) {
+	p := NewHeadersPropagator(benchmarkPropagationCfg)
+	set := func(_, _ string) {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.PropagateFunc(benchmarkClaims, set)
+	}
+}
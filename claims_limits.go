@@ -0,0 +1,153 @@
+package jose
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultMaxTokenSize      = 8192
+	defaultMaxClaimsDepth    = 8
+	defaultMaxClaimsArrayLen = 256
+)
+
+// ErrClaimsLimitExceeded is returned for the tokens exceeding the limits of the ClaimsLimitsConfig
+var ErrClaimsLimitExceeded = errors.New("the token exceeds the claims limits")
+
+// ClaimsLimitsConfig rejects the pathological tokens before they are parsed or verified, so a huge or
+// deeply nested payload can not exhaust the resources of the gateway. The claims of the accepted tokens are
+// bounded, so the lookups of the nested claims and the matchers iterating their arrays are bounded too.
+// The nesting and the arrays are only checked for the JOSE tokens.
+type ClaimsLimitsConfig struct {
+	// MaxTokenSize is the maximum length of the raw token, in bytes. Defaults to 8192
+	MaxTokenSize int `json:"max_token_size,omitempty"`
+	// MaxDepth is the maximum nesting of the objects and arrays of the payload. The payload itself is at
+	// depth 1. Defaults to 8
+	MaxDepth int `json:"max_depth,omitempty"`
+	// MaxArrayLength is the maximum number of elements of each array of the payload. Defaults to 256
+	MaxArrayLength int `json:"max_array_length,omitempty"`
+}
+
+func (c *ClaimsLimitsConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxTokenSize < 0 || c.MaxDepth < 0 || c.MaxArrayLength < 0 {
+		return errors.New("the limits can not be negative")
+	}
+	return nil
+}
+
+func (c *ClaimsLimitsConfig) limits() (int, int, int) {
+	size, depth, arrayLen := c.MaxTokenSize, c.MaxDepth, c.MaxArrayLength
+	if size == 0 {
+		size = defaultMaxTokenSize
+	}
+	if depth == 0 {
+		depth = defaultMaxClaimsDepth
+	}
+	if arrayLen == 0 {
+		arrayLen = defaultMaxClaimsArrayLen
+	}
+	return size, depth, arrayLen
+}
+
+// claimsLimitsValidator checks the raw token of the request against the limits before the validator
+// parses it
+func claimsLimitsValidator(signatureConfig *SignatureConfig, v ClaimsValidator) ClaimsValidator {
+	cfg := signatureConfig.ClaimsLimits
+	if cfg == nil {
+		return v
+	}
+	scan := signatureConfig.TokenFormat != TokenFormatPaseto && signatureConfig.TokenFormat != TokenFormatBiscuit
+	return func(r *http.Request) (map[string]interface{}, error) {
+		if err := cfg.check(requestRawToken(r, signatureConfig.CookieKey), scan); err != nil {
+			return nil, err
+		}
+		return v(r)
+	}
+}
+
+// check returns an ErrClaimsLimitExceeded error if the raw token exceeds the limits. The payload of the
+// compact JWS is decoded and scanned, without building the claims, if scan is true.
+func (c *ClaimsLimitsConfig) check(raw string, scan bool) error {
+	maxSize, maxDepth, maxArrayLen := c.limits()
+	if len(raw) > maxSize {
+		return fmt.Errorf("%w: the token has %d bytes", ErrClaimsLimitExceeded, len(raw))
+	}
+	if !scan {
+		return nil
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		// the malformed tokens are rejected by the parser and the JWE payloads can not be inspected
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	return checkJSONLimits(payload, maxDepth, maxArrayLen)
+}
+
+// checkJSONLimits scans the JSON document and returns an ErrClaimsLimitExceeded error if it is nested
+// deeper than maxDepth or one of its arrays has more than maxArrayLen elements. Malformed documents are
+// left to the decoder.
+func checkJSONLimits(data []byte, maxDepth, maxArrayLen int) error {
+	// the element counts of the open arrays, -1 for the open objects
+	var open []int
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '}', ']':
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			continue
+		case ',':
+			if last := len(open) - 1; last >= 0 && open[last] >= 0 {
+				open[last]++
+				if open[last] > maxArrayLen {
+					return fmt.Errorf("%w: an array has more than %d elements", ErrClaimsLimitExceeded, maxArrayLen)
+				}
+			}
+			continue
+		}
+
+		// the first value of an array counts as its first element
+		if last := len(open) - 1; last >= 0 && open[last] == 0 {
+			open[last] = 1
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			if len(open) == maxDepth {
+				return fmt.Errorf("%w: the payload is nested deeper than %d levels", ErrClaimsLimitExceeded, maxDepth)
+			}
+			if b == '{' {
+				open = append(open, -1)
+			} else {
+				open = append(open, 0)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package jose
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckJSONLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload string
+		err     bool
+	}{
+		{name: "flat", payload: `{"sub":"1234","scope":"a b","n":42}`},
+		{name: "nested", payload: `{"a":{"b":[1,2,3]}}`},
+		{name: "too deep", payload: `{"a":{"b":{"c":{"d":1}}}}`, err: true},
+		{name: "too deep arrays", payload: `{"a":[[[1]]]}`, err: true},
+		{name: "max array", payload: `{"a":[1,"x",{"b":2}],"b":[]}`},
+		{name: "long array", payload: `{"a":[1,2,3,4]}`, err: true},
+		{name: "long nested array", payload: `{"a":[[1,2,3,4]]}`, err: true},
+		{name: "object members", payload: `{"a":1,"b":2,"c":3,"d":4,"e":5}`},
+		{name: "strings", payload: `{"a":"[[[[,,,,\"{{{{"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkJSONLimits([]byte(tc.payload), 3, 3)
+			if tc.err != errors.Is(err, ErrClaimsLimitExceeded) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewClaimsValidator_claimsLimits(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		jwkEndpoint("public")(w, r)
+	}))
+	defer server.Close()
+
+	validator, err := NewClaimsValidator(&SignatureConfig{
+		Alg:                "RS256",
+		URI:                server.URL,
+		DisableJWKSecurity: true,
+		ClaimsLimits:       &ClaimsLimitsConfig{MaxTokenSize: 1024, MaxArrayLength: 10},
+	}, nopExtractor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// {"alg":"RS256","kid":"2011-04-29"}
+	header := "eyJhbGciOiJSUzI1NiIsImtpZCI6IjIwMTEtMDQtMjkifQ"
+	for _, payload := range []string{
+		`{"sub":"` + strings.Repeat("a", 1024) + `"}`,
+		`{"roles":[` + strings.Repeat(`"a",`, 10) + `"a"]}`,
+		`{"a":` + strings.Repeat("[", 9) + strings.Repeat("]", 9) + `}`,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+header+"."+base64.RawURLEncoding.EncodeToString([]byte(payload))+".c2ln")
+		_, err := validator(req)
+		if !errors.Is(err, ErrClaimsLimitExceeded) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if reason := NewTokenError(err).Reason; reason != ReasonLimitsExceeded {
+			t.Errorf("unexpected reason: %s", reason)
+		}
+	}
+	if c := atomic.LoadInt32(&calls); c != 0 {
+		t.Errorf("the key set should not be fetched for the rejected tokens: %d", c)
+	}
+}
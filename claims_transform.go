@@ -0,0 +1,166 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Operations of the claim transformations
+const (
+	ClaimTransformRename      = "rename"
+	ClaimTransformCoalesce    = "coalesce"
+	ClaimTransformLowercase   = "lowercase"
+	ClaimTransformUppercase   = "uppercase"
+	ClaimTransformContainsAny = "contains_any"
+)
+
+var (
+	ErrUnknownClaimTransform = errors.New("unknown claim transformation")
+	ErrInvalidClaimTransform = errors.New("invalid claim transformation")
+)
+
+// ClaimTransform is a step of the transformation of the validated claims, applied after the role mapping
+// and before any check or propagation. Dots in the claim names access nested claims.
+//   - rename moves the claim From[0] to To
+//   - coalesce copies the first non empty claim of From to To
+//   - lowercase and uppercase change the case of the string claim From[0], storing it in To (defaults to
+//     From[0])
+//   - contains_any sets To to true if the claim From[0] (an array or a space separated string) contains any
+//     of the Values, and to false otherwise
+type ClaimTransform struct {
+	Op     string   `json:"op"`
+	From   []string `json:"from"`
+	To     string   `json:"to,omitempty"`
+	Values []string `json:"values,omitempty"`
+}
+
+type claimTransform struct {
+	op     string
+	from   []ClaimPath
+	to     ClaimPath
+	values []string
+}
+
+// ClaimsTransformer applies the transformations of a signature config, in order, to the validated claims
+type ClaimsTransformer struct {
+	steps []claimTransform
+}
+
+// NewClaimsTransformer returns the ClaimsTransformer for the transformations, or nil if there are none
+func NewClaimsTransformer(transforms []ClaimTransform) (*ClaimsTransformer, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+	t := &ClaimsTransformer{steps: make([]claimTransform, len(transforms))}
+	for i, tr := range transforms {
+		if len(tr.From) == 0 {
+			return nil, fmt.Errorf("%w: #%d has no source claims", ErrInvalidClaimTransform, i)
+		}
+		to := tr.To
+		switch tr.Op {
+		case ClaimTransformLowercase, ClaimTransformUppercase:
+			if to == "" {
+				to = tr.From[0]
+			}
+		case ClaimTransformContainsAny:
+			if len(tr.Values) == 0 {
+				return nil, fmt.Errorf("%w: #%d has no values", ErrInvalidClaimTransform, i)
+			}
+		case ClaimTransformRename, ClaimTransformCoalesce:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownClaimTransform, tr.Op)
+		}
+		if to == "" {
+			return nil, fmt.Errorf("%w: #%d has no target claim", ErrInvalidClaimTransform, i)
+		}
+
+		step := claimTransform{op: tr.Op, from: make([]ClaimPath, len(tr.From)), to: NewClaimPath(to, true), values: tr.Values}
+		for j, from := range tr.From {
+			step.from[j] = NewClaimPath(from, true)
+		}
+		t.steps[i] = step
+	}
+	return t, nil
+}
+
+// Transform returns the transformed claims. The given claims are not modified. A nil ClaimsTransformer
+// returns the claims as they are.
+func (t *ClaimsTransformer) Transform(claims map[string]interface{}) map[string]interface{} {
+	if t == nil || claims == nil {
+		return claims
+	}
+	for _, step := range t.steps {
+		claims = step.apply(claims)
+	}
+	return claims
+}
+
+func (s claimTransform) apply(claims map[string]interface{}) map[string]interface{} {
+	switch s.op {
+	case ClaimTransformRename:
+		v, ok := s.from[0].Lookup(claims)
+		if !ok {
+			return claims
+		}
+		return withClaim(withoutClaim(claims, s.from[0]), s.to, v)
+
+	case ClaimTransformCoalesce:
+		for _, from := range s.from {
+			if v, ok := from.Lookup(claims); ok && v != nil && v != "" {
+				return withClaim(claims, s.to, v)
+			}
+		}
+		return claims
+
+	case ClaimTransformLowercase, ClaimTransformUppercase:
+		v, ok := s.from[0].Lookup(claims)
+		if !ok {
+			return claims
+		}
+		str, ok := v.(string)
+		if !ok {
+			return claims
+		}
+		if s.op == ClaimTransformLowercase {
+			return withClaim(claims, s.to, strings.ToLower(str))
+		}
+		return withClaim(claims, s.to, strings.ToUpper(str))
+
+	case ClaimTransformContainsAny:
+		v, _ := s.from[0].Lookup(claims)
+		for _, e := range claimValues(v) {
+			for _, value := range s.values {
+				if e == value {
+					return withClaim(claims, s.to, true)
+				}
+			}
+		}
+		return withClaim(claims, s.to, false)
+	}
+	return claims
+}
+
+// withoutClaim returns a copy of the claims without the claim at the path. The nested objects in the path
+// are copied too.
+func withoutClaim(claims map[string]interface{}, path ClaimPath) map[string]interface{} {
+	keys := path.keys
+	if keys == nil {
+		keys = []string{path.name}
+	}
+	if _, ok := claims[keys[0]]; !ok {
+		return claims
+	}
+	res := make(map[string]interface{}, len(claims))
+	for k, e := range claims {
+		res[k] = e
+	}
+	if len(keys) == 1 {
+		delete(res, keys[0])
+		return res
+	}
+	if nested, ok := res[keys[0]].(map[string]interface{}); ok {
+		res[keys[0]] = withoutClaim(nested, ClaimPath{name: strings.Join(keys[1:], "."), keys: keys[1:]})
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package jose
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClaimsTransformer_Transform(t *testing.T) {
+	tr, err := NewClaimsTransformer([]ClaimTransform{
+		{Op: ClaimTransformRename, From: []string{"ext.user_email"}, To: "mail"},
+		{Op: ClaimTransformCoalesce, From: []string{"email", "mail", "upn"}, To: "email"},
+		{Op: ClaimTransformLowercase, From: []string{"email"}},
+		{Op: ClaimTransformUppercase, From: []string{"country"}, To: "profile.country"},
+		{Op: ClaimTransformContainsAny, From: []string{"roles"}, To: "is_admin", Values: []string{"admin", "root"}},
+		{Op: ClaimTransformContainsAny, From: []string{"scope"}, To: "can_write", Values: []string{"write"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"sub":     "1234",
+		"ext":     map[string]interface{}{"user_email": "John.Doe@Example.com", "other": 1.0},
+		"upn":     "jdoe@corp.example.com",
+		"country": "es",
+		"roles":   []interface{}{"user", "admin"},
+		"scope":   "read",
+	}
+	res := tr.Transform(claims)
+
+	expected := map[string]interface{}{
+		"sub":       "1234",
+		"ext":       map[string]interface{}{"other": 1.0},
+		"mail":      "John.Doe@Example.com",
+		"email":     "john.doe@example.com",
+		"upn":       "jdoe@corp.example.com",
+		"country":   "es",
+		"profile":   map[string]interface{}{"country": "ES"},
+		"roles":     []interface{}{"user", "admin"},
+		"scope":     "read",
+		"is_admin":  true,
+		"can_write": false,
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+
+	if _, ok := claims["ext"].(map[string]interface{})["user_email"]; !ok {
+		t.Errorf("the claims have been modified: %v", claims)
+	}
+	if _, ok := claims["email"]; ok {
+		t.Errorf("the claims have been modified: %v", claims)
+	}
+}
+
+func TestClaimsTransformer_missingClaims(t *testing.T) {
+	tr, err := NewClaimsTransformer([]ClaimTransform{
+		{Op: ClaimTransformRename, From: []string{"a"}, To: "b"},
+		{Op: ClaimTransformCoalesce, From: []string{"c", "d"}, To: "e"},
+		{Op: ClaimTransformLowercase, From: []string{"f"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	claims := map[string]interface{}{"sub": "1234", "c": "", "f": 1.0}
+	if res := tr.Transform(claims); !reflect.DeepEqual(res, claims) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+}
+
+func TestNewClaimsTransformer(t *testing.T) {
+	if tr, err := NewClaimsTransformer(nil); tr != nil || err != nil {
+		t.Errorf("unexpected transformer: %v, %v", tr, err)
+	}
+
+	for _, tc := range []struct {
+		transform ClaimTransform
+		err       error
+	}{
+		{ClaimTransform{Op: "split", From: []string{"a"}, To: "b"}, ErrUnknownClaimTransform},
+		{ClaimTransform{Op: ClaimTransformRename, To: "b"}, ErrInvalidClaimTransform},
+		{ClaimTransform{Op: ClaimTransformRename, From: []string{"a"}}, ErrInvalidClaimTransform},
+		{ClaimTransform{Op: ClaimTransformContainsAny, From: []string{"a"}, To: "b"}, ErrInvalidClaimTransform},
+	} {
+		if _, err := NewClaimsTransformer([]ClaimTransform{tc.transform}); !errors.Is(err, tc.err) {
+			t.Errorf("%+v: unexpected error: %v", tc.transform, err)
+		}
+	}
+}
+
+func TestClaimsTransformer_nil(t *testing.T) {
+	var tr *ClaimsTransformer
+	claims := map[string]interface{}{"sub": "1234"}
+	if res := tr.Transform(claims); !reflect.DeepEqual(res, claims) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+}
@@ -0,0 +1,305 @@
+package jose
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+const (
+	ClientCredentialsNamespace = "github.com/DKolibar/krakend-jose/client_credentials"
+
+	AuthStyleHeader = "header"
+	AuthStyleParams = "params"
+
+	defaultOutboundTokenHeader   = "Authorization"
+	defaultOutboundRefreshBefore = 30 * time.Second
+	defaultOutboundTokenLifetime = 5 * time.Minute
+	defaultOutboundTokenTimeout  = 10 * time.Second
+)
+
+var (
+	ErrNoClientCredentialsCfg = errors.New("no client credentials config")
+	ErrInvalidClientCreds     = errors.New("invalid client credentials config")
+	ErrTokenEndpoint          = errors.New("token endpoint error")
+)
+
+// ClientCredentialsConfig defines how the gateway obtains its own tokens from an IdP with the client
+// credentials grant, in order to authenticate the requests sent to the backends. It is expected at the
+// backend level extra config.
+type ClientCredentialsConfig struct {
+	// TokenURL is the token endpoint of the IdP
+	TokenURL string `json:"token_url"`
+	ClientID string `json:"client_id"`
+	// ClientSecret accepts the references of the config values, as "@/etc/krakend/client_secret"
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// Audience is sent as the audience param, required by some IdPs
+	Audience string `json:"audience,omitempty"`
+	// EndpointParams are added to the token request
+	EndpointParams map[string]string `json:"endpoint_params,omitempty"`
+	// AuthStyle is how the client authenticates: header (Basic, the default) or params
+	AuthStyle string `json:"auth_style,omitempty"`
+	// Header is the header carrying the token to the backend. Defaults to Authorization, with the
+	// Bearer prefix
+	Header string `json:"header,omitempty"`
+	// RefreshBefore is the time before the expiration when the token is renewed, in seconds or as "30s".
+	// Defaults to 30s
+	RefreshBefore Seconds `json:"refresh_before,omitempty"`
+	// Timeout limits the token requests, in seconds or as "10s". Defaults to 10s
+	Timeout                 Seconds  `json:"timeout,omitempty"`
+	CipherSuites            []uint16 `json:"cipher_suites,omitempty"`
+	LocalCA                 string   `json:"local_ca,omitempty"`
+	DisableTokenURLSecurity bool     `json:"disable_token_url_security,omitempty"`
+}
+
+// GetClientCredentialsConfig parses the client credentials config from the backend extra config
+func GetClientCredentialsConfig(extra config.ExtraConfig) (*ClientCredentialsConfig, error) {
+	tmp, ok := extra[ClientCredentialsNamespace]
+	if !ok {
+		return nil, ErrNoClientCredentialsCfg
+	}
+	res := new(ClientCredentialsConfig)
+	if err := decodeConfig(tmp, res); err != nil {
+		return nil, err
+	}
+	if !validJWKSource(res.TokenURL, res.DisableTokenURLSecurity) {
+		return res, fmt.Errorf("%w: %q is not an https URL and disable_token_url_security is not set", ErrInvalidClientCreds, res.TokenURL)
+	}
+	return res, nil
+}
+
+// ClientCredentialsSource obtains and caches the tokens of a client. The token is renewed when it is about
+// to expire, and the concurrent requests wait for the same renewal.
+type ClientCredentialsSource struct {
+	mu            sync.Mutex
+	cfg           *ClientCredentialsConfig
+	client        *http.Client
+	header        string
+	refreshBefore time.Duration
+	token         string
+	expiry        time.Time
+	now           func() time.Time
+}
+
+// NewClientCredentialsSource returns the ClientCredentialsSource of the config
+func NewClientCredentialsSource(cfg *ClientCredentialsConfig) (*ClientCredentialsSource, error) {
+	if cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("%w: the token_url and the client_id are required", ErrInvalidClientCreds)
+	}
+	switch cfg.AuthStyle {
+	case "", AuthStyleHeader, AuthStyleParams:
+	default:
+		return nil, fmt.Errorf("%w: unknown auth_style %q", ErrInvalidClientCreds, cfg.AuthStyle)
+	}
+	client, err := newOutboundClient(cfg.CipherSuites, cfg.LocalCA, cfg.Timeout.Duration())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ClientCredentialsSource{
+		cfg:           cfg,
+		client:        client,
+		header:        cfg.Header,
+		refreshBefore: cfg.RefreshBefore.Duration(),
+		now:           time.Now,
+	}
+	if s.header == "" {
+		s.header = defaultOutboundTokenHeader
+	}
+	if s.refreshBefore == 0 {
+		s.refreshBefore = defaultOutboundRefreshBefore
+	}
+	return s, nil
+}
+
+func newOutboundClient(cs []uint16, localCA string, timeout time.Duration) (*http.Client, error) {
+	if len(cs) == 0 {
+		cs = DefaultEnabledCipherSuites
+	}
+	rootCAs, _ := x509.SystemCertPool()
+	if rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if localCA != "" {
+		certs, err := os.ReadFile(localCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append %q to RootCAs: %v", localCA, err)
+		}
+		rootCAs.AppendCertsFromPEM(certs)
+	}
+	if timeout == 0 {
+		timeout = defaultOutboundTokenTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		CipherSuites: cs,
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      rootCAs,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// Token returns the cached token, requesting a new one if there is none or it is about to expire
+func (s *ClientCredentialsSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.token != "" && now.Add(s.refreshBefore).Before(s.expiry) {
+		return s.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	if s.cfg.Audience != "" {
+		form.Set("audience", s.cfg.Audience)
+	}
+	for k, v := range s.cfg.EndpointParams {
+		form.Set(k, v)
+	}
+
+	auth := clientAuth(form, s.cfg.AuthStyle, s.cfg.ClientID, s.cfg.ClientSecret)
+	token, expiresIn, err := fetchToken(ctx, s.client, s.cfg.TokenURL, form, auth)
+	if err != nil {
+		return "", err
+	}
+	if expiresIn == 0 {
+		expiresIn = defaultOutboundTokenLifetime
+	}
+	s.token = token
+	s.expiry = now.Add(expiresIn)
+	return token, nil
+}
+
+// clientAuth adds the credentials of the client to the form (params auth style) or returns the function
+// setting them in the Authorization header of the token request
+func clientAuth(form url.Values, style, clientID, clientSecret string) func(*http.Request) {
+	if style == AuthStyleParams {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+		return func(*http.Request) {}
+	}
+	return func(req *http.Request) {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+}
+
+// tokenResponse is the successful response of an OAuth 2.0 token endpoint, or its error response
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// fetchToken posts the form to the token endpoint and returns the access token and its lifetime
+func fetchToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values, auth func(*http.Request)) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	auth(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+
+	var res tokenResponse
+	jsonErr := json.Unmarshal(body, &res)
+	if resp.StatusCode != http.StatusOK {
+		if jsonErr == nil && res.Error != "" {
+			return "", 0, fmt.Errorf("%w: %s %s", ErrTokenEndpoint, res.Error, res.ErrorDescription)
+		}
+		return "", 0, fmt.Errorf("%w: unexpected status code %d", ErrTokenEndpoint, resp.StatusCode)
+	}
+	if jsonErr != nil {
+		return "", 0, fmt.Errorf("%w: %s", ErrTokenEndpoint, jsonErr.Error())
+	}
+	if res.AccessToken == "" {
+		return "", 0, fmt.Errorf("%w: no access_token in the response", ErrTokenEndpoint)
+	}
+	if res.TokenType != "" && !strings.EqualFold(res.TokenType, "bearer") && !strings.EqualFold(res.TokenType, "N_A") {
+		return "", 0, fmt.Errorf("%w: unsupported token_type %q", ErrTokenEndpoint, res.TokenType)
+	}
+	expiresIn, _ := res.ExpiresIn.Int64()
+	return res.AccessToken, time.Duration(expiresIn) * time.Second, nil
+}
+
+// headerValue returns the value of the token header
+func (s *ClientCredentialsSource) headerValue(token string) string {
+	if strings.EqualFold(s.header, "Authorization") {
+		return "Bearer " + token
+	}
+	return token
+}
+
+// Inject sets the token header of the request, replacing the one sent by the client
+func (s *ClientCredentialsSource) Inject(r *http.Request) error {
+	token, err := s.Token(r.Context())
+	if err != nil {
+		return err
+	}
+	r.Header.Set(s.header, s.headerValue(token))
+	return nil
+}
+
+// NewBackendFactory returns a BackendFactory injecting the tokens of the client credentials config of the
+// backends into their requests. The backends without config are not modified, and the ones with an invalid
+// config fail all the requests, so they never receive unauthenticated calls.
+func NewBackendFactory(bf proxy.BackendFactory, logger logging.Logger) proxy.BackendFactory {
+	return func(remote *config.Backend) proxy.Proxy {
+		next := bf(remote)
+		logPrefix := "[BACKEND: " + remote.URLPattern + "][ClientCredentials]"
+		cfg, err := GetClientCredentialsConfig(remote.ExtraConfig)
+		if err == ErrNoClientCredentialsCfg {
+			return next
+		}
+		var source *ClientCredentialsSource
+		if err == nil {
+			source, err = NewClientCredentialsSource(cfg)
+		}
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the client credentials source:", err.Error())
+			return func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+				return nil, err
+			}
+		}
+		return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+			token, err := source.Token(ctx)
+			if err != nil {
+				logger.Error(logPrefix, "Unable to get the token:", err.Error())
+				return nil, err
+			}
+			headers := proxy.CloneRequestHeaders(req.Headers)
+			headers[http.CanonicalHeaderKey(source.header)] = []string{source.headerValue(token)}
+			req.Headers = headers
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+func TestClientCredentialsSource(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "gateway" || password != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" || r.FormValue("audience") != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":60}`, n)
+	}))
+	defer server.Close()
+
+	cfg := &ClientCredentialsConfig{
+		TokenURL:     server.URL,
+		ClientID:     "gateway",
+		ClientSecret: "s3cr3t",
+		Scopes:       []string{"read", "write"},
+		Audience:     "orders",
+	}
+	s, err := NewClientCredentialsSource(cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i, tc := range []struct {
+		elapsed  time.Duration
+		expected string
+	}{
+		{0, "token-1"},
+		{29 * time.Second, "token-1"},
+		{31 * time.Second, "token-2"},
+	} {
+		s.now = func() time.Time { return now.Add(tc.elapsed) }
+		token, err := s.Token(context.Background())
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if token != tc.expected {
+			t.Errorf("#%d: unexpected token %q", i, token)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if err := s.Inject(req); err != nil {
+		t.Error(err)
+	}
+	if h := req.Header.Get("Authorization"); h != "Bearer token-2" {
+		t.Errorf("unexpected header: %q", h)
+	}
+
+	cfg.ClientSecret = "wrong"
+	s, _ = NewClientCredentialsSource(cfg)
+	if _, err := s.Token(context.Background()); !errors.Is(err, ErrTokenEndpoint) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewBackendFactory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "gateway" || r.FormValue("client_secret") != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"machine-token","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	var received map[string][]string
+	bf := NewBackendFactory(func(_ *config.Backend) proxy.Proxy {
+		return func(_ context.Context, req *proxy.Request) (*proxy.Response, error) {
+			received = req.Headers
+			return &proxy.Response{IsComplete: true}, nil
+		}
+	}, logging.NoOp)
+
+	p := bf(&config.Backend{
+		URLPattern: "/orders",
+		ExtraConfig: config.ExtraConfig{
+			ClientCredentialsNamespace: map[string]interface{}{
+				"token_url":                  server.URL,
+				"client_id":                  "gateway",
+				"client_secret":              "s3cr3t",
+				"auth_style":                 "params",
+				"header":                     "X-Service-Token",
+				"disable_token_url_security": true,
+			},
+		},
+	})
+	original := map[string][]string{"X-Service-Token": {"forged"}}
+	if _, err := p(context.Background(), &proxy.Request{Headers: original}); err != nil {
+		t.Error(err)
+		return
+	}
+	if v := received["X-Service-Token"]; len(v) != 1 || v[0] != "machine-token" {
+		t.Errorf("unexpected header: %v", v)
+	}
+	if original["X-Service-Token"][0] != "forged" {
+		t.Error("the original headers have been modified")
+	}
+
+	p = bf(&config.Backend{
+		URLPattern: "/orders",
+		ExtraConfig: config.ExtraConfig{
+			ClientCredentialsNamespace: map[string]interface{}{"token_url": server.URL, "client_id": "gateway"},
+		},
+	})
+	if _, err := p(context.Background(), &proxy.Request{}); !errors.Is(err, ErrInvalidClientCreds) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	received = nil
+	p = bf(&config.Backend{URLPattern: "/public"})
+	if _, err := p(context.Background(), &proxy.Request{Headers: map[string][]string{}}); err != nil || received == nil {
+		t.Errorf("unexpected result: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidClientRules = errors.New("invalid client rules")
+
+var defaultClientClaims = []string{"azp", "client_id"}
+
+// ClientRulesConfig restricts the OAuth clients allowed to call the endpoint, whatever the roles of the
+// user. The patterns are client ids where * matches any sequence of characters, as "mobile-*".
+type ClientRulesConfig struct {
+	// Allow are the patterns of the allowed clients. Any client is allowed if empty, and the tokens without
+	// a client claim are rejected otherwise.
+	Allow []string `json:"allow,omitempty"`
+	// Deny are the patterns of the rejected clients. They take precedence over the allowed ones.
+	Deny []string `json:"deny,omitempty"`
+	// Claims are the claims with the client id. All the present ones are checked, so a token is rejected if
+	// any of them is denied or, when there are allowed clients, not allowed. Defaults to azp and client_id
+	Claims []string `json:"claims,omitempty"`
+}
+
+// ClientRules checks the client of the tokens against a ClientRulesConfig. A nil ClientRules allows any
+// client.
+type ClientRules struct {
+	allow  []string
+	deny   []string
+	claims []string
+}
+
+// NewClientRules returns the ClientRules of the config, or nil if there is no config
+func NewClientRules(cfg *ClientRulesConfig) (*ClientRules, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return nil, fmt.Errorf("%w: either the allowed or the denied clients must be defined", ErrInvalidClientRules)
+	}
+	if stringInSlice("", cfg.Allow) || stringInSlice("", cfg.Deny) {
+		return nil, fmt.Errorf("%w: empty client pattern", ErrInvalidClientRules)
+	}
+	if stringInSlice("", cfg.Claims) {
+		return nil, fmt.Errorf("%w: empty client claim", ErrInvalidClientRules)
+	}
+	c := &ClientRules{allow: cfg.Allow, deny: cfg.Deny, claims: cfg.Claims}
+	if len(c.claims) == 0 {
+		c.claims = defaultClientClaims
+	}
+	return c, nil
+}
+
+// Allowed returns true if none of the clients of the claims is denied and, if there are allowed clients,
+// all of them match one of the allowed patterns
+func (c *ClientRules) Allowed(claims map[string]interface{}) bool {
+	if c == nil {
+		return true
+	}
+	found := false
+	for _, name := range c.claims {
+		v, ok := claims[name]
+		if !ok || v == nil {
+			continue
+		}
+		client, ok := claimString(v)
+		if !ok {
+			// the clients can not be matched, so a malformed claim can not hide a denied client
+			return false
+		}
+		if client == "" {
+			continue
+		}
+		found = true
+		if matchClientPatterns(c.deny, client) {
+			return false
+		}
+		if len(c.allow) > 0 && !matchClientPatterns(c.allow, client) {
+			return false
+		}
+	}
+	return found || len(c.allow) == 0
+}
+
+func matchClientPatterns(patterns []string, client string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, client) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard returns true if the value matches the pattern, where * matches any sequence of characters,
+// including the empty one and the slashes of the api:// client ids
+func matchWildcard(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
@@ -0,0 +1,91 @@
+package jose
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, value string
+		expected       bool
+	}{
+		{pattern: "web", value: "web", expected: true},
+		{pattern: "web", value: "web2"},
+		{pattern: "*", value: "", expected: true},
+		{pattern: "mobile-*", value: "mobile-ios", expected: true},
+		{pattern: "mobile-*", value: "mobile-", expected: true},
+		{pattern: "mobile-*", value: "web-mobile-ios"},
+		{pattern: "*-internal", value: "billing-internal", expected: true},
+		{pattern: "api://*/batch", value: "api://1234/jobs/batch", expected: true},
+		{pattern: "a*b*c", value: "abc", expected: true},
+		{pattern: "a*b*c", value: "axbyc", expected: true},
+		{pattern: "a*b*c", value: "acb"},
+		{pattern: "ab*ba", value: "aba"},
+	} {
+		if res := matchWildcard(tc.pattern, tc.value); res != tc.expected {
+			t.Errorf("%q %q: unexpected result: %v", tc.pattern, tc.value, res)
+		}
+	}
+}
+
+func TestClientRules_Allowed(t *testing.T) {
+	rules, err := NewClientRules(&ClientRulesConfig{
+		Allow: []string{"web", "mobile-*"},
+		Deny:  []string{"mobile-legacy*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		claims   map[string]interface{}
+		expected bool
+	}{
+		{claims: map[string]interface{}{"azp": "web"}, expected: true},
+		{claims: map[string]interface{}{"client_id": "mobile-ios"}, expected: true},
+		{claims: map[string]interface{}{"azp": "mobile-legacy-v1"}},
+		{claims: map[string]interface{}{"azp": "batch", "client_id": "web"}},
+		{claims: map[string]interface{}{"azp": "", "client_id": "web"}, expected: true},
+		{claims: map[string]interface{}{"sub": "1234"}},
+		{claims: map[string]interface{}{"azp": []interface{}{"web"}}},
+		{claims: map[string]interface{}{"azp": "web", "client_id": "mobile-ios"}, expected: true},
+		{claims: map[string]interface{}{"azp": "web", "client_id": "mobile-legacy-v1"}},
+		{claims: map[string]interface{}{"azp": "web", "client_id": "batch"}},
+	} {
+		if res := rules.Allowed(tc.claims); res != tc.expected {
+			t.Errorf("%v: unexpected result: %v", tc.claims, res)
+		}
+	}
+
+	denyOnly, _ := NewClientRules(&ClientRulesConfig{Deny: []string{"batch"}, Claims: []string{"cid"}})
+	if !denyOnly.Allowed(map[string]interface{}{"sub": "1234"}) {
+		t.Error("the tokens without a client should be allowed by the deny list")
+	}
+	if denyOnly.Allowed(map[string]interface{}{"cid": "batch", "azp": "web"}) {
+		t.Error("the denied client should be rejected")
+	}
+	denyBoth, _ := NewClientRules(&ClientRulesConfig{Deny: []string{"batch"}})
+	if denyBoth.Allowed(map[string]interface{}{"azp": "web", "client_id": "batch"}) {
+		t.Error("the denied client_id should be rejected whatever the azp")
+	}
+	if !(*ClientRules)(nil).Allowed(nil) {
+		t.Error("the nil rules should allow any client")
+	}
+}
+
+func TestNewClientRules_errors(t *testing.T) {
+	for _, cfg := range []*ClientRulesConfig{
+		{},
+		{Claims: []string{"azp"}},
+		{Allow: []string{""}},
+		{Deny: []string{"web", ""}},
+		{Allow: []string{"web"}, Claims: []string{""}},
+	} {
+		if _, err := NewClientRules(cfg); !errors.Is(err, ErrInvalidClientRules) {
+			t.Errorf("%+v: unexpected error: %v", cfg, err)
+		}
+	}
+	if rules, err := NewClientRules(nil); rules != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", rules, err)
+	}
+}
@@ -0,0 +1,419 @@
+package jose
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Codes of the problems reported by ValidateConfig
+const (
+	ConfigErrUnknownAlg             = "unknown_alg"
+	ConfigErrUnknownTokenFormat     = "unknown_token_format"
+	ConfigErrInsecureJWKSource      = "insecure_jwk_source"
+	ConfigErrMissingKeySource       = "missing_key_source"
+	ConfigErrZeroCacheDuration      = "zero_cache_duration"
+	ConfigErrPropagationArity       = "propagation_arity"
+	ConfigErrInvalidPropagation     = "invalid_propagation"
+	ConfigErrEmptyRolesKey          = "empty_roles_key"
+	ConfigErrEmptyScopesKey         = "empty_scopes_key"
+	ConfigErrUnknownScopesMatcher   = "unknown_scopes_matcher"
+	ConfigErrUnknownKeyStrategy     = "unknown_key_identify_strategy"
+	ConfigErrUnknownEnforcementMode = "unknown_enforcement_mode"
+	ConfigErrInvalidDuration        = "invalid_duration"
+	ConfigErrInvalidRedaction       = "invalid_redaction"
+	ConfigErrUnknownMethod          = "unknown_method"
+	ConfigErrInvalidParamConstraint = "invalid_param_constraint"
+	ConfigErrInvalidBodyClaim       = "invalid_body_claim"
+	ConfigErrInvalidResponseFilter  = "invalid_response_filter"
+	ConfigErrInvalidTenant          = "invalid_tenant"
+	ConfigErrInvalidRoleMapping     = "invalid_role_mapping"
+	ConfigErrInvalidClaimTransform  = "invalid_claim_transform"
+	ConfigErrInvalidSignedClaims    = "invalid_signed_claims"
+	ConfigErrInvalidAPIKey          = "invalid_api_key"
+	ConfigErrInvalidBasicAuth       = "invalid_basic_auth"
+	ConfigErrInvalidSAML            = "invalid_saml"
+	ConfigErrInvalidProvider        = "invalid_provider"
+	ConfigErrUnknownKeySetFormat    = "unknown_jwk_format"
+	ConfigErrInvalidAppleSecret     = "invalid_apple_client_secret"
+	ConfigErrInvalidTokenExchange   = "invalid_token_exchange"
+	ConfigErrInvalidFAPI            = "invalid_fapi"
+	ConfigErrInvalidSchedule        = "invalid_schedule"
+	ConfigErrInvalidStepUp          = "invalid_step_up"
+	ConfigErrInvalidNonce           = "invalid_nonce"
+	ConfigErrInvalidEnrichment      = "invalid_enrichment"
+	ConfigErrInvalidUserInfo        = "invalid_userinfo"
+	ConfigErrInvalidTokenType       = "invalid_token_type"
+	ConfigErrInvalidTokenProfile    = "invalid_token_profile"
+	ConfigErrInvalidKubernetes      = "invalid_jwk_kubernetes"
+	ConfigErrInvalidKVStore         = "invalid_jwk_kv"
+	ConfigErrInvalidRevocationList  = "invalid_revocation_list"
+	ConfigErrInvalidSharedCache     = "invalid_shared_cache"
+	ConfigErrInvalidCacheControl    = "invalid_jwk_cache_control"
+	ConfigErrInvalidCacheDurations  = "invalid_cache_durations"
+	ConfigErrInvalidCacheMaxKeys    = "invalid_cache_max_keys"
+	ConfigErrInvalidPins            = "invalid_jwk_pins"
+	ConfigErrInvalidTLS             = "invalid_jwk_tls"
+	ConfigErrInvalidTransport       = "invalid_jwk_transport"
+	ConfigErrInvalidRetry           = "invalid_jwk_retry"
+	ConfigErrUnusedBackupPath       = "unused_jwk_backup_path"
+	ConfigErrInvalidSignedKeySet    = "invalid_jwk_signed"
+	ConfigErrInvalidKeyIteration    = "invalid_key_iteration"
+	ConfigErrInvalidClaimsLimits    = "invalid_claims_limits"
+	ConfigErrInvalidScopesMinCount  = "invalid_scopes_min_count"
+	ConfigErrInvalidRolesExpression = "invalid_roles_expression"
+	ConfigErrInvalidClientRules     = "invalid_clients"
+)
+
+// ConfigError is a problem found in a SignatureConfig
+type ConfigError struct {
+	// Code is the machine readable identifier of the problem
+	Code string `json:"code"`
+	// Field is the name of the offending field, as in the JSON config
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.Code, e.Field, e.Message)
+}
+
+// ValidateConfig checks the signature config for contradictions and values that would fail at runtime.
+// It returns all the problems found, as *ConfigError, or nil if there are none. The config is not
+// modified, so it should be validated before the defaults are applied.
+func ValidateConfig(scfg *SignatureConfig) []error {
+	var errs []error
+	add := func(code, field, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if scfg.Provider != nil {
+		withProvider := *scfg
+		if err := applyProvider(&withProvider); err != nil {
+			add(ConfigErrInvalidProvider, "provider", "%s", err.Error())
+		} else {
+			scfg = &withProvider
+		}
+	}
+
+	switch scfg.TokenFormat {
+	case "":
+		if _, ok := supportedAlgorithms[scfg.Alg]; !ok {
+			add(ConfigErrUnknownAlg, "alg", "unknown algorithm %q", scfg.Alg)
+		}
+	case TokenFormatPaseto, TokenFormatBiscuit:
+	default:
+		add(ConfigErrUnknownTokenFormat, "token_format", "unknown token format %q", scfg.TokenFormat)
+	}
+
+	if scfg.URI == "" && scfg.LocalPath == "" && scfg.Kubernetes == nil && scfg.KVStore == nil && scfg.TokenFormat == "" {
+		add(ConfigErrMissingKeySource, "jwk_url", "either jwk_url, jwk_local_path, jwk_kubernetes or jwk_kv must be defined")
+	}
+	if err := scfg.Kubernetes.validate(); err != nil {
+		add(ConfigErrInvalidKubernetes, "jwk_kubernetes", "%s", err.Error())
+	}
+	if err := scfg.KVStore.validate(); err != nil {
+		add(ConfigErrInvalidKVStore, "jwk_kv", "%s", err.Error())
+	}
+	if err := scfg.RevocationList.validate(); err != nil {
+		add(ConfigErrInvalidRevocationList, "revocation_list", "%s", err.Error())
+	}
+	if _, err := NewSharedCache(scfg.SharedCache); err != nil {
+		add(ConfigErrInvalidSharedCache, "shared_cache", "%s", err.Error())
+	}
+	if cc := scfg.CacheControl; cc != nil {
+		if !scfg.CacheEnabled {
+			add(ConfigErrInvalidCacheControl, "jwk_cache_control", "the cache is disabled, so the Cache-Control headers will be ignored")
+		}
+		if cc.MaxDuration != 0 && cc.MinDuration > cc.MaxDuration {
+			add(ConfigErrInvalidCacheControl, "jwk_cache_control", "the min_duration is greater than the max_duration")
+		}
+	}
+	if len(scfg.CacheDurations) > 0 && !scfg.CacheEnabled {
+		add(ConfigErrInvalidCacheDurations, "cache_durations", "the cache is disabled, so the cache durations will be ignored")
+	}
+	for uri, d := range scfg.CacheDurations {
+		if d <= 0 {
+			add(ConfigErrInvalidCacheDurations, "cache_durations", "the cache duration of %q is not positive", uri)
+		}
+	}
+	if scfg.CacheMaxKeys < 0 {
+		add(ConfigErrInvalidCacheMaxKeys, "cache_max_keys", "the max number of cached keys is negative")
+	} else if scfg.CacheMaxKeys > 0 && !scfg.CacheEnabled {
+		add(ConfigErrInvalidCacheMaxKeys, "cache_max_keys", "the cache is disabled, so the max number of cached keys will be ignored")
+	}
+	if pins, err := DecodePins(scfg.Pins); err != nil {
+		add(ConfigErrInvalidPins, "jwk_pins", "%s", err.Error())
+	} else if len(pins) > 0 && len(scfg.Fingerprints) == 0 {
+		valid := false
+		for _, p := range pins {
+			valid = valid || p.validAt(time.Now())
+		}
+		if !valid {
+			add(ConfigErrInvalidPins, "jwk_pins", "none of the pins is valid now, so the connections to the JWK service will be rejected")
+		}
+	}
+	if err := scfg.TLS.validate(); err != nil {
+		add(ConfigErrInvalidTLS, "jwk_tls", "%s", err.Error())
+	}
+	if _, err := parseJWKTransport(scfg.Transport); err != nil {
+		add(ConfigErrInvalidTransport, "jwk_transport", "%s", err.Error())
+	}
+	if err := scfg.Retry.validate(); err != nil {
+		add(ConfigErrInvalidRetry, "jwk_retry", "%s", err.Error())
+	}
+	if scfg.BackupPath != "" && scfg.URI == "" {
+		add(ConfigErrUnusedBackupPath, "jwk_backup_path", "the backup is only written for the key sets fetched from a jwk_url")
+	}
+	if sks := scfg.SignedKeySet; sks != nil {
+		if _, err := newSignedKeySetVerifier(sks); err != nil {
+			add(ConfigErrInvalidSignedKeySet, "jwk_signed", "%s", err.Error())
+		}
+		if scfg.KeySetFormat == KeySetFormatX509 {
+			add(ConfigErrInvalidSignedKeySet, "jwk_signed", "the signed key sets contain JWK sets, not x509 ones")
+		}
+	}
+	if err := scfg.KeyIteration.validate(); err != nil {
+		add(ConfigErrInvalidKeyIteration, "key_iteration", "%s", err.Error())
+	}
+	if err := scfg.ClaimsLimits.validate(); err != nil {
+		add(ConfigErrInvalidClaimsLimits, "claims_limits", "%s", err.Error())
+	}
+	if scfg.URI != "" && !validJWKSource(scfg.URI, scfg.DisableJWKSecurity) {
+		add(ConfigErrInsecureJWKSource, "jwk_url", "%q is not an https URL and disable_jwk_security is not set", scfg.URI)
+	}
+	switch scfg.KeySetFormat {
+	case "", KeySetFormatJWK, KeySetFormatX509:
+	default:
+		add(ConfigErrUnknownKeySetFormat, "jwk_format", "unknown key set format %q. Supported values: jwk, x509", scfg.KeySetFormat)
+	}
+	if scfg.CacheEnabled && scfg.CacheDuration == 0 {
+		add(ConfigErrZeroCacheDuration, "cache_duration", "the cache is enabled without a duration, so the default of 15m will be used")
+	}
+
+	for i, tuple := range scfg.PropagateClaimsToHeader {
+		if len(tuple) < 2 {
+			add(ConfigErrPropagationArity, "propagate_claims", "entry #%d has %d elements instead of [claim, header, options...]", i, len(tuple))
+			continue
+		}
+		if _, err := newPropagationEntry(tuple); err != nil {
+			add(ConfigErrInvalidPropagation, "propagate_claims", "entry #%d: %s", i, err.Error())
+		}
+	}
+	for i, tuple := range scfg.PropagateClaimsToGRPC {
+		if len(tuple) < 2 {
+			add(ConfigErrPropagationArity, "propagate_claims_to_grpc_metadata", "entry #%d has %d elements instead of [claim, key, options...]", i, len(tuple))
+			continue
+		}
+		if _, err := newPropagationEntry(tuple); err != nil {
+			add(ConfigErrInvalidPropagation, "propagate_claims_to_grpc_metadata", "entry #%d: %s", i, err.Error())
+		}
+	}
+	if n := len(scfg.PropagateIssAsTenantId); n != 0 && n != 2 {
+		add(ConfigErrPropagationArity, "propagate_iss_as_tenant_id", "%d elements instead of [header, format]", n)
+	}
+
+	if len(scfg.Roles) > 0 && strings.TrimSpace(scfg.RolesKey) == "" {
+		add(ConfigErrEmptyRolesKey, "roles_key", "roles are required but the roles_key is empty, so the %q claim will be used", defaultRolesKey)
+	}
+	if len(scfg.Scopes) > 0 && scfg.ScopesKey == "" {
+		add(ConfigErrEmptyScopesKey, "scopes_key", "scopes are required but the scopes_key is empty, so they will not be checked")
+	}
+	if scfg.Deny != nil && len(scfg.Deny.Scopes) > 0 && scfg.ScopesKey == "" {
+		add(ConfigErrEmptyScopesKey, "deny.scopes", "scopes are denied but the scopes_key is empty, so they will not be checked")
+	}
+	switch scfg.ScopesMatcher {
+	case "", "any", "all":
+	case ScopesMatcherAtLeast:
+		if n := scfg.ScopesMinCount; n < 1 || n > len(scfg.Scopes) {
+			add(ConfigErrInvalidScopesMinCount, "scopes_min_count", "the at_least matcher requires between 1 and %d scopes, not %d, so all of them will be required", len(scfg.Scopes), n)
+		}
+	default:
+		add(ConfigErrUnknownScopesMatcher, "scopes_matcher", "unknown matcher %q. Supported values: any, all, at_least", scfg.ScopesMatcher)
+	}
+
+	methods := make([]string, 0, len(scfg.MethodRequirements))
+	for method := range scfg.MethodRequirements {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		req := scfg.MethodRequirements[method]
+		switch strings.ToUpper(method) {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+			http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		default:
+			add(ConfigErrUnknownMethod, "method_requirements", "unknown HTTP method %q", method)
+		}
+		if len(req.Scopes) > 0 && scfg.ScopesKey == "" {
+			add(ConfigErrEmptyScopesKey, "method_requirements", "scopes are required for %s but the scopes_key is empty, so they will not be checked", method)
+		}
+		switch req.ScopesMatcher {
+		case "", "any", "all":
+		case ScopesMatcherAtLeast:
+			scopes, n := req.Scopes, req.ScopesMinCount
+			if scopes == nil {
+				scopes = scfg.Scopes
+			}
+			if n == 0 {
+				n = scfg.ScopesMinCount
+			}
+			if n < 1 || n > len(scopes) {
+				add(ConfigErrInvalidScopesMinCount, "method_requirements", "the at_least matcher for %s requires between 1 and %d scopes, not %d, so all of them will be required", method, len(scopes), n)
+			}
+		default:
+			add(ConfigErrUnknownScopesMatcher, "method_requirements", "unknown matcher %q for %s. Supported values: any, all, at_least", req.ScopesMatcher, method)
+		}
+		if _, err := ParseRolesExpression(req.RolesExpression); err != nil {
+			add(ConfigErrInvalidRolesExpression, "method_requirements", "%s: %s", method, err.Error())
+		}
+		if _, err := NewStepUp(req.StepUp); err != nil {
+			add(ConfigErrInvalidStepUp, "method_requirements", "%s: %s", method, err.Error())
+		}
+	}
+
+	for i, c := range scfg.ParamConstraints {
+		if c.Param == "" || c.Claim == "" {
+			add(ConfigErrInvalidParamConstraint, "param_constraints", "entry #%d must define both the param and the claim", i)
+		}
+	}
+
+	for i, c := range scfg.InjectClaimsIntoBody {
+		if c.Claim == "" || c.Field == "" {
+			add(ConfigErrInvalidBodyClaim, "inject_claims_into_body", "entry #%d must define both the claim and the field", i)
+		}
+	}
+	if _, err := NewBodyInjector(scfg.InjectClaimsIntoBody); err != nil {
+		add(ConfigErrInvalidBodyClaim, "inject_claims_into_body", "%s", err.Error())
+	}
+
+	if _, err := NewResponseFilter(scfg); err != nil {
+		add(ConfigErrInvalidResponseFilter, "response_filters", "%s", err.Error())
+	}
+
+	if _, err := NewTenantResolver(scfg.Tenant); err != nil {
+		add(ConfigErrInvalidTenant, "tenant", "%s", err.Error())
+	}
+
+	if rm := scfg.RoleMapping; rm != nil {
+		if len(rm.Mapping) == 0 && rm.Source == "" {
+			add(ConfigErrInvalidRoleMapping, "role_mapping", "either mapping or source must be defined")
+		}
+		if _, err := parseTimeout(rm.ReloadInterval); err != nil {
+			add(ConfigErrInvalidDuration, "role_mapping.reload_interval", "%s", err.Error())
+		}
+		if rm.ReloadInterval != "" && rm.Source == "" {
+			add(ConfigErrInvalidRoleMapping, "role_mapping.reload_interval", "there is no source to reload")
+		}
+	}
+
+	if _, err := NewClaimsTransformer(scfg.TransformClaims); err != nil {
+		add(ConfigErrInvalidClaimTransform, "transform_claims", "%s", err.Error())
+	}
+
+	if sc := scfg.SignedClaims; sc != nil {
+		if _, ok := supportedAlgorithms[sc.Signer.Alg]; !ok && sc.Signer.TokenFormat == "" {
+			add(ConfigErrInvalidSignedClaims, "propagate_signed_claims.signer.alg", "unknown algorithm %q", sc.Signer.Alg)
+		}
+		if sc.Signer.URI == "" && sc.Signer.LocalPath == "" && sc.Signer.Kubernetes == nil && sc.Signer.KVStore == nil {
+			add(ConfigErrInvalidSignedClaims, "propagate_signed_claims.signer.jwk_url", "either jwk_url, jwk_local_path, jwk_kubernetes or jwk_kv must be defined")
+		} else if sc.Signer.URI != "" && !validJWKSource(sc.Signer.URI, sc.Signer.DisableJWKSecurity) {
+			add(ConfigErrInsecureJWKSource, "propagate_signed_claims.signer.jwk_url", "%q is not an https URL and disable_jwk_security is not set", sc.Signer.URI)
+		}
+		if err := sc.Signer.Kubernetes.validate(); err != nil {
+			add(ConfigErrInvalidKubernetes, "propagate_signed_claims.signer.jwk_kubernetes", "%s", err.Error())
+		}
+		if err := sc.Signer.KVStore.validate(); err != nil {
+			add(ConfigErrInvalidKVStore, "propagate_signed_claims.signer.jwk_kv", "%s", err.Error())
+		}
+	}
+
+	if _, err := NewAPIKeyAuthenticator(scfg.APIKeys); err != nil {
+		add(ConfigErrInvalidAPIKey, "api_keys", "%s", err.Error())
+	}
+	if _, err := NewBasicAuthenticator(scfg.BasicAuth); err != nil {
+		add(ConfigErrInvalidBasicAuth, "basic_auth", "%s", err.Error())
+	}
+	if _, err := NewSAMLAuthenticator(scfg.SAML); err != nil {
+		add(ConfigErrInvalidSAML, "saml", "%s", err.Error())
+	}
+	if _, err := NewAppleClientSecret(scfg.AppleClientSecret); err != nil {
+		add(ConfigErrInvalidAppleSecret, "apple_client_secret", "%s", err.Error())
+	}
+	if _, err := NewTokenExchanger(scfg.TokenExchange); err != nil {
+		add(ConfigErrInvalidTokenExchange, "token_exchange", "%s", err.Error())
+	}
+	if err := checkFAPIConfig(scfg); err != nil {
+		add(ConfigErrInvalidFAPI, "fapi", "%s", err.Error())
+	}
+	if _, err := ParseRolesExpression(scfg.RolesExpression); err != nil {
+		add(ConfigErrInvalidRolesExpression, "roles_expression", "%s", err.Error())
+	}
+	if _, err := NewClientRules(scfg.Clients); err != nil {
+		add(ConfigErrInvalidClientRules, "clients", "%s", err.Error())
+	}
+	if _, err := NewSchedule(scfg.Schedule); err != nil {
+		add(ConfigErrInvalidSchedule, "schedule", "%s", err.Error())
+	}
+	if _, err := NewStepUp(stepUpConfig(scfg)); err != nil {
+		add(ConfigErrInvalidStepUp, "step_up", "%s", err.Error())
+	}
+	if n := scfg.Nonce; n != nil && n.Cookie == "" && n.Header == "" {
+		add(ConfigErrInvalidNonce, "nonce", "either the cookie or the header must be defined")
+	}
+	if _, err := NewEnricher(scfg.Enrichment); err != nil {
+		add(ConfigErrInvalidEnrichment, "enrichment", "%s", err.Error())
+	}
+	if scfg.TokenFormat != "" && (len(scfg.AllowedTypes) > 0 || len(scfg.AllowedContentTypes) > 0) {
+		add(ConfigErrInvalidTokenType, "allowed_typ", "the typ and cty headers are only checked in the JWT tokens")
+	}
+	if err := checkTokenProfileConfig(scfg); err != nil {
+		add(ConfigErrInvalidTokenProfile, "token_profile", "%s", err.Error())
+	}
+	if _, err := NewUserInfo(scfg.UserInfo); err != nil {
+		add(ConfigErrInvalidUserInfo, "userinfo", "%s", err.Error())
+	}
+
+	if _, ok := keyStrategy(scfg.KeyIdentifyStrategy); !ok && scfg.KeyIdentifyStrategy != "" {
+		add(ConfigErrUnknownKeyStrategy, "key_identify_strategy", "unknown strategy %q. Supported values: %s", scfg.KeyIdentifyStrategy, strings.Join(KeyStrategies(), ", "))
+	}
+	switch scfg.EnforcementMode {
+	case "", EnforcementModeEnforce, EnforcementModeLogOnly:
+	default:
+		add(ConfigErrUnknownEnforcementMode, "enforcement_mode", "unknown mode %q", scfg.EnforcementMode)
+	}
+
+	var durations [][2]string
+	if scfg.Timeouts != nil {
+		durations = append(durations,
+			[2]string{"timeouts.key_fetch", scfg.Timeouts.KeyFetch},
+			[2]string{"timeouts.validation", scfg.Timeouts.Validation})
+	}
+	if scfg.CircuitBreaker != nil {
+		durations = append(durations, [2]string{"circuit_breaker.open_duration", scfg.CircuitBreaker.OpenDuration})
+	}
+	if scfg.RefreshRateLimit != nil {
+		durations = append(durations, [2]string{"refresh_rate_limit.max_wait", scfg.RefreshRateLimit.MaxWait})
+	}
+	if scfg.Retry != nil {
+		durations = append(durations,
+			[2]string{"jwk_retry.initial_backoff", scfg.Retry.InitialBackoff},
+			[2]string{"jwk_retry.max_backoff", scfg.Retry.MaxBackoff})
+	}
+	if scfg.Reload != nil {
+		durations = append(durations, [2]string{"reload.interval", scfg.Reload.Interval})
+	}
+	for _, d := range durations {
+		if _, err := parseTimeout(d[1]); err != nil {
+			add(ConfigErrInvalidDuration, d[0], "%s", err.Error())
+		}
+	}
+
+	if _, err := NewRedactor(scfg.Redaction); err != nil {
+		add(ConfigErrInvalidRedaction, "redact_claims", "%s", err.Error())
+	}
+
+	return errs
+}
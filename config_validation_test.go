@@ -0,0 +1,145 @@
+package jose
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	if errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks.json"}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:                     "RS257",
+		URI:                     "http://example.com/jwks.json",
+		CacheEnabled:            true,
+		PropagateClaimsToHeader: [][]string{{"sub", "x-user"}, {"iss"}},
+		PropagateIssAsTenantId:  []string{"x-tenant"},
+		Roles:                   []string{"admin"},
+		Scopes:                  []string{"read"},
+		ScopesMatcher:           "some",
+		KeyIdentifyStrategy:     "jku",
+		EnforcementMode:         "audit",
+		Timeouts:                &TimeoutsConfig{KeyFetch: "1s", Validation: "soon"},
+		Redaction:               []RedactionRule{{Claim: "email", Strategy: "shuffle"}},
+	})
+
+	expected := []struct{ code, field string }{
+		{ConfigErrUnknownAlg, "alg"},
+		{ConfigErrInsecureJWKSource, "jwk_url"},
+		{ConfigErrZeroCacheDuration, "cache_duration"},
+		{ConfigErrPropagationArity, "propagate_claims"},
+		{ConfigErrPropagationArity, "propagate_iss_as_tenant_id"},
+		{ConfigErrEmptyRolesKey, "roles_key"},
+		{ConfigErrEmptyScopesKey, "scopes_key"},
+		{ConfigErrUnknownScopesMatcher, "scopes_matcher"},
+		{ConfigErrUnknownKeyStrategy, "key_identify_strategy"},
+		{ConfigErrUnknownEnforcementMode, "enforcement_mode"},
+		{ConfigErrInvalidDuration, "timeouts.validation"},
+		{ConfigErrInvalidRedaction, "redact_claims"},
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("unexpected errors: %v", errs)
+		return
+	}
+	for i, err := range errs {
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) {
+			t.Errorf("#%d: unexpected error type: %T", i, err)
+			continue
+		}
+		if cerr.Code != expected[i].code || cerr.Field != expected[i].field {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestValidateConfig_tokenFormat(t *testing.T) {
+	if errs := ValidateConfig(&SignatureConfig{TokenFormat: TokenFormatPaseto}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	errs := ValidateConfig(&SignatureConfig{TokenFormat: "macaroon"})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrUnknownTokenFormat {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	errs = ValidateConfig(&SignatureConfig{Alg: "HS256"})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrMissingKeySource {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateConfig_propagation(t *testing.T) {
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:                     "RS256",
+		URI:                     "https://example.com/jwks.json",
+		PropagateClaimsToHeader: [][]string{{"sub", "x-user", "true"}, {"iat", "x-iat", "format=rfc3339"}, {"exp", "x-exp", "format=unix"}},
+	})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidPropagation {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateConfig_methodRequirements(t *testing.T) {
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:                "RS256",
+		URI:                "https://example.com/jwks.json",
+		MethodRequirements: map[string]MethodRequirements{"FETCH": {Scopes: []string{"read"}}, "post": {ScopesMatcher: "every"}},
+	})
+	if len(errs) != 3 ||
+		errs[0].(*ConfigError).Code != ConfigErrUnknownMethod ||
+		errs[1].(*ConfigError).Code != ConfigErrEmptyScopesKey ||
+		errs[2].(*ConfigError).Code != ConfigErrUnknownScopesMatcher {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateConfig_scopesMinCount(t *testing.T) {
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:            "RS256",
+		URI:            "https://example.com/jwks.json",
+		ScopesKey:      "scope",
+		Scopes:         []string{"eu", "us", "apac"},
+		ScopesMatcher:  ScopesMatcherAtLeast,
+		ScopesMinCount: 2,
+		MethodRequirements: map[string]MethodRequirements{
+			"GET":    {ScopesMatcher: ScopesMatcherAtLeast},
+			"POST":   {Scopes: []string{"write"}, ScopesMatcher: ScopesMatcherAtLeast},
+			"DELETE": {ScopesMatcher: ScopesMatcherAtLeast, ScopesMinCount: 4},
+		},
+	})
+	if len(errs) != 2 ||
+		errs[0].(*ConfigError).Code != ConfigErrInvalidScopesMinCount || !strings.Contains(errs[0].Error(), "DELETE") ||
+		errs[1].(*ConfigError).Code != ConfigErrInvalidScopesMinCount || !strings.Contains(errs[1].Error(), "POST") {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	errs = ValidateConfig(&SignatureConfig{
+		Alg:           "RS256",
+		URI:           "https://example.com/jwks.json",
+		ScopesKey:     "scope",
+		Scopes:        []string{"eu", "us"},
+		ScopesMatcher: ScopesMatcherAtLeast,
+	})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidScopesMinCount {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateConfig_provider(t *testing.T) {
+	scfg := &SignatureConfig{Provider: &ProviderConfig{Name: ProviderCognito, Region: "eu-west-1", UserPoolID: "eu-west-1_AbCdEf"}, Roles: []string{"admin"}}
+	if errs := ValidateConfig(scfg); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if scfg.URI != "" || scfg.Alg != "" {
+		t.Error("the config should not be modified")
+	}
+
+	errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks.json", Provider: &ProviderConfig{Name: ProviderCognito}})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidProvider {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
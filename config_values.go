@@ -0,0 +1,189 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownEnvVar   = errors.New("undefined environment variable")
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+// Seconds is a duration in seconds. In the JSON config, it accepts both a number of seconds and a
+// duration string, as "15m" or "1h30m".
+type Seconds uint32
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (s *Seconds) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	var secs float64
+	switch v := v.(type) {
+	case float64:
+		secs = v
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidDuration, v)
+		}
+		secs = d.Seconds()
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidDuration, string(b))
+	}
+	if secs < 0 || secs > math.MaxUint32 {
+		return fmt.Errorf("%w: %s", ErrInvalidDuration, string(b))
+	}
+	*s = Seconds(math.Round(secs))
+	return nil
+}
+
+// Duration returns the value as a time.Duration
+func (s Seconds) Duration() time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandableConfigKeys are the secret, key and URL fields where the references are resolved. The rest of
+// the values, as the roles or the claim values, are literals, so a role starting with @ is not a file.
+var expandableConfigKeys = map[string]bool{
+	"address":          true,
+	"api_server":       true,
+	"base_url":         true,
+	"ca_path":          true,
+	"client_secret":    true,
+	"cypher_key":       true,
+	"idp_certificates": true,
+	"jwk_backup_path":  true,
+	"jwk_local_ca":     true,
+	"jwk_local_path":   true,
+	"jwk_url":          true,
+	"key":              true,
+	"local_ca":         true,
+	"password":         true,
+	"path":             true,
+	"private_key":      true,
+	"root_keys":        true,
+	"secret_url":       true,
+	"token":            true,
+	"token_path":       true,
+	"token_url":        true,
+	"uri":              true,
+	"url":              true,
+}
+
+// literalConfigKeys hold claims, claim values or headers, so their content is never expanded, whatever
+// the names of their entries
+var literalConfigKeys = map[string]bool{
+	"attribute_mapping":       true,
+	"attributes":              true,
+	"claims":                  true,
+	"deny":                    true,
+	"extra_headers":           true,
+	"from_response":           true,
+	"mapping":                 true,
+	"req_claim_fields_equals": true,
+	"static":                  true,
+}
+
+// expandConfigValues resolves the references in the string values of the expandableConfigKeys of a
+// decoded JSON config, at any depth:
+//   - ${NAME} is replaced by the value of the environment variable NAME
+//   - a value starting with @ is replaced by the content of the file at that path, without the trailing
+//     line break. Values starting with @@ are kept, without the first @.
+func expandConfigValues(v interface{}) (interface{}, error) {
+	return expandConfigValue(v, false)
+}
+
+// expandConfigValue resolves the references of the strings if expand is set. The elements of the arrays
+// inherit it from the key holding them.
+func expandConfigValue(v interface{}, expand bool) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if literalConfigKeys[k] {
+				res[k] = e
+				continue
+			}
+			ev, err := expandConfigValue(e, expandableConfigKeys[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			res[k] = ev
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			ev, err := expandConfigValue(e, expand)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = ev
+		}
+		return res, nil
+	case string:
+		if !expand {
+			return v, nil
+		}
+		return expandConfigString(v)
+	default:
+		return v, nil
+	}
+}
+
+func expandConfigString(s string) (string, error) {
+	if strings.HasPrefix(s, "@@") {
+		return s[1:], nil
+	}
+	if strings.HasPrefix(s, "@") {
+		data, err := os.ReadFile(s[1:])
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var err error
+	res := envVarPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[2 : len(m)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("%w: %s", ErrUnknownEnvVar, name)
+		}
+		return v
+	})
+	return res, err
+}
+
+// decodeConfig decodes the raw extra config into the value pointed by v, once the references in its
+// values have been resolved
+func decodeConfig(raw interface{}, v interface{}) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	expanded, err := expandConfigValues(generic)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(expanded); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,131 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestSeconds_UnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected Seconds
+		err      bool
+	}{
+		{in: `300`, expected: 300},
+		{in: `"15m"`, expected: 900},
+		{in: `"1h30m"`, expected: 5400},
+		{in: `"1500ms"`, expected: 2},
+		{in: `"15 minutes"`, err: true},
+		{in: `-1`, err: true},
+		{in: `true`, err: true},
+	} {
+		var s Seconds
+		err := json.Unmarshal([]byte(tc.in), &s)
+		if tc.err {
+			if !errors.Is(err, ErrInvalidDuration) {
+				t.Errorf("%s: unexpected error: %v", tc.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if s != tc.expected {
+			t.Errorf("%s: unexpected value: %d", tc.in, s)
+		}
+	}
+
+	if d := Seconds(90).Duration(); d != 90*time.Second {
+		t.Errorf("unexpected duration: %s", d)
+	}
+}
+
+func TestGetSignatureConfig_expansion(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "hmac")
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+	t.Setenv("KRAKEND_JOSE_TEST_HOST", "auth.example.com")
+
+	scfg, err := GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"alg":            "RS256",
+				"jwk_url":        "https://${KRAKEND_JOSE_TEST_HOST}/jwks.json",
+				"cache_duration": "15m",
+				"secret_url":     "@" + secret,
+				"jwk_local_ca":   "@@handle",
+				"audience":       []string{"@" + secret},
+				"roles":          []string{"@admins", "${KRAKEND_JOSE_TEST_HOST}"},
+				"req_claim_fields_equals": map[string]string{
+					"url": "@" + secret,
+				},
+				"token_cache": map[string]interface{}{"max_ttl": "1m"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if scfg.URI != "https://auth.example.com/jwks.json" {
+		t.Errorf("unexpected uri: %s", scfg.URI)
+	}
+	if scfg.CacheDuration != 900 {
+		t.Errorf("unexpected cache duration: %d", scfg.CacheDuration)
+	}
+	if scfg.SecretURL != "s3cr3t" || scfg.LocalCA != "@handle" {
+		t.Errorf("unexpected expanded values: %s %s", scfg.SecretURL, scfg.LocalCA)
+	}
+	if len(scfg.Audience) != 1 || scfg.Audience[0] != "@"+secret {
+		t.Errorf("unexpected audience: %v", scfg.Audience)
+	}
+	if len(scfg.Roles) != 2 || scfg.Roles[0] != "@admins" || scfg.Roles[1] != "${KRAKEND_JOSE_TEST_HOST}" {
+		t.Errorf("the roles should not be expanded: %v", scfg.Roles)
+	}
+	if v := scfg.ReqClaimFieldsEquals["url"]; v != "@"+secret {
+		t.Errorf("the claim values should not be expanded: %s", v)
+	}
+	if scfg.TokenCache.MaxTTL != 60 {
+		t.Errorf("unexpected max ttl: %d", scfg.TokenCache.MaxTTL)
+	}
+
+	_, err = GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"alg":     "RS256",
+				"jwk_url": "https://${KRAKEND_JOSE_TEST_UNDEFINED}/jwks.json",
+			},
+		},
+	})
+	if !errors.Is(err, ErrUnknownEnvVar) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"alg":     "RS256",
+				"jwk_url": "@" + secret + ".missing",
+			},
+		},
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeConfig_marshalError(t *testing.T) {
+	var v map[string]interface{}
+	if err := decodeConfig(map[string]interface{}{"a": make(chan int)}, &v); err == nil {
+		t.Error("error expected")
+	}
+}
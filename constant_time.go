@@ -0,0 +1,74 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strings"
+)
+
+// ConstantTimeEqual compares the strings in constant time. Both values are hashed before the comparison,
+// so the time does not depend on their lengths either.
+func ConstantTimeEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// CustomFieldsConstantTimeMatcher is the hardened version of the CustomFieldsMatcher. Every wanted value
+// is compared in constant time and the comparisons do not stop at the first match or mismatch, so the
+// response time does not leak which claim or value was wrong.
+func CustomFieldsConstantTimeMatcher(claims map[string]interface{}, wantedFields map[string]string) bool {
+	if len(wantedFields) == 0 {
+		return true
+	}
+
+	matched := 1
+	for wantedKey, possibleWantedValues := range wantedFields {
+		values, ok := customFieldValues(claims, wantedKey)
+		if !ok {
+			values = []string{""}
+		}
+		found := 0
+		for _, wantedValue := range strings.Split(possibleWantedValues, "|") {
+			for _, value := range values {
+				if ConstantTimeEqual(value, wantedValue) {
+					found = 1
+				}
+			}
+		}
+		if !ok {
+			found = 0
+		}
+		matched &= found
+	}
+	return matched == 1
+}
+
+// CanAccessPathConstantTime is the hardened version of the CanAccessPath. All the roles of the claim are
+// compared, in constant time, with all the required ones.
+func CanAccessPathConstantTime(path ClaimPath, claims map[string]interface{}, required []string) bool {
+	if len(required) == 0 {
+		return true
+	}
+
+	tmp, ok := path.Lookup(claims)
+	if !ok {
+		return false
+	}
+
+	var roles []string
+	if s, ok := tmp.(string); ok {
+		roles = strings.Split(s, " ")
+	} else {
+		roles = claimValues(tmp)
+	}
+
+	found := 0
+	for _, role := range required {
+		for _, r := range roles {
+			if ConstantTimeEqual(r, role) {
+				found = 1
+			}
+		}
+	}
+	return found == 1
+}
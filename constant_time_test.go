@@ -0,0 +1,83 @@
+package jose
+
+import (
+	"testing"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	if !ConstantTimeEqual("secret", "secret") {
+		t.Error("the strings should be equal")
+	}
+	if ConstantTimeEqual("secret", "secret2") || ConstantTimeEqual("secret", "") {
+		t.Error("the strings should not be equal")
+	}
+}
+
+func TestCustomFieldsConstantTimeMatcher(t *testing.T) {
+	claims := map[string]interface{}{
+		"tenant":                   "acme",
+		"api_key":                  "s3cr3t",
+		"level":                    42,
+		"org":                      map[string]interface{}{"plan": "enterprise", "seats": 100.0, "trial": false},
+		"groups":                   []interface{}{"admins", 7},
+		"https://example.com/tier": "gold",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		wanted   map[string]string
+		expected bool
+	}{
+		{name: "empty", expected: true},
+		{name: "single", wanted: map[string]string{"tenant": "acme"}, expected: true},
+		{name: "options", wanted: map[string]string{"tenant": "foo|acme"}, expected: true},
+		{name: "all", wanted: map[string]string{"tenant": "acme", "api_key": "s3cr3t"}, expected: true},
+		{name: "wrong value", wanted: map[string]string{"tenant": "acme", "api_key": "guess"}},
+		{name: "missing claim", wanted: map[string]string{"unknown": ""}},
+		{name: "number", wanted: map[string]string{"level": "42"}, expected: true},
+		{name: "nested", wanted: map[string]string{"org.plan": "enterprise"}, expected: true},
+		{name: "nested number and bool", wanted: map[string]string{"org.seats": "100", "org.trial": "false"}, expected: true},
+		{name: "nested wrong value", wanted: map[string]string{"org.plan": "free|trial"}},
+		{name: "nested missing", wanted: map[string]string{"org.region": "eu"}},
+		{name: "object", wanted: map[string]string{"org": "enterprise"}},
+		{name: "array", wanted: map[string]string{"groups": "7"}, expected: true},
+		{name: "dotted name", wanted: map[string]string{"https://example.com/tier": "gold"}, expected: true},
+	} {
+		if res := CustomFieldsConstantTimeMatcher(claims, tc.wanted); res != tc.expected {
+			t.Errorf("%s: unexpected result: %v", tc.name, res)
+		}
+		if res := CustomFieldsMatcher(claims, tc.wanted); res != tc.expected {
+			t.Errorf("%s: unexpected result of the default matcher: %v", tc.name, res)
+		}
+	}
+}
+
+func TestCanAccessPathConstantTime(t *testing.T) {
+	claims := map[string]interface{}{
+		"roles": []interface{}{"role_a", "role_b"},
+		"realm": map[string]interface{}{"roles": "role_c role_d"},
+		"other": 42,
+	}
+
+	for _, tc := range []struct {
+		key      string
+		required []string
+		expected bool
+	}{
+		{key: "roles", expected: true},
+		{key: "roles", required: []string{"role_b"}, expected: true},
+		{key: "roles", required: []string{"role_c"}},
+		{key: "realm.roles", required: []string{"role_x", "role_d"}, expected: true},
+		{key: "realm.roles", required: []string{"role_a"}},
+		{key: "other", required: []string{"42"}},
+		{key: "unknown", required: []string{"role_a"}},
+	} {
+		p := NewClaimPath(tc.key, true)
+		if res := CanAccessPathConstantTime(p, claims, tc.required); res != tc.expected {
+			t.Errorf("%s %v: unexpected result: %v", tc.key, tc.required, res)
+		}
+		if res := CanAccessPath(p, claims, tc.required); res != tc.expected {
+			t.Errorf("%s %v: unexpected result of the default matcher: %v", tc.key, tc.required, res)
+		}
+	}
+}
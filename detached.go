@@ -0,0 +1,367 @@
+package jose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// DetachedConfig enables the detached JWS (RFC 7515, appendix F) of the HTTP body, sent in a header. Unless
+// EncodedPayload is set, the unencoded payload option (RFC 7797, b64=false) is used.
+type DetachedConfig struct {
+	Header         string                 `json:"header,omitempty"`
+	EncodedPayload bool                   `json:"encoded_payload,omitempty"`
+	ExtraHeaders   map[string]interface{} `json:"extra_headers,omitempty"`
+	// Critical lists the crit header parameters (besides b64) accepted by the verifier
+	Critical []string `json:"critical,omitempty"`
+	// MaxBodySize is the maximum size of the verified bodies, in bytes. Defaults to 1MB
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+}
+
+const (
+	DefaultDetachedHeader      = "x-jws-signature"
+	DefaultDetachedMaxBodySize = 1 << 20
+)
+
+var (
+	ErrDetachedMissing     = errors.New("detached JWS not found")
+	ErrDetachedMalformed   = errors.New("malformed detached JWS")
+	ErrDetachedCritical    = errors.New("unsupported critical header in the detached JWS")
+	ErrDetachedSignature   = errors.New("detached JWS signature verification failed")
+	ErrDetachedUnsupported = errors.New("unsupported key or algorithm for the detached JWS")
+	ErrDetachedBodySize    = errors.New("the body exceeds the max size of the detached JWS payloads")
+)
+
+// HeaderName returns the name of the header holding the detached JWS
+func (d *DetachedConfig) HeaderName() string {
+	if d.Header == "" {
+		return DefaultDetachedHeader
+	}
+	return d.Header
+}
+
+func (d *DetachedConfig) maxBodySize() int64 {
+	if d.MaxBodySize <= 0 {
+		return DefaultDetachedMaxBodySize
+	}
+	return d.MaxBodySize
+}
+
+// DetachedSigner generates detached signatures of raw payloads
+type DetachedSigner struct {
+	alg       jose.SignatureAlgorithm
+	key       interface{}
+	protected string
+	encoded   bool
+}
+
+// NewDetachedSigner returns a DetachedSigner using the given key and algorithm
+func NewDetachedSigner(cfg *DetachedConfig, alg string, key jose.JSONWebKey) (*DetachedSigner, error) {
+	header := make(map[string]interface{}, len(cfg.ExtraHeaders)+4)
+	for k, v := range cfg.ExtraHeaders {
+		header[k] = v
+	}
+	header["alg"] = alg
+	if key.KeyID != "" {
+		header["kid"] = key.KeyID
+	}
+	if !cfg.EncodedPayload {
+		header["b64"] = false
+		crit := []interface{}{"b64"}
+		if tmp, ok := header["crit"].([]interface{}); ok {
+			crit = append(crit, tmp...)
+		}
+		header["crit"] = crit
+	}
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DetachedSigner{
+		alg:       jose.SignatureAlgorithm(alg),
+		key:       key.Key,
+		protected: base64.RawURLEncoding.EncodeToString(b),
+		encoded:   cfg.EncodedPayload,
+	}, nil
+}
+
+// Sign returns the detached JWS (protected header and signature, with an empty payload section)
+func (d *DetachedSigner) Sign(payload []byte) (string, error) {
+	sig, err := signRaw(d.alg, d.key, detachedSigningInput(d.protected, payload, d.encoded))
+	if err != nil {
+		return "", fmt.Errorf("unable to sign payload: %s", err.Error())
+	}
+	return d.protected + ".." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type detachedHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	B64  *bool    `json:"b64"`
+	Crit []string `json:"crit"`
+}
+
+// VerifyDetached checks the detached JWS against the payload. The key is resolved by the kid of the
+// JWS header. If alg is not empty, the JWS must use it.
+func VerifyDetached(jws string, payload []byte, alg string, critical []string, getKey func(string) (interface{}, error)) error {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return ErrDetachedMalformed
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrDetachedMalformed
+	}
+	header := detachedHeader{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return ErrDetachedMalformed
+	}
+	for _, c := range header.Crit {
+		if c == "b64" {
+			continue
+		}
+		if !containsString(critical, c) {
+			return ErrDetachedCritical
+		}
+	}
+	encoded := header.B64 == nil || *header.B64
+	if !encoded && !containsString(header.Crit, "b64") {
+		return ErrDetachedMalformed
+	}
+	if alg != "" && header.Alg != alg {
+		return auth0.ErrInvalidAlgorithm
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrDetachedMalformed
+	}
+
+	key, err := getKey(header.Kid)
+	if err != nil {
+		return err
+	}
+	return verifyRaw(jose.SignatureAlgorithm(header.Alg), key, detachedSigningInput(parts[0], payload, encoded), sig)
+}
+
+func detachedSigningInput(protected string, payload []byte, encoded bool) []byte {
+	if encoded {
+		return []byte(protected + "." + base64.RawURLEncoding.EncodeToString(payload))
+	}
+	input := make([]byte, 0, len(protected)+1+len(payload))
+	input = append(input, protected...)
+	input = append(input, '.')
+	return append(input, payload...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hashForAlg(alg jose.SignatureAlgorithm) (crypto.Hash, bool) {
+	switch alg {
+	case jose.HS256, jose.RS256, jose.PS256, jose.ES256:
+		return crypto.SHA256, true
+	case jose.HS384, jose.RS384, jose.PS384, jose.ES384:
+		return crypto.SHA384, true
+	case jose.HS512, jose.RS512, jose.PS512, jose.ES512:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func digest(h crypto.Hash, input []byte) []byte {
+	hasher := h.New()
+	hasher.Write(input)
+	return hasher.Sum(nil)
+}
+
+func signRaw(alg jose.SignatureAlgorithm, key interface{}, input []byte) ([]byte, error) {
+	if alg == jose.EdDSA {
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrDetachedUnsupported
+		}
+		return ed25519.Sign(k, input), nil
+	}
+
+	h, ok := hashForAlg(alg)
+	if !ok {
+		return nil, ErrDetachedUnsupported
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(h.New, k)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(string(alg), "PS") {
+			return rsa.SignPSS(rand.Reader, k, h, digest(h, input), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, h, digest(h, input))
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(h, input))
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		s.FillBytes(out[size:])
+		return out, nil
+	}
+	return nil, ErrDetachedUnsupported
+}
+
+func verifyRaw(alg jose.SignatureAlgorithm, key interface{}, input, sig []byte) error {
+	switch k := key.(type) {
+	case jose.JSONWebKey:
+		key = k.Key
+	case *jose.JSONWebKey:
+		key = k.Key
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		key = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		key = &k.PublicKey
+	case ed25519.PrivateKey:
+		key = k.Public()
+	}
+
+	if alg == jose.EdDSA {
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrDetachedUnsupported
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return ErrDetachedSignature
+		}
+		return nil
+	}
+
+	h, ok := hashForAlg(alg)
+	if !ok {
+		return ErrDetachedUnsupported
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(h.New, k)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrDetachedSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		var err error
+		if strings.HasPrefix(string(alg), "PS") {
+			err = rsa.VerifyPSS(k, h, digest(h, input), sig, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(k, h, digest(h, input), sig)
+		}
+		if err != nil {
+			return ErrDetachedSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrDetachedSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest(h, input), r, s) {
+			return ErrDetachedSignature
+		}
+		return nil
+	}
+	return ErrDetachedUnsupported
+}
+
+// DetachedVerifier checks the detached JWS of the request bodies
+type DetachedVerifier struct {
+	header      string
+	alg         string
+	critical    []string
+	maxBodySize int64
+	client      *JWKClient
+}
+
+// NewDetachedVerifier creates a DetachedVerifier using the key material of the signature config
+func NewDetachedVerifier(signatureConfig *SignatureConfig) (*DetachedVerifier, error) {
+	if signatureConfig.DetachedPayload == nil {
+		return nil, ErrNoDetachedCfg
+	}
+	cfg, err := newSecretProviderConfig(signatureConfig)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := SecretProvider(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DetachedVerifier{
+		header:      signatureConfig.DetachedPayload.HeaderName(),
+		alg:         signatureConfig.Alg,
+		critical:    signatureConfig.DetachedPayload.Critical,
+		maxBodySize: signatureConfig.DetachedPayload.maxBodySize(),
+		client:      sp,
+	}, nil
+}
+
+var ErrNoDetachedCfg = errors.New("no detached payload config")
+
+// VerifyRequest checks the detached JWS of the request against its body. The body is restored, so it
+// can be consumed later. The bodies larger than the max body size are rejected without buffering them.
+func (d *DetachedVerifier) VerifyRequest(r *http.Request) error {
+	jws := r.Header.Get(d.header)
+	if jws == "" {
+		return ErrDetachedMissing
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, d.maxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		if int64(len(body)) > d.maxBodySize {
+			return ErrDetachedBodySize
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return VerifyDetached(jws, body, d.alg, d.critical, func(kid string) (interface{}, error) {
+		return d.client.GetKey(kid)
+	})
+}
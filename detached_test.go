@@ -0,0 +1,184 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestDetachedSigner(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	payload := []byte(`{"foo":"bar","n":42}`)
+
+	for _, tc := range []struct {
+		alg string
+		key interface{}
+	}{
+		{alg: "HS256", key: []byte("a very secret key, long enough!!")},
+		{alg: "RS256", key: rsaKey},
+		{alg: "PS256", key: rsaKey},
+		{alg: "ES256", key: ecKey},
+		{alg: "EdDSA", key: edKey},
+	} {
+		for _, encoded := range []bool{true, false} {
+			name := tc.alg + "/unencoded"
+			if encoded {
+				name = tc.alg + "/encoded"
+			}
+			t.Run(name, func(t *testing.T) {
+				s, err := NewDetachedSigner(&DetachedConfig{EncodedPayload: encoded}, tc.alg, jose.JSONWebKey{Key: tc.key, KeyID: "kid"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				jws, err := s.Sign(payload)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if parts := strings.Split(jws, "."); len(parts) != 3 || parts[1] != "" {
+					t.Errorf("unexpected detached JWS: %s", jws)
+					return
+				}
+
+				getKey := func(kid string) (interface{}, error) {
+					if kid != "kid" {
+						t.Errorf("unexpected kid: %s", kid)
+					}
+					return tc.key, nil
+				}
+
+				if err := VerifyDetached(jws, payload, tc.alg, nil, getKey); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if err := VerifyDetached(jws, []byte(`{"foo":"bar","n":43}`), tc.alg, nil, getKey); err != ErrDetachedSignature {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyDetached_header(t *testing.T) {
+	key := []byte("a very secret key, long enough!!")
+	getKey := func(_ string) (interface{}, error) { return key, nil }
+	payload := []byte("hello")
+
+	s, _ := NewDetachedSigner(&DetachedConfig{ExtraHeaders: map[string]interface{}{"crit": []interface{}{"custom"}, "custom": 1}}, "HS256", jose.JSONWebKey{Key: key})
+	jws, err := s.Sign(payload)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := VerifyDetached(jws, payload, "HS256", nil, getKey); err != ErrDetachedCritical {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := VerifyDetached(jws, payload, "HS256", []string{"custom"}, getKey); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := VerifyDetached(jws, payload, "HS512", []string{"custom"}, getKey); err == nil {
+		t.Error("error expected")
+	}
+
+	noCrit, _ := json.Marshal(map[string]interface{}{"alg": "HS256", "b64": false})
+	for _, tc := range []string{
+		"",
+		"a.b",
+		"a.b.c",
+		"!!..sig",
+		base64.RawURLEncoding.EncodeToString(noCrit) + "..c2ln",
+	} {
+		if err := VerifyDetached(tc, payload, "HS256", nil, getKey); err != ErrDetachedMalformed {
+			t.Errorf("unexpected error for %q: %v", tc, err)
+		}
+	}
+}
+
+func TestDetachedVerifier_VerifyRequest(t *testing.T) {
+	sp, err := SecretProvider(SecretProviderConfig{LocalPath: "./fixtures/symmetric.json"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, err := sp.GetKey("sim2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, err := NewDetachedVerifier(&SignatureConfig{
+		Alg:             "HS256",
+		URI:             "./fixtures/symmetric.json",
+		LocalPath:       "./fixtures/symmetric.json",
+		DetachedPayload: &DetachedConfig{},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body := `{"amount":100}`
+	s, _ := NewDetachedSigner(&DetachedConfig{}, "HS256", key)
+	jws, _ := s.Sign([]byte(body))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if err := v.VerifyRequest(req); err != ErrDetachedMissing {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	req.Header.Set(DefaultDetachedHeader, jws)
+	if err := v.VerifyRequest(req); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	b, _ := io.ReadAll(req.Body)
+	if string(b) != body {
+		t.Errorf("unexpected body: %s", string(b))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount":1000}`))
+	req.Header.Set(DefaultDetachedHeader, jws)
+	if err := v.VerifyRequest(req); err != ErrDetachedSignature {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := NewDetachedVerifier(&SignatureConfig{}); err != ErrNoDetachedCfg {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDetachedVerifier_maxBodySize(t *testing.T) {
+	v, err := NewDetachedVerifier(&SignatureConfig{
+		Alg:             "HS256",
+		LocalPath:       "./fixtures/symmetric.json",
+		DetachedPayload: &DetachedConfig{MaxBodySize: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for body, expected := range map[string]error{
+		"12345678":  ErrDetachedMalformed,
+		"123456789": ErrDetachedBodySize,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(DefaultDetachedHeader, "malformed")
+		if err := v.VerifyRequest(req); err != expected {
+			t.Errorf("%s: unexpected error: %v", body, err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EnrichmentSourceHTTP = "http"
+
+	defaultEnrichmentCacheTTL = 5 * time.Minute
+	enrichmentSubjectParam    = "{sub}"
+)
+
+var (
+	ErrInvalidEnrichment = errors.New("invalid enrichment config")
+	ErrEnrichmentSource  = errors.New("enrichment source error")
+)
+
+// EnrichmentConfig adds to the claims of the validated tokens the attributes of their subject fetched from
+// an external source, as the entitlements or the feature flags, before the policy is evaluated. The
+// attributes are cached per subject.
+type EnrichmentConfig struct {
+	// Source is the source of the attributes: http (the default) or one registered with
+	// RegisterEnrichmentSource, as a Redis client
+	Source string `json:"source,omitempty"`
+	// URL is the endpoint returning the attributes of the subject as a JSON object. The {sub} placeholder
+	// is replaced by the escaped subject. Without placeholder, the subject is sent as the sub query param.
+	URL string `json:"url,omitempty"`
+	// Headers are added to the requests, and accept the references of the config values, as
+	// "@/etc/krakend/enrichment_token"
+	Headers map[string]string `json:"headers,omitempty"`
+	// Claim is the claim receiving the attributes, as "ext" or "app.attributes". Without claim, the
+	// attributes are merged into the claims, and the claims of the token win over the attributes.
+	Claim string `json:"claim,omitempty"`
+	// Attributes restricts the attributes merged into the claims. All of them by default
+	Attributes []string `json:"attributes,omitempty"`
+	// SubjectClaim is the claim identifying the subject. Defaults to sub
+	SubjectClaim string `json:"subject_claim,omitempty"`
+	// CacheTTL is the time the attributes are cached, in seconds or as "5m". Defaults to 5m
+	CacheTTL Seconds `json:"cache_ttl,omitempty"`
+	// CacheSize is the max number of cached subjects. Defaults to 1000
+	CacheSize int `json:"cache_size,omitempty"`
+	// Timeout limits the calls to the source, in seconds or as "2s". Defaults to 10s
+	Timeout Seconds `json:"timeout,omitempty"`
+	// FailOpen accepts the requests with the claims of the token when the source fails, instead of
+	// rejecting them
+	FailOpen bool `json:"fail_open,omitempty"`
+	// Options are passed to the sources registered with RegisterEnrichmentSource
+	Options            map[string]interface{} `json:"options,omitempty"`
+	CipherSuites       []uint16               `json:"cipher_suites,omitempty"`
+	LocalCA            string                 `json:"local_ca,omitempty"`
+	DisableURLSecurity bool                   `json:"disable_url_security,omitempty"`
+}
+
+// EnrichmentSource returns the attributes of a subject. A subject unknown by the source has no attributes.
+type EnrichmentSource interface {
+	Attributes(ctx context.Context, subject string) (map[string]interface{}, error)
+}
+
+// EnrichmentSourceFactory creates an EnrichmentSource from its config
+type EnrichmentSourceFactory func(*EnrichmentConfig) (EnrichmentSource, error)
+
+var (
+	enrichmentSources = map[string]EnrichmentSourceFactory{
+		EnrichmentSourceHTTP: newHTTPEnrichmentSource,
+	}
+	enrichmentSourcesMu sync.RWMutex
+)
+
+// RegisterEnrichmentSource adds a source (as a Redis client) to the ones available in the enrichment config
+func RegisterEnrichmentSource(name string, f EnrichmentSourceFactory) {
+	enrichmentSourcesMu.Lock()
+	enrichmentSources[name] = f
+	enrichmentSourcesMu.Unlock()
+}
+
+// Enricher merges the attributes of the subjects into their claims. A nil Enricher does nothing.
+type Enricher struct {
+	cfg     *EnrichmentConfig
+	source  EnrichmentSource
+	subject ClaimPath
+	target  *ClaimPath
+	ttl     time.Duration
+	timeout time.Duration
+	cache   *exchangeCache
+	now     func() time.Time
+}
+
+// NewEnricher returns the Enricher of the config, or nil if there is no config
+func NewEnricher(cfg *EnrichmentConfig) (*Enricher, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	name := cfg.Source
+	if name == "" {
+		name = EnrichmentSourceHTTP
+	}
+	enrichmentSourcesMu.RLock()
+	f, ok := enrichmentSources[name]
+	enrichmentSourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown source %q", ErrInvalidEnrichment, name)
+	}
+	source, err := f(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Enricher{
+		cfg:     cfg,
+		source:  source,
+		ttl:     cfg.CacheTTL.Duration(),
+		timeout: cfg.Timeout.Duration(),
+		cache:   newExchangeCache(cfg.CacheSize),
+		now:     time.Now,
+	}
+	if e.ttl == 0 {
+		e.ttl = defaultEnrichmentCacheTTL
+	}
+	if e.timeout == 0 {
+		e.timeout = defaultOutboundTokenTimeout
+	}
+	subject := cfg.SubjectClaim
+	if subject == "" {
+		subject = "sub"
+	}
+	e.subject = NewClaimPath(subject, true)
+	if cfg.Claim != "" {
+		target := NewClaimPath(cfg.Claim, true)
+		e.target = &target
+	}
+	return e, nil
+}
+
+// Enrich returns a copy of the claims with the attributes of their subject. The claims without subject (as
+// the anonymous ones) are returned as they are. When the source fails, the error is returned, unless the
+// enricher fails open.
+func (e *Enricher) Enrich(ctx context.Context, claims map[string]interface{}) (map[string]interface{}, error) {
+	if e == nil {
+		return claims, nil
+	}
+	sub, ok := e.subject.Get(claims)
+	if !ok || sub == "" {
+		return claims, nil
+	}
+	attrs, err := e.attributes(ctx, sub)
+	if err != nil {
+		if e.cfg.FailOpen {
+			return claims, nil
+		}
+		return nil, err
+	}
+
+	return mergeAttributes(claims, e.target, attrs), nil
+}
+
+// mergeAttributes returns a copy of the claims with the attributes added to the target claim or, without
+// target, merged into the claims. The claims of the token win over the merged attributes.
+func mergeAttributes(claims map[string]interface{}, target *ClaimPath, attrs map[string]interface{}) map[string]interface{} {
+	if target != nil {
+		return withClaim(claims, *target, attrs)
+	}
+	res := make(map[string]interface{}, len(claims)+len(attrs))
+	for k, v := range attrs {
+		res[k] = v
+	}
+	for k, v := range claims {
+		res[k] = v
+	}
+	return res
+}
+
+// filterAttributes returns the attributes with the given names. Without names, all of them are kept.
+func filterAttributes(attrs map[string]interface{}, names []string) map[string]interface{} {
+	if len(names) == 0 {
+		return attrs
+	}
+	filtered := make(map[string]interface{}, len(names))
+	for _, k := range names {
+		if v, ok := attrs[k]; ok {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// attributes returns the filtered attributes of the subject, from the cache if they have not expired. The
+// cache keeps them encoded, so every request gets its own copy.
+func (e *Enricher) attributes(ctx context.Context, sub string) (map[string]interface{}, error) {
+	now := e.now()
+	if cached, ok := e.cache.get(sub, now); ok {
+		attrs := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(cached), &attrs); err == nil {
+			return attrs, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	attrs, err := e.source.Attributes(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+	attrs = filterAttributes(attrs, e.cfg.Attributes)
+
+	if b, err := json.Marshal(attrs); err == nil {
+		e.cache.add(sub, string(b), now.Add(e.ttl))
+	}
+	return attrs, nil
+}
+
+// httpEnrichmentSource gets the attributes from an HTTP endpoint
+type httpEnrichmentSource struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPEnrichmentSource(cfg *EnrichmentConfig) (EnrichmentSource, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: the url is required", ErrInvalidEnrichment)
+	}
+	if !validJWKSource(cfg.URL, cfg.DisableURLSecurity) {
+		return nil, fmt.Errorf("%w: %q is not an https URL and disable_url_security is not set", ErrInvalidEnrichment, cfg.URL)
+	}
+	client, err := newOutboundClient(cfg.CipherSuites, cfg.LocalCA, cfg.Timeout.Duration())
+	if err != nil {
+		return nil, err
+	}
+	return &httpEnrichmentSource{url: cfg.URL, headers: cfg.Headers, client: client}, nil
+}
+
+func (s *httpEnrichmentSource) endpoint(sub string) string {
+	if strings.Contains(s.url, enrichmentSubjectParam) {
+		return strings.ReplaceAll(s.url, enrichmentSubjectParam, url.PathEscape(sub))
+	}
+	sep := "?"
+	if strings.Contains(s.url, "?") {
+		sep = "&"
+	}
+	return s.url + sep + "sub=" + url.QueryEscape(sub)
+}
+
+// Attributes implements the EnrichmentSource interface. The not found responses are the subjects without
+// attributes.
+func (s *httpEnrichmentSource) Attributes(ctx context.Context, sub string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint(sub), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEnrichmentSource, err.Error())
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrEnrichmentSource, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEnrichmentSource, err.Error())
+	}
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(body, &attrs); err != nil {
+		return nil, fmt.Errorf("%w: the response is not a JSON object", ErrEnrichmentSource)
+	}
+	return attrs, nil
+}
@@ -0,0 +1,121 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnricher_Enrich(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/users/alice/attributes":
+			fmt.Fprint(w, `{"entitlements":["reports"],"flags":{"beta":true},"sub":"mallory"}`)
+		case "/users/down/attributes":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	e, err := NewEnricher(&EnrichmentConfig{
+		URL:                server.URL + "/users/{sub}/attributes",
+		Headers:            map[string]string{"X-Api-Key": "secret"},
+		DisableURLSecurity: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := e.Enrich(context.Background(), map[string]interface{}{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "alice" {
+		t.Errorf("the claims of the token should win over the attributes: %v", claims)
+	}
+	if flags, ok := claims["flags"].(map[string]interface{}); !ok || flags["beta"] != true {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if _, err := e.Enrich(context.Background(), map[string]interface{}{"sub": "alice"}); err != nil || calls != 1 {
+		t.Errorf("the attributes should be cached: %v %d", err, calls)
+	}
+
+	claims, err = e.Enrich(context.Background(), map[string]interface{}{"sub": "bob"})
+	if err != nil || len(claims) != 1 {
+		t.Errorf("the unknown subjects should have no attributes: %v %v", claims, err)
+	}
+	if _, err := e.Enrich(context.Background(), map[string]interface{}{"sub": "down"}); !errors.Is(err, ErrEnrichmentSource) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if claims, err := e.Enrich(context.Background(), map[string]interface{}{}); err != nil || len(claims) != 0 {
+		t.Errorf("the claims without subject should not be enriched: %v %v", claims, err)
+	}
+}
+
+type staticEnrichmentSource map[string]map[string]interface{}
+
+func (s staticEnrichmentSource) Attributes(_ context.Context, sub string) (map[string]interface{}, error) {
+	if sub == "down" {
+		return nil, ErrEnrichmentSource
+	}
+	return s[sub], nil
+}
+
+func TestEnricher_registeredSource(t *testing.T) {
+	RegisterEnrichmentSource("static", func(cfg *EnrichmentConfig) (EnrichmentSource, error) {
+		return staticEnrichmentSource{
+			"alice": {"plan": "gold", "seats": 3.0},
+		}, nil
+	})
+
+	e, err := NewEnricher(&EnrichmentConfig{Source: "static", Claim: "ext.attrs", Attributes: []string{"plan"}, FailOpen: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	claims, err := e.Enrich(context.Background(), map[string]interface{}{"sub": "alice", "ext": map[string]interface{}{"id": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := claims["ext"].(map[string]interface{})
+	if attrs := ext["attrs"].(map[string]interface{}); attrs["plan"] != "gold" || attrs["seats"] != nil || ext["id"] != 1 {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	original := map[string]interface{}{"sub": "down"}
+	if claims, err := e.Enrich(context.Background(), original); err != nil || len(claims) != 1 {
+		t.Errorf("the enricher should fail open: %v %v", claims, err)
+	}
+}
+
+func TestNewEnricher(t *testing.T) {
+	if e, err := NewEnricher(nil); e != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", e, err)
+	}
+	for _, cfg := range []EnrichmentConfig{
+		{},
+		{URL: "http://example.com/{sub}"},
+		{Source: "unknown"},
+	} {
+		if _, err := NewEnricher(&cfg); !errors.Is(err, ErrInvalidEnrichment) {
+			t.Errorf("%+v: unexpected error: %v", cfg, err)
+		}
+	}
+	if authErr := NewEnrichmentError(); authErr.Status != http.StatusBadGateway || auditRule(authErr.Reason) != "enrichment" {
+		t.Errorf("unexpected error: %+v", authErr)
+	}
+}
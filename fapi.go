@@ -0,0 +1,273 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Sender constraints accepted by the FAPI profile
+const (
+	SenderConstraintDPoP = "dpop"
+	SenderConstraintMTLS = "mtls"
+
+	// ErrorCodeInvalidDPoPProof is the error code defined by RFC 9449 for the invalid DPoP proofs
+	ErrorCodeInvalidDPoPProof = "invalid_dpop_proof"
+
+	defaultFAPIMaxLifetime = time.Hour
+	defaultDPoPMaxAge      = 5 * time.Minute
+	defaultDPoPReplayCache = 10000
+)
+
+var (
+	ErrInvalidFAPI       = errors.New("invalid fapi config")
+	ErrFAPIClaims        = errors.New("the token lacks the claims required by FAPI")
+	ErrFAPILifetime      = errors.New("the lifetime of the token is too long")
+	ErrSenderConstraint  = errors.New("the token is not bound to the sender")
+	ErrInvalidDPoPProof  = errors.New("the DPoP proof is not valid")
+	fapiAlgorithms       = []string{"PS256", "ES256"}
+	fapiSenderConstraint = map[string]bool{"": true, SenderConstraintDPoP: true, SenderConstraintMTLS: true}
+)
+
+// FAPIConfig enforces the FAPI 2.0 security profile on the tokens of an endpoint: the tokens must be signed
+// with PS256 or ES256, have a jti, an aud and a bounded lifetime, and be sender-constrained with DPoP
+// (RFC 9449) or with the client certificate (RFC 8705). All the rejections are recorded in the audit log,
+// using the stdout sink if the endpoint has no audit config.
+type FAPIConfig struct {
+	// MaxLifetime caps the time between the iat and the exp claims, in seconds or as "10m". Defaults to 1h
+	MaxLifetime Seconds `json:"max_lifetime,omitempty"`
+	// SenderConstraint is the required binding: dpop or mtls. Empty accepts both, as declared by the cnf
+	// claim of the token
+	SenderConstraint string `json:"sender_constraint,omitempty"`
+	// ClientCertHeader is the header carrying the URL encoded PEM client certificate, when the TLS
+	// connection is terminated before the gateway
+	ClientCertHeader string `json:"client_cert_header,omitempty"`
+	// DPoPMaxAge is the max age of the DPoP proofs, in seconds or as "5m". Defaults to 5m
+	DPoPMaxAge Seconds `json:"dpop_max_age,omitempty"`
+	// ClockSkew is the leeway of the time checks, in seconds or as "30s"
+	ClockSkew Seconds `json:"clock_skew,omitempty"`
+}
+
+// checkFAPIConfig verifies the signature config complies with the FAPI profile
+func checkFAPIConfig(scfg *SignatureConfig) error {
+	f := scfg.FAPI
+	if f == nil {
+		return nil
+	}
+	if scfg.TokenFormat != "" {
+		return fmt.Errorf("%w: the token format %q is not allowed", ErrInvalidFAPI, scfg.TokenFormat)
+	}
+	if !stringInSlice(scfg.Alg, fapiAlgorithms) {
+		return fmt.Errorf("%w: the algorithm %q is not allowed. Supported values: PS256, ES256", ErrInvalidFAPI, scfg.Alg)
+	}
+	if len(scfg.Audience) == 0 {
+		return fmt.Errorf("%w: the audience is required", ErrInvalidFAPI)
+	}
+	if !fapiSenderConstraint[f.SenderConstraint] {
+		return fmt.Errorf("%w: unknown sender_constraint %q. Supported values: dpop, mtls", ErrInvalidFAPI, f.SenderConstraint)
+	}
+	return nil
+}
+
+// fapiClaimsValidator adds the checks of the FAPI profile to the validator
+func fapiClaimsValidator(scfg *SignatureConfig, v ClaimsValidator) (ClaimsValidator, error) {
+	if scfg.FAPI == nil {
+		return v, nil
+	}
+	if err := checkFAPIConfig(scfg); err != nil {
+		return nil, err
+	}
+	f := &fapiValidator{
+		cfg:         scfg.FAPI,
+		maxLifetime: scfg.FAPI.MaxLifetime.Duration(),
+		dpopMaxAge:  scfg.FAPI.DPoPMaxAge.Duration(),
+		clockSkew:   scfg.FAPI.ClockSkew.Duration(),
+		proofs:      newExchangeCache(defaultDPoPReplayCache),
+		now:         time.Now,
+	}
+	if f.maxLifetime == 0 {
+		f.maxLifetime = defaultFAPIMaxLifetime
+	}
+	if f.dpopMaxAge == 0 {
+		f.dpopMaxAge = defaultDPoPMaxAge
+	}
+	return func(r *http.Request) (map[string]interface{}, error) {
+		claims, err := v(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.check(r, claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}, nil
+}
+
+type fapiValidator struct {
+	cfg         *FAPIConfig
+	maxLifetime time.Duration
+	dpopMaxAge  time.Duration
+	clockSkew   time.Duration
+	// proofs keeps the jti of the DPoP proofs until they expire, so they can not be replayed
+	proofs *exchangeCache
+	now    func() time.Time
+}
+
+func (f *fapiValidator) check(r *http.Request, claims map[string]interface{}) error {
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return fmt.Errorf("%w: jti", ErrFAPIClaims)
+	}
+	if len(audienceValues(claims["aud"])) == 0 {
+		return fmt.Errorf("%w: aud", ErrFAPIClaims)
+	}
+	exp, okExp := numericClaim(claims["exp"])
+	iat, okIat := numericClaim(claims["iat"])
+	if !okExp || !okIat {
+		return fmt.Errorf("%w: exp and iat", ErrFAPIClaims)
+	}
+	if time.Duration(exp-iat)*time.Second > f.maxLifetime {
+		return ErrFAPILifetime
+	}
+
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	x5t, _ := cnf["x5t#S256"].(string)
+	switch {
+	case f.cfg.SenderConstraint == SenderConstraintDPoP, f.cfg.SenderConstraint == "" && jkt != "":
+		if jkt == "" {
+			return fmt.Errorf("%w: the token has no cnf.jkt", ErrSenderConstraint)
+		}
+		return f.checkDPoP(r, jkt)
+	case f.cfg.SenderConstraint == SenderConstraintMTLS, f.cfg.SenderConstraint == "" && x5t != "":
+		if x5t == "" {
+			return fmt.Errorf("%w: the token has no cnf.x5t#S256", ErrSenderConstraint)
+		}
+		return f.checkMTLS(r, x5t)
+	}
+	return fmt.Errorf("%w: the token has no cnf claim", ErrSenderConstraint)
+}
+
+// checkDPoP verifies the DPoP proof of the request has been signed by the key the token is bound to
+func (f *fapiValidator) checkDPoP(r *http.Request, jkt string) error {
+	values := r.Header.Values("DPoP")
+	if len(values) != 1 {
+		return fmt.Errorf("%w: one DPoP header is required", ErrInvalidDPoPProof)
+	}
+	proof, err := jwt.ParseSigned(values[0])
+	if err != nil || len(proof.Headers) != 1 {
+		return fmt.Errorf("%w: malformed", ErrInvalidDPoPProof)
+	}
+	header := proof.Headers[0]
+	if typ, _ := header.ExtraHeaders["typ"].(string); typ != "dpop+jwt" {
+		return fmt.Errorf("%w: the typ is not dpop+jwt", ErrInvalidDPoPProof)
+	}
+	if !stringInSlice(header.Algorithm, fapiAlgorithms) {
+		return fmt.Errorf("%w: the algorithm %s is not allowed", ErrInvalidDPoPProof, header.Algorithm)
+	}
+	key := header.JSONWebKey
+	if key == nil || !key.IsPublic() {
+		return fmt.Errorf("%w: the jwk is not a public key", ErrInvalidDPoPProof)
+	}
+
+	claims := struct {
+		JTI string           `json:"jti"`
+		HTM string           `json:"htm"`
+		HTU string           `json:"htu"`
+		IAT *jwt.NumericDate `json:"iat"`
+		ATH string           `json:"ath"`
+	}{}
+	if err := proof.Claims(key.Key, &claims); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDPoPProof, err.Error())
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil || base64.RawURLEncoding.EncodeToString(thumbprint) != jkt {
+		return fmt.Errorf("%w: the key is not the one bound to the token", ErrSenderConstraint)
+	}
+	if claims.JTI == "" || claims.IAT == nil {
+		return fmt.Errorf("%w: the jti and the iat are required", ErrInvalidDPoPProof)
+	}
+	if claims.HTM != r.Method || !sameRequestURI(claims.HTU, r) {
+		return fmt.Errorf("%w: the htm and the htu do not match the request", ErrInvalidDPoPProof)
+	}
+	now := f.now()
+	iat := claims.IAT.Time()
+	if iat.After(now.Add(f.clockSkew)) || now.Sub(iat) > f.dpopMaxAge+f.clockSkew {
+		return fmt.Errorf("%w: the iat is out of the accepted window", ErrInvalidDPoPProof)
+	}
+	ath := sha256.Sum256([]byte(rawTokenFromRequest(r, "")))
+	if claims.ATH != base64.RawURLEncoding.EncodeToString(ath[:]) {
+		return fmt.Errorf("%w: the ath does not match the token", ErrInvalidDPoPProof)
+	}
+
+	replayKey := jkt + "\x00" + claims.JTI
+	if _, ok := f.proofs.get(replayKey, now); ok {
+		return fmt.Errorf("%w: the proof has been used already", ErrInvalidDPoPProof)
+	}
+	f.proofs.add(replayKey, claims.JTI, iat.Add(f.dpopMaxAge+2*f.clockSkew))
+	return nil
+}
+
+// sameRequestURI compares the htu claim with the URI of the request, without the query and the fragment
+func sameRequestURI(htu string, r *http.Request) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return strings.EqualFold(u.Scheme, scheme) && strings.EqualFold(u.Host, r.Host) && u.Path == r.URL.Path
+}
+
+// checkMTLS verifies the client certificate of the request is the one the token is bound to
+func (f *fapiValidator) checkMTLS(r *http.Request, x5t string) error {
+	cert, err := f.clientCertificate(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSenderConstraint, err.Error())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != x5t {
+		return fmt.Errorf("%w: the client certificate is not the one bound to the token", ErrSenderConstraint)
+	}
+	return nil
+}
+
+func (f *fapiValidator) clientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], nil
+	}
+	if f.cfg.ClientCertHeader == "" {
+		return nil, errors.New("no client certificate")
+	}
+	raw := r.Header.Get(f.cfg.ClientCertHeader)
+	if unescaped, err := url.QueryUnescape(raw); err == nil {
+		raw = unescaped
+	}
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		return nil, errors.New("no client certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// FromDPoPHeader extracts the DPoP-bound tokens sent with the DPoP authorization scheme
+func FromDPoPHeader(r *http.Request) (*jwt.JSONWebToken, error) {
+	if h := r.Header.Get("Authorization"); len(h) > 5 && strings.EqualFold(h[:5], "dpop ") {
+		return jwt.ParseSigned(h[5:])
+	}
+	return nil, auth0.ErrTokenNotFound
+}
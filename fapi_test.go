@@ -0,0 +1,202 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestFAPIClaimsValidator_dpop(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := jose.JSONWebKey{Key: key.Public()}
+	thumbprint, _ := pub.Thumbprint(crypto.SHA256)
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"jti": "token-1",
+		"aud": "api",
+		"iat": json.Number(strconv.FormatInt(now.Unix(), 10)),
+		"exp": json.Number(strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10)),
+		"cnf": map[string]interface{}{"jkt": jkt},
+	}
+	scfg := &SignatureConfig{Alg: "PS256", Audience: []string{"api"}, FAPI: &FAPIConfig{SenderConstraint: SenderConstraintDPoP}}
+	v, err := fapiClaimsValidator(scfg, func(*http.Request) (map[string]interface{}, error) { return claims, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof := func(method, htu string, iat time.Time, jti, accessToken string) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(accessToken))
+		raw, err := jwt.Signed(signer).Claims(map[string]interface{}{
+			"jti": jti,
+			"htm": method,
+			"htu": htu,
+			"iat": iat.Unix(),
+			"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		}).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	for i, tc := range []struct {
+		proof string
+		err   error
+	}{
+		{proof("GET", "http://example.com/orders", now, "p-1", "the-token"), nil},
+		{proof("GET", "http://example.com/orders", now, "p-1", "the-token"), ErrInvalidDPoPProof},
+		{proof("POST", "http://example.com/orders", now, "p-2", "the-token"), ErrInvalidDPoPProof},
+		{proof("GET", "http://example.com/users", now, "p-3", "the-token"), ErrInvalidDPoPProof},
+		{proof("GET", "http://example.com/orders", now.Add(-time.Hour), "p-4", "the-token"), ErrInvalidDPoPProof},
+		{proof("GET", "http://example.com/orders", now, "p-5", "another-token"), ErrInvalidDPoPProof},
+		{"", ErrInvalidDPoPProof},
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/orders?page=2", nil)
+		req.Header.Set("Authorization", "DPoP the-token")
+		if tc.proof != "" {
+			req.Header.Set("DPoP", tc.proof)
+		}
+		if _, err := v(req); !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error. have: %v, want: %v", i, err, tc.err)
+		}
+	}
+
+	claims["cnf"] = map[string]interface{}{"jkt": "another-key"}
+	req := httptest.NewRequest("GET", "http://example.com/orders", nil)
+	req.Header.Set("Authorization", "DPoP the-token")
+	req.Header.Set("DPoP", proof("GET", "http://example.com/orders", now, "p-6", "the-token"))
+	if _, err := v(req); !errors.Is(err, ErrSenderConstraint) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFAPIClaimsValidator_mtls(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	sum := sha256.Sum256(der)
+
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"jti": "token-1",
+		"aud": []interface{}{"api"},
+		"iat": float64(now),
+		"exp": float64(now + 300),
+		"cnf": map[string]interface{}{"x5t#S256": base64.RawURLEncoding.EncodeToString(sum[:])},
+	}
+	scfg := &SignatureConfig{Alg: "ES256", Audience: []string{"api"}, FAPI: &FAPIConfig{}}
+	v, err := fapiClaimsValidator(scfg, func(*http.Request) (map[string]interface{}, error) { return claims, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/orders", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, err := v(req); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com/orders", nil)
+	if _, err := v(req); !errors.Is(err, ErrSenderConstraint) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFAPIClaimsValidator_claims(t *testing.T) {
+	now := time.Now().Unix()
+	for i, tc := range []struct {
+		claims map[string]interface{}
+		err    error
+	}{
+		{map[string]interface{}{"aud": "api", "iat": float64(now), "exp": float64(now + 60)}, ErrFAPIClaims},
+		{map[string]interface{}{"jti": "1", "iat": float64(now), "exp": float64(now + 60)}, ErrFAPIClaims},
+		{map[string]interface{}{"jti": "1", "aud": "api", "exp": float64(now + 60)}, ErrFAPIClaims},
+		{map[string]interface{}{"jti": "1", "aud": "api", "iat": float64(now), "exp": float64(now + 7200)}, ErrFAPILifetime},
+		{map[string]interface{}{"jti": "1", "aud": "api", "iat": float64(now), "exp": float64(now + 60)}, ErrSenderConstraint},
+	} {
+		scfg := &SignatureConfig{Alg: "PS256", Audience: []string{"api"}, FAPI: &FAPIConfig{}}
+		v, err := fapiClaimsValidator(scfg, func(*http.Request) (map[string]interface{}, error) { return tc.claims, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = v(httptest.NewRequest("GET", "http://example.com/", nil))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error. have: %v, want: %v", i, err, tc.err)
+		}
+	}
+}
+
+func TestCheckFAPIConfig(t *testing.T) {
+	for i, scfg := range []*SignatureConfig{
+		{Alg: "RS256", Audience: []string{"api"}, FAPI: &FAPIConfig{}},
+		{Alg: "PS256", FAPI: &FAPIConfig{}},
+		{Alg: "PS256", Audience: []string{"api"}, TokenFormat: TokenFormatPaseto, FAPI: &FAPIConfig{}},
+		{Alg: "PS256", Audience: []string{"api"}, FAPI: &FAPIConfig{SenderConstraint: "bearer"}},
+	} {
+		if err := checkFAPIConfig(scfg); !errors.Is(err, ErrInvalidFAPI) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+	if err := checkFAPIConfig(&SignatureConfig{Alg: "ES256", Audience: []string{"api"}, FAPI: &FAPIConfig{SenderConstraint: "mtls"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewTokenError_fapi(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		code   string
+		reason string
+	}{
+		{ErrInvalidDPoPProof, ErrorCodeInvalidDPoPProof, ReasonInvalidDPoPProof},
+		{ErrSenderConstraint, ErrorCodeInvalidToken, ReasonSenderConstraint},
+		{ErrFAPILifetime, ErrorCodeInvalidToken, ReasonLifetimeExceeded},
+		{ErrFAPIClaims, ErrorCodeInvalidToken, ReasonMissingClaims},
+	} {
+		res := NewTokenError(tc.err)
+		if res.Code != tc.code || res.Reason != tc.reason || res.Status != http.StatusUnauthorized {
+			t.Errorf("%v: unexpected error %+v", tc.err, res)
+		}
+		if rule := auditRule(res.Reason); rule != "fapi" {
+			t.Errorf("%v: unexpected audit rule %s", tc.err, rule)
+		}
+	}
+}
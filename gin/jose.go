@@ -2,11 +2,12 @@ package gin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	krakendjose "github.com/DKolibar/krakend-jose/v2"
 	auth0 "github.com/auth0-community/go-auth0"
@@ -19,13 +20,47 @@ import (
 )
 
 func HandlerFactory(hf ginlura.HandlerFactory, logger logging.Logger, rejecterF krakendjose.RejecterFactory) ginlura.HandlerFactory {
-	return TokenSignatureValidator(TokenSigner(hf, logger), logger, rejecterF)
+	return TokenSignatureValidator(RequestObjectValidator(TokenSigner(hf, logger), logger), logger, rejecterF)
+}
+
+// RequestObjectValidator validates the signed request objects (JAR) of the endpoints with a request object
+// config. The requests with an invalid request object are rejected with a 400.
+func RequestObjectValidator(hf ginlura.HandlerFactory, logger logging.Logger) ginlura.HandlerFactory {
+	return func(cfg *config.EndpointConfig, prxy proxy.Proxy) gin.HandlerFunc {
+		logPrefix := "[ENDPOINT: " + cfg.Endpoint + "][RequestObject]"
+		rocfg, err := krakendjose.GetRequestObjectConfig(cfg)
+		if err == krakendjose.ErrNoRequestObjectCfg {
+			return hf(cfg, prxy)
+		}
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the request object config:", err.Error())
+			return erroredHandler
+		}
+		validator, err := krakendjose.NewRequestObjectValidator(rocfg)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the request object validator:", err.Error())
+			return erroredHandler
+		}
+
+		logger.Debug(logPrefix, "Request object validation enabled")
+
+		handler := hf(cfg, prxy)
+		return func(c *gin.Context) {
+			if _, err := validator.Validate(c.Request); err != nil {
+				logger.Debug(logPrefix, "Invalid request object:", err.Error())
+				authErr := krakendjose.NewRequestObjectError(err)
+				c.AbortWithStatusJSON(authErr.Status, authErr)
+				return
+			}
+			handler(c)
+		}
+	}
 }
 
 func TokenSigner(hf ginlura.HandlerFactory, logger logging.Logger) ginlura.HandlerFactory {
 	return func(cfg *config.EndpointConfig, prxy proxy.Proxy) gin.HandlerFunc {
 		logPrefix := "[ENDPOINT: " + cfg.Endpoint + "][JWTSigner]"
-		signerCfg, signer, err := krakendjose.NewSigner(cfg, nil)
+		issuer, err := krakendjose.NewTokenIssuer(cfg, nil)
 		if err == krakendjose.ErrNoSignerCfg {
 			logger.Debug(logPrefix, "Signer disabled")
 			return hf(cfg, prxy)
@@ -55,7 +90,7 @@ func TokenSigner(hf ginlura.HandlerFactory, logger logging.Logger) ginlura.Handl
 				return
 			}
 
-			if err := krakendjose.SignFields(signerCfg.KeysToSign, signer, response); err != nil {
+			if err := issuer.Issue(response); err != nil {
 				logger.Error(logPrefix, "Signing fields:", err.Error())
 				c.AbortWithStatus(http.StatusBadRequest)
 				return
@@ -64,7 +99,34 @@ func TokenSigner(hf ginlura.HandlerFactory, logger logging.Logger) ginlura.Handl
 			for k, v := range response.Metadata.Headers {
 				c.Header(k, v[0])
 			}
-			c.JSON(response.Metadata.StatusCode, response.Data)
+
+			if form, ok := issuer.FormPost(response); ok {
+				c.Header("Cache-Control", "no-store")
+				c.Data(http.StatusOK, "text/html; charset=utf-8", form)
+				return
+			}
+
+			if !issuer.SignsPayload() {
+				c.JSON(response.Metadata.StatusCode, response.Data)
+				return
+			}
+
+			body, err := json.Marshal(response.Data)
+			if err != nil {
+				logger.Error(logPrefix, "Encoding the response:", err.Error())
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			headers, err := issuer.SignPayload(body)
+			if err != nil {
+				logger.Error(logPrefix, "Signing the payload:", err.Error())
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			for k, v := range headers {
+				c.Header(k, v)
+			}
+			c.Data(response.Metadata.StatusCode, "application/json; charset=utf-8", body)
 		}
 	}
 }
@@ -77,46 +139,132 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 		}
 		rejecter := rejecterF.New(logger, cfg)
 
-		handler := hf(cfg, prxy)
 		scfg, err := krakendjose.GetSignatureConfig(cfg)
 		if err == krakendjose.ErrNoValidatorCfg {
 			logger.Info(logPrefix, "Validator disabled for this endpoint")
-			return handler
+			return hf(cfg, prxy)
 		}
 		if err != nil {
 			logger.Warning(logPrefix, "Unable to parse the configuration:", err.Error())
 			return erroredHandler
 		}
+		scfg.Logger = krakendjose.NewLuraLogger(logger, logPrefix)
+
+		responseFilter, err := krakendjose.NewResponseFilter(scfg)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the response filters:", err.Error())
+			return erroredHandler
+		}
+		grpcPropagator, err := krakendjose.NewGRPCMetadataPropagator(scfg)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the gRPC metadata propagation:", err.Error())
+			return erroredHandler
+		}
+		handler := hf(cfg, responseFilter.Proxy(grpcPropagator.Proxy(prxy)))
+
+		errRenderer := krakendjose.NewErrorRenderer(scfg.ErrorResponse)
+
+		auditor, err := krakendjose.NewAuditor(cfg.Endpoint, scfg)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the audit sink:", err.Error())
+			return erroredHandler
+		}
+		tenants, err := krakendjose.NewTenantResolver(scfg.Tenant)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the tenant config:", err.Error())
+			return erroredHandler
+		}
+		if scfg.Tenant != nil {
+			logger.Debug(logPrefix, fmt.Sprintf("Tenant isolation enabled. The tenant will be read from the claim '%s'", scfg.Tenant.Claim))
+		}
+		logOnly := scfg.LogOnly()
+		if logOnly {
+			logger.Warning(logPrefix, "Enforcement mode is log_only. Rejections will be logged but not enforced")
+		}
+		// reject aborts the request, unless the enforcement mode is log_only. It returns true if the
+		// request has been aborted.
+		reject := func(c *gin.Context, start time.Time, claims map[string]interface{}, authErr *krakendjose.AuthError) bool {
+			if err := auditor.Record(c.Request, start, claims, authErr); err != nil {
+				logger.Warning(logPrefix, "Unable to record the audit event:", err.Error())
+			}
+			if tenants != nil {
+				tenant, _ := tenants.Tenant(claims)
+				krakendjose.DefaultMetrics.TenantRequest(cfg.Endpoint, tenants.MetricsLabel(tenant), authErr.Reason)
+			}
+			if logOnly {
+				krakendjose.DefaultMetrics.TokenWouldReject(cfg.Endpoint, authErr.Reason)
+				logger.Warning(logPrefix, fmt.Sprintf("Request would have been rejected with status %d: %s", authErr.Status, authErr.Reason))
+				return false
+			}
+			krakendjose.DefaultMetrics.TokenRejected(cfg.Endpoint, authErr.Reason)
+			scfg.Logger.Log(krakendjose.LogDebug, "request rejected", "status", authErr.Status, "reason", authErr.Reason)
+			abortWithError(c, errRenderer, authErr)
+			return true
+		}
+
+		redactor, err := krakendjose.NewRedactor(scfg.Redaction)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the redaction rules:", err.Error())
+			return erroredHandler
+		}
 
-		validator, err := krakendjose.NewValidator(scfg, FromCookie)
+		validators, err := krakendjose.NewReloadableValidator(scfg, krakendjose.FromGRPCMetadata(FromCookie), func(_ *krakendjose.SignatureConfig) krakendjose.Rejecter { return rejecter })
 		if err != nil {
 			logger.Fatal(logPrefix, "Unable to create the validator:", err.Error())
 			return erroredHandler
 		}
+		if scfg.TokenCache != nil {
+			logger.Debug(logPrefix, "Validated tokens will be cached")
+		}
+		if scfg.Reload != nil {
+			onError := func(err error) {
+				logger.Error(logPrefix, "Unable to reload the configuration:", err.Error())
+			}
+			if err := validators.WatchFile(context.Background(), scfg.Reload, onError); err != nil {
+				logger.Error(logPrefix, "Unable to watch the configuration file:", err.Error())
+				return erroredHandler
+			}
+			logger.Debug(logPrefix, "The validator will be reloaded when the file changes:", scfg.Reload.File)
+		}
+
+		var detached *krakendjose.DetachedVerifier
+		if scfg.DetachedPayload != nil {
+			detached, err = krakendjose.NewDetachedVerifier(scfg)
+			if err != nil {
+				logger.Error(logPrefix, "Unable to create the detached payload verifier:", err.Error())
+				return erroredHandler
+			}
+			logger.Debug(logPrefix, "Request bodies must be signed. Detached JWS expected at", scfg.DetachedPayload.HeaderName())
+		}
 
-		var aclCheck func(string, map[string]interface{}, []string) bool
+		if scfg.HardenedMatching {
+			logger.Debug(logPrefix, "Roles and custom fields will be compared in constant time")
+		}
 
-		if scfg.RolesKeyIsNested && strings.Contains(scfg.RolesKey, ".") && scfg.RolesKey[:4] != "http" {
+		if scfg.RolesKeyIsNested && strings.Contains(scfg.RolesKey, ".") && !strings.HasPrefix(scfg.RolesKey, "http") {
 			logger.Debug(logPrefix, fmt.Sprintf("Roles will be matched against the nested key: '%s'", scfg.RolesKey))
-			aclCheck = krakendjose.CanAccessNested
 		} else {
 			logger.Debug(logPrefix, fmt.Sprintf("Roles will be matched against the key: '%s'", scfg.RolesKey))
-			aclCheck = krakendjose.CanAccess
 		}
 
-		var scopesMatcher func(string, map[string]interface{}, []string) bool
+		if scfg.RolesExpression != "" {
+			logger.Debug(logPrefix, fmt.Sprintf("Constraint added: tokens must satisfy the roles expression: %s", scfg.RolesExpression))
+		}
+
+		if c := scfg.Clients; c != nil {
+			logger.Debug(logPrefix, fmt.Sprintf("Constraint added: tokens must be issued to the clients %v and not to %v", c.Allow, c.Deny))
+		}
 
 		if len(scfg.Scopes) > 0 && scfg.ScopesKey != "" {
 			if scfg.ScopesMatcher == "all" {
 				logger.Debug(logPrefix, fmt.Sprintf("Constraint added: tokens must contain a claim '%s' with all these scopes: %v", scfg.ScopesKey, scfg.Scopes))
-				scopesMatcher = krakendjose.ScopesAllMatcher
+			} else if scfg.ScopesMatcher == krakendjose.ScopesMatcherAtLeast {
+				logger.Debug(logPrefix, fmt.Sprintf("Constraint added: tokens must contain a claim '%s' with at least %d of these scopes: %v", scfg.ScopesKey, scfg.ScopesMinCount, scfg.Scopes))
 			} else {
 				logger.Debug(logPrefix, fmt.Sprintf("Constraint added: tokens must contain a claim '%s' with any of these scopes: %v", scfg.ScopesKey, scfg.Scopes))
-				scopesMatcher = krakendjose.ScopesAnyMatcher
 			}
 		} else {
 			logger.Debug(logPrefix, "No scope validation required")
-			scopesMatcher = krakendjose.ScopesDefaultMatcher
 		}
 
 		if scfg.OperationDebug {
@@ -125,8 +273,6 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 			logger.Debug(logPrefix, "Validator enabled for this endpoint")
 		}
 
-		var customFieldsMatcher func(map[string]interface{}, map[string]string) bool
-
 		//scfg.CustomFieldsEquals = map[string]string{
 		//	"roles": "http://api.example.com",
 		//}
@@ -135,85 +281,308 @@ func TokenSignatureValidator(hf ginlura.HandlerFactory, logger logging.Logger, r
 			logger.Debug(logPrefix, "Claim fields equality check will be used for this endpoint", scfg.ReqClaimFieldsEquals)
 		}
 
-		customFieldsMatcher = krakendjose.CustomFieldsMatcher
-
 		if len(scfg.PropagateIssAsTenantId) >= 2 && len(scfg.PropagateIssAsTenantId[0]) > 0 && len(scfg.PropagateIssAsTenantId[1]) > 0 {
 			logger.Debug(logPrefix, fmt.Sprintf("'iss' claim field will be returned as '%s' header for this endpoint", scfg.PropagateIssAsTenantId[0]))
 		}
 
 		paramExtractor := extractRequiredJWTClaims(cfg)
 
-		return func(c *gin.Context) {
-			token, err := validator.ValidateRequest(c.Request)
-			if err != nil {
+		roleMapper, err := krakendjose.NewRoleMapper(scfg)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to load the role mapping:", err.Error())
+			return erroredHandler
+		}
+		if scfg.RoleMapping != nil && scfg.RoleMapping.ReloadInterval != "" {
+			go roleMapper.Watch(context.Background(), func(err error) {
+				logger.Error(logPrefix, "Unable to reload the role mapping:", err.Error())
+			})
+		}
+
+		transformer, err := krakendjose.NewClaimsTransformer(scfg.TransformClaims)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the claim transformations:", err.Error())
+			return erroredHandler
+		}
+
+		claimsSigner, err := krakendjose.NewClaimsHeaderSigner(scfg.SignedClaims)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the signer of the propagated claims:", err.Error())
+			return erroredHandler
+		}
+
+		appleSecret, err := krakendjose.NewAppleClientSecret(scfg.AppleClientSecret)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the Apple client secret generator:", err.Error())
+			return erroredHandler
+		}
+
+		exchanger, err := krakendjose.NewTokenExchanger(scfg.TokenExchange)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the token exchanger:", err.Error())
+			return erroredHandler
+		}
+
+		bodyInjector, err := krakendjose.NewBodyInjector(scfg.InjectClaimsIntoBody)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the claims to inject into the body:", err.Error())
+			return erroredHandler
+		}
+
+		apiKeys, err := krakendjose.NewAPIKeyAuthenticator(scfg.APIKeys)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to parse the API keys:", err.Error())
+			return erroredHandler
+		}
+
+		basicAuth, err := krakendjose.NewBasicAuthenticator(scfg.BasicAuth)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the credential store:", err.Error())
+			return erroredHandler
+		}
+
+		saml, err := krakendjose.NewSAMLAuthenticator(scfg.SAML)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the SAML authenticator:", err.Error())
+			return erroredHandler
+		}
+
+		userInfo, err := krakendjose.NewUserInfo(scfg.UserInfo)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the userinfo client:", err.Error())
+			return erroredHandler
+		}
+
+		enricher, err := krakendjose.NewEnricher(scfg.Enrichment)
+		if err != nil {
+			logger.Error(logPrefix, "Unable to create the claims enricher:", err.Error())
+			return erroredHandler
+		}
+
+		history := krakendjose.NewSubjectHistory(scfg.SubjectHistory)
+		wsAuth := krakendjose.NewWebSocketAuth(scfg.WebSocket)
+		streams := krakendjose.NewStreamRevalidator(scfg.StreamRevalidation)
+		if streams != nil && logOnly {
+			logger.Warning(logPrefix, "Streams will not be revalidated, as the enforcement mode is log_only")
+			streams = nil
+		}
+
+		authorize := func(c *gin.Context, set *krakendjose.ValidatorSet, claims map[string]interface{}) *krakendjose.AuthError {
+			if detached != nil {
+				if err := detached.VerifyRequest(c.Request); err != nil {
+					if scfg.OperationDebug {
+						logger.Error(logPrefix, "Unable to verify the detached payload signature:", err.Error())
+					}
+					return krakendjose.NewPayloadSignatureError()
+				}
+			}
+
+			if set.Rejecter.Reject(claims) {
 				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Unable to validate the token:", err.Error())
+					logger.Error(logPrefix, "Token sent by client rejected")
 				}
-				c.AbortWithStatus(http.StatusUnauthorized)
-				return
+				return krakendjose.NewRejectedError()
 			}
 
-			claims := map[string]interface{}{}
-			err = validator.Claims(c.Request, token, &claims)
+			authErr := history.Check(claims, set.Rejecter)
+			if authErr == nil {
+				authErr = set.Policy.AuthorizeMethod(c.Request.Method, claims)
+			}
+			if authErr == nil {
+				authErr = set.Constraints.Check(c.Param, claims)
+			}
+			if authErr == nil {
+				_, authErr = tenants.Check(claims)
+			}
+			if authErr != nil && scfg.OperationDebug {
+				logger.Error(logPrefix, "Token sent by client does not satisfy the policy:", authErr.Error())
+			}
+			return authErr
+		}
+
+		rolesPath := scfg.RolesPath()
+
+		return func(c *gin.Context) {
+			start := time.Now()
+			wsAuth.Prepare(c.Request)
+			c.Request = krakendjose.WithRequestToken(c.Request, scfg.CookieKey)
+			set := validators.Current()
+			claims, err := set.Validator(c.Request)
+			claims, err = apiKeys.Fallback(c.Request, claims, err)
+			claims, err = basicAuth.Fallback(c.Request, claims, err)
+			claims, err = saml.Fallback(c.Request, claims, err)
+			if anonymous, ok := krakendjose.AnonymousClaims(scfg, rolesPath, err); ok {
+				claims, err = anonymous, nil
+			}
 			if err != nil {
 				if scfg.OperationDebug {
 					logger.Error(logPrefix, "Token sent by client is invalid:", err.Error())
 				}
-				c.AbortWithStatus(http.StatusUnauthorized)
+				if !reject(c, start, nil, krakendjose.NewTokenError(err)) {
+					handler(c)
+				}
 				return
 			}
-
-			if rejecter.Reject(claims) {
-				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Token sent by client rejected")
+			var enrichErr *krakendjose.AuthError
+			if merged, err := userInfo.Merge(c.Request.Context(), claims); err != nil {
+				logger.Error(logPrefix, "Unable to fetch the userinfo:", err.Error())
+				enrichErr = krakendjose.NewUserInfoError(err)
+				if reject(c, start, claims, enrichErr) {
+					return
 				}
-				c.AbortWithStatus(http.StatusUnauthorized)
-				return
+			} else {
+				claims = merged
 			}
-
-			if !aclCheck(scfg.RolesKey, claims, scfg.Roles) {
-				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Token sent by client does not have sufficient roles")
+			if enriched, err := enricher.Enrich(c.Request.Context(), claims); err != nil {
+				logger.Error(logPrefix, "Unable to enrich the claims:", err.Error())
+				if enrichErr == nil {
+					enrichErr = krakendjose.NewEnrichmentError()
+					if reject(c, start, claims, enrichErr) {
+						return
+					}
 				}
-				c.AbortWithStatus(http.StatusForbidden)
+			} else {
+				claims = enriched
+			}
+			claims = transformer.Transform(roleMapper.Map(claims))
+			c.Request = krakendjose.WithClaims(c.Request, claims)
+			c.Set(krakendjose.ClaimsContextKey, claims)
+
+			_, span := krakendjose.StartSpan(c.Request.Context(), krakendjose.SpanPolicyEvaluation)
+			authErr := authorize(c, set, claims)
+			if authErr != nil {
+				span.RecordError(authErr)
+			}
+			span.End()
+			if authErr != nil && reject(c, start, claims, authErr) {
 				return
 			}
+			if authErr == nil {
+				authErr = enrichErr
+			}
 
-			if !scopesMatcher(scfg.ScopesKey, claims, scfg.Scopes) {
-				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Token sent by client does not have the required scopes")
-				}
-				c.AbortWithStatus(http.StatusForbidden)
-				return
+			_, span = krakendjose.StartSpan(c.Request.Context(), krakendjose.SpanClaimPropagation)
+			propagated := redactor.Redact(claims)
+			propagateHeaders(set.Propagator, propagated, c)
+			if err := claimsSigner.Propagate(c.Request, propagated); err != nil {
+				logger.Error(logPrefix, "Unable to sign the propagated claims:", err.Error())
+			}
+			if err := appleSecret.Propagate(c.Request); err != nil {
+				logger.Error(logPrefix, "Unable to generate the Apple client secret:", err.Error())
 			}
 
-			if !scopesMatcher(scfg.ScopesKey, claims, scfg.Scopes) {
+			addIssHeader(c, propagated, scfg.PropagateIssAsTenantId)
+			tenant, _ := tenants.Tenant(claims)
+			tenants.Inject(c.Request, tenant)
+
+			paramExtractor(c, propagated)
+			bodyErr := bodyInjector.Inject(c.Request, propagated)
+			span.End()
+			if bodyErr != nil {
 				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Token sent by client does not have the required scopes")
+					logger.Error(logPrefix, "Unable to inject the claims into the body:", bodyErr.Error())
+				}
+				if reject(c, start, claims, bodyErr) {
+					return
+				}
+				if authErr == nil {
+					authErr = bodyErr
 				}
-				c.AbortWithStatus(http.StatusForbidden)
-				return
 			}
-
-			if !customFieldsMatcher(claims, scfg.ReqClaimFieldsEquals) {
-				if scfg.OperationDebug {
-					logger.Error(logPrefix, "Token sent by client does not have the required custom fields")
+			if err := exchanger.Propagate(c.Request, claims); err != nil {
+				logger.Error(logPrefix, "Unable to exchange the token:", err.Error())
+				exchangeErr := krakendjose.NewTokenExchangeError(err)
+				if reject(c, start, claims, exchangeErr) {
+					return
+				}
+				if authErr == nil {
+					authErr = exchangeErr
 				}
-				c.AbortWithStatus(http.StatusForbidden)
-				return
 			}
 
-			propagateHeaders(cfg, scfg.PropagateClaimsToHeader, claims, c, logger)
-
-			addIssHeader(c, claims, scfg.PropagateIssAsTenantId)
+			if authErr == nil {
+				history.Record(claims)
+				krakendjose.DefaultMetrics.TokenValidated(cfg.Endpoint)
+				if tenants != nil {
+					krakendjose.DefaultMetrics.TenantRequest(cfg.Endpoint, tenants.MetricsLabel(tenant), "accepted")
+				}
+				if err := auditor.Record(c.Request, start, claims, nil); err != nil {
+					logger.Warning(logPrefix, "Unable to record the audit event:", err.Error())
+				}
+			}
 
-			paramExtractor(c, claims)
+			if sw, req := streams.Guard(c.Writer, c.Request, claims, set.Rejecter); sw != nil {
+				c.Request = req
+				c.Writer = &streamWriter{ResponseWriter: c.Writer, stream: sw}
+				defer func() {
+					sw.Stop()
+					if authErr := sw.Err(); authErr != nil {
+						krakendjose.DefaultMetrics.TokenRejected(cfg.Endpoint, authErr.Reason)
+						logger.Warning(logPrefix, "Stream terminated:", authErr.Reason)
+					}
+				}()
+			}
 
 			handler(c)
 		}
 	}
 }
 
+// NewMiddleware returns a gin middleware validating the tokens with the validator config of the endpoint,
+// so the routers built with gin outside of the handler factories get the same validation, rejection and
+// propagation. The validated claims are stored in the context under the krakendjose.ClaimsContextKey key
+// and the next handlers are called unless the request is rejected. The response filters and the gRPC
+// metadata propagation are not applied, as they wrap the proxy of the endpoint.
+func NewMiddleware(cfg *config.EndpointConfig, logger logging.Logger, rejecterF krakendjose.RejecterFactory) gin.HandlerFunc {
+	next := func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return TokenSignatureValidator(next, logger, rejecterF)(cfg, proxy.NoopProxy)
+}
+
+// streamWriter sends the writes of a gin response through the writer of a guarded stream
+type streamWriter struct {
+	gin.ResponseWriter
+	stream *krakendjose.StreamWriter
+}
+
+func (w *streamWriter) WriteHeader(code int) { w.stream.WriteHeader(code) }
+
+func (w *streamWriter) Write(b []byte) (int, error) { return w.stream.Write(b) }
+
+func (w *streamWriter) WriteString(s string) (int, error) { return w.stream.Write([]byte(s)) }
+
+func (w *streamWriter) Flush() { w.stream.Flush() }
+
+// RegisterJWKSHandler adds the endpoint publishing the key set of the gateway to the router, if the service
+// extra config enables it
+func RegisterJWKSHandler(r gin.IRouter, extra config.ExtraConfig, logger logging.Logger) {
+	logPrefix := "[SERVICE: Gin][JWKS]"
+	cfg, err := krakendjose.GetJWKSConfig(extra)
+	if err == krakendjose.ErrNoJWKSCfg {
+		logger.Debug(logPrefix, "Key set publishing disabled")
+		return
+	}
+	if err != nil {
+		logger.Error(logPrefix, "Unable to parse the configuration:", err.Error())
+		return
+	}
+
+	cfg.Logger = krakendjose.NewLuraLogger(logger, logPrefix)
+	h, err := krakendjose.NewJWKSHandler(cfg)
+	if err != nil {
+		logger.Error(logPrefix, "Unable to load the key set:", err.Error())
+		return
+	}
+
+	r.GET(cfg.Path, gin.WrapH(h))
+	logger.Debug(logPrefix, "Key set published at", cfg.Path)
+}
+
+func abortWithError(c *gin.Context, r *krakendjose.ErrorRenderer, err *krakendjose.AuthError) {
+	r.Render(c.Writer, err)
+	c.Abort()
+}
+
 func erroredHandler(c *gin.Context) {
 	c.AbortWithStatus(http.StatusUnauthorized)
 }
@@ -228,16 +597,11 @@ func addIssHeader(c *gin.Context, claims map[string]interface{}, settings []stri
 	var targetHeader = settings[0]
 	var customFormat = settings[1]
 
-	if _, ok := claims["iss"]; !ok { //check if iss claim exists
-		return
-	}
-
-	if reflect.TypeOf(claims["iss"]).Kind() != reflect.String || len(claims["iss"].(string)) == 0 { //check if it is string and not of length 0
+	issValue, ok := claims["iss"].(string)
+	if !ok || issValue == "" { //check if it is a string and not of length 0
 		return
 	}
 
-	var issValue = claims["iss"].(string)
-
 	// Remove any "https://" prefix
 	issValue = strings.TrimPrefix(issValue, "https://")
 
@@ -250,18 +614,9 @@ func addIssHeader(c *gin.Context, claims map[string]interface{}, settings []stri
 	c.Request.Header.Set(targetHeader, fmt.Sprintf(customFormat, issValue))
 }
 
-func propagateHeaders(cfg *config.EndpointConfig, propagationCfg [][]string, claims map[string]interface{}, c *gin.Context, logger logging.Logger) {
-	logPrefix := "[ENDPOINT: " + cfg.Endpoint + "][PropagateHeaders]"
-	if len(propagationCfg) > 0 {
-		headersToPropagate, err := krakendjose.CalculateHeadersToPropagate(propagationCfg, claims)
-		if err != nil {
-			logger.Warning(logPrefix, err.Error())
-		}
-		for k, v := range headersToPropagate {
-			// Set header value - replaces existing one
-			c.Request.Header.Set(k, v)
-		}
-	}
+func propagateHeaders(propagator *krakendjose.HeadersPropagator, claims map[string]interface{}, c *gin.Context) {
+	// Set header value - replaces existing one
+	propagator.PropagateFunc(claims, c.Request.Header.Set)
 }
 
 var jwtParamsPattern = regexp.MustCompile(`{{\.JWT\.([^}]*)}}`)
@@ -3,11 +3,13 @@ package gin
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	jose "github.com/DKolibar/krakend-jose/v2"
 	"github.com/gin-gonic/gin"
@@ -229,6 +231,287 @@ func TestTokenSignatureValidator_error(t *testing.T) {
 	}
 }
 
+func TestTokenSignatureValidator_errorResponse(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger, _ := logging.NewLogger("DEBUG", buf, "")
+	hf := TokenSignatureValidator(ginlura.EndpointHandler, logger, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", []string{"role_c"})
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["error_response"] = map[string]interface{}{"realm": "api", "json_body": true, "describe": true}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, proxy.NoopProxy))
+
+	token := newSignedToken(t, map[string]interface{}{
+		"aud":   "http://api.example.com",
+		"iss":   "http://example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"role_a"},
+	})
+	expired := newSignedToken(t, map[string]interface{}{
+		"aud": "http://api.example.com",
+		"iss": "http://example.com",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		status int
+		header string
+		reason string
+	}{
+		{
+			name:   "missing",
+			status: http.StatusUnauthorized,
+			header: `Bearer realm="api"`,
+			reason: jose.ReasonMissing,
+		},
+		{
+			name:   "malformed",
+			token:  "a.b.c",
+			status: http.StatusUnauthorized,
+			header: `Bearer realm="api", error="invalid_token", error_description="the token is malformed"`,
+			reason: jose.ReasonMalformed,
+		},
+		{
+			name:   "expired",
+			token:  expired,
+			status: http.StatusUnauthorized,
+			header: `Bearer realm="api", error="invalid_token", error_description="the token is expired"`,
+			reason: jose.ReasonExpired,
+		},
+		{
+			name:   "forbidden",
+			token:  token,
+			status: http.StatusForbidden,
+			header: `Bearer realm="api", error="insufficient_scope", error_description="the token does not have the required roles"`,
+			reason: jose.ReasonInsufficientRole,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, cfg.Endpoint, http.NoBody)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "BEARER "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("unexpected status code: %d", w.Code)
+			}
+			if h := w.Header().Get("WWW-Authenticate"); h != tc.header {
+				t.Errorf("unexpected WWW-Authenticate header: %s", h)
+			}
+			body := map[string]interface{}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Errorf("unexpected body: %s", w.Body.String())
+				return
+			}
+			if body["reason"] != tc.reason {
+				t.Errorf("unexpected reason: %v", body["reason"])
+			}
+		})
+	}
+}
+
+func TestTokenSignatureValidator_logOnly(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger, _ := logging.NewLogger("DEBUG", buf, "")
+	hf := TokenSignatureValidator(func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		}
+	}, logger, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", []string{"role_c"})
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["enforcement_mode"] = "log_only"
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, proxy.NoopProxy))
+
+	token := newSignedToken(t, map[string]interface{}{
+		"aud":   "http://api.example.com",
+		"iss":   "http://example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"role_a"},
+	})
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		log   string
+	}{
+		{
+			name: "missing",
+			log:  "Request would have been rejected with status 401: missing",
+		},
+		{
+			name:  "forbidden",
+			token: token,
+			log:   "Request would have been rejected with status 403: insufficient_role",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, cfg.Endpoint, http.NoBody)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("unexpected status code: %d", w.Code)
+			}
+			if w.Header().Get("WWW-Authenticate") != "" {
+				t.Errorf("unexpected WWW-Authenticate header: %s", w.Header().Get("WWW-Authenticate"))
+			}
+			if !strings.Contains(buf.String(), tc.log) {
+				t.Errorf("unexpected log: %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestTokenSignatureValidator_paramConstraints(t *testing.T) {
+	hf := TokenSignatureValidator(func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		}
+	}, logging.NoOp, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", nil)
+	cfg.Endpoint = "/users/:user_id/orders"
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["param_constraints"] = []map[string]string{{"param": "user_id", "claim": "sub"}}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, proxy.NoopProxy))
+
+	token := newSignedToken(t, map[string]interface{}{
+		"aud": "http://api.example.com",
+		"iss": "http://example.com",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"sub": "1234567890",
+	})
+
+	for path, status := range map[string]int{
+		"/users/1234567890/orders": http.StatusOK,
+		"/users/42/orders":         http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != status {
+			t.Errorf("%s: unexpected status code: %d", path, w.Code)
+		}
+	}
+}
+
+func TestTokenSignatureValidator_responseFilters(t *testing.T) {
+	prxy := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"name": "john", "salary": 1000}}, nil
+	}
+	hf := TokenSignatureValidator(func(_ *config.EndpointConfig, p proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			resp, _ := p(c, &proxy.Request{})
+			c.JSON(http.StatusOK, resp.Data)
+		}
+	}, logging.NoOp, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", nil)
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["scopes_key"] = "scope"
+	extra["response_filters"] = []map[string]interface{}{{"field": "salary", "scopes": []string{"hr:read"}}}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, prxy))
+
+	for scope, expected := range map[string]string{
+		"hr:read": `{"name":"john","salary":1000}`,
+		"read":    `{"name":"john"}`,
+	} {
+		token := newSignedToken(t, map[string]interface{}{
+			"aud":   "http://api.example.com",
+			"iss":   "http://example.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": scope,
+		})
+		req := httptest.NewRequest(http.MethodGet, cfg.Endpoint, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status code: %d", scope, w.Code)
+		}
+		if body := w.Body.String(); body != expected {
+			t.Errorf("%s: unexpected body: %s", scope, body)
+		}
+	}
+}
+
+func TestRegisterJWKSHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	RegisterJWKSHandler(engine, config.ExtraConfig{
+		jose.JWKSNamespace: map[string]interface{}{
+			"path":           "/.well-known/jwks.json",
+			"jwk_local_path": "../fixtures/private.json",
+		},
+	}, logging.NoOp)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", http.NoBody)
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"kid":"2011-04-29"`) || strings.Contains(body, `"d":`) {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func newSignedToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	_, signer, err := jose.NewSigner(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			jose.SignerNamespace: map[string]interface{}{
+				"alg":                  "HS256",
+				"kid":                  "sim2",
+				"jwk_local_path":       "../fixtures/symmetric.json",
+				"disable_jwk_security": true,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signer(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
 func jwkEndpoint(name string) http.HandlerFunc {
 	data, err := os.ReadFile("../fixtures/" + name + ".json")
 	return func(rw http.ResponseWriter, _ *http.Request) {
@@ -240,3 +523,145 @@ func jwkEndpoint(name string) http.HandlerFunc {
 		rw.Write(data)
 	}
 }
+
+func TestTokenSignatureValidator_tenant(t *testing.T) {
+	hf := TokenSignatureValidator(func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetHeader("X-Tenant")+" "+c.Query("tenant"))
+		}
+	}, logging.NoOp, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", nil)
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["tenant"] = map[string]interface{}{
+		"claim":       "tenant",
+		"allowed":     []string{"acme"},
+		"header":      "X-Tenant",
+		"query_param": "tenant",
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, proxy.NoopProxy))
+
+	for tenant, status := range map[string]int{
+		"acme":   http.StatusOK,
+		"globex": http.StatusForbidden,
+	} {
+		token := newSignedToken(t, map[string]interface{}{
+			"aud":    "http://api.example.com",
+			"iss":    "http://example.com",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"tenant": tenant,
+		})
+		req := httptest.NewRequest(http.MethodGet, cfg.Endpoint+"?tenant=globex", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Tenant", "globex")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != status {
+			t.Errorf("%s: unexpected status code: %d", tenant, w.Code)
+			continue
+		}
+		if status == http.StatusOK && w.Body.String() != "acme acme" {
+			t.Errorf("%s: unexpected body: %s", tenant, w.Body.String())
+		}
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", []string{"admin"})
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["propagate_claims"] = [][]string{{"sub", "X-User"}}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(NewMiddleware(cfg, logging.NoOp, nil))
+	engine.GET(cfg.Endpoint, func(c *gin.Context) {
+		claims, _ := c.Get(jose.ClaimsContextKey)
+		sub, _ := claims.(map[string]interface{})["sub"].(string)
+		c.String(http.StatusOK, c.GetHeader("X-User")+" "+sub)
+	})
+
+	for i, tc := range []struct {
+		roles  []string
+		status int
+	}{
+		{roles: []string{"admin"}, status: http.StatusOK},
+		{roles: []string{"user"}, status: http.StatusForbidden},
+		{status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, cfg.Endpoint, http.NoBody)
+		if len(tc.roles) > 0 {
+			token := newSignedToken(t, map[string]interface{}{
+				"aud":   "http://api.example.com",
+				"iss":   "http://example.com",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"sub":   "1234",
+				"roles": tc.roles,
+			})
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Code)
+			continue
+		}
+		if tc.status == http.StatusOK && w.Body.String() != "1234 1234" {
+			t.Errorf("#%d: unexpected body: %s", i, w.Body.String())
+		}
+	}
+}
+
+func TestTokenSignatureValidator_authOptional(t *testing.T) {
+	hf := TokenSignatureValidator(func(_ *config.EndpointConfig, _ proxy.Proxy) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		}
+	}, logging.NoOp, nil)
+
+	cfg := newVerifierEndpointCfg("HS256", "../fixtures/symmetric.json", []string{"anonymous", "user"})
+	extra := cfg.ExtraConfig[jose.ValidatorNamespace].(map[string]interface{})
+	extra["jwk_local_path"] = "../fixtures/symmetric.json"
+	extra["cache"] = false
+	extra["auth_optional"] = true
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET(cfg.Endpoint, hf(cfg, proxy.NoopProxy))
+
+	for name, tc := range map[string]struct {
+		authorization string
+		status        int
+	}{
+		"missing": {status: http.StatusOK},
+		"invalid": {authorization: "Bearer not.a.token", status: http.StatusUnauthorized},
+		"valid": {
+			authorization: "Bearer " + newSignedToken(t, map[string]interface{}{
+				"aud":   "http://api.example.com",
+				"iss":   "http://example.com",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"roles": []string{"user"},
+			}),
+			status: http.StatusOK,
+		},
+	} {
+		req := httptest.NewRequest(http.MethodGet, cfg.Endpoint, http.NoBody)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: unexpected status code: %d", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package jose
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/luraproject/lura/v2/proxy"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const grpcAuthorizationKey = "authorization"
+
+// GRPCMetadataPropagator copies claims to the outgoing gRPC metadata of the requests sent to the backends.
+// The entries of the config are the ones of the header propagation, using metadata keys instead of header
+// names. The keys are lowercased, as required by gRPC.
+type GRPCMetadataPropagator struct {
+	propagator *HeadersPropagator
+	redactor   *Redactor
+}
+
+// NewGRPCMetadataPropagator returns the GRPCMetadataPropagator of the signature config, or nil if there are
+// no claims to propagate as gRPC metadata
+func NewGRPCMetadataPropagator(scfg *SignatureConfig) (*GRPCMetadataPropagator, error) {
+	if len(scfg.PropagateClaimsToGRPC) == 0 {
+		return nil, nil
+	}
+	redactor, err := NewRedactor(scfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]string, len(scfg.PropagateClaimsToGRPC))
+	for i, tuple := range scfg.PropagateClaimsToGRPC {
+		entry := append([]string{}, tuple...)
+		if len(entry) > 1 {
+			entry[1] = strings.ToLower(entry[1])
+		}
+		entries[i] = entry
+	}
+	return &GRPCMetadataPropagator{propagator: NewHeadersPropagator(entries), redactor: redactor}, nil
+}
+
+// Metadata returns the metadata pairs for the claims, once redacted
+func (p *GRPCMetadataPropagator) Metadata(claims map[string]interface{}) metadata.MD {
+	md := metadata.MD{}
+	p.propagator.PropagateFunc(p.redactor.Redact(claims), func(k, v string) {
+		md.Set(k, v)
+	})
+	return md
+}
+
+// Proxy wraps the proxy, adding the metadata of the claims carried by the context to the outgoing gRPC
+// metadata of the context passed to the next proxy
+func (p *GRPCMetadataPropagator) Proxy(next proxy.Proxy) proxy.Proxy {
+	if p == nil {
+		return next
+	}
+	return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok {
+			return next(ctx, req)
+		}
+		md := p.Metadata(claims)
+		if prev, ok := metadata.FromOutgoingContext(ctx); ok {
+			md = metadata.Join(prev, md)
+		}
+		return next(metadata.NewOutgoingContext(ctx, md), req)
+	}
+}
+
+// FromGRPCMetadata wraps the extractor factory, so the tokens sent as Bearer tokens in the authorization
+// key of the incoming gRPC metadata of the request context are extracted before trying the wrapped
+// extractors
+func FromGRPCMetadata(ef ExtractorFactory) ExtractorFactory {
+	return func(key string) func(r *http.Request) (*jwt.JSONWebToken, error) {
+		next := ef(key)
+		return func(r *http.Request) (*jwt.JSONWebToken, error) {
+			if raw := grpcMetadataToken(r.Context()); raw != "" {
+				return jwt.ParseSigned(raw)
+			}
+			if next == nil {
+				return nil, auth0.ErrTokenNotFound
+			}
+			return next(r)
+		}
+	}
+}
+
+// grpcMetadataToken returns the Bearer token of the incoming gRPC metadata of the context, if any
+func grpcMetadataToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, h := range md.Get(grpcAuthorizationKey) {
+		if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+			return h[7:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/luraproject/lura/v2/proxy"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestGRPCMetadataPropagator_Proxy(t *testing.T) {
+	p, err := NewGRPCMetadataPropagator(&SignatureConfig{
+		PropagateClaimsToGRPC: [][]string{{"sub", "X-User"}, {"email", "x-email"}, {"groups", "x-groups", "join=;"}},
+		Redaction:             []RedactionRule{{Claim: "email", Strategy: RedactionDrop}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var md metadata.MD
+	prxy := p.Proxy(func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return &proxy.Response{}, nil
+	})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc")
+	ctx = context.WithValue(ctx, ClaimsContextKey, map[string]interface{}{
+		"sub":    "1234",
+		"email":  "john@example.com",
+		"groups": []interface{}{"a", "b"},
+	})
+	if _, err := prxy(ctx, &proxy.Request{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := metadata.MD{"x-request-id": {"abc"}, "x-user": {"1234"}, "x-groups": {"a;b"}}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+
+	md = nil
+	if _, err := prxy(context.Background(), &proxy.Request{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if md != nil {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+}
+
+func TestGRPCMetadataPropagator_nil(t *testing.T) {
+	p, err := NewGRPCMetadataPropagator(&SignatureConfig{})
+	if p != nil || err != nil {
+		t.Errorf("unexpected propagator: %v, %v", p, err)
+	}
+	called := false
+	prxy := p.Proxy(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		called = true
+		return nil, nil
+	})
+	prxy(context.Background(), nil)
+	if !called {
+		t.Error("the proxy has not been called")
+	}
+}
+
+func TestFromGRPCMetadata(t *testing.T) {
+	token := "eyJhbGciOiJSUzI1NiIsImtpZCI6IjIwMTEtMDQtMjkifQ.e30.c2ln"
+	ef := FromGRPCMetadata(func(string) func(*http.Request) (*jwt.JSONWebToken, error) {
+		return func(*http.Request) (*jwt.JSONWebToken, error) {
+			return nil, auth0.ErrTokenNotFound
+		}
+	})("access_token")
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+	if _, err := ef(req); !errors.Is(err, auth0.ErrTokenNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ctx := metadata.NewIncomingContext(req.Context(), metadata.Pairs("authorization", "Bearer "+token))
+	req = req.WithContext(ctx)
+	jwtToken, err := ef(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if kid := jwtToken.Headers[0].KeyID; kid != "2011-04-29" {
+		t.Errorf("unexpected kid: %s", kid)
+	}
+	if raw := rawTokenFromRequest(req, "access_token"); raw != token {
+		t.Errorf("unexpected raw token: %s", raw)
+	}
+}
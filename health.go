@@ -0,0 +1,163 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// HealthChecker reports the status of a secret provider
+type HealthChecker interface {
+	Health() ProviderHealth
+}
+
+// ProviderHealth is the status of a secret provider
+type ProviderHealth struct {
+	URI string `json:"uri"`
+	// Healthy is false when the last fetch of the key set failed or when the circuit breaker is open
+	Healthy bool `json:"healthy"`
+	// LastFetch is the time of the last successful fetch of the key set
+	LastFetch time.Time `json:"last_fetch,omitempty"`
+	// LastError is the error of the last fetch, if it failed
+	LastError string `json:"last_error,omitempty"`
+	// Keys is the number of keys in the last key set fetched
+	Keys int `json:"keys"`
+	// CacheAge is the age of the last key set fetched, in nanoseconds
+	CacheAge time.Duration `json:"cache_age"`
+	// CircuitBreaker is the state of the circuit breaker, if any
+	CircuitBreaker string `json:"circuit_breaker,omitempty"`
+	// Backup is true when the keys have been loaded from the backup file, because the key set could not
+	// be fetched
+	Backup bool `json:"backup,omitempty"`
+}
+
+// providerStatus tracks the fetches of the key set of a secret provider
+type providerStatus struct {
+	uri     string
+	breaker *CircuitBreaker
+	now     func() time.Time
+	logger  Logger
+
+	mu        sync.RWMutex
+	lastFetch time.Time
+	lastErr   error
+	keys      int
+	backup    bool
+}
+
+func newProviderStatus(uri string, breaker *CircuitBreaker) *providerStatus {
+	return &providerStatus{uri: uri, breaker: breaker, now: time.Now, logger: stdLogger{}}
+}
+
+func (s *providerStatus) fetched(keys int) {
+	s.mu.Lock()
+	s.lastFetch = s.now()
+	s.lastErr = nil
+	s.keys = keys
+	s.backup = false
+	s.mu.Unlock()
+	s.logger.Log(LogDebug, "key set fetched", "uri", s.uri, "keys", keys)
+}
+
+// restored records the keys loaded from the backup. The provider stays unhealthy.
+func (s *providerStatus) restored(keys int) {
+	s.mu.Lock()
+	s.keys = keys
+	s.backup = true
+	s.mu.Unlock()
+	s.logger.Log(LogWarning, "key set loaded from the backup", "uri", s.uri, "keys", keys)
+}
+
+func (s *providerStatus) failed(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+	s.logger.Log(LogWarning, "unable to fetch the key set", "uri", s.uri, "error", err.Error())
+}
+
+func (s *providerStatus) health() ProviderHealth {
+	s.mu.RLock()
+	h := ProviderHealth{
+		URI:       s.uri,
+		Healthy:   s.lastErr == nil,
+		LastFetch: s.lastFetch,
+		Keys:      s.keys,
+		Backup:    s.backup,
+	}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
+	}
+	s.mu.RUnlock()
+
+	if !h.LastFetch.IsZero() {
+		h.CacheAge = s.now().Sub(h.LastFetch)
+	}
+	if s.breaker != nil {
+		h.CircuitBreaker = s.breaker.State()
+		h.Healthy = h.Healthy && h.CircuitBreaker != CircuitOpen
+	}
+	return h
+}
+
+// statusTransport records the result of the key set fetches in the status of the provider
+type statusTransport struct {
+	next   http.RoundTripper
+	status *providerStatus
+}
+
+func (t statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.status.failed(err)
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.status.failed(fmt.Errorf("unexpected status code %d", resp.StatusCode))
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.status.failed(err)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		t.status.failed(err)
+		return resp, nil
+	}
+	t.status.fetched(len(keySet.Keys))
+	return resp, nil
+}
+
+// SecretProvidersHealth returns the status of the secret providers shared by the validators
+func SecretProvidersHealth() []ProviderHealth {
+	return secretProviders.health()
+}
+
+// HealthHandler serves the status of the shared secret providers as JSON. The status code is 503 when
+// any of them is not healthy.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		statuses := SecretProvidersHealth()
+		code := http.StatusOK
+		for _, s := range statuses {
+			if !s.Healthy {
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(code)
+		json.NewEncoder(rw).Encode(statuses)
+	})
+}
@@ -0,0 +1,111 @@
+package jose
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJWKClient_Health(t *testing.T) {
+	var failing int32
+	endpoint := jwkEndpoint("public")
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		endpoint(rw, req)
+	}))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{
+		URI:            server.URL,
+		AllowInsecure:  true,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: "1m"},
+	}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	h := sp.Health()
+	if !h.Healthy || !h.LastFetch.IsZero() || h.Keys != 0 || h.CircuitBreaker != CircuitClosed {
+		t.Errorf("unexpected status before the first fetch: %+v", h)
+	}
+
+	if _, err := sp.GetKey("1"); err != nil {
+		t.Error(err)
+		return
+	}
+	h = sp.Health()
+	if !h.Healthy || h.LastFetch.IsZero() || h.Keys != 7 || h.CacheAge < 0 || h.URI != server.URL {
+		t.Errorf("unexpected status after a fetch: %+v", h)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	sp.GetKey("unknown")
+	h = sp.Health()
+	if h.Healthy || h.LastError == "" || h.Keys != 7 || h.CircuitBreaker != CircuitClosed {
+		t.Errorf("unexpected status after a failed fetch: %+v", h)
+	}
+
+	sp.GetKey("unknown")
+	if h = sp.Health(); h.CircuitBreaker != CircuitOpen {
+		t.Errorf("unexpected status after the circuit opened: %+v", h)
+	}
+}
+
+func TestJWKClient_Health_local(t *testing.T) {
+	sp, err := SecretProvider(SecretProviderConfig{LocalPath: "./fixtures/symmetric.json"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if h := sp.Health(); !h.Healthy || h.Keys != 2 || h.LastFetch.IsZero() || h.CircuitBreaker != "" {
+		t.Errorf("unexpected status: %+v", h)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	pool := secretProviders
+	defer func() { secretProviders = pool }()
+	secretProviders = &secretProviderPool{providers: map[string]*JWKClient{}}
+
+	server := httptest.NewServer(jwkEndpoint("public"))
+	defer server.Close()
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+
+	for _, uri := range []string{server.URL, failing.URL} {
+		if _, err := SharedSecretProvider(SecretProviderConfig{URI: uri, AllowInsecure: true}, "", nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	w := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+
+	for _, sp := range secretProviders.providers {
+		sp.GetKey("1")
+	}
+
+	w = httptest.NewRecorder()
+	HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	var statuses []ProviderHealth
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(statuses) != 2 || statuses[0].Healthy == statuses[1].Healthy {
+		t.Errorf("unexpected statuses: %+v", statuses)
+	}
+}
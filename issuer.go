@@ -0,0 +1,268 @@
+package jose
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+// ClaimsTemplate describes the claims added by the gateway to every payload before signing it
+type ClaimsTemplate struct {
+	// Static claims are set as they are, overriding the ones returned by the backend
+	Static map[string]interface{} `json:"static,omitempty"`
+	// FromResponse maps claim names to (dot separated) paths in the backend response
+	FromResponse map[string]string `json:"from_response,omitempty"`
+	// ExpiresIn is the lifetime of the token, in seconds or as "1h". The exp claim is not set when 0
+	ExpiresIn Seconds `json:"expires_in,omitempty"`
+	// NotBefore is the offset, in seconds, applied to the current time for the nbf claim
+	NotBefore *int64 `json:"not_before,omitempty"`
+	IssuedAt  bool   `json:"issued_at,omitempty"`
+	JTI       bool   `json:"jti,omitempty"`
+}
+
+// Apply returns a copy of the payload including the claims defined by the template. The
+// response data is used for resolving the FromResponse paths.
+func (t *ClaimsTemplate) Apply(payload, response map[string]interface{}) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(payload)+len(t.Static)+len(t.FromResponse)+4)
+	for k, v := range payload {
+		res[k] = v
+	}
+
+	for claim, path := range t.FromResponse {
+		key, data := getNestedClaim(path, response)
+		v, ok := data[key]
+		if !ok {
+			continue
+		}
+		res[claim] = v
+	}
+
+	for k, v := range t.Static {
+		res[k] = v
+	}
+
+	now := time.Now().Unix()
+	if t.IssuedAt {
+		res["iat"] = now
+	}
+	if t.NotBefore != nil {
+		res["nbf"] = now + *t.NotBefore
+	}
+	if t.ExpiresIn > 0 {
+		res["exp"] = now + int64(t.ExpiresIn)
+	}
+	if t.JTI {
+		jti, err := newJTI()
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate the jti: %s", err.Error())
+		}
+		res["jti"] = jti
+	}
+
+	return res, nil
+}
+
+func newJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// TokenIssuer signs the selected keys of the backend responses, using one or more signing profiles
+type TokenIssuer struct {
+	profiles       []issuerProfile
+	detached       *DetachedSigner
+	detachedHeader string
+	jarm           *JARMEncoder
+}
+
+type issuerProfile struct {
+	keys   []string
+	claims *ClaimsTemplate
+	sign   Signer
+}
+
+// NewTokenIssuer creates a TokenIssuer from the signer config of the endpoint. The root signer config is
+// used as a profile when it declares keys to sign. Every entry in the profiles list inherits the undefined
+// key material settings from the root config.
+func NewTokenIssuer(cfg *config.EndpointConfig, te auth0.RequestTokenExtractor) (*TokenIssuer, error) {
+	signerCfg, err := getSignerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgs := []SignerConfig{}
+	if len(signerCfg.KeysToSign) > 0 || (len(signerCfg.Profiles) == 0 && signerCfg.Detached == nil && signerCfg.JARM == nil) {
+		cfgs = append(cfgs, *signerCfg)
+	}
+	for _, p := range signerCfg.Profiles {
+		cfgs = append(cfgs, p.inherit(signerCfg))
+	}
+
+	issuer := &TokenIssuer{profiles: make([]issuerProfile, 0, len(cfgs))}
+
+	if signerCfg.Detached != nil {
+		spcfg, err := newSignerSecretProviderConfig(signerCfg)
+		if err != nil {
+			return nil, err
+		}
+		key, err := loadSigningKey(spcfg, signerCfg.KeyID, te)
+		if err != nil {
+			return nil, err
+		}
+		if signerCfg.ThumbprintKeyID {
+			if key, err = withThumbprintKeyID(key); err != nil {
+				return nil, err
+			}
+		}
+		issuer.detached, err = NewDetachedSigner(signerCfg.Detached, signerCfg.Alg, key)
+		if err != nil {
+			return nil, err
+		}
+		issuer.detachedHeader = signerCfg.Detached.HeaderName()
+	}
+
+	if signerCfg.JARM != nil {
+		s, err := newSigner(signerCfg, te)
+		if err != nil {
+			return nil, err
+		}
+		issuer.jarm = NewJARMEncoder(signerCfg.JARM, s)
+	}
+
+	for i := range cfgs {
+		p := cfgs[i]
+		if p.URI != signerCfg.URI && !validJWKSource(p.URI, p.DisableJWKSecurity) {
+			return nil, ErrInsecureJWKSource
+		}
+		s, err := newSigner(&p, te)
+		if err != nil {
+			return nil, err
+		}
+		issuer.profiles = append(issuer.profiles, issuerProfile{
+			keys:   p.KeysToSign,
+			claims: p.Claims,
+			sign:   s,
+		})
+	}
+	return issuer, nil
+}
+
+// Issue replaces the values of the keys to sign in the response data with the tokens generated by
+// every profile, and wraps the authorization responses into JARM ones if enabled
+func (t *TokenIssuer) Issue(response *proxy.Response) error {
+	for _, p := range t.profiles {
+		if err := SignFields(p.keys, p.signer(response.Data), response); err != nil {
+			return err
+		}
+	}
+	if t.jarm != nil {
+		return t.jarm.Wrap(response)
+	}
+	return nil
+}
+
+// FormPost returns the HTML form posting the JARM response to the client, for the form_post.jwt mode
+func (t *TokenIssuer) FormPost(response *proxy.Response) ([]byte, bool) {
+	if t.jarm == nil {
+		return nil, false
+	}
+	return t.jarm.FormPost(response)
+}
+
+// SignsPayload returns true if the issuer generates detached signatures of the response bodies
+func (t *TokenIssuer) SignsPayload() bool {
+	return t.detached != nil
+}
+
+// SignPayload returns the headers to add to the response in order to send the detached signature of
+// the payload. It returns nil if detached signatures are not enabled.
+func (t *TokenIssuer) SignPayload(payload []byte) (map[string]string, error) {
+	if t.detached == nil {
+		return nil, nil
+	}
+	jws, err := t.detached.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{t.detachedHeader: jws}, nil
+}
+
+func (p issuerProfile) signer(data map[string]interface{}) Signer {
+	if p.claims == nil {
+		return p.sign
+	}
+	return func(v interface{}) (string, error) {
+		payload, ok := v.(map[string]interface{})
+		if !ok {
+			return p.sign(v)
+		}
+		claims, err := p.claims.Apply(payload, data)
+		if err != nil {
+			return "", err
+		}
+		return p.sign(claims)
+	}
+}
+
+func (s SignerConfig) inherit(parent *SignerConfig) SignerConfig {
+	if s.Alg == "" {
+		s.Alg = parent.Alg
+	}
+	if s.KeyID == "" {
+		s.KeyID = parent.KeyID
+	}
+	if s.URI == "" {
+		s.URI = parent.URI
+	}
+	if len(s.CipherSuites) == 0 {
+		s.CipherSuites = parent.CipherSuites
+	}
+	if len(s.Fingerprints) == 0 {
+		s.Fingerprints = parent.Fingerprints
+	}
+	if len(s.Pins) == 0 {
+		s.Pins = parent.Pins
+	}
+	if s.TLS == nil {
+		s.TLS = parent.TLS
+	}
+	if s.Transport == "" {
+		s.Transport = parent.Transport
+	}
+	if s.LocalCA == "" {
+		s.LocalCA = parent.LocalCA
+	}
+	if s.LocalPath == "" {
+		s.LocalPath = parent.LocalPath
+	}
+	if s.Kubernetes == nil {
+		s.Kubernetes = parent.Kubernetes
+	}
+	if s.KVStore == nil {
+		s.KVStore = parent.KVStore
+	}
+	if s.SecretURL == "" {
+		s.SecretURL = parent.SecretURL
+	}
+	if len(s.CipherKey) == 0 {
+		s.CipherKey = parent.CipherKey
+	}
+	if s.TokenFormat == "" {
+		s.TokenFormat = parent.TokenFormat
+	}
+	if s.Paseto == nil {
+		s.Paseto = parent.Paseto
+	}
+	s.DisableJWKSecurity = s.DisableJWKSecurity || parent.DisableJWKSecurity
+	s.ThumbprintKeyID = s.ThumbprintKeyID || parent.ThumbprintKeyID
+	s.Profiles = nil
+	return s
+}
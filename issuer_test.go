@@ -0,0 +1,212 @@
+package jose
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestClaimsTemplate_Apply(t *testing.T) {
+	nbf := int64(-10)
+	tmpl := ClaimsTemplate{
+		Static:       map[string]interface{}{"iss": "https://gateway.example.com", "aud": "backend"},
+		FromResponse: map[string]string{"sub": "user.id", "missing": "user.unknown"},
+		ExpiresIn:    3600,
+		NotBefore:    &nbf,
+		IssuedAt:     true,
+		JTI:          true,
+	}
+	payload := map[string]interface{}{"iss": "backend", "roles": []interface{}{"a"}}
+	response := map[string]interface{}{"user": map[string]interface{}{"id": "1234"}}
+
+	now := time.Now().Unix()
+	res, err := tmpl.Apply(payload, response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res["iss"] != "https://gateway.example.com" {
+		t.Errorf("unexpected iss: %v", res["iss"])
+	}
+	if res["aud"] != "backend" {
+		t.Errorf("unexpected aud: %v", res["aud"])
+	}
+	if res["sub"] != "1234" {
+		t.Errorf("unexpected sub: %v", res["sub"])
+	}
+	if _, ok := res["missing"]; ok {
+		t.Error("unexpected claim 'missing'")
+	}
+	if iat, ok := res["iat"].(int64); !ok || iat < now {
+		t.Errorf("unexpected iat: %v", res["iat"])
+	}
+	if exp, ok := res["exp"].(int64); !ok || exp < now+3600 {
+		t.Errorf("unexpected exp: %v", res["exp"])
+	}
+	if nbf, ok := res["nbf"].(int64); !ok || nbf < now-10 || nbf > now {
+		t.Errorf("unexpected nbf: %v", res["nbf"])
+	}
+	if jti, ok := res["jti"].(string); !ok || len(jti) != 22 {
+		t.Errorf("unexpected jti: %v", res["jti"])
+	}
+	if payload["iss"] != "backend" {
+		t.Error("the payload has been modified")
+	}
+}
+
+func TestNewTokenIssuer(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["claims"] = map[string]interface{}{
+		"static":        map[string]interface{}{"iss": "https://gateway.example.com"},
+		"from_response": map[string]interface{}{"sub": "user_id"},
+		"expires_in":    60,
+	}
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["profiles"] = []interface{}{
+		map[string]interface{}{
+			"alg":          "PS256",
+			"kid":          "p256",
+			"keys_to_sign": []string{"grants"},
+		},
+	}
+
+	issuer, err := NewTokenIssuer(cfg, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response := &proxy.Response{
+		Data: map[string]interface{}{
+			"user_id":       "1234",
+			"access_token":  map[string]interface{}{"roles": []interface{}{"a"}},
+			"refresh_token": []interface{}{map[string]interface{}{"a": 1}, "foo"},
+			"grants":        []interface{}{map[string]interface{}{"grant": "a"}, map[string]interface{}{"grant": "b"}},
+		},
+	}
+	if err := issuer.Issue(response); err != nil {
+		t.Error(err)
+		return
+	}
+
+	claims := parseUnverified(t, response.Data["access_token"], "2011-04-29")
+	if claims["iss"] != "https://gateway.example.com" || claims["sub"] != "1234" || claims["exp"] == nil {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	refresh, ok := response.Data["refresh_token"].([]interface{})
+	if !ok || len(refresh) != 2 {
+		t.Errorf("unexpected refresh tokens: %v", response.Data["refresh_token"])
+		return
+	}
+	parseUnverified(t, refresh[0], "2011-04-29")
+	if refresh[1] != "foo" {
+		t.Errorf("unexpected refresh token: %v", refresh[1])
+	}
+
+	grants, ok := response.Data["grants"].([]interface{})
+	if !ok || len(grants) != 2 {
+		t.Errorf("unexpected grants: %v", response.Data["grants"])
+		return
+	}
+	for i, g := range grants {
+		claims := parseUnverified(t, g, "p256")
+		if _, ok := claims["iss"]; ok {
+			t.Errorf("the profile #%d should not inherit the claims template: %v", i, claims)
+		}
+	}
+}
+
+func TestNewTokenIssuer_unsecureProfile(t *testing.T) {
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", "https://jwk.example.com")
+	cfg.ExtraConfig[SignerNamespace] = map[string]interface{}{
+		"alg":     "RS256",
+		"kid":     "2011-04-29",
+		"jwk_url": "https://jwk.example.com",
+		"profiles": []interface{}{
+			map[string]interface{}{
+				"jwk_url":      "http://jwk.example.com",
+				"keys_to_sign": []string{"grants"},
+			},
+		},
+	}
+	if _, err := NewTokenIssuer(cfg, nil); err != ErrInsecureJWKSource {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTokenIssuer_SignPayload(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["detached"] = map[string]interface{}{"header": "x-signature"}
+
+	issuer, err := NewTokenIssuer(cfg, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !issuer.SignsPayload() {
+		t.Error("the issuer should sign the payloads")
+		return
+	}
+
+	body := []byte(`{"foo":"bar"}`)
+	headers, err := issuer.SignPayload(body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	jws, ok := headers["x-signature"]
+	if !ok {
+		t.Errorf("unexpected headers: %v", headers)
+		return
+	}
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = VerifyDetached(jws, body, "RS256", nil, func(kid string) (interface{}, error) {
+		return sp.GetKey(kid)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewTokenIssuer_noConfig(t *testing.T) {
+	if _, err := NewTokenIssuer(&config.EndpointConfig{}, nil); err != ErrNoSignerCfg {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func parseUnverified(t *testing.T, v interface{}, kid string) map[string]interface{} {
+	token, ok := v.(string)
+	if !ok {
+		t.Errorf("unexpected token type: %T", v)
+		return nil
+	}
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Errorf("parsing the token: %s", err.Error())
+		return nil
+	}
+	if parsed.Headers[0].KeyID != kid {
+		t.Errorf("unexpected kid. have %s, want %s", parsed.Headers[0].KeyID, kid)
+	}
+	claims := map[string]interface{}{}
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		t.Errorf("decoding the claims: %s", err.Error())
+	}
+	return claims
+}
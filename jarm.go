@@ -0,0 +1,157 @@
+package jose
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+// Response modes defined by JARM
+const (
+	ResponseModeJWT         = "jwt"
+	ResponseModeQueryJWT    = "query.jwt"
+	ResponseModeFragmentJWT = "fragment.jwt"
+	ResponseModeFormPostJWT = "form_post.jwt"
+
+	defaultJARMLifetime = 10 * time.Minute
+)
+
+var (
+	ErrUnknownResponseMode = errors.New("unknown response mode")
+	ErrJARMNoClient        = errors.New("the authorization response has no client_id")
+)
+
+// JARMConfig wraps the authorization responses returned by the backend into JWT secured authorization
+// responses (JARM), signed (and encrypted, if the signer has an encryption key) with the signer key. The
+// backend returns the params of the response (code, state, error...) together with the client_id, the
+// redirect_uri and the response_mode, and the gateway redirects the user agent to the client with the
+// response JWT. Without redirect_uri, the response JWT is returned in the response key of the body.
+type JARMConfig struct {
+	// Issuer is the iss claim of the responses, the issuer of the authorization server
+	Issuer string `json:"issuer"`
+	// ExpiresIn is the lifetime of the responses, in seconds or as "5m". Defaults to 10m
+	ExpiresIn Seconds `json:"expires_in,omitempty"`
+}
+
+// JARMEncoder generates the JWT secured authorization responses
+type JARMEncoder struct {
+	issuer    string
+	expiresIn time.Duration
+	sign      Signer
+}
+
+// NewJARMEncoder returns a JARMEncoder signing the responses with the signer
+func NewJARMEncoder(cfg *JARMConfig, sign Signer) *JARMEncoder {
+	e := &JARMEncoder{issuer: cfg.Issuer, expiresIn: cfg.ExpiresIn.Duration(), sign: sign}
+	if e.expiresIn == 0 {
+		e.expiresIn = defaultJARMLifetime
+	}
+	return e
+}
+
+// Encode returns the response JWT with the params of the authorization response, issued for the client
+func (e *JARMEncoder) Encode(clientID string, params map[string]interface{}) (string, error) {
+	if clientID == "" {
+		return "", ErrJARMNoClient
+	}
+	claims := make(map[string]interface{}, len(params)+3)
+	for k, v := range params {
+		claims[k] = v
+	}
+	claims["iss"] = e.issuer
+	claims["aud"] = clientID
+	claims["exp"] = time.Now().Add(e.expiresIn).Unix()
+	return e.sign(claims)
+}
+
+// Wrap replaces the authorization response of the backend with the JARM one. The response is turned into
+// a redirection to the redirect_uri for the query and fragment modes.
+func (e *JARMEncoder) Wrap(response *proxy.Response) error {
+	params := make(map[string]interface{}, len(response.Data))
+	for k, v := range response.Data {
+		params[k] = v
+	}
+	clientID, _ := params["client_id"].(string)
+	redirectURI, _ := params["redirect_uri"].(string)
+	mode, _ := params["response_mode"].(string)
+	delete(params, "client_id")
+	delete(params, "redirect_uri")
+	delete(params, "response_mode")
+
+	switch mode {
+	case "", ResponseModeJWT:
+		// the default mode of the response types issuing tokens in the authorization response is fragment
+		mode = ResponseModeQueryJWT
+		if _, ok := params["access_token"]; ok {
+			mode = ResponseModeFragmentJWT
+		} else if _, ok := params["id_token"]; ok {
+			mode = ResponseModeFragmentJWT
+		}
+	case ResponseModeQueryJWT, ResponseModeFragmentJWT, ResponseModeFormPostJWT:
+	default:
+		return ErrUnknownResponseMode
+	}
+
+	token, err := e.Encode(clientID, params)
+	if err != nil {
+		return err
+	}
+	response.Data = map[string]interface{}{"response": token}
+	if redirectURI == "" {
+		return nil
+	}
+
+	switch mode {
+	case ResponseModeFormPostJWT:
+		response.Data["redirect_uri"] = redirectURI
+		response.Data["response_mode"] = mode
+	default:
+		u, err := url.Parse(redirectURI)
+		if err != nil {
+			return err
+		}
+		if mode == ResponseModeQueryJWT {
+			q := u.Query()
+			q.Set("response", token)
+			u.RawQuery = q.Encode()
+		} else {
+			u.Fragment, u.RawFragment = "response="+token, ""
+		}
+		if response.Metadata.Headers == nil {
+			response.Metadata.Headers = map[string][]string{}
+		}
+		response.Metadata.Headers["Location"] = []string{u.String()}
+		response.Metadata.StatusCode = http.StatusSeeOther
+	}
+	return nil
+}
+
+var jarmFormPost = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html><head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.RedirectURI}}"><input type="hidden" name="response" value="{{.Response}}"/></form>
+</body></html>
+`))
+
+// FormPost returns the auto-submitted HTML form posting the response JWT to the client, if the response
+// has been wrapped in the form_post.jwt mode
+func (e *JARMEncoder) FormPost(response *proxy.Response) ([]byte, bool) {
+	if response.Data["response_mode"] != ResponseModeFormPostJWT {
+		return nil, false
+	}
+	redirectURI, _ := response.Data["redirect_uri"].(string)
+	token, _ := response.Data["response"].(string)
+	buf := new(bytes.Buffer)
+	if err := jarmFormPost.Execute(buf, struct {
+		RedirectURI string
+		Response    string
+	}{redirectURI, token}); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
@@ -0,0 +1,106 @@
+package jose
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/proxy"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestTokenIssuer_jarm(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["jarm"] = map[string]interface{}{
+		"issuer":     "https://gateway.example.com",
+		"expires_in": "5m",
+	}
+	issuer, err := NewTokenIssuer(cfg, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response := &proxy.Response{
+		Data: map[string]interface{}{
+			"code":         "abc",
+			"state":        "xyz",
+			"client_id":    "bank-1",
+			"redirect_uri": "https://client.example.com/cb?tenant=1",
+		},
+		Metadata: proxy.Metadata{StatusCode: http.StatusOK},
+	}
+	if err := issuer.Issue(response); err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Metadata.StatusCode != http.StatusSeeOther {
+		t.Errorf("unexpected status code: %d", response.Metadata.StatusCode)
+	}
+	location, err := url.Parse(response.Metadata.Headers["Location"][0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if location.Host != "client.example.com" || location.Query().Get("tenant") != "1" {
+		t.Errorf("unexpected location: %s", location)
+	}
+	token, err := jwt.ParseSigned(location.Query().Get("response"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	claims := map[string]interface{}{}
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		t.Error(err)
+		return
+	}
+	if claims["iss"] != "https://gateway.example.com" || claims["aud"] != "bank-1" || claims["code"] != "abc" || claims["state"] != "xyz" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if _, ok := claims["redirect_uri"]; ok {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	response = &proxy.Response{Data: map[string]interface{}{
+		"access_token": "token",
+		"client_id":    "bank-1",
+		"redirect_uri": "https://client.example.com/cb",
+	}}
+	if err := issuer.Issue(response); err != nil {
+		t.Error(err)
+		return
+	}
+	if l := response.Metadata.Headers["Location"][0]; !strings.HasPrefix(l, "https://client.example.com/cb#response=ey") {
+		t.Errorf("unexpected location: %s", l)
+	}
+
+	response = &proxy.Response{Data: map[string]interface{}{
+		"code":          "abc",
+		"client_id":     "bank-1",
+		"redirect_uri":  "https://client.example.com/cb",
+		"response_mode": "form_post.jwt",
+	}}
+	if err := issuer.Issue(response); err != nil {
+		t.Error(err)
+		return
+	}
+	form, ok := issuer.FormPost(response)
+	if !ok || !strings.Contains(string(form), `action="https://client.example.com/cb"`) || !strings.Contains(string(form), `name="response" value="ey`) {
+		t.Errorf("unexpected form: %s", form)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"code": "abc"},
+		{"code": "abc", "client_id": "bank-1", "response_mode": "query"},
+	} {
+		if err := issuer.Issue(&proxy.Response{Data: data}); err == nil {
+			t.Errorf("%v: error expected", data)
+		}
+	}
+}
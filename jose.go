@@ -1,9 +1,9 @@
 package jose
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -22,33 +22,31 @@ type ExtractorFactory func(string) func(r *http.Request) (*jwt.JSONWebToken, err
 func NewValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (*auth0.JWTValidator, error) {
 	sa, ok := supportedAlgorithms[signatureConfig.Alg]
 	if !ok {
-		return nil, fmt.Errorf("JOSE: unknown algorithm %s", signatureConfig.Alg)
+		return nil, fmt.Errorf("JOSE: %w %s", ErrUnknownAlg, signatureConfig.Alg)
 	}
-	te := auth0.FromMultiple(
+	logger := loggerOrDefault(signatureConfig.Logger)
+	for _, err := range ValidateConfig(signatureConfig) {
+		if e, ok := err.(*ConfigError); ok {
+			logger.Log(LogWarning, "config problem", "code", e.Code, "field", e.Field, "message", e.Message)
+		}
+	}
+	extractors := []auth0.RequestTokenExtractor{
 		auth0.RequestTokenExtractorFunc(auth0.FromHeader),
 		auth0.RequestTokenExtractorFunc(ef(signatureConfig.CookieKey)),
-	)
+	}
+	extractorID := fmt.Sprintf("%s|%x", signatureConfig.CookieKey, reflect.ValueOf(ef).Pointer())
+	if signatureConfig.FAPI != nil {
+		extractors = append(extractors, auth0.RequestTokenExtractorFunc(FromDPoPHeader))
+		extractorID += "|dpop"
+	}
+	te := memoizedExtractor(auth0.FromMultiple(extractors...))
 
-	decodedFs, err := DecodeFingerprints(signatureConfig.Fingerprints)
+	cfg, err := newSecretProviderConfig(signatureConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := SecretProviderConfig{
-		URI:                 signatureConfig.URI,
-		CacheEnabled:        signatureConfig.CacheEnabled,
-		CacheDuration:       signatureConfig.CacheDuration,
-		Fingerprints:        decodedFs,
-		Cs:                  signatureConfig.CipherSuites,
-		LocalCA:             signatureConfig.LocalCA,
-		AllowInsecure:       signatureConfig.DisableJWKSecurity,
-		LocalPath:           signatureConfig.LocalPath,
-		SecretURL:           signatureConfig.SecretURL,
-		CipherKey:           signatureConfig.CipherKey,
-		KeyIdentifyStrategy: signatureConfig.KeyIdentifyStrategy,
-	}
-
-	sp, err := SecretProvider(cfg, te)
+	sp, err := SharedSecretProvider(cfg, extractorID, te)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +54,7 @@ func NewValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (*auth0
 	return auth0.NewValidator(
 		auth0.NewConfiguration(
 			sp,
-			signatureConfig.Audience,
+			expectedAudience(signatureConfig),
 			signatureConfig.Issuer,
 			sa,
 		),
@@ -64,97 +62,212 @@ func NewValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (*auth0
 	), nil
 }
 
-func CanAccessNested(roleKey string, claims map[string]interface{}, required []string) bool {
-	if len(required) == 0 {
-		return true
+// expectedAudience returns the audiences the token must have all of. The entra profile accepts any of its
+// audiences instead, so it checks them itself.
+func expectedAudience(signatureConfig *SignatureConfig) []string {
+	if signatureConfig.Provider != nil && signatureConfig.Provider.Name == ProviderEntra {
+		return nil
 	}
+	return signatureConfig.Audience
+}
 
-	tmp := claims
-	keys := strings.Split(roleKey, ".")
+// ClaimsValidator validates the token sent with the request and returns its claims
+type ClaimsValidator func(r *http.Request) (map[string]interface{}, error)
 
-	for _, key := range keys[:len(keys)-1] {
-		v, ok := tmp[key]
-		if !ok {
-			return false
+// NewClaimsValidator returns a ClaimsValidator for the token format of the signature config
+func NewClaimsValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (ClaimsValidator, error) {
+	v, err := newTokenClaimsValidator(signatureConfig, ef)
+	if err != nil {
+		return nil, err
+	}
+	return newRequestClaimsValidator(signatureConfig, v)
+}
+
+// newTokenClaimsValidator validates the token itself, so its result only depends on the token and it can
+// be cached
+func newTokenClaimsValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (ClaimsValidator, error) {
+	v, err := newClaimsValidator(signatureConfig, ef)
+	if err != nil {
+		return nil, err
+	}
+	return providerClaimsValidator(signatureConfig, v), nil
+}
+
+// newRequestClaimsValidator adds the checks bound to the request, as the DPoP proofs, the client
+// certificates and the nonces, to the token validator. They are applied to every request, even when the
+// token validation is cached.
+func newRequestClaimsValidator(signatureConfig *SignatureConfig, v ClaimsValidator) (ClaimsValidator, error) {
+	timeout, err := signatureConfig.Timeouts.validation()
+	if err != nil {
+		return nil, err
+	}
+	if v, err = fapiClaimsValidator(signatureConfig, v); err != nil {
+		return nil, err
+	}
+	if v, err = tokenProfileClaimsValidator(signatureConfig, v); err != nil {
+		return nil, err
+	}
+	if v, err = nonceClaimsValidator(signatureConfig.Nonce, v); err != nil {
+		return nil, err
+	}
+	v = tracedClaimsValidator(v, signatureConfig.CookieKey)
+	return timeoutClaimsValidator(claimsLimitsValidator(signatureConfig, v), timeout), nil
+}
+
+func newClaimsValidator(signatureConfig *SignatureConfig, ef ExtractorFactory) (ClaimsValidator, error) {
+	switch signatureConfig.TokenFormat {
+	case TokenFormatPaseto:
+		v, err := NewPasetoValidator(signatureConfig)
+		if err != nil {
+			return nil, err
 		}
-		tmp, ok = v.(map[string]interface{})
-		if !ok {
-			return false
+		return v.ValidateRequest, nil
+	case TokenFormatBiscuit:
+		v, err := NewBiscuitValidator(signatureConfig)
+		if err != nil {
+			return nil, err
 		}
+		return v.ValidateRequest, nil
 	}
-	return CanAccess(keys[len(keys)-1], tmp, required)
+
+	validator, err := NewValidator(signatureConfig, ef)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) (map[string]interface{}, error) {
+		token, err := validator.ValidateRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTokenType(signatureConfig, token.Headers[0]); err != nil {
+			return nil, err
+		}
+		claims := numberClaims{}
+		if err := validator.Claims(r, token, &claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}, nil
 }
 
+func newSecretProviderConfig(signatureConfig *SignatureConfig) (SecretProviderConfig, error) {
+	decodedFs, err := DecodeFingerprints(signatureConfig.Fingerprints)
+	if err != nil {
+		return SecretProviderConfig{}, err
+	}
+	pins, err := DecodePins(signatureConfig.Pins)
+	if err != nil {
+		return SecretProviderConfig{}, err
+	}
+	keyFetchTimeout, err := signatureConfig.Timeouts.keyFetch()
+	if err != nil {
+		return SecretProviderConfig{}, err
+	}
+
+	return SecretProviderConfig{
+		URI:                 signatureConfig.URI,
+		CacheEnabled:        signatureConfig.CacheEnabled,
+		CacheDuration:       uint32(signatureConfig.CacheDuration),
+		CacheDurations:      signatureConfig.cacheDurations(),
+		CacheMaxKeys:        signatureConfig.CacheMaxKeys,
+		Pins:                pins,
+		PinExpiryWarning:    signatureConfig.PinExpiryWarning.Duration(),
+		TLS:                 signatureConfig.TLS,
+		Transport:           signatureConfig.Transport,
+		Retry:               signatureConfig.Retry,
+		Prefetch:            signatureConfig.PrefetchOnStart,
+		PrefetchFailFast:    signatureConfig.PrefetchFailFast,
+		BackupPath:          signatureConfig.BackupPath,
+		SignedKeySet:        signatureConfig.SignedKeySet,
+		KeyIteration:        signatureConfig.KeyIteration,
+		Logger:              signatureConfig.Logger,
+		Fingerprints:        decodedFs,
+		Cs:                  signatureConfig.CipherSuites,
+		LocalCA:             signatureConfig.LocalCA,
+		AllowInsecure:       signatureConfig.DisableJWKSecurity,
+		LocalPath:           signatureConfig.LocalPath,
+		SecretURL:           signatureConfig.SecretURL,
+		CipherKey:           signatureConfig.CipherKey,
+		KeyIdentifyStrategy: signatureConfig.KeyIdentifyStrategy,
+		KeyFetchTimeout:     keyFetchTimeout,
+		CircuitBreaker:      signatureConfig.CircuitBreaker,
+		RefreshLimit:        signatureConfig.RefreshRateLimit,
+		KeySetFormat:        signatureConfig.KeySetFormat,
+		KeyUsage:            signatureConfig.KeyUsage,
+		LocalReloadInterval: signatureConfig.LocalReloadInterval.Duration(),
+		Kubernetes:          signatureConfig.Kubernetes,
+		KVStore:             signatureConfig.KVStore,
+		SharedCache:         signatureConfig.SharedCache,
+		CacheControl:        signatureConfig.CacheControl,
+	}, nil
+}
+
+func CanAccessNested(roleKey string, claims map[string]interface{}, required []string) bool {
+	return CanAccessPath(NewClaimPath(roleKey, true), claims, required)
+}
+
+// CustomFieldsMatcher returns true if every wanted claim has one of its wanted values, separated by |.
+// The keys can be dot separated paths to nested claims, and the numbers and booleans are compared by their
+// text, as "42" or "true". Array claims match if any of their elements does.
 func CustomFieldsMatcher(claims map[string]interface{}, wantedFields map[string]string) bool {
 	if len(wantedFields) == 0 {
 		return true
 	}
 
-	var matched = 0
-
 	for wantedKey, possibleWantedValues := range wantedFields {
+		values, ok := customFieldValues(claims, wantedKey)
+		if !ok {
+			return false
+		}
 
 		wantedValues := strings.Split(possibleWantedValues, "|")
-
-		for key, value := range claims {
-			if key == wantedKey && reflect.TypeOf(value).Kind() == reflect.String {
-				valueAsString := value.(string)
-
-				foundPossibility := false
-				for _, wantedValue := range wantedValues {
-					if valueAsString == wantedValue {
-						foundPossibility = true
-						break
-					}
-				}
-
-				if foundPossibility {
-					matched++
+		foundPossibility := false
+		for _, value := range values {
+			for _, wantedValue := range wantedValues {
+				if value == wantedValue {
+					foundPossibility = true
 					break
 				}
 			}
 		}
+		if !foundPossibility {
+			return false
+		}
 	}
 
-	return matched == len(wantedFields)
+	return true
 }
 
-func CanAccess(roleKey string, claims map[string]interface{}, required []string) bool {
-	if len(required) == 0 {
-		return true
-	}
-
-	tmp, ok := claims[roleKey]
+// customFieldValues returns the values of the claim compared by the custom fields matchers. A claim whose
+// name contains dots is looked up before the nested path. Objects never match.
+func customFieldValues(claims map[string]interface{}, key string) ([]string, bool) {
+	v, ok := claims[key]
 	if !ok {
-		return false
-	}
-
-	roles, ok := tmp.([]interface{})
-	if ok {
-		for _, role := range required {
-			for _, r := range roles {
-				if r.(string) == role {
-					return true
-				}
-			}
-		}
-		return false
+		v, ok = NewClaimPath(key, true).Lookup(claims)
 	}
-
-	roleString, ok := tmp.(string)
 	if !ok {
-		return false
+		return nil, false
 	}
-	roless := strings.Split(roleString, " ")
-
-	for _, role := range required {
-		for _, r := range roless {
-			if r == role {
-				return true
+	switch t := v.(type) {
+	case nil, map[string]interface{}:
+		return nil, false
+	case []interface{}:
+		values := make([]string, 0, len(t))
+		for _, elem := range t {
+			switch elem.(type) {
+			case nil, map[string]interface{}, []interface{}:
+				continue
 			}
+			values = append(values, normalizeClaim(elem))
 		}
+		return values, len(values) > 0
+	default:
+		return []string{normalizeClaim(t)}, true
 	}
-	return false
+}
+
+func CanAccess(roleKey string, claims map[string]interface{}, required []string) bool {
+	return CanAccessPath(NewClaimPath(roleKey, false), claims, required)
 }
 
 func getNestedClaim(nestedKey string, claims map[string]interface{}) (string, map[string]interface{}) {
@@ -176,44 +289,7 @@ func getNestedClaim(nestedKey string, claims map[string]interface{}) (string, ma
 }
 
 func ScopesAllMatcher(scopesKey string, claims map[string]interface{}, requiredScopes []string) bool {
-	if len(requiredScopes) == 0 {
-		return true
-	}
-
-	tmpClaims := claims
-	tmpKey := scopesKey
-
-	if strings.Contains(scopesKey, ".") {
-		tmpKey, tmpClaims = getNestedClaim(scopesKey, claims)
-	}
-
-	tmp, ok := tmpClaims[tmpKey]
-	if !ok {
-		return false
-	}
-	scopeClaim, ok := tmp.(string)
-	if !ok {
-		return false
-	}
-
-	presentScopes := strings.Split(scopeClaim, " ")
-	if len(presentScopes) > 0 {
-		for _, rScope := range requiredScopes {
-			matched := false
-			for _, pScope := range presentScopes {
-				if rScope == pScope {
-					matched = true
-				}
-			}
-			if !matched { // required scope was not found --> immediately return
-				return false
-			}
-		}
-		// all required scopes have been found in provided (claims) scopes
-		return true
-	}
-
-	return false
+	return ScopesAllPathMatcher(NewClaimPath(scopesKey, true), claims, requiredScopes)
 }
 
 func ScopesDefaultMatcher(_ string, _ map[string]interface{}, _ []string) bool {
@@ -221,63 +297,93 @@ func ScopesDefaultMatcher(_ string, _ map[string]interface{}, _ []string) bool {
 }
 
 func ScopesAnyMatcher(scopesKey string, claims map[string]interface{}, requiredScopes []string) bool {
-	if len(requiredScopes) == 0 {
-		return true
-	}
+	return ScopesAnyPathMatcher(NewClaimPath(scopesKey, true), claims, requiredScopes)
+}
 
-	tmpClaims := claims
-	tmpKey := scopesKey
+// MissingScopes returns the required scopes not present in the (space separated) scopes claim
+func MissingScopes(scopesKey string, claims map[string]interface{}, requiredScopes []string) []string {
+	return MissingScopesPath(NewClaimPath(scopesKey, true), claims, requiredScopes)
+}
 
-	if strings.Contains(scopesKey, ".") {
-		tmpKey, tmpClaims = getNestedClaim(scopesKey, claims)
+// SignFields replaces the values under the given keys of the response with the tokens generated by the signer.
+// Keys can be dot separated paths to nested objects. Lists of objects found while walking the path are
+// processed element by element.
+func SignFields(keys []string, signer Signer, response *proxy.Response) error {
+	for _, key := range keys {
+		if _, ok := response.Data[key]; ok || !strings.Contains(key, ".") {
+			if err := signField([]string{key}, signer, response.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := signField(strings.Split(key, "."), signer, response.Data); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	tmp, ok := tmpClaims[tmpKey]
-	if !ok {
-		return false
-	}
-	scopeClaim, ok := tmp.(string)
+func signField(path []string, signer Signer, data map[string]interface{}) error {
+	tmp, ok := data[path[0]]
 	if !ok {
-		return false
+		return nil
 	}
 
-	presentScopes := strings.Split(scopeClaim, " ")
-	if len(presentScopes) > 0 {
-		for _, rScope := range requiredScopes {
-			for _, pScope := range presentScopes {
-				if rScope == pScope {
-					return true // found any of the required scopes --> return
+	if len(path) > 1 {
+		switch v := tmp.(type) {
+		case map[string]interface{}:
+			return signField(path[1:], signer, v)
+		case []interface{}:
+			for _, elem := range v {
+				m, ok := elem.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if err := signField(path[1:], signer, m); err != nil {
+					return err
 				}
 			}
 		}
-		// none of the scopes have been found in provided (claims) scopes
-		return false
+		return nil
 	}
 
-	return false
-}
-
-func SignFields(keys []string, signer Signer, response *proxy.Response) error {
-	for _, key := range keys {
-		tmp, ok := response.Data[key]
-		if !ok {
-			continue
-		}
-		data, ok := tmp.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		token, err := signer(data)
+	switch v := tmp.(type) {
+	case map[string]interface{}:
+		token, err := signer(v)
 		if err != nil {
 			return err
 		}
-		response.Data[key] = token
+		data[path[0]] = token
+	case []interface{}:
+		tokens := make([]interface{}, len(v))
+		for i, elem := range v {
+			if _, ok := elem.(map[string]interface{}); !ok {
+				tokens[i] = elem
+				continue
+			}
+			token, err := signer(elem)
+			if err != nil {
+				return err
+			}
+			tokens[i] = token
+		}
+		data[path[0]] = tokens
 	}
 	return nil
 }
 
 type Claims map[string]interface{}
 
+// numberClaims decodes the numbers of the claims as json.Number, so the large integer IDs keep their
+// precision
+type numberClaims map[string]interface{}
+
+func (c *numberClaims) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode((*map[string]interface{})(c))
+}
+
 const epsilon = 1e-6
 
 func (c Claims) Get(name string) (string, bool) {
@@ -285,84 +391,82 @@ func (c Claims) Get(name string) (string, bool) {
 	if !ok {
 		return "", ok
 	}
+	return normalizeClaim(tmp), ok
+}
+
+// Value returns the claim at the path. Dots in the path access nested claims.
+func (c Claims) Value(path string) (interface{}, bool) {
+	return NewClaimPath(path, true).Lookup(c)
+}
+
+// String returns the claim at the path as a string. Numbers and booleans are formatted and arrays are
+// joined with commas.
+func (c Claims) String(path string) (string, bool) {
+	v, ok := c.Value(path)
+	if !ok {
+		return "", false
+	}
+	return normalizeClaim(v), true
+}
 
-	var normalized string
+// Strings returns the elements of the array claim at the path, or the words of the space separated string
+// claim, as the scope one
+func (c Claims) Strings(path string) []string {
+	v, _ := c.Value(path)
+	return claimValues(v)
+}
 
+// Int64 returns the numeric claim at the path
+func (c Claims) Int64(path string) (int64, bool) {
+	v, ok := c.Value(path)
+	if !ok {
+		return 0, false
+	}
+	return numericClaim(v)
+}
+
+func normalizeClaim(tmp interface{}) string {
 	switch v := tmp.(type) {
 	case string:
-		normalized = v
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
 	case int:
-		normalized = fmt.Sprintf("%d", v)
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
 	case float64:
 		if r := math.Round(v); math.Abs(v-r) <= epsilon {
-			return fmt.Sprintf("%d", int(r)), ok
+			v = r
 		}
-		normalized = fmt.Sprintf("%f", v)
+		return strconv.FormatFloat(v, 'f', -1, 64)
 	case []interface{}:
-		normalized = fmt.Sprintf("%v", v[0])
-		for _, elem := range v[1:] {
-			normalized += fmt.Sprintf(",%v", elem)
+		if len(v) == 0 {
+			return ""
+		}
+		normalized := make([]string, len(v))
+		for i, elem := range v {
+			normalized[i] = normalizeClaim(elem)
 		}
+		return strings.Join(normalized, ",")
 	default:
 		b, _ := json.Marshal(v)
-		normalized = string(b)
+		return string(b)
 	}
-
-	return normalized, ok
 }
 
 func CalculateHeadersToPropagate(propagationCfg [][]string, claims map[string]interface{}) (map[string]string, error) {
 	if len(propagationCfg) == 0 {
 		return nil, fmt.Errorf("JOSE: no headers to propagate. Config size: %d", len(propagationCfg))
 	}
-
-	propagated := make(map[string]string)
-
-	c := Claims(claims)
-
-	for _, triple := range propagationCfg {
-		fromClaim := triple[0]
-		toHeader := triple[1]
-		hashValue := false
-		if len(triple) > 2 {
-			boolValue, err := strconv.ParseBool(triple[2])
-			if err == nil {
-				hashValue = boolValue
-			}
-		}
-
-		if strings.Contains(fromClaim, ".") && (len(fromClaim) < 4 || fromClaim[:4] != "http") {
-			tmpKey, tmpClaims := getNestedClaim(fromClaim, claims)
-
-			tmp, ok := Claims(tmpClaims).Get(tmpKey)
-			if !ok {
-				continue
-			}
-
-			if hashValue {
-				h := sha1.New()
-				h.Write([]byte(tmp))
-				tmp = hex.EncodeToString(h.Sum(nil))
-			}
-			propagated[toHeader] = tmp
-			continue
-		}
-
-		v, ok := c.Get(fromClaim)
-		if !ok {
-			continue
-		}
-		if hashValue {
-			h := sha1.New()
-			h.Write([]byte(v))
-			v = hex.EncodeToString(h.Sum(nil))
-		}
-		propagated[toHeader] = v
-	}
-
-	return propagated, nil
+	return cachedHeadersPropagator(propagationCfg).Propagate(claims), nil
 }
 
+// ErrUnknownAlg is returned when the alg of the config is not a supported signature algorithm
+var ErrUnknownAlg = errors.New("unknown algorithm")
+
 var supportedAlgorithms = map[string]jose.SignatureAlgorithm{
 	"EdDSA": jose.EdDSA,
 	"HS256": jose.HS256,
@@ -2,10 +2,13 @@ package jose
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
 	"testing"
 
+	"github.com/luraproject/lura/v2/proxy"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
@@ -17,7 +20,7 @@ func Test_NewValidator_unkownAlg(t *testing.T) {
 	_, err := NewValidator(&SignatureConfig{
 		Alg: "random",
 	}, nopExtractor)
-	if err == nil || err.Error() != "JOSE: unknown algorithm random" {
+	if err == nil || err.Error() != "JOSE: unknown algorithm random" || !errors.Is(err, ErrUnknownAlg) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
@@ -306,6 +309,51 @@ func TestScopesAnyMatcher(t *testing.T) {
 	}
 }
 
+func TestMissingScopes(t *testing.T) {
+	for _, v := range []struct {
+		name           string
+		scopesKey      string
+		claims         map[string]interface{}
+		requiredScopes []string
+		expected       []string
+	}{
+		{
+			name:           "none_missing",
+			scopesKey:      "scope",
+			claims:         map[string]interface{}{"scope": "a b"},
+			requiredScopes: []string{"a", "b"},
+			expected:       []string{},
+		},
+		{
+			name:           "some_missing",
+			scopesKey:      "scope",
+			claims:         map[string]interface{}{"scope": "a b"},
+			requiredScopes: []string{"a", "c", "d"},
+			expected:       []string{"c", "d"},
+		},
+		{
+			name:           "no_claim",
+			scopesKey:      "scope",
+			claims:         map[string]interface{}{},
+			requiredScopes: []string{"a"},
+			expected:       []string{"a"},
+		},
+		{
+			name:           "nested",
+			scopesKey:      "data.scope",
+			claims:         map[string]interface{}{"data": map[string]interface{}{"scope": "a"}},
+			requiredScopes: []string{"a", "b"},
+			expected:       []string{"b"},
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			if res := MissingScopes(v.scopesKey, v.claims, v.requiredScopes); !reflect.DeepEqual(res, v.expected) {
+				t.Errorf("'%s' have %v, want %v", v.name, res, v.expected)
+			}
+		})
+	}
+}
+
 func TestCalculateHeadersToPropagate(t *testing.T) {
 	for i, tc := range []struct {
 		cfg      [][]string
@@ -357,7 +405,8 @@ func TestUnmarshalDataTypesGetClaim(t *testing.T) {
 		"t7_big_int": 1000001,
 		"t8_float_round": 4.000001,
 		"t9_float_round": 4.0000001,
-		"t10_timestamp": 1651529725
+		"t10_timestamp": 1651529725,
+		"t11_bool": true
 	}`), &c)
 
 	for i, tc := range []struct {
@@ -378,7 +427,7 @@ func TestUnmarshalDataTypesGetClaim(t *testing.T) {
 		},
 		{
 			key:      "t3_float",
-			expected: "-42.420000",
+			expected: "-42.42",
 		},
 		{
 			key:      "t4_string",
@@ -408,6 +457,10 @@ func TestUnmarshalDataTypesGetClaim(t *testing.T) {
 			key:      "t10_timestamp",
 			expected: "1651529725",
 		},
+		{
+			key:      "t11_bool",
+			expected: "true",
+		},
 	} {
 		t.Run(tc.key, func(t *testing.T) {
 			res, ok := c.Get(tc.key)
@@ -417,3 +470,70 @@ func TestUnmarshalDataTypesGetClaim(t *testing.T) {
 		})
 	}
 }
+
+func TestNumberClaims(t *testing.T) {
+	var c numberClaims
+	if err := json.Unmarshal([]byte(`{"id": 9007199254740993, "ratio": 0.1, "ids": [1234567890123456789]}`), &c); err != nil {
+		t.Error(err)
+		return
+	}
+	for key, expected := range map[string]string{
+		"id":    "9007199254740993",
+		"ratio": "0.1",
+		"ids":   "1234567890123456789",
+	} {
+		if v, _ := Claims(c).Get(key); v != expected {
+			t.Errorf("%s: unexpected value: %s", key, v)
+		}
+	}
+}
+
+func TestSignFields(t *testing.T) {
+	signer := func(v interface{}) (string, error) {
+		return fmt.Sprintf("signed(%v)", v.(map[string]interface{})["id"]), nil
+	}
+	response := &proxy.Response{
+		Data: map[string]interface{}{
+			"token": map[string]interface{}{"id": 1},
+			"data": map[string]interface{}{
+				"session": map[string]interface{}{
+					"token_payload": map[string]interface{}{"id": 2},
+				},
+			},
+			"grants": []interface{}{
+				map[string]interface{}{"id": 3},
+				"unsignable",
+				map[string]interface{}{"id": 4},
+			},
+			"users": []interface{}{
+				map[string]interface{}{"token": map[string]interface{}{"id": 5}},
+				map[string]interface{}{"name": "foo"},
+			},
+			"a.b": map[string]interface{}{"id": 6},
+		},
+	}
+
+	err := SignFields([]string{"token", "data.session.token_payload", "grants", "users.token", "a.b", "data.unknown"}, signer, response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"token": "signed(1)",
+		"data": map[string]interface{}{
+			"session": map[string]interface{}{
+				"token_payload": "signed(2)",
+			},
+		},
+		"grants": []interface{}{"signed(3)", "unsignable", "signed(4)"},
+		"users": []interface{}{
+			map[string]interface{}{"token": "signed(5)"},
+			map[string]interface{}{"name": "foo"},
+		},
+		"a.b": "signed(6)",
+	}
+	if !reflect.DeepEqual(expected, response.Data) {
+		t.Errorf("unexpected response: %v", response.Data)
+	}
+}
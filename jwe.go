@@ -0,0 +1,109 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// EncryptionConfig defines the recipient key used for encrypting the signed tokens (nested JWT)
+type EncryptionConfig struct {
+	Alg                string           `json:"alg,omitempty"`
+	Enc                string           `json:"enc,omitempty"`
+	KeyID              string           `json:"kid,omitempty"`
+	URI                string           `json:"jwk_url,omitempty"`
+	LocalPath          string           `json:"jwk_local_path,omitempty"`
+	DisableJWKSecurity bool             `json:"disable_jwk_security,omitempty"`
+	JWK                *jose.JSONWebKey `json:"jwk,omitempty"`
+}
+
+const defaultContentEncryption = jose.A256GCM
+
+var ErrNoKeyManagementAlg = errors.New("no key management algorithm defined for the encryption key")
+
+func newEncrypter(cfg *EncryptionConfig, signerCfg *SignerConfig, te auth0.RequestTokenExtractor) (jose.Encrypter, error) {
+	key := cfg.JWK
+	if key == nil {
+		if cfg.LocalPath == "" && !validJWKSource(cfg.URI, cfg.DisableJWKSecurity) {
+			return nil, ErrInsecureJWKSource
+		}
+		decodedFs, err := DecodeFingerprints(signerCfg.Fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		sp, err := SecretProvider(SecretProviderConfig{
+			URI:           cfg.URI,
+			Cs:            signerCfg.CipherSuites,
+			Fingerprints:  decodedFs,
+			LocalCA:       signerCfg.LocalCA,
+			AllowInsecure: cfg.DisableJWKSecurity,
+			LocalPath:     cfg.LocalPath,
+		}, te)
+		if err != nil {
+			return nil, err
+		}
+		k, err := sp.GetKey(cfg.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		key = &k
+	}
+
+	alg := cfg.Alg
+	if alg == "" {
+		alg = key.Algorithm
+	}
+	if alg == "" {
+		return nil, ErrNoKeyManagementAlg
+	}
+	enc := jose.ContentEncryption(cfg.Enc)
+	if enc == "" {
+		enc = defaultContentEncryption
+	}
+
+	recipientKey := key.Key
+	if !key.IsPublic() {
+		if pub := key.Public(); pub.Key != nil {
+			recipientKey = pub.Key
+		}
+	}
+
+	kid := key.KeyID
+	if cfg.KeyID != "" {
+		kid = cfg.KeyID
+	}
+
+	return jose.NewEncrypter(
+		enc,
+		jose.Recipient{
+			Algorithm: jose.KeyAlgorithm(alg),
+			Key:       recipientKey,
+			KeyID:     kid,
+		},
+		(&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT"),
+	)
+}
+
+type nestedSigner struct {
+	signer    Signer
+	encrypter jose.Encrypter
+	full      bool
+}
+
+// Sign signs the payload and encrypts the resulting compact JWS
+func (n nestedSigner) Sign(v interface{}) (string, error) {
+	jws, err := n.signer(v)
+	if err != nil {
+		return "", err
+	}
+	obj, err := n.encrypter.Encrypt([]byte(jws))
+	if err != nil {
+		return "", fmt.Errorf("unable to encrypt payload: %s", err.Error())
+	}
+	if n.full {
+		return obj.FullSerialize(), nil
+	}
+	return obj.CompactSerialize()
+}
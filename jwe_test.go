@@ -0,0 +1,120 @@
+package jose
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestNewSigner_nested(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	data, err := os.ReadFile("./fixtures/private.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keys, err := NewFileKeyCacher(data, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	signingKey, _ := keys.Get("2011-04-29")
+	encryptionKey, _ := keys.Get("4k512")
+
+	for _, tc := range []struct {
+		name string
+		cfg  map[string]interface{}
+	}{
+		{
+			name: "local",
+			cfg: map[string]interface{}{
+				"alg":            "RSA-OAEP-256",
+				"kid":            "4k512",
+				"jwk_local_path": "./fixtures/public.json",
+			},
+		},
+		{
+			name: "inline",
+			cfg: map[string]interface{}{
+				"alg": "RSA-OAEP",
+				"enc": "A128CBC-HS256",
+				"jwk": encryptionKey.Public(),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+			cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["encrypt"] = tc.cfg
+
+			_, signer, err := NewSigner(cfg, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			token, err := signer(map[string]interface{}{"sub": "1234567890qwertyuio"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			nested, err := jwt.ParseSignedAndEncrypted(token)
+			if err != nil {
+				t.Errorf("parsing the token: %s", err.Error())
+				return
+			}
+			if kid := nested.Headers[0].KeyID; kid != "4k512" {
+				t.Errorf("unexpected kid: %s", kid)
+			}
+			jws, err := nested.Decrypt(encryptionKey.Key)
+			if err != nil {
+				t.Errorf("decrypting the token: %s", err.Error())
+				return
+			}
+			claims := map[string]interface{}{}
+			if err := jws.Claims(signingKey.Public().Key, &claims); err != nil {
+				t.Errorf("verifying the token: %s", err.Error())
+				return
+			}
+			if claims["sub"] != "1234567890qwertyuio" {
+				t.Errorf("unexpected claims: %v", claims)
+			}
+		})
+	}
+}
+
+func TestNewSigner_nestedNoAlg(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["encrypt"] = map[string]interface{}{
+		"kid":            "1",
+		"jwk_local_path": "./fixtures/public.json",
+	}
+
+	if _, _, err := NewSigner(cfg, nil); err != ErrNoKeyManagementAlg {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewSigner_nestedUnsecure(t *testing.T) {
+	server := httptest.NewServer(jwkEndpoint("private"))
+	defer server.Close()
+
+	cfg := newSignerEndpointCfg("RS256", "2011-04-29", server.URL)
+	cfg.ExtraConfig[SignerNamespace].(map[string]interface{})["encrypt"] = map[string]interface{}{
+		"alg":     string(jose.RSA_OAEP),
+		"kid":     "4k512",
+		"jwk_url": "http://jwk.example.com",
+	}
+
+	if _, _, err := NewSigner(cfg, nil); err != ErrInsecureJWKSource {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
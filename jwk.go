@@ -6,11 +6,9 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -37,11 +35,67 @@ type SecretProviderConfig struct {
 	SecretURL           string
 	CipherKey           []byte
 	KeyIdentifyStrategy string
+	// KeyFetchTimeout limits the download of the key set
+	KeyFetchTimeout time.Duration
+	CircuitBreaker  *CircuitBreakerConfig
+	RefreshLimit    *RefreshRateLimitConfig
+	// KeySetFormat is the format of the key set: jwk (the default) or x509
+	KeySetFormat string
+	// KeyUsage drops the keys not meant to verify signatures from the key set
+	KeyUsage *KeyUsageConfig
+	// LocalReloadInterval is the time between the checks of the local path. Zero disables the reload
+	LocalReloadInterval time.Duration
+	// Kubernetes reads the key set from a Kubernetes secret or config map
+	Kubernetes *KubernetesConfig
+	// KVStore reads the key set from a Consul or etcd key
+	KVStore *KVStoreConfig
+	// SharedCache stores the downloaded key set in a cache shared by all the gateway instances
+	SharedCache *SharedCacheConfig
+	// CacheControl uses the max-age of the key set responses as the cache duration
+	CacheControl *CacheControlConfig
+	// CacheDurations overrides the cache duration of the key sets downloaded from the given URIs
+	CacheDurations map[string]time.Duration
+	// CacheMaxKeys limits the number of cached keys, evicting the oldest ones. Zero caches the whole key set
+	CacheMaxKeys int
+	// Pins are the fingerprints accepted, along with the static ones, during their validity windows
+	Pins []Pin
+	// PinExpiryWarning is the time before the expiration of the last valid pin when a warning is logged
+	PinExpiryWarning time.Duration
+	// TLS sets the TLS versions, curves and renegotiation of the connections to the JWK service
+	TLS *TLSConfig
+	// Transport connects to the JWK service through a SOCKS5 proxy (socks5://host:port) or a unix socket
+	// (unix:///path/to/socket) instead of directly
+	Transport string
+	// Retry retries the failed downloads of the key set
+	Retry *RetryConfig
+	// Prefetch downloads the key set while the provider is created, instead of in the background
+	Prefetch bool
+	// PrefetchFailFast fails the creation of the provider when no keys are loaded. It implies Prefetch
+	PrefetchFailFast bool
+	// BackupPath is the file where the last key set fetched is written. It is loaded when the key set can
+	// not be fetched
+	BackupPath string
+	// SignedKeySet verifies the signature of the key sets published as a signed JWT
+	SignedKeySet *SignedKeySetConfig
+	// KeyIteration verifies the tokens without kid with the keys of the key set
+	KeyIteration *KeyIterationConfig
+	// Logger receives the fetches and the cache events. The shared providers keep the logger of the first
+	// config. Defaults to the log package, for the warnings and the errors
+	Logger Logger `json:"-"`
+}
+
+func (cfg SecretProviderConfig) prefetch() bool {
+	return cfg.Prefetch || cfg.PrefetchFailFast
 }
 
 var (
 	ErrInsecureJWKSource = errors.New("JWK client is using an insecure connection to the JWK service")
-	ErrPinnedKeyNotFound = errors.New("JWK client did not find a pinned key")
+	// ErrPinMismatch is returned when the certificate of the JWK service does not match any fingerprint or
+	// pin. The PinMismatchError also matches it
+	ErrPinMismatch = errors.New("JWK client did not find a pinned key")
+	// ErrPinnedKeyNotFound is the former name of ErrPinMismatch
+	ErrPinnedKeyNotFound = ErrPinMismatch
+	ErrPrefetch          = errors.New("JWK client could not load the key set at startup")
 
 	cacheWorkers   = runtime.GOMAXPROCS(-1)
 	cacheSemaphore = make(chan struct{}, cacheWorkers)
@@ -49,25 +103,59 @@ var (
 )
 
 func SecretProvider(cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*JWKClient, error) {
+	client, err := newSecretProvider(cfg, te)
+	if err != nil || !cfg.PrefetchFailFast {
+		return client, err
+	}
+	if h := client.Health(); h.Keys == 0 || (!h.Healthy && !h.Backup) {
+		reason := h.LastError
+		if reason == "" {
+			reason = "the key set has no keys"
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPrefetch, reason)
+	}
+	return client, nil
+}
+
+func newSecretProvider(cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*JWKClient, error) {
 	opts, err := newJWKClientOptions(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.Kubernetes != nil {
+		return newKubernetesSecretProvider(opts, cfg, te)
+	}
+	if cfg.KVStore != nil {
+		return newKVSecretProvider(opts, cfg, te)
+	}
+
 	if !cfg.CacheEnabled {
 		if cfg.LocalPath == "" {
-			return NewJWKClientWithCache(opts, te, NewMemoryKeyCacher(0, 0, opts.KeyIdentifyStrategy)), nil
+			client := NewJWKClientWithCache(opts, te, NewMemoryKeyCacher(0, 0, opts.KeyIdentifyStrategy))
+			if cfg.prefetch() {
+				client.GetKey("unknown")
+			}
+			return client, nil
 		}
 		return newLocalSecretProvider(opts, cfg, te)
 	}
 
 	var cacheDuration time.Duration
 	cacheDuration = time.Duration(cfg.CacheDuration) * time.Second
+	if d, ok := cfg.CacheDurations[cfg.URI]; ok {
+		cacheDuration = d
+	}
 	// Set default duration to 15 minute
 	if cacheDuration == 0 {
 		cacheDuration = 15 * time.Minute
 	}
 
+	cacheSize := auth0.MaxCacheSizeNoCheck
+	if cfg.CacheMaxKeys > 0 {
+		cacheSize = cfg.CacheMaxKeys
+	}
+
 	// init the semaphore
 	cacheOnce.Do(func() {
 		for i := 0; i < cacheWorkers; i++ {
@@ -75,13 +163,15 @@ func SecretProvider(cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*
 		}
 	})
 
-	client := NewJWKClientWithCache(
-		opts,
-		te,
-		NewMemoryKeyCacher(cacheDuration, auth0.MaxCacheSizeNoCheck, opts.KeyIdentifyStrategy),
-	)
+	keyCacher := newMemoryKeyCacher(cacheDuration, cacheSize, opts.KeyIdentifyStrategy, opts.freshness)
+	keyCacher.logger = opts.logger
+	client := NewJWKClientWithCache(opts, te, keyCacher)
 
 	// request an unexistent key in order to cache all the actual ones
+	if cfg.prefetch() {
+		client.GetKey("unknown")
+		return client, nil
+	}
 	<-cacheSemaphore
 	go func() {
 		client.GetKey("unknown")
@@ -92,7 +182,61 @@ func SecretProvider(cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*
 }
 
 func newLocalSecretProvider(opts JWKClientOptions, cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*JWKClient, error) {
-	data, err := os.ReadFile(cfg.LocalPath)
+	data, err := readLocalKeySet(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCacher, err := NewFileKeyCacher(data, opts.KeyIdentifyStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.status != nil {
+		opts.status.fetched(keyCacher.size())
+	}
+	if cfg.LocalReloadInterval > 0 {
+		version, err := localKeySetVersion(cfg.LocalPath)
+		if err != nil {
+			return nil, err
+		}
+		go keyCacher.watch(context.Background(), cfg, version, opts.KeyIdentifyStrategy, opts.status)
+	}
+	return NewJWKClientWithCache(opts, te, keyCacher), nil
+}
+
+// readLocalKeySet returns the JWK set stored in the local path. When the path is a directory, the keys of
+// all its files are merged into one set.
+func readLocalKeySet(cfg SecretProviderConfig) ([]byte, error) {
+	info, err := os.Stat(cfg.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if info.IsDir() {
+		data, err = readKeySetDir(cfg)
+	} else {
+		data, err = readLocalFile(cfg, cfg.LocalPath)
+		switch {
+		case err != nil:
+		case isPEM(data):
+			data, err = pemKeySet(cfg.LocalPath, data)
+		case cfg.KeySetFormat == KeySetFormatX509:
+			data, err = x509KeySet(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeyUsage != nil {
+		return filterKeySet(data, cfg.KeyUsage)
+	}
+	return data, nil
+}
+
+// readLocalFile returns the content of the file, decrypted if the config has a secret URL
+func readLocalFile(cfg SecretProviderConfig, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -103,18 +247,13 @@ func newLocalSecretProvider(opts JWKClientOptions, cfg SecretProviderConfig, te
 		if err != nil {
 			return nil, err
 		}
+		defer sk.Close()
 		data, err = sk.Decrypt(ctx, data, cfg.CipherKey)
 		if err != nil {
 			return nil, err
 		}
-		sk.Close()
 	}
-
-	keyCacher, err := NewFileKeyCacher(data, opts.KeyIdentifyStrategy)
-	if err != nil {
-		return nil, err
-	}
-	return NewJWKClientWithCache(opts, te, keyCacher), nil
+	return data, nil
 }
 
 func NewFileKeyCacher(data []byte, keyIdentifyStrategy string) (*FileKeyCacher, error) {
@@ -122,31 +261,37 @@ func NewFileKeyCacher(data []byte, keyIdentifyStrategy string) (*FileKeyCacher,
 	if err := json.Unmarshal(data, &keys); err != nil {
 		return nil, err
 	}
-	keyMap := map[string]*jose.JSONWebKey{}
-	keyIDGetter := KeyIDGetterFactory(keyIdentifyStrategy)
-	for _, k := range keys.Keys {
-		keyToStore := k
-		keyMap[keyIDGetter.Get(&keyToStore)] = &keyToStore
-	}
+	keyMap := selectKeys(keys.Keys, KeyIDGetterFactory(keyIdentifyStrategy), keyPreferrer(keyIdentifyStrategy))
 	return &FileKeyCacher{keys: keyMap}, nil
 }
 
 type FileKeyCacher struct {
+	mu   sync.RWMutex
 	keys map[string]*jose.JSONWebKey
 }
 
 func (f *FileKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
+	f.mu.RLock()
 	v, ok := f.keys[keyID]
+	f.mu.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("key '%s' not found in the key set", keyID)
+		return nil, &KeyNotFoundError{KeyID: keyID}
 	}
 	return v, nil
 }
 
 func (f *FileKeyCacher) Add(keyID string, _ []jose.JSONWebKey) (*jose.JSONWebKey, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.keys[keyID], nil
 }
 
+func (f *FileKeyCacher) size() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.keys)
+}
+
 func newJWKClientOptions(cfg SecretProviderConfig) (JWKClientOptions, error) {
 	if len(cfg.Cs) == 0 {
 		cfg.Cs = DefaultEnabledCipherSuites
@@ -171,11 +316,22 @@ func newJWKClientOptions(cfg SecretProviderConfig) (JWKClientOptions, error) {
 		InsecureSkipVerify: cfg.AllowInsecure, // skipcq: GSC-G402
 		RootCAs:            rootCAs,
 	}
+	if err := cfg.TLS.apply(tlsConfig); err != nil {
+		return JWKClientOptions{}, err
+	}
+	jwkTransport, err := parseJWKTransport(cfg.Transport)
+	if err != nil {
+		return JWKClientOptions{}, err
+	}
 	dialer := NewDialer(cfg, tlsConfig)
+	proxy := http.ProxyFromEnvironment
+	if jwkTransport != nil {
+		proxy = nil
+	}
 
 	transport := krakendTransport{
 		Transport: &http.Transport{
-			Proxy:                 http.ProxyFromEnvironment,
+			Proxy:                 proxy,
 			DialContext:           dialer.DialContext,
 			MaxIdleConns:          10,
 			IdleConnTimeout:       90 * time.Second,
@@ -185,18 +341,82 @@ func newJWKClientOptions(cfg SecretProviderConfig) (JWKClientOptions, error) {
 		},
 	}
 
-	if len(cfg.Fingerprints) > 0 {
+	if !dialer.pins.empty() {
 		transport.DialTLSContext = dialer.DialTLSContext
 	}
 
+	var cb *CircuitBreaker
+	if cfg.CircuitBreaker != nil {
+		var err error
+		cb, err = NewCircuitBreaker(cfg.CircuitBreaker)
+		if err != nil {
+			return JWKClientOptions{}, err
+		}
+	}
+	status := newProviderStatus(cfg.URI, cb)
+	status.logger = loggerOrDefault(cfg.Logger)
+
+	var freshness *keySetFreshness
+	if cfg.CacheControl != nil {
+		freshness = newKeySetFreshness(cfg.CacheControl)
+	}
+	var rt http.RoundTripper = transport
+	if cfg.Retry != nil {
+		retry, err := newRetryTransport(rt, cfg.Retry)
+		if err != nil {
+			return JWKClientOptions{}, err
+		}
+		rt = retry
+	}
+	rt = &conditionalTransport{next: rt, freshness: freshness}
+	if cfg.SharedCache != nil {
+		var err error
+		rt, err = newSharedCacheTransport(rt, cfg.SharedCache, cfg.KeyFetchTimeout)
+		if err != nil {
+			return JWKClientOptions{}, err
+		}
+	}
+	if cfg.SignedKeySet != nil {
+		verifier, err := newSignedKeySetVerifier(cfg.SignedKeySet)
+		if err != nil {
+			return JWKClientOptions{}, err
+		}
+		rt = signedKeySetTransport{next: rt, verifier: verifier}
+	}
+	if cfg.KeySetFormat == KeySetFormatX509 {
+		rt = x509KeySetTransport{next: rt}
+	}
+	if cfg.KeyUsage != nil {
+		rt = keyUsageTransport{next: rt, cfg: cfg.KeyUsage}
+	}
+	rt = statusTransport{next: rt, status: status}
+	if cb != nil {
+		rt = &breakerTransport{next: rt, cb: cb}
+	}
+	if cfg.BackupPath != "" {
+		rt = &backupTransport{next: rt, path: cfg.BackupPath, status: status}
+	}
+	if cfg.RefreshLimit != nil {
+		limited, err := newRateLimitTransport(rt, cfg.RefreshLimit)
+		if err != nil {
+			return JWKClientOptions{}, err
+		}
+		rt = limited
+	}
+
 	return JWKClientOptions{
 		JWKClientOptions: auth0.JWKClientOptions{
 			URI: cfg.URI,
 			Client: &http.Client{
-				Transport: transport,
+				Transport: rt,
+				Timeout:   cfg.KeyFetchTimeout,
 			},
 		},
 		KeyIdentifyStrategy: cfg.KeyIdentifyStrategy,
+		status:              status,
+		freshness:           freshness,
+		keyIteration:        cfg.KeyIteration,
+		logger:              status.logger,
 	}, nil
 }
 
@@ -206,13 +426,18 @@ type krakendTransport struct {
 
 func (k krakendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", core.KrakendUserAgent)
-	return k.Transport.RoundTrip(req)
+	start := time.Now()
+	resp, err := k.Transport.RoundTrip(req)
+	DefaultMetrics.JWKSFetch(req.URL.Host, time.Since(start), err != nil || resp.StatusCode >= http.StatusBadRequest)
+	return resp, err
 }
 
+// DecodeFingerprints decodes the pinned SubjectPublicKeyInfo fingerprints. See decodePin for the notations
+// supported.
 func DecodeFingerprints(in []string) ([][]byte, error) {
 	out := make([][]byte, len(in))
 	for i, f := range in {
-		r, err := base64.URLEncoding.DecodeString(f)
+		r, err := decodePin(f)
 		if err != nil {
 			return out, fmt.Errorf("decoding fingerprint #%d: %s", i, err.Error())
 		}
@@ -221,47 +446,78 @@ func DecodeFingerprints(in []string) ([][]byte, error) {
 	return out, nil
 }
 
+// NewDialer returns the Dialer of the JWK client. An invalid transport is ignored, so the config must be
+// checked with newJWKClientOptions.
 func NewDialer(cfg SecretProviderConfig, tlsConfig *tls.Config) *Dialer {
+	netDialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	transport, _ := parseJWKTransport(cfg.Transport)
 	return &Dialer{
 		dialer: &tls.Dialer{
-			NetDialer: &net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			},
-			Config: tlsConfig,
+			NetDialer: netDialer,
+			Config:    tlsConfig,
 		},
-		fingerprints: cfg.Fingerprints,
+		dial: newTransportDial(transport, netDialer),
+		pins: newPinSet(cfg),
 	}
 }
 
 type Dialer struct {
-	dialer       *tls.Dialer
-	fingerprints [][]byte
+	dialer *tls.Dialer
+	dial   dialFunc
+	pins   *pinSet
+}
+
+// PinMismatchError is returned when the certificate of the JWK service at Addr does not match any
+// fingerprint or pin. It matches ErrPinMismatch with errors.Is.
+type PinMismatchError struct {
+	Addr string
+}
+
+func (e *PinMismatchError) Error() string {
+	return ErrPinMismatch.Error()
+}
+
+// Is reports whether the target is ErrPinMismatch
+func (e *PinMismatchError) Is(target error) bool {
+	return target == ErrPinMismatch
 }
 
 func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	return d.dialer.NetDialer.DialContext(ctx, network, address)
+	return d.dial(ctx, network, address)
 }
 
 func (d *Dialer) DialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	conn, err := d.dialer.DialContext(ctx, network, addr)
+	conn, err := d.dial(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
-	c, ok := conn.(*tls.Conn)
-	if !ok {
-		return conn, errors.New("wrong connection type")
+	tlsConfig := d.dialer.Config.Clone()
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		tlsConfig.ServerName = host
+	}
+	c := tls.Client(conn, tlsConfig)
+	if err := c.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
 	}
 	connstate := c.ConnectionState()
+	fingerprints := d.pins.valid(addr)
 	keyPinValid := false
 	for _, peercert := range connstate.PeerCertificates {
 		der, err := x509.MarshalPKIXPublicKey(peercert.PublicKey)
-		hash := sha256.Sum256(der)
 		if err != nil {
-			log.Fatal(err)
+			continue
 		}
-		for _, fingerprint := range d.fingerprints {
+		hash := sha256.Sum256(der)
+		for _, fingerprint := range fingerprints {
 			if bytes.Equal(hash[0:], fingerprint) {
 				keyPinValid = true
 				break
@@ -269,7 +525,8 @@ func (d *Dialer) DialTLSContext(ctx context.Context, network, addr string) (net.
 		}
 	}
 	if !keyPinValid {
-		return nil, ErrPinnedKeyNotFound
+		c.Close()
+		return nil, &PinMismatchError{Addr: addr}
 	}
 	return c, nil
 }
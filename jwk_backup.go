@@ -0,0 +1,113 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// backupTransport writes the last key set fetched into a file and serves it when the key set can not be
+// fetched, so the gateway can restart while the JWK service is down. The fallback is used on connection
+// errors, 429 and 5xx responses.
+type backupTransport struct {
+	next   http.RoundTripper
+	path   string
+	status *providerStatus
+
+	mu   sync.Mutex
+	last []byte
+}
+
+func (t *backupTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.save(body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	if err == nil && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+		return resp, nil
+	}
+
+	body, keys := t.load()
+	if keys == 0 {
+		return resp, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	t.status.restored(keys)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// save replaces the backup with the key set, if it changed
+func (t *backupTransport) save(body []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bytes.Equal(body, t.last) {
+		return
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err != nil || len(keySet.Keys) == 0 {
+		return
+	}
+
+	if err := writeFileAtomic(t.path, body); err != nil {
+		return
+	}
+	t.last = body
+}
+
+// load returns the content of the backup and its number of keys
+func (t *backupTransport) load() ([]byte, int) {
+	body, err := os.ReadFile(t.path)
+	if err != nil {
+		return nil, 0
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		return nil, 0
+	}
+	return body, len(keySet.Keys)
+}
+
+// writeFileAtomic replaces the content of the file, so a crash does not leave a partial file behind. The file
+// is only readable by its owner.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSecretProvider_backup(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var down int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	backup := filepath.Join(t.TempDir(), "jwks.json")
+	cfg := SecretProviderConfig{URI: server.URL, AllowInsecure: true, BackupPath: backup, PrefetchFailFast: true}
+	if _, err := SecretProvider(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(backup); err != nil || string(b) != string(data) {
+		t.Fatalf("unexpected backup: %s %v", b, err)
+	}
+
+	// the gateway restarts while the JWK service is down
+	atomic.StoreInt32(&down, 1)
+	sp, err := SecretProvider(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := sp.Health(); h.Healthy || !h.Backup || h.Keys == 0 {
+		t.Errorf("unexpected health: %+v", h)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Error(err)
+	}
+
+	cfg.BackupPath = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := SecretProvider(cfg, nil); !errors.Is(err, ErrPrefetch) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package jose
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheControlMinDuration = time.Minute
+	defaultCacheControlMaxDuration = 24 * time.Hour
+)
+
+// CacheControlConfig uses the max-age of the Cache-Control header of the JWK service as the cache duration
+// of the downloaded keys, bounded by the config. The responses without max-age use the cache_duration.
+type CacheControlConfig struct {
+	// MinDuration is the min cache duration, in seconds or as "1m". Defaults to 1m
+	MinDuration Seconds `json:"min_duration,omitempty"`
+	// MaxDuration is the max cache duration, in seconds or as "24h". Defaults to 24h
+	MaxDuration Seconds `json:"max_duration,omitempty"`
+}
+
+// keySetFreshness keeps the max-age of the last key set downloaded, bounded by the config
+type keySetFreshness struct {
+	mu     sync.Mutex
+	maxAge time.Duration
+	ok     bool
+	min    time.Duration
+	max    time.Duration
+}
+
+func newKeySetFreshness(cfg *CacheControlConfig) *keySetFreshness {
+	f := &keySetFreshness{min: cfg.MinDuration.Duration(), max: cfg.MaxDuration.Duration()}
+	if f.min == 0 {
+		f.min = defaultCacheControlMinDuration
+	}
+	if f.max == 0 {
+		f.max = defaultCacheControlMaxDuration
+	}
+	return f
+}
+
+func (f *keySetFreshness) update(h http.Header) {
+	if f == nil {
+		return
+	}
+	maxAge, ok := parseMaxAge(h)
+	if ok {
+		if maxAge < f.min {
+			maxAge = f.min
+		}
+		if maxAge > f.max {
+			maxAge = f.max
+		}
+	}
+	f.mu.Lock()
+	f.maxAge, f.ok = maxAge, ok
+	f.mu.Unlock()
+}
+
+// get returns the cache duration of the last key set downloaded, if the response had a max-age
+func (f *keySetFreshness) get() (time.Duration, bool) {
+	if f == nil {
+		return 0, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxAge, f.ok
+}
+
+// parseMaxAge returns the time the response is fresh, according to its Cache-Control and Age headers. The
+// no-cache and no-store directives make it stale.
+func parseMaxAge(h http.Header) (time.Duration, bool) {
+	var maxAge time.Duration
+	var found bool
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.ParseInt(strings.Trim(directive[len("max-age="):], `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			maxAge, found = time.Duration(seconds)*time.Second, true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		maxAge -= time.Duration(age) * time.Second
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	return maxAge, true
+}
+
+// conditionalTransport sends the validators of the last key set downloaded, as If-None-Match and
+// If-Modified-Since, and replaces the 304 responses with the last key set, so the unchanged key sets are
+// not downloaded again. It also records the max-age of the responses.
+type conditionalTransport struct {
+	next      http.RoundTripper
+	freshness *keySetFreshness
+
+	mu           sync.Mutex
+	url          string
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := req.URL.String()
+	t.mu.Lock()
+	etag, lastModified := t.etag, t.lastModified
+	if t.url != u {
+		etag, lastModified = "", ""
+	}
+	t.mu.Unlock()
+
+	if etag != "" || lastModified != "" {
+		req = req.Clone(req.Context())
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		t.mu.Lock()
+		header, body := t.header.Clone(), t.body
+		t.mu.Unlock()
+		if body == nil {
+			return resp, nil
+		}
+		for _, name := range []string{"Cache-Control", "Age", "Expires", "Etag", "Last-Modified"} {
+			if v := resp.Header.Get(name); v != "" {
+				header.Set(name, v)
+			}
+		}
+		t.freshness.update(header)
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Header = header
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		return resp, nil
+
+	case http.StatusOK:
+		t.freshness.update(resp.Header)
+		etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag == "" && lastModified == "" {
+			return resp, nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.url, t.etag, t.lastModified = u, etag, lastModified
+		t.header, t.body = resp.Header.Clone(), body
+		t.mu.Unlock()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	return resp, nil
+}
@@ -0,0 +1,156 @@
+package jose
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestSecretProvider_conditionalRequests(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloads, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := sp.GetKey("2011-04-29"); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+	if downloads != 1 || notModified != 2 {
+		t.Errorf("unexpected requests: %d downloads, %d not modified", downloads, notModified)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		age          string
+		expected     time.Duration
+		ok           bool
+	}{
+		{cacheControl: "public, max-age=3600", expected: time.Hour, ok: true},
+		{cacheControl: "max-age=3600", age: "600", expected: 50 * time.Minute, ok: true},
+		{cacheControl: "max-age=60", age: "600", ok: true},
+		{cacheControl: "no-store", ok: true},
+		{cacheControl: "public"},
+		{cacheControl: "max-age=abc"},
+	} {
+		h := http.Header{"Cache-Control": {tc.cacheControl}}
+		if tc.age != "" {
+			h.Set("Age", tc.age)
+		}
+		d, ok := parseMaxAge(h)
+		if d != tc.expected || ok != tc.ok {
+			t.Errorf("%q: unexpected max-age %v %v", tc.cacheControl, d, ok)
+		}
+	}
+}
+
+func TestMemoryKeyCacher_cacheControl(t *testing.T) {
+	freshness := newKeySetFreshness(&CacheControlConfig{MinDuration: 60, MaxDuration: 90})
+	kc := newMemoryKeyCacher(15*time.Minute, -1, "", freshness)
+	keys := []jose.JSONWebKey{{KeyID: "k1", Key: []byte("secret")}}
+
+	for _, tc := range []struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		{cacheControl: "max-age=3600", expected: 90 * time.Second},
+		{cacheControl: "no-cache", expected: time.Minute},
+		{cacheControl: "", expected: 15 * time.Minute},
+	} {
+		freshness.update(http.Header{"Cache-Control": {tc.cacheControl}})
+		if _, err := kc.Add("k1", keys); err != nil {
+			t.Fatal(err)
+		}
+		if d := kc.entries["k1"].maxAge; d != tc.expected {
+			t.Errorf("%q: unexpected max age %v", tc.cacheControl, d)
+		}
+	}
+}
+
+func TestMemoryKeyCacher_maxKeys(t *testing.T) {
+	m := NewMetrics()
+	kc := newMemoryKeyCacher(time.Millisecond, 2, "", nil)
+	kc.metrics = m
+	keys := []jose.JSONWebKey{
+		{KeyID: "k1", Key: []byte("secret")},
+		{KeyID: "k2", Key: []byte("secret")},
+		{KeyID: "k3", Key: []byte("secret")},
+	}
+	for _, kid := range []string{"k1", "k2", "k3"} {
+		if _, err := kc.Add(kid, keys); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Microsecond)
+	}
+	if len(kc.entries) != 2 {
+		t.Errorf("unexpected number of cached keys: %d", len(kc.entries))
+	}
+	if _, ok := kc.entries["k1"]; ok {
+		t.Error("the oldest key should have been evicted")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := kc.Get("k3"); err != ErrKeyExpired {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v := m.evictions.get(KeyEvictionOverflow); v != 1 {
+		t.Errorf("unexpected overflow evictions: %d", v)
+	}
+	if v := m.evictions.get(KeyEvictionExpired); v != 1 {
+		t.Errorf("unexpected expired evictions: %d", v)
+	}
+}
+
+func TestNewSecretProviderConfig_cacheDurations(t *testing.T) {
+	cfg, err := newSecretProviderConfig(&SignatureConfig{
+		URI:          "https://example.com/jwks",
+		CacheEnabled: true,
+		CacheDurations: map[string]Seconds{
+			"https://example.com/jwks": 3600,
+		},
+		CacheMaxKeys: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cfg.CacheDurations["https://example.com/jwks"]; d != time.Hour {
+		t.Errorf("unexpected cache duration: %v", d)
+	}
+	if cfg.CacheMaxKeys != 10 {
+		t.Errorf("unexpected max keys: %d", cfg.CacheMaxKeys)
+	}
+
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:            "RS256",
+		URI:            "https://example.com/jwks",
+		CacheDurations: map[string]Seconds{"https://example.com/jwks": 3600},
+		CacheMaxKeys:   -1,
+	})
+	if len(errs) != 2 || errs[0].(*ConfigError).Code != ConfigErrInvalidCacheDurations || errs[1].(*ConfigError).Code != ConfigErrInvalidCacheMaxKeys {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -1,9 +1,12 @@
 package jose
 
 import (
+	"context"
 	"net/http"
+	"sync"
 
 	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
@@ -40,42 +43,70 @@ func CompoundX5TTokenKeyIDGetter(token *jwt.JSONWebToken) string {
 	return token.Headers[0].KeyID + X5TTokenKeyIDGetter(token)
 }
 
-// TokenIDGetterFactory returns the TokenIDGetter from the keyIdentifyStrategy configuration string
-func TokenIDGetterFactory(keyIdentifyStrategy string) TokenIDGetter {
-	supportedKeyIdentifyStrategy := map[string]TokenKeyIDGetterFunc{
-		"kid":     DefaultTokenKeyIDGetter,
-		"x5t":     X5TTokenKeyIDGetter,
-		"kid_x5t": CompoundX5TTokenKeyIDGetter,
+// X5TS256TokenKeyIDGetter extracts the key id from the jSONWebToken as the x5t#S256
+func X5TS256TokenKeyIDGetter(token *jwt.JSONWebToken) string {
+	x5t, ok := token.Headers[0].ExtraHeaders["x5t#S256"].(string)
+	if !ok {
+		return token.Headers[0].KeyID
 	}
+	return x5t
+}
 
-	if tokenGetter, ok := supportedKeyIdentifyStrategy[keyIdentifyStrategy]; ok {
-		return tokenGetter
-	}
-	return TokenKeyIDGetterFunc(DefaultTokenKeyIDGetter)
+// TokenIDGetterFactory returns the TokenIDGetter from the keyIdentifyStrategy configuration string
+func TokenIDGetterFactory(keyIdentifyStrategy string) TokenIDGetter {
+	return TokenKeyIDGetterFunc(lookupKeyStrategy(keyIdentifyStrategy).TokenKeyID)
 }
 
 type JWKClientOptions struct {
 	auth0.JWKClientOptions
 	KeyIdentifyStrategy string
+	status              *providerStatus
+	freshness           *keySetFreshness
+	keyIteration        *KeyIterationConfig
+	logger              Logger
 }
 
 type JWKClient struct {
 	*auth0.JWKClient
 	extractor     auth0.RequestTokenExtractor
 	tokenIDGetter TokenIDGetter
+	cache         auth0.KeyCacher
+	lookups       *lookupKeyCacher
+	lookupMu      sync.Mutex
+	status        *providerStatus
+	iteration     *KeyIterationConfig
 }
 
 // NewJWKClientWithCache creates a new JWKClient instance from the provided options and custom extractor and keycacher.
 // Passing nil to keyCacher will create a persistent key cacher.
 // the extractor is also saved in the extended JWKClient.
 func NewJWKClientWithCache(options JWKClientOptions, extractor auth0.RequestTokenExtractor, keyCacher auth0.KeyCacher) *JWKClient {
+	cache := keyCacher
+	status := options.status
+	if status == nil {
+		status = newProviderStatus(options.URI, nil)
+	}
+	var lookups *lookupKeyCacher
+	if keyCacher != nil {
+		lookups = &lookupKeyCacher{KeyCacher: metricsKeyCacher{KeyCacher: keyCacher, metrics: DefaultMetrics}}
+		keyCacher = lookups
+	}
 	return &JWKClient{
 		JWKClient:     auth0.NewJWKClientWithCache(options.JWKClientOptions, extractor, keyCacher),
 		extractor:     extractor,
 		tokenIDGetter: TokenIDGetterFactory(options.KeyIdentifyStrategy),
+		cache:         cache,
+		lookups:       lookups,
+		status:        status,
+		iteration:     options.keyIteration,
 	}
 }
 
+// Health implements the HealthChecker interface
+func (j *JWKClient) Health() ProviderHealth {
+	return j.status.health()
+}
+
 // GetSecret implements the GetSecret method of the SecretProvider interface.
 func (j *JWKClient) GetSecret(r *http.Request) (interface{}, error) {
 	token, err := j.extractor.Extract(r)
@@ -87,5 +118,86 @@ func (j *JWKClient) GetSecret(r *http.Request) (interface{}, error) {
 		return nil, auth0.ErrNoJWTHeaders
 	}
 	keyID := j.tokenIDGetter.Get(token)
-	return j.GetKey(keyID)
+
+	_, span := StartSpan(r.Context(), SpanKeyFetch)
+	defer span.End()
+	span.SetAttributes(Attribute{AttributeKeyID, keyID})
+	if j.status.uri != "" {
+		span.SetAttributes(Attribute{AttributeHTTPURL, j.status.uri})
+	}
+
+	var key jose.JSONWebKey
+	if keyID == "" && j.iteration != nil {
+		key, err = j.iterateKeys(r.Context(), token)
+	} else {
+		var hit bool
+		key, hit, err = j.getKey(r.Context(), keyID)
+		if j.lookups != nil {
+			span.SetAttributes(Attribute{AttributeCacheHit, hit})
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	return key, err
+}
+
+// GetKey returns the key associated with the provided ID
+func (j *JWKClient) GetKey(keyID string) (jose.JSONWebKey, error) {
+	key, _, err := j.lookupKey(keyID)
+	return key, err
+}
+
+// lookupKey returns the key and whether it was in the cache. The auth0 client looks the key up under its
+// own lock, so the lookups are serialized here as well in order to read the hit of this very lookup.
+func (j *JWKClient) lookupKey(keyID string) (jose.JSONWebKey, bool, error) {
+	if j.lookups == nil {
+		key, err := j.JWKClient.GetKey(keyID)
+		return key, false, err
+	}
+	j.lookupMu.Lock()
+	defer j.lookupMu.Unlock()
+	j.lookups.hit = false
+	key, err := j.JWKClient.GetKey(keyID)
+	return key, j.lookups.hit, err
+}
+
+// getKey returns the key and whether it was in the cache, giving up when the context is done. The key set
+// download can not be cancelled, but it is bounded by the timeout of the HTTP client, and the request is
+// released as soon as its context is done.
+func (j *JWKClient) getKey(ctx context.Context, keyID string) (jose.JSONWebKey, bool, error) {
+	if ctx.Done() == nil {
+		return j.lookupKey(keyID)
+	}
+
+	type result struct {
+		key jose.JSONWebKey
+		hit bool
+		err error
+	}
+	out := make(chan result, 1)
+	go func() {
+		key, hit, err := j.lookupKey(keyID)
+		out <- result{key, hit, err}
+	}()
+
+	select {
+	case res := <-out:
+		return res.key, res.hit, res.err
+	case <-ctx.Done():
+		return jose.JSONWebKey{}, false, ctx.Err()
+	}
+}
+
+// lookupKeyCacher records whether the last lookup of the wrapped KeyCacher was a hit. It is only read and
+// written while the JWKClient holds its lookup lock.
+type lookupKeyCacher struct {
+	auth0.KeyCacher
+	hit bool
+}
+
+func (l *lookupKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
+	k, err := l.KeyCacher.Get(keyID)
+	l.hit = err == nil
+	return k, err
 }
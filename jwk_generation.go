@@ -0,0 +1,168 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// The key types accepted by GenerateKey
+const (
+	KeyTypeRSA2048 = "RSA-2048"
+	KeyTypeRSA3072 = "RSA-3072"
+	KeyTypeRSA4096 = "RSA-4096"
+	KeyTypeP256    = "P-256"
+	KeyTypeP384    = "P-384"
+	KeyTypeP521    = "P-521"
+	KeyTypeEd25519 = "Ed25519"
+	KeyTypeX25519  = "X25519"
+)
+
+var ErrUnsupportedKeyType = errors.New("unsupported key type")
+
+// X25519PrivateKey is a private key for the X25519 key agreement. It is not supported by the JOSE library,
+// so it is only generated to be exported.
+type X25519PrivateKey []byte
+
+// X25519PublicKey is the public part of a X25519PrivateKey
+type X25519PublicKey []byte
+
+// Public returns the public key
+func (k X25519PrivateKey) Public() crypto.PublicKey {
+	pub, _ := curve25519.X25519(k, curve25519.Basepoint)
+	return X25519PublicKey(pub)
+}
+
+// KeyTypeForAlg returns the key type generated by default for a JWS algorithm
+func KeyTypeForAlg(alg string) (string, error) {
+	switch alg {
+	case "RS256", "PS256":
+		return KeyTypeRSA2048, nil
+	case "RS384", "PS384":
+		return KeyTypeRSA3072, nil
+	case "RS512", "PS512":
+		return KeyTypeRSA4096, nil
+	case "ES256":
+		return KeyTypeP256, nil
+	case "ES384":
+		return KeyTypeP384, nil
+	case "ES512":
+		return KeyTypeP521, nil
+	case "EdDSA":
+		return KeyTypeEd25519, nil
+	}
+	return "", fmt.Errorf("%w: no key type for the alg %q", ErrUnsupportedKeyType, alg)
+}
+
+// GenerateKey returns a new private key of the key type
+func GenerateKey(keyType string) (crypto.PrivateKey, error) {
+	switch keyType {
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case KeyTypeEd25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	case KeyTypeX25519:
+		k := make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(k); err != nil {
+			return nil, err
+		}
+		return X25519PrivateKey(k), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedKeyType, keyType)
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key, base64url encoded, as used for the kid of the
+// generated keys
+func Thumbprint(key interface{}) (string, error) {
+	var (
+		sum []byte
+		err error
+	)
+	switch k := key.(type) {
+	case X25519PrivateKey:
+		sum = x25519Thumbprint(k.Public().(X25519PublicKey))
+	case X25519PublicKey:
+		sum = x25519Thumbprint(k)
+	default:
+		public := jose.JSONWebKey{Key: key}
+		public = public.Public()
+		if !public.Valid() {
+			return "", fmt.Errorf("%w: %T", ErrUnsupportedKeyType, key)
+		}
+		sum, err = public.Thumbprint(crypto.SHA256)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
+
+func x25519Thumbprint(k X25519PublicKey) []byte {
+	// the members of the RFC 7638 input are sorted and without spaces
+	input := fmt.Sprintf(`{"crv":"X25519","kty":"OKP","x":"%s"}`, base64.RawURLEncoding.EncodeToString(k))
+	sum := sha256.Sum256([]byte(input))
+	return sum[:]
+}
+
+// GenerateJWK returns a new private key as a JWK. The key type defaults to the one of the alg, and the kid
+// is the kid prefix followed by the thumbprint of the key.
+func GenerateJWK(keyType, alg, use, kidPrefix string) ([]byte, error) {
+	if keyType == "" {
+		kt, err := KeyTypeForAlg(alg)
+		if err != nil {
+			return nil, err
+		}
+		keyType = kt
+	}
+	key, err := GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := Thumbprint(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := key.(X25519PrivateKey); ok {
+		return json.Marshal(struct {
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			D   string `json:"d"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg,omitempty"`
+			Use string `json:"use,omitempty"`
+		}{
+			Kty: "OKP",
+			Crv: KeyTypeX25519,
+			X:   base64.RawURLEncoding.EncodeToString(k.Public().(X25519PublicKey)),
+			D:   base64.RawURLEncoding.EncodeToString(k),
+			Kid: kidPrefix + thumbprint,
+			Alg: alg,
+			Use: use,
+		})
+	}
+	return jose.JSONWebKey{Key: key, KeyID: kidPrefix + thumbprint, Algorithm: alg, Use: use}.MarshalJSON()
+}
@@ -0,0 +1,64 @@
+package jose
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestThumbprint(t *testing.T) {
+	// RFC 8037, appendix A.3
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	thumbprint, err := Thumbprint(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("unexpected thumbprint %s", thumbprint)
+	}
+}
+
+func TestGenerateJWK(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS256", "ES256", "ES384", "ES512", "EdDSA"} {
+		data, err := GenerateJWK("", alg, "sig", "gw-")
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+		var k jose.JSONWebKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+		thumbprint, _ := Thumbprint(k.Key)
+		if k.IsPublic() || k.Algorithm != alg || k.Use != "sig" || k.KeyID != "gw-"+thumbprint {
+			t.Errorf("%s: unexpected key %s", alg, data)
+		}
+	}
+
+	data, err := GenerateJWK(KeyTypeX25519, "ECDH-ES", "enc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var k map[string]string
+	if err := json.Unmarshal(data, &k); err != nil {
+		t.Fatal(err)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(k["x"])
+	d, _ := base64.RawURLEncoding.DecodeString(k["d"])
+	thumbprint, _ := Thumbprint(X25519PublicKey(x))
+	if k["kty"] != "OKP" || k["crv"] != "X25519" || len(x) != 32 || string(X25519PrivateKey(d).Public().(X25519PublicKey)) != string(x) || k["kid"] != thumbprint {
+		t.Errorf("unexpected key %s", data)
+	}
+
+	if _, err := GenerateJWK("", "HS256", "sig", ""); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := GenerateKey("RSA-1024"); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
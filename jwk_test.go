@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/DKolibar/krakend-jose/v2/secrets"
+	"github.com/auth0-community/go-auth0"
 	"github.com/luraproject/lura/v2/core"
 )
 
@@ -344,6 +346,8 @@ func TestNewFileKeyCacher_unknownKey(t *testing.T) {
 		t.Error("error expected")
 	} else if e := err.Error(); e != "key 'unknown' not found in the key set" {
 		t.Error("unexpected error:", e)
+	} else if !errors.Is(err, ErrKeyNotFound) || !errors.Is(err, auth0.ErrNoKeyFound) {
+		t.Error("the error must match the key not found errors:", e)
 	}
 	if v != nil {
 		t.Error("nil value expected")
@@ -378,3 +382,46 @@ func jwkEndpointWithCounter(name string, hits *uint32) http.HandlerFunc {
 		atomic.AddUint32(hits, 1)
 	}
 }
+
+func TestSecretProvider_prefetch(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch r.URL.Path {
+		case "/jwks":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+		case "/empty":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"keys":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL + "/jwks", AllowInsecure: true, CacheEnabled: true, Prefetch: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&hits); n != 1 {
+		t.Errorf("the key set has not been prefetched: %d requests", n)
+	}
+	if h := sp.Health(); h.Keys == 0 {
+		t.Errorf("unexpected health: %+v", h)
+	}
+
+	for _, path := range []string{"/empty", "/missing"} {
+		_, err := SecretProvider(SecretProviderConfig{URI: server.URL + path, AllowInsecure: true, CacheEnabled: true, PrefetchFailFast: true}, nil)
+		if !errors.Is(err, ErrPrefetch) {
+			t.Errorf("%s: unexpected error %v", path, err)
+		}
+	}
+	if _, err := SecretProvider(SecretProviderConfig{URI: server.URL + "/jwks", AllowInsecure: true, PrefetchFailFast: true}, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package jose
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidTransport is returned when the transport of the JWK client is not a socks5:// or unix:// URL
+var ErrInvalidTransport = errors.New("invalid JWK transport")
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// parseJWKTransport parses the transport of the JWK client: socks5://[user:password@]host:port, to connect
+// through a SOCKS5 proxy, or unix:///path/to/socket, to connect to a sidecar listening on a unix socket.
+func parseJWKTransport(transport string) (*url.URL, error) {
+	if transport == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(transport)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTransport, err.Error())
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("%w: the SOCKS5 proxy %q has no port", ErrInvalidTransport, u.Host)
+		}
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("%w: the unix socket has no path", ErrInvalidTransport)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown scheme %q. Supported values: socks5, unix", ErrInvalidTransport, u.Scheme)
+	}
+	return u, nil
+}
+
+// newTransportDial returns the function connecting to the JWK service through the transport. Without
+// transport, it connects to the service directly.
+func newTransportDial(u *url.URL, d *net.Dialer) dialFunc {
+	if u == nil {
+		return d.DialContext
+	}
+	if u.Scheme == "unix" {
+		return func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", u.Path)
+		}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+		if err := socks5Connect(conn, u.User, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// socks5Connect asks the SOCKS5 proxy at the other end of the conn to connect to the addr. The host names
+// are resolved by the proxy.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+
+	method := byte(0x00)
+	if user != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return errors.New("socks5: the proxy does not accept the authentication method")
+	}
+	if method == 0x02 {
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("socks5: the username or the password are too long")
+		}
+		req := []byte{0x01, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5: the host name %q is too long", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks5: the proxy failed to connect to %s: code %d", addr, header[1])
+	}
+	var bound int
+	switch header[3] {
+	case 0x01:
+		bound = net.IPv4len
+	case 0x04:
+		bound = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		bound = int(l[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, bound+2))
+	return err
+}
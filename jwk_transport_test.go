@@ -0,0 +1,195 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// socks5Server is a SOCKS5 proxy accepting the user:secret credentials. It counts the connections proxied.
+func socks5Server(t *testing.T) (string, *int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var connections int64
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				atomic.AddInt64(&connections, 1)
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x03, 0x09, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 0})
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String(), &connections
+}
+
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[2] != 0x02 {
+		conn.Write([]byte{0x05, 0xff})
+		return "", errors.New("no credentials")
+	}
+	conn.Write([]byte{0x05, 0x02})
+	auth := make([]byte, 2)
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return "", err
+	}
+	user := make([]byte, auth[1]+1)
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", err
+	}
+	password := make([]byte, user[len(user)-1])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", err
+	}
+	if string(user[:len(user)-1]) != "user" || string(password) != "secret" {
+		conn.Write([]byte{0x01, 0x01})
+		return "", errors.New("wrong credentials")
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func jwksHandler(t *testing.T) http.Handler {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+func TestSecretProvider_socks5(t *testing.T) {
+	server := httptest.NewTLSServer(jwksHandler(t))
+	defer server.Close()
+	proxy, connections := socks5Server(t)
+
+	der, err := x509.MarshalPKIXPublicKey(server.Certificate().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(der)
+
+	for i, cfg := range []SecretProviderConfig{
+		{URI: server.URL, AllowInsecure: true, Transport: "socks5://user:secret@" + proxy},
+		{URI: server.URL, AllowInsecure: true, Transport: "socks5://user:secret@" + proxy, Fingerprints: [][]byte{hash[:]}},
+	} {
+		sp, err := SecretProvider(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.GetKey("2011-04-29"); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt64(connections); n != 2 {
+		t.Errorf("unexpected connections through the proxy: %d", n)
+	}
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, Transport: "socks5://user:wrong@" + proxy}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err == nil {
+		t.Error("the proxy should reject the credentials")
+	}
+}
+
+func TestSecretProvider_unixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "idp.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(jwksHandler(t))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{
+		URI:           "http://idp.internal/jwks",
+		AllowInsecure: true,
+		Transport:     "unix://" + socket,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseJWKTransport(t *testing.T) {
+	for _, transport := range []string{"http://proxy:3128", "socks5://proxy", "unix://", "::"} {
+		if _, err := parseJWKTransport(transport); !errors.Is(err, ErrInvalidTransport) {
+			t.Errorf("%q: unexpected error %v", transport, err)
+		}
+	}
+	if _, err := SecretProvider(SecretProviderConfig{URI: "https://example.com/jwks", Transport: "ftp://proxy"}, nil); !errors.Is(err, ErrInvalidTransport) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
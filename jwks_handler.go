@@ -0,0 +1,187 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	JWKSNamespace       = "github.com/DKolibar/krakend-jose/jwks"
+	DefaultJWKSPath     = "/__jwks"
+	defaultJWKSInterval = time.Minute
+)
+
+var ErrNoJWKSCfg = errors.New("no jwks config")
+
+// JWKSConfig defines the key set published by the gateway. It is expected at the service level extra config
+type JWKSConfig struct {
+	Path               string   `json:"path,omitempty"`
+	URI                string   `json:"jwk_url,omitempty"`
+	CacheDuration      Seconds  `json:"cache_duration,omitempty"`
+	CipherSuites       []uint16 `json:"cipher_suites,omitempty"`
+	DisableJWKSecurity bool     `json:"disable_jwk_security"`
+	Fingerprints       []string `json:"jwk_fingerprints,omitempty"`
+	LocalCA            string   `json:"jwk_local_ca,omitempty"`
+	LocalPath          string   `json:"jwk_local_path,omitempty"`
+	SecretURL          string   `json:"secret_url,omitempty"`
+	CipherKey          []byte   `json:"cypher_key,omitempty"`
+	// Rotation generates the signing keys in the jwk_local_path key set on a schedule
+	Rotation *KeyRotationConfig `json:"rotation,omitempty"`
+	// Logger receives the errors of the key rotation
+	Logger Logger `json:"-"`
+}
+
+// GetJWKSConfig parses the jwks config from the service extra config
+func GetJWKSConfig(extra config.ExtraConfig) (*JWKSConfig, error) {
+	tmp, ok := extra[JWKSNamespace]
+	if !ok {
+		return nil, ErrNoJWKSCfg
+	}
+	res := new(JWKSConfig)
+	if err := decodeConfig(tmp, res); err != nil {
+		return nil, err
+	}
+	if res.Path == "" {
+		res.Path = DefaultJWKSPath
+	}
+	if res.LocalPath == "" && !validJWKSource(res.URI, res.DisableJWKSecurity) {
+		return res, ErrInsecureJWKSource
+	}
+	return res, nil
+}
+
+// JWKSHandler serves the public part of a key set. The key set is reloaded once the cache duration is over,
+// so new keys are published as soon as they are added to the set. Keys with an exp member in the past are
+// not published.
+type JWKSHandler struct {
+	mu       sync.RWMutex
+	body     []byte
+	next     time.Time
+	interval time.Duration
+	load     func() ([]selectableKey, error)
+}
+
+// NewJWKSHandler creates a JWKSHandler for the configured key set
+func NewJWKSHandler(cfg *JWKSConfig) (*JWKSHandler, error) {
+	decodedFs, err := DecodeFingerprints(cfg.Fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	spcfg := SecretProviderConfig{
+		URI:           cfg.URI,
+		Cs:            cfg.CipherSuites,
+		Fingerprints:  decodedFs,
+		LocalCA:       cfg.LocalCA,
+		AllowInsecure: cfg.DisableJWKSecurity,
+		LocalPath:     cfg.LocalPath,
+		SecretURL:     cfg.SecretURL,
+		CipherKey:     cfg.CipherKey,
+	}
+
+	if cfg.Rotation != nil {
+		m, err := NewKeyManager(cfg.LocalPath, cfg.Rotation)
+		if err != nil {
+			return nil, err
+		}
+		logger := loggerOrDefault(cfg.Logger)
+		go m.Run(context.Background(), func(err error) {
+			logger.Log(LogError, "unable to rotate the keys", "path", cfg.LocalPath, "error", err.Error())
+		})
+	}
+
+	var client *http.Client
+	if spcfg.LocalPath == "" {
+		opts, err := newJWKClientOptions(spcfg)
+		if err != nil {
+			return nil, err
+		}
+		client = opts.Client
+	}
+
+	interval := cfg.CacheDuration.Duration()
+	if interval == 0 {
+		interval = defaultJWKSInterval
+	}
+
+	h := &JWKSHandler{
+		interval: interval,
+		load: func() ([]selectableKey, error) {
+			data, err := loadKeySet(spcfg, client)
+			if err != nil {
+				return nil, err
+			}
+			return parseSelectableKeys(data)
+		},
+	}
+	if err := h.refresh(time.Now()); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *JWKSHandler) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+
+	h.mu.RLock()
+	expired := now.After(h.next)
+	h.mu.RUnlock()
+
+	if expired {
+		h.refresh(now)
+	}
+
+	h.mu.RLock()
+	body := h.body
+	h.mu.RUnlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.interval.Seconds())))
+	rw.Write(body)
+}
+
+func (h *JWKSHandler) refresh(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.body != nil && !now.After(h.next) {
+		return nil
+	}
+	h.next = now.Add(h.interval)
+
+	keys, err := h.load()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(publicKeySet(keys, now))
+	if err != nil {
+		return err
+	}
+	h.body = body
+	return nil
+}
+
+// publicKeySet returns the public part of the asymmetric and not expired keys
+func publicKeySet(keys []selectableKey, now time.Time) jose.JSONWebKeySet {
+	ts := float64(now.Unix())
+	res := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, k := range keys {
+		if exp, ok := k.number("exp"); ok && exp <= ts {
+			continue
+		}
+		pub := k.Public()
+		if pub.Key == nil {
+			continue
+		}
+		res.Keys = append(res.Keys, pub)
+	}
+	return res
+}
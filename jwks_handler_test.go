@@ -0,0 +1,98 @@
+package jose
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestGetJWKSConfig(t *testing.T) {
+	if _, err := GetJWKSConfig(config.ExtraConfig{}); err != ErrNoJWKSCfg {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err := GetJWKSConfig(config.ExtraConfig{JWKSNamespace: map[string]interface{}{"jwk_url": "http://jwk.example.com"}})
+	if err != ErrInsecureJWKSource {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg, err := GetJWKSConfig(config.ExtraConfig{JWKSNamespace: map[string]interface{}{"jwk_local_path": "./fixtures/private.json"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if cfg.Path != DefaultJWKSPath {
+		t.Errorf("unexpected path: %s", cfg.Path)
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	now := time.Now()
+
+	current := newSelectableKey(t, "current", map[string]interface{}{})
+	expired := newSelectableKey(t, "expired", map[string]interface{}{"exp": float64(now.Add(-time.Hour).Unix())})
+	writeKeySet(t, path, current, expired)
+
+	h, err := NewJWKSHandler(&JWKSConfig{LocalPath: path, CacheDuration: 3600})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assertPublishedKeys(t, h, "current")
+
+	next := newSelectableKey(t, "next", map[string]interface{}{"nbf": float64(now.Add(time.Hour).Unix())})
+	writeKeySet(t, path, current, expired, next)
+
+	assertPublishedKeys(t, h, "current")
+
+	h.mu.Lock()
+	h.next = now
+	h.mu.Unlock()
+
+	assertPublishedKeys(t, h, "current", "next")
+}
+
+func TestJWKSHandler_symmetric(t *testing.T) {
+	h, err := NewJWKSHandler(&JWKSConfig{LocalPath: "./fixtures/symmetric.json"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assertPublishedKeys(t, h)
+}
+
+func assertPublishedKeys(t *testing.T, h http.Handler, kids ...string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DefaultJWKSPath, http.NoBody))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(keys.Keys) != len(kids) {
+		t.Errorf("unexpected number of keys: %s", w.Body.String())
+		return
+	}
+	for i, k := range keys.Keys {
+		if k.KeyID != kids[i] {
+			t.Errorf("unexpected key #%d: %s", i, k.KeyID)
+		}
+		if !k.IsPublic() {
+			t.Errorf("key %s is not public", k.KeyID)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/auth0-community/go-auth0"
 	"github.com/luraproject/lura/v2/config"
@@ -15,54 +17,210 @@ const (
 	ValidatorNamespace = "github.com/DKolibar/krakend-jose/validator"
 	SignerNamespace    = "github.com/DKolibar/krakend-jose/signer"
 	defaultRolesKey    = "roles"
+
+	EnforcementModeEnforce = "enforce"
+	EnforcementModeLogOnly = "log_only"
 )
 
 type SignatureConfig struct {
-	Alg                     string            `json:"alg"`
-	URI                     string            `json:"jwk_url"`
-	CacheEnabled            bool              `json:"cache,omitempty"`
-	CacheDuration           uint32            `json:"cache_duration,omitempty"`
-	Issuer                  string            `json:"issuer,omitempty"`
-	Audience                []string          `json:"audience,omitempty"`
-	Roles                   []string          `json:"roles,omitempty"`
-	PropagateClaimsToHeader [][]string        `json:"propagate_claims,omitempty"`
-	PropagateIssAsTenantId  []string          `json:"propagate_iss_as_tenant_id,omitempty"`
-	RolesKey                string            `json:"roles_key,omitempty"`
-	RolesKeyIsNested        bool              `json:"roles_key_is_nested,omitempty"`
-	ReqClaimFieldsEquals    map[string]string `json:"req_claim_fields_equals,omitempty"`
-	CookieKey               string            `json:"cookie_key,omitempty"`
-	CipherSuites            []uint16          `json:"cipher_suites,omitempty"`
-	DisableJWKSecurity      bool              `json:"disable_jwk_security"`
-	Fingerprints            []string          `json:"jwk_fingerprints,omitempty"`
-	LocalCA                 string            `json:"jwk_local_ca,omitempty"`
-	LocalPath               string            `json:"jwk_local_path,omitempty"`
-	SecretURL               string            `json:"secret_url,omitempty"`
-	CipherKey               []byte            `json:"cypher_key,omitempty"`
-	Scopes                  []string          `json:"scopes,omitempty"`
-	ScopesKey               string            `json:"scopes_key,omitempty"`
-	ScopesMatcher           string            `json:"scopes_matcher,omitempty"`
-	KeyIdentifyStrategy     string            `json:"key_identify_strategy"`
-	OperationDebug          bool              `json:"operation_debug,omitempty"`
+	Alg                     string                        `json:"alg"`
+	URI                     string                        `json:"jwk_url"`
+	CacheEnabled            bool                          `json:"cache,omitempty"`
+	CacheDuration           Seconds                       `json:"cache_duration,omitempty"`
+	Issuer                  string                        `json:"issuer,omitempty"`
+	Audience                []string                      `json:"audience,omitempty"`
+	Roles                   []string                      `json:"roles,omitempty"`
+	PropagateClaimsToHeader [][]string                    `json:"propagate_claims,omitempty"`
+	PropagateIssAsTenantId  []string                      `json:"propagate_iss_as_tenant_id,omitempty"`
+	RolesKey                string                        `json:"roles_key,omitempty"`
+	RolesKeyIsNested        bool                          `json:"roles_key_is_nested,omitempty"`
+	ReqClaimFieldsEquals    map[string]string             `json:"req_claim_fields_equals,omitempty"`
+	CookieKey               string                        `json:"cookie_key,omitempty"`
+	CipherSuites            []uint16                      `json:"cipher_suites,omitempty"`
+	DisableJWKSecurity      bool                          `json:"disable_jwk_security"`
+	Fingerprints            []string                      `json:"jwk_fingerprints,omitempty"`
+	LocalCA                 string                        `json:"jwk_local_ca,omitempty"`
+	LocalPath               string                        `json:"jwk_local_path,omitempty"`
+	SecretURL               string                        `json:"secret_url,omitempty"`
+	CipherKey               []byte                        `json:"cypher_key,omitempty"`
+	Scopes                  []string                      `json:"scopes,omitempty"`
+	ScopesKey               string                        `json:"scopes_key,omitempty"`
+	ScopesMatcher           string                        `json:"scopes_matcher,omitempty"`
+	KeyIdentifyStrategy     string                        `json:"key_identify_strategy"`
+	OperationDebug          bool                          `json:"operation_debug,omitempty"`
+	DetachedPayload         *DetachedConfig               `json:"detached_payload,omitempty"`
+	TokenFormat             string                        `json:"token_format,omitempty"`
+	Paseto                  *PasetoConfig                 `json:"paseto,omitempty"`
+	Biscuit                 *BiscuitConfig                `json:"biscuit,omitempty"`
+	ErrorResponse           *ErrorResponseConfig          `json:"error_response,omitempty"`
+	Audit                   *AuditConfig                  `json:"audit,omitempty"`
+	EnforcementMode         string                        `json:"enforcement_mode,omitempty"`
+	Redaction               []RedactionRule               `json:"redact_claims,omitempty"`
+	TokenCache              *TokenCacheConfig             `json:"token_cache,omitempty"`
+	HardenedMatching        bool                          `json:"hardened_matching,omitempty"`
+	Timeouts                *TimeoutsConfig               `json:"timeouts,omitempty"`
+	CircuitBreaker          *CircuitBreakerConfig         `json:"circuit_breaker,omitempty"`
+	RefreshRateLimit        *RefreshRateLimitConfig       `json:"refresh_rate_limit,omitempty"`
+	Reload                  *ReloadConfig                 `json:"reload,omitempty"`
+	MethodRequirements      map[string]MethodRequirements `json:"method_requirements,omitempty"`
+	ParamConstraints        []ParamConstraint             `json:"param_constraints,omitempty"`
+	InjectClaimsIntoBody    []BodyClaim                   `json:"inject_claims_into_body,omitempty"`
+	ResponseFilters         []ResponseFilterRule          `json:"response_filters,omitempty"`
+	Tenant                  *TenantConfig                 `json:"tenant,omitempty"`
+	RoleMapping             *RoleMappingConfig            `json:"role_mapping,omitempty"`
+	TransformClaims         []ClaimTransform              `json:"transform_claims,omitempty"`
+	SignedClaims            *SignedClaimsConfig           `json:"propagate_signed_claims,omitempty"`
+	PropagateClaimsToGRPC   [][]string                    `json:"propagate_claims_to_grpc_metadata,omitempty"`
+	WebSocket               *WebSocketConfig              `json:"websocket,omitempty"`
+	StreamRevalidation      *StreamRevalidationConfig     `json:"stream_revalidation,omitempty"`
+	AuthOptional            bool                          `json:"auth_optional,omitempty"`
+	APIKeys                 *APIKeyConfig                 `json:"api_keys,omitempty"`
+	BasicAuth               *BasicAuthConfig              `json:"basic_auth,omitempty"`
+	SAML                    *SAMLConfig                   `json:"saml,omitempty"`
+	Provider                *ProviderConfig               `json:"provider,omitempty"`
+	KeySetFormat            string                        `json:"jwk_format,omitempty"`
+	AppleClientSecret       *AppleClientSecretConfig      `json:"apple_client_secret,omitempty"`
+	TokenExchange           *TokenExchangeConfig          `json:"token_exchange,omitempty"`
+	FAPI                    *FAPIConfig                   `json:"fapi,omitempty"`
+	Deny                    *DenyRules                    `json:"deny,omitempty"`
+	Schedule                *ScheduleConfig               `json:"schedule,omitempty"`
+	StepUp                  *StepUpConfig                 `json:"step_up,omitempty"`
+	MaxAuthAge              Seconds                       `json:"max_auth_age,omitempty"`
+	Nonce                   *NonceConfig                  `json:"nonce,omitempty"`
+	SubjectHistory          *SubjectHistoryConfig         `json:"subject_history,omitempty"`
+	Enrichment              *EnrichmentConfig             `json:"enrichment,omitempty"`
+	UserInfo                *UserInfoConfig               `json:"userinfo,omitempty"`
+	AllowedTypes            []string                      `json:"allowed_typ,omitempty"`
+	AllowedContentTypes     []string                      `json:"allowed_cty,omitempty"`
+	TokenProfile            string                        `json:"token_profile,omitempty"`
+	KeyUsage                *KeyUsageConfig               `json:"key_usage,omitempty"`
+	LocalReloadInterval     Seconds                       `json:"jwk_local_reload_interval,omitempty"`
+	Kubernetes              *KubernetesConfig             `json:"jwk_kubernetes,omitempty"`
+	KVStore                 *KVStoreConfig                `json:"jwk_kv,omitempty"`
+	RevocationList          *RevocationListConfig         `json:"revocation_list,omitempty"`
+	SharedCache             *SharedCacheConfig            `json:"shared_cache,omitempty"`
+	CacheControl            *CacheControlConfig           `json:"jwk_cache_control,omitempty"`
+	CacheDurations          map[string]Seconds            `json:"cache_durations,omitempty"`
+	CacheMaxKeys            int                           `json:"cache_max_keys,omitempty"`
+	Pins                    []PinConfig                   `json:"jwk_pins,omitempty"`
+	PinExpiryWarning        Seconds                       `json:"jwk_pin_expiry_warning,omitempty"`
+	TLS                     *TLSConfig                    `json:"jwk_tls,omitempty"`
+	Transport               string                        `json:"jwk_transport,omitempty"`
+	Retry                   *RetryConfig                  `json:"jwk_retry,omitempty"`
+	PrefetchOnStart         bool                          `json:"prefetch_on_start,omitempty"`
+	PrefetchFailFast        bool                          `json:"prefetch_fail_fast,omitempty"`
+	BackupPath              string                        `json:"jwk_backup_path,omitempty"`
+	SignedKeySet            *SignedKeySetConfig           `json:"jwk_signed,omitempty"`
+	KeyIteration            *KeyIterationConfig           `json:"key_iteration,omitempty"`
+	Logger                  Logger                        `json:"-"`
+	ClaimsLimits            *ClaimsLimitsConfig           `json:"claims_limits,omitempty"`
+	ScopesKeyFallbacks      []string                      `json:"scopes_key_fallbacks,omitempty"`
+	ScopesMinCount          int                           `json:"scopes_min_count,omitempty"`
+	RolesExpression         string                        `json:"roles_expression,omitempty"`
+	Clients                 *ClientRulesConfig            `json:"clients,omitempty"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. The scopes_key can be a list of claims, as
+// ["scope", "scp", "permissions"], so the same config works with the tokens of several identity providers:
+// the first claim is the ScopesKey and the others are its ScopesKeyFallbacks, looked up in order when the
+// previous ones are missing.
+func (s *SignatureConfig) UnmarshalJSON(b []byte) error {
+	type signatureConfig SignatureConfig
+	aux := struct {
+		*signatureConfig
+		ScopesKey json.RawMessage `json:"scopes_key,omitempty"`
+	}{signatureConfig: (*signatureConfig)(s)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		// report the unexpected types as the decoder does without the auxiliary struct
+		if e, ok := err.(*json.UnmarshalTypeError); ok && e.Field == "" {
+			e.Type = reflect.TypeOf(*s)
+		} else if ok && e.Struct == "" {
+			e.Struct = "SignatureConfig"
+		}
+		return err
+	}
+	if len(aux.ScopesKey) == 0 || string(aux.ScopesKey) == "null" {
+		return nil
+	}
+
+	var keys []string
+	if json.Unmarshal(aux.ScopesKey, &s.ScopesKey) == nil {
+		return nil
+	}
+	if err := json.Unmarshal(aux.ScopesKey, &keys); err != nil {
+		return &json.UnmarshalTypeError{Value: string(aux.ScopesKey), Type: reflect.TypeOf(keys), Struct: "SignatureConfig", Field: "scopes_key"}
+	}
+	if len(keys) > 0 {
+		s.ScopesKey = keys[0]
+	}
+	if len(keys) > 1 {
+		s.ScopesKeyFallbacks = append(keys[1:], s.ScopesKeyFallbacks...)
+	}
+	return nil
+}
+
+// RolesPath returns the path of the roles claim. The roles key is nested if it is flagged as such, it has
+// dots and it is not a URL.
+func (s *SignatureConfig) RolesPath() ClaimPath {
+	return NewClaimPath(s.RolesKey, s.RolesKeyIsNested && strings.Contains(s.RolesKey, ".") && !strings.HasPrefix(s.RolesKey, "http"))
+}
+
+// scopesPath returns the path of the scopes claim, with its fallbacks
+func (s *SignatureConfig) scopesPath() ClaimPath {
+	return NewClaimPaths(append([]string{s.ScopesKey}, s.ScopesKeyFallbacks...), true)
+}
+
+// cacheDurations returns the cache duration overrides by JWK URL
+func (s *SignatureConfig) cacheDurations() map[string]time.Duration {
+	if len(s.CacheDurations) == 0 {
+		return nil
+	}
+	res := make(map[string]time.Duration, len(s.CacheDurations))
+	for uri, d := range s.CacheDurations {
+		res[uri] = d.Duration()
+	}
+	return res
+}
+
+// LogOnly returns true if the rejections must be logged but not enforced
+func (s *SignatureConfig) LogOnly() bool {
+	return s.EnforcementMode == EnforcementModeLogOnly
 }
 
 type SignerConfig struct {
-	Alg                string   `json:"alg"`
-	KeyID              string   `json:"kid"`
-	URI                string   `json:"jwk_url"`
-	FullSerialization  bool     `json:"full,omitempty"`
-	KeysToSign         []string `json:"keys_to_sign,omitempty"`
-	CipherSuites       []uint16 `json:"cipher_suites,omitempty"`
-	DisableJWKSecurity bool     `json:"disable_jwk_security"`
-	Fingerprints       []string `json:"jwk_fingerprints,omitempty"`
-	LocalCA            string   `json:"jwk_local_ca,omitempty"`
-	LocalPath          string   `json:"jwk_local_path,omitempty"`
-	SecretURL          string   `json:"secret_url,omitempty"`
-	CipherKey          []byte   `json:"cypher_key,omitempty"`
+	Alg                string            `json:"alg"`
+	KeyID              string            `json:"kid"`
+	URI                string            `json:"jwk_url"`
+	FullSerialization  bool              `json:"full,omitempty"`
+	KeysToSign         []string          `json:"keys_to_sign,omitempty"`
+	CipherSuites       []uint16          `json:"cipher_suites,omitempty"`
+	DisableJWKSecurity bool              `json:"disable_jwk_security"`
+	Fingerprints       []string          `json:"jwk_fingerprints,omitempty"`
+	Pins               []PinConfig       `json:"jwk_pins,omitempty"`
+	TLS                *TLSConfig        `json:"jwk_tls,omitempty"`
+	Transport          string            `json:"jwk_transport,omitempty"`
+	LocalCA            string            `json:"jwk_local_ca,omitempty"`
+	LocalPath          string            `json:"jwk_local_path,omitempty"`
+	SecretURL          string            `json:"secret_url,omitempty"`
+	CipherKey          []byte            `json:"cypher_key,omitempty"`
+	Claims             *ClaimsTemplate   `json:"claims,omitempty"`
+	Profiles           []SignerConfig    `json:"profiles,omitempty"`
+	Encryption         *EncryptionConfig `json:"encrypt,omitempty"`
+	KeySelection       *KeySelection     `json:"key_selection,omitempty"`
+	Detached           *DetachedConfig   `json:"detached,omitempty"`
+	TokenFormat        string            `json:"token_format,omitempty"`
+	Paseto             *PasetoConfig     `json:"paseto,omitempty"`
+	JARM               *JARMConfig       `json:"jarm,omitempty"`
+	Kubernetes         *KubernetesConfig `json:"jwk_kubernetes,omitempty"`
+	KVStore            *KVStoreConfig    `json:"jwk_kv,omitempty"`
+	ThumbprintKeyID    bool              `json:"kid_thumbprint,omitempty"`
 }
 
 var (
 	ErrNoValidatorCfg = errors.New("no validator config")
 	ErrNoSignerCfg    = errors.New("no signer config")
+
+	ErrUnknownEnforcementMode = errors.New("unknown enforcement mode")
 )
 
 func GetSignatureConfig(cfg *config.EndpointConfig) (*SignatureConfig, error) {
@@ -70,16 +228,23 @@ func GetSignatureConfig(cfg *config.EndpointConfig) (*SignatureConfig, error) {
 	if !ok {
 		return nil, ErrNoValidatorCfg
 	}
-	data, _ := json.Marshal(tmp)
 	res := new(SignatureConfig)
-	if err := json.Unmarshal(data, res); err != nil {
+	if err := decodeConfig(tmp, res); err != nil {
 		return nil, err
 	}
 
+	if err := applyProvider(res); err != nil {
+		return res, err
+	}
 	if res.RolesKey == "" {
 		res.RolesKey = defaultRolesKey
 	}
-	if !strings.HasPrefix(res.URI, "https://") && !res.DisableJWKSecurity {
+	switch res.EnforcementMode {
+	case "", EnforcementModeEnforce, EnforcementModeLogOnly:
+	default:
+		return res, fmt.Errorf("%w: %s", ErrUnknownEnforcementMode, res.EnforcementMode)
+	}
+	if (res.URI != "" || (res.Kubernetes == nil && res.KVStore == nil)) && !validJWKSource(res.URI, res.DisableJWKSecurity) {
 		return res, ErrInsecureJWKSource
 	}
 	return res, nil
@@ -90,50 +255,121 @@ func getSignerConfig(cfg *config.EndpointConfig) (*SignerConfig, error) {
 	if !ok {
 		return nil, ErrNoSignerCfg
 	}
-	data, _ := json.Marshal(tmp)
 	res := new(SignerConfig)
-	if err := json.Unmarshal(data, res); err != nil {
+	if err := decodeConfig(tmp, res); err != nil {
 		return nil, err
 	}
-	if !strings.HasPrefix(res.URI, "https://") && !res.DisableJWKSecurity {
+	if (res.URI != "" || (res.Kubernetes == nil && res.KVStore == nil)) && !validJWKSource(res.URI, res.DisableJWKSecurity) {
 		return res, ErrInsecureJWKSource
 	}
 	return res, nil
 }
 
+func validJWKSource(uri string, allowInsecure bool) bool {
+	return allowInsecure || strings.HasPrefix(uri, "https://")
+}
+
 func NewSigner(cfg *config.EndpointConfig, te auth0.RequestTokenExtractor) (*SignerConfig, Signer, error) {
 	signerCfg, err := getSignerConfig(cfg)
 	if err != nil {
 		return signerCfg, nopSigner, err
 	}
 
+	s, err := newSigner(signerCfg, te)
+	return signerCfg, s, err
+}
+
+func newSigner(signerCfg *SignerConfig, te auth0.RequestTokenExtractor) (Signer, error) {
+	spcfg, err := newSignerSecretProviderConfig(signerCfg)
+	if err != nil {
+		return nopSigner, err
+	}
+
+	var s Signer
+	if signerCfg.KeyID == "" || signerCfg.KeySelection != nil {
+		s, err = newRotatingSigner(signerCfg, spcfg, te)
+	} else {
+		var key jose.JSONWebKey
+		key, err = loadSigningKey(spcfg, signerCfg.KeyID, te)
+		if err != nil {
+			return nopSigner, err
+		}
+		s, err = newKeySigner(signerCfg, key, te)
+	}
+	if err != nil {
+		return nopSigner, err
+	}
+	return instrumentedSigner(s, DefaultMetrics), nil
+}
+
+func instrumentedSigner(s Signer, m *Metrics) Signer {
+	return func(v interface{}) (string, error) {
+		res, err := s(v)
+		m.SignerOperation(err)
+		return res, err
+	}
+}
+
+func newSignerSecretProviderConfig(signerCfg *SignerConfig) (SecretProviderConfig, error) {
 	decodedFs, err := DecodeFingerprints(signerCfg.Fingerprints)
 	if err != nil {
-		return signerCfg, nopSigner, err
+		return SecretProviderConfig{}, err
+	}
+	pins, err := DecodePins(signerCfg.Pins)
+	if err != nil {
+		return SecretProviderConfig{}, err
 	}
 
-	spcfg := SecretProviderConfig{
+	return SecretProviderConfig{
 		URI:           signerCfg.URI,
 		Cs:            signerCfg.CipherSuites,
 		Fingerprints:  decodedFs,
+		Pins:          pins,
+		TLS:           signerCfg.TLS,
+		Transport:     signerCfg.Transport,
 		LocalCA:       signerCfg.LocalCA,
 		AllowInsecure: signerCfg.DisableJWKSecurity,
 		LocalPath:     signerCfg.LocalPath,
 		SecretURL:     signerCfg.SecretURL,
 		CipherKey:     signerCfg.CipherKey,
-	}
+		Kubernetes:    signerCfg.Kubernetes,
+		KVStore:       signerCfg.KVStore,
+	}, nil
+}
 
+func loadSigningKey(spcfg SecretProviderConfig, keyID string, te auth0.RequestTokenExtractor) (jose.JSONWebKey, error) {
 	sp, err := SecretProvider(spcfg, te)
 	if err != nil {
-		return signerCfg, nopSigner, err
+		return jose.JSONWebKey{}, err
 	}
-	key, err := sp.GetKey(signerCfg.KeyID)
+	return sp.GetKey(keyID)
+}
+
+// withThumbprintKeyID replaces the kid of the key with its RFC 7638 thumbprint, for the clients identifying the
+// keys by their thumbprint
+func withThumbprintKeyID(key jose.JSONWebKey) (jose.JSONWebKey, error) {
+	thumbprint, err := Thumbprint(key.Key)
 	if err != nil {
-		return signerCfg, nopSigner, err
+		return key, err
 	}
+	key.KeyID = thumbprint
+	return key, nil
+}
+
+func newKeySigner(signerCfg *SignerConfig, key jose.JSONWebKey, te auth0.RequestTokenExtractor) (Signer, error) {
 	// if key.IsPublic() {
 	// 	// TODO: we should not sign with a public key
 	// }
+	if signerCfg.ThumbprintKeyID {
+		var err error
+		if key, err = withThumbprintKeyID(key); err != nil {
+			return nopSigner, err
+		}
+	}
+	if signerCfg.TokenFormat == TokenFormatPaseto {
+		return newPasetoSigner(signerCfg, key)
+	}
+
 	signingKey := jose.SigningKey{
 		Key:       key.Key,
 		Algorithm: jose.SignatureAlgorithm(signerCfg.Alg),
@@ -145,13 +381,25 @@ func NewSigner(cfg *config.EndpointConfig, te auth0.RequestTokenExtractor) (*Sig
 	}
 	s, err := jose.NewSigner(signingKey, opts)
 	if err != nil {
-		return signerCfg, nopSigner, err
+		return nopSigner, err
+	}
+
+	if signerCfg.Encryption != nil {
+		encrypter, err := newEncrypter(signerCfg.Encryption, signerCfg, te)
+		if err != nil {
+			return nopSigner, err
+		}
+		return nestedSigner{
+			signer:    compactSerializeSigner{signer{s}}.Sign,
+			encrypter: encrypter,
+			full:      signerCfg.FullSerialization,
+		}.Sign, nil
 	}
 
 	if signerCfg.FullSerialization {
-		return signerCfg, fullSerializeSigner{signer{s}}.Sign, nil
+		return fullSerializeSigner{signer{s}}.Sign, nil
 	}
-	return signerCfg, compactSerializeSigner{signer{s}}.Sign, nil
+	return compactSerializeSigner{signer{s}}.Sign, nil
 }
 
 type Signer func(interface{}) (string, error)
@@ -1,7 +1,9 @@
 package jose
 
 import (
+	"errors"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -57,6 +59,86 @@ func Test_getSignatureConfig_unsecure(t *testing.T) {
 	}
 }
 
+func Test_getSignatureConfig_enforcementMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		logOnly bool
+		err     error
+	}{
+		{mode: ""},
+		{mode: EnforcementModeEnforce},
+		{mode: EnforcementModeLogOnly, logOnly: true},
+		{mode: "audit", err: ErrUnknownEnforcementMode},
+	} {
+		cfg := &config.EndpointConfig{
+			Endpoint: "/private",
+			ExtraConfig: config.ExtraConfig{
+				ValidatorNamespace: map[string]interface{}{
+					"alg":              "RS256",
+					"jwk_url":          "https://jwk.example.com",
+					"enforcement_mode": tc.mode,
+				},
+			},
+		}
+
+		res, err := GetSignatureConfig(cfg)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: unexpected error: %v", tc.mode, err)
+			continue
+		}
+		if res.LogOnly() != tc.logOnly {
+			t.Errorf("%s: unexpected log only: %v", tc.mode, res.LogOnly())
+		}
+	}
+}
+
+func Test_getSignatureConfig_scopesKeys(t *testing.T) {
+	for _, tc := range []struct {
+		scopesKey interface{}
+		key       string
+		fallbacks []string
+	}{
+		{scopesKey: "scope", key: "scope"},
+		{scopesKey: []interface{}{"scope"}, key: "scope"},
+		{scopesKey: []interface{}{"scope", "scp", "permissions"}, key: "scope", fallbacks: []string{"scp", "permissions"}},
+		{scopesKey: []interface{}{}},
+	} {
+		cfg := &config.EndpointConfig{
+			Endpoint: "/private",
+			ExtraConfig: config.ExtraConfig{
+				ValidatorNamespace: map[string]interface{}{
+					"alg":        "RS256",
+					"jwk_url":    "https://jwk.example.com",
+					"scopes_key": tc.scopesKey,
+					"scopes":     []string{"read"},
+				},
+			},
+		}
+
+		res, err := GetSignatureConfig(cfg)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tc.scopesKey, err)
+			continue
+		}
+		if res.ScopesKey != tc.key || !reflect.DeepEqual(res.ScopesKeyFallbacks, tc.fallbacks) {
+			t.Errorf("%v: unexpected scopes keys: %q %q", tc.scopesKey, res.ScopesKey, res.ScopesKeyFallbacks)
+		}
+		if res.Alg != "RS256" || len(res.Scopes) != 1 {
+			t.Errorf("%v: unexpected config: %+v", tc.scopesKey, res)
+		}
+	}
+
+	cfg := &config.EndpointConfig{
+		Endpoint: "/private",
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{"alg": "RS256", "jwk_url": "https://jwk.example.com", "scopes_key": 42},
+		},
+	}
+	if _, err := GetSignatureConfig(cfg); err == nil || err.Error() != "json: cannot unmarshal 42 into Go struct field SignatureConfig.scopes_key of type []string" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func Test_getSignatureConfig_wrongStruct(t *testing.T) {
 	cfg := &config.EndpointConfig{
 		Timeout:  time.Second,
@@ -293,3 +375,23 @@ func newVerifierEndpointCfg(alg, URL string, roles []string) *config.EndpointCon
 		},
 	}
 }
+
+func TestSignatureConfig_RolesPath(t *testing.T) {
+	claims := map[string]interface{}{
+		"realm":                   map[string]interface{}{"roles": "nested"},
+		"realm.roles":             "flat",
+		"http://example.com/role": "url",
+	}
+	for _, tc := range []struct {
+		scfg     SignatureConfig
+		expected string
+	}{
+		{scfg: SignatureConfig{RolesKey: "realm.roles", RolesKeyIsNested: true}, expected: "nested"},
+		{scfg: SignatureConfig{RolesKey: "realm.roles"}, expected: "flat"},
+		{scfg: SignatureConfig{RolesKey: "http://example.com/role", RolesKeyIsNested: true}, expected: "url"},
+	} {
+		if v, _ := tc.scfg.RolesPath().Get(claims); v != tc.expected {
+			t.Errorf("%s: unexpected value %q", tc.scfg.RolesKey, v)
+		}
+	}
+}
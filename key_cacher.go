@@ -3,19 +3,41 @@ package jose
 import (
 	b64 "encoding/base64"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/auth0-community/go-auth0"
 	"gopkg.in/square/go-jose.v2"
 )
 
 var (
-	ErrNoKeyFound = errors.New("no Keys have been found")
+	// ErrKeyNotFound is returned when the key of a token is not in the key set. The KeyNotFoundError also
+	// matches it
+	ErrKeyNotFound = errors.New("no Keys have been found")
+	// ErrNoKeyFound is the former name of ErrKeyNotFound
+	ErrNoKeyFound = ErrKeyNotFound
 	ErrKeyExpired = errors.New("key exists but is expired")
 
 	// Configuring with MaxKeyAgeNoCheck will skip key expiry check
 	MaxKeyAgeNoCheck = time.Duration(-1)
 )
 
+// KeyNotFoundError is returned when the key with the given id is not in the key set. It matches both
+// ErrKeyNotFound and the auth0.ErrNoKeyFound with errors.Is.
+type KeyNotFoundError struct {
+	KeyID string
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key '%s' not found in the key set", e.KeyID)
+}
+
+// Is reports whether the target is one of the key not found sentinel errors
+func (e *KeyNotFoundError) Is(target error) bool {
+	return target == ErrKeyNotFound || target == auth0.ErrNoKeyFound
+}
+
 // KeyIDGetter extracts a key id from a JSONWebKey
 type KeyIDGetter interface {
 	Get(*jose.JSONWebKey) string
@@ -44,17 +66,24 @@ func CompoundX5TKeyIDGetter(key *jose.JSONWebKey) string {
 	return key.KeyID + X5TKeyIDGetter(key)
 }
 
-func KeyIDGetterFactory(keyIdentifyStrategy string) KeyIDGetter {
-	supportedKeyIdentifyStrategy := map[string]KeyIDGetterFunc{
-		"kid":     DefaultKeyIDGetter,
-		"x5t":     X5TKeyIDGetter,
-		"kid_x5t": CompoundX5TKeyIDGetter,
-	}
+// X5TS256KeyIDGetter extracts the key id from the jSONWebKey as the x5t#S256
+func X5TS256KeyIDGetter(key *jose.JSONWebKey) string {
+	return b64.RawURLEncoding.EncodeToString(key.CertificateThumbprintSHA256)
+}
 
-	if keyGetter, ok := supportedKeyIdentifyStrategy[keyIdentifyStrategy]; ok {
-		return keyGetter
+// ThumbprintKeyIDGetter returns the RFC 7638 thumbprint of the jSONWebKey as its key id, for the key sets
+// whose keys are identified by their thumbprint
+func ThumbprintKeyIDGetter(key *jose.JSONWebKey) string {
+	thumbprint, err := Thumbprint(key.Key)
+	if err != nil {
+		return ""
 	}
-	return KeyIDGetterFunc(DefaultKeyIDGetter)
+	return thumbprint
+}
+
+// KeyIDGetterFactory returns the KeyIDGetter from the keyIdentifyStrategy configuration string
+func KeyIDGetterFactory(keyIdentifyStrategy string) KeyIDGetter {
+	return KeyIDGetterFunc(lookupKeyStrategy(keyIdentifyStrategy).KeyID)
 }
 
 type KeyCacher interface {
@@ -67,21 +96,37 @@ type MemoryKeyCacher struct {
 	maxKeyAge    time.Duration
 	maxCacheSize int
 	keyIDGetter  KeyIDGetter
+	preferrer    KeyPreferrer
+	freshness    *keySetFreshness
+	metrics      *Metrics
+	logger       Logger
+	setMu        sync.Mutex
+	set          *cachedKeySet
 }
 
 type keyCacherEntry struct {
 	addedAt time.Time
+	maxAge  time.Duration
 	jose.JSONWebKey
 }
 
 // NewMemoryKeyCacher creates a new Keycacher interface with option
 // to set max age of cached keys and max size of the cache.
 func NewMemoryKeyCacher(maxKeyAge time.Duration, maxCacheSize int, keyIdentifyStrategy string) KeyCacher {
+	return newMemoryKeyCacher(maxKeyAge, maxCacheSize, keyIdentifyStrategy, nil)
+}
+
+// newMemoryKeyCacher creates a MemoryKeyCacher where the keys use the max-age of the response they have
+// been downloaded with, when there is one, instead of the max key age
+func newMemoryKeyCacher(maxKeyAge time.Duration, maxCacheSize int, keyIdentifyStrategy string, freshness *keySetFreshness) *MemoryKeyCacher {
 	return &MemoryKeyCacher{
 		entries:      map[string]keyCacherEntry{},
 		maxKeyAge:    maxKeyAge,
 		maxCacheSize: maxCacheSize,
 		keyIDGetter:  KeyIDGetterFactory(keyIdentifyStrategy),
+		preferrer:    keyPreferrer(keyIdentifyStrategy),
+		freshness:    freshness,
+		metrics:      DefaultMetrics,
 	}
 }
 
@@ -101,16 +146,21 @@ func (mkc *MemoryKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
 func (mkc *MemoryKeyCacher) Add(keyID string, downloadedKeys []jose.JSONWebKey) (*jose.JSONWebKey, error) {
 	var addingKey jose.JSONWebKey
 	var addingKeyID string
-	for i := range downloadedKeys {
-		cacheKey := mkc.keyIDGetter.Get(&downloadedKeys[i])
+	maxAge := mkc.maxKeyAge
+	if d, ok := mkc.freshness.get(); ok {
+		maxAge = d
+	}
+	mkc.recordSet(downloadedKeys, maxAge)
+	for cacheKey, k := range selectKeys(downloadedKeys, mkc.keyIDGetter, mkc.preferrer) {
 		if cacheKey == keyID {
-			addingKey = downloadedKeys[i]
+			addingKey = *k
 			addingKeyID = cacheKey
 		}
 		if mkc.maxCacheSize == -1 {
 			mkc.entries[cacheKey] = keyCacherEntry{
 				addedAt:    time.Now(),
-				JSONWebKey: downloadedKeys[i],
+				maxAge:     maxAge,
+				JSONWebKey: *k,
 			}
 		}
 	}
@@ -118,6 +168,7 @@ func (mkc *MemoryKeyCacher) Add(keyID string, downloadedKeys []jose.JSONWebKey)
 		if mkc.maxCacheSize != -1 {
 			mkc.entries[addingKeyID] = keyCacherEntry{
 				addedAt:    time.Now(),
+				maxAge:     maxAge,
 				JSONWebKey: addingKey,
 			}
 			mkc.handleOverflow()
@@ -129,8 +180,10 @@ func (mkc *MemoryKeyCacher) Add(keyID string, downloadedKeys []jose.JSONWebKey)
 
 // keyIsExpired deletes the key from cache if it is expired
 func (mkc *MemoryKeyCacher) keyIsExpired(keyID string) bool {
-	if time.Now().After(mkc.entries[keyID].addedAt.Add(mkc.maxKeyAge)) {
+	entry := mkc.entries[keyID]
+	if time.Now().After(entry.addedAt.Add(entry.maxAge)) {
 		delete(mkc.entries, keyID)
+		mkc.evicted(KeyEvictionExpired)
 		return true
 	}
 	return false
@@ -148,5 +201,31 @@ func (mkc *MemoryKeyCacher) handleOverflow() {
 			}
 		}
 		delete(mkc.entries, oldestEntryKeyID)
+		mkc.evicted(KeyEvictionOverflow)
+	}
+}
+
+// evicted counts an eviction, unless the cache is disabled and every key is dropped right away
+func (mkc *MemoryKeyCacher) evicted(reason string) {
+	if mkc.metrics == nil || mkc.maxCacheSize == 0 {
+		return
+	}
+	mkc.metrics.KeyCacheEviction(reason)
+	if mkc.logger != nil {
+		mkc.logger.Log(LogDebug, "key evicted from the cache", "reason", reason)
+	}
+}
+
+// selectKeys returns the keys by id. When several keys have the same id, the preferred one is selected or,
+// without preference, the last one.
+func selectKeys(keys []jose.JSONWebKey, getter KeyIDGetter, preferrer KeyPreferrer) map[string]*jose.JSONWebKey {
+	res := make(map[string]*jose.JSONWebKey, len(keys))
+	for i := range keys {
+		id := getter.Get(&keys[i])
+		if current, ok := res[id]; ok && preferrer != nil && !preferrer.Prefer(&keys[i], current) {
+			continue
+		}
+		res[id] = &keys[i]
 	}
+	return res
 }
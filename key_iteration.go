@@ -0,0 +1,131 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const defaultKeyIterationMaxKeys = 5
+
+// The results of the key iterations
+const (
+	KeyIterationMatch = "match"
+	KeyIterationMiss  = "miss"
+)
+
+// KeyIterationConfig verifies the tokens without kid, signed by the legacy issuers, with every key of the
+// key set matching the alg of the token, instead of rejecting them right away. The first key verifying the
+// signature is used.
+type KeyIterationConfig struct {
+	// MaxKeys is the number of keys tried for each token. Defaults to 5
+	MaxKeys int `json:"max_keys,omitempty"`
+}
+
+func (c *KeyIterationConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxKeys < 0 {
+		return errors.New("the max_keys is negative")
+	}
+	return nil
+}
+
+func (c *KeyIterationConfig) maxKeys() int {
+	if c.MaxKeys == 0 {
+		return defaultKeyIterationMaxKeys
+	}
+	return c.MaxKeys
+}
+
+// keyLister is implemented by the key cachers able to list the keys of the last key set loaded. It returns
+// false when the key set must be loaded again.
+type keyLister interface {
+	list() ([]jose.JSONWebKey, bool)
+}
+
+// iterateKeys returns the first key of the key set verifying the signature of the token. The key set is
+// only downloaded when the cached one is missing or expired.
+func (j *JWKClient) iterateKeys(ctx context.Context, token *jwt.JSONWebToken) (jose.JSONWebKey, error) {
+	lister, ok := j.cache.(keyLister)
+	if !ok {
+		return jose.JSONWebKey{}, ErrKeyNotFound
+	}
+	keys, fresh := lister.list()
+	if !fresh {
+		// the missing key makes the client download and cache the key set
+		j.getKey(ctx, "")
+		keys, _ = lister.list()
+	}
+
+	alg := token.Headers[0].Algorithm
+	tried := 0
+	for i := range keys {
+		k := keys[i]
+		if (k.Algorithm != "" && k.Algorithm != alg) || k.Use == "enc" {
+			continue
+		}
+		if tried == j.iteration.maxKeys() {
+			break
+		}
+		tried++
+		if err := token.Claims(verificationKey(&k)); err == nil {
+			DefaultMetrics.KeyIteration(KeyIterationMatch)
+			return k, nil
+		}
+	}
+	DefaultMetrics.KeyIteration(KeyIterationMiss)
+	return jose.JSONWebKey{}, ErrKeyNotFound
+}
+
+// verificationKey returns the public part of the asymmetric keys and the symmetric ones as they are
+func verificationKey(k *jose.JSONWebKey) interface{} {
+	if k.IsPublic() {
+		return k.Key
+	}
+	if pub := k.Public(); pub.Key != nil {
+		return pub.Key
+	}
+	return k.Key
+}
+
+// list implements the keyLister interface
+func (f *FileKeyCacher) list() ([]jose.JSONWebKey, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	res := make([]jose.JSONWebKey, 0, len(f.keys))
+	for _, k := range f.keys {
+		res = append(res, *k)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].KeyID < res[j].KeyID })
+	return res, true
+}
+
+// list implements the keyLister interface
+func (mkc *MemoryKeyCacher) list() ([]jose.JSONWebKey, bool) {
+	mkc.setMu.Lock()
+	defer mkc.setMu.Unlock()
+	if mkc.set == nil {
+		return nil, false
+	}
+	fresh := mkc.set.maxAge == MaxKeyAgeNoCheck || time.Since(mkc.set.addedAt) < mkc.set.maxAge
+	return mkc.set.keys, fresh
+}
+
+// recordSet keeps the last key set downloaded, for the key iteration
+func (mkc *MemoryKeyCacher) recordSet(keys []jose.JSONWebKey, maxAge time.Duration) {
+	mkc.setMu.Lock()
+	mkc.set = &cachedKeySet{keys: keys, addedAt: time.Now(), maxAge: maxAge}
+	mkc.setMu.Unlock()
+}
+
+type cachedKeySet struct {
+	keys    []jose.JSONWebKey
+	addedAt time.Time
+	maxAge  time.Duration
+}
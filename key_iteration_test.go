@@ -0,0 +1,109 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	auth0 "github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestJWKClient_keyIteration(t *testing.T) {
+	var privateKeys []*ecdsa.PrivateKey
+	keySet := jose.JSONWebKeySet{}
+	for i := 0; i < 3; i++ {
+		k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		privateKeys = append(privateKeys, k)
+		keySet.Keys = append(keySet.Keys, jose.JSONWebKey{Key: &k.PublicKey, KeyID: fmt.Sprintf("key-%d", i), Algorithm: "ES256", Use: "sig"})
+	}
+	data, _ := json.Marshal(keySet)
+
+	var downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	te := auth0.RequestTokenExtractorFunc(auth0.FromHeader)
+	localPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(localPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(key *ecdsa.PrivateKey) *http.Request {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Signed(signer).Claims(map[string]interface{}{"sub": "1234"}).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	for _, cfg := range []SecretProviderConfig{
+		{URI: server.URL, AllowInsecure: true, CacheEnabled: true, Prefetch: true, KeyIteration: &KeyIterationConfig{}},
+		{LocalPath: localPath, KeyIteration: &KeyIterationConfig{}},
+	} {
+		sp, err := SecretProvider(cfg, te)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := sp.GetSecret(request(privateKeys[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k := key.(jose.JSONWebKey); k.KeyID != "key-2" {
+			t.Errorf("unexpected key %s", k.KeyID)
+		}
+
+		other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if _, err := sp.GetSecret(request(other)); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		cfg.KeyIteration.MaxKeys = 1
+		sp, _ = SecretProvider(cfg, te)
+		if _, err := sp.GetSecret(request(privateKeys[2])); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("the iteration must be bounded: %v", err)
+		}
+		cfg.KeyIteration = nil
+		sp, _ = SecretProvider(cfg, te)
+		if _, err := sp.GetSecret(request(privateKeys[0])); err == nil {
+			t.Errorf("the iteration must be opt-in: %v", err)
+		}
+	}
+
+	// the cached key set is reused
+	before := atomic.LoadInt32(&downloads)
+	sp, _ := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, CacheEnabled: true, Prefetch: true, KeyIteration: &KeyIterationConfig{}}, te)
+	for i := 0; i < 3; i++ {
+		if _, err := sp.GetSecret(request(privateKeys[1])); err != nil {
+			t.Error(err)
+		}
+	}
+	if d := atomic.LoadInt32(&downloads) - before; d != 1 {
+		t.Errorf("unexpected downloads: %d", d)
+	}
+
+	errs := ValidateConfig(&SignatureConfig{Alg: "ES256", URI: "https://example.com/jwks", KeyIteration: &KeyIterationConfig{MaxKeys: -1}})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidKeyIteration {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,200 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeyRotationInterval = 30 * 24 * time.Hour
+	defaultKeyRotationOverlap  = 24 * time.Hour
+	maxKeyRotationCheck        = time.Hour
+)
+
+var ErrInvalidKeyRotation = errors.New("invalid key rotation config")
+
+// KeyRotationConfig makes the gateway generate its signing keys in the local key set, on a schedule:
+//   - the next key is added with an nbf member one overlap before the end of the period of the active one,
+//     so it is published before it is used
+//   - once its nbf is reached, the signers with key_selection start using it
+//   - the previous key keeps being published for another overlap, so the tokens it signed can still be
+//     validated, and then it is removed from the key set
+//
+// The overlap must be longer than the cache durations of the clients of the key set and the lifetime of
+// the tokens signed.
+type KeyRotationConfig struct {
+	// Alg is the algorithm of the keys: RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or
+	// EdDSA
+	Alg string `json:"alg"`
+	// KeyIDPrefix is prepended to the thumbprint of the keys to build their kid
+	KeyIDPrefix string `json:"kid_prefix,omitempty"`
+	// Interval is the time each key is used for signing, in seconds or as "720h". Defaults to 30 days
+	Interval Seconds `json:"interval,omitempty"`
+	// Overlap is the time a key is published before and after it is used, in seconds or as "24h". Defaults
+	// to 1 day
+	Overlap Seconds `json:"overlap,omitempty"`
+}
+
+func (c *KeyRotationConfig) durations() (time.Duration, time.Duration) {
+	interval, overlap := c.Interval.Duration(), c.Overlap.Duration()
+	if interval == 0 {
+		interval = defaultKeyRotationInterval
+	}
+	if overlap == 0 {
+		overlap = defaultKeyRotationOverlap
+	}
+	return interval, overlap
+}
+
+func (c *KeyRotationConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if _, err := KeyTypeForAlg(c.Alg); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidKeyRotation, err.Error())
+	}
+	if interval, overlap := c.durations(); overlap >= interval {
+		return fmt.Errorf("%w: the overlap is not shorter than the interval", ErrInvalidKeyRotation)
+	}
+	return nil
+}
+
+// KeyManager rotates the signing keys of the gateway in a local key set file. Only one gateway instance
+// should run it. The others can read the file from a shared volume.
+type KeyManager struct {
+	cfg      *KeyRotationConfig
+	path     string
+	interval time.Duration
+	overlap  time.Duration
+	mu       sync.Mutex
+}
+
+// NewKeyManager creates a KeyManager for the key set at path and rotates its keys, creating the file if it
+// does not exist
+func NewKeyManager(path string, cfg *KeyRotationConfig) (*KeyManager, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%w: no key set path", ErrInvalidKeyRotation)
+	}
+	interval, overlap := cfg.durations()
+	m := &KeyManager{cfg: cfg, path: path, interval: interval, overlap: overlap}
+	if err := m.Rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Run rotates the keys until the context is done. The errors are passed to onError, if not nil.
+func (m *KeyManager) Run(ctx context.Context, onError func(error)) {
+	check := m.overlap / 4
+	if check > maxKeyRotationCheck {
+		check = maxKeyRotationCheck
+	}
+	t := time.NewTicker(check)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if err := m.Rotate(now); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Rotate removes the expired keys and adds the next one, if the active key is about to be replaced
+func (m *KeyManager) Rotate(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	ts := float64(now.Unix())
+	res := make([]map[string]interface{}, 0, len(keys)+1)
+	var newest *selectableKey
+	for i := range keys {
+		k := keys[i]
+		if exp, ok := k.number("exp"); ok && exp <= ts {
+			continue
+		}
+		res = append(res, k.attrs)
+		if m.managed(&k) && (newest == nil || k.newerThan(newest)) {
+			newest = &keys[i]
+		}
+	}
+
+	nbf := now
+	if newest != nil {
+		current, _ := newest.number("nbf")
+		next := time.Unix(int64(current), 0).Add(m.interval)
+		if now.Before(next.Add(-m.overlap)) {
+			if len(res) == len(keys) {
+				return nil
+			}
+			return m.save(res)
+		}
+		if next.After(now) {
+			nbf = next
+		}
+	}
+
+	key, err := m.generate(now, nbf)
+	if err != nil {
+		return err
+	}
+	return m.save(append(res, key))
+}
+
+func (m *KeyManager) managed(k *selectableKey) bool {
+	if k.Algorithm != m.cfg.Alg || k.IsPublic() {
+		return false
+	}
+	_, ok := k.number("nbf")
+	return ok && len(k.KeyID) >= len(m.cfg.KeyIDPrefix) && k.KeyID[:len(m.cfg.KeyIDPrefix)] == m.cfg.KeyIDPrefix
+}
+
+func (m *KeyManager) generate(now, nbf time.Time) (map[string]interface{}, error) {
+	data, err := GenerateJWK("", m.cfg.Alg, "sig", m.cfg.KeyIDPrefix)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+	attrs["iat"] = now.Unix()
+	attrs["nbf"] = nbf.Unix()
+	attrs["exp"] = nbf.Add(m.interval + m.overlap).Unix()
+	return attrs, nil
+}
+
+func (m *KeyManager) load() ([]selectableKey, error) {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSelectableKeys(data)
+}
+
+func (m *KeyManager) save(keys []map[string]interface{}) error {
+	data, err := json.MarshalIndent(map[string]interface{}{"keys": keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, data)
+}
@@ -0,0 +1,98 @@
+package jose
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyManager_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	cfg := &KeyRotationConfig{Alg: "ES256", KeyIDPrefix: "gw-", Interval: Seconds(10 * 3600), Overlap: Seconds(2 * 3600)}
+	m, err := NewKeyManager(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() []selectableKey {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := parseSelectableKeys(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	active := func(now time.Time) string {
+		k, err := selectSigningKey(load(), "ES256", "gw-", &KeySelection{}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.KeyID
+	}
+	published := func(now time.Time) int {
+		return len(publicKeySet(load(), now).Keys)
+	}
+
+	start := time.Now()
+	first := active(start)
+	if published(start) != 1 {
+		t.Fatalf("unexpected key set: %d keys", published(start))
+	}
+
+	// nothing to do before the overlap
+	at := start.Add(7 * time.Hour)
+	if err := m.Rotate(at); err != nil {
+		t.Fatal(err)
+	}
+	if published(at) != 1 {
+		t.Errorf("unexpected key set: %d keys", published(at))
+	}
+
+	// the next key is published before it is used
+	at = start.Add(8*time.Hour + time.Minute)
+	if err := m.Rotate(at); err != nil {
+		t.Fatal(err)
+	}
+	if published(at) != 2 || active(at) != first {
+		t.Errorf("unexpected key set: %d keys, active %s", published(at), active(at))
+	}
+
+	// and used once the interval of the first one is over
+	at = start.Add(10*time.Hour + time.Minute)
+	if err := m.Rotate(at); err != nil {
+		t.Fatal(err)
+	}
+	second := active(at)
+	if published(at) != 2 || second == first {
+		t.Errorf("unexpected key set: %d keys, active %s", published(at), second)
+	}
+
+	// the first key is retired after the overlap
+	at = start.Add(12*time.Hour + time.Minute)
+	if err := m.Rotate(at); err != nil {
+		t.Fatal(err)
+	}
+	if keys := load(); len(keys) != 1 || keys[0].KeyID != second {
+		t.Errorf("unexpected key set: %+v", keys)
+	}
+}
+
+func TestKeyRotationConfig_validate(t *testing.T) {
+	for _, cfg := range []*KeyRotationConfig{
+		{},
+		{Alg: "HS256"},
+		{Alg: "RS256", Interval: Seconds(3600), Overlap: Seconds(3600)},
+	} {
+		if err := cfg.validate(); !errors.Is(err, ErrInvalidKeyRotation) {
+			t.Errorf("%+v: unexpected error %v", cfg, err)
+		}
+	}
+	if _, err := NewKeyManager("", &KeyRotationConfig{Alg: "EdDSA"}); !errors.Is(err, ErrInvalidKeyRotation) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// KeySelection defines how the signer picks the active key of a key set. The selection is enabled
+// when the signer has no kid or when a key_selection config is present. In the later case, the kid (if
+// defined) is used as a prefix for filtering the candidates.
+//
+// Candidates must be signing keys with private material, matching the signer alg (if the key declares one)
+// and all the configured attributes. Keys with an nbf member in the future or an exp member in the past are
+// ignored. The newest candidate (by nbf, then iat and then kid) is selected.
+type KeySelection struct {
+	// Use is the expected value of the use member of the keys. Defaults to "sig"
+	Use string `json:"use,omitempty"`
+	// Attributes are custom members that must be present in the key with the given value
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// CheckInterval is the time between key set reloads, in seconds or as "1m". Defaults to 60
+	CheckInterval Seconds `json:"check_interval,omitempty"`
+}
+
+const defaultKeySelectionInterval = time.Minute
+
+var ErrNoSigningKey = errors.New("no active signing key found in the key set")
+
+type selectableKey struct {
+	jose.JSONWebKey
+	attrs map[string]interface{}
+}
+
+func (s selectableKey) number(name string) (float64, bool) {
+	v, ok := s.attrs[name].(float64)
+	return v, ok
+}
+
+func (s selectableKey) allowsOp(op string) bool {
+	ops, ok := s.attrs["key_ops"].([]interface{})
+	if !ok {
+		return true
+	}
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSelectableKeys(data []byte) ([]selectableKey, error) {
+	raw := struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	keys := make([]selectableKey, 0, len(raw.Keys))
+	for i, r := range raw.Keys {
+		k := selectableKey{}
+		if err := k.JSONWebKey.UnmarshalJSON(r); err != nil {
+			return nil, fmt.Errorf("decoding key #%d: %s", i, err.Error())
+		}
+		if err := json.Unmarshal(r, &k.attrs); err != nil {
+			return nil, fmt.Errorf("decoding key #%d: %s", i, err.Error())
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func selectSigningKey(keys []selectableKey, alg, kidPrefix string, sel *KeySelection, now time.Time) (jose.JSONWebKey, error) {
+	use := sel.Use
+	if use == "" {
+		use = "sig"
+	}
+	ts := float64(now.Unix())
+
+	var selected *selectableKey
+	for i := range keys {
+		k := keys[i]
+		if k.Use != "" && k.Use != use {
+			continue
+		}
+		if !k.allowsOp("sign") {
+			continue
+		}
+		if k.Algorithm != "" && k.Algorithm != alg {
+			continue
+		}
+		if k.IsPublic() {
+			continue
+		}
+		if !strings.HasPrefix(k.KeyID, kidPrefix) {
+			continue
+		}
+		if nbf, ok := k.number("nbf"); ok && nbf > ts {
+			continue
+		}
+		if exp, ok := k.number("exp"); ok && exp <= ts {
+			continue
+		}
+		if !k.hasAttributes(sel.Attributes) {
+			continue
+		}
+		if selected == nil || k.newerThan(selected) {
+			selected = &keys[i]
+		}
+	}
+
+	if selected == nil {
+		return jose.JSONWebKey{}, ErrNoSigningKey
+	}
+	return selected.JSONWebKey, nil
+}
+
+func (s selectableKey) hasAttributes(attrs map[string]string) bool {
+	for name, expected := range attrs {
+		v, ok := s.attrs[name]
+		if !ok || fmt.Sprintf("%v", v) != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func (s selectableKey) newerThan(other *selectableKey) bool {
+	for _, name := range []string{"nbf", "iat"} {
+		a, _ := s.number(name)
+		b, _ := other.number(name)
+		if a != b {
+			return a > b
+		}
+	}
+	return s.KeyID > other.KeyID
+}
+
+func loadKeySet(cfg SecretProviderConfig, client *http.Client) ([]byte, error) {
+	if cfg.LocalPath != "" {
+		return readLocalKeySet(cfg)
+	}
+	if cfg.Kubernetes != nil {
+		return loadKubernetesKeySet(cfg)
+	}
+	if cfg.KVStore != nil {
+		return loadKVKeySet(cfg)
+	}
+
+	resp, err := client.Get(cfg.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from the JWK service: %d", resp.StatusCode)
+	}
+	if contentH := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentH, "application/json") {
+		return nil, auth0.ErrInvalidContentType
+	}
+	return io.ReadAll(resp.Body)
+}
+
+type rotatingSigner struct {
+	mu       sync.RWMutex
+	kid      string
+	signer   Signer
+	next     time.Time
+	interval time.Duration
+	load     func(time.Time) (jose.JSONWebKey, error)
+	build    func(jose.JSONWebKey) (Signer, error)
+}
+
+func newRotatingSigner(signerCfg *SignerConfig, spcfg SecretProviderConfig, te auth0.RequestTokenExtractor) (Signer, error) {
+	sel := signerCfg.KeySelection
+	if sel == nil {
+		sel = &KeySelection{}
+	}
+
+	var client *http.Client
+	if spcfg.LocalPath == "" && spcfg.Kubernetes == nil && spcfg.KVStore == nil {
+		opts, err := newJWKClientOptions(spcfg)
+		if err != nil {
+			return nopSigner, err
+		}
+		client = opts.Client
+	}
+
+	interval := sel.CheckInterval.Duration()
+	if interval == 0 {
+		interval = defaultKeySelectionInterval
+	}
+
+	r := &rotatingSigner{
+		interval: interval,
+		load: func(now time.Time) (jose.JSONWebKey, error) {
+			data, err := loadKeySet(spcfg, client)
+			if err != nil {
+				return jose.JSONWebKey{}, err
+			}
+			keys, err := parseSelectableKeys(data)
+			if err != nil {
+				return jose.JSONWebKey{}, err
+			}
+			return selectSigningKey(keys, signerCfg.Alg, signerCfg.KeyID, sel, now)
+		},
+		build: func(key jose.JSONWebKey) (Signer, error) {
+			return newKeySigner(signerCfg, key, te)
+		},
+	}
+
+	if err := r.refresh(time.Now()); err != nil {
+		return nopSigner, err
+	}
+	return r.Sign, nil
+}
+
+// Sign signs the payload with the active key, reloading the key set when the check interval is over.
+// If the reload fails, the previous key is used.
+func (r *rotatingSigner) Sign(v interface{}) (string, error) {
+	now := time.Now()
+
+	r.mu.RLock()
+	expired := now.After(r.next)
+	r.mu.RUnlock()
+
+	if expired {
+		r.refresh(now)
+	}
+
+	r.mu.RLock()
+	s := r.signer
+	r.mu.RUnlock()
+
+	return s(v)
+}
+
+func (r *rotatingSigner) refresh(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.signer != nil && !now.After(r.next) {
+		return nil
+	}
+	r.next = now.Add(r.interval)
+
+	key, err := r.load(now)
+	if err != nil {
+		return err
+	}
+	if r.signer != nil && key.KeyID == r.kid {
+		return nil
+	}
+
+	s, err := r.build(key)
+	if err != nil {
+		return err
+	}
+	r.kid = key.KeyID
+	r.signer = s
+	return nil
+}
@@ -0,0 +1,192 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestSelectSigningKey(t *testing.T) {
+	now := time.Now()
+	keys := []selectableKey{
+		newSelectableKey(t, "enc-key", map[string]interface{}{"use": "enc"}),
+		newSelectableKey(t, "gw-2020", map[string]interface{}{"nbf": float64(now.Add(-48 * time.Hour).Unix())}),
+		newSelectableKey(t, "gw-2021", map[string]interface{}{"nbf": float64(now.Add(-24 * time.Hour).Unix()), "status": "active"}),
+		newSelectableKey(t, "gw-2022", map[string]interface{}{"nbf": float64(now.Add(time.Hour).Unix())}),
+		newSelectableKey(t, "gw-expired", map[string]interface{}{"exp": float64(now.Add(-time.Hour).Unix())}),
+		newSelectableKey(t, "gw-verify", map[string]interface{}{"key_ops": []interface{}{"verify"}}),
+		newSelectableKey(t, "other", map[string]interface{}{"iat": float64(now.Unix())}),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		prefix   string
+		sel      *KeySelection
+		expected string
+	}{
+		{
+			name:     "newest",
+			sel:      &KeySelection{},
+			expected: "gw-2021",
+		},
+		{
+			name:     "prefix",
+			prefix:   "oth",
+			sel:      &KeySelection{},
+			expected: "other",
+		},
+		{
+			name:     "attributes",
+			sel:      &KeySelection{Attributes: map[string]string{"status": "active"}},
+			expected: "gw-2021",
+		},
+		{
+			name:     "use",
+			prefix:   "enc",
+			sel:      &KeySelection{Use: "enc"},
+			expected: "enc-key",
+		},
+		{
+			name:   "wrong_use",
+			prefix: "enc",
+			sel:    &KeySelection{},
+		},
+		{
+			name:   "unknown",
+			prefix: "unknown",
+			sel:    &KeySelection{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := selectSigningKey(keys, "ES256", tc.prefix, tc.sel, now)
+			if tc.expected == "" {
+				if err != ErrNoSigningKey {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if key.KeyID != tc.expected {
+				t.Errorf("unexpected key. have %s, want %s", key.KeyID, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewSigner_keyRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+
+	now := time.Now()
+	first := newSelectableKey(t, "first", map[string]interface{}{"nbf": float64(now.Add(-time.Hour).Unix())})
+	writeKeySet(t, path, first)
+
+	cfg := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			SignerNamespace: map[string]interface{}{
+				"alg":                  "ES256",
+				"jwk_local_path":       path,
+				"disable_jwk_security": true,
+			},
+		},
+	}
+	_, signer, err := NewSigner(cfg, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	token, err := signer(map[string]interface{}{"sub": "foo"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	parseUnverified(t, token, "first")
+
+	second := newSelectableKey(t, "second", map[string]interface{}{"nbf": float64(now.Unix())})
+	writeKeySet(t, path, first, second)
+
+	r := &rotatingSigner{
+		interval: time.Nanosecond,
+		load: func(now time.Time) (jose.JSONWebKey, error) {
+			data, err := readLocalKeySet(SecretProviderConfig{LocalPath: path})
+			if err != nil {
+				return jose.JSONWebKey{}, err
+			}
+			keys, err := parseSelectableKeys(data)
+			if err != nil {
+				return jose.JSONWebKey{}, err
+			}
+			return selectSigningKey(keys, "ES256", "", &KeySelection{}, now)
+		},
+		build: func(key jose.JSONWebKey) (Signer, error) {
+			return newKeySigner(&SignerConfig{Alg: "ES256"}, key, nil)
+		},
+	}
+	if err := r.refresh(now); err != nil {
+		t.Error(err)
+		return
+	}
+
+	token, err = r.Sign(map[string]interface{}{"sub": "foo"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	parseUnverified(t, token, "second")
+
+	os.Remove(path)
+	time.Sleep(time.Millisecond)
+
+	token, err = r.Sign(map[string]interface{}{"sub": "foo"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	parseUnverified(t, token, "second")
+}
+
+func newSelectableKey(t *testing.T, kid string, attrs map[string]interface{}) selectableKey {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := jose.JSONWebKey{Key: pk, KeyID: kid, Algorithm: "ES256"}
+	if use, ok := attrs["use"].(string); ok {
+		k.Use = use
+	}
+	b, err := k.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]interface{}{}
+	json.Unmarshal(b, &all)
+	for name, v := range attrs {
+		all[name] = v
+	}
+	return selectableKey{JSONWebKey: k, attrs: all}
+}
+
+func writeKeySet(t *testing.T, path string, keys ...selectableKey) {
+	set := struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.attrs)
+	}
+	b, _ := json.Marshal(set)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
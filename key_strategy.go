@@ -0,0 +1,95 @@
+package jose
+
+import (
+	"sort"
+	"sync"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// KeyStrategy identifies the key of a token in the key set: the key whose id matches the key id of the
+// token is used to verify it. The strategies are selected by name with the key_identify_strategy of the
+// signature config.
+type KeyStrategy interface {
+	// TokenKeyID returns the key id of the token
+	TokenKeyID(*jwt.JSONWebToken) string
+	// KeyID returns the id of a key of the key set
+	KeyID(*jose.JSONWebKey) string
+}
+
+// KeyPreferrer is implemented by the strategies choosing between the keys of the key set with the same
+// id, as the EC keys over the RSA ones. Without preference, the last key of the key set is used.
+type KeyPreferrer interface {
+	// Prefer returns true if the candidate must replace the current key
+	Prefer(candidate, current *jose.JSONWebKey) bool
+}
+
+// KeyStrategyFuncs is a KeyStrategy built from a pair of getters
+type KeyStrategyFuncs struct {
+	Token TokenKeyIDGetterFunc
+	Key   KeyIDGetterFunc
+}
+
+// TokenKeyID implements the KeyStrategy interface
+func (s KeyStrategyFuncs) TokenKeyID(token *jwt.JSONWebToken) string {
+	return s.Token(token)
+}
+
+// KeyID implements the KeyStrategy interface
+func (s KeyStrategyFuncs) KeyID(key *jose.JSONWebKey) string {
+	return s.Key(key)
+}
+
+var (
+	keyStrategies = map[string]KeyStrategy{
+		"kid":        KeyStrategyFuncs{Token: DefaultTokenKeyIDGetter, Key: DefaultKeyIDGetter},
+		"x5t":        KeyStrategyFuncs{Token: X5TTokenKeyIDGetter, Key: X5TKeyIDGetter},
+		"kid_x5t":    KeyStrategyFuncs{Token: CompoundX5TTokenKeyIDGetter, Key: CompoundX5TKeyIDGetter},
+		"x5t_s256":   KeyStrategyFuncs{Token: X5TS256TokenKeyIDGetter, Key: X5TS256KeyIDGetter},
+		"thumbprint": KeyStrategyFuncs{Token: DefaultTokenKeyIDGetter, Key: ThumbprintKeyIDGetter},
+	}
+	keyStrategiesMu sync.RWMutex
+)
+
+// RegisterKeyStrategy adds a strategy (as the match by use and alg) to the ones available in the
+// key_identify_strategy of the signature config. The built-in strategies can be replaced.
+func RegisterKeyStrategy(name string, s KeyStrategy) {
+	keyStrategiesMu.Lock()
+	keyStrategies[name] = s
+	keyStrategiesMu.Unlock()
+}
+
+// KeyStrategies returns the names of the available strategies
+func KeyStrategies() []string {
+	keyStrategiesMu.RLock()
+	names := make([]string, 0, len(keyStrategies))
+	for name := range keyStrategies {
+		names = append(names, name)
+	}
+	keyStrategiesMu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+func keyStrategy(name string) (KeyStrategy, bool) {
+	keyStrategiesMu.RLock()
+	s, ok := keyStrategies[name]
+	keyStrategiesMu.RUnlock()
+	return s, ok
+}
+
+// lookupKeyStrategy returns the strategy of the name, or the kid one if it is unknown
+func lookupKeyStrategy(name string) KeyStrategy {
+	if s, ok := keyStrategy(name); ok {
+		return s
+	}
+	s, _ := keyStrategy("kid")
+	return s
+}
+
+// keyPreferrer returns the preference of the strategy of the name, if any
+func keyPreferrer(name string) KeyPreferrer {
+	p, _ := lookupKeyStrategy(name).(KeyPreferrer)
+	return p
+}
@@ -0,0 +1,148 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type useStrategy struct{}
+
+func (useStrategy) TokenKeyID(*jwt.JSONWebToken) string { return "sig" }
+
+func (useStrategy) KeyID(key *jose.JSONWebKey) string { return key.Use }
+
+func (useStrategy) Prefer(candidate, _ *jose.JSONWebKey) bool {
+	_, ok := candidate.Key.(*ecdsa.PublicKey)
+	return ok
+}
+
+func TestRegisterKeyStrategy(t *testing.T) {
+	RegisterKeyStrategy("use_prefer_ec", useStrategy{})
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []jose.JSONWebKey{
+		{Key: &rsaKey.PublicKey, KeyID: "rsa-1", Use: "sig", Algorithm: "RS256"},
+		{Key: &ecKey.PublicKey, KeyID: "ec-1", Use: "sig", Algorithm: "ES256"},
+		{Key: &rsaKey.PublicKey, KeyID: "rsa-2", Use: "sig", Algorithm: "RS256"},
+	}
+
+	token := &jwt.JSONWebToken{Headers: []jose.Header{{KeyID: "rsa-2"}}}
+	keyID := TokenIDGetterFactory("use_prefer_ec").Get(token)
+	if keyID != "sig" {
+		t.Errorf("unexpected key id: %s", keyID)
+	}
+
+	kc := NewMemoryKeyCacher(0, 0, "use_prefer_ec")
+	k, err := kc.Add(keyID, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.KeyID != "ec-1" {
+		t.Errorf("the EC key should be preferred: %s", k.KeyID)
+	}
+
+	b, err := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fkc, err := NewFileKeyCacher(b, "use_prefer_ec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := fkc.Get(keyID); err != nil || k.KeyID != "ec-1" {
+		t.Errorf("unexpected key: %v %v", k, err)
+	}
+
+	// without preference, the last key with the same id is used
+	fkc, err = NewFileKeyCacher(b, "kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := fkc.Get("rsa-2"); err != nil || k.KeyID != "rsa-2" {
+		t.Errorf("unexpected key: %v %v", k, err)
+	}
+
+	if errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks", KeyIdentifyStrategy: "use_prefer_ec"}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestX5TS256TokenKeyIDGetter(t *testing.T) {
+	token := &jwt.JSONWebToken{Headers: []jose.Header{{
+		KeyID:        "kid",
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"x5t#S256": "thumbprint"},
+	}}}
+	if id := TokenIDGetterFactory("x5t_s256").Get(token); id != "thumbprint" {
+		t.Errorf("unexpected key id: %s", id)
+	}
+	if id := TokenIDGetterFactory("unknown").Get(token); id != "kid" {
+		t.Errorf("unexpected key id: %s", id)
+	}
+	key := &jose.JSONWebKey{CertificateThumbprintSHA256: []byte{1, 2, 3}}
+	if id := KeyIDGetterFactory("x5t_s256").Get(key); id != "AQID" {
+		t.Errorf("unexpected key id: %s", id)
+	}
+}
+
+func TestThumbprintKeyStrategy(t *testing.T) {
+	data, err := GenerateJWK("", "ES256", "sig", "arbitrary-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key jose.JSONWebKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, _ := Thumbprint(key.Key)
+	key.KeyID = "arbitrary"
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	b, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key}})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSigner(&SignerConfig{Alg: "ES256", KeyID: "arbitrary", LocalPath: path, ThumbprintKeyID: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := s(map[string]interface{}{"sub": "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := TokenIDGetterFactory("thumbprint").Get(token)
+	if keyID != thumbprint {
+		t.Errorf("unexpected key id: %s", keyID)
+	}
+
+	b, _ = json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}})
+	fkc, err := NewFileKeyCacher(b, "thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fkc.Get(keyID); err != nil {
+		t.Error(err)
+	}
+	if _, err := fkc.Get("arbitrary"); err == nil {
+		t.Error("the key must be identified by its thumbprint")
+	}
+}
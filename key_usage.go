@@ -0,0 +1,88 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// KeyUsageConfig drops from the key sets the keys not meant to verify signatures, as the encryption keys
+// published in the same JWK set. The keys with a use member other than sig, or with a key_ops member without
+// verify, are always dropped.
+type KeyUsageConfig struct {
+	// RequireUse drops the keys without the use member too
+	RequireUse bool `json:"require_use,omitempty"`
+	// RequireKeyOps drops the keys without the key_ops member too
+	RequireKeyOps bool `json:"require_key_ops,omitempty"`
+}
+
+// verifies returns true if the use and the key_ops members of the key allow the signature verification
+func (c *KeyUsageConfig) verifies(use *string, keyOps []string) bool {
+	if use == nil {
+		if c.RequireUse {
+			return false
+		}
+	} else if *use != "sig" {
+		return false
+	}
+	if keyOps == nil {
+		return !c.RequireKeyOps
+	}
+	return stringInSlice("verify", keyOps)
+}
+
+// filterKeySet returns the JWK set without the keys not allowed by the config
+func filterKeySet(data []byte, cfg *KeyUsageConfig) ([]byte, error) {
+	raw := struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	keys := make([]json.RawMessage, 0, len(raw.Keys))
+	for i, r := range raw.Keys {
+		members := struct {
+			Use    *string  `json:"use"`
+			KeyOps []string `json:"key_ops"`
+		}{}
+		if err := json.Unmarshal(r, &members); err != nil {
+			return nil, fmt.Errorf("decoding key #%d: %s", i, err.Error())
+		}
+		if cfg.verifies(members.Use, members.KeyOps) {
+			keys = append(keys, r)
+		}
+	}
+	raw.Keys = keys
+	return json.Marshal(raw)
+}
+
+// keyUsageTransport filters the key sets downloaded by the JWK clients
+type keyUsageTransport struct {
+	next http.RoundTripper
+	cfg  *KeyUsageConfig
+}
+
+func (t keyUsageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := filterKeySet(body, t.cfg)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(keySet))
+	resp.ContentLength = int64(len(keySet))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(keySet)))
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+package jose
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFilterKeySet(t *testing.T) {
+	data := []byte(`{"keys":[
+		{"kid":"sig","use":"sig"},
+		{"kid":"enc","use":"enc"},
+		{"kid":"none"},
+		{"kid":"verify","key_ops":["verify"]},
+		{"kid":"encrypt","key_ops":["encrypt","decrypt"]},
+		{"kid":"both","use":"sig","key_ops":["sign","verify"]}
+	]}`)
+
+	for _, tc := range []struct {
+		name     string
+		cfg      KeyUsageConfig
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"sig", "none", "verify", "both"},
+		},
+		{
+			name:     "require use",
+			cfg:      KeyUsageConfig{RequireUse: true},
+			expected: []string{"sig", "both"},
+		},
+		{
+			name:     "require key_ops",
+			cfg:      KeyUsageConfig{RequireKeyOps: true},
+			expected: []string{"verify", "both"},
+		},
+	} {
+		res, err := filterKeySet(data, &tc.cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		keySet := struct {
+			Keys []struct {
+				KeyID string `json:"kid"`
+			} `json:"keys"`
+		}{}
+		if err := json.Unmarshal(res, &keySet); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var kids []string
+		for _, k := range keySet.Keys {
+			kids = append(kids, k.KeyID)
+		}
+		if len(kids) != len(tc.expected) {
+			t.Errorf("%s: unexpected keys: %v", tc.name, kids)
+			continue
+		}
+		for i := range kids {
+			if kids[i] != tc.expected[i] {
+				t.Errorf("%s: unexpected keys: %v", tc.name, kids)
+				break
+			}
+		}
+	}
+}
+
+func TestSecretProvider_keyUsage(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	for _, cfg := range []SecretProviderConfig{
+		{URI: server.URL, KeyUsage: &KeyUsageConfig{}},
+		{LocalPath: "./fixtures/public.json", KeyUsage: &KeyUsageConfig{}},
+	} {
+		sp, err := SecretProvider(cfg, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if _, err := sp.GetKey("1"); err == nil {
+			t.Error("the encryption key should be dropped")
+		}
+		if _, err := sp.GetKey("2011-04-29"); err != nil {
+			t.Errorf("the key without use should be kept: %v", err)
+		}
+	}
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, KeyUsage: &KeyUsageConfig{RequireUse: true}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err == nil {
+		t.Error("the key without use should be dropped")
+	}
+	if _, err := sp.GetKey("p256"); err != nil {
+		t.Errorf("the signature key should be kept: %v", err)
+	}
+}
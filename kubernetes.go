@@ -0,0 +1,381 @@
+package jose
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	auth0 "github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	KubernetesKindSecret    = "secret"
+	KubernetesKindConfigMap = "configmap"
+
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesWatchTimeout      = 5 * time.Minute
+	kubernetesWatchBackoff      = 5 * time.Second
+)
+
+var (
+	ErrInvalidKubernetes = errors.New("invalid kubernetes key source")
+	ErrKubernetesAPI     = errors.New("kubernetes API error")
+)
+
+// KubernetesConfig reads the keys from a Kubernetes secret or config map through the API, for the clusters
+// where mounting them as files is not allowed. The value of the key is parsed as the local files (a JWK set,
+// a single JWK or PEM encoded certificates and keys), or used as an HMAC shared secret. The keys are reloaded
+// every time the resource changes.
+type KubernetesConfig struct {
+	// Kind is the kind of the resource: secret (the default) or configmap
+	Kind string `json:"kind,omitempty"`
+	// Namespace defaults to the namespace of the pod
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Key is the entry of the resource data holding the keys
+	Key string `json:"key"`
+	// SharedSecret uses the raw value as the key of the HMAC algorithms, identified by the KeyID
+	SharedSecret bool   `json:"shared_secret,omitempty"`
+	KeyID        string `json:"kid,omitempty"`
+	// APIServer defaults to the in-cluster address of the API
+	APIServer string `json:"api_server,omitempty"`
+	// TokenPath is the file with the bearer token. Defaults to the token of the service account of the pod
+	TokenPath string `json:"token_path,omitempty"`
+	// CAPath is the file with the CA of the API server. Defaults to the CA of the service account of the
+	// pod when the APIServer is not set
+	CAPath             string `json:"ca_path,omitempty"`
+	DisableWatch       bool   `json:"disable_watch,omitempty"`
+	DisableAPISecurity bool   `json:"disable_api_security,omitempty"`
+}
+
+func (c *KubernetesConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Kind {
+	case "", KubernetesKindSecret, KubernetesKindConfigMap:
+	default:
+		return fmt.Errorf("%w: unknown kind %q. Supported values: secret, configmap", ErrInvalidKubernetes, c.Kind)
+	}
+	if c.Name == "" || c.Key == "" {
+		return fmt.Errorf("%w: both the name and the key must be defined", ErrInvalidKubernetes)
+	}
+	if c.SharedSecret && c.KeyID == "" {
+		return fmt.Errorf("%w: the shared secret requires a kid", ErrInvalidKubernetes)
+	}
+	if c.APIServer != "" && !validJWKSource(c.APIServer, c.DisableAPISecurity) {
+		return fmt.Errorf("%w: %q is not an https URL and disable_api_security is not set", ErrInvalidKubernetes, c.APIServer)
+	}
+	return nil
+}
+
+type kubernetesObject struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string]string `json:"binaryData"`
+}
+
+type kubernetesEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubernetesStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// kubernetesSource gets and watches a secret or a config map
+type kubernetesSource struct {
+	cfg       *KubernetesConfig
+	resources string
+	tokenPath string
+	client    *http.Client
+	watcher   *http.Client
+	backoff   time.Duration
+}
+
+func newKubernetesSource(cfg *KubernetesConfig, timeout time.Duration) (*kubernetesSource, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	server, caPath := cfg.APIServer, cfg.CAPath
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("%w: not running in a cluster and no api_server defined", ErrInvalidKubernetes)
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+		if caPath == "" {
+			caPath = filepath.Join(kubernetesServiceAccountDir, "ca.crt")
+		}
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		b, err := os.ReadFile(filepath.Join(kubernetesServiceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: no namespace defined: %s", ErrInvalidKubernetes, err.Error())
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	tokenPath := cfg.TokenPath
+	if tokenPath == "" {
+		tokenPath = filepath.Join(kubernetesServiceAccountDir, "token")
+	}
+
+	client, err := newOutboundClient(nil, caPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	kind := "secrets"
+	if cfg.Kind == KubernetesKindConfigMap {
+		kind = "configmaps"
+	}
+	return &kubernetesSource{
+		cfg:       cfg,
+		resources: fmt.Sprintf("%s/api/v1/namespaces/%s/%s", strings.TrimSuffix(server, "/"), url.PathEscape(namespace), kind),
+		tokenPath: tokenPath,
+		client:    client,
+		// the watch requests are long lived, so they are only limited by the timeoutSeconds param
+		watcher: &http.Client{Transport: client.Transport},
+		backoff: kubernetesWatchBackoff,
+	}, nil
+}
+
+// do sends the request with the token of the service account, read every time because it is rotated
+func (s *kubernetesSource) do(ctx context.Context, client *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(s.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKubernetesAPI, err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKubernetesAPI, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrKubernetesAPI, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (s *kubernetesSource) get(ctx context.Context) (*kubernetesObject, error) {
+	resp, err := s.do(ctx, s.client, s.resources+"/"+url.PathEscape(s.cfg.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	obj := new(kubernetesObject)
+	if err := json.NewDecoder(resp.Body).Decode(obj); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKubernetesAPI, err.Error())
+	}
+	return obj, nil
+}
+
+// value returns the decoded value of the key of the resource
+func (s *kubernetesSource) value(obj *kubernetesObject) ([]byte, error) {
+	if v, ok := obj.Data[s.cfg.Key]; ok {
+		if s.cfg.Kind == KubernetesKindConfigMap {
+			return []byte(v), nil
+		}
+		return base64.StdEncoding.DecodeString(v)
+	}
+	if v, ok := obj.BinaryData[s.cfg.Key]; ok {
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return nil, fmt.Errorf("%w: the key %q is not in %s", ErrKubernetesAPI, s.cfg.Key, s.cfg.Name)
+}
+
+// keySet returns the JWK set of the resource
+func (s *kubernetesSource) keySet(cfg SecretProviderConfig, obj *kubernetesObject) ([]byte, error) {
+	v, err := s.value(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []json.RawMessage
+	if s.cfg.SharedSecret {
+		var key []byte
+		key, err = jose.JSONWebKey{Key: bytes.TrimSpace(v), KeyID: s.cfg.KeyID, Use: "sig"}.MarshalJSON()
+		keys = []json.RawMessage{key}
+	} else {
+		keys, err = parseLocalKeys(s.cfg.Key, v, cfg.KeySetFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s: %s", ErrInvalidLocalKey, s.cfg.Name, s.cfg.Key, err.Error())
+	}
+
+	data, err := json.Marshal(rawKeySet{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeyUsage != nil {
+		return filterKeySet(data, cfg.KeyUsage)
+	}
+	return data, nil
+}
+
+// watch calls update with every new version of the resource, until the context is done. The watch is
+// restarted when the API closes it, and the resource is read again when its version is too old.
+func (s *kubernetesSource) watch(ctx context.Context, version string, update func(*kubernetesObject) error, status *providerStatus) {
+	failed := func(err error) {
+		if status != nil {
+			status.failed(err)
+		}
+	}
+
+	for ctx.Err() == nil {
+		if version == "" {
+			obj, err := s.get(ctx)
+			if err == nil {
+				err = update(obj)
+			}
+			if err != nil {
+				failed(err)
+				s.sleep(ctx)
+				continue
+			}
+			version = obj.Metadata.ResourceVersion
+		}
+
+		var err error
+		version, err = s.watchOnce(ctx, version, update)
+		if err != nil && ctx.Err() == nil {
+			failed(err)
+			s.sleep(ctx)
+		}
+	}
+}
+
+// watchOnce consumes one watch stream, returning the last version seen, or an empty one if the resource
+// must be read again
+func (s *kubernetesSource) watchOnce(ctx context.Context, version string, update func(*kubernetesObject) error) (string, error) {
+	q := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + s.cfg.Name},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {fmt.Sprintf("%d", int(kubernetesWatchTimeout/time.Second))},
+	}
+	resp, err := s.do(ctx, s.watcher, s.resources+"?"+q.Encode())
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event kubernetesEvent
+		if err := dec.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return version, nil
+			}
+			return version, fmt.Errorf("%w: %s", ErrKubernetesAPI, err.Error())
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			obj := new(kubernetesObject)
+			if err := json.Unmarshal(event.Object, obj); err != nil {
+				return version, fmt.Errorf("%w: %s", ErrKubernetesAPI, err.Error())
+			}
+			if err := update(obj); err != nil {
+				return obj.Metadata.ResourceVersion, err
+			}
+			version = obj.Metadata.ResourceVersion
+		case "DELETED":
+			return "", fmt.Errorf("%w: %s has been deleted", ErrKubernetesAPI, s.cfg.Name)
+		case "ERROR":
+			st := kubernetesStatus{}
+			json.Unmarshal(event.Object, &st)
+			if st.Code == http.StatusGone {
+				return "", nil
+			}
+			return version, fmt.Errorf("%w: %s", ErrKubernetesAPI, st.Message)
+		}
+	}
+}
+
+func (s *kubernetesSource) sleep(ctx context.Context) {
+	t := time.NewTimer(s.backoff)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// loadKubernetesKeySet returns the JWK set stored in the Kubernetes resource of the config
+func loadKubernetesKeySet(cfg SecretProviderConfig) ([]byte, error) {
+	source, err := newKubernetesSource(cfg.Kubernetes, cfg.KeyFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := source.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return source.keySet(cfg, obj)
+}
+
+func newKubernetesSecretProvider(opts JWKClientOptions, cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*JWKClient, error) {
+	source, err := newKubernetesSource(cfg.Kubernetes, cfg.KeyFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := source.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	data, err := source.keySet(cfg, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCacher, err := NewFileKeyCacher(data, opts.KeyIdentifyStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.status != nil {
+		opts.status.fetched(keyCacher.size())
+	}
+	if !cfg.Kubernetes.DisableWatch {
+		update := func(obj *kubernetesObject) error {
+			data, err := source.keySet(cfg, obj)
+			if err != nil {
+				return err
+			}
+			if err := keyCacher.replace(data, opts.KeyIdentifyStrategy); err != nil {
+				return err
+			}
+			if opts.status != nil {
+				opts.status.fetched(keyCacher.size())
+			}
+			return nil
+		}
+		go source.watch(context.Background(), obj.Metadata.ResourceVersion, update, opts.status)
+	}
+	return NewJWKClientWithCache(opts, te, keyCacher), nil
+}
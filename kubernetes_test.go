@@ -0,0 +1,148 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func kubernetesTokenFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretProvider_kubernetesSecret(t *testing.T) {
+	public, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := `{"keys":[{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow","kid":"rotated","alg":"HS256"}]}`
+	secret := func(version, keys string) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": version},
+			"data":     map[string]string{"jwks.json": base64.StdEncoding.EncodeToString([]byte(keys))},
+		}
+	}
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/gateway/secrets/keys":
+			json.NewEncoder(w).Encode(secret("1", string(public)))
+		case r.URL.Path == "/api/v1/namespaces/gateway/secrets" && r.URL.Query().Get("watch") == "true":
+			if r.URL.Query().Get("fieldSelector") != "metadata.name=keys" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.URL.Query().Get("resourceVersion") == "1" {
+				<-release
+				json.NewEncoder(w).Encode(map[string]interface{}{"type": "MODIFIED", "object": secret("2", rotated)})
+				return
+			}
+			// the next watches stay open until the end of the test
+			<-done
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	sp, err := SecretProvider(SecretProviderConfig{Kubernetes: &KubernetesConfig{
+		Namespace:          "gateway",
+		Name:               "keys",
+		Key:                "jwks.json",
+		APIServer:          server.URL,
+		TokenPath:          kubernetesTokenFile(t),
+		DisableAPISecurity: true,
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Error(err)
+	}
+	if _, err := sp.GetKey("rotated"); err == nil {
+		t.Error("the rotated key should be unknown")
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := sp.GetKey("rotated"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the rotated key has not been loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err == nil {
+		t.Error("the previous keys should be replaced")
+	}
+}
+
+func TestSecretProvider_kubernetesSharedSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/gateway/configmaps/hmac" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"metadata":{"resourceVersion":"7"},"data":{"secret":"my-shared-secret\n"}}`))
+	}))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{Kubernetes: &KubernetesConfig{
+		Kind:               KubernetesKindConfigMap,
+		Namespace:          "gateway",
+		Name:               "hmac",
+		Key:                "secret",
+		SharedSecret:       true,
+		KeyID:              "shared",
+		APIServer:          server.URL,
+		TokenPath:          kubernetesTokenFile(t),
+		DisableWatch:       true,
+		DisableAPISecurity: true,
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := sp.GetKey("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := k.Key.([]byte); !ok || string(b) != "my-shared-secret" {
+		t.Errorf("unexpected key: %v", k.Key)
+	}
+}
+
+func TestKubernetesConfig_validate(t *testing.T) {
+	for _, cfg := range []*KubernetesConfig{
+		{Kind: "pod", Name: "keys", Key: "jwks.json"},
+		{Name: "keys"},
+		{Name: "keys", Key: "secret", SharedSecret: true},
+		{Name: "keys", Key: "jwks.json", APIServer: "http://10.0.0.1"},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("error expected for %+v", cfg)
+		}
+	}
+	if errs := ValidateConfig(&SignatureConfig{Alg: "RS256", Kubernetes: &KubernetesConfig{Name: "keys", Key: "jwks.json"}}); errs != nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,378 @@
+package jose
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	auth0 "github.com/auth0-community/go-auth0"
+)
+
+const (
+	KVBackendConsul = "consul"
+	KVBackendEtcd   = "etcd"
+
+	kvWatchTimeout = 5 * time.Minute
+	kvWatchBackoff = 5 * time.Second
+)
+
+var (
+	ErrInvalidKVStore = errors.New("invalid kv store config")
+	ErrKVStore        = errors.New("kv store error")
+)
+
+// KVStoreConfig defines a key of a Consul KV or etcd store. The key is watched, so all the gateways reading
+// it converge on its changes within seconds, without hitting the identity provider from every node.
+type KVStoreConfig struct {
+	// Backend is the store: consul or etcd
+	Backend string `json:"backend"`
+	// Address is the base URL of the HTTP API of the store, as "https://consul.service:8501"
+	Address string `json:"address"`
+	Key     string `json:"key"`
+	// Token is the ACL token of Consul, or the auth token of etcd. It accepts the references of the config
+	// values, as "@/etc/krakend/consul_token"
+	Token string `json:"token,omitempty"`
+	// Timeout limits the reads of the key, in seconds or as "2s". Defaults to 10s
+	Timeout            Seconds  `json:"timeout,omitempty"`
+	DisableWatch       bool     `json:"disable_watch,omitempty"`
+	CipherSuites       []uint16 `json:"cipher_suites,omitempty"`
+	LocalCA            string   `json:"local_ca,omitempty"`
+	DisableURLSecurity bool     `json:"disable_url_security,omitempty"`
+}
+
+func (c *KVStoreConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Backend {
+	case KVBackendConsul, KVBackendEtcd:
+	default:
+		return fmt.Errorf("%w: unknown backend %q. Supported values: consul, etcd", ErrInvalidKVStore, c.Backend)
+	}
+	if c.Address == "" || c.Key == "" {
+		return fmt.Errorf("%w: both the address and the key must be defined", ErrInvalidKVStore)
+	}
+	if !validJWKSource(c.Address, c.DisableURLSecurity) {
+		return fmt.Errorf("%w: %q is not an https URL and disable_url_security is not set", ErrInvalidKVStore, c.Address)
+	}
+	return nil
+}
+
+// kvValue is a value of the store and the version it has been read at
+type kvValue struct {
+	data    []byte
+	version string
+}
+
+// kvBackend reads the key of a store. wait blocks until the key has a version newer than the given one, or
+// the watch times out, returning the same version.
+type kvBackend interface {
+	get(ctx context.Context) (kvValue, error)
+	wait(ctx context.Context, version string) (kvValue, error)
+}
+
+// kvSource reads and watches a key of a store
+type kvSource struct {
+	cfg     *KVStoreConfig
+	backend kvBackend
+	backoff time.Duration
+}
+
+func newKVSource(cfg *KVStoreConfig) (*kvSource, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	client, err := newOutboundClient(cfg.CipherSuites, cfg.LocalCA, cfg.Timeout.Duration())
+	if err != nil {
+		return nil, err
+	}
+	c := kvClient{
+		address: strings.TrimSuffix(cfg.Address, "/"),
+		key:     cfg.Key,
+		token:   cfg.Token,
+		client:  client,
+		// the watches are long lived, so they are only limited by their own timeouts
+		watcher: &http.Client{Transport: client.Transport},
+	}
+
+	var backend kvBackend = consulBackend{c}
+	if cfg.Backend == KVBackendEtcd {
+		backend = etcdBackend{c}
+	}
+	return &kvSource{cfg: cfg, backend: backend, backoff: kvWatchBackoff}, nil
+}
+
+// watch calls update with every new value of the key, until the context is done
+func (s *kvSource) watch(ctx context.Context, version string, update func([]byte) error, failed func(error)) {
+	for ctx.Err() == nil {
+		v, err := s.backend.wait(ctx, version)
+		if err != nil {
+			if ctx.Err() == nil {
+				failed(err)
+				s.sleep(ctx)
+			}
+			continue
+		}
+		if v.version == version {
+			continue
+		}
+		if err := update(v.data); err != nil {
+			failed(err)
+		}
+		version = v.version
+	}
+}
+
+func (s *kvSource) sleep(ctx context.Context) {
+	t := time.NewTimer(s.backoff)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+type kvClient struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+	watcher *http.Client
+}
+
+func (c kvClient) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKVStore, err.Error())
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: key %q not found", ErrKVStore, c.key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrKVStore, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// consulBackend uses the blocking queries of the Consul KV API
+type consulBackend struct {
+	kvClient
+}
+
+func (b consulBackend) get(ctx context.Context) (kvValue, error) {
+	return b.read(ctx, b.client, url.Values{"raw": {""}})
+}
+
+func (b consulBackend) wait(ctx context.Context, version string) (kvValue, error) {
+	return b.read(ctx, b.watcher, url.Values{
+		"raw":   {""},
+		"index": {version},
+		"wait":  {kvWatchTimeout.String()},
+	})
+}
+
+func (b consulBackend) read(ctx context.Context, client *http.Client, q url.Values) (kvValue, error) {
+	u := fmt.Sprintf("%s/v1/kv/%s?%s", b.address, strings.TrimPrefix(b.key, "/"), q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return kvValue{}, err
+	}
+	if b.token != "" {
+		req.Header.Set("X-Consul-Token", b.token)
+	}
+	resp, err := b.do(client, req)
+	if err != nil {
+		return kvValue{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return kvValue{}, fmt.Errorf("%w: %s", ErrKVStore, err.Error())
+	}
+	return kvValue{data: data, version: resp.Header.Get("X-Consul-Index")}, nil
+}
+
+// etcdBackend uses the JSON gateway of the etcd v3 API
+type etcdBackend struct {
+	kvClient
+}
+
+type etcdKeyValue struct {
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+func (b etcdBackend) post(ctx context.Context, client *http.Client, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.address+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.token != "" {
+		req.Header.Set("Authorization", b.token)
+	}
+	return b.do(client, req)
+}
+
+func (b etcdBackend) get(ctx context.Context) (kvValue, error) {
+	resp, err := b.post(ctx, b.client, "/v3/kv/range", map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(b.key)),
+	})
+	if err != nil {
+		return kvValue{}, err
+	}
+	defer resp.Body.Close()
+
+	res := struct {
+		Kvs []etcdKeyValue `json:"kvs"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return kvValue{}, fmt.Errorf("%w: %s", ErrKVStore, err.Error())
+	}
+	if len(res.Kvs) == 0 {
+		return kvValue{}, fmt.Errorf("%w: key %q not found", ErrKVStore, b.key)
+	}
+	return res.Kvs[0].decode()
+}
+
+func (b etcdBackend) wait(ctx context.Context, version string) (kvValue, error) {
+	revision, _ := strconv.ParseInt(version, 10, 64)
+	ctx, cancel := context.WithTimeout(ctx, kvWatchTimeout)
+	defer cancel()
+
+	resp, err := b.post(ctx, b.watcher, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            base64.StdEncoding.EncodeToString([]byte(b.key)),
+			"start_revision": strconv.FormatInt(revision+1, 10),
+		},
+	})
+	if err != nil {
+		return kvValue{}, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		msg := struct {
+			Result struct {
+				Events []struct {
+					Type string       `json:"type"`
+					Kv   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}{}
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return kvValue{version: version}, nil
+			}
+			return kvValue{}, fmt.Errorf("%w: %s", ErrKVStore, err.Error())
+		}
+		events := msg.Result.Events
+		if len(events) == 0 {
+			continue
+		}
+		last := events[len(events)-1]
+		if last.Type == "DELETE" {
+			return kvValue{}, fmt.Errorf("%w: key %q deleted", ErrKVStore, b.key)
+		}
+		return last.Kv.decode()
+	}
+}
+
+func (kv etcdKeyValue) decode() (kvValue, error) {
+	data, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return kvValue{}, fmt.Errorf("%w: %s", ErrKVStore, err.Error())
+	}
+	return kvValue{data: data, version: kv.ModRevision}, nil
+}
+
+// kvKeySet returns the JWK set stored in the value
+func kvKeySet(cfg SecretProviderConfig, data []byte) ([]byte, error) {
+	keys, err := parseLocalKeys(cfg.KVStore.Key, data, cfg.KeySetFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLocalKey, cfg.KVStore.Key, err.Error())
+	}
+	res, err := json.Marshal(rawKeySet{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeyUsage != nil {
+		return filterKeySet(res, cfg.KeyUsage)
+	}
+	return res, nil
+}
+
+// loadKVKeySet returns the JWK set stored in the key of the config
+func loadKVKeySet(cfg SecretProviderConfig) ([]byte, error) {
+	source, err := newKVSource(cfg.KVStore)
+	if err != nil {
+		return nil, err
+	}
+	v, err := source.backend.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return kvKeySet(cfg, v.data)
+}
+
+func newKVSecretProvider(opts JWKClientOptions, cfg SecretProviderConfig, te auth0.RequestTokenExtractor) (*JWKClient, error) {
+	source, err := newKVSource(cfg.KVStore)
+	if err != nil {
+		return nil, err
+	}
+	v, err := source.backend.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	data, err := kvKeySet(cfg, v.data)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCacher, err := NewFileKeyCacher(data, opts.KeyIdentifyStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.status != nil {
+		opts.status.fetched(keyCacher.size())
+	}
+	if !cfg.KVStore.DisableWatch {
+		update := func(b []byte) error {
+			data, err := kvKeySet(cfg, b)
+			if err != nil {
+				return err
+			}
+			if err := keyCacher.replace(data, opts.KeyIdentifyStrategy); err != nil {
+				return err
+			}
+			if opts.status != nil {
+				opts.status.fetched(keyCacher.size())
+			}
+			return nil
+		}
+		failed := func(err error) {
+			if opts.status != nil {
+				opts.status.failed(err)
+			}
+		}
+		go source.watch(context.Background(), v.version, update, failed)
+	}
+	return NewJWKClientWithCache(opts, te, keyCacher), nil
+}
@@ -0,0 +1,186 @@
+package jose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// consulServer serves the key with the index 1 and, once released, the next value with the index 2
+func consulServer(t *testing.T, key, first, next string) (*httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/"+key || r.Header.Get("X-Consul-Token") != "acl-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("index") {
+		case "":
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(first))
+		case "1":
+			<-release
+			w.Header().Set("X-Consul-Index", "2")
+			w.Write([]byte(next))
+		default:
+			<-done
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+	return server, release
+}
+
+func waitFor(t *testing.T, msg string, f func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSecretProvider_consul(t *testing.T) {
+	public, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := `{"keys":[{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow","kid":"rotated","alg":"HS256"}]}`
+	server, release := consulServer(t, "gateway/jwks", string(public), rotated)
+
+	sp, err := SecretProvider(SecretProviderConfig{KVStore: &KVStoreConfig{
+		Backend:            KVBackendConsul,
+		Address:            server.URL,
+		Key:                "gateway/jwks",
+		Token:              "acl-token",
+		DisableURLSecurity: true,
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Error(err)
+	}
+
+	release <- struct{}{}
+	waitFor(t, "the rotated key has not been loaded", func() bool {
+		_, err := sp.GetKey("rotated")
+		return err == nil
+	})
+}
+
+func TestSecretProvider_etcd(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("/gateway/jwks"))
+	value := func(keys string) string { return base64.StdEncoding.EncodeToString([]byte(keys)) }
+	first := `{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow","kid":"first","alg":"HS256"}`
+	next := `{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow","kid":"next","alg":"HS256"}`
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			if body["key"] != key {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"kvs": []map[string]string{{"key": key, "value": value(first), "mod_revision": "5"}},
+			})
+		case "/v3/watch":
+			req, _ := body["create_request"].(map[string]interface{})
+			if req["start_revision"] != "6" {
+				<-done
+				return
+			}
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+			w.(http.Flusher).Flush()
+			<-release
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{
+				"events": []map[string]interface{}{{"kv": map[string]string{"key": key, "value": value(next), "mod_revision": "6"}}},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	sp, err := SecretProvider(SecretProviderConfig{KVStore: &KVStoreConfig{
+		Backend:            KVBackendEtcd,
+		Address:            server.URL,
+		Key:                "/gateway/jwks",
+		DisableURLSecurity: true,
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("first"); err != nil {
+		t.Error(err)
+	}
+
+	release <- struct{}{}
+	waitFor(t, "the next key has not been loaded", func() bool {
+		_, err := sp.GetKey("next")
+		return err == nil
+	})
+}
+
+func TestRevocationList(t *testing.T) {
+	server, release := consulServer(t, "gateway/revoked", "# revoked tokens\ntoken-1\n\ntoken-2\n", `["token-3"]`)
+
+	r, err := NewRevocationList(&RevocationListConfig{Store: KVStoreConfig{
+		Backend:            KVBackendConsul,
+		Address:            server.URL,
+		Key:                "gateway/revoked",
+		Token:              "acl-token",
+		DisableURLSecurity: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Reject(map[string]interface{}{"jti": "token-2"}) {
+		t.Error("token-2 should be rejected")
+	}
+	if r.Reject(map[string]interface{}{"jti": "token-3"}) || r.Reject(map[string]interface{}{"sub": "token-1"}) {
+		t.Error("the token should be accepted")
+	}
+	version := r.RevocationVersion()
+
+	release <- struct{}{}
+	waitFor(t, "the list has not been reloaded", func() bool { return r.RevocationVersion() != version })
+	if !r.Reject(map[string]interface{}{"jti": "token-3"}) || r.Reject(map[string]interface{}{"jti": "token-2"}) {
+		t.Error("the new list should be used")
+	}
+}
+
+func TestKVStoreConfig_validate(t *testing.T) {
+	for _, cfg := range []*KVStoreConfig{
+		{Backend: "zookeeper", Address: "https://zk", Key: "jwks"},
+		{Backend: KVBackendConsul, Address: "https://consul"},
+		{Backend: KVBackendEtcd, Address: "http://etcd:2379", Key: "jwks"},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("error expected for %+v", cfg)
+		}
+	}
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:            "RS256",
+		KVStore:        &KVStoreConfig{Backend: KVBackendConsul, Address: "https://consul", Key: "jwks"},
+		RevocationList: &RevocationListConfig{Store: KVStoreConfig{Backend: KVBackendEtcd, Address: "https://etcd"}},
+	})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidRevocationList {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,262 @@
+package jose
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+var ErrInvalidLocalKey = errors.New("invalid local key")
+
+type rawKeySet struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// readKeySetDir merges the keys of the files of the directory into one JWK set. The files are parsed as the
+// single local files, and the hidden files and the subdirectories are ignored, as the ..data ones of the
+// Kubernetes secret volumes.
+func readKeySetDir(cfg SecretProviderConfig) ([]byte, error) {
+	names, err := keySetDirFiles(cfg.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := rawKeySet{Keys: []json.RawMessage{}}
+	for _, name := range names {
+		data, err := readLocalFile(cfg, filepath.Join(cfg.LocalPath, name))
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseLocalKeys(name, data, cfg.KeySetFormat)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLocalKey, name, err.Error())
+		}
+		keySet.Keys = append(keySet.Keys, keys...)
+	}
+	return json.Marshal(keySet)
+}
+
+// pemKeySet converts a PEM file into a JWK set
+func pemKeySet(path string, data []byte) ([]byte, error) {
+	keys, err := parseLocalKeys(path, data, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLocalKey, path, err.Error())
+	}
+	return json.Marshal(rawKeySet{Keys: keys})
+}
+
+// keySetDirFiles returns the sorted names of the regular files of the directory, following the symlinks
+func keySetDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isPEM returns true if the data looks like PEM encoded blocks
+func isPEM(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN"))
+}
+
+// parseLocalKeys returns the keys of a local file, that can contain a JWK set, a single JWK or PEM encoded
+// certificates, public keys and private keys. The key id of the PEM keys is the name of the file without
+// extension, followed by the position of the block when the file has several of them. The JWKs are kept
+// as they are, with their custom members.
+func parseLocalKeys(name string, data []byte, format string) ([]json.RawMessage, error) {
+	if isPEM(data) {
+		keys, err := parsePEMKeys(strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), data)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]json.RawMessage, 0, len(keys))
+		for _, k := range keys {
+			b, err := k.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, b)
+		}
+		return res, nil
+	}
+	if format == KeySetFormatX509 {
+		var err error
+		if data, err = x509KeySet(data); err != nil {
+			return nil, err
+		}
+	}
+
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	keys := []json.RawMessage{data}
+	if _, ok := members["keys"]; ok {
+		keySet := rawKeySet{}
+		if err := json.Unmarshal(data, &keySet); err != nil {
+			return nil, err
+		}
+		keys = keySet.Keys
+	}
+	for i, k := range keys {
+		if err := new(jose.JSONWebKey).UnmarshalJSON(k); err != nil {
+			return nil, fmt.Errorf("decoding key #%d: %s", i, err.Error())
+		}
+	}
+	return keys, nil
+}
+
+func parsePEMKeys(kid string, data []byte) ([]jose.JSONWebKey, error) {
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("no PEM block found")
+	}
+
+	keys := make([]jose.JSONWebKey, 0, len(blocks))
+	for i, block := range blocks {
+		key := jose.JSONWebKey{KeyID: kid}
+		if len(blocks) > 1 {
+			key.KeyID = fmt.Sprintf("%s-%d", kid, i)
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				sha1sum := sha1.Sum(cert.Raw) // skipcq: GSC-G401
+				sha256sum := sha256.Sum256(cert.Raw)
+				key.Key = cert.PublicKey
+				key.Certificates = []*x509.Certificate{cert}
+				key.CertificateThumbprintSHA1 = sha1sum[:]
+				key.CertificateThumbprintSHA256 = sha256sum[:]
+			}
+		case "PUBLIC KEY":
+			key.Key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key.Key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "PRIVATE KEY":
+			key.Key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key.Key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key.Key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			err = fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// localKeySetVersion returns a value changing every time the file, or any file of the directory, is
+// modified. The symlinks are followed, so the swap of the ..data link of the Kubernetes secret volumes is
+// detected.
+func localKeySetVersion(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf("%d|%d", info.ModTime().UnixNano(), info.Size()), nil
+	}
+
+	names, err := keySetDirFiles(path)
+	if err != nil {
+		return "", err
+	}
+	var version strings.Builder
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(path, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&version, "%s|%d|%d\n", name, info.ModTime().UnixNano(), info.Size())
+	}
+	return version.String(), nil
+}
+
+// watch reloads the keys every time the local key set is modified, until the context is done. The
+// previous keys are kept when the new ones can not be loaded.
+func (f *FileKeyCacher) watch(ctx context.Context, cfg SecretProviderConfig, version, keyIdentifyStrategy string, status *providerStatus) {
+	ticker := time.NewTicker(cfg.LocalReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := localKeySetVersion(cfg.LocalPath)
+		if err != nil || current == version {
+			continue
+		}
+		if err := f.reload(cfg, keyIdentifyStrategy); err != nil {
+			if status != nil {
+				status.failed(err)
+			}
+			continue
+		}
+		version = current
+		if status != nil {
+			status.fetched(f.size())
+		}
+	}
+}
+
+func (f *FileKeyCacher) reload(cfg SecretProviderConfig, keyIdentifyStrategy string) error {
+	data, err := readLocalKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	return f.replace(data, keyIdentifyStrategy)
+}
+
+// replace swaps the keys of the cacher with the ones of the JWK set
+func (f *FileKeyCacher) replace(data []byte, keyIdentifyStrategy string) error {
+	next, err := NewFileKeyCacher(data, keyIdentifyStrategy)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.keys = next.keys
+	f.mu.Unlock()
+	return nil
+}
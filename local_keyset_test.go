@@ -0,0 +1,121 @@
+package jose
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretProvider_localDir(t *testing.T) {
+	dir := t.TempDir()
+	public, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := os.ReadFile("cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"public.json": public,
+		"google.pem":  cert,
+		".hidden":     []byte("not a key"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	sp, err := SecretProvider(SecretProviderConfig{LocalPath: dir, LocalReloadInterval: 10 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range []string{"2011-04-29", "p256", "google"} {
+		if _, err := sp.GetKey(kid); err != nil {
+			t.Errorf("%s: %v", kid, err)
+		}
+	}
+	if _, err := sp.GetKey("new"); err == nil {
+		t.Error("the new key should be unknown")
+	}
+
+	key := `{"kty":"oct","k":"AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow","kid":"new","alg":"HS256"}`
+	if err := os.WriteFile(filepath.Join(dir, "new.json"), []byte(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := sp.GetKey("new"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the new key has not been loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h := sp.Health(); h.Keys != 9 {
+		t.Errorf("unexpected health: %+v", h)
+	}
+}
+
+func TestParsePEMKeys(t *testing.T) {
+	cert, err := os.ReadFile("cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parsePEMKeys("chain", append(append([]byte{}, cert...), cert...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != "chain-0" || keys[1].KeyID != "chain-1" || !keys[0].IsPublic() {
+		t.Errorf("unexpected keys: %+v", keys)
+	}
+	if len(keys[0].CertificateThumbprintSHA1) == 0 || len(keys[0].CertificateThumbprintSHA256) == 0 {
+		t.Error("the certificate thumbprints should be set")
+	}
+	if _, err := parsePEMKeys("empty", []byte("-----BEGIN nothing")); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestSecretProvider_localPEM(t *testing.T) {
+	sp, err := SecretProvider(SecretProviderConfig{LocalPath: "cert.pem"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("cert"); err != nil {
+		t.Errorf("the certificate should be loaded: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signer.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sp, err = SecretProvider(SecretProviderConfig{LocalPath: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := sp.GetKey("signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Key.(*rsa.PrivateKey); !ok {
+		t.Errorf("unexpected key: %T", k.Key)
+	}
+}
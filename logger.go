@@ -0,0 +1,107 @@
+package jose
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/luraproject/lura/v2/logging"
+)
+
+// LogLevel is the severity of a log event
+type LogLevel int
+
+// The levels of the log events
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarning:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger receives the events of the validators, the signers and the secret providers. The keyvals are
+// alternate keys and values, as the attributes of a slog.Logger, so a slog.Logger can be adapted with a
+// LoggerFunc:
+//
+//	jose.LoggerFunc(func(level jose.LogLevel, msg string, keyvals ...interface{}) {
+//		logger.Log(context.Background(), slog.Level(4*(int(level)-1)), msg, keyvals...)
+//	})
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc is a function implementing the Logger interface
+type LoggerFunc func(level LogLevel, msg string, keyvals ...interface{})
+
+// Log implements the Logger interface
+func (f LoggerFunc) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+// NewLuraLogger adapts a Lura logger. The events are logged with the prefix, the message and the key=value
+// pairs.
+func NewLuraLogger(l logging.Logger, prefix string) Logger {
+	return LoggerFunc(func(level LogLevel, msg string, keyvals ...interface{}) {
+		line := formatLogEvent(msg, keyvals)
+		switch level {
+		case LogDebug:
+			l.Debug(prefix, line)
+		case LogInfo:
+			l.Info(prefix, line)
+		case LogWarning:
+			l.Warning(prefix, line)
+		default:
+			l.Error(prefix, line)
+		}
+	})
+}
+
+// stdLogger is used when no logger is injected. It logs the warnings and the errors with the log package.
+type stdLogger struct{}
+
+func (stdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < LogWarning {
+		return
+	}
+	log.Printf("JOSE: %s %s", level, formatLogEvent(msg, keyvals))
+}
+
+// loggerOrDefault returns the logger, or the stdLogger if it is nil
+func loggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return stdLogger{}
+	}
+	return l
+}
+
+// formatLogEvent returns the message followed by the key=value pairs. The values with spaces are quoted.
+func formatLogEvent(msg string, keyvals []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		s := fmt.Sprint(v)
+		if strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], s)
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package jose
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/luraproject/lura/v2/logging"
+)
+
+type recordedEvent struct {
+	level LogLevel
+	line  string
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	l.events = append(l.events, recordedEvent{level, formatLogEvent(msg, keyvals)})
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) find(level LogLevel, prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if e.level == level && strings.HasPrefix(e.line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFormatLogEvent(t *testing.T) {
+	line := formatLogEvent("key set fetched", []interface{}{"uri", "https://idp/jwks", "keys", 2, "error", "unexpected status code 500", "odd"})
+	if expected := `key set fetched uri=https://idp/jwks keys=2 error="unexpected status code 500" odd=(missing)`; line != expected {
+		t.Errorf("unexpected line: %s", line)
+	}
+}
+
+func TestNewLuraLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := logging.NewLogger("INFO", buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLuraLogger(l, "[JOSE]")
+	logger.Log(LogDebug, "hidden")
+	logger.Log(LogWarning, "unable to fetch the key set", "uri", "https://idp/jwks")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("the debug events must be filtered by the Lura logger: %s", out)
+	}
+	if !strings.Contains(out, "WARNING: [JOSE] unable to fetch the key set uri=https://idp/jwks") {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestSecretProvider_logger(t *testing.T) {
+	var fail bool
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		jwkEndpoint("public")(w, r)
+	}))
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, Logger: logger}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.find(LogDebug, "key set fetched uri="+server.URL) {
+		t.Errorf("missing fetch event: %+v", logger.events)
+	}
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	sp.GetKey("2011-04-29")
+	if !logger.find(LogWarning, "unable to fetch the key set uri="+server.URL+` error="unexpected status code 500"`) {
+		t.Errorf("missing failure event: %+v", logger.events)
+	}
+
+	scfg := &SignatureConfig{Alg: "RS256", URI: server.URL, DisableJWKSecurity: true, CacheMaxKeys: -1, Logger: logger}
+	if _, err := NewValidator(scfg, nopExtractor); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.find(LogWarning, "config problem code="+ConfigErrInvalidCacheMaxKeys) {
+		t.Errorf("missing config event: %+v", logger.events)
+	}
+}
@@ -0,0 +1,240 @@
+package jose
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const metricsPrefix = "krakend_jose_"
+
+// The reasons of the key cache evictions
+const (
+	KeyEvictionExpired  = "expired"
+	KeyEvictionOverflow = "overflow"
+)
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMetrics is the Metrics instance updated by the validators, the signers and the JWK clients
+var DefaultMetrics = NewMetrics()
+
+// Metrics keeps the counters and histograms of the validation pipeline
+type Metrics struct {
+	validated    counterVec
+	rejected     counterVec
+	wouldReject  counterVec
+	rejecterHits counterVec
+	signerOps    counterVec
+	jwksErrors   counterVec
+	keyCache     counterVec
+	evictions    counterVec
+	tokenCache   counterVec
+	throttled    counterVec
+	retries      counterVec
+	tenants      counterVec
+	iterations   counterVec
+	jwksFetch    prometheus.Histogram
+}
+
+// NewMetrics returns an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		validated:    newCounterVec("tokens_validated_total", "Tokens accepted by the validators", "endpoint"),
+		rejected:     newCounterVec("tokens_rejected_total", "Requests rejected by the validators", "endpoint", "reason"),
+		wouldReject:  newCounterVec("tokens_would_reject_total", "Requests that would have been rejected in log_only mode", "endpoint", "reason"),
+		rejecterHits: newCounterVec("rejecter_hits_total", "Tokens rejected by the rejecter", "endpoint"),
+		signerOps:    newCounterVec("signer_operations_total", "Payloads signed", "result"),
+		jwksErrors:   newCounterVec("jwks_fetch_errors_total", "Failed JWKS fetches", "host"),
+		keyCache:     newCounterVec("key_cache_requests_total", "Key cache lookups", "result"),
+		evictions:    newCounterVec("key_cache_evictions_total", "Keys evicted from the key cache", "reason"),
+		tokenCache:   newCounterVec("token_cache_requests_total", "Validated token cache lookups", "result"),
+		throttled:    newCounterVec("jwks_refresh_throttled_total", "JWKS refreshes rejected by the rate limit", "host"),
+		retries:      newCounterVec("jwks_fetch_retries_total", "Failed JWKS fetches retried", "host"),
+		tenants:      newCounterVec("tenant_requests_total", "Requests of the endpoints with tenant isolation", "endpoint", "tenant", "result"),
+		iterations:   newCounterVec("key_iterations_total", "Tokens without kid verified by trying the keys of the set", "result"),
+		jwksFetch:    newHistogram("jwks_fetch_duration_seconds", "Duration of the JWKS fetches", defaultBuckets),
+	}
+}
+
+// TokenValidated counts an accepted request
+func (m *Metrics) TokenValidated(endpoint string) {
+	m.validated.inc(endpoint)
+}
+
+// TokenRejected counts a rejected request. The rejections of the Rejecter are also counted as rejecter hits.
+func (m *Metrics) TokenRejected(endpoint, reason string) {
+	m.rejected.inc(endpoint, reason)
+	if reason == ReasonRejected {
+		m.rejecterHits.inc(endpoint)
+	}
+}
+
+// TokenWouldReject counts a rejection not enforced because of the log_only enforcement mode
+func (m *Metrics) TokenWouldReject(endpoint, reason string) {
+	m.wouldReject.inc(endpoint, reason)
+}
+
+// TenantRequest counts a request of an endpoint with tenant isolation. The result is "accepted" or the
+// reason of the rejection.
+func (m *Metrics) TenantRequest(endpoint, tenant, result string) {
+	m.tenants.inc(endpoint, tenant, result)
+}
+
+// SignerOperation counts a sign operation
+func (m *Metrics) SignerOperation(err error) {
+	if err != nil {
+		m.signerOps.inc("error")
+		return
+	}
+	m.signerOps.inc("ok")
+}
+
+// JWKSFetch records the duration of a JWKS fetch and counts the failed ones
+func (m *Metrics) JWKSFetch(host string, d time.Duration, failed bool) {
+	m.jwksFetch.Observe(d.Seconds())
+	if failed {
+		m.jwksErrors.inc(host)
+	}
+}
+
+// JWKSRefreshThrottled counts a JWKS refresh rejected by the rate limit
+func (m *Metrics) JWKSRefreshThrottled(host string) {
+	m.throttled.inc(host)
+}
+
+// JWKSFetchRetried counts a failed JWKS fetch retried
+func (m *Metrics) JWKSFetchRetried(host string) {
+	m.retries.inc(host)
+}
+
+// KeyCacheLookup counts a key cache hit or miss
+func (m *Metrics) KeyCacheLookup(hit bool) {
+	if hit {
+		m.keyCache.inc("hit")
+		return
+	}
+	m.keyCache.inc("miss")
+}
+
+// KeyCacheEviction counts a key evicted from the key cache, because it expired or because the cache was full
+func (m *Metrics) KeyCacheEviction(reason string) {
+	m.evictions.inc(reason)
+}
+
+// KeyIteration counts a token without kid verified by trying the keys of the set, with the result of the
+// iteration
+func (m *Metrics) KeyIteration(result string) {
+	m.iterations.inc(result)
+}
+
+// TokenCacheLookup counts a validated token cache hit or miss
+func (m *Metrics) TokenCacheLookup(hit bool) {
+	if hit {
+		m.tokenCache.inc("hit")
+		return
+	}
+	m.tokenCache.inc("miss")
+}
+
+// Collector exposes the metrics to Prometheus. It implements the prometheus.Collector interface, so the
+// host application registers it with its own registry:
+//
+//	registry.MustRegister(jose.NewCollector(nil))
+type Collector struct {
+	metrics *Metrics
+	once    sync.Once
+	handler http.Handler
+}
+
+// NewCollector returns a Collector for the metrics. A nil Metrics uses the DefaultMetrics.
+func NewCollector(m *Metrics) *Collector {
+	if m == nil {
+		m = DefaultMetrics
+	}
+	return &Collector{metrics: m}
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, col := range c.metrics.collectors() {
+		col.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, col := range c.metrics.collectors() {
+		col.Collect(ch)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface, serving the metrics from a registry of their own for
+// the host applications without a Prometheus registry
+func (c *Collector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c.once.Do(func() {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c)
+		c.handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	})
+	c.handler.ServeHTTP(rw, r)
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.validated,
+		m.rejected,
+		m.wouldReject,
+		m.rejecterHits,
+		m.signerOps,
+		m.jwksErrors,
+		m.keyCache,
+		m.evictions,
+		m.tokenCache,
+		m.throttled,
+		m.retries,
+		m.tenants,
+		m.iterations,
+		m.jwksFetch,
+	}
+}
+
+type counterVec struct {
+	*prometheus.CounterVec
+}
+
+func newCounterVec(name, help string, labels ...string) counterVec {
+	return counterVec{prometheus.NewCounterVec(prometheus.CounterOpts{Name: metricsPrefix + name, Help: help}, labels)}
+}
+
+// inc increments the counter of the label values. Prometheus rejects the label values with invalid UTF-8,
+// so they are replaced first.
+func (c counterVec) inc(labelValues ...string) {
+	valid := make([]string, len(labelValues))
+	for i, v := range labelValues {
+		valid[i] = strings.ToValidUTF8(v, "\uFFFD")
+	}
+	c.WithLabelValues(valid...).Inc()
+}
+
+func newHistogram(name, help string, buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{Name: metricsPrefix + name, Help: help, Buckets: buckets})
+}
+
+// metricsKeyCacher counts the hits and misses of the wrapped KeyCacher
+type metricsKeyCacher struct {
+	auth0.KeyCacher
+	metrics *Metrics
+}
+
+func (m metricsKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
+	k, err := m.KeyCacher.Get(keyID)
+	m.metrics.KeyCacheLookup(err == nil)
+	return k, err
+}
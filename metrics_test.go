@@ -0,0 +1,148 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.TokenValidated("/foo")
+	m.TokenValidated("/foo")
+	m.TokenRejected("/foo", ReasonExpired)
+	m.TokenRejected("/bar", ReasonRejected)
+	m.SignerOperation(nil)
+	m.SignerOperation(errors.New("boom"))
+	m.JWKSFetch("example.com", 20*time.Millisecond, false)
+	m.JWKSFetch("example.com", 2*time.Second, true)
+	m.KeyCacheLookup(true)
+	m.KeyCacheLookup(false)
+	m.TenantRequest("/foo", "acme", "accepted")
+
+	if v := m.validated.get("/foo"); v != 2 {
+		t.Errorf("unexpected validated counter: %d", v)
+	}
+	if v := m.rejecterHits.get("/bar"); v != 1 {
+		t.Errorf("unexpected rejecter hits: %d", v)
+	}
+	if v := m.rejecterHits.get("/foo"); v != 0 {
+		t.Errorf("unexpected rejecter hits: %d", v)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(m))
+	expected := `
+# HELP krakend_jose_tokens_validated_total Tokens accepted by the validators
+# TYPE krakend_jose_tokens_validated_total counter
+krakend_jose_tokens_validated_total{endpoint="/foo"} 2
+# HELP krakend_jose_tokens_rejected_total Requests rejected by the validators
+# TYPE krakend_jose_tokens_rejected_total counter
+krakend_jose_tokens_rejected_total{endpoint="/bar",reason="rejected"} 1
+krakend_jose_tokens_rejected_total{endpoint="/foo",reason="expired"} 1
+# HELP krakend_jose_signer_operations_total Payloads signed
+# TYPE krakend_jose_signer_operations_total counter
+krakend_jose_signer_operations_total{result="error"} 1
+krakend_jose_signer_operations_total{result="ok"} 1
+# HELP krakend_jose_jwks_fetch_errors_total Failed JWKS fetches
+# TYPE krakend_jose_jwks_fetch_errors_total counter
+krakend_jose_jwks_fetch_errors_total{host="example.com"} 1
+# HELP krakend_jose_key_cache_requests_total Key cache lookups
+# TYPE krakend_jose_key_cache_requests_total counter
+krakend_jose_key_cache_requests_total{result="hit"} 1
+krakend_jose_key_cache_requests_total{result="miss"} 1
+# HELP krakend_jose_tenant_requests_total Requests of the endpoints with tenant isolation
+# TYPE krakend_jose_tenant_requests_total counter
+krakend_jose_tenant_requests_total{endpoint="/foo",result="accepted",tenant="acme"} 1
+# HELP krakend_jose_jwks_fetch_duration_seconds Duration of the JWKS fetches
+# TYPE krakend_jose_jwks_fetch_duration_seconds histogram
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.005"} 0
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.01"} 0
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.025"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.05"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.1"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.25"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="0.5"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="1"} 1
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="2.5"} 2
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="5"} 2
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="10"} 2
+krakend_jose_jwks_fetch_duration_seconds_bucket{le="+Inf"} 2
+krakend_jose_jwks_fetch_duration_seconds_sum 2.02
+krakend_jose_jwks_fetch_duration_seconds_count 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"krakend_jose_tokens_validated_total",
+		"krakend_jose_tokens_rejected_total",
+		"krakend_jose_signer_operations_total",
+		"krakend_jose_jwks_fetch_errors_total",
+		"krakend_jose_key_cache_requests_total",
+		"krakend_jose_tenant_requests_total",
+		"krakend_jose_jwks_fetch_duration_seconds",
+	); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollector_ServeHTTP(t *testing.T) {
+	m := NewMetrics()
+	m.TokenValidated("/foo")
+
+	w := httptest.NewRecorder()
+	NewCollector(m).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/__metrics", http.NoBody))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	if !strings.Contains(w.Body.String(), `krakend_jose_tokens_validated_total{endpoint="/foo"} 1`) {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+}
+
+func TestMetrics_invalidLabelValue(t *testing.T) {
+	m := NewMetrics()
+	m.TokenValidated("/ñandú/\"quoted\"\n\xff")
+
+	if v := m.validated.get("/ñandú/\"quoted\"\n\uFFFD"); v != 1 {
+		t.Errorf("unexpected validated counter: %d", v)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(m))
+	if _, err := registry.Gather(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMetricsKeyCacher(t *testing.T) {
+	m := NewMetrics()
+	kc := metricsKeyCacher{KeyCacher: auth0.NewMemoryKeyCacher(time.Minute, 10), metrics: m}
+
+	if _, err := kc.Get("unknown"); err == nil {
+		t.Error("error expected")
+	}
+	if _, err := kc.Add("kid", []jose.JSONWebKey{{KeyID: "kid", Key: []byte("secret")}}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := kc.Get("kid"); err != nil {
+		t.Error(err)
+	}
+
+	if v := m.keyCache.get("hit"); v != 1 {
+		t.Errorf("unexpected hits: %d", v)
+	}
+	if v := m.keyCache.get("miss"); v != 1 {
+		t.Errorf("unexpected misses: %d", v)
+	}
+}
+
+func (c counterVec) get(labelValues ...string) uint64 {
+	return uint64(testutil.ToFloat64(c.WithLabelValues(labelValues...)))
+}
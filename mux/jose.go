@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	krakendjose "github.com/DKolibar/krakend-jose/v2"
 	"github.com/auth0-community/go-auth0"
@@ -18,12 +19,45 @@ import (
 )
 
 func HandlerFactory(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtractor, logger logging.Logger, rejecterF krakendjose.RejecterFactory) muxlura.HandlerFactory {
-	return TokenSignatureValidator(TokenSigner(hf, paramExtractor, logger), logger, rejecterF)
+	return TokenSignatureValidatorWithParamExtractor(RequestObjectValidator(TokenSigner(hf, paramExtractor, logger), logger), paramExtractor, logger, rejecterF)
+}
+
+// RequestObjectValidator validates the signed request objects (JAR) of the endpoints with a request object
+// config. The requests with an invalid request object are rejected with a 400.
+func RequestObjectValidator(hf muxlura.HandlerFactory, logger logging.Logger) muxlura.HandlerFactory {
+	return func(cfg *config.EndpointConfig, prxy proxy.Proxy) http.HandlerFunc {
+		rocfg, err := krakendjose.GetRequestObjectConfig(cfg)
+		if err == krakendjose.ErrNoRequestObjectCfg {
+			return hf(cfg, prxy)
+		}
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+		validator, err := krakendjose.NewRequestObjectValidator(rocfg)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		logger.Info("JOSE: request object validation enabled for the endpoint", cfg.Endpoint)
+
+		handler := hf(cfg, prxy)
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, err := validator.Validate(r); err != nil {
+				logger.Debug(fmt.Sprintf("JOSE: invalid request object for %s: %s", cfg.Endpoint, err.Error()))
+				authErr := krakendjose.NewRequestObjectError(err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(authErr.Status)
+				json.NewEncoder(w).Encode(authErr)
+				return
+			}
+			handler(w, r)
+		}
+	}
 }
 
 func TokenSigner(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtractor, logger logging.Logger) muxlura.HandlerFactory {
 	return func(cfg *config.EndpointConfig, prxy proxy.Proxy) http.HandlerFunc {
-		signerCfg, signer, err := krakendjose.NewSigner(cfg, nil)
+		issuer, err := krakendjose.NewTokenIssuer(cfg, nil)
 		if err == krakendjose.ErrNoSignerCfg {
 			logger.Info("JOSE: signer disabled for the endpoint", cfg.Endpoint)
 			return hf(cfg, prxy)
@@ -53,7 +87,7 @@ func TokenSigner(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtracto
 				return
 			}
 
-			if err := krakendjose.SignFields(signerCfg.KeysToSign, signer, response); err != nil {
+			if err := issuer.Issue(response); err != nil {
 				logger.Error(err.Error())
 				http.Error(w, "", http.StatusBadRequest)
 				return
@@ -63,7 +97,19 @@ func TokenSigner(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtracto
 				w.Header().Set(k, v[0])
 			}
 
-			err = jsonRender(w, response)
+			if form, ok := issuer.FormPost(response); ok {
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write(form)
+				return
+			}
+
+			if issuer.SignsPayload() {
+				err = signedJSONRender(w, response, issuer)
+			} else {
+				err = jsonRender(w, response)
+			}
 			if err != nil {
 				logger.Error("render answer error:", err.Error())
 			}
@@ -73,6 +119,26 @@ func TokenSigner(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtracto
 
 var emptyResponse = []byte("{}")
 
+func signedJSONRender(w http.ResponseWriter, response *proxy.Response, issuer *krakendjose.TokenIssuer) error {
+	body, err := json.Marshal(response.Data)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return err
+	}
+	headers, err := issuer.SignPayload(body)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return err
+	}
+	for k, v := range headers {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Metadata.StatusCode)
+	_, err = w.Write(body)
+	return err
+}
+
 func jsonRender(w http.ResponseWriter, response *proxy.Response) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(response.Metadata.StatusCode)
@@ -92,86 +158,368 @@ func jsonRender(w http.ResponseWriter, response *proxy.Response) error {
 }
 
 func TokenSignatureValidator(hf muxlura.HandlerFactory, logger logging.Logger, rejecterF krakendjose.RejecterFactory) muxlura.HandlerFactory {
+	return TokenSignatureValidatorWithParamExtractor(hf, nil, logger, rejecterF)
+}
+
+// TokenSignatureValidatorWithParamExtractor is a TokenSignatureValidator using the param extractor to get
+// the URL parameters checked by the param constraints
+func TokenSignatureValidatorWithParamExtractor(hf muxlura.HandlerFactory, paramExtractor muxlura.ParamExtractor, logger logging.Logger, rejecterF krakendjose.RejecterFactory) muxlura.HandlerFactory {
 	return func(cfg *config.EndpointConfig, prxy proxy.Proxy) http.HandlerFunc {
 		if rejecterF == nil {
 			rejecterF = new(krakendjose.NopRejecterFactory)
 		}
 		rejecter := rejecterF.New(logger, cfg)
 
-		handler := hf(cfg, prxy)
 		signatureConfig, err := krakendjose.GetSignatureConfig(cfg)
 		if err == krakendjose.ErrNoValidatorCfg {
 			logger.Info("JOSE: validator disabled for the endpoint", cfg.Endpoint)
-			return handler
+			return hf(cfg, prxy)
 		}
 		if err != nil {
 			logger.Warning(fmt.Sprintf("JOSE: validator for %s: %s", cfg.Endpoint, err.Error()))
-			return handler
+			return hf(cfg, prxy)
 		}
+		signatureConfig.Logger = krakendjose.NewLuraLogger(logger, "JOSE: validator for "+cfg.Endpoint+":")
 
-		validator, err := krakendjose.NewValidator(signatureConfig, FromCookie)
+		responseFilter, err := krakendjose.NewResponseFilter(signatureConfig)
 		if err != nil {
 			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
 		}
+		grpcPropagator, err := krakendjose.NewGRPCMetadataPropagator(signatureConfig)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+		handler := hf(cfg, responseFilter.Proxy(grpcPropagator.Proxy(prxy)))
 
-		var aclCheck func(string, map[string]interface{}, []string) bool
+		validators, err := krakendjose.NewReloadableValidator(signatureConfig, krakendjose.FromGRPCMetadata(FromCookie), func(_ *krakendjose.SignatureConfig) krakendjose.Rejecter { return rejecter })
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+		if signatureConfig.Reload != nil {
+			onError := func(err error) {
+				logger.Error(fmt.Sprintf("JOSE: unable to reload the validator for %s: %s", cfg.Endpoint, err.Error()))
+			}
+			if err := validators.WatchFile(context.Background(), signatureConfig.Reload, onError); err != nil {
+				log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+			}
+		}
 
-		if signatureConfig.RolesKeyIsNested && strings.Contains(signatureConfig.RolesKey, ".") && signatureConfig.RolesKey[:4] != "http" {
-			aclCheck = krakendjose.CanAccessNested
-		} else {
-			aclCheck = krakendjose.CanAccess
+		var detached *krakendjose.DetachedVerifier
+		if signatureConfig.DetachedPayload != nil {
+			detached, err = krakendjose.NewDetachedVerifier(signatureConfig)
+			if err != nil {
+				log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+			}
 		}
 
-		var scopesMatcher func(string, map[string]interface{}, []string) bool
+		redactor, err := krakendjose.NewRedactor(signatureConfig.Redaction)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
 
-		if len(signatureConfig.Scopes) > 0 && signatureConfig.ScopesKey != "" {
-			if signatureConfig.ScopesMatcher == "all" {
-				scopesMatcher = krakendjose.ScopesAllMatcher
-			} else {
-				scopesMatcher = krakendjose.ScopesAnyMatcher
+		roleMapper, err := krakendjose.NewRoleMapper(signatureConfig)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+		if signatureConfig.RoleMapping != nil && signatureConfig.RoleMapping.ReloadInterval != "" {
+			go roleMapper.Watch(context.Background(), func(err error) {
+				logger.Error(fmt.Sprintf("JOSE: unable to reload the role mapping for %s: %s", cfg.Endpoint, err.Error()))
+			})
+		}
+
+		transformer, err := krakendjose.NewClaimsTransformer(signatureConfig.TransformClaims)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		claimsSigner, err := krakendjose.NewClaimsHeaderSigner(signatureConfig.SignedClaims)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		appleSecret, err := krakendjose.NewAppleClientSecret(signatureConfig.AppleClientSecret)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		exchanger, err := krakendjose.NewTokenExchanger(signatureConfig.TokenExchange)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		bodyInjector, err := krakendjose.NewBodyInjector(signatureConfig.InjectClaimsIntoBody)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		apiKeys, err := krakendjose.NewAPIKeyAuthenticator(signatureConfig.APIKeys)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		basicAuth, err := krakendjose.NewBasicAuthenticator(signatureConfig.BasicAuth)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		saml, err := krakendjose.NewSAMLAuthenticator(signatureConfig.SAML)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		tenants, err := krakendjose.NewTenantResolver(signatureConfig.Tenant)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		errRenderer := krakendjose.NewErrorRenderer(signatureConfig.ErrorResponse)
+
+		auditor, err := krakendjose.NewAuditor(cfg.Endpoint, signatureConfig)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+		logOnly := signatureConfig.LogOnly()
+		if logOnly {
+			logger.Warning("JOSE: enforcement mode is log_only for the endpoint", cfg.Endpoint)
+		}
+		// reject renders the error, unless the enforcement mode is log_only. It returns true if the
+		// request has been rejected.
+		reject := func(w http.ResponseWriter, r *http.Request, start time.Time, claims map[string]interface{}, authErr *krakendjose.AuthError, body string) bool {
+			if err := auditor.Record(r, start, claims, authErr); err != nil {
+				logger.Warning("JOSE: unable to record the audit event:", err.Error())
+			}
+			if tenants != nil {
+				tenant, _ := tenants.Tenant(claims)
+				krakendjose.DefaultMetrics.TenantRequest(cfg.Endpoint, tenants.MetricsLabel(tenant), authErr.Reason)
 			}
-		} else {
-			scopesMatcher = krakendjose.ScopesDefaultMatcher
+			if logOnly {
+				krakendjose.DefaultMetrics.TokenWouldReject(cfg.Endpoint, authErr.Reason)
+				logger.Warning(fmt.Sprintf("JOSE: request to %s would have been rejected with status %d: %s", cfg.Endpoint, authErr.Status, authErr.Reason))
+				return false
+			}
+			krakendjose.DefaultMetrics.TokenRejected(cfg.Endpoint, authErr.Reason)
+			signatureConfig.Logger.Log(krakendjose.LogDebug, "request rejected", "status", authErr.Status, "reason", authErr.Reason)
+			renderError(w, errRenderer, authErr, body)
+			return true
+		}
+
+		if len(signatureConfig.ParamConstraints) > 0 && paramExtractor == nil {
+			logger.Warning("JOSE: no param extractor for the param constraints of the endpoint", cfg.Endpoint)
 		}
 
 		logger.Info("JOSE: validator enabled for the endpoint", cfg.Endpoint)
 
-		return func(w http.ResponseWriter, r *http.Request) {
-			token, err := validator.ValidateRequest(r)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
-				return
+		userInfo, err := krakendjose.NewUserInfo(signatureConfig.UserInfo)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		enricher, err := krakendjose.NewEnricher(signatureConfig.Enrichment)
+		if err != nil {
+			log.Fatalf("%s: %s", cfg.Endpoint, err.Error())
+		}
+
+		history := krakendjose.NewSubjectHistory(signatureConfig.SubjectHistory)
+		wsAuth := krakendjose.NewWebSocketAuth(signatureConfig.WebSocket)
+		streams := krakendjose.NewStreamRevalidator(signatureConfig.StreamRevalidation)
+		if streams != nil && logOnly {
+			logger.Warning("JOSE: the streams of the endpoint will not be revalidated, as the enforcement mode is log_only:", cfg.Endpoint)
+			streams = nil
+		}
+
+		authorize := func(r *http.Request, set *krakendjose.ValidatorSet, claims map[string]interface{}) *krakendjose.AuthError {
+			if detached != nil {
+				if err := detached.VerifyRequest(r); err != nil {
+					if signatureConfig.OperationDebug {
+						logger.Error("JOSE: unable to verify the detached payload signature:", err.Error())
+					}
+					return krakendjose.NewPayloadSignatureError()
+				}
+			}
+			if set.Rejecter.Reject(claims) {
+				return krakendjose.NewRejectedError()
 			}
+			if authErr := history.Check(claims, set.Rejecter); authErr != nil {
+				return authErr
+			}
+			if authErr := set.Policy.AuthorizeMethod(r.Method, claims); authErr != nil {
+				return authErr
+			}
+			if set.Constraints != nil {
+				if authErr := set.Constraints.Check(paramGetter(paramExtractor, r), claims); authErr != nil {
+					return authErr
+				}
+			}
+			_, authErr := tenants.Check(claims)
+			return authErr
+		}
+
+		rolesPath := signatureConfig.RolesPath()
 
-			claims := map[string]interface{}{}
-			err = validator.Claims(r, token, &claims)
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wsAuth.Prepare(r)
+			r = krakendjose.WithRequestToken(r, signatureConfig.CookieKey)
+			set := validators.Current()
+			claims, err := set.Validator(r)
+			claims, err = apiKeys.Fallback(r, claims, err)
+			claims, err = basicAuth.Fallback(r, claims, err)
+			claims, err = saml.Fallback(r, claims, err)
+			if anonymous, ok := krakendjose.AnonymousClaims(signatureConfig, rolesPath, err); ok {
+				claims, err = anonymous, nil
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+				if !reject(w, r, start, nil, krakendjose.NewTokenError(err), err.Error()) {
+					handler(w, r)
+				}
 				return
 			}
+			var enrichErr *krakendjose.AuthError
+			if merged, err := userInfo.Merge(r.Context(), claims); err != nil {
+				logger.Error(fmt.Sprintf("JOSE: unable to fetch the userinfo for %s: %s", cfg.Endpoint, err.Error()))
+				enrichErr = krakendjose.NewUserInfoError(err)
+				if reject(w, r, start, claims, enrichErr, "") {
+					return
+				}
+			} else {
+				claims = merged
+			}
+			if enriched, err := enricher.Enrich(r.Context(), claims); err != nil {
+				logger.Error(fmt.Sprintf("JOSE: unable to enrich the claims for %s: %s", cfg.Endpoint, err.Error()))
+				if enrichErr == nil {
+					enrichErr = krakendjose.NewEnrichmentError()
+					if reject(w, r, start, claims, enrichErr, "") {
+						return
+					}
+				}
+			} else {
+				claims = enriched
+			}
+			claims = transformer.Transform(roleMapper.Map(claims))
+			r = krakendjose.WithClaims(r, claims)
 
-			if rejecter.Reject(claims) {
-				http.Error(w, "", http.StatusUnauthorized)
+			_, span := krakendjose.StartSpan(r.Context(), krakendjose.SpanPolicyEvaluation)
+			authErr := authorize(r, set, claims)
+			if authErr != nil {
+				span.RecordError(authErr)
+			}
+			span.End()
+			if authErr != nil && reject(w, r, start, claims, authErr, "") {
 				return
 			}
+			if authErr == nil {
+				authErr = enrichErr
+			}
 
-			if !aclCheck(signatureConfig.RolesKey, claims, signatureConfig.Roles) {
-				http.Error(w, "", http.StatusForbidden)
-				return
+			_, span = krakendjose.StartSpan(r.Context(), krakendjose.SpanClaimPropagation)
+			propagated := redactor.Redact(claims)
+			propagateHeaders(set.Propagator, propagated, r)
+			if err := claimsSigner.Propagate(r, propagated); err != nil {
+				logger.Error(fmt.Sprintf("JOSE: unable to sign the propagated claims for %s: %s", cfg.Endpoint, err.Error()))
+			}
+			if err := appleSecret.Propagate(r); err != nil {
+				logger.Error(fmt.Sprintf("JOSE: unable to generate the Apple client secret for %s: %s", cfg.Endpoint, err.Error()))
+			}
+			tenant, _ := tenants.Tenant(claims)
+			tenants.Inject(r, tenant)
+			bodyErr := bodyInjector.Inject(r, propagated)
+			span.End()
+			if bodyErr != nil {
+				if reject(w, r, start, claims, bodyErr, "") {
+					return
+				}
+				if authErr == nil {
+					authErr = bodyErr
+				}
+			}
+			if err := exchanger.Propagate(r, claims); err != nil {
+				logger.Error(fmt.Sprintf("JOSE: unable to exchange the token for %s: %s", cfg.Endpoint, err.Error()))
+				exchangeErr := krakendjose.NewTokenExchangeError(err)
+				if reject(w, r, start, claims, exchangeErr, "") {
+					return
+				}
+				if authErr == nil {
+					authErr = exchangeErr
+				}
 			}
 
-			if !scopesMatcher(signatureConfig.ScopesKey, claims, signatureConfig.Scopes) {
-				http.Error(w, "", http.StatusForbidden)
-				return
+			if authErr == nil {
+				history.Record(claims)
+				krakendjose.DefaultMetrics.TokenValidated(cfg.Endpoint)
+				if tenants != nil {
+					krakendjose.DefaultMetrics.TenantRequest(cfg.Endpoint, tenants.MetricsLabel(tenant), "accepted")
+				}
+				if err := auditor.Record(r, start, claims, nil); err != nil {
+					logger.Warning("JOSE: unable to record the audit event:", err.Error())
+				}
 			}
 
-			propagateHeaders(cfg, signatureConfig.PropagateClaimsToHeader, claims, r, logger)
+			if sw, req := streams.Guard(w, r, claims, set.Rejecter); sw != nil {
+				w, r = sw, req
+				defer func() {
+					sw.Stop()
+					if authErr := sw.Err(); authErr != nil {
+						krakendjose.DefaultMetrics.TokenRejected(cfg.Endpoint, authErr.Reason)
+						logger.Warning(fmt.Sprintf("JOSE: stream to %s terminated: %s", cfg.Endpoint, authErr.Reason))
+					}
+				}()
+			}
 
 			handler(w, r)
 		}
 	}
 }
 
+// NewMiddleware returns a net/http middleware validating the tokens with the signature config, so the
+// services outside of the gateway get the same validation, rejection and propagation. It fits the routers
+// using func(http.Handler) http.Handler middlewares, as chi, and the others through their adapters, as
+// the echo.WrapMiddleware. The name is used in the logs and the metrics as the endpoint. The validator
+// is created every time the middleware wraps a handler, and the response filters and the gRPC metadata
+// propagation are not applied, as they wrap the proxy of the endpoint.
+func NewMiddleware(name string, scfg *krakendjose.SignatureConfig, logger logging.Logger, rejecterF krakendjose.RejecterFactory) func(http.Handler) http.Handler {
+	cfg := &config.EndpointConfig{
+		Endpoint:    name,
+		ExtraConfig: config.ExtraConfig{krakendjose.ValidatorNamespace: scfg},
+	}
+	return func(next http.Handler) http.Handler {
+		hf := func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc { return next.ServeHTTP }
+		return TokenSignatureValidator(hf, logger, rejecterF)(cfg, proxy.NoopProxy)
+	}
+}
+
+// renderError writes the error response. Unless the JSON body is enabled, the legacy plain text body is kept.
+func renderError(w http.ResponseWriter, r *krakendjose.ErrorRenderer, authErr *krakendjose.AuthError, body string) {
+	if r.RendersBody() {
+		r.Render(w, authErr)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", r.WWWAuthenticate(authErr))
+	http.Error(w, body, authErr.Status)
+}
+
+// RegisterJWKSHandler adds the endpoint publishing the key set of the gateway to the engine, if the service
+// extra config enables it
+func RegisterJWKSHandler(e muxlura.Engine, extra config.ExtraConfig, logger logging.Logger) {
+	cfg, err := krakendjose.GetJWKSConfig(extra)
+	if err == krakendjose.ErrNoJWKSCfg {
+		return
+	}
+	if err != nil {
+		logger.Error("JOSE: unable to parse the jwks config:", err.Error())
+		return
+	}
+
+	cfg.Logger = krakendjose.NewLuraLogger(logger, "JOSE: jwks:")
+	h, err := krakendjose.NewJWKSHandler(cfg)
+	if err != nil {
+		logger.Error("JOSE: unable to load the key set:", err.Error())
+		return
+	}
+
+	e.Handle(cfg.Path, http.MethodGet, h)
+	logger.Info("JOSE: key set published at", cfg.Path)
+}
+
 func FromCookie(key string) func(r *http.Request) (*jwt.JSONWebToken, error) {
 	if key == "" {
 		key = "access_token"
@@ -185,15 +533,26 @@ func FromCookie(key string) func(r *http.Request) (*jwt.JSONWebToken, error) {
 	}
 }
 
-func propagateHeaders(cfg *config.EndpointConfig, propagationCfg [][]string, claims map[string]interface{}, r *http.Request, logger logging.Logger) {
-	if len(propagationCfg) > 0 {
-		headersToPropagate, err := krakendjose.CalculateHeadersToPropagate(propagationCfg, claims)
-		if err != nil {
-			logger.Warning(fmt.Sprintf("JOSE: header propagations error for %s: %s", cfg.Endpoint, err.Error()))
+func propagateHeaders(propagator *krakendjose.HeadersPropagator, claims map[string]interface{}, r *http.Request) {
+	// Set header value - replaces existing one
+	propagator.PropagateFunc(claims, r.Header.Set)
+}
+
+// paramGetter returns the ParamGetter of the request. Without param extractor, all the params are empty.
+func paramGetter(paramExtractor muxlura.ParamExtractor, r *http.Request) krakendjose.ParamGetter {
+	if paramExtractor == nil {
+		return func(string) string { return "" }
+	}
+	params := paramExtractor(r)
+	return func(name string) string {
+		if v, ok := params[name]; ok {
+			return v
 		}
-		for k, v := range headersToPropagate {
-			// Set header value - replaces existing one
-			r.Header.Set(k, v)
+		for k, v := range params {
+			if strings.EqualFold(k, name) {
+				return v
+			}
 		}
+		return ""
 	}
 }
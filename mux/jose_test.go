@@ -8,7 +8,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	krakendjose "github.com/DKolibar/krakend-jose/v2"
+	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/logging"
 	"github.com/luraproject/lura/v2/proxy"
 	muxlura "github.com/luraproject/lura/v2/router/mux"
@@ -168,3 +171,138 @@ func jwkEndpoint(name string) http.HandlerFunc {
 func dummyParamsExtractor(_ *http.Request) map[string]string {
 	return map[string]string{}
 }
+
+func TestNewMiddleware(t *testing.T) {
+	_, signer, err := krakendjose.NewSigner(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			krakendjose.SignerNamespace: map[string]interface{}{
+				"alg":                  "HS256",
+				"kid":                  "sim2",
+				"jwk_local_path":       "../fixtures/symmetric.json",
+				"disable_jwk_security": true,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mw := NewMiddleware("users", &krakendjose.SignatureConfig{
+		Alg:                     "HS256",
+		LocalPath:               "../fixtures/symmetric.json",
+		DisableJWKSecurity:      true,
+		Roles:                   []string{"admin"},
+		PropagateClaimsToHeader: [][]string{{"sub", "X-User"}},
+	}, logging.NoOp, nil)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-User")))
+	}))
+
+	for i, tc := range []struct {
+		roles  []string
+		status int
+	}{
+		{roles: []string{"admin"}, status: http.StatusOK},
+		{roles: []string{"user"}, status: http.StatusForbidden},
+		{status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/users", http.NoBody)
+		if len(tc.roles) > 0 {
+			token, err := signer(map[string]interface{}{
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"sub":   "1234",
+				"roles": tc.roles,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Code)
+			continue
+		}
+		if tc.status == http.StatusOK && w.Body.String() != "1234" {
+			t.Errorf("#%d: unexpected body: %s", i, w.Body.String())
+		}
+	}
+}
+
+func TestTokenSignatureValidator_detachedPayload(t *testing.T) {
+	_, signer, err := krakendjose.NewSigner(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			krakendjose.SignerNamespace: map[string]interface{}{
+				"alg":                  "HS256",
+				"kid":                  "sim2",
+				"jwk_local_path":       "../fixtures/symmetric.json",
+				"disable_jwk_security": true,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signer(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "sub": "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp, err := krakendjose.SecretProvider(krakendjose.SecretProviderConfig{LocalPath: "../fixtures/symmetric.json"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := sp.GetKey("sim2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	detachedSigner, err := krakendjose.NewDetachedSigner(&krakendjose.DetachedConfig{}, "HS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"amount":100}`
+	jws, _ := detachedSigner.Sign([]byte(body))
+
+	endpoint := &config.EndpointConfig{
+		Timeout:  time.Second,
+		Endpoint: "/payments",
+		Method:   http.MethodPost,
+		ExtraConfig: config.ExtraConfig{
+			krakendjose.ValidatorNamespace: map[string]interface{}{
+				"alg":                  "HS256",
+				"jwk_local_path":       "../fixtures/symmetric.json",
+				"disable_jwk_security": true,
+				"detached_payload":     map[string]interface{}{"max_body_size": 64},
+			},
+		},
+	}
+	dummyProxy := func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"ok": true}, IsComplete: true}, nil
+	}
+	engine := muxlura.DefaultEngine()
+	engine.Handle(endpoint.Endpoint, http.MethodPost, HandlerFactory(muxlura.EndpointHandler, dummyParamsExtractor, logging.NoOp, nil)(endpoint, dummyProxy))
+
+	for i, tc := range []struct {
+		body   string
+		jws    string
+		status int
+	}{
+		{body: body, jws: jws, status: http.StatusOK},
+		{body: body, status: http.StatusUnauthorized},
+		{body: `{"amount":1000}`, jws: jws, status: http.StatusUnauthorized},
+		{body: strings.Repeat(" ", 64) + body, jws: jws, status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, endpoint.Endpoint, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if tc.jws != "" {
+			req.Header.Set(krakendjose.DefaultDetachedHeader, tc.jws)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("#%d: unexpected status code: %d", i, w.Code)
+		}
+	}
+}
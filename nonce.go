@@ -0,0 +1,76 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrInvalidNonceCfg = errors.New("invalid nonce config")
+	ErrNonceMissing    = errors.New("the nonce of the request is missing")
+	ErrNonceMismatch   = errors.New("the nonce of the token does not match the request")
+)
+
+// NonceConfig binds the ID tokens to the user agent that started the authentication: the nonce claim of
+// the token must match the value stored in a cookie (or sent in a header) when the gateway redirected the
+// user to the authorization server.
+type NonceConfig struct {
+	// Cookie is the cookie with the nonce of the request
+	Cookie string `json:"cookie,omitempty"`
+	// Header is the header with the nonce of the request, used when there is no cookie
+	Header string `json:"header,omitempty"`
+	// Claim is the claim with the nonce of the token. Defaults to nonce
+	Claim string `json:"claim,omitempty"`
+	// Hashed is set when the nonce sent to the authorization server is the base64url encoded SHA-256 of
+	// the stored value, so the value itself never leaves the user agent
+	Hashed bool `json:"hashed,omitempty"`
+}
+
+// nonceClaimsValidator adds the nonce check to the validator
+func nonceClaimsValidator(cfg *NonceConfig, v ClaimsValidator) (ClaimsValidator, error) {
+	if cfg == nil {
+		return v, nil
+	}
+	if cfg.Cookie == "" && cfg.Header == "" {
+		return nil, fmt.Errorf("%w: either the cookie or the header must be defined", ErrInvalidNonceCfg)
+	}
+	claim := cfg.Claim
+	if claim == "" {
+		claim = "nonce"
+	}
+	path := NewClaimPath(claim, true)
+	return func(r *http.Request) (map[string]interface{}, error) {
+		claims, err := v(r)
+		if err != nil {
+			return nil, err
+		}
+		expected := requestNonce(r, cfg)
+		if expected == "" {
+			return nil, ErrNonceMissing
+		}
+		if cfg.Hashed {
+			sum := sha256.Sum256([]byte(expected))
+			expected = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+		nonce, _ := path.Get(claims)
+		if nonce == "" || !ConstantTimeEqual(nonce, expected) {
+			return nil, ErrNonceMismatch
+		}
+		return claims, nil
+	}, nil
+}
+
+func requestNonce(r *http.Request, cfg *NonceConfig) string {
+	if cfg.Cookie != "" {
+		if c, err := r.Cookie(cfg.Cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	if cfg.Header != "" {
+		return r.Header.Get(cfg.Header)
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNonceClaimsValidator(t *testing.T) {
+	sum := sha256.Sum256([]byte("n-0S6_WzA2Mj"))
+	hashed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	for _, tc := range []struct {
+		name   string
+		cfg    NonceConfig
+		claims map[string]interface{}
+		cookie string
+		header string
+		err    error
+	}{
+		{
+			name:   "cookie",
+			cfg:    NonceConfig{Cookie: "oidc_nonce"},
+			claims: map[string]interface{}{"nonce": "n-0S6_WzA2Mj"},
+			cookie: "n-0S6_WzA2Mj",
+		},
+		{
+			name:   "header",
+			cfg:    NonceConfig{Cookie: "oidc_nonce", Header: "X-Nonce"},
+			claims: map[string]interface{}{"nonce": "n-0S6_WzA2Mj"},
+			header: "n-0S6_WzA2Mj",
+		},
+		{
+			name:   "hashed",
+			cfg:    NonceConfig{Cookie: "oidc_nonce", Hashed: true},
+			claims: map[string]interface{}{"nonce": hashed},
+			cookie: "n-0S6_WzA2Mj",
+		},
+		{
+			name:   "mismatch",
+			cfg:    NonceConfig{Cookie: "oidc_nonce"},
+			claims: map[string]interface{}{"nonce": "another"},
+			cookie: "n-0S6_WzA2Mj",
+			err:    ErrNonceMismatch,
+		},
+		{
+			name:   "missing claim",
+			cfg:    NonceConfig{Cookie: "oidc_nonce"},
+			claims: map[string]interface{}{},
+			cookie: "n-0S6_WzA2Mj",
+			err:    ErrNonceMismatch,
+		},
+		{
+			name:   "missing nonce",
+			cfg:    NonceConfig{Cookie: "oidc_nonce"},
+			claims: map[string]interface{}{"nonce": "n-0S6_WzA2Mj"},
+			err:    ErrNonceMissing,
+		},
+	} {
+		v, err := nonceClaimsValidator(&tc.cfg, func(*http.Request) (map[string]interface{}, error) { return tc.claims, nil })
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		req := httptest.NewRequest("GET", "/callback", nil)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "oidc_nonce", Value: tc.cookie})
+		}
+		if tc.header != "" {
+			req.Header.Set("X-Nonce", tc.header)
+		}
+		if _, err := v(req); !errors.Is(err, tc.err) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.err != nil {
+			if res := NewTokenError(tc.err); res.Reason != ReasonInvalidNonce || res.Status != http.StatusUnauthorized {
+				t.Errorf("%s: unexpected error: %+v", tc.name, res)
+			}
+		}
+	}
+
+	if _, err := nonceClaimsValidator(&NonceConfig{}, nil); !errors.Is(err, ErrInvalidNonceCfg) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package jose
+
+// ParamConstraint requires the value of a URL parameter to match a claim of the token. The claim can be a
+// single value or an array containing the parameter, so resources as /users/{user_id}/orders can only be
+// accessed by their owners.
+type ParamConstraint struct {
+	// Param is the name of the URL parameter, without braces
+	Param string `json:"param"`
+	// Claim is the name of the claim. Dots access nested claims.
+	Claim string `json:"claim"`
+}
+
+// ParamGetter returns the value of a URL parameter, or an empty string if it is not present
+type ParamGetter func(name string) string
+
+// ParamConstraints checks the constraints of a signature config
+type ParamConstraints struct {
+	params []string
+	claims []ClaimPath
+	equal  func(a, b string) bool
+}
+
+// NewParamConstraints returns the ParamConstraints of the signature config, or nil if there are none
+func NewParamConstraints(scfg *SignatureConfig) *ParamConstraints {
+	if len(scfg.ParamConstraints) == 0 {
+		return nil
+	}
+	pc := &ParamConstraints{
+		params: make([]string, len(scfg.ParamConstraints)),
+		claims: make([]ClaimPath, len(scfg.ParamConstraints)),
+		equal:  func(a, b string) bool { return a == b },
+	}
+	if scfg.HardenedMatching {
+		pc.equal = ConstantTimeEqual
+	}
+	for i, c := range scfg.ParamConstraints {
+		pc.params[i] = c.Param
+		pc.claims[i] = NewClaimPath(c.Claim, true)
+	}
+	return pc
+}
+
+// Check returns an AuthError if any of the parameters does not match its claim. A nil ParamConstraints
+// accepts all the requests.
+func (pc *ParamConstraints) Check(param ParamGetter, claims map[string]interface{}) *AuthError {
+	if pc == nil {
+		return nil
+	}
+	for i, name := range pc.params {
+		if !pc.matches(param(name), pc.claims[i], claims) {
+			return NewForbiddenError(ReasonParamMismatch)
+		}
+	}
+	return nil
+}
+
+func (pc *ParamConstraints) matches(value string, path ClaimPath, claims map[string]interface{}) bool {
+	if value == "" {
+		return false
+	}
+	tmp, ok := path.Lookup(claims)
+	if !ok {
+		return false
+	}
+	if vs, ok := tmp.([]interface{}); ok {
+		for _, v := range vs {
+			if s, ok := claimString(v); ok && pc.equal(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := claimString(tmp)
+	return ok && pc.equal(s, value)
+}
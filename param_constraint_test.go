@@ -0,0 +1,55 @@
+package jose
+
+import (
+	"testing"
+)
+
+func TestParamConstraints_Check(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":  "user-1",
+		"orgs": []interface{}{"acme", "globex"},
+		"account": map[string]interface{}{
+			"id": float64(42),
+		},
+	}
+	params := map[string]string{
+		"user_id": "user-1",
+		"org":     "globex",
+		"account": "42",
+		"other":   "user-2",
+	}
+	getter := func(name string) string { return params[name] }
+
+	for _, tc := range []struct {
+		name        string
+		constraints []ParamConstraint
+		ok          bool
+	}{
+		{name: "none", ok: true},
+		{name: "equal", constraints: []ParamConstraint{{Param: "user_id", Claim: "sub"}}, ok: true},
+		{name: "in array", constraints: []ParamConstraint{{Param: "org", Claim: "orgs"}}, ok: true},
+		{name: "nested number", constraints: []ParamConstraint{{Param: "account", Claim: "account.id"}}, ok: true},
+		{name: "different", constraints: []ParamConstraint{{Param: "other", Claim: "sub"}}},
+		{name: "not in array", constraints: []ParamConstraint{{Param: "user_id", Claim: "orgs"}}},
+		{name: "missing param", constraints: []ParamConstraint{{Param: "unknown", Claim: "sub"}}},
+		{name: "missing claim", constraints: []ParamConstraint{{Param: "user_id", Claim: "owner"}}},
+		{
+			name:        "all of them",
+			constraints: []ParamConstraint{{Param: "user_id", Claim: "sub"}, {Param: "other", Claim: "sub"}},
+		},
+	} {
+		for _, hardened := range []bool{false, true} {
+			pc := NewParamConstraints(&SignatureConfig{ParamConstraints: tc.constraints, HardenedMatching: hardened})
+			authErr := pc.Check(getter, claims)
+			if tc.ok {
+				if authErr != nil {
+					t.Errorf("%s (hardened: %v): unexpected error: %v", tc.name, hardened, authErr)
+				}
+				continue
+			}
+			if authErr == nil || authErr.Reason != ReasonParamMismatch || authErr.Status != 403 {
+				t.Errorf("%s (hardened: %v): unexpected error: %v", tc.name, hardened, authErr)
+			}
+		}
+	}
+}
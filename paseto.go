@@ -0,0 +1,545 @@
+package jose
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// TokenFormatPaseto switches the validator or the signer from JOSE to PASETO tokens
+const TokenFormatPaseto = "paseto"
+
+const (
+	pasetoV2     = "v2"
+	pasetoV4     = "v4"
+	pasetoLocal  = "local"
+	pasetoPublic = "public"
+)
+
+var (
+	ErrPasetoMalformed   = errors.New("malformed PASETO token")
+	ErrPasetoUnsupported = errors.New("unsupported PASETO version or purpose")
+	ErrPasetoInvalid     = errors.New("invalid PASETO token")
+	ErrPasetoKey         = errors.New("invalid key for the PASETO version and purpose")
+)
+
+// PasetoConfig defines the PASETO version and purpose used when the token format is paseto. Local tokens
+// use a symmetric (oct) key of 32 bytes and public tokens an Ed25519 (OKP) key, both loaded from the
+// configured JWK source. The kid is sent in the footer of the tokens.
+type PasetoConfig struct {
+	// Version is either v2 or v4 (default)
+	Version string `json:"version,omitempty"`
+	// Purpose is either local or public (default)
+	Purpose string `json:"purpose,omitempty"`
+	// ImplicitAssertion is authenticated but not included in the v4 tokens
+	ImplicitAssertion string `json:"implicit_assertion,omitempty"`
+}
+
+func (p *PasetoConfig) header() (string, error) {
+	version, purpose := pasetoV4, pasetoPublic
+	var implicit string
+	if p != nil {
+		if p.Version != "" {
+			version = p.Version
+		}
+		if p.Purpose != "" {
+			purpose = p.Purpose
+		}
+		implicit = p.ImplicitAssertion
+	}
+	if version != pasetoV2 && version != pasetoV4 {
+		return "", ErrPasetoUnsupported
+	}
+	if purpose != pasetoLocal && purpose != pasetoPublic {
+		return "", ErrPasetoUnsupported
+	}
+	if version == pasetoV2 && implicit != "" {
+		return "", ErrPasetoUnsupported
+	}
+	return version + "." + purpose + ".", nil
+}
+
+type pasetoFooter struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+// pae is the pre-authentication encoding of the PASETO spec
+func pae(pieces ...[]byte) []byte {
+	size := 8
+	for _, p := range pieces {
+		size += 8 + len(p)
+	}
+	out := make([]byte, 8, size)
+	binary.LittleEndian.PutUint64(out, uint64(len(pieces)))
+	for _, p := range pieces {
+		l := make([]byte, 8)
+		binary.LittleEndian.PutUint64(l, uint64(len(p)))
+		out = append(out, l...)
+		out = append(out, p...)
+	}
+	return out
+}
+
+func pasetoSymmetricKey(key interface{}) ([]byte, error) {
+	k, ok := key.([]byte)
+	if !ok || len(k) != 32 {
+		return nil, ErrPasetoKey
+	}
+	return k, nil
+}
+
+func pasetoSeal(header string, key interface{}, message, footer, implicit []byte) (string, error) {
+	var body []byte
+	switch header {
+	case "v2.local.", "v4.local.":
+		k, err := pasetoSymmetricKey(key)
+		if err != nil {
+			return "", err
+		}
+		if header == "v2.local." {
+			body, err = pasetoV2Encrypt(k, message, footer)
+		} else {
+			body, err = pasetoV4Encrypt(k, message, footer, implicit)
+		}
+		if err != nil {
+			return "", err
+		}
+	case "v2.public.", "v4.public.":
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", ErrPasetoKey
+		}
+		m2 := pae([]byte(header), message, footer)
+		if header == "v4.public." {
+			m2 = pae([]byte(header), message, footer, implicit)
+		}
+		body = append(append([]byte{}, message...), ed25519.Sign(k, m2)...)
+	default:
+		return "", ErrPasetoUnsupported
+	}
+	return pasetoToken(header, body, footer), nil
+}
+
+// pasetoToken encodes the token with its header, body and optional footer
+func pasetoToken(header string, body, footer []byte) string {
+	token := header + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+// pasetoEncoding rejects the non canonical encodings, with unused bits set, so a token has a single form
+var pasetoEncoding = base64.RawURLEncoding.Strict()
+
+// pasetoParse decodes the body and the footer of the token, that must have the expected header
+func pasetoParse(header, token string) (body, footer []byte, err error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, ErrPasetoUnsupported
+	}
+	parts := strings.Split(token[len(header):], ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrPasetoMalformed
+	}
+	if body, err = pasetoEncoding.DecodeString(parts[0]); err != nil {
+		return nil, nil, ErrPasetoMalformed
+	}
+	if len(parts) == 2 {
+		if footer, err = pasetoEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, ErrPasetoMalformed
+		}
+	}
+	return body, footer, nil
+}
+
+func pasetoOpen(header string, key interface{}, body, footer, implicit []byte) ([]byte, error) {
+	switch header {
+	case "v2.local.", "v4.local.":
+		k, err := pasetoSymmetricKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if header == "v2.local." {
+			return pasetoV2Decrypt(k, body, footer)
+		}
+		return pasetoV4Decrypt(k, body, footer, implicit)
+	case "v2.public.", "v4.public.":
+		var pub ed25519.PublicKey
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			pub = k
+		case ed25519.PrivateKey:
+			pub = k.Public().(ed25519.PublicKey)
+		default:
+			return nil, ErrPasetoKey
+		}
+		if len(body) < ed25519.SignatureSize {
+			return nil, ErrPasetoMalformed
+		}
+		message := body[:len(body)-ed25519.SignatureSize]
+		sig := body[len(body)-ed25519.SignatureSize:]
+		m2 := pae([]byte(header), message, footer)
+		if header == "v4.public." {
+			m2 = pae([]byte(header), message, footer, implicit)
+		}
+		if !ed25519.Verify(pub, m2, sig) {
+			return nil, ErrPasetoInvalid
+		}
+		return message, nil
+	}
+	return nil, ErrPasetoUnsupported
+}
+
+func pasetoV2Encrypt(key, message, footer []byte) ([]byte, error) {
+	b := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return pasetoV2EncryptWithNonce(key, b, message, footer)
+}
+
+// pasetoV2EncryptWithNonce encrypts the message with the nonce derived from the random bytes b
+func pasetoV2EncryptWithNonce(key, b, message, footer []byte) ([]byte, error) {
+	h, err := blake2b.New(chacha20poly1305.NonceSizeX, b)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	nonce := h.Sum(nil)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, message, pae([]byte("v2.local."), nonce, footer)), nil
+}
+
+func pasetoV2Decrypt(key, body, footer []byte) ([]byte, error) {
+	if len(body) < chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, ErrPasetoMalformed
+	}
+	nonce := body[:chacha20poly1305.NonceSizeX]
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	message, err := aead.Open(nil, nonce, body[chacha20poly1305.NonceSizeX:], pae([]byte("v2.local."), nonce, footer))
+	if err != nil {
+		return nil, ErrPasetoInvalid
+	}
+	return message, nil
+}
+
+func pasetoV4Keys(key, nonce []byte) (ek, n2, ak []byte, err error) {
+	h, err := blake2b.New(56, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+
+	h, err = blake2b.New256(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(nonce)
+	return tmp[:32], tmp[32:], h.Sum(nil), nil
+}
+
+func pasetoV4Tag(ak, nonce, ciphertext, footer, implicit []byte) ([]byte, error) {
+	h, err := blake2b.New256(ak)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(pae([]byte("v4.local."), nonce, ciphertext, footer, implicit))
+	return h.Sum(nil), nil
+}
+
+func pasetoV4Encrypt(key, message, footer, implicit []byte) ([]byte, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return pasetoV4EncryptWithNonce(key, nonce, message, footer, implicit)
+}
+
+func pasetoV4EncryptWithNonce(key, nonce, message, footer, implicit []byte) ([]byte, error) {
+	ek, n2, ak, err := pasetoV4Keys(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, len(message))
+	c.XORKeyStream(ciphertext, message)
+
+	tag, err := pasetoV4Tag(ak, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, len(nonce)+len(ciphertext)+len(tag))
+	body = append(body, nonce...)
+	body = append(body, ciphertext...)
+	return append(body, tag...), nil
+}
+
+func pasetoV4Decrypt(key, body, footer, implicit []byte) ([]byte, error) {
+	if len(body) < 64 {
+		return nil, ErrPasetoMalformed
+	}
+	nonce := body[:32]
+	ciphertext := body[32 : len(body)-32]
+
+	ek, n2, ak, err := pasetoV4Keys(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := pasetoV4Tag(ak, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(tag, body[len(body)-32:]) != 1 {
+		return nil, ErrPasetoInvalid
+	}
+
+	c, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	c.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// newPasetoSigner returns a Signer generating PASETO tokens. The numeric exp, nbf and iat claims are
+// encoded as RFC 3339 strings, as required by the PASETO spec.
+func newPasetoSigner(signerCfg *SignerConfig, key jose.JSONWebKey) (Signer, error) {
+	header, err := signerCfg.Paseto.header()
+	if err != nil {
+		return nopSigner, err
+	}
+	var implicit []byte
+	if signerCfg.Paseto != nil {
+		implicit = []byte(signerCfg.Paseto.ImplicitAssertion)
+	}
+
+	var footer []byte
+	if key.KeyID != "" {
+		footer, _ = json.Marshal(pasetoFooter{KeyID: key.KeyID})
+	}
+
+	// check the key before serving any request
+	if _, err := pasetoSeal(header, key.Key, []byte("{}"), footer, implicit); err != nil {
+		return nopSigner, err
+	}
+
+	return func(v interface{}) (string, error) {
+		if claims, ok := v.(map[string]interface{}); ok {
+			v = pasetoTimeClaims(claims)
+		}
+		message, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return pasetoSeal(header, key.Key, message, footer, implicit)
+	}, nil
+}
+
+func pasetoTimeClaims(claims map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		res[k] = v
+	}
+	for _, k := range []string{"exp", "nbf", "iat"} {
+		var ts int64
+		switch n := res[k].(type) {
+		case int64:
+			ts = n
+		case int:
+			ts = int64(n)
+		case float64:
+			ts = int64(n)
+		default:
+			continue
+		}
+		res[k] = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	}
+	return res
+}
+
+// PasetoValidator validates the PASETO tokens sent with the requests
+type PasetoValidator struct {
+	header    string
+	implicit  []byte
+	issuer    string
+	audience  []string
+	cookieKey string
+	client    *JWKClient
+}
+
+// NewPasetoValidator creates a PasetoValidator using the key material of the signature config
+func NewPasetoValidator(signatureConfig *SignatureConfig) (*PasetoValidator, error) {
+	header, err := signatureConfig.Paseto.header()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := newSecretProviderConfig(signatureConfig)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := SecretProvider(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	v := &PasetoValidator{
+		header:    header,
+		issuer:    signatureConfig.Issuer,
+		audience:  signatureConfig.Audience,
+		cookieKey: signatureConfig.CookieKey,
+		client:    sp,
+	}
+	if signatureConfig.Paseto != nil {
+		v.implicit = []byte(signatureConfig.Paseto.ImplicitAssertion)
+	}
+	if v.cookieKey == "" {
+		v.cookieKey = "access_token"
+	}
+	return v, nil
+}
+
+// ValidateRequest extracts the token from the Authorization header (or the cookie), validates it and
+// returns its claims
+func (v *PasetoValidator) ValidateRequest(r *http.Request) (map[string]interface{}, error) {
+	_, token := requestToken(r, v.cookieKey)
+	if token.Raw == "" {
+		return nil, auth0.ErrTokenNotFound
+	}
+	return v.Validate(token.Raw, time.Now())
+}
+
+// rawTokenFromRequest returns the bearer (or DPoP) token of the Authorization header or, if missing, the value of
+// the cookie
+func rawTokenFromRequest(r *http.Request, cookieKey string) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return h[7:]
+	}
+	if h := r.Header.Get("Authorization"); len(h) > 5 && strings.EqualFold(h[:5], "dpop ") {
+		return h[5:]
+	}
+	if c, err := r.Cookie(cookieKey); err == nil {
+		return c.Value
+	}
+	return grpcMetadataToken(r.Context())
+}
+
+// Validate checks the token and its registered claims
+func (v *PasetoValidator) Validate(token string, now time.Time) (map[string]interface{}, error) {
+	body, footer, err := pasetoParse(v.header, token)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := pasetoFooter{}
+	if len(footer) > 0 {
+		if err := json.Unmarshal(footer, &kid); err != nil {
+			return nil, ErrPasetoMalformed
+		}
+	}
+	key, err := v.client.GetKey(kid.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := pasetoOpen(v.header, key.Key, body, footer, v.implicit)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrPasetoMalformed
+	}
+	if err := v.validateClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *PasetoValidator) validateClaims(claims map[string]interface{}, now time.Time) error {
+	if exp, ok, err := pasetoTime(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp) {
+		return jwt.ErrExpired
+	}
+	if nbf, ok, err := pasetoTime(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Before(nbf) {
+		return jwt.ErrNotValidYet
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return jwt.ErrInvalidIssuer
+		}
+	}
+
+	if len(v.audience) > 0 {
+		var aud []string
+		switch a := claims["aud"].(type) {
+		case string:
+			aud = []string{a}
+		case []interface{}:
+			for _, e := range a {
+				if s, ok := e.(string); ok {
+					aud = append(aud, s)
+				}
+			}
+		}
+		found := false
+		for _, a := range v.audience {
+			if containsString(aud, a) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return jwt.ErrInvalidAudience
+		}
+	}
+	return nil
+}
+
+func pasetoTime(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false, ErrPasetoMalformed
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, ErrPasetoMalformed
+	}
+	return t, true, nil
+}
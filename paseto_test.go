@@ -0,0 +1,303 @@
+package jose
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestPasetoSeal_v4PublicVector(t *testing.T) {
+	// test vector 4-S-1 of the PASETO spec
+	sk, _ := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	message := []byte(`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`)
+	expected := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+
+	token, err := pasetoSeal("v4.public.", ed25519.PrivateKey(sk), message, nil, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if token != expected {
+		t.Errorf("unexpected token: %s", token)
+	}
+}
+
+// pasetoVector is a test vector of the PASETO spec (github.com/paseto-standard/test-vectors)
+type pasetoVector struct {
+	Name       string  `json:"name"`
+	ExpectFail bool    `json:"expect-fail"`
+	Key        string  `json:"key"`
+	Nonce      string  `json:"nonce"`
+	PublicKey  string  `json:"public-key"`
+	SecretKey  string  `json:"secret-key"`
+	Token      string  `json:"token"`
+	Payload    *string `json:"payload"`
+	Footer     string  `json:"footer"`
+	Implicit   string  `json:"implicit-assertion"`
+}
+
+func TestPaseto_vectors(t *testing.T) {
+	for _, version := range []string{pasetoV2, pasetoV4} {
+		b, err := os.ReadFile("./fixtures/paseto-" + version + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors struct {
+			Tests []pasetoVector `json:"tests"`
+		}
+		if err := json.Unmarshal(b, &vectors); err != nil {
+			t.Fatal(err)
+		}
+		if len(vectors.Tests) == 0 {
+			t.Fatalf("%s: no test vectors", version)
+		}
+		for _, tc := range vectors.Tests {
+			t.Run(tc.Name, func(t *testing.T) {
+				testPasetoVector(t, version, tc)
+			})
+		}
+	}
+}
+
+func testPasetoVector(t *testing.T, version string, tc pasetoVector) {
+	footer := []byte(tc.Footer)
+	var implicit []byte
+	if version == pasetoV4 {
+		implicit = []byte(tc.Implicit)
+	}
+
+	// the local vectors have a symmetric key and the public ones a key pair, so a token of the other
+	// purpose must be rejected
+	header := version + "." + pasetoLocal + "."
+	var key, sealKey interface{}
+	if tc.Key != "" {
+		k := mustDecodeHex(t, tc.Key)
+		key, sealKey = k, k
+	} else {
+		header = version + "." + pasetoPublic + "."
+		key = ed25519.PublicKey(mustDecodeHex(t, tc.PublicKey))
+		sealKey = ed25519.PrivateKey(mustDecodeHex(t, tc.SecretKey))
+	}
+
+	body, tokenFooter, err := pasetoParse(header, tc.Token)
+	if err == nil {
+		var message []byte
+		if message, err = pasetoOpen(header, key, body, tokenFooter, implicit); err == nil && tc.Payload != nil {
+			if string(message) != *tc.Payload {
+				t.Errorf("unexpected payload: %s", message)
+			}
+			if string(tokenFooter) != tc.Footer {
+				t.Errorf("unexpected footer: %s", tokenFooter)
+			}
+		}
+	}
+	if tc.ExpectFail {
+		if err == nil {
+			t.Error("the token has been accepted")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the encryption and the signature are deterministic with the nonce of the vector
+	var token string
+	switch header {
+	case "v2.local.":
+		body, err = pasetoV2EncryptWithNonce(key.([]byte), mustDecodeHex(t, tc.Nonce), []byte(*tc.Payload), footer)
+		token = pasetoToken(header, body, footer)
+	case "v4.local.":
+		body, err = pasetoV4EncryptWithNonce(key.([]byte), mustDecodeHex(t, tc.Nonce), []byte(*tc.Payload), footer, implicit)
+		token = pasetoToken(header, body, footer)
+	default:
+		token, err = pasetoSeal(header, sealKey, []byte(*tc.Payload), footer, implicit)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != tc.Token {
+		t.Errorf("unexpected token: %s", token)
+	}
+
+	// any change of the footer or the implicit assertion is detected
+	if _, err := pasetoOpen(header, key, body, append(footer, '.'), implicit); err == nil {
+		t.Error("the token has been accepted with another footer")
+	}
+	if version == pasetoV4 {
+		if _, err := pasetoOpen(header, key, body, footer, append(implicit, '.')); err == nil {
+			t.Error("the token has been accepted with another implicit assertion")
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPaseto(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	symKey := make([]byte, 32)
+	rand.Read(symKey)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	b, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: edKey, KeyID: "ed"},
+		{Key: symKey, KeyID: "sym"},
+	}})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		version string
+		purpose string
+		kid     string
+	}{
+		{version: "v2", purpose: "local", kid: "sym"},
+		{version: "v2", purpose: "public", kid: "ed"},
+		{version: "v4", purpose: "local", kid: "sym"},
+		{version: "v4", purpose: "public", kid: "ed"},
+	} {
+		t.Run(tc.version+"."+tc.purpose, func(t *testing.T) {
+			pcfg := &PasetoConfig{Version: tc.version, Purpose: tc.purpose}
+			if tc.version == "v4" {
+				pcfg.ImplicitAssertion = "gateway"
+			}
+
+			key, err := loadSigningKey(SecretProviderConfig{LocalPath: path}, tc.kid, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			signer, err := newKeySigner(&SignerConfig{TokenFormat: TokenFormatPaseto, Paseto: pcfg}, key, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			now := time.Now()
+			token, err := signer(map[string]interface{}{
+				"sub": "1234",
+				"iss": "https://gateway.example.com",
+				"aud": []string{"backend"},
+				"exp": now.Add(time.Hour).Unix(),
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			v, err := NewPasetoValidator(&SignatureConfig{
+				URI:         path,
+				LocalPath:   path,
+				Issuer:      "https://gateway.example.com",
+				Audience:    []string{"backend"},
+				TokenFormat: TokenFormatPaseto,
+				Paseto:      pcfg,
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+token)
+			claims, err := v.ValidateRequest(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if claims["sub"] != "1234" {
+				t.Errorf("unexpected claims: %v", claims)
+			}
+
+			if _, err := v.Validate(token, now.Add(2*time.Hour)); err != jwt.ErrExpired {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			tampered := []byte(token)
+			tampered[len(v.header)+5] ^= 1
+			if _, err := v.Validate(string(tampered), now); err == nil {
+				t.Error("error expected")
+			}
+
+			v.issuer = "https://other.example.com"
+			if _, err := v.Validate(token, now); err != jwt.ErrInvalidIssuer {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPasetoValidator_claims(t *testing.T) {
+	v := &PasetoValidator{audience: []string{"a", "b"}}
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{
+			name:   "ok",
+			claims: map[string]interface{}{"aud": "b", "nbf": now.Add(-time.Minute).Format(time.RFC3339)},
+		},
+		{
+			name:   "not_yet_valid",
+			claims: map[string]interface{}{"aud": "a", "nbf": now.Add(time.Minute).Format(time.RFC3339)},
+			err:    jwt.ErrNotValidYet,
+		},
+		{
+			name:   "numeric_exp",
+			claims: map[string]interface{}{"aud": "a", "exp": float64(now.Unix())},
+			err:    ErrPasetoMalformed,
+		},
+		{
+			name:   "audience",
+			claims: map[string]interface{}{"aud": []interface{}{"c"}},
+			err:    jwt.ErrInvalidAudience,
+		},
+	} {
+		if err := v.validateClaims(tc.claims, now); err != tc.err {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestPasetoConfig_header(t *testing.T) {
+	for _, tc := range []struct {
+		cfg      *PasetoConfig
+		expected string
+		err      error
+	}{
+		{cfg: nil, expected: "v4.public."},
+		{cfg: &PasetoConfig{Version: "v2", Purpose: "local"}, expected: "v2.local."},
+		{cfg: &PasetoConfig{Version: "v3"}, err: ErrPasetoUnsupported},
+		{cfg: &PasetoConfig{Purpose: "secret"}, err: ErrPasetoUnsupported},
+		{cfg: &PasetoConfig{Version: "v2", ImplicitAssertion: "x"}, err: ErrPasetoUnsupported},
+	} {
+		h, err := tc.cfg.header()
+		if err != tc.err {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if h != tc.expected {
+			t.Errorf("unexpected header: %s", h)
+		}
+	}
+}
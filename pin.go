@@ -0,0 +1,166 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPinExpiryWarning = 7 * 24 * time.Hour
+	pinWarningInterval      = time.Hour
+)
+
+// decodePin decodes a pinned fingerprint. The fingerprints are the sha256 of the DER encoded
+// SubjectPublicKeyInfo of a certificate of the chain, so a certificate renewed with the same key still
+// matches. Besides the base64 URL encoding, it accepts the HPKP (pin-sha256="...") and curl (sha256//...)
+// notations, encoded in standard base64.
+func decodePin(pin string) ([]byte, error) {
+	pin = strings.TrimSpace(pin)
+	var value string
+	switch {
+	case strings.HasPrefix(pin, "pin-sha256="):
+		value = strings.Trim(pin[len("pin-sha256="):], `"`)
+	case strings.HasPrefix(pin, "sha256/"):
+		value = strings.TrimLeft(pin[len("sha256/"):], "/")
+	default:
+		return base64.URLEncoding.DecodeString(pin)
+	}
+	var f []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if f, err = enc.DecodeString(value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(f) != sha256.Size {
+		return nil, fmt.Errorf("the pin has %d bytes instead of the %d of a sha256", len(f), sha256.Size)
+	}
+	return f, nil
+}
+
+// PinConfig is a pinned fingerprint of the public key of the JWK service, valid between NotBefore and NotAfter.
+// Several pins can be valid at the same time, so the certificate of the service can be rotated without
+// lockouts: the new pin is added before the rotation and the old one expires after it.
+type PinConfig struct {
+	// Fingerprint is the sha256 of the DER encoded SubjectPublicKeyInfo, in any of the notations of the
+	// jwk_fingerprints
+	Fingerprint string `json:"fingerprint"`
+	// NotBefore is the RFC3339 time the pin becomes valid. Empty means it is already valid
+	NotBefore string `json:"not_before,omitempty"`
+	// NotAfter is the RFC3339 time the pin expires. Empty means it never expires
+	NotAfter string `json:"not_after,omitempty"`
+}
+
+// Pin is a decoded PinConfig
+type Pin struct {
+	Fingerprint []byte
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+func (p Pin) validAt(t time.Time) bool {
+	return (p.NotBefore.IsZero() || !t.Before(p.NotBefore)) && (p.NotAfter.IsZero() || t.Before(p.NotAfter))
+}
+
+// DecodePins decodes the fingerprints and parses the validity windows of the pins
+func DecodePins(in []PinConfig) ([]Pin, error) {
+	out := make([]Pin, len(in))
+	for i, p := range in {
+		f, err := decodePin(p.Fingerprint)
+		if err != nil {
+			return out, fmt.Errorf("decoding pin #%d: %s", i, err.Error())
+		}
+		out[i].Fingerprint = f
+		if p.NotBefore != "" {
+			if out[i].NotBefore, err = time.Parse(time.RFC3339, p.NotBefore); err != nil {
+				return out, fmt.Errorf("parsing the not_before of pin #%d: %s", i, err.Error())
+			}
+		}
+		if p.NotAfter != "" {
+			if out[i].NotAfter, err = time.Parse(time.RFC3339, p.NotAfter); err != nil {
+				return out, fmt.Errorf("parsing the not_after of pin #%d: %s", i, err.Error())
+			}
+		}
+		if !out[i].NotBefore.IsZero() && !out[i].NotAfter.IsZero() && !out[i].NotBefore.Before(out[i].NotAfter) {
+			return out, fmt.Errorf("pin #%d: the not_before is not before the not_after", i)
+		}
+	}
+	return out, nil
+}
+
+// pinSet is the set of fingerprints accepted by the Dialer. The static fingerprints never expire.
+type pinSet struct {
+	static     [][]byte
+	pins       []Pin
+	warnBefore time.Duration
+	now        func() time.Time
+	logger     Logger
+
+	mu          sync.Mutex
+	lastWarning time.Time
+}
+
+func newPinSet(cfg SecretProviderConfig) *pinSet {
+	warnBefore := cfg.PinExpiryWarning
+	if warnBefore == 0 {
+		warnBefore = defaultPinExpiryWarning
+	}
+	return &pinSet{
+		static:     cfg.Fingerprints,
+		pins:       cfg.Pins,
+		warnBefore: warnBefore,
+		now:        time.Now,
+		logger:     loggerOrDefault(cfg.Logger),
+	}
+}
+
+func (s *pinSet) empty() bool {
+	return len(s.static) == 0 && len(s.pins) == 0
+}
+
+// valid returns the fingerprints valid now, and logs a warning if the connection to addr is about to be
+// locked out
+func (s *pinSet) valid(addr string) [][]byte {
+	now := s.now()
+	res := append([][]byte{}, s.static...)
+	var last *Pin
+	for i := range s.pins {
+		if s.pins[i].validAt(now) {
+			res = append(res, s.pins[i].Fingerprint)
+			last = &s.pins[i]
+		}
+	}
+	if len(res) == 1 && last != nil && !last.NotAfter.IsZero() && last.NotAfter.Sub(now) < s.warnBefore && !s.renewed(last) {
+		s.warn("the only valid pin expires and there is no pin to replace it", "addr", addr, "not_after", last.NotAfter.Format(time.RFC3339))
+	}
+	return res
+}
+
+// renewed returns true if another pin is valid when the pin expires
+func (s *pinSet) renewed(p *Pin) bool {
+	for i := range s.pins {
+		if &s.pins[i] != p && s.pins[i].validAt(p.NotAfter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *pinSet) warn(msg string, keyvals ...interface{}) {
+	s.mu.Lock()
+	now := s.now()
+	if !s.lastWarning.IsZero() && now.Sub(s.lastWarning) < pinWarningInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastWarning = now
+	s.mu.Unlock()
+	s.logger.Log(LogWarning, msg, keyvals...)
+}
@@ -0,0 +1,179 @@
+package jose
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSecretProvider_pins(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	der, err := x509.MarshalPKIXPublicKey(server.Certificate().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(der)
+	fingerprint := base64.URLEncoding.EncodeToString(hash[:])
+	now := time.Now()
+
+	for i, tc := range []struct {
+		pins []PinConfig
+		ok   bool
+	}{
+		{
+			pins: []PinConfig{
+				{Fingerprint: fingerprint, NotAfter: now.Add(-time.Hour).Format(time.RFC3339)},
+			},
+		},
+		{
+			pins: []PinConfig{
+				{Fingerprint: fingerprint, NotBefore: now.Add(time.Hour).Format(time.RFC3339)},
+			},
+		},
+		{
+			pins: []PinConfig{
+				{Fingerprint: base64.URLEncoding.EncodeToString(make([]byte, 32)), NotAfter: now.Add(time.Hour).Format(time.RFC3339)},
+				{Fingerprint: fingerprint, NotBefore: now.Add(-time.Hour).Format(time.RFC3339)},
+			},
+			ok: true,
+		},
+	} {
+		pins, err := DecodePins(tc.pins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, Pins: pins}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = sp.GetKey("2011-04-29")
+		if (err == nil) != tc.ok {
+			t.Errorf("#%d: unexpected result: %v", i, err)
+		}
+		var pinErr *PinMismatchError
+		if !tc.ok && (!errors.Is(err, ErrPinMismatch) || !errors.As(err, &pinErr) || pinErr.Addr != server.Listener.Addr().String()) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestPinSet_expiryWarning(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := Pin{Fingerprint: []byte("current"), NotAfter: now.Add(48 * time.Hour)}
+	next := Pin{Fingerprint: []byte("next"), NotBefore: now.Add(24 * time.Hour)}
+
+	for i, tc := range []struct {
+		pins     []Pin
+		expected int
+	}{
+		{pins: []Pin{current}, expected: 1},
+		{pins: []Pin{current, next}},
+		{pins: []Pin{{Fingerprint: []byte("current"), NotAfter: now.Add(30 * 24 * time.Hour)}}},
+		{pins: []Pin{{Fingerprint: []byte("current")}}},
+	} {
+		var warnings []string
+		s := newPinSet(SecretProviderConfig{Pins: tc.pins})
+		s.now = func() time.Time { return now }
+		s.logger = LoggerFunc(func(level LogLevel, msg string, keyvals ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf("%s %s", level, formatLogEvent(msg, keyvals)))
+		})
+
+		for j := 0; j < 3; j++ {
+			if valid := s.valid("idp:443"); len(valid) != 1 {
+				t.Errorf("#%d: unexpected valid fingerprints: %q", i, valid)
+			}
+		}
+		if len(warnings) != tc.expected {
+			t.Errorf("#%d: unexpected warnings: %q", i, warnings)
+		}
+	}
+}
+
+func TestDecodePins(t *testing.T) {
+	for _, pins := range [][]PinConfig{
+		{{Fingerprint: "not_encoded_message"}},
+		{{Fingerprint: "Zm9v", NotBefore: "yesterday"}},
+		{{Fingerprint: "Zm9v", NotBefore: "2026-02-01T00:00:00Z", NotAfter: "2026-01-01T00:00:00Z"}},
+	} {
+		if _, err := DecodePins(pins); err == nil {
+			t.Errorf("error expected for %+v", pins)
+		}
+	}
+	errs := ValidateConfig(&SignatureConfig{
+		Alg:  "RS256",
+		URI:  "https://example.com/jwks",
+		Pins: []PinConfig{{Fingerprint: "Zm9v", NotAfter: "2020-01-01T00:00:00Z"}},
+	})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidPins {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestDecodeFingerprints_notations(t *testing.T) {
+	hash := sha256.Sum256([]byte("public key"))
+	for _, f := range []string{
+		base64.URLEncoding.EncodeToString(hash[:]),
+		"sha256/" + base64.StdEncoding.EncodeToString(hash[:]),
+		"sha256//" + base64.StdEncoding.EncodeToString(hash[:]),
+		`pin-sha256="` + base64.StdEncoding.EncodeToString(hash[:]) + `"`,
+		"pin-sha256=" + base64.RawStdEncoding.EncodeToString(hash[:]),
+	} {
+		out, err := DecodeFingerprints([]string{f})
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if !bytes.Equal(out[0], hash[:]) {
+			t.Errorf("%s: unexpected fingerprint %x", f, out[0])
+		}
+	}
+	if _, err := DecodeFingerprints([]string{"sha256/" + base64.StdEncoding.EncodeToString([]byte("short"))}); err == nil {
+		t.Error("error expected for a pin that is not a sha256")
+	}
+}
+
+func TestSecretProvider_spkiPin(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	der, err := x509.MarshalPKIXPublicKey(server.Certificate().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(der)
+	fingerprints, err := DecodeFingerprints([]string{`pin-sha256="` + base64.StdEncoding.EncodeToString(hash[:]) + `"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, Fingerprints: fingerprints}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.GetKey("2011-04-29"); err != nil {
+		t.Error(err)
+	}
+}
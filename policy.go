@@ -0,0 +1,174 @@
+package jose
+
+import (
+	"strings"
+)
+
+// MethodRequirements overrides the roles and the scopes required for the requests with a given HTTP
+// method. The empty fields keep the values of the endpoint.
+type MethodRequirements struct {
+	Roles         []string `json:"roles,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	ScopesMatcher string   `json:"scopes_matcher,omitempty"`
+	// ScopesMinCount is the number of scopes required by the at_least matcher
+	ScopesMinCount int `json:"scopes_min_count,omitempty"`
+	// RolesExpression replaces the roles expression of the endpoint
+	RolesExpression string `json:"roles_expression,omitempty"`
+	// StepUp replaces the authentication requirements of the endpoint
+	StepUp *StepUpConfig `json:"step_up,omitempty"`
+}
+
+// DenyRules rejects the tokens carrying any of the roles, the scopes or the claim values, even when they
+// satisfy the rest of the requirements. The claims follow the format of the req_claim_fields_equals ones:
+// dot separated paths and values separated by |.
+type DenyRules struct {
+	Roles  []string          `json:"roles,omitempty"`
+	Scopes []string          `json:"scopes,omitempty"`
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// Policy checks the roles, the scopes and the custom fields required by a SignatureConfig
+type Policy struct {
+	roles               []string
+	rolesPath           ClaimPath
+	aclCheck            func(ClaimPath, map[string]interface{}, []string) bool
+	scopes              []string
+	scopesPath          ClaimPath
+	scopesMatcher       func(ClaimPath, map[string]interface{}, []string) bool
+	customFields        map[string]string
+	customFieldsMatcher func(map[string]interface{}, map[string]string) bool
+	deny                *DenyRules
+	schedule            *Schedule
+	// scheduleErr rejects all the requests if the schedule is not valid
+	scheduleErr error
+	stepUp      *StepUp
+	stepUpErr   error
+	rolesExpr   *RolesExpression
+	// rolesExprErr rejects all the requests if the roles expression is not valid
+	rolesExprErr error
+	clients      *ClientRules
+	// clientsErr rejects all the requests if the client rules are not valid
+	clientsErr error
+	methods    map[string]*Policy
+}
+
+// NewPolicy returns the Policy of the signature config
+func NewPolicy(scfg *SignatureConfig) *Policy {
+	p := &Policy{
+		roles:               scfg.Roles,
+		rolesPath:           scfg.RolesPath(),
+		aclCheck:            CanAccessPath,
+		scopes:              scfg.Scopes,
+		scopesPath:          scfg.scopesPath(),
+		scopesMatcher:       ScopesDefaultPathMatcher,
+		customFields:        scfg.ReqClaimFieldsEquals,
+		customFieldsMatcher: CustomFieldsMatcher,
+		deny:                scfg.Deny,
+	}
+	p.rolesExpr, p.rolesExprErr = ParseRolesExpression(scfg.RolesExpression)
+	p.clients, p.clientsErr = NewClientRules(scfg.Clients)
+	p.schedule, p.scheduleErr = NewSchedule(scfg.Schedule)
+	p.stepUp, p.stepUpErr = NewStepUp(stepUpConfig(scfg))
+	if scfg.HardenedMatching {
+		p.aclCheck = CanAccessPathConstantTime
+		p.customFieldsMatcher = CustomFieldsConstantTimeMatcher
+	}
+	if len(scfg.Scopes) > 0 && scfg.ScopesKey != "" {
+		switch scfg.ScopesMatcher {
+		case "all":
+			p.scopesMatcher = ScopesAllPathMatcher
+		case ScopesMatcherAtLeast:
+			p.scopesMatcher = ScopesAtLeastPathMatcher(scfg.ScopesMinCount)
+		default:
+			p.scopesMatcher = ScopesAnyPathMatcher
+		}
+	}
+
+	if len(scfg.MethodRequirements) > 0 {
+		p.methods = make(map[string]*Policy, len(scfg.MethodRequirements))
+		for method, req := range scfg.MethodRequirements {
+			mcfg := *scfg
+			mcfg.MethodRequirements = nil
+			if req.Roles != nil {
+				mcfg.Roles = req.Roles
+			}
+			if req.RolesExpression != "" {
+				mcfg.RolesExpression = req.RolesExpression
+			}
+			if req.Scopes != nil {
+				mcfg.Scopes = req.Scopes
+			}
+			if req.ScopesMatcher != "" {
+				mcfg.ScopesMatcher = req.ScopesMatcher
+			}
+			if req.ScopesMinCount != 0 {
+				mcfg.ScopesMinCount = req.ScopesMinCount
+			}
+			if req.StepUp != nil {
+				mcfg.StepUp = req.StepUp
+			}
+			p.methods[strings.ToUpper(method)] = NewPolicy(&mcfg)
+		}
+	}
+	return p
+}
+
+// AuthorizeMethod is like Authorize, applying the requirements defined for the HTTP method, if any
+func (p *Policy) AuthorizeMethod(method string, claims map[string]interface{}) *AuthError {
+	if mp, ok := p.methods[method]; ok {
+		return mp.Authorize(claims)
+	}
+	return p.Authorize(claims)
+}
+
+// Authorize returns the AuthError of the first requirement not satisfied by the claims, or nil. The deny
+// rules are evaluated first, followed by the client rules.
+func (p *Policy) Authorize(claims map[string]interface{}) *AuthError {
+	if p.denied(claims) {
+		return NewForbiddenError(ReasonDenied)
+	}
+	if p.clientsErr != nil || !p.clients.Allowed(claims) {
+		return NewForbiddenError(ReasonClientNotAllowed)
+	}
+	if p.stepUpErr != nil {
+		return NewStepUpError(nil, 0)
+	}
+	if authErr := p.stepUp.Authorize(claims); authErr != nil {
+		return authErr
+	}
+	if !p.aclCheck(p.rolesPath, claims, p.roles) {
+		return NewForbiddenError(ReasonInsufficientRole)
+	}
+	if p.rolesExprErr != nil || !p.rolesExpr.Eval(func(role []string) bool { return p.aclCheck(p.rolesPath, claims, role) }) {
+		return NewForbiddenError(ReasonInsufficientRole)
+	}
+	if !p.scopesMatcher(p.scopesPath, claims, p.scopes) {
+		return NewForbiddenError(ReasonInsufficientScope, MissingScopesPath(p.scopesPath, claims, p.scopes)...)
+	}
+	if !p.customFieldsMatcher(claims, p.customFields) {
+		return NewForbiddenError(ReasonClaimMismatch)
+	}
+	if p.scheduleErr != nil || !p.schedule.Allowed(claims) {
+		return NewForbiddenError(ReasonOutsideSchedule)
+	}
+	return nil
+}
+
+// denied returns true if the claims match any of the deny rules
+func (p *Policy) denied(claims map[string]interface{}) bool {
+	if p.deny == nil {
+		return false
+	}
+	if len(p.deny.Roles) > 0 && p.aclCheck(p.rolesPath, claims, p.deny.Roles) {
+		return true
+	}
+	if len(p.deny.Scopes) > 0 && ScopesAnyPathMatcher(p.scopesPath, claims, p.deny.Scopes) {
+		return true
+	}
+	for key, values := range p.deny.Claims {
+		if p.customFieldsMatcher(claims, map[string]string{key: values}) {
+			return true
+		}
+	}
+	return false
+}
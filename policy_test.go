@@ -0,0 +1,241 @@
+package jose
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPolicy_Authorize(t *testing.T) {
+	claims := map[string]interface{}{
+		"realm":  map[string]interface{}{"roles": []interface{}{"role_a"}},
+		"scope":  "read write",
+		"tenant": "acme",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      SignatureConfig
+		expected string
+	}{
+		{name: "empty", cfg: SignatureConfig{RolesKey: "roles"}},
+		{
+			name: "nested roles",
+			cfg:  SignatureConfig{RolesKey: "realm.roles", RolesKeyIsNested: true, Roles: []string{"role_a"}},
+		},
+		{
+			name:     "missing role",
+			cfg:      SignatureConfig{RolesKey: "realm.roles", RolesKeyIsNested: true, Roles: []string{"role_b"}},
+			expected: ReasonInsufficientRole,
+		},
+		{
+			name:     "all scopes",
+			cfg:      SignatureConfig{RolesKey: "roles", ScopesKey: "scope", ScopesMatcher: "all", Scopes: []string{"read", "delete"}},
+			expected: ReasonInsufficientScope,
+		},
+		{
+			name: "any scope",
+			cfg:  SignatureConfig{RolesKey: "roles", ScopesKey: "scope", Scopes: []string{"read", "delete"}},
+		},
+		{
+			name:     "denied role",
+			cfg:      SignatureConfig{RolesKey: "realm.roles", RolesKeyIsNested: true, Deny: &DenyRules{Roles: []string{"suspended", "role_a"}}},
+			expected: ReasonDenied,
+		},
+		{
+			name:     "denied scope before the allowed ones",
+			cfg:      SignatureConfig{RolesKey: "roles", ScopesKey: "scope", Scopes: []string{"read"}, Deny: &DenyRules{Scopes: []string{"write"}}},
+			expected: ReasonDenied,
+		},
+		{
+			name:     "denied claim",
+			cfg:      SignatureConfig{RolesKey: "roles", Deny: &DenyRules{Claims: map[string]string{"blocked": "true", "tenant": "evil|acme"}}, HardenedMatching: true},
+			expected: ReasonDenied,
+		},
+		{
+			name: "not denied",
+			cfg:  SignatureConfig{RolesKey: "realm.roles", RolesKeyIsNested: true, ScopesKey: "scope", Deny: &DenyRules{Roles: []string{"suspended"}, Scopes: []string{"admin"}, Claims: map[string]string{"blocked": "true"}}},
+		},
+		{
+			name:     "custom fields",
+			cfg:      SignatureConfig{RolesKey: "roles", ReqClaimFieldsEquals: map[string]string{"tenant": "other"}, HardenedMatching: true},
+			expected: ReasonClaimMismatch,
+		},
+	} {
+		authErr := NewPolicy(&tc.cfg).Authorize(claims)
+		if tc.expected == "" {
+			if authErr != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+			}
+			continue
+		}
+		if authErr == nil || authErr.Reason != tc.expected {
+			t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+		}
+	}
+}
+
+func TestPolicy_scopesKeyFallbacks(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		RolesKey:           "roles",
+		ScopesKey:          "scope",
+		ScopesKeyFallbacks: []string{"scp", "permissions"},
+		ScopesMatcher:      "all",
+		Scopes:             []string{"read:users"},
+	})
+
+	for _, tc := range []struct {
+		name     string
+		claims   map[string]interface{}
+		expected string
+	}{
+		{name: "auth0", claims: map[string]interface{}{"scope": "openid read:users"}},
+		{name: "entra", claims: map[string]interface{}{"scp": "read:users write:users"}},
+		{name: "permissions", claims: map[string]interface{}{"permissions": []interface{}{"read:users"}}},
+		{name: "first found", claims: map[string]interface{}{"scope": "openid", "scp": "read:users"}, expected: ReasonInsufficientScope},
+		{name: "missing", claims: map[string]interface{}{"roles": "read:users"}, expected: ReasonInsufficientScope},
+	} {
+		authErr := p.Authorize(tc.claims)
+		if tc.expected == "" {
+			if authErr != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+			}
+			continue
+		}
+		if authErr == nil || authErr.Reason != tc.expected {
+			t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+		}
+	}
+}
+
+func TestPolicy_scopesMinCount(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		RolesKey:       "roles",
+		ScopesKey:      "scope",
+		Scopes:         []string{"eu", "us", "apac"},
+		ScopesMatcher:  ScopesMatcherAtLeast,
+		ScopesMinCount: 2,
+		MethodRequirements: map[string]MethodRequirements{
+			"DELETE": {ScopesMinCount: 3},
+		},
+	})
+
+	two := map[string]interface{}{"scope": "eu apac"}
+	if authErr := p.AuthorizeMethod(http.MethodGet, two); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	authErr := p.AuthorizeMethod(http.MethodGet, map[string]interface{}{"scope": "eu"})
+	if authErr == nil || authErr.Reason != ReasonInsufficientScope || len(authErr.Scope) != 2 {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if authErr := p.AuthorizeMethod(http.MethodDelete, two); authErr == nil || authErr.Reason != ReasonInsufficientScope {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+}
+
+func TestPolicy_rolesExpression(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		RolesKey:         "realm.roles",
+		RolesKeyIsNested: true,
+		RolesExpression:  "(admin OR editor) AND NOT suspended",
+		MethodRequirements: map[string]MethodRequirements{
+			"DELETE": {RolesExpression: "admin"},
+		},
+	})
+
+	for _, tc := range []struct {
+		method   string
+		roles    []interface{}
+		expected string
+	}{
+		{method: http.MethodGet, roles: []interface{}{"editor"}},
+		{method: http.MethodGet, roles: []interface{}{"editor", "suspended"}, expected: ReasonInsufficientRole},
+		{method: http.MethodGet, roles: []interface{}{"viewer"}, expected: ReasonInsufficientRole},
+		{method: http.MethodDelete, roles: []interface{}{"editor"}, expected: ReasonInsufficientRole},
+		{method: http.MethodDelete, roles: []interface{}{"admin", "suspended"}},
+	} {
+		claims := map[string]interface{}{"realm": map[string]interface{}{"roles": tc.roles}}
+		authErr := p.AuthorizeMethod(tc.method, claims)
+		if tc.expected == "" {
+			if authErr != nil {
+				t.Errorf("%s %v: unexpected error: %v", tc.method, tc.roles, authErr)
+			}
+			continue
+		}
+		if authErr == nil || authErr.Reason != tc.expected {
+			t.Errorf("%s %v: unexpected error: %v", tc.method, tc.roles, authErr)
+		}
+	}
+
+	invalid := NewPolicy(&SignatureConfig{RolesKey: "roles", RolesExpression: "admin AND"})
+	if authErr := invalid.Authorize(map[string]interface{}{"roles": "admin"}); authErr == nil || authErr.Reason != ReasonInsufficientRole {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks.json", RolesExpression: "admin AND"})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidRolesExpression {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestPolicy_clients(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		Roles:    []string{"admin"},
+		RolesKey: "roles",
+		Clients:  &ClientRulesConfig{Allow: []string{"web-*"}},
+	})
+	if authErr := p.Authorize(map[string]interface{}{"azp": "web-admin", "roles": "admin"}); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if authErr := p.Authorize(map[string]interface{}{"azp": "batch", "roles": "admin"}); authErr == nil || authErr.Reason != ReasonClientNotAllowed {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+
+	invalid := NewPolicy(&SignatureConfig{Clients: &ClientRulesConfig{}})
+	if authErr := invalid.Authorize(map[string]interface{}{"azp": "web"}); authErr == nil || authErr.Reason != ReasonClientNotAllowed {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks.json", Clients: &ClientRulesConfig{}})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidClientRules {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestPolicy_AuthorizeMethod(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		RolesKey:  "roles",
+		ScopesKey: "scope",
+		Scopes:    []string{"read"},
+		MethodRequirements: map[string]MethodRequirements{
+			"post":   {Scopes: []string{"write"}},
+			"DELETE": {Roles: []string{"admin"}, Scopes: []string{"write", "delete"}, ScopesMatcher: "all"},
+		},
+	})
+
+	reader := map[string]interface{}{"scope": "read"}
+	writer := map[string]interface{}{"scope": "read write"}
+	admin := map[string]interface{}{"scope": "write delete", "roles": []interface{}{"admin"}}
+
+	for _, tc := range []struct {
+		method   string
+		claims   map[string]interface{}
+		expected string
+	}{
+		{method: http.MethodGet, claims: reader},
+		{method: http.MethodPut, claims: reader},
+		{method: http.MethodGet, claims: admin, expected: ReasonInsufficientScope},
+		{method: http.MethodPost, claims: reader, expected: ReasonInsufficientScope},
+		{method: http.MethodPost, claims: writer},
+		{method: http.MethodDelete, claims: writer, expected: ReasonInsufficientRole},
+		{method: http.MethodDelete, claims: admin},
+	} {
+		authErr := p.AuthorizeMethod(tc.method, tc.claims)
+		if tc.expected == "" {
+			if authErr != nil {
+				t.Errorf("%s %v: unexpected error: %v", tc.method, tc.claims, authErr)
+			}
+			continue
+		}
+		if authErr == nil || authErr.Reason != tc.expected {
+			t.Errorf("%s %v: unexpected error: %v", tc.method, tc.claims, authErr)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package jose
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxPooledPropagationBuffer is the capacity above which the buffers are dropped instead of pooled, so
+	// a huge claim does not stay in memory
+	maxPooledPropagationBuffer = 16 << 10
+	// maxPropagationPlans bounds the plans cached by CalculateHeadersToPropagate, for the callers building a
+	// new config on every call
+	maxPropagationPlans = 64
+)
+
+// propagationBuffer is the scratch space of the hashes and the encodings of the propagated values. The
+// buffers are pooled, so only the final strings are allocated.
+type propagationBuffer struct {
+	hash hash.Hash
+	in   []byte
+	out  []byte
+}
+
+var propagationBuffers = sync.Pool{
+	New: func() interface{} {
+		return &propagationBuffer{hash: sha1.New()} // skipcq: GSC-G401
+	},
+}
+
+func getPropagationBuffer() *propagationBuffer {
+	return propagationBuffers.Get().(*propagationBuffer)
+}
+
+func putPropagationBuffer(b *propagationBuffer) {
+	if cap(b.in) > maxPooledPropagationBuffer || cap(b.out) > maxPooledPropagationBuffer {
+		return
+	}
+	propagationBuffers.Put(b)
+}
+
+// sha1Hex returns the hex encoded SHA1 hash of the value
+func (b *propagationBuffer) sha1Hex(v string) string {
+	b.in = append(b.in[:0], v...)
+	b.hash.Reset()
+	b.hash.Write(b.in)
+	b.in = b.hash.Sum(b.in[:0])
+	b.out = growBuffer(b.out, hex.EncodedLen(len(b.in)))
+	hex.Encode(b.out, b.in)
+	return string(b.out)
+}
+
+// encode returns the value encoded with the encoding
+func (b *propagationBuffer) encode(enc *base64.Encoding, v string) string {
+	b.in = append(b.in[:0], v...)
+	b.out = growBuffer(b.out, enc.EncodedLen(len(b.in)))
+	enc.Encode(b.out, b.in)
+	return string(b.out)
+}
+
+func growBuffer(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// propagationPlanKey identifies a propagation config by its backing array, as the configs passed to
+// CalculateHeadersToPropagate are usually parsed once and reused for every request
+type propagationPlanKey struct {
+	first *[]string
+	n     int
+}
+
+type propagationPlan struct {
+	cfg        [][]string
+	propagator *HeadersPropagator
+}
+
+var (
+	propagationPlans     sync.Map
+	propagationPlanCount int32
+)
+
+// cachedHeadersPropagator returns the HeadersPropagator of the config, parsing it only once. The cached
+// plan is replaced if the config was modified in place.
+func cachedHeadersPropagator(cfg [][]string) *HeadersPropagator {
+	key := propagationPlanKey{first: &cfg[0], n: len(cfg)}
+	v, cached := propagationPlans.Load(key)
+	if cached {
+		if plan := v.(*propagationPlan); plan.matches(cfg) {
+			return plan.propagator
+		}
+	}
+
+	p := NewHeadersPropagator(cfg)
+	if cached || atomic.AddInt32(&propagationPlanCount, 1) <= maxPropagationPlans {
+		propagationPlans.Store(key, &propagationPlan{cfg: copyPropagationConfig(cfg), propagator: p})
+	}
+	return p
+}
+
+func (p *propagationPlan) matches(cfg [][]string) bool {
+	if len(p.cfg) != len(cfg) {
+		return false
+	}
+	for i, tuple := range cfg {
+		if len(p.cfg[i]) != len(tuple) {
+			return false
+		}
+		for j := range tuple {
+			if p.cfg[i][j] != tuple[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func copyPropagationConfig(cfg [][]string) [][]string {
+	res := make([][]string, len(cfg))
+	for i, tuple := range cfg {
+		res[i] = append([]string(nil), tuple...)
+	}
+	return res
+}
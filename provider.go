@@ -0,0 +1,369 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Identity providers with a built-in profile
+const (
+	ProviderCognito  = "cognito"
+	ProviderEntra    = "entra"
+	ProviderKeycloak = "keycloak"
+	ProviderFirebase = "firebase"
+	ProviderGoogle   = "google"
+
+	firebaseKeysURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+	googleKeysURL   = "https://www.googleapis.com/oauth2/v1/certs"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidProvider = errors.New("invalid identity provider config")
+	ErrInvalidTokenUse = errors.New("invalid token use")
+	ErrInvalidTokenVer = errors.New("invalid token version")
+	ErrInvalidAuthTime = errors.New("invalid auth time")
+)
+
+// ProviderConfig selects the profile of a well-known identity provider, so the key source, the issuer and
+// the location of the roles are derived from a few settings. The values set in the signature config are
+// kept.
+//
+// The cognito profile requires the Region and the UserPoolID. The roles are read from the cognito:groups
+// claim and the scopes from the scope claim.
+//
+// The entra profile (Azure AD) requires the TenantID, that can be common, organizations or consumers for
+// the multi tenant apps. The issuer of the v1 and v2 tokens is checked against the tenant (the tid claim
+// of the token for the multi tenant apps), the app roles are read from the roles claim and the delegated
+// scopes from the scp claim. The calling app of the v1 tokens (appid) is copied to the azp claim, as in the
+// v2 ones. The audience defaults to the ClientIDs, and any of them is accepted, with or without the api://
+// prefix. The multi tenant apps require the ClientIDs or the audience, since the issuer check alone accepts
+// the tokens of any app of any tenant.
+//
+// The keycloak profile requires the BaseURL and the Realm. The realm roles (realm_access.roles) and the
+// client roles (resource_access.<client>.roles) are flattened into the roles claim, and the scopes are
+// read from the scope claim.
+//
+// The firebase profile requires the ProjectID. The keys are downloaded from the x509 certificates of the
+// Firebase secure token service, the issuer and the audience are derived from the project, and the tokens
+// must have a subject and an auth_time in the past. The roles are read from the roles custom claim.
+//
+// The google profile validates the Google Sign-In ID tokens, issued by accounts.google.com. The keys are
+// downloaded from the x509 certificates of Google and the ClientIDs are checked against the aud claim.
+type ProviderConfig struct {
+	Name string `json:"name"`
+	// Region is the AWS region of the Cognito user pool
+	Region string `json:"region,omitempty"`
+	// UserPoolID is the ID of the Cognito user pool
+	UserPoolID string `json:"user_pool_id,omitempty"`
+	// TokenUse is the accepted Cognito token type: id or access. Empty accepts both.
+	TokenUse string `json:"token_use,omitempty"`
+	// TenantID is the Entra tenant: its ID, common, organizations or consumers
+	TenantID string `json:"tenant_id,omitempty"`
+	// Version is the accepted version of the Entra tokens: v1 or v2. Empty accepts both.
+	Version string `json:"version,omitempty"`
+	// BaseURL is the URL of the Keycloak server, including the /auth path of the legacy versions
+	BaseURL string `json:"base_url,omitempty"`
+	// Realm is the Keycloak realm
+	Realm string `json:"realm,omitempty"`
+	// ProjectID is the Firebase project
+	ProjectID string `json:"project_id,omitempty"`
+	// PrefixClientRoles names the flattened Keycloak client roles as <client>:<role>
+	PrefixClientRoles bool `json:"prefix_client_roles,omitempty"`
+	// ClientIDs are the accepted app clients, checked against the aud claim of the ID tokens and the
+	// client_id claim of the Cognito access tokens. Entra tokens with the api://<client id> audience are
+	// accepted too. Empty accepts all of them, but the google profile and the multi tenant Entra apps
+	// require them or an audience. For Keycloak, they are the clients whose roles are flattened instead.
+	// Empty flattens the roles of all the clients.
+	ClientIDs []string `json:"client_ids,omitempty"`
+}
+
+// applyProvider fills the settings of the signature config derived from its provider profile
+func applyProvider(scfg *SignatureConfig) error {
+	p := scfg.Provider
+	if p == nil {
+		return nil
+	}
+	switch p.Name {
+	case ProviderCognito:
+		if p.Region == "" || p.UserPoolID == "" {
+			return fmt.Errorf("%w: cognito requires the region and the user_pool_id", ErrInvalidProvider)
+		}
+		switch p.TokenUse {
+		case "", "id", "access":
+		default:
+			return fmt.Errorf("%w: unknown token_use %q", ErrInvalidProvider, p.TokenUse)
+		}
+		issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", p.Region, p.UserPoolID)
+		setDefault(&scfg.URI, issuer+"/.well-known/jwks.json")
+		setDefault(&scfg.Issuer, issuer)
+		setDefault(&scfg.RolesKey, "cognito:groups")
+		setDefault(&scfg.ScopesKey, "scope")
+	case ProviderEntra:
+		if p.TenantID == "" {
+			return fmt.Errorf("%w: entra requires the tenant_id", ErrInvalidProvider)
+		}
+		if entraMultiTenant(p.TenantID) && len(p.ClientIDs) == 0 && len(scfg.Audience) == 0 {
+			return fmt.Errorf("%w: entra requires the client_ids or the audience with the %s tenant_id", ErrInvalidProvider, p.TenantID)
+		}
+		keys := "https://login.microsoftonline.com/" + p.TenantID + "/discovery/v2.0/keys"
+		switch p.Version {
+		case "", "v2":
+		case "v1":
+			keys = "https://login.microsoftonline.com/" + p.TenantID + "/discovery/keys"
+		default:
+			return fmt.Errorf("%w: unknown version %q", ErrInvalidProvider, p.Version)
+		}
+		setDefault(&scfg.URI, keys)
+		setDefault(&scfg.RolesKey, "roles")
+		setDefault(&scfg.ScopesKey, "scp")
+		if len(scfg.Audience) == 0 {
+			scfg.Audience = p.ClientIDs
+		}
+	case ProviderKeycloak:
+		if p.BaseURL == "" || p.Realm == "" {
+			return fmt.Errorf("%w: keycloak requires the base_url and the realm", ErrInvalidProvider)
+		}
+		issuer := strings.TrimRight(p.BaseURL, "/") + "/realms/" + p.Realm
+		setDefault(&scfg.URI, issuer+"/protocol/openid-connect/certs")
+		setDefault(&scfg.Issuer, issuer)
+		setDefault(&scfg.RolesKey, "roles")
+		setDefault(&scfg.ScopesKey, "scope")
+	case ProviderFirebase:
+		if p.ProjectID == "" {
+			return fmt.Errorf("%w: firebase requires the project_id", ErrInvalidProvider)
+		}
+		setDefault(&scfg.URI, firebaseKeysURL)
+		setDefault(&scfg.KeySetFormat, KeySetFormatX509)
+		setDefault(&scfg.Issuer, "https://securetoken.google.com/"+p.ProjectID)
+		if len(scfg.Audience) == 0 {
+			scfg.Audience = []string{p.ProjectID}
+		}
+		setDefault(&scfg.RolesKey, "roles")
+	case ProviderGoogle:
+		if len(p.ClientIDs) == 0 {
+			return fmt.Errorf("%w: google requires the client_ids", ErrInvalidProvider)
+		}
+		setDefault(&scfg.URI, googleKeysURL)
+		setDefault(&scfg.KeySetFormat, KeySetFormatX509)
+		if len(scfg.Audience) == 0 {
+			scfg.Audience = p.ClientIDs
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, p.Name)
+	}
+	setDefault(&scfg.Alg, "RS256")
+	return nil
+}
+
+func setDefault(v *string, def string) {
+	if *v == "" {
+		*v = def
+	}
+}
+
+// providerClaimsValidator adds the checks and the normalization of the provider profile to the validator
+func providerClaimsValidator(scfg *SignatureConfig, v ClaimsValidator) ClaimsValidator {
+	p := scfg.Provider
+	if p == nil {
+		return v
+	}
+	switch p.Name {
+	case ProviderCognito:
+		return func(r *http.Request) (map[string]interface{}, error) {
+			claims, err := v(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkCognitoClaims(p, claims); err != nil {
+				return nil, err
+			}
+			return claims, nil
+		}
+	case ProviderEntra:
+		return func(r *http.Request) (map[string]interface{}, error) {
+			claims, err := v(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkEntraClaims(p, scfg.Audience, claims); err != nil {
+				return nil, err
+			}
+			if _, ok := claims["azp"]; !ok {
+				if appID, ok := claims["appid"]; ok {
+					claims = withClaim(claims, NewClaimPath("azp", false), appID)
+				}
+			}
+			return claims, nil
+		}
+	case ProviderKeycloak:
+		rolesPath := scfg.RolesPath()
+		return func(r *http.Request) (map[string]interface{}, error) {
+			claims, err := v(r)
+			if err != nil {
+				return nil, err
+			}
+			return flattenKeycloakRoles(p, rolesPath, claims), nil
+		}
+	case ProviderFirebase:
+		return func(r *http.Request) (map[string]interface{}, error) {
+			claims, err := v(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkFirebaseClaims(claims, time.Now()); err != nil {
+				return nil, err
+			}
+			return claims, nil
+		}
+	case ProviderGoogle:
+		return func(r *http.Request) (map[string]interface{}, error) {
+			claims, err := v(r)
+			if err != nil {
+				return nil, err
+			}
+			if iss, _ := claims["iss"].(string); iss != "accounts.google.com" && iss != "https://accounts.google.com" {
+				return nil, jwt.ErrInvalidIssuer
+			}
+			return claims, nil
+		}
+	}
+	return v
+}
+
+// flattenKeycloakRoles adds the realm and client roles to the roles at the path, skipping the duplicates
+func flattenKeycloakRoles(p *ProviderConfig, rolesPath ClaimPath, claims map[string]interface{}) map[string]interface{} {
+	current, _ := rolesPath.Lookup(claims)
+	roles := []interface{}{}
+	seen := map[string]bool{}
+	add := func(role string) {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	for _, role := range claimValues(current) {
+		add(role)
+	}
+
+	if realm, ok := claims["realm_access"].(map[string]interface{}); ok {
+		for _, role := range claimValues(realm["roles"]) {
+			add(role)
+		}
+	}
+	resources, _ := claims["resource_access"].(map[string]interface{})
+	clients := make([]string, 0, len(resources))
+	for client := range resources {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		if len(p.ClientIDs) > 0 && !stringInSlice(client, p.ClientIDs) {
+			continue
+		}
+		access, _ := resources[client].(map[string]interface{})
+		for _, role := range claimValues(access["roles"]) {
+			if p.PrefixClientRoles {
+				role = client + ":" + role
+			}
+			add(role)
+		}
+	}
+	return withClaim(claims, rolesPath, roles)
+}
+
+func stringInSlice(s string, values []string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func checkCognitoClaims(p *ProviderConfig, claims map[string]interface{}) error {
+	tokenUse, _ := claims["token_use"].(string)
+	if p.TokenUse != "" && tokenUse != p.TokenUse {
+		return fmt.Errorf("%w: %q", ErrInvalidTokenUse, tokenUse)
+	}
+	if len(p.ClientIDs) == 0 {
+		return nil
+	}
+	var clients []string
+	if tokenUse == "access" {
+		clients = claimValues(claims["client_id"])
+	} else {
+		clients = audienceValues(claims["aud"])
+	}
+	for _, c := range clients {
+		for _, allowed := range p.ClientIDs {
+			if c == allowed {
+				return nil
+			}
+		}
+	}
+	return jwt.ErrInvalidAudience
+}
+
+// entraMultiTenant returns true for the tenant_id of the multi tenant apps, accepting the tokens of any tenant
+func entraMultiTenant(tenant string) bool {
+	switch tenant {
+	case "common", "organizations", "consumers":
+		return true
+	}
+	return false
+}
+
+func checkEntraClaims(p *ProviderConfig, audience []string, claims map[string]interface{}) error {
+	ver, _ := claims["ver"].(string)
+	switch {
+	case p.Version == "v1" && ver != "1.0", p.Version == "v2" && ver != "2.0":
+		return fmt.Errorf("%w: %q", ErrInvalidTokenVer, ver)
+	}
+
+	tenant := p.TenantID
+	if entraMultiTenant(tenant) {
+		tenant, _ = claims["tid"].(string)
+	}
+	iss, _ := claims["iss"].(string)
+	if tenant == "" || (iss != "https://sts.windows.net/"+tenant+"/" && iss != "https://login.microsoftonline.com/"+tenant+"/v2.0") {
+		return jwt.ErrInvalidIssuer
+	}
+
+	if len(audience) == 0 {
+		return nil
+	}
+	for _, aud := range audienceValues(claims["aud"]) {
+		for _, allowed := range audience {
+			if aud == allowed || aud == "api://"+allowed {
+				return nil
+			}
+		}
+	}
+	return jwt.ErrInvalidAudience
+}
+
+func checkFirebaseClaims(claims map[string]interface{}, now time.Time) error {
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return jwt.ErrInvalidSubject
+	}
+	authTime, ok := numericClaim(claims["auth_time"])
+	if !ok || authTime > now.Unix() {
+		return ErrInvalidAuthTime
+	}
+	return nil
+}
+
+// audienceValues returns the audiences of the aud claim, a string or an array
+func audienceValues(v interface{}) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	return claimValues(v)
+}
@@ -0,0 +1,318 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestGetSignatureConfig_cognito(t *testing.T) {
+	scfg, err := GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"provider": map[string]interface{}{
+					"name":         "cognito",
+					"region":       "eu-west-1",
+					"user_pool_id": "eu-west-1_AbCdEf",
+				},
+				"scopes_key": "scp",
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if scfg.URI != "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_AbCdEf/.well-known/jwks.json" {
+		t.Errorf("unexpected jwk_url: %s", scfg.URI)
+	}
+	if scfg.Issuer != "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_AbCdEf" {
+		t.Errorf("unexpected issuer: %s", scfg.Issuer)
+	}
+	if scfg.Alg != "RS256" || scfg.RolesKey != "cognito:groups" || scfg.ScopesKey != "scp" {
+		t.Errorf("unexpected config: %s %s %s", scfg.Alg, scfg.RolesKey, scfg.ScopesKey)
+	}
+
+	for _, p := range []map[string]interface{}{
+		{"name": "cognito", "region": "eu-west-1"},
+		{"name": "cognito", "region": "eu-west-1", "user_pool_id": "pool", "token_use": "refresh"},
+		{"name": "unknown"},
+	} {
+		_, err := GetSignatureConfig(&config.EndpointConfig{
+			ExtraConfig: config.ExtraConfig{ValidatorNamespace: map[string]interface{}{"provider": p}},
+		})
+		if !errors.Is(err, ErrInvalidProvider) && !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("%v: unexpected error: %v", p, err)
+		}
+	}
+}
+
+func TestProviderClaimsValidator_cognito(t *testing.T) {
+	p := &ProviderConfig{Name: ProviderCognito, TokenUse: "access", ClientIDs: []string{"app-1"}}
+	for i, tc := range []struct {
+		claims map[string]interface{}
+		err    error
+	}{
+		{claims: map[string]interface{}{"token_use": "access", "client_id": "app-1"}},
+		{claims: map[string]interface{}{"token_use": "access", "client_id": "app-2"}, err: jwt.ErrInvalidAudience},
+		{claims: map[string]interface{}{"token_use": "id", "aud": "app-1"}, err: ErrInvalidTokenUse},
+	} {
+		v := providerClaimsValidator(&SignatureConfig{Provider: p}, func(_ *http.Request) (map[string]interface{}, error) {
+			return tc.claims, nil
+		})
+		if _, err := v(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+
+	p = &ProviderConfig{Name: ProviderCognito, ClientIDs: []string{"app-1"}}
+	v := providerClaimsValidator(&SignatureConfig{Provider: p}, func(_ *http.Request) (map[string]interface{}, error) {
+		return map[string]interface{}{"token_use": "id", "aud": "app-1"}, nil
+	})
+	if _, err := v(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetSignatureConfig_entra(t *testing.T) {
+	scfg, err := GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"provider": map[string]interface{}{"name": "entra", "tenant_id": "common", "version": "v1", "client_ids": []string{"app-1"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if scfg.URI != "https://login.microsoftonline.com/common/discovery/keys" {
+		t.Errorf("unexpected jwk_url: %s", scfg.URI)
+	}
+	if scfg.Issuer != "" || scfg.RolesKey != "roles" || scfg.ScopesKey != "scp" {
+		t.Errorf("unexpected config: %s %s %s", scfg.Issuer, scfg.RolesKey, scfg.ScopesKey)
+	}
+	if len(scfg.Audience) != 1 || scfg.Audience[0] != "app-1" {
+		t.Errorf("unexpected audience: %v", scfg.Audience)
+	}
+
+	for _, tenant := range []string{"common", "organizations", "consumers"} {
+		_, err := GetSignatureConfig(&config.EndpointConfig{
+			ExtraConfig: config.ExtraConfig{
+				ValidatorNamespace: map[string]interface{}{
+					"provider": map[string]interface{}{"name": "entra", "tenant_id": tenant},
+				},
+			},
+		})
+		if !errors.Is(err, ErrInvalidProvider) {
+			t.Errorf("%s: unexpected error: %v", tenant, err)
+		}
+	}
+}
+
+func TestProviderClaimsValidator_entra(t *testing.T) {
+	tenant := "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	for i, tc := range []struct {
+		provider *ProviderConfig
+		audience []string
+		claims   map[string]interface{}
+		err      error
+	}{
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: tenant, ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "1.0", "iss": "https://sts.windows.net/" + tenant + "/", "aud": "api://app-1", "appid": "caller"},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: tenant, ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "2.0", "iss": "https://login.microsoftonline.com/" + tenant + "/v2.0", "aud": "app-1", "azp": "caller"},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: "organizations", ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "2.0", "tid": "other", "iss": "https://login.microsoftonline.com/other/v2.0", "aud": "app-1", "azp": "caller"},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: "organizations"},
+			audience: []string{"app-1", "app-2"},
+			claims:   map[string]interface{}{"ver": "2.0", "tid": "other", "iss": "https://login.microsoftonline.com/other/v2.0", "aud": "api://app-2", "azp": "caller"},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: "common", ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "2.0", "tid": "other", "iss": "https://login.microsoftonline.com/" + tenant + "/v2.0", "aud": "app-1"},
+			err:      jwt.ErrInvalidIssuer,
+		},
+		{
+			// a token of another app, issued by another tenant
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: "common", ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "2.0", "tid": "other", "iss": "https://login.microsoftonline.com/other/v2.0", "aud": "app-2"},
+			err:      jwt.ErrInvalidAudience,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: "consumers"},
+			audience: []string{"app-1"},
+			claims:   map[string]interface{}{"ver": "2.0", "tid": "other", "iss": "https://login.microsoftonline.com/other/v2.0"},
+			err:      jwt.ErrInvalidAudience,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: tenant, ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"ver": "2.0", "iss": "https://login.microsoftonline.com/" + tenant + "/v2.0", "aud": "app-2"},
+			err:      jwt.ErrInvalidAudience,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderEntra, TenantID: tenant, Version: "v2"},
+			claims:   map[string]interface{}{"ver": "1.0", "iss": "https://sts.windows.net/" + tenant + "/"},
+			err:      ErrInvalidTokenVer,
+		},
+	} {
+		scfg := &SignatureConfig{Provider: tc.provider, Audience: tc.audience}
+		if err := applyProvider(scfg); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		v := providerClaimsValidator(scfg, func(_ *http.Request) (map[string]interface{}, error) {
+			return tc.claims, nil
+		})
+		claims, err := v(httptest.NewRequest("GET", "/", nil))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if err == nil && claims["azp"] != "caller" {
+			t.Errorf("#%d: unexpected azp: %v", i, claims["azp"])
+		}
+	}
+}
+
+func TestProviderClaimsValidator_keycloak(t *testing.T) {
+	scfg, err := GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"provider": map[string]interface{}{
+					"name":                "keycloak",
+					"base_url":            "https://sso.example.com/auth/",
+					"realm":               "acme",
+					"client_ids":          []string{"billing", "orders"},
+					"prefix_client_roles": true,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if scfg.URI != "https://sso.example.com/auth/realms/acme/protocol/openid-connect/certs" {
+		t.Errorf("unexpected jwk_url: %s", scfg.URI)
+	}
+	if scfg.Issuer != "https://sso.example.com/auth/realms/acme" || scfg.RolesKey != "roles" {
+		t.Errorf("unexpected config: %s %s", scfg.Issuer, scfg.RolesKey)
+	}
+
+	v := providerClaimsValidator(scfg, func(_ *http.Request) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"roles":        []interface{}{"custom"},
+			"realm_access": map[string]interface{}{"roles": []interface{}{"offline_access", "custom"}},
+			"resource_access": map[string]interface{}{
+				"orders":  map[string]interface{}{"roles": []interface{}{"read"}},
+				"billing": map[string]interface{}{"roles": []interface{}{"admin"}},
+				"account": map[string]interface{}{"roles": []interface{}{"manage-account"}},
+			},
+		}, nil
+	})
+	claims, err := v(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []interface{}{"custom", "offline_access", "billing:admin", "orders:read"}
+	if !reflect.DeepEqual(claims["roles"], expected) {
+		t.Errorf("unexpected roles: %v", claims["roles"])
+	}
+}
+
+func TestGetSignatureConfig_firebase(t *testing.T) {
+	scfg, err := GetSignatureConfig(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			ValidatorNamespace: map[string]interface{}{
+				"provider": map[string]interface{}{"name": "firebase", "project_id": "my-project"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if scfg.URI != firebaseKeysURL || scfg.KeySetFormat != KeySetFormatX509 {
+		t.Errorf("unexpected key source: %s %s", scfg.URI, scfg.KeySetFormat)
+	}
+	if scfg.Issuer != "https://securetoken.google.com/my-project" {
+		t.Errorf("unexpected issuer: %s", scfg.Issuer)
+	}
+	if !reflect.DeepEqual(scfg.Audience, []string{"my-project"}) {
+		t.Errorf("unexpected audience: %v", scfg.Audience)
+	}
+
+	for _, p := range []map[string]interface{}{
+		{"name": "firebase"},
+		{"name": "google"},
+	} {
+		_, err := GetSignatureConfig(&config.EndpointConfig{
+			ExtraConfig: config.ExtraConfig{ValidatorNamespace: map[string]interface{}{"provider": p}},
+		})
+		if !errors.Is(err, ErrInvalidProvider) {
+			t.Errorf("%v: unexpected error: %v", p, err)
+		}
+	}
+}
+
+func TestProviderClaimsValidator_firebase(t *testing.T) {
+	now := time.Now().Unix()
+	for i, tc := range []struct {
+		provider *ProviderConfig
+		claims   map[string]interface{}
+		err      error
+	}{
+		{
+			provider: &ProviderConfig{Name: ProviderFirebase, ProjectID: "my-project"},
+			claims:   map[string]interface{}{"sub": "uid", "auth_time": json.Number(strconv.FormatInt(now-60, 10))},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderFirebase, ProjectID: "my-project"},
+			claims:   map[string]interface{}{"auth_time": json.Number(strconv.FormatInt(now-60, 10))},
+			err:      jwt.ErrInvalidSubject,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderFirebase, ProjectID: "my-project"},
+			claims:   map[string]interface{}{"sub": "uid", "auth_time": json.Number(strconv.FormatInt(now+3600, 10))},
+			err:      ErrInvalidAuthTime,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderFirebase, ProjectID: "my-project"},
+			claims:   map[string]interface{}{"sub": "uid"},
+			err:      ErrInvalidAuthTime,
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderGoogle, ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"iss": "https://accounts.google.com"},
+		},
+		{
+			provider: &ProviderConfig{Name: ProviderGoogle, ClientIDs: []string{"app-1"}},
+			claims:   map[string]interface{}{"iss": "https://securetoken.google.com/my-project"},
+			err:      jwt.ErrInvalidIssuer,
+		},
+	} {
+		v := providerClaimsValidator(&SignatureConfig{Provider: tc.provider}, func(_ *http.Request) (map[string]interface{}, error) {
+			return tc.claims, nil
+		})
+		if _, err := v(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
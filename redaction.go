@@ -0,0 +1,144 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	RedactionDrop    = "drop"
+	RedactionHash    = "hash"
+	RedactionPartial = "partial"
+
+	defaultRedactionVisible = 2
+)
+
+var (
+	ErrUnknownRedactionStrategy = errors.New("unknown redaction strategy")
+	ErrEmptyRedactionRule       = errors.New("the redaction rule requires a claim or a pattern")
+)
+
+// RedactionRule masks the claims matching its name or its pattern. Nested claims are matched by their
+// dot separated path (as user.email).
+type RedactionRule struct {
+	Claim    string `json:"claim,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Strategy string `json:"strategy"`
+	// Visible is the number of leading characters kept by the partial strategy. Defaults to 2
+	Visible int `json:"visible,omitempty"`
+}
+
+type redactionRule struct {
+	claim    string
+	pattern  *regexp.Regexp
+	strategy string
+	visible  int
+}
+
+func (r redactionRule) matches(path string) bool {
+	if r.claim != "" {
+		return r.claim == path
+	}
+	return r.pattern.MatchString(path)
+}
+
+// Redactor applies the redaction rules to the claims before they reach the logs, the audit sinks or the
+// propagated headers. A nil Redactor returns the claims untouched.
+type Redactor struct {
+	rules []redactionRule
+}
+
+// NewRedactor compiles the rules. It returns nil if there are no rules.
+func NewRedactor(rules []RedactionRule) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{rules: make([]redactionRule, len(rules))}
+	for i, rule := range rules {
+		switch rule.Strategy {
+		case RedactionDrop, RedactionHash, RedactionPartial:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRedactionStrategy, rule.Strategy)
+		}
+		compiled := redactionRule{claim: rule.Claim, strategy: rule.Strategy, visible: rule.Visible}
+		if compiled.visible <= 0 {
+			compiled.visible = defaultRedactionVisible
+		}
+		if rule.Claim == "" {
+			if rule.Pattern == "" {
+				return nil, ErrEmptyRedactionRule
+			}
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled.pattern = re
+		}
+		r.rules[i] = compiled
+	}
+	return r, nil
+}
+
+// Redact returns a copy of the claims with the rules applied. The original claims are not modified.
+func (r *Redactor) Redact(claims map[string]interface{}) map[string]interface{} {
+	if r == nil || claims == nil {
+		return claims
+	}
+	return r.redact("", claims)
+}
+
+func (r *Redactor) redact(prefix string, claims map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		path := prefix + k
+		rule, ok := r.rule(path)
+		if !ok {
+			if nested, isMap := v.(map[string]interface{}); isMap {
+				v = r.redact(path+".", nested)
+			}
+			res[k] = v
+			continue
+		}
+
+		switch rule.strategy {
+		case RedactionDrop:
+		case RedactionHash:
+			res[k] = hashClaim(v)
+		case RedactionPartial:
+			res[k] = partialClaim(v, rule.visible)
+		}
+	}
+	return res
+}
+
+func (r *Redactor) rule(path string) (redactionRule, bool) {
+	for _, rule := range r.rules {
+		if rule.matches(path) {
+			return rule, true
+		}
+	}
+	return redactionRule{}, false
+}
+
+func hashClaim(v interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+	return hex.EncodeToString(sum[:])
+}
+
+// partialClaim keeps the first visible characters of the value. The domain of the email addresses is kept.
+func partialClaim(v interface{}, visible int) string {
+	s := fmt.Sprint(v)
+	domain := ""
+	if i := strings.LastIndexByte(s, '@'); i > 0 {
+		s, domain = s[:i], s[i:]
+	}
+	runes := []rune(s)
+	if len(runes) <= visible {
+		return strings.Repeat("*", len(runes)) + domain
+	}
+	return string(runes[:visible]) + strings.Repeat("*", len(runes)-visible) + domain
+}
@@ -0,0 +1,93 @@
+package jose
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r, err := NewRedactor([]RedactionRule{
+		{Claim: "email", Strategy: RedactionPartial},
+		{Claim: "user.phone", Strategy: RedactionPartial, Visible: 3},
+		{Claim: "sub", Strategy: RedactionHash},
+		{Pattern: "^(user\\.)?ssn$", Strategy: RedactionDrop},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   "http://example.com",
+		"sub":   "1234",
+		"email": "john.doe@example.com",
+		"ssn":   "123-45-6789",
+		"user": map[string]interface{}{
+			"phone": "600123456",
+			"ssn":   "123-45-6789",
+			"name":  "John",
+		},
+	}
+	res := r.Redact(claims)
+
+	if res["iss"] != "http://example.com" {
+		t.Errorf("unexpected iss: %v", res["iss"])
+	}
+	if res["sub"] != "03ac674216f3e15c761ee1a5e255f067953623c8b388b4459e13f978d7c846f4" {
+		t.Errorf("unexpected sub: %v", res["sub"])
+	}
+	if res["email"] != "jo******@example.com" {
+		t.Errorf("unexpected email: %v", res["email"])
+	}
+	if _, ok := res["ssn"]; ok {
+		t.Error("the ssn should be dropped")
+	}
+	user := res["user"].(map[string]interface{})
+	if user["phone"] != "600******" || user["name"] != "John" {
+		t.Errorf("unexpected user: %v", user)
+	}
+	if _, ok := user["ssn"]; ok {
+		t.Error("the nested ssn should be dropped")
+	}
+
+	if claims["email"] != "john.doe@example.com" || claims["user"].(map[string]interface{})["ssn"] == nil {
+		t.Errorf("the original claims have been modified: %v", claims)
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	if r, err := NewRedactor(nil); r != nil || err != nil {
+		t.Errorf("unexpected redactor: %v %v", r, err)
+	}
+	var r *Redactor
+	claims := map[string]interface{}{"email": "john.doe@example.com"}
+	if res := r.Redact(claims); res["email"] != "john.doe@example.com" {
+		t.Errorf("unexpected claims: %v", res)
+	}
+
+	if _, err := NewRedactor([]RedactionRule{{Claim: "email", Strategy: "mask"}}); !errors.Is(err, ErrUnknownRedactionStrategy) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewRedactor([]RedactionRule{{Strategy: RedactionDrop}}); err != ErrEmptyRedactionRule {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewRedactor([]RedactionRule{{Pattern: "(", Strategy: RedactionDrop}}); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestPartialClaim(t *testing.T) {
+	for _, tc := range []struct {
+		in  interface{}
+		out string
+	}{
+		{in: "a", out: "*"},
+		{in: "ab@example.com", out: "**@example.com"},
+		{in: "abcdef", out: "ab****"},
+		{in: 123456, out: "12****"},
+	} {
+		if res := partialClaim(tc.in, 2); res != tc.out {
+			t.Errorf("%v: unexpected result: %s", tc.in, res)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package jose
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRefreshRate  = 1.0
+	defaultRefreshBurst = 1
+)
+
+var ErrRefreshThrottled = errors.New("the refresh of the key set has been throttled")
+
+// RefreshRateLimitConfig limits the requests sent to the key set endpoint, so tokens with random kids can
+// not trigger a storm of refreshes
+type RefreshRateLimitConfig struct {
+	// Rate is the number of requests per second allowed. Defaults to 1
+	Rate float64 `json:"max_rate,omitempty"`
+	// Burst is the number of requests allowed in a burst. Defaults to 1
+	Burst int `json:"burst,omitempty"`
+	// MaxWait is the maximum time a throttled refresh waits for the bucket, as "2s". Empty values reject
+	// the throttled refreshes without waiting.
+	MaxWait string `json:"max_wait,omitempty"`
+}
+
+// tokenBucket is a token bucket refilled at a constant rate
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		rate = defaultRefreshRate
+	}
+	if burst <= 0 {
+		burst = defaultRefreshBurst
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// take consumes a token if there is one available. Otherwise, it returns the time until the next one.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimitTransport throttles the requests to the key set endpoint. The throttled requests wait for the
+// bucket with a jittered backoff, so the waiting ones do not hit the endpoint at the same time.
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	bucket  *tokenBucket
+	maxWait time.Duration
+}
+
+func newRateLimitTransport(next http.RoundTripper, cfg *RefreshRateLimitConfig) (*rateLimitTransport, error) {
+	maxWait, err := parseTimeout(cfg.MaxWait)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitTransport{
+		next:    next,
+		bucket:  newTokenBucket(cfg.Rate, cfg.Burst),
+		maxWait: maxWait,
+	}, nil
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for {
+		wait := t.bucket.take()
+		if wait == 0 {
+			return t.next.RoundTrip(req)
+		}
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1)) // skipcq: GSC-G404
+		if waited+wait > t.maxWait {
+			DefaultMetrics.JWKSRefreshThrottled(req.URL.Host)
+			return nil, ErrRefreshThrottled
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		waited += wait
+	}
+}
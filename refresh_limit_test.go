@@ -0,0 +1,120 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 2)
+	now := time.Now()
+	b.last = now
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait := b.take(); wait != 0 {
+			t.Errorf("#%d: unexpected wait: %s", i, wait)
+		}
+	}
+	if wait := b.take(); wait != 500*time.Millisecond {
+		t.Errorf("unexpected wait: %s", wait)
+	}
+
+	now = now.Add(250 * time.Millisecond)
+	if wait := b.take(); wait != 250*time.Millisecond {
+		t.Errorf("unexpected wait: %s", wait)
+	}
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if wait := b.take(); wait != 0 {
+			t.Errorf("#%d: unexpected wait: %s", i, wait)
+		}
+	}
+	if wait := b.take(); wait == 0 {
+		t.Error("the bucket should not store more tokens than the burst")
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	var calls uint32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddUint32(&calls, 1)
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	rt, err := newRateLimitTransport(http.DefaultTransport, &RefreshRateLimitConfig{Rate: 0.001, Burst: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	client := &http.Client{Transport: rt}
+	m := DefaultMetrics.throttled
+	host := server.Listener.Addr().String()
+	throttled := m.get(host)
+
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL)
+		if i < 2 {
+			if err != nil {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+				continue
+			}
+			resp.Body.Close()
+			continue
+		}
+		if !errors.Is(err, ErrRefreshThrottled) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+	if c := atomic.LoadUint32(&calls); c != 2 {
+		t.Errorf("unexpected number of calls: %d", c)
+	}
+	if v := m.get(host) - throttled; v != 3 {
+		t.Errorf("unexpected number of throttled refreshes: %d", v)
+	}
+}
+
+func TestRateLimitTransport_wait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	rt, err := newRateLimitTransport(http.DefaultTransport, &RefreshRateLimitConfig{Rate: 20, MaxWait: "1s"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	client := &http.Client{Transport: rt}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("the throttled refreshes should wait for the bucket: %s", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+	if _, err := client.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := newRateLimitTransport(http.DefaultTransport, &RefreshRateLimitConfig{MaxWait: "wrong"}); err == nil {
+		t.Error("error expected")
+	}
+}
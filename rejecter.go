@@ -49,16 +49,43 @@ type ChainedRejecterFactory []RejecterFactory
 // New returns a chainned rejected that evaluates all the rejecters until v is rejected or the chain
 // is finished
 func (c ChainedRejecterFactory) New(l logging.Logger, cfg *config.EndpointConfig) Rejecter {
-	rejecters := []Rejecter{}
+	rejecters := chainedRejecter{}
 	for _, rf := range c {
 		rejecters = append(rejecters, rf.New(l, cfg))
 	}
-	return RejecterFunc(func(v map[string]interface{}) bool {
-		for _, r := range rejecters {
-			if r.Reject(v) {
-				return true
-			}
+	return rejecters
+}
+
+type chainedRejecter []Rejecter
+
+func (c chainedRejecter) Reject(v map[string]interface{}) bool {
+	for _, r := range c {
+		if r.Reject(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// RejectWithHistory calls the chained rejecters implementing the HistoryRejecter interface until the
+// claims are rejected
+func (c chainedRejecter) RejectWithHistory(v map[string]interface{}, previous *ClaimsSnapshot) bool {
+	for _, r := range c {
+		if hr, ok := r.(HistoryRejecter); ok && hr.RejectWithHistory(v, previous) {
+			return true
+		}
+	}
+	return false
+}
+
+// RevocationVersion adds the versions of the chained rejecters implementing the RevocationVersioner
+// interface, so any change in their revocation lists changes the version of the chain
+func (c chainedRejecter) RevocationVersion() uint64 {
+	var version uint64
+	for _, r := range c {
+		if rv, ok := r.(RevocationVersioner); ok {
+			version += rv.RevocationVersion()
 		}
-		return false
-	})
+	}
+	return version
 }
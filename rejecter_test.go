@@ -48,3 +48,23 @@ func TestChainedRejecterFactory(t *testing.T) {
 		}
 	}
 }
+
+func TestChainedRejecterFactory_revocationVersion(t *testing.T) {
+	r1, r2 := &versionedRejecter{version: 1}, &versionedRejecter{version: 2}
+	rf := ChainedRejecterFactory([]RejecterFactory{
+		NopRejecterFactory{},
+		RejecterFactoryFunc(func(_ logging.Logger, _ *config.EndpointConfig) Rejecter { return r1 }),
+		RejecterFactoryFunc(func(_ logging.Logger, _ *config.EndpointConfig) Rejecter { return r2 }),
+	})
+
+	rv, ok := rf.New(nil, nil).(RevocationVersioner)
+	if !ok {
+		t.Error("the chained rejecter should implement the RevocationVersioner interface")
+		return
+	}
+	before := rv.RevocationVersion()
+	r2.version++
+	if rv.RevocationVersion() == before {
+		t.Error("the version of the chain should change")
+	}
+}
@@ -0,0 +1,218 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+const defaultReloadInterval = 10 * time.Second
+
+// ReloadConfig defines the file watched by the ReloadableValidator. The file contains the validator
+// config, as in the extra config of the endpoint.
+type ReloadConfig struct {
+	File string `json:"file"`
+	// Interval between the checks of the file, as "10s". Defaults to 10s
+	Interval string `json:"interval,omitempty"`
+}
+
+// ValidatorSet groups the validator and the rules built from a SignatureConfig
+type ValidatorSet struct {
+	Config      *SignatureConfig
+	Validator   ClaimsValidator
+	Rejecter    Rejecter
+	Policy      *Policy
+	Constraints *ParamConstraints
+	Propagator  *HeadersPropagator
+}
+
+// RejecterBuilder returns the Rejecter to use with a SignatureConfig
+type RejecterBuilder func(*SignatureConfig) Rejecter
+
+// NewValidatorSet builds the validator and the rules of the signature config. A nil RejecterBuilder
+// accepts all the tokens. The revocation list of the config, if any, is checked before the rejecter.
+func NewValidatorSet(scfg *SignatureConfig, ef ExtractorFactory, rb RejecterBuilder) (*ValidatorSet, error) {
+	tokenValidator, err := newTokenClaimsValidator(scfg, ef)
+	if err != nil {
+		return nil, err
+	}
+	// the token cache needs the rejecter, so it is bound once the rest of the config has been checked
+	var cached ClaimsValidator
+	validator, err := newRequestClaimsValidator(scfg, func(r *http.Request) (map[string]interface{}, error) {
+		return cached(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := NewSchedule(scfg.Schedule); err != nil {
+		return nil, err
+	}
+	if _, err := NewClientRules(scfg.Clients); err != nil {
+		return nil, err
+	}
+	if _, err := NewStepUp(stepUpConfig(scfg)); err != nil {
+		return nil, err
+	}
+
+	var rejecter Rejecter = FixedRejecter(false)
+	if rb != nil {
+		rejecter = rb(scfg)
+	}
+	if scfg.RevocationList != nil {
+		revocations, err := sharedRevocationList(scfg.RevocationList)
+		if err != nil {
+			return nil, err
+		}
+		rejecter = chainedRejecter{revocations, rejecter}
+	}
+	cached = NewCachedClaimsValidator(tokenValidator, scfg, rejecter)
+
+	return &ValidatorSet{
+		Config:      scfg,
+		Validator:   validator,
+		Rejecter:    rejecter,
+		Policy:      NewPolicy(scfg),
+		Constraints: NewParamConstraints(scfg),
+		Propagator:  NewHeadersPropagator(scfg.PropagateClaimsToHeader),
+	}, nil
+}
+
+// ReloadableValidator keeps a ValidatorSet that can be replaced at runtime. The requests in flight keep
+// using the set they started with. Only the validator, the rejecter, the policy, the param constraints and
+// the propagation rules are replaced: the rest of the settings of the endpoint keep the values they had at
+// startup.
+type ReloadableValidator struct {
+	ef      ExtractorFactory
+	rb      RejecterBuilder
+	mu      sync.Mutex
+	current atomic.Value
+}
+
+// NewReloadableValidator returns a ReloadableValidator with the ValidatorSet of the signature config
+func NewReloadableValidator(scfg *SignatureConfig, ef ExtractorFactory, rb RejecterBuilder) (*ReloadableValidator, error) {
+	set, err := NewValidatorSet(scfg, ef, rb)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReloadableValidator{ef: ef, rb: rb}
+	r.current.Store(set)
+	return r, nil
+}
+
+// Current returns the ValidatorSet in use
+func (r *ReloadableValidator) Current() *ValidatorSet {
+	return r.current.Load().(*ValidatorSet)
+}
+
+// Validate validates the request with the current validator
+func (r *ReloadableValidator) Validate(req *http.Request) (map[string]interface{}, error) {
+	return r.Current().Validator(req)
+}
+
+// Reload replaces the ValidatorSet with the one of the signature config. If the new set can not be
+// built, the current one is kept.
+func (r *ReloadableValidator) Reload(scfg *SignatureConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if scfg.Logger == nil {
+		scfg.Logger = r.Current().Config.Logger
+	}
+	set, err := NewValidatorSet(scfg, r.ef, r.rb)
+	if err != nil {
+		return err
+	}
+	r.current.Store(set)
+	return nil
+}
+
+// Watch reloads the validator with every config received from the channel, until the context is done or
+// the channel is closed
+func (r *ReloadableValidator) Watch(ctx context.Context, configs <-chan *SignatureConfig, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case scfg, ok := <-configs:
+			if !ok {
+				return
+			}
+			if err := r.Reload(scfg); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// WatchFunc calls the load function every interval and reloads the validator with the returned config,
+// until the context is done. Nil configs are ignored.
+func (r *ReloadableValidator) WatchFunc(ctx context.Context, interval time.Duration, load func() (*SignatureConfig, error), onError func(error)) {
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		scfg, err := load()
+		if err == nil && scfg != nil {
+			err = r.Reload(scfg)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// WatchFile reloads the validator every time the file defined by the config is modified, until the
+// context is done
+func (r *ReloadableValidator) WatchFile(ctx context.Context, cfg *ReloadConfig, onError func(error)) error {
+	interval, err := parseTimeout(cfg.Interval)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(cfg.File)
+	if err != nil {
+		return err
+	}
+
+	lastMod := info.ModTime()
+	go r.WatchFunc(ctx, interval, func() (*SignatureConfig, error) {
+		info, err := os.Stat(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		if !info.ModTime().After(lastMod) {
+			return nil, nil
+		}
+		lastMod = info.ModTime()
+		return ReadSignatureConfigFile(cfg.File)
+	}, onError)
+	return nil
+}
+
+// ReadSignatureConfigFile parses the validator config stored in the file, applying the same defaults and
+// checks as GetSignatureConfig
+func ReadSignatureConfigFile(path string) (*SignatureConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return GetSignatureConfig(&config.EndpointConfig{ExtraConfig: config.ExtraConfig{ValidatorNamespace: raw}})
+}
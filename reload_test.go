@@ -0,0 +1,113 @@
+package jose
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadableValidator(t *testing.T) {
+	scfg := &SignatureConfig{Alg: "RS256", URI: "http://example.com", DisableJWKSecurity: true, RolesKey: "roles", Roles: []string{"role_a"}}
+	rejecters := 0
+	r, err := NewReloadableValidator(scfg, nopExtractor, func(_ *SignatureConfig) Rejecter {
+		rejecters++
+		return FixedRejecter(false)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	claims := map[string]interface{}{"roles": []interface{}{"role_b"}, "sub": "1234"}
+	if r.Current().Policy.Authorize(claims) == nil {
+		t.Error("the initial policy should reject the claims")
+	}
+
+	if err := r.Reload(&SignatureConfig{Alg: "unknown"}); err == nil {
+		t.Error("error expected")
+	}
+	if r.Current().Config != scfg {
+		t.Error("a failed reload should keep the current set")
+	}
+
+	configs := make(chan *SignatureConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx, configs, nil)
+		close(done)
+	}()
+	configs <- &SignatureConfig{
+		Alg:                     "RS256",
+		URI:                     "http://example.com",
+		DisableJWKSecurity:      true,
+		RolesKey:                "roles",
+		Roles:                   []string{"role_b"},
+		PropagateClaimsToHeader: [][]string{{"sub", "x-user"}},
+	}
+	cancel()
+	<-done
+
+	set := r.Current()
+	if authErr := set.Policy.Authorize(claims); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if h := set.Propagator.Propagate(claims); h["x-user"] != "1234" {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	if rejecters != 2 {
+		t.Errorf("unexpected number of rejecters built: %d", rejecters)
+	}
+}
+
+func TestReloadableValidator_WatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validator.json")
+	if err := os.WriteFile(path, []byte(`{"alg":"RS256","jwk_url":"https://example.com","roles":["role_a"]}`), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+	scfg, err := ReadSignatureConfigFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r, err := NewReloadableValidator(scfg, nopExtractor, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	if err := r.WatchFile(ctx, &ReloadConfig{File: path, Interval: "10ms"}, func(err error) { errs <- err }); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := os.WriteFile(path, []byte(`{"alg":"RS256","jwk_url":"https://example.com","roles":["role_b"]}`), 0o600); err != nil {
+		t.Error(err)
+		return
+	}
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	claims := map[string]interface{}{"roles": []interface{}{"role_b"}}
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Current().Policy.Authorize(claims) != nil {
+		if time.Now().After(deadline) {
+			t.Error("the validator has not been reloaded")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error: %v", err)
+	default:
+	}
+
+	if err := r.WatchFile(ctx, &ReloadConfig{File: path + ".missing"}, nil); err == nil {
+		t.Error("error expected")
+	}
+}
@@ -0,0 +1,290 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	RequestObjectNamespace = "github.com/DKolibar/krakend-jose/request_object"
+
+	// ErrorCodeInvalidRequestObject is the error code defined by RFC 9101 for the invalid request objects
+	ErrorCodeInvalidRequestObject = "invalid_request_object"
+
+	defaultRequestObjectParam = "request"
+)
+
+var (
+	ErrNoRequestObjectCfg      = errors.New("no request object config")
+	ErrRequestObjectMissing    = errors.New("the request object is missing")
+	ErrRequestObjectMalformed  = errors.New("the request object is malformed")
+	ErrRequestObjectClient     = errors.New("the client of the request object is unknown")
+	ErrRequestObjectAlg        = errors.New("the algorithm of the request object is not allowed")
+	ErrRequestObjectInvalid    = errors.New("the request object is not valid")
+	ErrRequestObjectLifetime   = errors.New("the lifetime of the request object is too long")
+	defaultRequestObjectAlgs   = []string{"RS256", "PS256", "ES256"}
+	requestObjectReservedNames = map[string]bool{"iss": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true}
+)
+
+// RequestObjectConfig enables the validation of the signed request objects (JAR, RFC 9101) sent by the
+// clients in the request param. The request object must be signed with a key of the client identified by
+// the client_id param, and its claims replace the params of the request, so the backends only get the
+// verified ones. It is expected at the endpoint level extra config.
+type RequestObjectConfig struct {
+	// Param is the name of the param carrying the request object. Defaults to request
+	Param string `json:"param,omitempty"`
+	// Required rejects the requests without request object
+	Required bool `json:"required,omitempty"`
+	// Audience is the required audience of the request objects, usually the issuer of the gateway
+	Audience string `json:"audience,omitempty"`
+	// MaxLifetime limits the time between the nbf (or iat) and the exp claims, in seconds or as "1h". When
+	// set, the exp claim is required.
+	MaxLifetime Seconds `json:"max_lifetime,omitempty"`
+	// ClockSkew is the leeway of the time claims, in seconds or as "30s"
+	ClockSkew Seconds `json:"clock_skew,omitempty"`
+	// Clients are the key sets of the clients, by client_id
+	Clients map[string]RequestObjectClient `json:"clients"`
+}
+
+// RequestObjectClient defines the key set of a client
+type RequestObjectClient struct {
+	URI                string   `json:"jwk_url,omitempty"`
+	LocalPath          string   `json:"jwk_local_path,omitempty"`
+	DisableJWKSecurity bool     `json:"disable_jwk_security,omitempty"`
+	Fingerprints       []string `json:"jwk_fingerprints,omitempty"`
+	LocalCA            string   `json:"jwk_local_ca,omitempty"`
+	CacheDuration      Seconds  `json:"cache_duration,omitempty"`
+	// Algs are the accepted signature algorithms. Defaults to RS256, PS256 and ES256
+	Algs []string `json:"algs,omitempty"`
+}
+
+// GetRequestObjectConfig parses the request object config from the endpoint extra config
+func GetRequestObjectConfig(cfg *config.EndpointConfig) (*RequestObjectConfig, error) {
+	tmp, ok := cfg.ExtraConfig[RequestObjectNamespace]
+	if !ok {
+		return nil, ErrNoRequestObjectCfg
+	}
+	res := new(RequestObjectConfig)
+	if err := decodeConfig(tmp, res); err != nil {
+		return nil, err
+	}
+	for clientID, c := range res.Clients {
+		if c.URI == "" && c.LocalPath == "" {
+			return res, fmt.Errorf("the client %q requires either a jwk_url or a jwk_local_path", clientID)
+		}
+		if c.LocalPath == "" && !validJWKSource(c.URI, c.DisableJWKSecurity) {
+			return res, ErrInsecureJWKSource
+		}
+	}
+	return res, nil
+}
+
+type requestObjectClient struct {
+	keys *JWKClient
+	algs []string
+}
+
+// RequestObjectValidator validates the request objects of the requests
+type RequestObjectValidator struct {
+	param       string
+	required    bool
+	audience    string
+	maxLifetime time.Duration
+	clockSkew   time.Duration
+	clients     map[string]requestObjectClient
+	now         func() time.Time
+}
+
+// NewRequestObjectValidator returns the RequestObjectValidator of the config
+func NewRequestObjectValidator(cfg *RequestObjectConfig) (*RequestObjectValidator, error) {
+	v := &RequestObjectValidator{
+		param:       cfg.Param,
+		required:    cfg.Required,
+		audience:    cfg.Audience,
+		maxLifetime: cfg.MaxLifetime.Duration(),
+		clockSkew:   cfg.ClockSkew.Duration(),
+		clients:     make(map[string]requestObjectClient, len(cfg.Clients)),
+		now:         time.Now,
+	}
+	if v.param == "" {
+		v.param = defaultRequestObjectParam
+	}
+	for clientID, c := range cfg.Clients {
+		decodedFs, err := DecodeFingerprints(c.Fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := SecretProvider(SecretProviderConfig{
+			URI:           c.URI,
+			CacheEnabled:  c.LocalPath == "",
+			CacheDuration: uint32(c.CacheDuration),
+			Fingerprints:  decodedFs,
+			LocalCA:       c.LocalCA,
+			AllowInsecure: c.DisableJWKSecurity,
+			LocalPath:     c.LocalPath,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("client %q: %s", clientID, err.Error())
+		}
+		algs := c.Algs
+		if len(algs) == 0 {
+			algs = defaultRequestObjectAlgs
+		}
+		for _, alg := range algs {
+			if _, ok := supportedAlgorithms[alg]; !ok || strings.HasPrefix(alg, "HS") {
+				return nil, fmt.Errorf("client %q: unsupported algorithm %s", clientID, alg)
+			}
+		}
+		v.clients[clientID] = requestObjectClient{keys: keys, algs: algs}
+	}
+	return v, nil
+}
+
+// Validate verifies the request object of the request and replaces the params of the request (the query
+// string or the form body) with its claims. It returns the claims of the request object, or nil if the
+// request has none and they are not required.
+func (v *RequestObjectValidator) Validate(r *http.Request) (map[string]interface{}, error) {
+	params, isForm, err := requestParams(r)
+	if err != nil {
+		return nil, ErrRequestObjectMalformed
+	}
+	raw := params.Get(v.param)
+	if raw == "" {
+		if v.required {
+			return nil, ErrRequestObjectMissing
+		}
+		return nil, nil
+	}
+	clientID := params.Get("client_id")
+	client, ok := v.clients[clientID]
+	if !ok {
+		return nil, ErrRequestObjectClient
+	}
+
+	token, err := jwt.ParseSigned(raw)
+	if err != nil || len(token.Headers) != 1 {
+		return nil, ErrRequestObjectMalformed
+	}
+	header := token.Headers[0]
+	if !stringInSlice(header.Algorithm, client.algs) {
+		return nil, ErrRequestObjectAlg
+	}
+	key, err := client.keys.GetKey(header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRequestObjectInvalid, err.Error())
+	}
+
+	claims := numberClaims{}
+	std := jwt.Claims{}
+	if err := token.Claims(publicKey(&key), &claims, &std); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRequestObjectInvalid, err.Error())
+	}
+	now := v.now()
+	expected := jwt.Expected{Issuer: clientID, Time: now}
+	if v.audience != "" {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+	if err := std.ValidateWithLeeway(expected, v.clockSkew); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRequestObjectInvalid, err.Error())
+	}
+	if c, ok := claims["client_id"]; ok && normalizeClaim(c) != clientID {
+		return nil, fmt.Errorf("%w: the client_id does not match", ErrRequestObjectInvalid)
+	}
+	if v.maxLifetime > 0 {
+		start := std.NotBefore
+		if start == nil {
+			start = std.IssuedAt
+		}
+		if std.Expiry == nil || start == nil || std.Expiry.Time().Sub(start.Time()) > v.maxLifetime {
+			return nil, ErrRequestObjectLifetime
+		}
+	}
+
+	verified := url.Values{"client_id": {clientID}}
+	for name, value := range claims {
+		if requestObjectReservedNames[name] || name == "client_id" {
+			continue
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			b, _ := json.Marshal(value)
+			verified.Set(name, string(b))
+		default:
+			verified.Set(name, normalizeClaim(value))
+		}
+	}
+	setRequestParams(r, verified, isForm)
+	return claims, nil
+}
+
+func publicKey(key *jose.JSONWebKey) interface{} {
+	if key.IsPublic() {
+		return key.Key
+	}
+	return key.Public().Key
+}
+
+// requestParams returns the params of the request: the form body of the POST requests or the query string
+func requestParams(r *http.Request) (url.Values, bool, error) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return r.URL.Query(), false, nil
+	}
+	if r.Body == nil {
+		return url.Values{}, true, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, true, err
+	}
+	params, err := url.ParseQuery(string(body))
+	return params, true, err
+}
+
+func setRequestParams(r *http.Request, params url.Values, isForm bool) {
+	encoded := params.Encode()
+	if !isForm {
+		r.URL.RawQuery = encoded
+		return
+	}
+	r.Body = io.NopCloser(strings.NewReader(encoded))
+	r.ContentLength = int64(len(encoded))
+	r.Header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	r.PostForm = nil
+	r.Form = nil
+}
+
+// NewRequestObjectError returns the error for the requests with an invalid request object
+func NewRequestObjectError(err error) *AuthError {
+	res := &AuthError{
+		Status:      http.StatusBadRequest,
+		Code:        ErrorCodeInvalidRequestObject,
+		Reason:      ErrorCodeInvalidRequestObject,
+		Description: "the request object is not valid",
+	}
+	switch {
+	case errors.Is(err, ErrRequestObjectMissing):
+		res.Code = ErrorCodeInvalidRequest
+		res.Reason = ReasonMissing
+		res.Description = err.Error()
+	case errors.Is(err, ErrRequestObjectClient):
+		res.Code = ErrorCodeInvalidRequest
+		res.Description = err.Error()
+	case errors.Is(err, ErrRequestObjectMalformed), errors.Is(err, ErrRequestObjectAlg), errors.Is(err, ErrRequestObjectLifetime):
+		res.Description = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package jose
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestRequestObjectValidator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keySet, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "partner-1", Use: "sig"}}})
+	path := filepath.Join(t.TempDir(), "partner.json")
+	if err := os.WriteFile(path, keySet, 0600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, err := NewRequestObjectValidator(&RequestObjectConfig{
+		Required:    true,
+		Audience:    "https://gateway.example.com",
+		MaxLifetime: 3600,
+		Clients:     map[string]RequestObjectClient{"bank-1": {LocalPath: path, Algs: []string{"PS256"}}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sign := func(alg jose.SignatureAlgorithm, claims map[string]interface{}) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key},
+			(&jose.SignerOptions{}).WithHeader("kid", "partner-1").WithType("oauth-authz-req+jwt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":           "bank-1",
+			"aud":           "https://gateway.example.com",
+			"client_id":     "bank-1",
+			"response_type": "code",
+			"scope":         "openid accounts",
+			"claims":        map[string]interface{}{"id_token": map[string]interface{}{"acr": map[string]interface{}{"essential": true}}},
+			"nbf":           now.Unix(),
+			"exp":           now.Add(30 * time.Minute).Unix(),
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/authorize?client_id=bank-1&scope=admin&request="+sign(jose.PS256, valid()), nil)
+	claims, err := v.Validate(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if claims["response_type"] != "code" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	q := req.URL.Query()
+	if q.Get("scope") != "openid accounts" || q.Get("client_id") != "bank-1" || q.Get("request") != "" || q.Get("iss") != "" {
+		t.Errorf("unexpected query: %s", req.URL.RawQuery)
+	}
+	if q.Get("claims") != `{"id_token":{"acr":{"essential":true}}}` {
+		t.Errorf("unexpected claims param: %s", q.Get("claims"))
+	}
+
+	form := url.Values{"client_id": {"bank-1"}, "request": {sign(jose.PS256, valid())}}
+	req = httptest.NewRequest("POST", "/par", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := v.Validate(req); err != nil {
+		t.Error(err)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	if params, _ := url.ParseQuery(string(body)); params.Get("response_type") != "code" || params.Get("request") != "" {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	wrongIssuer := valid()
+	wrongIssuer["iss"] = "bank-2"
+	tooLong := valid()
+	tooLong["exp"] = now.Add(2 * time.Hour).Unix()
+	otherClient := valid()
+	otherClient["client_id"] = "bank-2"
+
+	for i, tc := range []struct {
+		query string
+		err   error
+	}{
+		{"client_id=bank-1", ErrRequestObjectMissing},
+		{"client_id=bank-2&request=" + sign(jose.PS256, valid()), ErrRequestObjectClient},
+		{"client_id=bank-1&request=not.a.token", ErrRequestObjectMalformed},
+		{"client_id=bank-1&request=" + sign(jose.RS256, valid()), ErrRequestObjectAlg},
+		{"client_id=bank-1&request=" + sign(jose.PS256, wrongIssuer), ErrRequestObjectInvalid},
+		{"client_id=bank-1&request=" + sign(jose.PS256, otherClient), ErrRequestObjectInvalid},
+		{"client_id=bank-1&request=" + sign(jose.PS256, tooLong), ErrRequestObjectLifetime},
+	} {
+		if _, err := v.Validate(httptest.NewRequest("GET", "/authorize?"+tc.query, nil)); !errors.Is(err, tc.err) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+
+	if authErr := NewRequestObjectError(ErrRequestObjectInvalid); authErr.Status != 400 || authErr.Code != ErrorCodeInvalidRequestObject {
+		t.Errorf("unexpected error: %+v", authErr)
+	}
+}
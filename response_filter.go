@@ -0,0 +1,179 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+// ClaimsContextKey is the key of the validated claims in the context of the requests, and in the gin
+// context, as it only exposes the values stored with string keys to the proxy stack. The plugins and the
+// modifiers behind the validator get them with ClaimsFromContext.
+const ClaimsContextKey = "krakend-jose-claims"
+
+// Actions of the response filters
+const (
+	ResponseFilterRemove = "remove"
+	ResponseFilterMask   = "mask"
+
+	defaultResponseMask = "****"
+)
+
+var (
+	ErrUnknownResponseFilterAction = errors.New("unknown response filter action")
+	ErrEmptyResponseFilterField    = errors.New("response filter without field")
+)
+
+// ResponseFilterRule hides a field of the backend response to the callers without the required roles or
+// scopes. If both the roles and the scopes are defined, the caller needs one of each.
+type ResponseFilterRule struct {
+	// Field is the name of the field. Dots access nested objects, and the rule applies to every element of
+	// the arrays found in the path.
+	Field  string   `json:"field"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Action is remove (the default) or mask
+	Action string `json:"action,omitempty"`
+	// Mask is the value replacing the masked fields. Defaults to "****"
+	Mask string `json:"mask,omitempty"`
+}
+
+type responseFilterRule struct {
+	path   []string
+	roles  []string
+	scopes []string
+	mask   *string
+}
+
+// ResponseFilter removes or masks the fields of the backend responses depending on the claims of the caller
+type ResponseFilter struct {
+	rules      []responseFilterRule
+	rolesPath  ClaimPath
+	scopesPath ClaimPath
+}
+
+// NewResponseFilter returns the ResponseFilter of the signature config, or nil if there are no rules
+func NewResponseFilter(scfg *SignatureConfig) (*ResponseFilter, error) {
+	if len(scfg.ResponseFilters) == 0 {
+		return nil, nil
+	}
+	f := &ResponseFilter{
+		rules:      make([]responseFilterRule, len(scfg.ResponseFilters)),
+		rolesPath:  scfg.RolesPath(),
+		scopesPath: scfg.scopesPath(),
+	}
+	for i, r := range scfg.ResponseFilters {
+		if r.Field == "" {
+			return nil, ErrEmptyResponseFilterField
+		}
+		rule := responseFilterRule{path: strings.Split(r.Field, "."), roles: r.Roles, scopes: r.Scopes}
+		switch r.Action {
+		case "", ResponseFilterRemove:
+		case ResponseFilterMask:
+			mask := r.Mask
+			if mask == "" {
+				mask = defaultResponseMask
+			}
+			rule.mask = &mask
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownResponseFilterAction, r.Action)
+		}
+		f.rules[i] = rule
+	}
+	return f, nil
+}
+
+// Filter returns the data without the fields hidden to the caller. The data is not modified: the objects
+// and the arrays in the path of the hidden fields are copied. Without claims, all the fields of the rules
+// are hidden.
+func (f *ResponseFilter) Filter(claims, data map[string]interface{}) map[string]interface{} {
+	if f == nil || data == nil {
+		return data
+	}
+	for _, rule := range f.rules {
+		if claims != nil && f.allowed(rule, claims) {
+			continue
+		}
+		data, _ = filterField(data, rule.path, rule.mask).(map[string]interface{})
+	}
+	return data
+}
+
+func (f *ResponseFilter) allowed(rule responseFilterRule, claims map[string]interface{}) bool {
+	if len(rule.roles) > 0 && !CanAccessPath(f.rolesPath, claims, rule.roles) {
+		return false
+	}
+	return len(rule.scopes) == 0 || ScopesAnyPathMatcher(f.scopesPath, claims, rule.scopes)
+}
+
+func filterField(v interface{}, path []string, mask *string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		current, ok := v[path[0]]
+		if !ok {
+			return v
+		}
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[k] = e
+		}
+		switch {
+		case len(path) > 1:
+			res[path[0]] = filterField(current, path[1:], mask)
+		case mask != nil:
+			res[path[0]] = *mask
+		default:
+			delete(res, path[0])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = filterField(e, path, mask)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// Proxy wraps the proxy, filtering the data of its responses with the claims carried by the context
+func (f *ResponseFilter) Proxy(next proxy.Proxy) proxy.Proxy {
+	if f == nil {
+		return next
+	}
+	return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+		resp, err := next(ctx, req)
+		if resp == nil {
+			return resp, err
+		}
+		claims, _ := ClaimsFromContext(ctx)
+		filtered := *resp
+		filtered.Data = f.Filter(claims, resp.Data)
+		return &filtered, err
+	}
+}
+
+// ClaimsFromContext returns the validated claims carried by the context, if any. The claims are the ones
+// checked by the validator, once mapped and transformed.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	switch claims := ctx.Value(ClaimsContextKey).(type) {
+	case Claims:
+		return claims, true
+	case map[string]interface{}:
+		return claims, true
+	}
+	if t, ok := TokenFromContext(ctx); ok && t.Claims != nil {
+		return t.Claims, true
+	}
+	return nil, false
+}
+
+// WithClaims returns a shallow copy of the request carrying the validated claims in its context
+func WithClaims(r *http.Request, claims map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, Claims(claims)))
+}
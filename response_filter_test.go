@@ -0,0 +1,166 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/luraproject/lura/v2/proxy"
+)
+
+func TestResponseFilter_Filter(t *testing.T) {
+	f, err := NewResponseFilter(&SignatureConfig{
+		RolesKey:  "roles",
+		ScopesKey: "scope",
+		ResponseFilters: []ResponseFilterRule{
+			{Field: "salary", Scopes: []string{"hr:read"}},
+			{Field: "collection.ssn", Roles: []string{"admin"}, Action: ResponseFilterMask},
+			{Field: "manager.email", Roles: []string{"admin"}, Scopes: []string{"hr:read"}, Action: ResponseFilterMask, Mask: "hidden"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"name":   "john",
+		"salary": 1000,
+		"collection": []interface{}{
+			map[string]interface{}{"id": 1, "ssn": "123"},
+			map[string]interface{}{"id": 2},
+		},
+		"manager": map[string]interface{}{"email": "jane@example.com"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		claims   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:   "no claims",
+			claims: nil,
+			expected: map[string]interface{}{
+				"name": "john",
+				"collection": []interface{}{
+					map[string]interface{}{"id": 1, "ssn": "****"},
+					map[string]interface{}{"id": 2},
+				},
+				"manager": map[string]interface{}{"email": "hidden"},
+			},
+		},
+		{
+			name:   "scope",
+			claims: map[string]interface{}{"scope": "hr:read"},
+			expected: map[string]interface{}{
+				"name":   "john",
+				"salary": 1000,
+				"collection": []interface{}{
+					map[string]interface{}{"id": 1, "ssn": "****"},
+					map[string]interface{}{"id": 2},
+				},
+				"manager": map[string]interface{}{"email": "hidden"},
+			},
+		},
+		{
+			name:     "all",
+			claims:   map[string]interface{}{"scope": "hr:read", "roles": []interface{}{"admin"}},
+			expected: data,
+		},
+	} {
+		if res := f.Filter(tc.claims, data); !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("%s: unexpected result: %v", tc.name, res)
+		}
+	}
+
+	if _, ok := data["salary"]; !ok {
+		t.Error("the original data should not be modified")
+	}
+	if ssn := data["collection"].([]interface{})[0].(map[string]interface{})["ssn"]; ssn != "123" {
+		t.Errorf("the original data should not be modified: %v", ssn)
+	}
+}
+
+func TestResponseFilter_Proxy(t *testing.T) {
+	f, _ := NewResponseFilter(&SignatureConfig{
+		ScopesKey:       "scope",
+		ResponseFilters: []ResponseFilterRule{{Field: "salary", Scopes: []string{"hr:read"}}},
+	})
+	p := f.Proxy(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"salary": 1000}, IsComplete: true}, nil
+	})
+
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		visible bool
+	}{
+		{name: "no claims", ctx: context.Background()},
+		{name: "gin", ctx: context.WithValue(context.Background(), ClaimsContextKey, map[string]interface{}{"scope": "hr:read"}), visible: true}, // skipcq: SCC-SA1029
+		{name: "token", ctx: context.WithValue(context.Background(), tokenContextKey{}, &Token{Claims: Claims{"scope": "hr:read"}}), visible: true},
+	} {
+		resp, err := p(tc.ctx, &proxy.Request{})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if _, ok := resp.Data["salary"]; ok != tc.visible || !resp.IsComplete {
+			t.Errorf("%s: unexpected response: %+v", tc.name, resp)
+		}
+	}
+}
+
+func TestNewResponseFilter(t *testing.T) {
+	if f, err := NewResponseFilter(&SignatureConfig{}); f != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", f, err)
+	}
+	if _, err := NewResponseFilter(&SignatureConfig{ResponseFilters: []ResponseFilterRule{{Field: "a", Action: "hash"}}}); !errors.Is(err, ErrUnknownResponseFilterAction) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewResponseFilter(&SignatureConfig{ResponseFilters: []ResponseFilterRule{{}}}); !errors.Is(err, ErrEmptyResponseFilterField) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWithClaims(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, ok := ClaimsFromContext(req.Context()); ok {
+		t.Error("unexpected claims")
+	}
+
+	req = WithClaims(req, map[string]interface{}{
+		"sub":    "1234",
+		"exp":    json.Number("1735689600"),
+		"scope":  "read write",
+		"groups": []interface{}{"a", "b"},
+		"org":    map[string]interface{}{"id": float64(42)},
+	})
+	claims, ok := ClaimsFromContext(req.Context())
+	if !ok {
+		t.Error("the claims should be in the context")
+		return
+	}
+
+	if v, ok := claims.String("sub"); !ok || v != "1234" {
+		t.Errorf("unexpected sub: %s", v)
+	}
+	if v, ok := claims.String("org.id"); !ok || v != "42" {
+		t.Errorf("unexpected org.id: %s", v)
+	}
+	if v, ok := claims.Int64("exp"); !ok || v != 1735689600 {
+		t.Errorf("unexpected exp: %d", v)
+	}
+	if v := claims.Strings("scope"); !reflect.DeepEqual(v, []string{"read", "write"}) {
+		t.Errorf("unexpected scope: %v", v)
+	}
+	if v := claims.Strings("groups"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("unexpected groups: %v", v)
+	}
+	if _, ok := claims.Value("org.name"); ok {
+		t.Error("unexpected org.name")
+	}
+}
@@ -0,0 +1,147 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+)
+
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryConfig retries the failed downloads of the key set with an exponential backoff, so a transient error
+// of the JWK service does not reject the tokens signed with keys not cached yet. The retries are bounded by
+// the key_fetch timeout.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt. Defaults to 3
+	MaxRetries int `json:"max_retries,omitempty"`
+	// InitialBackoff is the wait before the first retry, as "100ms". It doubles after every retry. Defaults
+	// to 100ms
+	InitialBackoff string `json:"initial_backoff,omitempty"`
+	// MaxBackoff caps the wait between retries, as "2s". Defaults to 2s
+	MaxBackoff string `json:"max_backoff,omitempty"`
+	// StatusCodes are the status codes retried, along with the connection errors. Defaults to 429, 502, 503
+	// and 504
+	StatusCodes []int `json:"status_codes,omitempty"`
+}
+
+func (c *RetryConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("the max_retries is negative")
+	}
+	for _, code := range c.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("%d is not a status code", code)
+		}
+		if code == http.StatusOK || code == http.StatusNotModified {
+			return fmt.Errorf("the status code %d can not be retried", code)
+		}
+	}
+	initial, _ := parseTimeout(c.InitialBackoff)
+	max, _ := parseTimeout(c.MaxBackoff)
+	if initial > 0 && max > 0 && initial > max {
+		return errors.New("the initial_backoff is greater than the max_backoff")
+	}
+	return nil
+}
+
+// retryTransport retries the failed requests to the key set endpoint with a jittered exponential backoff
+type retryTransport struct {
+	next           http.RoundTripper
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	statusCodes    map[int]bool
+}
+
+func newRetryTransport(next http.RoundTripper, cfg *RetryConfig) (*retryTransport, error) {
+	initial, err := parseTimeout(cfg.InitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseTimeout(cfg.MaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	t := &retryTransport{
+		next:           next,
+		maxRetries:     cfg.MaxRetries,
+		initialBackoff: initial,
+		maxBackoff:     max,
+		statusCodes:    map[int]bool{},
+	}
+	if t.maxRetries == 0 {
+		t.maxRetries = defaultMaxRetries
+	}
+	if t.initialBackoff == 0 {
+		t.initialBackoff = defaultInitialBackoff
+	}
+	if t.maxBackoff == 0 {
+		t.maxBackoff = defaultMaxBackoff
+	}
+	codes := cfg.StatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		t.statusCodes[code] = true
+	}
+	return t, nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.initialBackoff
+	for retry := 0; ; retry++ {
+		resp, err := t.next.RoundTrip(req)
+		if retry == t.maxRetries || !t.retryable(req.Context(), resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		DefaultMetrics.JWKSFetchRetried(req.URL.Host)
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)) // skipcq: GSC-G404
+		if wait > t.maxBackoff {
+			wait = t.maxBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+func (t *retryTransport) retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrPinMismatch)
+	}
+	return t.statusCodes[resp.StatusCode]
+}
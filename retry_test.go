@@ -0,0 +1,77 @@
+package jose
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSecretProvider_retry(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		failures int64
+		status   int
+		cfg      *RetryConfig
+		hits     int64
+		ok       bool
+	}{
+		{name: "transient", failures: 2, status: http.StatusBadGateway, cfg: &RetryConfig{InitialBackoff: "1ms"}, hits: 3, ok: true},
+		{name: "exhausted", failures: 5, status: http.StatusServiceUnavailable, cfg: &RetryConfig{MaxRetries: 2, InitialBackoff: "1ms"}, hits: 3},
+		{name: "not retryable", failures: 1, status: http.StatusNotFound, cfg: &RetryConfig{InitialBackoff: "1ms"}, hits: 1},
+		{name: "custom codes", failures: 1, status: http.StatusInternalServerError, cfg: &RetryConfig{InitialBackoff: "1ms", StatusCodes: []int{500}}, hits: 2, ok: true},
+		{name: "disabled", failures: 1, status: http.StatusBadGateway, hits: 1},
+	} {
+		var hits int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if atomic.AddInt64(&hits, 1) <= tc.failures {
+				w.WriteHeader(tc.status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+		}))
+		u, _ := url.Parse(server.URL)
+		retries := DefaultMetrics.retries.get(u.Host)
+
+		sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, Retry: tc.cfg}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.GetKey("2011-04-29"); (err == nil) != tc.ok {
+			t.Errorf("%s: unexpected result: %v", tc.name, err)
+		}
+		if n := atomic.LoadInt64(&hits); n != tc.hits {
+			t.Errorf("%s: unexpected requests: %d", tc.name, n)
+		}
+		if n := DefaultMetrics.retries.get(u.Host) - retries; n != uint64(tc.hits-1) {
+			t.Errorf("%s: unexpected retries: %d", tc.name, n)
+		}
+		server.Close()
+	}
+}
+
+func TestRetryConfig_validation(t *testing.T) {
+	for _, cfg := range []*RetryConfig{
+		{MaxRetries: -1},
+		{StatusCodes: []int{1000}},
+		{StatusCodes: []int{200}},
+		{InitialBackoff: "5s", MaxBackoff: "1s"},
+	} {
+		errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks", Retry: cfg})
+		if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidRetry {
+			t.Errorf("unexpected errors for %+v: %v", cfg, errs)
+		}
+	}
+	errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks", Retry: &RetryConfig{MaxBackoff: "forever"}})
+	if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidDuration {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
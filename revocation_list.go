@@ -0,0 +1,148 @@
+package jose
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RevocationListConfig rejects the tokens whose claim is in a list stored in a Consul or etcd key. The list
+// is a JSON array of strings, or a text with a value per line, where the empty lines and the ones starting
+// with # are ignored.
+type RevocationListConfig struct {
+	Store KVStoreConfig `json:"store"`
+	// Claim is the claim looked up in the list, as "sub" or "client.id". Defaults to jti
+	Claim string `json:"claim,omitempty"`
+}
+
+func (c *RevocationListConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	return c.Store.validate()
+}
+
+// RevocationList is a Rejecter of the tokens revoked in a store. The list is replaced every time the key
+// changes, and the previous one is kept while the new one can not be read. It implements the
+// RevocationVersioner interface, so the cached tokens are checked again after every change.
+type RevocationList struct {
+	claim   ClaimPath
+	mu      sync.RWMutex
+	revoked map[string]struct{}
+	version uint64
+}
+
+// NewRevocationList reads the list of the config and watches its changes. It returns nil if there is no
+// config.
+func NewRevocationList(cfg *RevocationListConfig) (*RevocationList, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	source, err := newKVSource(&cfg.Store)
+	if err != nil {
+		return nil, err
+	}
+	v, err := source.backend.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	claim := cfg.Claim
+	if claim == "" {
+		claim = "jti"
+	}
+	r := &RevocationList{claim: NewClaimPath(claim, true)}
+	if err := r.update(v.data); err != nil {
+		return nil, err
+	}
+	if !cfg.Store.DisableWatch {
+		go source.watch(context.Background(), v.version, r.update, func(error) {})
+	}
+	return r, nil
+}
+
+// Reject returns true if the claim of the token is in the list
+func (r *RevocationList) Reject(claims map[string]interface{}) bool {
+	v, ok := r.claim.Get(claims)
+	if !ok {
+		return false
+	}
+	r.mu.RLock()
+	_, ok = r.revoked[v]
+	r.mu.RUnlock()
+	return ok
+}
+
+// RevocationVersion returns the number of times the list has been loaded
+func (r *RevocationList) RevocationVersion() uint64 {
+	return atomic.LoadUint64(&r.version)
+}
+
+func (r *RevocationList) update(data []byte) error {
+	revoked, err := parseRevocationList(data)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.revoked = revoked
+	r.mu.Unlock()
+	atomic.AddUint64(&r.version, 1)
+	return nil
+}
+
+func parseRevocationList(data []byte) (map[string]struct{}, error) {
+	data = bytes.TrimSpace(data)
+	var values []string
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%w: invalid revocation list: %s", ErrKVStore, err.Error())
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				values = append(values, line)
+			}
+		}
+	}
+
+	res := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		res[v] = struct{}{}
+	}
+	return res, nil
+}
+
+var (
+	revocationLists   = map[string]*RevocationList{}
+	revocationListsMu sync.Mutex
+)
+
+// sharedRevocationList returns the RevocationList of the config, shared by all the validators with the same
+// config, so the reloaded validators do not start new watches
+func sharedRevocationList(cfg *RevocationListConfig) (*RevocationList, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	key := hex.EncodeToString(h[:])
+
+	revocationListsMu.Lock()
+	defer revocationListsMu.Unlock()
+	if r, ok := revocationLists[key]; ok {
+		return r, nil
+	}
+	r, err := NewRevocationList(cfg)
+	if err != nil {
+		return nil, err
+	}
+	revocationLists[key] = r
+	return r, nil
+}
@@ -0,0 +1,205 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/luraproject/lura/v2/core"
+)
+
+const (
+	defaultGroupsKey          = "groups"
+	defaultRoleMappingTimeout = 10 * time.Second
+)
+
+var ErrRoleMappingSource = errors.New("unable to load the role mapping")
+
+// RoleMappingConfig translates the groups of the token (as the AD group GUIDs) into the roles required by
+// the gateway. The mapped roles are added to the roles claim before the roles are checked.
+type RoleMappingConfig struct {
+	// GroupsKey is the name of the claim with the groups. Dots access nested claims. Defaults to "groups"
+	GroupsKey string `json:"groups_key,omitempty"`
+	// Mapping contains the roles granted by every group
+	Mapping map[string][]string `json:"mapping,omitempty"`
+	// Source is a file path or an http(s) URL serving a JSON object with more mappings. Its groups
+	// replace the inline ones.
+	Source string `json:"source,omitempty"`
+	// ReloadInterval enables the periodic reload of the source, as "5m"
+	ReloadInterval string `json:"reload_interval,omitempty"`
+}
+
+// RoleMapper adds the roles mapped from the groups to the claims. A nil RoleMapper does nothing.
+type RoleMapper struct {
+	groupsPath ClaimPath
+	rolesPath  ClaimPath
+	inline     map[string][]string
+	source     string
+	interval   time.Duration
+	client     *http.Client
+	mapping    atomic.Value
+}
+
+// NewRoleMapper returns the RoleMapper of the signature config, or nil if there is no role mapping. The
+// source, if any, is loaded before returning.
+func NewRoleMapper(scfg *SignatureConfig) (*RoleMapper, error) {
+	cfg := scfg.RoleMapping
+	if cfg == nil {
+		return nil, nil
+	}
+	interval, err := parseTimeout(cfg.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	groupsKey := cfg.GroupsKey
+	if groupsKey == "" {
+		groupsKey = defaultGroupsKey
+	}
+	m := &RoleMapper{
+		groupsPath: NewClaimPath(groupsKey, true),
+		rolesPath:  scfg.RolesPath(),
+		inline:     cfg.Mapping,
+		source:     cfg.Source,
+		interval:   interval,
+		client:     &http.Client{Timeout: defaultRoleMappingTimeout},
+	}
+	m.mapping.Store(cfg.Mapping)
+	if err := m.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the mapping from the source again. The current mapping is kept if the source can not be
+// loaded.
+func (m *RoleMapper) Reload(ctx context.Context) error {
+	if m == nil || m.source == "" {
+		return nil
+	}
+	loaded, err := m.load(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRoleMappingSource, err.Error())
+	}
+	mapping := make(map[string][]string, len(m.inline)+len(loaded))
+	for group, roles := range m.inline {
+		mapping[group] = roles
+	}
+	for group, roles := range loaded {
+		mapping[group] = roles
+	}
+	m.mapping.Store(mapping)
+	return nil
+}
+
+func (m *RoleMapper) load(ctx context.Context) (map[string][]string, error) {
+	var data []byte
+	if strings.HasPrefix(m.source, "http://") || strings.HasPrefix(m.source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.source, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", core.KrakendUserAgent)
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(m.source); err != nil {
+			return nil, err
+		}
+	}
+	mapping := map[string][]string{}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// Watch reloads the source every reload interval, until the context is done. It returns immediately if
+// there is no source or no reload interval.
+func (m *RoleMapper) Watch(ctx context.Context, onError func(error)) {
+	if m == nil || m.source == "" || m.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Reload(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Map returns the claims with the roles of their groups added to the roles claim. The given claims are not
+// modified. The claims without mapped groups are returned as they are.
+func (m *RoleMapper) Map(claims map[string]interface{}) map[string]interface{} {
+	if m == nil || claims == nil {
+		return claims
+	}
+	v, ok := m.groupsPath.Lookup(claims)
+	if !ok {
+		return claims
+	}
+	mapping, _ := m.mapping.Load().(map[string][]string)
+
+	var mapped []string
+	for _, group := range claimValues(v) {
+		mapped = append(mapped, mapping[group]...)
+	}
+	if len(mapped) == 0 {
+		return claims
+	}
+
+	current, _ := m.rolesPath.Lookup(claims)
+	roles := []interface{}{}
+	seen := map[string]struct{}{}
+	for _, role := range append(claimValues(current), mapped...) {
+		if _, ok := seen[role]; ok {
+			continue
+		}
+		seen[role] = struct{}{}
+		roles = append(roles, role)
+	}
+	return withClaim(claims, m.rolesPath, roles)
+}
+
+// withClaim returns a copy of the claims with the value set at the path. The nested objects in the path
+// are copied too.
+func withClaim(claims map[string]interface{}, path ClaimPath, v interface{}) map[string]interface{} {
+	keys := path.keys
+	if keys == nil {
+		keys = []string{path.name}
+	}
+	res := make(map[string]interface{}, len(claims)+1)
+	for k, e := range claims {
+		res[k] = e
+	}
+	if len(keys) == 1 {
+		res[keys[0]] = v
+		return res
+	}
+	nested, _ := res[keys[0]].(map[string]interface{})
+	res[keys[0]] = withClaim(nested, ClaimPath{name: strings.Join(keys[1:], "."), keys: keys[1:]}, v)
+	return res
+}
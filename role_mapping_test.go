@@ -0,0 +1,157 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoleMapper_Map(t *testing.T) {
+	m, err := NewRoleMapper(&SignatureConfig{
+		RolesKey: "roles",
+		Roles:    []string{"admin"},
+		RoleMapping: &RoleMappingConfig{
+			Mapping: map[string][]string{
+				"8f4c2a1e": {"admin", "reader"},
+				"1b2d3c4e": {"reader"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"sub":    "1234",
+		"roles":  []interface{}{"user"},
+		"groups": []interface{}{"8f4c2a1e", "1b2d3c4e", "unknown"},
+	}
+	res := m.Map(claims)
+	if roles := res["roles"]; !reflect.DeepEqual(roles, []interface{}{"user", "admin", "reader"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+	if roles := claims["roles"]; !reflect.DeepEqual(roles, []interface{}{"user"}) {
+		t.Errorf("the claims have been modified: %v", roles)
+	}
+	if !CanAccessPath(m.rolesPath, res, []string{"admin"}) {
+		t.Error("the mapped role has not been accepted")
+	}
+
+	noGroups := map[string]interface{}{"sub": "1234"}
+	if res := m.Map(noGroups); !reflect.DeepEqual(res, noGroups) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+}
+
+func TestRoleMapper_Map_nested(t *testing.T) {
+	m, err := NewRoleMapper(&SignatureConfig{
+		RolesKey:         "realm_access.roles",
+		RolesKeyIsNested: true,
+		RoleMapping: &RoleMappingConfig{
+			GroupsKey: "ext.groups",
+			Mapping:   map[string][]string{"g1": {"admin"}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res := m.Map(map[string]interface{}{
+		"ext":          map[string]interface{}{"groups": "g1 g2"},
+		"realm_access": map[string]interface{}{"other": true},
+	})
+	realm, _ := res["realm_access"].(map[string]interface{})
+	if !reflect.DeepEqual(realm, map[string]interface{}{"other": true, "roles": []interface{}{"admin"}}) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+}
+
+func TestRoleMapper_source(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	if err := os.WriteFile(path, []byte(`{"g1":["reader"]}`), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	m, err := NewRoleMapper(&SignatureConfig{
+		RolesKey: "roles",
+		RoleMapping: &RoleMappingConfig{
+			Mapping: map[string][]string{"g1": {"admin"}, "g2": {"admin"}},
+			Source:  path,
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res := m.Map(map[string]interface{}{"groups": []interface{}{"g1"}})
+	if roles := res["roles"]; !reflect.DeepEqual(roles, []interface{}{"reader"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"g1":["writer"]}`), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := m.Reload(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	res = m.Map(map[string]interface{}{"groups": []interface{}{"g1", "g2"}})
+	if roles := res["roles"]; !reflect.DeepEqual(roles, []interface{}{"writer", "admin"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	if err := os.WriteFile(path, []byte(`not json`), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := m.Reload(context.Background()); !errors.Is(err, ErrRoleMappingSource) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	res = m.Map(map[string]interface{}{"groups": []interface{}{"g1"}})
+	if roles := res["roles"]; !reflect.DeepEqual(roles, []interface{}{"writer"}) {
+		t.Errorf("the previous mapping has not been kept: %v", roles)
+	}
+}
+
+func TestRoleMapper_sourceURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"g1":["admin"]}`))
+	}))
+	defer server.Close()
+
+	m, err := NewRoleMapper(&SignatureConfig{RolesKey: "roles", RoleMapping: &RoleMappingConfig{Source: server.URL}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res := m.Map(map[string]interface{}{"groups": []interface{}{"g1"}})
+	if roles := res["roles"]; !reflect.DeepEqual(roles, []interface{}{"admin"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := NewRoleMapper(&SignatureConfig{RoleMapping: &RoleMappingConfig{Source: notFound.URL}}); !errors.Is(err, ErrRoleMappingSource) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRoleMapper_nil(t *testing.T) {
+	m, err := NewRoleMapper(&SignatureConfig{})
+	if m != nil || err != nil {
+		t.Errorf("unexpected mapper: %v, %v", m, err)
+	}
+	claims := map[string]interface{}{"groups": []interface{}{"g1"}}
+	if res := m.Map(claims); !reflect.DeepEqual(res, claims) {
+		t.Errorf("unexpected claims: %v", res)
+	}
+}
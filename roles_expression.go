@@ -0,0 +1,246 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRolesExpression = errors.New("invalid roles expression")
+
+// RolesExpression is a boolean expression of the roles required to access an endpoint, as
+// "(admin OR editor) AND NOT suspended", so the identity provider does not need composite roles for each
+// combination. NOT binds tighter than AND, and AND tighter than OR. The operators are case insensitive
+// and the roles named as an operator, or with spaces or parentheses, are quoted: "NOT" OR "team a".
+type RolesExpression struct {
+	source string
+	root   rolesNode
+}
+
+// ParseRolesExpression parses the expression. It returns nil if the expression is empty.
+func ParseRolesExpression(expr string) (*RolesExpression, error) {
+	tokens, err := tokenizeRolesExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &rolesParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %s", ErrInvalidRolesExpression, p.tokens[p.pos].text)
+	}
+	return &RolesExpression{source: expr, root: root}, nil
+}
+
+// Eval returns the value of the expression. has returns true if the claims have the role, passed as a
+// single element slice, as the required roles of CanAccessPath.
+func (e *RolesExpression) Eval(has func(role []string) bool) bool {
+	if e == nil {
+		return true
+	}
+	return e.root.eval(has)
+}
+
+// String returns the source of the expression
+func (e *RolesExpression) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.source
+}
+
+type rolesNode interface {
+	eval(has func([]string) bool) bool
+}
+
+// roleNode is the single element slice of a role, allocated once
+type roleNode []string
+
+func (n roleNode) eval(has func([]string) bool) bool {
+	return has(n)
+}
+
+type notNode struct {
+	x rolesNode
+}
+
+func (n notNode) eval(has func([]string) bool) bool {
+	return !n.x.eval(has)
+}
+
+type andNode []rolesNode
+
+func (n andNode) eval(has func([]string) bool) bool {
+	for _, x := range n {
+		if !x.eval(has) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []rolesNode
+
+func (n orNode) eval(has func([]string) bool) bool {
+	for _, x := range n {
+		if x.eval(has) {
+			return true
+		}
+	}
+	return false
+}
+
+type rolesTokenKind int
+
+const (
+	rolesTokenRole rolesTokenKind = iota
+	rolesTokenAnd
+	rolesTokenOr
+	rolesTokenNot
+	rolesTokenOpen
+	rolesTokenClose
+)
+
+type rolesToken struct {
+	kind rolesTokenKind
+	text string
+	role string
+}
+
+func tokenizeRolesExpression(expr string) ([]rolesToken, error) {
+	var tokens []rolesToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, rolesToken{kind: rolesTokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, rolesToken{kind: rolesTokenClose, text: ")"})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated quoted role at %d", ErrInvalidRolesExpression, i)
+			}
+			role, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRolesExpression, err.Error())
+			}
+			tokens = append(tokens, rolesToken{kind: rolesTokenRole, text: expr[i : end+1], role: role})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\n\r()\"", rune(expr[end])) {
+				end++
+			}
+			word := expr[i:end]
+			t := rolesToken{kind: rolesTokenRole, text: word, role: word}
+			switch strings.ToUpper(word) {
+			case "AND":
+				t.kind = rolesTokenAnd
+			case "OR":
+				t.kind = rolesTokenOr
+			case "NOT":
+				t.kind = rolesTokenNot
+			}
+			tokens = append(tokens, t)
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// rolesParser is a recursive descent parser of the grammar:
+//
+//	or    = and { OR and }
+//	and   = unary { AND unary }
+//	unary = NOT unary | "(" or ")" | role
+type rolesParser struct {
+	tokens []rolesToken
+	pos    int
+}
+
+func (p *rolesParser) accept(kind rolesTokenKind) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *rolesParser) or() (rolesNode, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	nodes := orNode{x}
+	for p.accept(rolesTokenOr) {
+		if x, err = p.and(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, x)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *rolesParser) and() (rolesNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := andNode{x}
+	for p.accept(rolesTokenAnd) {
+		if x, err = p.unary(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, x)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *rolesParser) unary() (rolesNode, error) {
+	if p.pos == len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of the expression", ErrInvalidRolesExpression)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case rolesTokenNot:
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case rolesTokenOpen:
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(rolesTokenClose) {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidRolesExpression)
+		}
+		return x, nil
+	case rolesTokenRole:
+		return roleNode{t.role}, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected %s", ErrInvalidRolesExpression, t.text)
+}
@@ -0,0 +1,57 @@
+package jose
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRolesExpression(t *testing.T) {
+	for _, tc := range []struct {
+		expr     string
+		roles    []string
+		expected bool
+	}{
+		{expr: "admin", roles: []string{"admin"}, expected: true},
+		{expr: "admin", roles: []string{"editor"}},
+		{expr: "(admin OR editor) AND NOT suspended", roles: []string{"editor"}, expected: true},
+		{expr: "(admin OR editor) AND NOT suspended", roles: []string{"admin", "suspended"}},
+		{expr: "(admin OR editor) AND NOT suspended", roles: []string{"viewer"}},
+		{expr: "admin OR editor AND reviewer", roles: []string{"admin"}, expected: true},
+		{expr: "admin OR editor AND reviewer", roles: []string{"editor"}},
+		{expr: "admin or editor and reviewer", roles: []string{"editor", "reviewer"}, expected: true},
+		{expr: "NOT NOT admin", roles: []string{"admin"}, expected: true},
+		{expr: "not (a and b)", roles: []string{"a"}, expected: true},
+		{expr: `"NOT" AND "team a"`, roles: []string{"NOT", "team a"}, expected: true},
+		{expr: `"team \"b\""`, roles: []string{`team "b"`}, expected: true},
+		{expr: "realm:admin OR client:admin", roles: []string{"client:admin"}, expected: true},
+	} {
+		e, err := ParseRolesExpression(tc.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		has := func(role []string) bool {
+			return stringInSlice(role[0], tc.roles)
+		}
+		if res := e.Eval(has); res != tc.expected {
+			t.Errorf("%s %v: unexpected result: %v", tc.expr, tc.roles, res)
+		}
+		if e.String() != tc.expr {
+			t.Errorf("unexpected source: %s", e.String())
+		}
+	}
+}
+
+func TestParseRolesExpression_errors(t *testing.T) {
+	for _, expr := range []string{"admin AND", "(admin", "admin)", "AND admin", "admin editor", "NOT", `"admin`, "()"} {
+		if _, err := ParseRolesExpression(expr); !errors.Is(err, ErrInvalidRolesExpression) {
+			t.Errorf("%s: unexpected error: %v", expr, err)
+		}
+	}
+	if e, err := ParseRolesExpression("  "); e != nil || err != nil {
+		t.Errorf("unexpected result of the empty expression: %v %v", e, err)
+	}
+	if !(*RolesExpression)(nil).Eval(func([]string) bool { return false }) {
+		t.Error("the nil expression should accept any roles")
+	}
+}
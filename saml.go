@@ -0,0 +1,258 @@
+package jose
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const defaultSAMLHeader = "X-SAML-Assertion"
+
+var (
+	ErrNoSAMLVerifier   = errors.New("no SAML verifier registered")
+	ErrSAMLMalformed    = errors.New("malformed SAML assertion")
+	ErrSAMLCertificates = errors.New("invalid SAML IdP certificates")
+)
+
+// SAMLConfig enables the SAML assertions as a fallback of the tokens. The requests without token sending
+// a base64 encoded assertion signed by a trusted IdP get the claims mapped from the assertion, and they go
+// through the same checks and propagation as the tokens.
+type SAMLConfig struct {
+	// Header is the header carrying the assertion. Defaults to X-SAML-Assertion
+	Header string `json:"header,omitempty"`
+	// Certificates are the PEM encoded certificates of the IdPs. They accept the references of the config
+	// values, as "@/etc/krakend/idp.pem"
+	Certificates []string `json:"idp_certificates"`
+	// Issuer is the required entity ID of the IdP, if set
+	Issuer string `json:"issuer,omitempty"`
+	// Audience is the list of accepted audiences. Any of them must be in the audience restriction.
+	Audience []string `json:"audience,omitempty"`
+	// AttributeMapping maps the names of the attributes to claims. Dots in the claims create nested
+	// claims. If empty, all the attributes are added as claims with their names.
+	AttributeMapping map[string]string `json:"attribute_mapping,omitempty"`
+	// ClockSkew is the leeway of the validity window, in seconds or as "30s"
+	ClockSkew Seconds `json:"clock_skew,omitempty"`
+}
+
+// SAMLVerifier verifies the XML signature of an assertion with the certificates of the IdPs and returns
+// the signed element, so only the signed content is mapped into claims.
+//
+// The XML signature and its canonicalization are not part of this package, so an implementation (usually
+// backed by an XML-DSig library) must be registered with RegisterSAMLVerifier before the handlers are
+// created.
+type SAMLVerifier interface {
+	Verify(assertion []byte, certificates []*x509.Certificate) ([]byte, error)
+}
+
+var (
+	samlVerifier   SAMLVerifier
+	samlVerifierMu sync.RWMutex
+)
+
+// RegisterSAMLVerifier sets the SAMLVerifier used by the SAML authenticators
+func RegisterSAMLVerifier(v SAMLVerifier) {
+	samlVerifierMu.Lock()
+	samlVerifier = v
+	samlVerifierMu.Unlock()
+}
+
+// SAMLAuthenticator maps the SAML assertions of the requests into claims. A nil SAMLAuthenticator does
+// nothing.
+type SAMLAuthenticator struct {
+	verifier     SAMLVerifier
+	header       string
+	certificates []*x509.Certificate
+	issuer       string
+	audience     []string
+	mapping      map[string]ClaimPath
+	clockSkew    time.Duration
+}
+
+// NewSAMLAuthenticator returns the SAMLAuthenticator of the config, using the registered verifier, or nil
+// if there is no config
+func NewSAMLAuthenticator(cfg *SAMLConfig) (*SAMLAuthenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	samlVerifierMu.RLock()
+	verifier := samlVerifier
+	samlVerifierMu.RUnlock()
+	if verifier == nil {
+		return nil, ErrNoSAMLVerifier
+	}
+
+	a := &SAMLAuthenticator{
+		verifier:  verifier,
+		header:    cfg.Header,
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		clockSkew: cfg.ClockSkew.Duration(),
+	}
+	if a.header == "" {
+		a.header = defaultSAMLHeader
+	}
+	for i, c := range cfg.Certificates {
+		block, _ := pem.Decode([]byte(c))
+		if block == nil {
+			return nil, fmt.Errorf("%w: #%d is not PEM encoded", ErrSAMLCertificates, i)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: #%d: %s", ErrSAMLCertificates, i, err.Error())
+		}
+		a.certificates = append(a.certificates, cert)
+	}
+	if len(a.certificates) == 0 {
+		return nil, fmt.Errorf("%w: no certificates", ErrSAMLCertificates)
+	}
+	if len(cfg.AttributeMapping) > 0 {
+		a.mapping = make(map[string]ClaimPath, len(cfg.AttributeMapping))
+		for attr, claim := range cfg.AttributeMapping {
+			a.mapping[attr] = NewClaimPath(claim, true)
+		}
+	}
+	return a, nil
+}
+
+type samlAssertion struct {
+	XMLName xml.Name
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID string `xml:"NameID"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore    string   `xml:"NotBefore,attr"`
+		NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+		Audiences    []string `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+// Authenticate verifies the assertion of the request and returns its claims: iss, sub, aud, nbf and exp
+// from the assertion, and the mapped attributes. The attributes with a single value are mapped as strings.
+// It returns auth0.ErrTokenNotFound if the request has no assertion.
+func (a *SAMLAuthenticator) Authenticate(r *http.Request) (map[string]interface{}, error) {
+	raw := strings.TrimSpace(r.Header.Get(a.header))
+	if raw == "" {
+		return nil, auth0.ErrTokenNotFound
+	}
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrSAMLMalformed
+	}
+	signed, err := a.verifier.Verify(data, a.certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	var assertion samlAssertion
+	if err := xml.Unmarshal(signed, &assertion); err != nil || assertion.XMLName.Local != "Assertion" {
+		return nil, ErrSAMLMalformed
+	}
+	claims, err := a.claims(&assertion, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Del(a.header)
+	return claims, nil
+}
+
+func (a *SAMLAuthenticator) claims(assertion *samlAssertion, now time.Time) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if assertion.Issuer = strings.TrimSpace(assertion.Issuer); assertion.Issuer != "" {
+		claims["iss"] = assertion.Issuer
+	}
+	if a.issuer != "" && assertion.Issuer != a.issuer {
+		return nil, jwt.ErrInvalidIssuer
+	}
+	if sub := strings.TrimSpace(assertion.Subject.NameID); sub != "" {
+		claims["sub"] = sub
+	}
+
+	if v := assertion.Conditions.NotBefore; v != "" {
+		nbf, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, ErrSAMLMalformed
+		}
+		if now.Add(a.clockSkew).Before(nbf) {
+			return nil, jwt.ErrNotValidYet
+		}
+		claims["nbf"] = nbf.Unix()
+	}
+	if v := assertion.Conditions.NotOnOrAfter; v != "" {
+		exp, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, ErrSAMLMalformed
+		}
+		if !now.Add(-a.clockSkew).Before(exp) {
+			return nil, jwt.ErrExpired
+		}
+		claims["exp"] = exp.Unix()
+	}
+
+	audiences := make([]interface{}, len(assertion.Conditions.Audiences))
+	for i, aud := range assertion.Conditions.Audiences {
+		audiences[i] = strings.TrimSpace(aud)
+	}
+	if len(audiences) > 0 {
+		claims["aud"] = audiences
+	}
+	if len(a.audience) > 0 && !samlAudienceAllowed(audiences, a.audience) {
+		return nil, jwt.ErrInvalidAudience
+	}
+
+	for _, attr := range assertion.Attributes {
+		path := NewClaimPath(attr.Name, false)
+		if a.mapping != nil {
+			var ok bool
+			if path, ok = a.mapping[attr.Name]; !ok {
+				continue
+			}
+		}
+		var v interface{}
+		if len(attr.Values) == 1 {
+			v = strings.TrimSpace(attr.Values[0])
+		} else {
+			values := make([]interface{}, len(attr.Values))
+			for i, e := range attr.Values {
+				values[i] = strings.TrimSpace(e)
+			}
+			v = values
+		}
+		claims = withClaim(claims, path, v)
+	}
+	return claims, nil
+}
+
+func samlAudienceAllowed(audiences []interface{}, allowed []string) bool {
+	for _, aud := range audiences {
+		for _, a := range allowed {
+			if aud == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Fallback authenticates the SAML assertion of the requests without token. The result of the token
+// validation is returned as it is if there is a token or the fallback is disabled.
+func (a *SAMLAuthenticator) Fallback(r *http.Request, claims map[string]interface{}, err error) (map[string]interface{}, error) {
+	if a == nil || !errors.Is(err, auth0.ErrTokenNotFound) {
+		return claims, err
+	}
+	return a.Authenticate(r)
+}
@@ -0,0 +1,122 @@
+package jose
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type samlVerifierFunc func([]byte, []*x509.Certificate) ([]byte, error)
+
+func (f samlVerifierFunc) Verify(assertion []byte, certs []*x509.Certificate) ([]byte, error) {
+	return f(assertion, certs)
+}
+
+func TestSAMLAuthenticator(t *testing.T) {
+	RegisterSAMLVerifier(nil)
+	if _, err := NewSAMLAuthenticator(&SAMLConfig{}); err != ErrNoSAMLVerifier {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	errSignature := errors.New("invalid signature")
+	RegisterSAMLVerifier(samlVerifierFunc(func(assertion []byte, certs []*x509.Certificate) ([]byte, error) {
+		if len(certs) != 1 || !strings.Contains(string(assertion), "<ds:Signature>valid</ds:Signature>") {
+			return nil, errSignature
+		}
+		return assertion, nil
+	}))
+	defer RegisterSAMLVerifier(nil)
+
+	cert, err := os.ReadFile("cert.pem")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := NewSAMLAuthenticator(&SAMLConfig{Certificates: []string{"not a cert"}}); !errors.Is(err, ErrSAMLCertificates) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	a, err := NewSAMLAuthenticator(&SAMLConfig{
+		Certificates: []string{string(cert)},
+		Issuer:       "https://idp.example.com",
+		Audience:     []string{"https://api.example.com"},
+		AttributeMapping: map[string]string{
+			"http://schemas.xmlsoap.org/claims/Group": "roles",
+			"email": "profile.email",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Now().UTC()
+	assertion := func(signature, issuer, audience string, notOnOrAfter time.Time) string {
+		return base64.StdEncoding.EncodeToString([]byte(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+	<saml:Issuer>` + issuer + `</saml:Issuer>
+	<ds:Signature>` + signature + `</ds:Signature>
+	<saml:Subject><saml:NameID>alice@example.com</saml:NameID></saml:Subject>
+	<saml:Conditions NotBefore="` + now.Add(-time.Minute).Format(time.RFC3339) + `" NotOnOrAfter="` + notOnOrAfter.Format(time.RFC3339) + `">
+		<saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction>
+	</saml:Conditions>
+	<saml:AttributeStatement>
+		<saml:Attribute Name="http://schemas.xmlsoap.org/claims/Group">
+			<saml:AttributeValue>admin</saml:AttributeValue>
+			<saml:AttributeValue>user</saml:AttributeValue>
+		</saml:Attribute>
+		<saml:Attribute Name="email"><saml:AttributeValue>alice@example.com</saml:AttributeValue></saml:Attribute>
+		<saml:Attribute Name="department"><saml:AttributeValue>hr</saml:AttributeValue></saml:Attribute>
+	</saml:AttributeStatement>
+</saml:Assertion>`))
+	}
+	exp := now.Add(time.Hour)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-SAML-Assertion", assertion("valid", "https://idp.example.com", "https://api.example.com", exp))
+	claims, err := a.Fallback(req, nil, auth0.ErrTokenNotFound)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[string]interface{}{
+		"iss":     "https://idp.example.com",
+		"sub":     "alice@example.com",
+		"aud":     []interface{}{"https://api.example.com"},
+		"nbf":     now.Add(-time.Minute).Unix(),
+		"exp":     exp.Unix(),
+		"roles":   []interface{}{"admin", "user"},
+		"profile": map[string]interface{}{"email": "alice@example.com"},
+	}
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if req.Header.Get("X-SAML-Assertion") != "" {
+		t.Error("the assertion should be removed from the request")
+	}
+
+	for i, tc := range []struct {
+		header string
+		err    error
+	}{
+		{header: "", err: auth0.ErrTokenNotFound},
+		{header: "not base64!", err: ErrSAMLMalformed},
+		{header: assertion("forged", "https://idp.example.com", "https://api.example.com", exp), err: errSignature},
+		{header: assertion("valid", "https://evil.example.com", "https://api.example.com", exp), err: jwt.ErrInvalidIssuer},
+		{header: assertion("valid", "https://idp.example.com", "https://other.example.com", exp), err: jwt.ErrInvalidAudience},
+		{header: assertion("valid", "https://idp.example.com", "https://api.example.com", now.Add(-time.Second)), err: jwt.ErrExpired},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-SAML-Assertion", tc.header)
+		if _, err := a.Authenticate(req); err != tc.err {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
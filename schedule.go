@@ -0,0 +1,328 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	ErrInvalidCron     = errors.New("invalid cron expression")
+	ErrInvalidWindow   = errors.New("invalid time window")
+)
+
+// ScheduleConfig restricts the access to the endpoint to some time windows. The static windows of the
+// endpoint are cron expressions, and the windows of each token are read from a claim, so the contractor
+// accounts can be limited to their working hours.
+type ScheduleConfig struct {
+	// Cron are the allowed minutes, as cron expressions with the minute, hour, day of month, month and day
+	// of week fields, as "* 8-17 * * MON-FRI". The access is allowed if any of them matches.
+	Cron []string `json:"cron,omitempty"`
+	// Timezone of the cron expressions and the windows, as Europe/Madrid. Defaults to UTC
+	Timezone string `json:"timezone,omitempty"`
+	// Claim is the claim with the windows of the token, as access_hours. Its value is a window or an array
+	// of them, with the format "[days ]HH:MM-HH:MM", as "Mon-Fri 09:00-17:30" or "22:00-06:00", or a cron
+	// expression
+	Claim string `json:"claim,omitempty"`
+	// ClaimRequired rejects the tokens without the claim. Otherwise, they are only checked against the
+	// cron expressions.
+	ClaimRequired bool `json:"claim_required,omitempty"`
+	// TimezoneClaim is the claim with the timezone of the windows of the token, as the zoneinfo claim of
+	// OpenID Connect. Defaults to the Timezone
+	TimezoneClaim string `json:"timezone_claim,omitempty"`
+}
+
+// Schedule checks the time windows of a ScheduleConfig. A nil Schedule allows any time.
+type Schedule struct {
+	cron          []*cronExpr
+	location      *time.Location
+	claim         ClaimPath
+	hasClaim      bool
+	claimRequired bool
+	timezoneClaim ClaimPath
+	hasTimezone   bool
+	now           func() time.Time
+}
+
+// NewSchedule returns the Schedule of the config, or nil if there is no config
+func NewSchedule(cfg *ScheduleConfig) (*Schedule, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.Cron) == 0 && cfg.Claim == "" {
+		return nil, fmt.Errorf("%w: either the cron expressions or the claim must be defined", ErrInvalidSchedule)
+	}
+	s := &Schedule{
+		location:      time.UTC,
+		claim:         NewClaimPath(cfg.Claim, true),
+		hasClaim:      cfg.Claim != "",
+		claimRequired: cfg.ClaimRequired,
+		timezoneClaim: NewClaimPath(cfg.TimezoneClaim, true),
+		hasTimezone:   cfg.TimezoneClaim != "",
+		now:           time.Now,
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSchedule, err.Error())
+		}
+		s.location = loc
+	}
+	for _, expr := range cfg.Cron {
+		c, err := parseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		s.cron = append(s.cron, c)
+	}
+	return s, nil
+}
+
+// Allowed returns true if the current time is inside the windows of the endpoint and the ones of the claims
+func (s *Schedule) Allowed(claims map[string]interface{}) bool {
+	if s == nil {
+		return true
+	}
+	now := s.now()
+	if len(s.cron) > 0 {
+		t := now.In(s.location)
+		matched := false
+		for _, c := range s.cron {
+			if c.matches(t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if !s.hasClaim {
+		return true
+	}
+
+	v, ok := s.claim.Lookup(claims)
+	if !ok {
+		return !s.claimRequired
+	}
+	loc := s.location
+	if s.hasTimezone {
+		if tz, ok := s.timezoneClaim.Get(claims); ok && tz != "" {
+			l, err := time.LoadLocation(tz)
+			if err != nil {
+				return false
+			}
+			loc = l
+		}
+	}
+	t := now.In(loc)
+	windows := claimValues(v)
+	if str, ok := v.(string); ok {
+		// a single window has spaces, so it is not split as the space separated claims
+		windows = []string{str}
+	}
+	for _, w := range windows {
+		if windowMatches(w, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// windowMatches returns true if the time is inside the window. Malformed windows never match.
+func windowMatches(window string, t time.Time) bool {
+	window = strings.TrimSpace(window)
+	if len(strings.Fields(window)) == 5 {
+		c, err := parseCron(window)
+		return err == nil && c.matches(t)
+	}
+	w, err := parseWindow(window)
+	return err == nil && w.matches(t)
+}
+
+type timeWindow struct {
+	days       uint64
+	start, end int
+}
+
+// parseWindow parses the windows with the format "[days ]HH:MM-HH:MM". The end of the window is exclusive,
+// and a window ending before its start spans midnight.
+func parseWindow(window string) (timeWindow, error) {
+	w := timeWindow{days: 1<<7 - 1}
+	hours := window
+	if i := strings.LastIndexByte(window, ' '); i >= 0 {
+		days, err := parseCronField(strings.TrimSpace(window[:i]), 0, 7, weekdayNames)
+		if err != nil {
+			return w, fmt.Errorf("%w: %q", ErrInvalidWindow, window)
+		}
+		w.days = normalizeWeekdays(days)
+		hours = window[i+1:]
+	}
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("%w: %q", ErrInvalidWindow, window)
+	}
+	var err error
+	if w.start, err = parseClock(parts[0]); err != nil {
+		return w, fmt.Errorf("%w: %q", ErrInvalidWindow, window)
+	}
+	if w.end, err = parseClock(parts[1]); err != nil {
+		return w, fmt.Errorf("%w: %q", ErrInvalidWindow, window)
+	}
+	return w, nil
+}
+
+func (w timeWindow) matches(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.start <= w.end {
+		return w.days&(1<<uint(day)) != 0 && minute >= w.start && minute < w.end
+	}
+	// the windows spanning midnight belong to the day they start
+	if minute >= w.start {
+		return w.days&(1<<uint(day)) != 0
+	}
+	return minute < w.end && w.days&(1<<uint((day+6)%7)) != 0
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time. 24:00 is accepted as the end of the day.
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, ErrInvalidWindow
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, ErrInvalidWindow
+	}
+	return h*60 + m, nil
+}
+
+var (
+	weekdayNames = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+	monthNames   = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+)
+
+// cronExpr is a parsed cron expression. Each field is the bitset of its accepted values.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set when the field is *. As in cron, if both days are restricted, the
+	// expression matches any of them.
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q has %d fields instead of 5", ErrInvalidCron, expr, len(fields))
+	}
+	c := &cronExpr{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		dst      *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&c.minute, 0, 59, nil},
+		{&c.hour, 0, 23, nil},
+		{&c.dom, 1, 31, nil},
+		{&c.month, 1, 12, monthNames},
+		{&c.dow, 0, 7, weekdayNames},
+	} {
+		if *f.dst, err = parseCronField(fields[0], f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrInvalidCron, expr, err.Error())
+		}
+		fields = fields[1:]
+	}
+	c.dow = normalizeWeekdays(c.dow)
+	return c, nil
+}
+
+// normalizeWeekdays maps the day 7 to the sunday
+func normalizeWeekdays(days uint64) uint64 {
+	if days&(1<<7) != 0 {
+		days = days&^(1<<7) | 1
+	}
+	return days
+}
+
+// parseCronField returns the bitset of the values of a field: *, values, ranges and steps, separated by
+// commas, as "1-5", "*/15" or "MON,WED,FRI"
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var res uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = cronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = cronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			res |= 1 << uint(v)
+		}
+	}
+	return res, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+func (c *cronExpr) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,158 @@
+package jose
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Allowed(t *testing.T) {
+	// a monday
+	monday := time.Date(2024, time.March, 4, 10, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		cfg      ScheduleConfig
+		now      time.Time
+		claims   map[string]interface{}
+		expected bool
+	}{
+		{
+			name:     "cron inside",
+			cfg:      ScheduleConfig{Cron: []string{"* 8-17 * * MON-FRI"}},
+			now:      monday,
+			expected: true,
+		},
+		{
+			name: "cron outside",
+			cfg:  ScheduleConfig{Cron: []string{"* 8-17 * * MON-FRI"}},
+			now:  monday.Add(9 * time.Hour),
+		},
+		{
+			name: "cron weekend",
+			cfg:  ScheduleConfig{Cron: []string{"* 8-17 * * 1-5"}},
+			now:  monday.Add(-48 * time.Hour),
+		},
+		{
+			name:     "any of the cron expressions",
+			cfg:      ScheduleConfig{Cron: []string{"* 8-17 * * MON-FRI", "0-29 19 * * *"}},
+			now:      monday.Add(9*time.Hour - 10*time.Minute),
+			expected: true,
+		},
+		{
+			name:     "cron timezone",
+			cfg:      ScheduleConfig{Cron: []string{"* 8-17 * * *"}, Timezone: "America/New_York"},
+			now:      monday.Add(4 * time.Hour),
+			expected: true,
+		},
+		{
+			name:     "claim window",
+			cfg:      ScheduleConfig{Claim: "access_hours"},
+			now:      monday,
+			claims:   map[string]interface{}{"access_hours": "Mon-Fri 09:00-17:30"},
+			expected: true,
+		},
+		{
+			name:   "claim window outside",
+			cfg:    ScheduleConfig{Claim: "access_hours"},
+			now:    monday,
+			claims: map[string]interface{}{"access_hours": "Sat,Sun 09:00-17:30"},
+		},
+		{
+			name:     "claim windows",
+			cfg:      ScheduleConfig{Claim: "contract.access_hours"},
+			now:      monday,
+			claims:   map[string]interface{}{"contract": map[string]interface{}{"access_hours": []interface{}{"07:00-09:00", "10:00-11:00"}}},
+			expected: true,
+		},
+		{
+			name:     "claim window spanning midnight",
+			cfg:      ScheduleConfig{Claim: "access_hours"},
+			now:      monday.Add(-11 * time.Hour),
+			claims:   map[string]interface{}{"access_hours": "Sun 22:00-06:00"},
+			expected: true,
+		},
+		{
+			name:     "claim cron",
+			cfg:      ScheduleConfig{Claim: "access_hours"},
+			now:      monday,
+			claims:   map[string]interface{}{"access_hours": "*/15 10 * * 1"},
+			expected: true,
+		},
+		{
+			name:     "claim timezone",
+			cfg:      ScheduleConfig{Claim: "access_hours", TimezoneClaim: "zoneinfo"},
+			now:      monday,
+			claims:   map[string]interface{}{"access_hours": "11:00-12:00", "zoneinfo": "Europe/Madrid"},
+			expected: true,
+		},
+		{
+			name:   "malformed claim",
+			cfg:    ScheduleConfig{Claim: "access_hours"},
+			now:    monday,
+			claims: map[string]interface{}{"access_hours": "9 to 5"},
+		},
+		{
+			name:     "missing claim",
+			cfg:      ScheduleConfig{Claim: "access_hours"},
+			now:      monday,
+			claims:   map[string]interface{}{},
+			expected: true,
+		},
+		{
+			name:   "missing required claim",
+			cfg:    ScheduleConfig{Claim: "access_hours", ClaimRequired: true},
+			now:    monday,
+			claims: map[string]interface{}{},
+		},
+		{
+			name:   "cron and claim",
+			cfg:    ScheduleConfig{Cron: []string{"* 8-17 * * *"}, Claim: "access_hours"},
+			now:    monday,
+			claims: map[string]interface{}{"access_hours": "12:00-13:00"},
+		},
+	} {
+		s, err := NewSchedule(&tc.cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		now := tc.now
+		s.now = func() time.Time { return now }
+		if res := s.Allowed(tc.claims); res != tc.expected {
+			t.Errorf("%s: unexpected result: %v", tc.name, res)
+		}
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	if s, err := NewSchedule(nil); s != nil || err != nil || !s.Allowed(nil) {
+		t.Errorf("unexpected result: %v %v", s, err)
+	}
+	for _, tc := range []struct {
+		cfg ScheduleConfig
+		err error
+	}{
+		{ScheduleConfig{}, ErrInvalidSchedule},
+		{ScheduleConfig{Cron: []string{"* * * *"}}, ErrInvalidCron},
+		{ScheduleConfig{Cron: []string{"* 25 * * *"}}, ErrInvalidCron},
+		{ScheduleConfig{Cron: []string{"* * * * FUN"}}, ErrInvalidCron},
+		{ScheduleConfig{Cron: []string{"*/0 * * * *"}}, ErrInvalidCron},
+		{ScheduleConfig{Claim: "access_hours", Timezone: "Mars/Olympus"}, ErrInvalidSchedule},
+	} {
+		if _, err := NewSchedule(&tc.cfg); !errors.Is(err, tc.err) {
+			t.Errorf("%v: unexpected error: %v", tc.cfg, err)
+		}
+	}
+}
+
+func TestPolicy_Authorize_schedule(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{RolesKey: "roles", Schedule: &ScheduleConfig{Cron: []string{"* * 31 2 *"}}})
+	if authErr := p.Authorize(map[string]interface{}{}); authErr == nil || authErr.Reason != ReasonOutsideSchedule {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	p = NewPolicy(&SignatureConfig{RolesKey: "roles", Schedule: &ScheduleConfig{Cron: []string{"not a cron"}}})
+	if authErr := p.Authorize(map[string]interface{}{}); authErr == nil || authErr.Reason != ReasonOutsideSchedule {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+}
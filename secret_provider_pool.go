@@ -0,0 +1,76 @@
+package jose
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	auth0 "github.com/auth0-community/go-auth0"
+)
+
+var secretProviders = &secretProviderPool{providers: map[string]*JWKClient{}}
+
+// secretProviderPool shares the JWKClients, their key caches and their HTTP clients between the validators
+// with the same secret provider config
+type secretProviderPool struct {
+	mu        sync.Mutex
+	providers map[string]*JWKClient
+}
+
+// SharedSecretProvider returns the JWKClient for the config and the token extractor, creating it on the
+// first call. The extractorID identifies the token extractor, so validators extracting the tokens in
+// different ways do not share their clients.
+func SharedSecretProvider(cfg SecretProviderConfig, extractorID string, te auth0.RequestTokenExtractor) (*JWKClient, error) {
+	return secretProviders.get(cfg, extractorID, te)
+}
+
+func (p *secretProviderPool) get(cfg SecretProviderConfig, extractorID string, te auth0.RequestTokenExtractor) (*JWKClient, error) {
+	key, err := secretProviderConfigHash(cfg, extractorID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sp, ok := p.providers[key]; ok {
+		return sp, nil
+	}
+	sp, err := SecretProvider(cfg, te)
+	if err != nil {
+		return nil, err
+	}
+	p.providers[key] = sp
+	return sp, nil
+}
+
+func (p *secretProviderPool) health() []ProviderHealth {
+	p.mu.Lock()
+	res := make([]ProviderHealth, 0, len(p.providers))
+	for _, sp := range p.providers {
+		res = append(res, sp.Health())
+	}
+	p.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].URI < res[j].URI })
+	return res
+}
+
+func (p *secretProviderPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.providers)
+}
+
+func secretProviderConfigHash(cfg SecretProviderConfig, extractorID string) (string, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(b)
+	h.Write([]byte(extractorID))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,65 @@
+package jose
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedSecretProvider(t *testing.T) {
+	cfg := SecretProviderConfig{URI: "", AllowInsecure: true, LocalPath: "./fixtures/symmetric.json"}
+
+	sp1, err := SharedSecretProvider(cfg, "a", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sp2, _ := SharedSecretProvider(cfg, "a", nil)
+	if sp1 != sp2 {
+		t.Error("the secret providers should be shared")
+	}
+
+	if sp3, _ := SharedSecretProvider(cfg, "b", nil); sp3 == sp1 {
+		t.Error("the secret providers of different extractors should not be shared")
+	}
+
+	cfg.LocalPath = "./fixtures/public.json"
+	if sp4, _ := SharedSecretProvider(cfg, "a", nil); sp4 == sp1 {
+		t.Error("the secret providers of different configs should not be shared")
+	}
+
+	cfg.LocalPath = "./fixtures/unknown.json"
+	if _, err := SharedSecretProvider(cfg, "a", nil); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestNewValidator_sharedSecretProvider(t *testing.T) {
+	var hits uint32
+	server := httptest.NewServer(jwkEndpointWithCounter("public", &hits))
+	defer server.Close()
+
+	before := secretProviders.len()
+	for _, endpoint := range []string{"/a", "/b", "/c"} {
+		if _, err := NewValidator(&SignatureConfig{
+			Alg:                "RS256",
+			URI:                server.URL,
+			CacheEnabled:       true,
+			DisableJWKSecurity: true,
+			Audience:           []string{"http://api.example.com" + endpoint},
+		}, nopExtractor); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if n := secretProviders.len() - before; n != 1 {
+		t.Errorf("unexpected number of secret providers: %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if h := atomic.LoadUint32(&hits); h != 1 {
+		t.Errorf("unexpected number of JWKS fetches: %d", h)
+	}
+}
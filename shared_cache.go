@@ -0,0 +1,493 @@
+package jose
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SharedCacheRedis     = "redis"
+	SharedCacheMemcached = "memcached"
+
+	defaultSharedCacheTTL     = 5 * time.Minute
+	defaultSharedCacheTimeout = time.Second
+	defaultSharedCachePrefix  = "krakend-jose:jwks:"
+	sharedCachePollInterval   = 100 * time.Millisecond
+)
+
+var (
+	ErrInvalidSharedCache = errors.New("invalid shared cache config")
+	ErrSharedCache        = errors.New("shared cache error")
+)
+
+// SharedCacheConfig stores the key sets downloaded from the JWK services in Redis or memcached, so all the
+// gateway instances behind the same identity provider share a single download instead of each one keeping
+// its own cache. When the cache fails, the key sets are downloaded as usual.
+type SharedCacheConfig struct {
+	// Backend is the cache: redis, memcached or one registered with RegisterSharedCache
+	Backend string `json:"backend"`
+	// Address is the host:port of the cache
+	Address string `json:"address,omitempty"`
+	// Username and Password authenticate the Redis connections. They accept the references of the config
+	// values, as "@/etc/krakend/redis_password"
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// DB is the Redis database
+	DB int `json:"db,omitempty"`
+	// TLS connects to the cache with TLS
+	TLS bool `json:"tls,omitempty"`
+	// TTL is the time the key sets are cached, in seconds or as "5m". Defaults to 5m
+	TTL Seconds `json:"ttl,omitempty"`
+	// Timeout limits the operations of the cache, in seconds or as "500ms". Defaults to 1s
+	Timeout Seconds `json:"timeout,omitempty"`
+	// Prefix is added to the cache keys. Defaults to "krakend-jose:jwks:"
+	Prefix string `json:"prefix,omitempty"`
+	// Options are passed to the caches registered with RegisterSharedCache
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// SharedCache stores the key sets shared by the gateway instances
+type SharedCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// SharedCacheLocker is implemented by the shared caches able to grant a lock to a single instance, so only
+// the instance holding the lock downloads the key set while the rest wait for it to be cached
+type SharedCacheLocker interface {
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// SharedCacheFactory creates a SharedCache from its config
+type SharedCacheFactory func(*SharedCacheConfig) (SharedCache, error)
+
+var (
+	sharedCacheFactories = map[string]SharedCacheFactory{
+		SharedCacheRedis:     newRedisCache,
+		SharedCacheMemcached: newMemcachedCache,
+	}
+	sharedCacheFactoriesMu sync.RWMutex
+
+	sharedCaches   = map[string]SharedCache{}
+	sharedCachesMu sync.Mutex
+)
+
+// RegisterSharedCache adds a cache to the ones available in the shared cache config
+func RegisterSharedCache(name string, f SharedCacheFactory) {
+	sharedCacheFactoriesMu.Lock()
+	sharedCacheFactories[name] = f
+	sharedCacheFactoriesMu.Unlock()
+}
+
+// NewSharedCache returns the SharedCache of the config, or nil if there is no config
+func NewSharedCache(cfg *SharedCacheConfig) (SharedCache, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	sharedCacheFactoriesMu.RLock()
+	f, ok := sharedCacheFactories[cfg.Backend]
+	sharedCacheFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown backend %q", ErrInvalidSharedCache, cfg.Backend)
+	}
+	return f(cfg)
+}
+
+// sharedCache returns the SharedCache of the config, shared by all the key set clients with the same config
+func sharedCache(cfg *SharedCacheConfig) (SharedCache, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	key := hex.EncodeToString(h[:])
+
+	sharedCachesMu.Lock()
+	defer sharedCachesMu.Unlock()
+	if c, ok := sharedCaches[key]; ok {
+		return c, nil
+	}
+	c, err := NewSharedCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sharedCaches[key] = c
+	return c, nil
+}
+
+// sharedCacheTransport serves the key sets from the shared cache, and stores there the ones downloaded
+type sharedCacheTransport struct {
+	next    http.RoundTripper
+	cache   SharedCache
+	prefix  string
+	ttl     time.Duration
+	lockTTL time.Duration
+}
+
+func newSharedCacheTransport(next http.RoundTripper, cfg *SharedCacheConfig, lockTTL time.Duration) (http.RoundTripper, error) {
+	cache, err := sharedCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := sharedCacheTransport{
+		next:    next,
+		cache:   cache,
+		prefix:  cfg.Prefix,
+		ttl:     cfg.TTL.Duration(),
+		lockTTL: lockTTL,
+	}
+	if t.prefix == "" {
+		t.prefix = defaultSharedCachePrefix
+	}
+	if t.ttl == 0 {
+		t.ttl = defaultSharedCacheTTL
+	}
+	if t.lockTTL == 0 {
+		t.lockTTL = defaultOutboundTokenTimeout
+	}
+	return t, nil
+}
+
+func (t sharedCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	h := sha256.Sum256([]byte(req.URL.String()))
+	key := t.prefix + hex.EncodeToString(h[:])
+
+	if data, ok, err := t.cache.Get(ctx, key); err == nil && ok {
+		return cachedKeySetResponse(req, data), nil
+	}
+	if locker, ok := t.cache.(SharedCacheLocker); ok {
+		if acquired, err := locker.Lock(ctx, key+":lock", t.lockTTL); err == nil && !acquired {
+			if data, ok := t.wait(ctx, key); ok {
+				return cachedKeySetResponse(req, data), nil
+			}
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.cache.Set(ctx, key, body, t.ttl)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// wait polls the cache until the instance holding the lock stores the key set, or the lock expires
+func (t sharedCacheTransport) wait(ctx context.Context, key string) ([]byte, bool) {
+	ticker := time.NewTicker(sharedCachePollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(t.lockTTL)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-deadline.C:
+			return nil, false
+		case <-ticker.C:
+		}
+		if data, ok, err := t.cache.Get(ctx, key); err == nil && ok {
+			return data, true
+		}
+	}
+}
+
+func cachedKeySetResponse(req *http.Request, data []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
+
+// cacheReplyError is an error returned by the cache server. The connection is still usable after it.
+type cacheReplyError string
+
+func (e cacheReplyError) Error() string { return string(e) }
+
+// cacheConn is a single connection to a cache server, opened on demand and reopened after the network
+// errors. The operations on the key sets are rare, so there is no pool.
+type cacheConn struct {
+	cfg     *SharedCacheConfig
+	timeout time.Duration
+	init    func(w io.Writer, r *bufio.Reader) error
+	mu      sync.Mutex
+	conn    net.Conn
+	rd      *bufio.Reader
+}
+
+func newCacheConn(cfg *SharedCacheConfig) (*cacheConn, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("%w: the address must be defined", ErrInvalidSharedCache)
+	}
+	c := &cacheConn{cfg: cfg, timeout: cfg.Timeout.Duration()}
+	if c.timeout == 0 {
+		c.timeout = defaultSharedCacheTimeout
+	}
+	return c, nil
+}
+
+func (c *cacheConn) do(ctx context.Context, f func(w io.Writer, r *bufio.Reader) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if c.conn == nil {
+		dialer := &net.Dialer{Deadline: deadline}
+		var conn net.Conn
+		var err error
+		if c.cfg.TLS {
+			conn, err = tls.DialWithDialer(dialer, "tcp", c.cfg.Address, &tls.Config{MinVersion: tls.VersionTLS12})
+		} else {
+			conn, err = dialer.DialContext(ctx, "tcp", c.cfg.Address)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrSharedCache, err.Error())
+		}
+		c.conn, c.rd = conn, bufio.NewReader(conn)
+		if c.init != nil {
+			c.conn.SetDeadline(deadline)
+			if err := c.init(c.conn, c.rd); err != nil {
+				c.close()
+				return fmt.Errorf("%w: %s", ErrSharedCache, err.Error())
+			}
+		}
+	}
+
+	c.conn.SetDeadline(deadline)
+	err := f(c.conn, c.rd)
+	var replyErr cacheReplyError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.close()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSharedCache, err.Error())
+	}
+	return nil
+}
+
+func (c *cacheConn) close() {
+	c.conn.Close()
+	c.conn, c.rd = nil, nil
+}
+
+// redisCache speaks the RESP protocol of Redis
+type redisCache struct {
+	conn *cacheConn
+}
+
+func newRedisCache(cfg *SharedCacheConfig) (SharedCache, error) {
+	conn, err := newCacheConn(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn.init = func(w io.Writer, r *bufio.Reader) error {
+		if cfg.Password != "" {
+			args := []string{"AUTH", cfg.Password}
+			if cfg.Username != "" {
+				args = []string{"AUTH", cfg.Username, cfg.Password}
+			}
+			if _, err := redisCommand(w, r, args...); err != nil {
+				return err
+			}
+		}
+		if cfg.DB != 0 {
+			if _, err := redisCommand(w, r, "SELECT", strconv.Itoa(cfg.DB)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return &redisCache{conn: conn}, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var res interface{}
+	err := c.conn.do(ctx, func(w io.Writer, r *bufio.Reader) (err error) {
+		res, err = redisCommand(w, r, "GET", key)
+		return
+	})
+	if err != nil || res == nil {
+		return nil, false, err
+	}
+	b, ok := res.([]byte)
+	return b, ok, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.conn.do(ctx, func(w io.Writer, r *bufio.Reader) error {
+		_, err := redisCommand(w, r, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+		return err
+	})
+}
+
+func (c *redisCache) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var res interface{}
+	err := c.conn.do(ctx, func(w io.Writer, r *bufio.Reader) (err error) {
+		res, err = redisCommand(w, r, "SET", key, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+		return
+	})
+	return res == "OK", err
+}
+
+// redisCommand sends the command and returns its reply: a string for the simple strings, an int64 for the
+// integers, a []byte for the bulk strings and nil for the null ones
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (interface{}, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	line, err := readCacheLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, cacheReplyError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
+
+// memcachedCache speaks the text protocol of memcached
+type memcachedCache struct {
+	conn *cacheConn
+}
+
+func newMemcachedCache(cfg *SharedCacheConfig) (SharedCache, error) {
+	conn, err := newCacheConn(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedCache{conn: conn}, nil
+}
+
+func (c *memcachedCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var res []byte
+	var found bool
+	err := c.conn.do(ctx, func(w io.Writer, r *bufio.Reader) error {
+		if _, err := fmt.Fprintf(w, "get %s\r\n", key); err != nil {
+			return err
+		}
+		for {
+			line, err := readCacheLine(r)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "VALUE" {
+				return fmt.Errorf("unexpected memcached reply %q", line)
+			}
+			n, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return err
+			}
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			res, found = data[:n], true
+		}
+	})
+	return res, found, err
+}
+
+func (c *memcachedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.store(ctx, "set", key, value, ttl)
+	return err
+}
+
+func (c *memcachedCache) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.store(ctx, "add", key, []byte("1"), ttl)
+}
+
+// store sends a storage command, returning false if the value has not been stored, as the add of an
+// existing key
+func (c *memcachedCache) store(ctx context.Context, cmd, key string, value []byte, ttl time.Duration) (bool, error) {
+	exptime := int64(ttl / time.Second)
+	if exptime < 1 {
+		exptime = 1
+	}
+	var stored bool
+	err := c.conn.do(ctx, func(w io.Writer, r *bufio.Reader) error {
+		if _, err := fmt.Fprintf(w, "%s %s 0 %d %d\r\n%s\r\n", cmd, key, exptime, len(value), value); err != nil {
+			return err
+		}
+		line, err := readCacheLine(r)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			stored = true
+		case "NOT_STORED", "EXISTS":
+		default:
+			return cacheReplyError(line)
+		}
+		return nil
+	})
+	return stored, err
+}
+
+func readCacheLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,246 @@
+package jose
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type memorySharedCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (c *memorySharedCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.data[key]
+	return v, ok, nil
+}
+
+func (c *memorySharedCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	c.data[key] = value
+	c.mu.Unlock()
+	return nil
+}
+
+func TestSecretProvider_sharedCache(t *testing.T) {
+	cache := &memorySharedCache{data: map[string][]byte{}}
+	RegisterSharedCache("test_memory", func(*SharedCacheConfig) (SharedCache, error) { return cache, nil })
+
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	// every provider simulates a gateway instance
+	for i := 0; i < 3; i++ {
+		sp, err := SecretProvider(SecretProviderConfig{
+			URI:           server.URL,
+			AllowInsecure: true,
+			SharedCache:   &SharedCacheConfig{Backend: "test_memory"},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.GetKey("2011-04-29"); err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt64(&hits); n != 1 {
+		t.Errorf("the key set has been downloaded %d times", n)
+	}
+}
+
+// fakeCacheServer serves the connections with the handler until the end of the test
+func fakeCacheServer(t *testing.T, handle func(r *bufio.Reader, w io.Writer) error) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for handle(r, conn) == nil {
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRedisCache(t *testing.T) {
+	var mu sync.Mutex
+	data := map[string]string{}
+	addr := fakeCacheServer(t, func(r *bufio.Reader, w io.Writer) error {
+		line, err := readCacheLine(r)
+		if err != nil {
+			return err
+		}
+		n, _ := strconv.Atoi(line[1:])
+		args := make([]string, n)
+		for i := range args {
+			if _, err := readCacheLine(r); err != nil {
+				return err
+			}
+			if args[i], err = readCacheLine(r); err != nil {
+				return err
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch args[0] {
+		case "AUTH":
+			if args[1] != "secret" {
+				_, err = io.WriteString(w, "-WRONGPASS invalid password\r\n")
+				return err
+			}
+		case "GET":
+			v, ok := data[args[1]]
+			if !ok {
+				_, err = io.WriteString(w, "$-1\r\n")
+				return err
+			}
+			_, err = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+			return err
+		case "SET":
+			if _, ok := data[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+				_, err = io.WriteString(w, "$-1\r\n")
+				return err
+			}
+			data[args[1]] = args[2]
+		}
+		_, err = io.WriteString(w, "+OK\r\n")
+		return err
+	})
+
+	ctx := context.Background()
+	c, err := NewSharedCache(&SharedCacheConfig{Backend: SharedCacheRedis, Address: addr, Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := c.Get(ctx, "jwks"); ok || err != nil {
+		t.Errorf("unexpected hit: %v", err)
+	}
+	if err := c.Set(ctx, "jwks", []byte(`{"keys":[]}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Get(ctx, "jwks"); !ok || err != nil || string(v) != `{"keys":[]}` {
+		t.Errorf("unexpected value: %s %v", v, err)
+	}
+
+	locker := c.(SharedCacheLocker)
+	if ok, err := locker.Lock(ctx, "jwks:lock", time.Second); !ok || err != nil {
+		t.Errorf("the lock should be granted: %v", err)
+	}
+	if ok, err := locker.Lock(ctx, "jwks:lock", time.Second); ok || err != nil {
+		t.Errorf("the lock should be denied: %v", err)
+	}
+
+	c, err = NewSharedCache(&SharedCacheConfig{Backend: SharedCacheRedis, Address: addr, Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(ctx, "jwks"); err == nil {
+		t.Error("the authentication should fail")
+	}
+}
+
+func TestMemcachedCache(t *testing.T) {
+	var mu sync.Mutex
+	data := map[string]string{}
+	addr := fakeCacheServer(t, func(r *bufio.Reader, w io.Writer) error {
+		line, err := readCacheLine(r)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch fields[0] {
+		case "get":
+			if v, ok := data[fields[1]]; ok {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(v), v)
+			}
+			_, err = io.WriteString(w, "END\r\n")
+			return err
+		case "set", "add":
+			value, err := readCacheLine(r)
+			if err != nil {
+				return err
+			}
+			if _, ok := data[fields[1]]; ok && fields[0] == "add" {
+				_, err = io.WriteString(w, "NOT_STORED\r\n")
+				return err
+			}
+			data[fields[1]] = value
+			_, err = io.WriteString(w, "STORED\r\n")
+			return err
+		}
+		_, err = io.WriteString(w, "ERROR\r\n")
+		return err
+	})
+
+	ctx := context.Background()
+	c, err := NewSharedCache(&SharedCacheConfig{Backend: SharedCacheMemcached, Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := c.Get(ctx, "jwks"); ok || err != nil {
+		t.Errorf("unexpected hit: %v", err)
+	}
+	if err := c.Set(ctx, "jwks", []byte(`{"keys":[]}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Get(ctx, "jwks"); !ok || err != nil || string(v) != `{"keys":[]}` {
+		t.Errorf("unexpected value: %s %v", v, err)
+	}
+
+	locker := c.(SharedCacheLocker)
+	if ok, err := locker.Lock(ctx, "jwks:lock", time.Second); !ok || err != nil {
+		t.Errorf("the lock should be granted: %v", err)
+	}
+	if ok, err := locker.Lock(ctx, "jwks:lock", time.Second); ok || err != nil {
+		t.Errorf("the lock should be denied: %v", err)
+	}
+}
+
+func TestSharedCacheConfig_validation(t *testing.T) {
+	for _, cfg := range []*SharedCacheConfig{
+		{Backend: "unknown", Address: "localhost:6379"},
+		{Backend: SharedCacheRedis},
+	} {
+		errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks", SharedCache: cfg})
+		if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidSharedCache {
+			t.Errorf("unexpected errors for %+v: %v", cfg, errs)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package jose
+
+import (
+	"net/http"
+	"time"
+)
+
+const defaultSignedClaimsHeader = "X-Signed-Claims"
+
+// SignedClaimsConfig enables the propagation of the claims of the request as a single compact JWS,
+// signed by the gateway, so the backends get a tamper-proof identity context parsing a single header
+type SignedClaimsConfig struct {
+	// Header is the name of the header. Defaults to X-Signed-Claims
+	Header string `json:"header,omitempty"`
+	// Claims is the list of claims to include. Dots access nested claims. Empty lists include all the claims
+	// (once redacted).
+	Claims []string `json:"claims,omitempty"`
+	// ExpiresIn is the lifetime of the signed claims, in seconds or as "30s". When set, the iat and exp
+	// claims are replaced. Otherwise, the claims of the token are kept.
+	ExpiresIn Seconds `json:"expires_in,omitempty"`
+	// Signer defines the key and the algorithm, as the signer extra config. The full serialization is not
+	// supported, as it is not header-safe.
+	Signer SignerConfig `json:"signer"`
+}
+
+// ClaimsHeaderSigner sets the signed claims header of the requests. A nil ClaimsHeaderSigner does
+// nothing.
+type ClaimsHeaderSigner struct {
+	header    string
+	claims    []ClaimPath
+	expiresIn time.Duration
+	sign      Signer
+}
+
+// NewClaimsHeaderSigner returns the ClaimsHeaderSigner of the config, or nil if there is no config
+func NewClaimsHeaderSigner(cfg *SignedClaimsConfig) (*ClaimsHeaderSigner, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	signerCfg := cfg.Signer
+	signerCfg.FullSerialization = false
+	if signerCfg.URI != "" && !validJWKSource(signerCfg.URI, signerCfg.DisableJWKSecurity) {
+		return nil, ErrInsecureJWKSource
+	}
+	sign, err := newSigner(&signerCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ClaimsHeaderSigner{
+		header:    cfg.Header,
+		expiresIn: cfg.ExpiresIn.Duration(),
+		sign:      sign,
+	}
+	if s.header == "" {
+		s.header = defaultSignedClaimsHeader
+	}
+	for _, c := range cfg.Claims {
+		s.claims = append(s.claims, NewClaimPath(c, true))
+	}
+	return s, nil
+}
+
+// Sign returns the compact JWS with the selected claims
+func (s *ClaimsHeaderSigner) Sign(claims map[string]interface{}) (string, error) {
+	payload := claims
+	if len(s.claims) > 0 {
+		payload = map[string]interface{}{}
+		for _, path := range s.claims {
+			if v, ok := path.Lookup(claims); ok {
+				payload = withClaim(payload, path, v)
+			}
+		}
+	}
+	if s.expiresIn > 0 {
+		now := time.Now()
+		payload = withClaim(payload, NewClaimPath("iat", false), now.Unix())
+		payload = withClaim(payload, NewClaimPath("exp", false), now.Add(s.expiresIn).Unix())
+	}
+	return s.sign(payload)
+}
+
+// Propagate sets the signed claims header of the request, replacing the one sent by the client. The
+// header is removed if the claims can not be signed.
+func (s *ClaimsHeaderSigner) Propagate(r *http.Request, claims map[string]interface{}) error {
+	if s == nil {
+		return nil
+	}
+	r.Header.Del(s.header)
+	token, err := s.Sign(claims)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(s.header, token)
+	return nil
+}
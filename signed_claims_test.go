@@ -0,0 +1,110 @@
+package jose
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestClaimsHeaderSigner_Propagate(t *testing.T) {
+	s, err := NewClaimsHeaderSigner(&SignedClaimsConfig{
+		Claims:    []string{"sub", "org.id", "missing"},
+		ExpiresIn: 30,
+		Signer: SignerConfig{
+			Alg:       "RS256",
+			KeyID:     "2011-04-29",
+			LocalPath: "./fixtures/private.json",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+	req.Header.Set(defaultSignedClaimsHeader, "spoofed")
+	if err := s.Propagate(req, map[string]interface{}{
+		"sub":   "1234",
+		"email": "john@example.com",
+		"org":   map[string]interface{}{"id": "acme", "name": "ACME"},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	values := req.Header.Values(defaultSignedClaimsHeader)
+	if len(values) != 1 {
+		t.Errorf("unexpected header: %v", values)
+		return
+	}
+	token, err := jwt.ParseSigned(values[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if kid := token.Headers[0].KeyID; kid != "2011-04-29" {
+		t.Errorf("unexpected kid: %s", kid)
+	}
+
+	key := publicFixtureKey(t, "2011-04-29")
+	claims := map[string]interface{}{}
+	if err := token.Claims(key, &claims); err != nil {
+		t.Error(err)
+		return
+	}
+	exp, _ := claims["exp"].(float64)
+	iat, _ := claims["iat"].(float64)
+	if exp-iat != 30 || time.Unix(int64(iat), 0).After(time.Now()) {
+		t.Errorf("unexpected iat and exp: %v", claims)
+	}
+	delete(claims, "exp")
+	delete(claims, "iat")
+	if expected := map[string]interface{}{"sub": "1234", "org": map[string]interface{}{"id": "acme"}}; !reflect.DeepEqual(claims, expected) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+}
+
+func TestClaimsHeaderSigner_nil(t *testing.T) {
+	s, err := NewClaimsHeaderSigner(nil)
+	if s != nil || err != nil {
+		t.Errorf("unexpected signer: %v, %v", s, err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+	req.Header.Set(defaultSignedClaimsHeader, "spoofed")
+	if err := s.Propagate(req, map[string]interface{}{"sub": "1234"}); err != nil {
+		t.Error(err)
+	}
+	if h := req.Header.Get(defaultSignedClaimsHeader); h != "spoofed" {
+		t.Errorf("unexpected header: %s", h)
+	}
+}
+
+func TestNewClaimsHeaderSigner_insecure(t *testing.T) {
+	_, err := NewClaimsHeaderSigner(&SignedClaimsConfig{Signer: SignerConfig{Alg: "RS256", URI: "http://example.com/jwks.json"}})
+	if err != ErrInsecureJWKSource {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func publicFixtureKey(t *testing.T, kid string) interface{} {
+	data, err := os.ReadFile("./fixtures/private.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+	ks := keys.Key(kid)
+	if len(ks) == 0 {
+		t.Fatalf("unknown key %s", kid)
+	}
+	return ks[0].Public().Key
+}
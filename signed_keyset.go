@@ -0,0 +1,138 @@
+package jose
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// SignedKeySetType is the typ header of the signed key sets
+const SignedKeySetType = "jwk-set+jwt"
+
+var ErrInvalidSignedKeySet = errors.New("invalid signed key set")
+
+// SignedKeySetConfig verifies the key sets published as a JWT signed by a root key, as the signed JWKS of
+// the OpenID Federation, before trusting the keys they contain
+type SignedKeySetConfig struct {
+	// RootKeys is the public JWK, or the JWK set, allowed to sign the key set. "@/path" reads it from a file
+	RootKeys string `json:"root_keys"`
+	// Issuer, when set, must match the iss claim of the signed key set
+	Issuer string `json:"issuer,omitempty"`
+}
+
+// signedKeySetVerifier verifies the signed key sets with the root keys
+type signedKeySetVerifier struct {
+	roots  []jose.JSONWebKey
+	issuer string
+	now    func() time.Time
+}
+
+func newSignedKeySetVerifier(cfg *SignedKeySetConfig) (*signedKeySetVerifier, error) {
+	data := []byte(strings.TrimSpace(cfg.RootKeys))
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no root_keys", ErrInvalidSignedKeySet)
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil || len(keySet.Keys) == 0 {
+		var key jose.JSONWebKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, fmt.Errorf("%w: the root_keys are not a JWK or a JWK set: %s", ErrInvalidSignedKeySet, err.Error())
+		}
+		keySet.Keys = []jose.JSONWebKey{key}
+	}
+
+	v := &signedKeySetVerifier{issuer: cfg.Issuer, now: time.Now}
+	for _, k := range keySet.Keys {
+		public := k.Public()
+		if !public.Valid() {
+			return nil, fmt.Errorf("%w: the root key %q is not an asymmetric key", ErrInvalidSignedKeySet, k.KeyID)
+		}
+		v.roots = append(v.roots, public)
+	}
+	return v, nil
+}
+
+// verify checks the signature, the type, the issuer and the expiration of the signed key set, and returns
+// the JWK set it contains
+func (v *signedKeySetVerifier) verify(data []byte) ([]byte, error) {
+	jws, err := jose.ParseSigned(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignedKeySet, err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("%w: %d signatures", ErrInvalidSignedKeySet, len(jws.Signatures))
+	}
+	header := jws.Signatures[0].Header
+	if typ, ok := header.ExtraHeaders[jose.HeaderType].(string); ok && !strings.EqualFold(typ, SignedKeySetType) && !strings.EqualFold(typ, "application/"+SignedKeySetType) {
+		return nil, fmt.Errorf("%w: unexpected type %q", ErrInvalidSignedKeySet, typ)
+	}
+
+	var payload []byte
+	for _, root := range v.roots {
+		if header.KeyID != "" && root.KeyID != "" && header.KeyID != root.KeyID {
+			continue
+		}
+		if payload, err = jws.Verify(root); err == nil {
+			break
+		}
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("%w: the signature does not match any root key", ErrInvalidSignedKeySet)
+	}
+
+	var claims struct {
+		Keys   []json.RawMessage `json:"keys"`
+		Issuer string            `json:"iss"`
+		Expiry *float64          `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignedKeySet, err.Error())
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidSignedKeySet, claims.Issuer)
+	}
+	if claims.Expiry != nil && v.now().After(time.Unix(int64(*claims.Expiry), 0)) {
+		return nil, fmt.Errorf("%w: the key set has expired", ErrInvalidSignedKeySet)
+	}
+	if claims.Keys == nil {
+		return nil, fmt.Errorf("%w: no keys claim", ErrInvalidSignedKeySet)
+	}
+	return json.Marshal(rawKeySet{Keys: claims.Keys})
+}
+
+// signedKeySetTransport verifies the signed key sets downloaded by the JWK clients and replaces them with
+// the JWK sets they contain
+type signedKeySetTransport struct {
+	next     http.RoundTripper
+	verifier *signedKeySetVerifier
+}
+
+func (t signedKeySetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := t.verifier.verify(body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(keySet))
+	resp.ContentLength = int64(len(keySet))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(keySet)))
+	return resp, nil
+}
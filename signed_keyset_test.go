@@ -0,0 +1,106 @@
+package jose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func signKeySet(t *testing.T, root *ecdsa.PrivateKey, typ string, claims map[string]interface{}) string {
+	opts := (&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), "root")
+	if typ != "" {
+		opts = opts.WithType(jose.ContentType(typ))
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: root}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestSecretProvider_signedKeySet(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keySet map[string]interface{}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rootJWK, _ := json.Marshal(jose.JSONWebKey{Key: &root.PublicKey, KeyID: "root", Algorithm: "ES256"})
+
+	claims := func(exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":  "https://federation.example.com",
+			"exp":  time.Now().Add(exp).Unix(),
+			"keys": keySet["keys"],
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{name: "valid", body: signKeySet(t, root, SignedKeySetType, claims(time.Hour)), ok: true},
+		{name: "untyped", body: signKeySet(t, root, "", claims(time.Hour)), ok: true},
+		{name: "expired", body: signKeySet(t, root, SignedKeySetType, claims(-time.Hour))},
+		{name: "other type", body: signKeySet(t, root, "JWT", claims(time.Hour))},
+		{name: "other signer", body: signKeySet(t, other, SignedKeySetType, claims(time.Hour))},
+		{name: "not signed", body: string(data)},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/jwk-set+jwt")
+			w.Write([]byte(tc.body))
+		}))
+
+		sp, err := SecretProvider(SecretProviderConfig{
+			URI:           server.URL,
+			AllowInsecure: true,
+			SignedKeySet:  &SignedKeySetConfig{RootKeys: string(rootJWK), Issuer: "https://federation.example.com"},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.GetKey("2011-04-29"); (err == nil) != tc.ok {
+			t.Errorf("%s: unexpected result: %v", tc.name, err)
+		}
+		server.Close()
+	}
+}
+
+func TestSignedKeySetConfig_validation(t *testing.T) {
+	for _, cfg := range []*SignedKeySetConfig{
+		{},
+		{RootKeys: "not a key"},
+		{RootKeys: `{"kty":"oct","k":"c2VjcmV0","kid":"root"}`},
+	} {
+		if _, err := newSignedKeySetVerifier(cfg); !errors.Is(err, ErrInvalidSignedKeySet) {
+			t.Errorf("%+v: unexpected error %v", cfg, err)
+		}
+		errs := ValidateConfig(&SignatureConfig{Alg: "RS256", URI: "https://example.com/jwks", SignedKeySet: cfg})
+		if len(errs) != 1 || errs[0].(*ConfigError).Code != ConfigErrInvalidSignedKeySet {
+			t.Errorf("unexpected errors for %+v: %v", cfg, errs)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrorCodeInsufficientUserAuthentication is the error code defined by RFC 9470 for the tokens whose
+// authentication does not meet the requirements of the resource
+const ErrorCodeInsufficientUserAuthentication = "insufficient_user_authentication"
+
+var ErrInvalidStepUp = errors.New("invalid step up config")
+
+// StepUpConfig requires a level of authentication to the tokens, from their acr, amr and auth_time
+// claims. The tokens not satisfying it are rejected with a 401 challenge (RFC 9470) telling the client the
+// acr_values and the max_age to request to the authorization server.
+type StepUpConfig struct {
+	// ACRLevels are the known acr values, from the weakest to the strongest. When set, the tokens with an
+	// acr at or above the MinACR are accepted. Otherwise, the acr must be the MinACR.
+	ACRLevels []string `json:"acr_levels,omitempty"`
+	MinACR    string   `json:"min_acr,omitempty"`
+	// AMR are the authentication methods required in the amr claim, as mfa or hwk. All of them must be
+	// present.
+	AMR []string `json:"amr,omitempty"`
+	// MaxAge is the max time since the authentication of the user (the auth_time claim), in seconds or as
+	// "15m"
+	MaxAge Seconds `json:"max_age,omitempty"`
+}
+
+// stepUpConfig returns the step up config of the signature config, with the max_auth_age of the endpoint
+// as its max_age if it does not define one
+func stepUpConfig(scfg *SignatureConfig) *StepUpConfig {
+	if scfg.MaxAuthAge == 0 || (scfg.StepUp != nil && scfg.StepUp.MaxAge != 0) {
+		return scfg.StepUp
+	}
+	cfg := StepUpConfig{}
+	if scfg.StepUp != nil {
+		cfg = *scfg.StepUp
+	}
+	cfg.MaxAge = scfg.MaxAuthAge
+	return &cfg
+}
+
+// StepUp checks the authentication level of the tokens. A nil StepUp accepts all of them.
+type StepUp struct {
+	accepted  []string
+	amr       []string
+	maxAge    time.Duration
+	challenge []string
+	now       func() time.Time
+}
+
+// NewStepUp returns the StepUp of the config, or nil if there is no config
+func NewStepUp(cfg *StepUpConfig) (*StepUp, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.MinACR == "" && len(cfg.AMR) == 0 && cfg.MaxAge == 0 {
+		return nil, fmt.Errorf("%w: the min_acr, the amr or the max_age must be defined", ErrInvalidStepUp)
+	}
+	s := &StepUp{amr: cfg.AMR, maxAge: cfg.MaxAge.Duration(), now: time.Now}
+	if cfg.MinACR == "" {
+		return s, nil
+	}
+	if len(cfg.ACRLevels) == 0 {
+		s.accepted = []string{cfg.MinACR}
+		s.challenge = s.accepted
+		return s, nil
+	}
+	for i, level := range cfg.ACRLevels {
+		if level != cfg.MinACR {
+			continue
+		}
+		s.accepted = cfg.ACRLevels[i:]
+		// the challenge lists the strongest levels first, in order of preference
+		s.challenge = make([]string, len(s.accepted))
+		for j, acr := range s.accepted {
+			s.challenge[len(s.accepted)-1-j] = acr
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: the min_acr %q is not one of the acr_levels", ErrInvalidStepUp, cfg.MinACR)
+}
+
+// Authorize returns the step up error if the authentication of the token does not meet the requirements
+func (s *StepUp) Authorize(claims map[string]interface{}) *AuthError {
+	if s == nil {
+		return nil
+	}
+	if len(s.accepted) > 0 {
+		acr, _ := claimString(claims["acr"])
+		if !stringInSlice(acr, s.accepted) {
+			return s.error()
+		}
+	}
+	if len(s.amr) > 0 {
+		amr := claimValues(claims["amr"])
+		for _, method := range s.amr {
+			if !stringInSlice(method, amr) {
+				return s.error()
+			}
+		}
+	}
+	if s.maxAge > 0 {
+		authTime, ok := numericClaim(claims["auth_time"])
+		if !ok || s.now().Sub(time.Unix(authTime, 0)) > s.maxAge {
+			return s.error()
+		}
+	}
+	return nil
+}
+
+func (s *StepUp) error() *AuthError {
+	return NewStepUpError(s.challenge, s.maxAge)
+}
+
+// NewStepUpError returns the error for the tokens whose authentication is not strong or recent enough. The
+// acr values and the max age are reported in the WWW-Authenticate header.
+func NewStepUpError(acrValues []string, maxAge time.Duration) *AuthError {
+	return &AuthError{
+		Status:      http.StatusUnauthorized,
+		Code:        ErrorCodeInsufficientUserAuthentication,
+		Reason:      ReasonInsufficientAuth,
+		Description: "a different authentication level is required",
+		ACRValues:   acrValues,
+		MaxAge:      int64(maxAge / time.Second),
+	}
+}
@@ -0,0 +1,146 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStepUp_Authorize(t *testing.T) {
+	now := time.Now()
+	levels := []string{"urn:acr:pwd", "urn:acr:mfa", "urn:acr:hwk"}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      StepUpConfig
+		claims   map[string]interface{}
+		expected bool
+	}{
+		{
+			name:     "acr at the min level",
+			cfg:      StepUpConfig{ACRLevels: levels, MinACR: "urn:acr:mfa"},
+			claims:   map[string]interface{}{"acr": "urn:acr:mfa"},
+			expected: true,
+		},
+		{
+			name:     "acr above the min level",
+			cfg:      StepUpConfig{ACRLevels: levels, MinACR: "urn:acr:mfa"},
+			claims:   map[string]interface{}{"acr": "urn:acr:hwk"},
+			expected: true,
+		},
+		{
+			name:   "acr below the min level",
+			cfg:    StepUpConfig{ACRLevels: levels, MinACR: "urn:acr:mfa"},
+			claims: map[string]interface{}{"acr": "urn:acr:pwd"},
+		},
+		{
+			name:   "unknown acr",
+			cfg:    StepUpConfig{MinACR: "gold"},
+			claims: map[string]interface{}{"acr": "silver"},
+		},
+		{
+			name:     "amr",
+			cfg:      StepUpConfig{AMR: []string{"mfa", "hwk"}},
+			claims:   map[string]interface{}{"amr": []interface{}{"pwd", "hwk", "mfa"}},
+			expected: true,
+		},
+		{
+			name:   "missing amr",
+			cfg:    StepUpConfig{AMR: []string{"mfa", "hwk"}},
+			claims: map[string]interface{}{"amr": []interface{}{"pwd", "mfa"}},
+		},
+		{
+			name:     "recent authentication",
+			cfg:      StepUpConfig{MaxAge: 300},
+			claims:   map[string]interface{}{"auth_time": float64(now.Add(-time.Minute).Unix())},
+			expected: true,
+		},
+		{
+			name:   "old authentication",
+			cfg:    StepUpConfig{MaxAge: 300},
+			claims: map[string]interface{}{"auth_time": float64(now.Add(-time.Hour).Unix())},
+		},
+		{
+			name: "missing auth_time",
+			cfg:  StepUpConfig{MaxAge: 300},
+		},
+	} {
+		s, err := NewStepUp(&tc.cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		authErr := s.Authorize(tc.claims)
+		if tc.expected {
+			if authErr != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+			}
+			continue
+		}
+		if authErr == nil || authErr.Status != http.StatusUnauthorized || authErr.Code != ErrorCodeInsufficientUserAuthentication {
+			t.Errorf("%s: unexpected error: %v", tc.name, authErr)
+		}
+	}
+}
+
+func TestNewStepUp(t *testing.T) {
+	if s, err := NewStepUp(nil); s != nil || err != nil || s.Authorize(nil) != nil {
+		t.Errorf("unexpected result: %v %v", s, err)
+	}
+	for _, cfg := range []StepUpConfig{
+		{},
+		{ACRLevels: []string{"1", "2"}, MinACR: "3"},
+	} {
+		if _, err := NewStepUp(&cfg); !errors.Is(err, ErrInvalidStepUp) {
+			t.Errorf("%v: unexpected error: %v", cfg, err)
+		}
+	}
+}
+
+func TestStepUp_challenge(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{
+		RolesKey: "roles",
+		MethodRequirements: map[string]MethodRequirements{
+			"DELETE": {StepUp: &StepUpConfig{ACRLevels: []string{"pwd", "mfa", "hwk"}, MinACR: "mfa", MaxAge: 600}},
+		},
+	})
+	claims := map[string]interface{}{"acr": "pwd"}
+	if authErr := p.AuthorizeMethod(http.MethodGet, claims); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	authErr := p.AuthorizeMethod(http.MethodDelete, claims)
+	if authErr == nil || authErr.Reason != ReasonInsufficientAuth {
+		t.Fatalf("unexpected error: %v", authErr)
+	}
+	r := NewErrorRenderer(nil)
+	if h := r.WWWAuthenticate(authErr); h != `Bearer error="insufficient_user_authentication", acr_values="hwk mfa", max_age=600` {
+		t.Errorf("unexpected header: %s", h)
+	}
+}
+
+func TestPolicy_Authorize_maxAuthAge(t *testing.T) {
+	p := NewPolicy(&SignatureConfig{RolesKey: "roles", MaxAuthAge: 900})
+	now := time.Now()
+	if authErr := p.Authorize(map[string]interface{}{"auth_time": float64(now.Add(-time.Minute).Unix())}); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	for _, claims := range []map[string]interface{}{
+		{"auth_time": float64(now.Add(-time.Hour).Unix())},
+		{},
+	} {
+		authErr := p.Authorize(claims)
+		if authErr == nil || authErr.Reason != ReasonInsufficientAuth || authErr.MaxAge != 900 {
+			t.Errorf("unexpected error: %v", authErr)
+		}
+	}
+
+	cfg := stepUpConfig(&SignatureConfig{MaxAuthAge: 900, StepUp: &StepUpConfig{MinACR: "mfa", MaxAge: 60}})
+	if cfg.MaxAge != 60 || cfg.MinACR != "mfa" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	cfg = stepUpConfig(&SignatureConfig{MaxAuthAge: 900, StepUp: &StepUpConfig{MinACR: "mfa"}})
+	if cfg.MaxAge != 900 || cfg.MinACR != "mfa" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
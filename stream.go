@@ -0,0 +1,210 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	defaultStreamInterval    = 30 * time.Second
+	defaultStreamCloseStatus = http.StatusUnauthorized
+	defaultStreamCloseEvent  = "auth_error"
+)
+
+var ErrStreamTerminated = errors.New("the stream has been terminated by the token revalidation")
+
+// StreamRevalidationConfig enables the periodic revalidation of the tokens of the long-lived streaming
+// responses, as the Server-Sent Events ones. The streams are terminated as soon as the token expires or
+// it is rejected.
+type StreamRevalidationConfig struct {
+	// Interval is the time between the revocation checks, in seconds or as "30s". The expiration is
+	// checked on time, regardless of the interval. Defaults to 30s
+	Interval Seconds `json:"interval,omitempty"`
+	// Accept is the list of media types of the Accept header selecting the streaming requests. Defaults
+	// to text/event-stream
+	Accept []string `json:"accept,omitempty"`
+	// CloseStatus is the status code of the terminated streams when the response has not started yet.
+	// Defaults to 401
+	CloseStatus int `json:"close_status,omitempty"`
+	// CloseEvent is the name of the event sent before terminating a started stream. Its data is the JSON
+	// error, as in the error responses. Defaults to auth_error
+	CloseEvent string `json:"close_event,omitempty"`
+}
+
+// StreamRevalidator guards the streaming responses, terminating them when their token is no longer
+// valid. A nil StreamRevalidator guards nothing.
+type StreamRevalidator struct {
+	interval    time.Duration
+	accept      []string
+	closeStatus int
+	closeEvent  string
+}
+
+// NewStreamRevalidator returns the StreamRevalidator of the config, or nil if there is no config
+func NewStreamRevalidator(cfg *StreamRevalidationConfig) *StreamRevalidator {
+	if cfg == nil {
+		return nil
+	}
+	s := &StreamRevalidator{
+		interval:    cfg.Interval.Duration(),
+		accept:      cfg.Accept,
+		closeStatus: cfg.CloseStatus,
+		closeEvent:  cfg.CloseEvent,
+	}
+	if s.interval <= 0 {
+		s.interval = defaultStreamInterval
+	}
+	if len(s.accept) == 0 {
+		s.accept = []string{"text/event-stream"}
+	}
+	if s.closeStatus == 0 {
+		s.closeStatus = defaultStreamCloseStatus
+	}
+	if s.closeEvent == "" {
+		s.closeEvent = defaultStreamCloseEvent
+	}
+	return s
+}
+
+// IsStream returns true if the request accepts any of the streaming media types
+func (s *StreamRevalidator) IsStream(r *http.Request) bool {
+	if s == nil {
+		return false
+	}
+	for _, v := range r.Header.Values("Accept") {
+		for _, mt := range strings.Split(v, ",") {
+			mt = strings.TrimSpace(strings.SplitN(mt, ";", 2)[0])
+			for _, a := range s.accept {
+				if strings.EqualFold(mt, a) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Guard starts the revalidation of the claims of a streaming request. It returns the writer to use for
+// the response and the request to pass to the handler, whose context is cancelled when the stream is
+// terminated. The StreamWriter must be stopped once the response is done. The returned StreamWriter is
+// nil if the request is not a streaming one.
+func (s *StreamRevalidator) Guard(w http.ResponseWriter, r *http.Request, claims map[string]interface{}, rejecter Rejecter) (*StreamWriter, *http.Request) {
+	if !s.IsStream(r) {
+		return nil, r
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	sw := &StreamWriter{ResponseWriter: w, cancel: cancel}
+	go s.watch(ctx, sw, claims, rejecter)
+	return sw, r.WithContext(ctx)
+}
+
+func (s *StreamRevalidator) watch(ctx context.Context, sw *StreamWriter, claims map[string]interface{}, rejecter Rejecter) {
+	exp, hasExp := numericClaim(claims["exp"])
+	for {
+		wait := s.interval
+		if hasExp {
+			if until := time.Until(time.Unix(exp, 0)); until < wait {
+				wait = until
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		if hasExp && !time.Now().Before(time.Unix(exp, 0)) {
+			sw.terminate(NewTokenError(jwt.ErrExpired), s.closeStatus, s.closeEvent)
+			return
+		}
+		if rejecter != nil && rejecter.Reject(claims) {
+			sw.terminate(NewRejectedError(), s.closeStatus, s.closeEvent)
+			return
+		}
+	}
+}
+
+// StreamWriter is the response writer of a guarded stream. Once the stream is terminated, the writes
+// fail with ErrStreamTerminated.
+type StreamWriter struct {
+	http.ResponseWriter
+	mu      sync.Mutex
+	started bool
+	err     *AuthError
+	cancel  context.CancelFunc
+}
+
+// WriteHeader sends the status code, unless the stream has been terminated
+func (w *StreamWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the data, unless the stream has been terminated
+func (w *StreamWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, ErrStreamTerminated
+	}
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered data to the client, if the wrapped writer supports it
+func (w *StreamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Err returns the error that terminated the stream, if any
+func (w *StreamWriter) Err() *AuthError {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Stop ends the revalidation of the stream
+func (w *StreamWriter) Stop() {
+	w.cancel()
+}
+
+// terminate closes the stream with the close event, or with the close status if nothing has been sent
+// yet, and cancels the context of the request
+func (w *StreamWriter) terminate(authErr *AuthError, status int, event string) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = authErr
+		if w.started {
+			data, _ := json.Marshal(authErr)
+			fmt.Fprintf(w.ResponseWriter, "event: %s\ndata: %s\n\n", event, data)
+		} else {
+			w.ResponseWriter.WriteHeader(status)
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	w.mu.Unlock()
+	w.cancel()
+}
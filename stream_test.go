@@ -0,0 +1,110 @@
+package jose
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamRevalidator_IsStream(t *testing.T) {
+	s := NewStreamRevalidator(&StreamRevalidationConfig{})
+	for accept, expected := range map[string]bool{
+		"":                  false,
+		"application/json":  false,
+		"text/event-stream": true,
+		"application/json, Text/Event-Stream;q=0.9": true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		if res := s.IsStream(req); res != expected {
+			t.Errorf("unexpected result for %q: %v", accept, res)
+		}
+	}
+
+	var nilRevalidator *StreamRevalidator
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	if sw, _ := nilRevalidator.Guard(httptest.NewRecorder(), req, nil, nil); sw != nil {
+		t.Error("unexpected stream writer")
+	}
+}
+
+func TestStreamRevalidator_expired(t *testing.T) {
+	s := NewStreamRevalidator(&StreamRevalidationConfig{Interval: 3600})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	claims := map[string]interface{}{"exp": json.Number(strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))}
+
+	sw, req := s.Guard(w, req, claims, nil)
+	if sw == nil {
+		t.Error("the request should be guarded")
+		return
+	}
+	defer sw.Stop()
+
+	if _, err := sw.Write([]byte("data: hello\n\n")); err != nil {
+		t.Error(err)
+		return
+	}
+	sw.Flush()
+
+	select {
+	case <-req.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Error("the stream has not been terminated")
+		return
+	}
+
+	if authErr := sw.Err(); authErr == nil || authErr.Reason != ReasonExpired {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if _, err := sw.Write([]byte("data: bye\n\n")); err != ErrStreamTerminated {
+		t.Errorf("unexpected error: %v", err)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "data: hello\n\nevent: auth_error\ndata: {") || !strings.Contains(body, `"reason":"expired"`) {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+func TestStreamRevalidator_rejected(t *testing.T) {
+	s := NewStreamRevalidator(&StreamRevalidationConfig{Interval: 1, CloseStatus: http.StatusForbidden})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	var revoked int32
+	rejecter := RejecterFunc(func(_ map[string]interface{}) bool { return atomic.LoadInt32(&revoked) == 1 })
+
+	sw, req := s.Guard(w, req, map[string]interface{}{"sub": "1234"}, rejecter)
+	if sw == nil {
+		t.Error("the request should be guarded")
+		return
+	}
+	defer sw.Stop()
+
+	atomic.StoreInt32(&revoked, 1)
+
+	select {
+	case <-req.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Error("the stream has not been terminated")
+		return
+	}
+
+	if authErr := sw.Err(); authErr == nil || authErr.Reason != ReasonRejected {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected body: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,239 @@
+package jose
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSubjectHistorySize   = 10000
+	defaultSubjectHistoryTTL    = time.Hour
+	defaultSubjectHistoryTokens = 16
+)
+
+// SubjectHistoryConfig keeps a snapshot of the claims of the last accepted requests of every subject, so
+// the rejecters and the policy can correlate the requests of the same subject. The endpoints with the same
+// namespace share the snapshots.
+type SubjectHistoryConfig struct {
+	// Namespace is the name of the store shared by the endpoints. Defaults to default
+	Namespace string `json:"namespace,omitempty"`
+	// Size is the max number of subjects of the store. Defaults to 10000
+	Size int `json:"size,omitempty"`
+	// TTL is the time the snapshots are kept, in seconds or as "1h". Defaults to 1h
+	TTL Seconds `json:"ttl,omitempty"`
+	// SubjectClaim is the claim identifying the subject. Defaults to sub
+	SubjectClaim string `json:"subject_claim,omitempty"`
+	// StableClaims are the claims that can not change between the requests of a subject, as the tenant.
+	// The requests with a different value than the last accepted one are rejected.
+	StableClaims []string `json:"stable_claims,omitempty"`
+}
+
+// ClaimsSnapshot is the claims of an accepted request of a subject
+type ClaimsSnapshot struct {
+	Subject string
+	JTI     string
+	Claims  map[string]interface{}
+	Time    time.Time
+}
+
+// HistoryRejecter is implemented by the rejecters correlating the requests of a subject. The previous
+// snapshot is nil for the first request of the subject.
+type HistoryRejecter interface {
+	RejectWithHistory(claims map[string]interface{}, previous *ClaimsSnapshot) bool
+}
+
+// SubjectHistory checks the requests against the snapshots of their subjects. A nil SubjectHistory checks
+// and records nothing.
+type SubjectHistory struct {
+	store   *subjectStore
+	subject ClaimPath
+	stable  []ClaimPath
+}
+
+var (
+	subjectStores   = map[string]*subjectStore{}
+	subjectStoresMu sync.Mutex
+)
+
+// NewSubjectHistory returns the SubjectHistory of the config, or nil if there is no config. The size and
+// the ttl of a shared store are the ones of the first endpoint using it.
+func NewSubjectHistory(cfg *SubjectHistoryConfig) *SubjectHistory {
+	if cfg == nil {
+		return nil
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	subjectStoresMu.Lock()
+	store, ok := subjectStores[namespace]
+	if !ok {
+		store = newSubjectStore(cfg.Size, cfg.TTL.Duration())
+		subjectStores[namespace] = store
+	}
+	subjectStoresMu.Unlock()
+
+	subject := cfg.SubjectClaim
+	if subject == "" {
+		subject = "sub"
+	}
+	h := &SubjectHistory{store: store, subject: NewClaimPath(subject, true)}
+	for _, c := range cfg.StableClaims {
+		h.stable = append(h.stable, NewClaimPath(c, true))
+	}
+	return h
+}
+
+// Previous returns the snapshot of the last accepted request of the subject of the claims
+func (h *SubjectHistory) Previous(claims map[string]interface{}) (*ClaimsSnapshot, bool) {
+	if h == nil {
+		return nil, false
+	}
+	sub, ok := h.subject.Get(claims)
+	if !ok || sub == "" {
+		return nil, false
+	}
+	return h.store.latest(sub)
+}
+
+// Lookup returns the snapshot of the last accepted request of the subject with the token of the jti
+func (h *SubjectHistory) Lookup(sub, jti string) (*ClaimsSnapshot, bool) {
+	if h == nil {
+		return nil, false
+	}
+	return h.store.token(sub, jti)
+}
+
+// Check compares the claims with the last snapshot of their subject. The stable claims must keep their
+// values, and the rejecter is called with the snapshot if it implements the HistoryRejecter interface.
+func (h *SubjectHistory) Check(claims map[string]interface{}, rejecter Rejecter) *AuthError {
+	if h == nil {
+		return nil
+	}
+	previous, _ := h.Previous(claims)
+	if previous != nil {
+		for _, p := range h.stable {
+			current, okCurrent := p.Get(claims)
+			last, okLast := p.Get(previous.Claims)
+			if okCurrent != okLast || current != last {
+				return NewForbiddenError(ReasonClaimChanged)
+			}
+		}
+	}
+	if hr, ok := rejecter.(HistoryRejecter); ok && hr.RejectWithHistory(claims, previous) {
+		return NewRejectedError()
+	}
+	return nil
+}
+
+// Record stores the claims of an accepted request as the last snapshot of its subject
+func (h *SubjectHistory) Record(claims map[string]interface{}) {
+	if h == nil {
+		return
+	}
+	sub, ok := h.subject.Get(claims)
+	if !ok || sub == "" {
+		return
+	}
+	jti, _ := claims["jti"].(string)
+	h.store.add(&ClaimsSnapshot{Subject: sub, JTI: jti, Claims: claims})
+}
+
+type subjectEntry struct {
+	last   *ClaimsSnapshot
+	tokens map[string]*ClaimsSnapshot
+	// jtis keeps the order of the tokens, so the oldest one is dropped first
+	jtis []string
+}
+
+// subjectStore is a LRU cache of the snapshots of the subjects
+type subjectStore struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+func newSubjectStore(size int, ttl time.Duration) *subjectStore {
+	if size <= 0 {
+		size = defaultSubjectHistorySize
+	}
+	if ttl == 0 {
+		ttl = defaultSubjectHistoryTTL
+	}
+	return &subjectStore{size: size, ttl: ttl, entries: map[string]*list.Element{}, order: list.New(), now: time.Now}
+}
+
+// entry returns the entry of the subject, if its last snapshot has not expired. It must be called with the
+// lock held.
+func (s *subjectStore) entry(sub string) (*subjectEntry, bool) {
+	el, ok := s.entries[sub]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*subjectEntry)
+	if s.now().Sub(e.last.Time) > s.ttl {
+		s.order.Remove(el)
+		delete(s.entries, sub)
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *subjectStore) latest(sub string) (*ClaimsSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entry(sub)
+	if !ok {
+		return nil, false
+	}
+	return e.last, true
+}
+
+func (s *subjectStore) token(sub, jti string) (*ClaimsSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entry(sub)
+	if !ok {
+		return nil, false
+	}
+	snapshot, ok := e.tokens[jti]
+	if !ok || s.now().Sub(snapshot.Time) > s.ttl {
+		return nil, false
+	}
+	return snapshot, true
+}
+
+func (s *subjectStore) add(snapshot *ClaimsSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot.Time = s.now()
+
+	e, ok := s.entry(snapshot.Subject)
+	if !ok {
+		e = &subjectEntry{tokens: map[string]*ClaimsSnapshot{}}
+		s.entries[snapshot.Subject] = s.order.PushFront(e)
+	} else {
+		s.order.MoveToFront(s.entries[snapshot.Subject])
+	}
+	e.last = snapshot
+	if snapshot.JTI != "" {
+		if _, ok := e.tokens[snapshot.JTI]; !ok {
+			e.jtis = append(e.jtis, snapshot.JTI)
+		}
+		e.tokens[snapshot.JTI] = snapshot
+		if len(e.jtis) > defaultSubjectHistoryTokens {
+			delete(e.tokens, e.jtis[0])
+			e.jtis = e.jtis[1:]
+		}
+	}
+
+	for s.order.Len() > s.size {
+		last := s.order.Back()
+		s.order.Remove(last)
+		delete(s.entries, last.Value.(*subjectEntry).last.Subject)
+	}
+}
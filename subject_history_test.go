@@ -0,0 +1,113 @@
+package jose
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubjectHistory_Check(t *testing.T) {
+	h := NewSubjectHistory(&SubjectHistoryConfig{Namespace: t.Name(), StableClaims: []string{"org.tenant"}})
+
+	first := map[string]interface{}{"sub": "alice", "jti": "1", "org": map[string]interface{}{"tenant": "acme"}}
+	if authErr := h.Check(first, FixedRejecter(false)); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	h.Record(first)
+
+	same := map[string]interface{}{"sub": "alice", "jti": "2", "org": map[string]interface{}{"tenant": "acme"}}
+	if authErr := h.Check(same, FixedRejecter(false)); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	changed := map[string]interface{}{"sub": "alice", "jti": "3", "org": map[string]interface{}{"tenant": "evil"}}
+	if authErr := h.Check(changed, FixedRejecter(false)); authErr == nil || authErr.Reason != ReasonClaimChanged {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	other := map[string]interface{}{"sub": "bob", "org": map[string]interface{}{"tenant": "evil"}}
+	if authErr := h.Check(other, FixedRejecter(false)); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+
+	// the endpoints with the same namespace share the snapshots
+	shared := NewSubjectHistory(&SubjectHistoryConfig{Namespace: t.Name()})
+	if s, ok := shared.Lookup("alice", "1"); !ok || s.Claims["org"].(map[string]interface{})["tenant"] != "acme" {
+		t.Errorf("unexpected snapshot: %v", s)
+	}
+	if _, ok := shared.Lookup("alice", "2"); ok {
+		t.Error("the snapshot of a token not recorded should be missing")
+	}
+}
+
+type historyRejecter struct {
+	calls []*ClaimsSnapshot
+}
+
+func (*historyRejecter) Reject(map[string]interface{}) bool { return false }
+
+func (r *historyRejecter) RejectWithHistory(claims map[string]interface{}, previous *ClaimsSnapshot) bool {
+	r.calls = append(r.calls, previous)
+	return previous != nil && previous.Claims["ip"] != claims["ip"]
+}
+
+func TestSubjectHistory_historyRejecter(t *testing.T) {
+	h := NewSubjectHistory(&SubjectHistoryConfig{Namespace: t.Name()})
+	r := &historyRejecter{}
+	rejecter := chainedRejecter{FixedRejecter(false), r}
+
+	claims := map[string]interface{}{"sub": "alice", "ip": "10.0.0.1"}
+	if authErr := h.Check(claims, rejecter); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	h.Record(claims)
+	if authErr := h.Check(map[string]interface{}{"sub": "alice", "ip": "10.0.0.1"}, rejecter); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if authErr := h.Check(map[string]interface{}{"sub": "alice", "ip": "192.168.1.1"}, rejecter); authErr == nil || authErr.Reason != ReasonRejected {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if len(r.calls) != 3 || r.calls[0] != nil || r.calls[1] == nil {
+		t.Errorf("unexpected calls: %v", r.calls)
+	}
+}
+
+func TestSubjectStore(t *testing.T) {
+	s := newSubjectStore(2, time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		s.add(&ClaimsSnapshot{Subject: fmt.Sprintf("user-%d", i)})
+	}
+	if _, ok := s.latest("user-0"); ok {
+		t.Error("the least recently used subject should be evicted")
+	}
+	if _, ok := s.latest("user-2"); !ok {
+		t.Error("the last subject should be kept")
+	}
+
+	for i := 0; i < defaultSubjectHistoryTokens+1; i++ {
+		s.add(&ClaimsSnapshot{Subject: "user-2", JTI: fmt.Sprintf("jti-%d", i)})
+	}
+	if _, ok := s.token("user-2", "jti-0"); ok {
+		t.Error("the oldest token should be dropped")
+	}
+	if _, ok := s.token("user-2", fmt.Sprintf("jti-%d", defaultSubjectHistoryTokens)); !ok {
+		t.Error("the last token should be kept")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := s.latest("user-2"); ok {
+		t.Error("the expired snapshots should be dropped")
+	}
+}
+
+func TestSubjectHistory_nil(t *testing.T) {
+	var h *SubjectHistory
+	h.Record(map[string]interface{}{"sub": "alice"})
+	if authErr := h.Check(map[string]interface{}{"sub": "alice"}, FixedRejecter(true)); authErr != nil {
+		t.Errorf("unexpected error: %v", authErr)
+	}
+	if NewSubjectHistory(nil) != nil {
+		t.Error("no config should return a nil history")
+	}
+}
@@ -0,0 +1,121 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrEmptyTenantClaim = errors.New("tenant config without claim")
+
+// TenantConfig enables the tenant isolation of an endpoint. The tenant is read from a claim and checked
+// against the allowed ones. The requests without tenant are rejected.
+type TenantConfig struct {
+	// Claim is the name of the claim with the tenant. Dots access nested claims.
+	Claim string `json:"claim"`
+	// Allowed is the list of tenants accepted. Empty lists accept any tenant.
+	Allowed []string `json:"allowed,omitempty"`
+	// EndpointTenant is the only tenant allowed to access the endpoint, if set
+	EndpointTenant string `json:"endpoint_tenant,omitempty"`
+	// Header receives the tenant, replacing the value sent by the client. It must be listed in the headers
+	// to pass of the endpoint.
+	Header string `json:"header,omitempty"`
+	// QueryParam receives the tenant, replacing the value sent by the client. It must be listed in the
+	// query strings of the endpoint.
+	QueryParam string `json:"query_param,omitempty"`
+}
+
+// TenantResolver extracts and checks the tenant of the requests. A nil TenantResolver accepts all the
+// requests.
+type TenantResolver struct {
+	path       ClaimPath
+	allowed    map[string]struct{}
+	endpoint   string
+	header     string
+	queryParam string
+}
+
+// NewTenantResolver returns the TenantResolver of the config, or nil if there is no config
+func NewTenantResolver(cfg *TenantConfig) (*TenantResolver, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Claim == "" {
+		return nil, ErrEmptyTenantClaim
+	}
+	t := &TenantResolver{
+		path:       NewClaimPath(cfg.Claim, true),
+		endpoint:   cfg.EndpointTenant,
+		header:     cfg.Header,
+		queryParam: cfg.QueryParam,
+	}
+	if len(cfg.Allowed) > 0 {
+		t.allowed = make(map[string]struct{}, len(cfg.Allowed))
+		for _, a := range cfg.Allowed {
+			t.allowed[a] = struct{}{}
+		}
+	}
+	return t, nil
+}
+
+// Tenant returns the tenant of the claims
+func (t *TenantResolver) Tenant(claims map[string]interface{}) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	v, ok := t.path.Lookup(claims)
+	if !ok {
+		return "", false
+	}
+	tenant, ok := claimString(v)
+	return tenant, ok && tenant != ""
+}
+
+// otherTenantLabel replaces the tenants not configured in the metrics
+const otherTenantLabel = "other"
+
+// MetricsLabel returns the tenant as reported by the metrics. Only the allowed tenants and the tenant of
+// the endpoint are reported, and the rest as "other", so the tokens can not create unbounded label values.
+func (t *TenantResolver) MetricsLabel(tenant string) string {
+	if t == nil || tenant == "" || tenant == t.endpoint {
+		return tenant
+	}
+	if _, ok := t.allowed[tenant]; ok {
+		return tenant
+	}
+	return otherTenantLabel
+}
+
+// Check returns the tenant of the claims, or an AuthError if it is missing or not allowed
+func (t *TenantResolver) Check(claims map[string]interface{}) (string, *AuthError) {
+	if t == nil {
+		return "", nil
+	}
+	tenant, ok := t.Tenant(claims)
+	if !ok {
+		return "", NewForbiddenError(ReasonTenantMismatch)
+	}
+	if t.endpoint != "" && tenant != t.endpoint {
+		return tenant, NewForbiddenError(ReasonTenantMismatch)
+	}
+	if t.allowed != nil {
+		if _, ok := t.allowed[tenant]; !ok {
+			return tenant, NewForbiddenError(ReasonTenantMismatch)
+		}
+	}
+	return tenant, nil
+}
+
+// Inject adds the tenant to the header and the query param of the request, if they are configured
+func (t *TenantResolver) Inject(r *http.Request, tenant string) {
+	if t == nil || tenant == "" {
+		return
+	}
+	if t.header != "" {
+		r.Header.Set(t.header, tenant)
+	}
+	if t.queryParam != "" {
+		q := r.URL.Query()
+		q.Set(t.queryParam, tenant)
+		r.URL.RawQuery = q.Encode()
+	}
+}
@@ -0,0 +1,151 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTenantResolver(t *testing.T) {
+	if tr, err := NewTenantResolver(nil); tr != nil || err != nil {
+		t.Errorf("unexpected resolver: %v, %v", tr, err)
+	}
+	if _, err := NewTenantResolver(&TenantConfig{}); !errors.Is(err, ErrEmptyTenantClaim) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTenantResolver_Check(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    TenantConfig
+		claims map[string]interface{}
+		tenant string
+		ok     bool
+	}{
+		{
+			name:   "any tenant",
+			cfg:    TenantConfig{Claim: "tenant"},
+			claims: map[string]interface{}{"tenant": "acme"},
+			tenant: "acme",
+			ok:     true,
+		},
+		{
+			name:   "missing tenant",
+			cfg:    TenantConfig{Claim: "tenant"},
+			claims: map[string]interface{}{"sub": "1234"},
+		},
+		{
+			name:   "empty tenant",
+			cfg:    TenantConfig{Claim: "tenant"},
+			claims: map[string]interface{}{"tenant": ""},
+		},
+		{
+			name:   "allowed nested tenant",
+			cfg:    TenantConfig{Claim: "org.id", Allowed: []string{"acme", "globex"}},
+			claims: map[string]interface{}{"org": map[string]interface{}{"id": "globex"}},
+			tenant: "globex",
+			ok:     true,
+		},
+		{
+			name:   "tenant not allowed",
+			cfg:    TenantConfig{Claim: "tenant", Allowed: []string{"acme"}},
+			claims: map[string]interface{}{"tenant": "globex"},
+			tenant: "globex",
+		},
+		{
+			name:   "endpoint tenant",
+			cfg:    TenantConfig{Claim: "tenant", EndpointTenant: "acme"},
+			claims: map[string]interface{}{"tenant": "acme"},
+			tenant: "acme",
+			ok:     true,
+		},
+		{
+			name:   "other endpoint tenant",
+			cfg:    TenantConfig{Claim: "tenant", EndpointTenant: "acme", Allowed: []string{"acme", "globex"}},
+			claims: map[string]interface{}{"tenant": "globex"},
+			tenant: "globex",
+		},
+		{
+			name:   "numeric tenant",
+			cfg:    TenantConfig{Claim: "tenant", Allowed: []string{"42"}},
+			claims: map[string]interface{}{"tenant": 42.0},
+			tenant: "42",
+			ok:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := NewTenantResolver(&tc.cfg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tenant, authErr := tr.Check(tc.claims)
+			if tenant != tc.tenant {
+				t.Errorf("unexpected tenant: %q", tenant)
+			}
+			if tc.ok {
+				if authErr != nil {
+					t.Errorf("unexpected error: %v", authErr)
+				}
+				return
+			}
+			if authErr == nil || authErr.Status != http.StatusForbidden || authErr.Reason != ReasonTenantMismatch {
+				t.Errorf("unexpected error: %v", authErr)
+			}
+		})
+	}
+}
+
+func TestTenantResolver_Inject(t *testing.T) {
+	tr, _ := NewTenantResolver(&TenantConfig{Claim: "tenant", Header: "X-Tenant", QueryParam: "tenant"})
+
+	req := httptest.NewRequest(http.MethodGet, "/foo?tenant=globex&page=2", http.NoBody)
+	req.Header.Set("X-Tenant", "globex")
+	tr.Inject(req, "acme")
+
+	if h := req.Header.Get("X-Tenant"); h != "acme" {
+		t.Errorf("unexpected header: %s", h)
+	}
+	q := req.URL.Query()
+	if v := q["tenant"]; len(v) != 1 || v[0] != "acme" {
+		t.Errorf("unexpected query param: %v", v)
+	}
+	if q.Get("page") != "2" {
+		t.Errorf("unexpected query: %s", req.URL.RawQuery)
+	}
+}
+
+func TestTenantResolver_nil(t *testing.T) {
+	var tr *TenantResolver
+	if tenant, authErr := tr.Check(map[string]interface{}{}); tenant != "" || authErr != nil {
+		t.Errorf("unexpected result: %q, %v", tenant, authErr)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/foo", http.NoBody)
+	tr.Inject(req, "acme")
+	if len(req.Header) != 0 || req.URL.RawQuery != "" {
+		t.Errorf("unexpected request: %v", req)
+	}
+}
+
+func TestTenantResolver_MetricsLabel(t *testing.T) {
+	tr, _ := NewTenantResolver(&TenantConfig{Claim: "tenant", Allowed: []string{"acme", "globex"}, EndpointTenant: "initech"})
+	for tenant, expected := range map[string]string{
+		"acme":    "acme",
+		"initech": "initech",
+		"evil-1":  "other",
+		"":        "",
+	} {
+		if label := tr.MetricsLabel(tenant); label != expected {
+			t.Errorf("%q: unexpected label %q", tenant, label)
+		}
+	}
+	open, _ := NewTenantResolver(&TenantConfig{Claim: "tenant"})
+	if label := open.MetricsLabel("acme"); label != "other" {
+		t.Errorf("the tenants not configured should not be reported: %q", label)
+	}
+	if label := (*TenantResolver)(nil).MetricsLabel("acme"); label != "acme" {
+		t.Errorf("unexpected label of the nil resolver: %q", label)
+	}
+}
@@ -0,0 +1,50 @@
+package jose
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TimeoutsConfig limits the duration of the stages of the validation pipeline. The values are durations
+// as "500ms" or "2s". Empty values disable the limit.
+type TimeoutsConfig struct {
+	// KeyFetch limits the download of the key set. The key fetch also honors the cancellation and the
+	// deadline of the request context.
+	KeyFetch string `json:"key_fetch,omitempty"`
+	// Validation limits the whole validation of the token, key fetch included
+	Validation string `json:"validation,omitempty"`
+}
+
+func (t *TimeoutsConfig) keyFetch() (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+	return parseTimeout(t.KeyFetch)
+}
+
+func (t *TimeoutsConfig) validation() (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+	return parseTimeout(t.Validation)
+}
+
+func parseTimeout(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// timeoutClaimsValidator adds the validation timeout to the context of the request passed to the validator
+func timeoutClaimsValidator(v ClaimsValidator, timeout time.Duration) ClaimsValidator {
+	if timeout <= 0 {
+		return v
+	}
+	return func(r *http.Request) (map[string]interface{}, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		return v(r.WithContext(ctx))
+	}
+}
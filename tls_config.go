@@ -0,0 +1,90 @@
+package jose
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTLS is returned when the TLS config of the JWK client is not valid
+var ErrInvalidTLS = errors.New("invalid TLS config")
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P-256":  tls.CurveP256,
+		"P-384":  tls.CurveP384,
+		"P-521":  tls.CurveP521,
+	}
+	tlsRenegotiation = map[string]tls.RenegotiationSupport{
+		"never":  tls.RenegotiateNever,
+		"once":   tls.RenegotiateOnceAsClient,
+		"freely": tls.RenegotiateFreelyAsClient,
+	}
+)
+
+// TLSConfig tunes the TLS connections to the JWK service. The cipher_suites only apply to TLS 1.2, as the
+// ones of TLS 1.3 are not configurable.
+type TLSConfig struct {
+	// MinVersion is the min TLS version: 1.2 (the default) or 1.3
+	MinVersion string `json:"min_version,omitempty"`
+	// MaxVersion is the max TLS version: 1.2 or 1.3. Defaults to the max supported
+	MaxVersion string `json:"max_version,omitempty"`
+	// CurvePreferences are the curves of the key exchange, in order of preference: X25519, P-256, P-384
+	// and P-521. Defaults to the ones of the Go runtime
+	CurvePreferences []string `json:"curve_preferences,omitempty"`
+	// Renegotiation is the renegotiation accepted: never (the default), once or freely
+	Renegotiation string `json:"renegotiation,omitempty"`
+}
+
+func (c *TLSConfig) validate() error {
+	return c.apply(&tls.Config{MinVersion: tls.VersionTLS12})
+}
+
+// apply sets the config into t
+func (c *TLSConfig) apply(t *tls.Config) error {
+	if c == nil {
+		return nil
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return fmt.Errorf("%w: unknown min_version %q. Supported values: 1.2, 1.3", ErrInvalidTLS, c.MinVersion)
+		}
+		t.MinVersion = v
+	}
+	if c.MaxVersion != "" {
+		v, ok := tlsVersions[c.MaxVersion]
+		if !ok {
+			return fmt.Errorf("%w: unknown max_version %q. Supported values: 1.2, 1.3", ErrInvalidTLS, c.MaxVersion)
+		}
+		if v < t.MinVersion {
+			return fmt.Errorf("%w: the max_version is lower than the min_version", ErrInvalidTLS)
+		}
+		t.MaxVersion = v
+	}
+	if len(c.CurvePreferences) > 0 {
+		curves := make([]tls.CurveID, len(c.CurvePreferences))
+		for i, name := range c.CurvePreferences {
+			curve, ok := tlsCurves[strings.ToUpper(name)]
+			if !ok {
+				return fmt.Errorf("%w: unknown curve %q. Supported values: X25519, P-256, P-384, P-521", ErrInvalidTLS, name)
+			}
+			curves[i] = curve
+		}
+		t.CurvePreferences = curves
+	}
+	if c.Renegotiation != "" {
+		r, ok := tlsRenegotiation[c.Renegotiation]
+		if !ok {
+			return fmt.Errorf("%w: unknown renegotiation %q. Supported values: never, once, freely", ErrInvalidTLS, c.Renegotiation)
+		}
+		t.Renegotiation = r
+	}
+	return nil
+}
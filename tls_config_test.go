@@ -0,0 +1,73 @@
+package jose
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestTLSConfig_apply(t *testing.T) {
+	cfg := &TLSConfig{
+		MinVersion:       "1.3",
+		CurvePreferences: []string{"x25519", "P-256"},
+		Renegotiation:    "once",
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := cfg.apply(tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 || tlsConfig.MaxVersion != 0 {
+		t.Errorf("unexpected versions: %x %x", tlsConfig.MinVersion, tlsConfig.MaxVersion)
+	}
+	if len(tlsConfig.CurvePreferences) != 2 || tlsConfig.CurvePreferences[0] != tls.X25519 {
+		t.Errorf("unexpected curves: %v", tlsConfig.CurvePreferences)
+	}
+	if tlsConfig.Renegotiation != tls.RenegotiateOnceAsClient {
+		t.Errorf("unexpected renegotiation: %v", tlsConfig.Renegotiation)
+	}
+
+	for _, cfg := range []*TLSConfig{
+		{MinVersion: "1.1"},
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{CurvePreferences: []string{"P-224"}},
+		{Renegotiation: "always"},
+	} {
+		if err := cfg.validate(); !errors.Is(err, ErrInvalidTLS) {
+			t.Errorf("unexpected error for %+v: %v", cfg, err)
+		}
+	}
+}
+
+func TestSecretProvider_tlsVersion(t *testing.T) {
+	data, err := os.ReadFile("./fixtures/public.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		cfg *TLSConfig
+		ok  bool
+	}{
+		{cfg: nil, ok: true},
+		{cfg: &TLSConfig{MaxVersion: "1.2", CurvePreferences: []string{"P-256"}}, ok: true},
+		{cfg: &TLSConfig{MinVersion: "1.3"}},
+	} {
+		sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, TLS: tc.cfg}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sp.GetKey("2011-04-29"); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result: %v", tc.cfg, err)
+		}
+	}
+}
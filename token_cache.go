@@ -0,0 +1,192 @@
+package jose
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultTokenCacheSize = 1000
+
+// TokenCacheConfig enables the cache of the validated tokens. The claims of a token are cached until its
+// expiration, so the clients sending the same token skip the signature verification.
+type TokenCacheConfig struct {
+	// Size is the max number of cached tokens. Defaults to 1000
+	Size int `json:"size,omitempty"`
+	// MaxTTL limits the time a token is cached, in seconds or as "10m". The tokens without exp claim are only
+	// cached if it is set.
+	MaxTTL Seconds `json:"max_ttl,omitempty"`
+}
+
+// RevocationVersioner is implemented by the rejecters able to report the changes of their revocation
+// lists. The cached tokens are dropped every time the version changes.
+type RevocationVersioner interface {
+	RevocationVersion() uint64
+}
+
+// NewCachedClaimsValidator adds the token cache of the signature config to the validator. The rejecter
+// is still evaluated for every request, but if it implements the RevocationVersioner interface, the
+// cache is also flushed when its revocation list changes. The cached validator skips the checks bound to
+// the request, so it must only wrap the validation of the token itself, as the ValidatorSet does.
+func NewCachedClaimsValidator(v ClaimsValidator, signatureConfig *SignatureConfig, rejecter Rejecter) ClaimsValidator {
+	if signatureConfig.TokenCache == nil {
+		return v
+	}
+	cookieKey := signatureConfig.CookieKey
+	versioner, _ := rejecter.(RevocationVersioner)
+	c := newTokenCache(signatureConfig.TokenCache, versioner)
+
+	return func(r *http.Request) (map[string]interface{}, error) {
+		r, token := requestToken(r, cookieKey)
+		if token.Raw == "" {
+			return v(r)
+		}
+		key := sha256.Sum256([]byte(token.Raw))
+		now := time.Now()
+		if claims, ok := c.get(key, now); ok {
+			DefaultMetrics.TokenCacheLookup(true)
+			token.Claims = claims
+			return claims, nil
+		}
+		DefaultMetrics.TokenCacheLookup(false)
+
+		claims, err := v(r)
+		if err != nil {
+			return nil, err
+		}
+		c.add(key, claims, now)
+		return copyClaims(claims), nil
+	}
+}
+
+type tokenCacheEntry struct {
+	key     [sha256.Size]byte
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// tokenCache is a LRU cache of the claims of the validated tokens
+type tokenCache struct {
+	mu        sync.Mutex
+	size      int
+	maxTTL    time.Duration
+	entries   map[[sha256.Size]byte]*list.Element
+	order     *list.List
+	versioner RevocationVersioner
+	version   uint64
+}
+
+func newTokenCache(cfg *TokenCacheConfig, versioner RevocationVersioner) *tokenCache {
+	c := &tokenCache{
+		size:      cfg.Size,
+		maxTTL:    cfg.MaxTTL.Duration(),
+		entries:   map[[sha256.Size]byte]*list.Element{},
+		order:     list.New(),
+		versioner: versioner,
+	}
+	if c.size <= 0 {
+		c.size = defaultTokenCacheSize
+	}
+	if versioner != nil {
+		c.version = versioner.RevocationVersion()
+	}
+	return c
+}
+
+func (c *tokenCache) get(key [sha256.Size]byte, now time.Time) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkVersion()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*tokenCacheEntry)
+	if !now.Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return copyClaims(e.claims), true
+}
+
+func (c *tokenCache) add(key [sha256.Size]byte, claims map[string]interface{}, now time.Time) {
+	expires, ok := c.expiration(claims, now)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkVersion()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&tokenCacheEntry{key: key, claims: claims, expires: expires})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*tokenCacheEntry).key)
+	}
+}
+
+// expiration returns the time the claims can be cached until, limited by the max ttl
+func (c *tokenCache) expiration(claims map[string]interface{}, now time.Time) (time.Time, bool) {
+	var expires time.Time
+	switch exp := claims["exp"].(type) {
+	case float64:
+		expires = time.Unix(int64(exp), 0)
+	case int64:
+		expires = time.Unix(exp, 0)
+	case json.Number:
+		secs, err := exp.Float64()
+		if err != nil {
+			return expires, false
+		}
+		expires = time.Unix(int64(secs), 0)
+	case string:
+		t, err := time.Parse(time.RFC3339, exp)
+		if err != nil {
+			return expires, false
+		}
+		expires = t
+	default:
+		if c.maxTTL == 0 {
+			return expires, false
+		}
+		return now.Add(c.maxTTL), true
+	}
+
+	if c.maxTTL > 0 && expires.After(now.Add(c.maxTTL)) {
+		expires = now.Add(c.maxTTL)
+	}
+	return expires, expires.After(now)
+}
+
+// checkVersion flushes the cache if the revocation list has changed. The lock must be held.
+func (c *tokenCache) checkVersion() {
+	if c.versioner == nil {
+		return
+	}
+	if v := c.versioner.RevocationVersion(); v != c.version {
+		c.version = v
+		c.entries = map[[sha256.Size]byte]*list.Element{}
+		c.order.Init()
+	}
+}
+
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,197 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/auth0-community/go-auth0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestNewCachedClaimsValidator(t *testing.T) {
+	var calls uint32
+	v := func(r *http.Request) (map[string]interface{}, error) {
+		atomic.AddUint32(&calls, 1)
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			return map[string]interface{}{"sub": "1234", "exp": float64(time.Now().Add(time.Hour).Unix())}, nil
+		case "Bearer no_exp":
+			return map[string]interface{}{"sub": "1234"}, nil
+		}
+		return nil, errors.New("invalid token")
+	}
+
+	rejecter := &versionedRejecter{}
+	cached := NewCachedClaimsValidator(v, &SignatureConfig{TokenCache: &TokenCacheConfig{}}, rejecter)
+
+	request := func(token string) (map[string]interface{}, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return cached(req)
+	}
+
+	for i := 0; i < 3; i++ {
+		claims, err := request("valid")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if claims["sub"] != "1234" {
+			t.Errorf("unexpected claims: %v", claims)
+		}
+		claims["sub"] = "modified"
+	}
+	if c := atomic.LoadUint32(&calls); c != 1 {
+		t.Errorf("unexpected number of validations: %d", c)
+	}
+
+	rejecter.version++
+	request("valid")
+	if c := atomic.LoadUint32(&calls); c != 2 {
+		t.Errorf("the cache should be flushed after a revocation change: %d", c)
+	}
+
+	request("no_exp")
+	request("no_exp")
+	if c := atomic.LoadUint32(&calls); c != 4 {
+		t.Errorf("the tokens without exp should not be cached: %d", c)
+	}
+
+	request("invalid")
+	if _, err := request("invalid"); err == nil {
+		t.Error("error expected")
+	}
+	if c := atomic.LoadUint32(&calls); c != 6 {
+		t.Errorf("the invalid tokens should not be cached: %d", c)
+	}
+
+	if res := NewCachedClaimsValidator(v, &SignatureConfig{}, rejecter); res == nil {
+		t.Error("the validator should be returned when the cache is disabled")
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	c := newTokenCache(&TokenCacheConfig{Size: 2, MaxTTL: 60}, nil)
+
+	exp := float64(now.Add(time.Hour).Unix())
+	c.add([32]byte{1}, map[string]interface{}{"exp": exp}, now)
+	c.add([32]byte{2}, map[string]interface{}{"exp": exp}, now)
+	c.get([32]byte{1}, now)
+	c.add([32]byte{3}, map[string]interface{}{}, now)
+
+	if _, ok := c.get([32]byte{2}, now); ok {
+		t.Error("the least recently used entry should be evicted")
+	}
+	if _, ok := c.get([32]byte{1}, now); !ok {
+		t.Error("entry not found")
+	}
+	if _, ok := c.get([32]byte{3}, now); !ok {
+		t.Error("the tokens without exp should be cached for the max ttl")
+	}
+	if _, ok := c.get([32]byte{1}, now.Add(2*time.Minute)); ok {
+		t.Error("the entries should expire after the max ttl")
+	}
+
+	c.add([32]byte{4}, map[string]interface{}{"exp": float64(now.Add(-time.Second).Unix())}, now)
+	if _, ok := c.get([32]byte{4}, now); ok {
+		t.Error("the expired tokens should not be cached")
+	}
+	c.add([32]byte{5}, map[string]interface{}{"exp": now.Add(time.Minute).Format(time.RFC3339)}, now)
+	if _, ok := c.get([32]byte{5}, now); !ok {
+		t.Error("the RFC3339 exp claims should be supported")
+	}
+}
+
+type versionedRejecter struct {
+	version uint64
+}
+
+func (*versionedRejecter) Reject(_ map[string]interface{}) bool { return false }
+
+func (v *versionedRejecter) RevocationVersion() uint64 { return v.version }
+
+func TestNewValidatorSet_tokenCacheDPoP(t *testing.T) {
+	signingKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	proofKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: signingKey.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+
+	pub := jose.JSONWebKey{Key: proofKey.Public()}
+	thumbprint, _ := pub.Thumbprint(crypto.SHA256)
+	now := time.Now()
+	signer, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signingKey}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	token, err := jwt.Signed(signer).Claims(map[string]interface{}{
+		"jti": "token-1",
+		"aud": "api",
+		"iat": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+		"cnf": map[string]interface{}{"jkt": base64.RawURLEncoding.EncodeToString(thumbprint)},
+	}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofSigner, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: proofKey}, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"))
+	sum := sha256.Sum256([]byte(token))
+	proof, _ := jwt.Signed(proofSigner).Claims(map[string]interface{}{
+		"jti": "proof-1",
+		"htm": http.MethodGet,
+		"htu": "http://example.com/orders",
+		"iat": now.Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	}).CompactSerialize()
+
+	set, err := NewValidatorSet(&SignatureConfig{
+		Alg:                "ES256",
+		URI:                server.URL,
+		DisableJWKSecurity: true,
+		Audience:           []string{"api"},
+		FAPI:               &FAPIConfig{SenderConstraint: SenderConstraintDPoP},
+		TokenCache:         &TokenCacheConfig{},
+	}, func(string) func(*http.Request) (*jwt.JSONWebToken, error) {
+		return func(*http.Request) (*jwt.JSONWebToken, error) { return nil, auth0.ErrTokenNotFound }
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(proof string) error {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/orders", http.NoBody)
+		req.Header.Set("Authorization", "DPoP "+token)
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		_, err := set.Validator(req)
+		return err
+	}
+	if err := request(proof); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{"", proof} {
+		err := request(p)
+		if !errors.Is(err, ErrInvalidDPoPProof) {
+			t.Errorf("the cached token should require a new proof: %v", err)
+		}
+		if status := NewTokenError(err).Status; status != http.StatusUnauthorized {
+			t.Errorf("unexpected status: %d", status)
+		}
+	}
+}
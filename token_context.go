@@ -0,0 +1,76 @@
+package jose
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/auth0-community/go-auth0"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type tokenContextKey struct{}
+
+// Token is the token sent with a request. It is extracted once and carried by the request context, so the
+// stages of the validation pipeline (and the handlers behind the validator) share it instead of parsing it
+// again.
+type Token struct {
+	Raw   string
+	KeyID string
+	Alg   string
+	// Claims are set once the token has been validated
+	Claims Claims
+
+	once   sync.Once
+	jwt    *jwt.JSONWebToken
+	jwtErr error
+}
+
+// WithRequestToken returns a shallow copy of the request carrying its token in the context. The request is
+// returned untouched if its context already carries the token.
+func WithRequestToken(r *http.Request, cookieKey string) *http.Request {
+	r, _ = requestToken(r, cookieKey)
+	return r
+}
+
+// TokenFromContext returns the token carried by the context, if any
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(tokenContextKey{}).(*Token)
+	return t, ok
+}
+
+// requestToken returns the token of the request, extracting it if the context does not carry it yet
+func requestToken(r *http.Request, cookieKey string) (*http.Request, *Token) {
+	if t, ok := TokenFromContext(r.Context()); ok {
+		return r, t
+	}
+	t := &Token{Raw: requestRawToken(r, cookieKey)}
+	t.KeyID, t.Alg = tokenMetadata(t.Raw)
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, t)), t
+}
+
+// requestRawToken returns the raw token of the request, without parsing it
+func requestRawToken(r *http.Request, cookieKey string) string {
+	if t, ok := TokenFromContext(r.Context()); ok {
+		return t.Raw
+	}
+	if cookieKey == "" {
+		cookieKey = "access_token"
+	}
+	return rawTokenFromRequest(r, cookieKey)
+}
+
+// memoizedExtractor parses the JWT once per request. The secret provider and the validator extract the
+// token from the same request, so the second call reuses the result of the first one.
+func memoizedExtractor(te auth0.RequestTokenExtractor) auth0.RequestTokenExtractor {
+	return auth0.RequestTokenExtractorFunc(func(r *http.Request) (*jwt.JSONWebToken, error) {
+		t, ok := TokenFromContext(r.Context())
+		if !ok {
+			return te.Extract(r)
+		}
+		t.once.Do(func() {
+			t.jwt, t.jwtErr = te.Extract(r)
+		})
+		return t.jwt, t.jwtErr
+	})
+}
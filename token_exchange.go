@@ -0,0 +1,245 @@
+package jose
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+
+	tokenExchangeGrantType        = "urn:ietf:params:oauth:grant-type:token-exchange"
+	defaultTokenExchangeCacheSize = 1000
+)
+
+var ErrInvalidTokenExchange = errors.New("invalid token exchange config")
+
+// TokenExchangeConfig enables the exchange (RFC 8693) of the validated tokens for tokens scoped to the
+// backends, issued by the token exchange endpoint of the IdP. The exchanged token replaces the inbound one
+// in the requests sent to the backends, and it is cached per subject and audience.
+type TokenExchangeConfig struct {
+	// TokenURL is the token endpoint of the IdP
+	TokenURL string `json:"token_url"`
+	ClientID string `json:"client_id"`
+	// ClientSecret accepts the references of the config values, as "@/etc/krakend/client_secret"
+	ClientSecret string `json:"client_secret"`
+	// AuthStyle is how the client authenticates: header (Basic, the default) or params
+	AuthStyle string `json:"auth_style,omitempty"`
+	// Audience is the logical name of the backend the token is requested for
+	Audience string `json:"audience,omitempty"`
+	// Resource is the URI of the backend the token is requested for
+	Resource string   `json:"resource,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// RequestedTokenType defaults to urn:ietf:params:oauth:token-type:access_token
+	RequestedTokenType string `json:"requested_token_type,omitempty"`
+	// SubjectTokenType defaults to urn:ietf:params:oauth:token-type:access_token
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	// SubjectClaim is the claim identifying the subject in the cache. Defaults to sub
+	SubjectClaim string `json:"subject_claim,omitempty"`
+	// Header is the header carrying the exchanged token to the backend. Defaults to Authorization, with the
+	// Bearer prefix
+	Header string `json:"header,omitempty"`
+	// CacheSize is the max number of cached tokens. Defaults to 1000
+	CacheSize int `json:"cache_size,omitempty"`
+	// RefreshBefore is the time before the expiration when the token is exchanged again, in seconds or as
+	// "30s". Defaults to 30s
+	RefreshBefore Seconds `json:"refresh_before,omitempty"`
+	// Timeout limits the token requests, in seconds or as "10s". Defaults to 10s
+	Timeout                 Seconds  `json:"timeout,omitempty"`
+	CipherSuites            []uint16 `json:"cipher_suites,omitempty"`
+	LocalCA                 string   `json:"local_ca,omitempty"`
+	DisableTokenURLSecurity bool     `json:"disable_token_url_security,omitempty"`
+}
+
+// TokenExchanger exchanges the inbound tokens for the backend tokens. A nil TokenExchanger does nothing.
+type TokenExchanger struct {
+	cfg           *TokenExchangeConfig
+	client        *http.Client
+	header        string
+	subject       ClaimPath
+	refreshBefore time.Duration
+	cache         *exchangeCache
+	now           func() time.Time
+}
+
+// NewTokenExchanger returns the TokenExchanger of the config, or nil if there is no config
+func NewTokenExchanger(cfg *TokenExchangeConfig) (*TokenExchanger, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.TokenURL == "" {
+		return nil, fmt.Errorf("%w: the token_url is required", ErrInvalidTokenExchange)
+	}
+	if !validJWKSource(cfg.TokenURL, cfg.DisableTokenURLSecurity) {
+		return nil, fmt.Errorf("%w: %q is not an https URL and disable_token_url_security is not set", ErrInvalidTokenExchange, cfg.TokenURL)
+	}
+	switch cfg.AuthStyle {
+	case "", AuthStyleHeader, AuthStyleParams:
+	default:
+		return nil, fmt.Errorf("%w: unknown auth_style %q", ErrInvalidTokenExchange, cfg.AuthStyle)
+	}
+	client, err := newOutboundClient(cfg.CipherSuites, cfg.LocalCA, cfg.Timeout.Duration())
+	if err != nil {
+		return nil, err
+	}
+
+	e := &TokenExchanger{
+		cfg:           cfg,
+		client:        client,
+		header:        cfg.Header,
+		refreshBefore: cfg.RefreshBefore.Duration(),
+		cache:         newExchangeCache(cfg.CacheSize),
+		now:           time.Now,
+	}
+	if e.header == "" {
+		e.header = defaultOutboundTokenHeader
+	}
+	if e.refreshBefore == 0 {
+		e.refreshBefore = defaultOutboundRefreshBefore
+	}
+	subject := cfg.SubjectClaim
+	if subject == "" {
+		subject = "sub"
+	}
+	e.subject = NewClaimPath(subject, true)
+	return e, nil
+}
+
+// Exchange returns the backend token of the subject token, from the cache if the subject got a token
+// not about to expire. The cached tokens never outlive the subject token.
+func (e *TokenExchanger) Exchange(ctx context.Context, subjectToken string, claims map[string]interface{}) (string, error) {
+	now := e.now()
+	subject, _ := e.subject.Lookup(claims)
+	key := ""
+	if sub := normalizeClaim(subject); subject != nil && sub != "" {
+		key = sub + "\x00" + e.cfg.Audience
+		if token, ok := e.cache.get(key, now.Add(e.refreshBefore)); ok {
+			return token, nil
+		}
+	}
+
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {TokenTypeAccessToken},
+		"requested_token_type": {TokenTypeAccessToken},
+	}
+	if e.cfg.SubjectTokenType != "" {
+		form.Set("subject_token_type", e.cfg.SubjectTokenType)
+	}
+	if e.cfg.RequestedTokenType != "" {
+		form.Set("requested_token_type", e.cfg.RequestedTokenType)
+	}
+	if e.cfg.Audience != "" {
+		form.Set("audience", e.cfg.Audience)
+	}
+	if e.cfg.Resource != "" {
+		form.Set("resource", e.cfg.Resource)
+	}
+	if len(e.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(e.cfg.Scopes, " "))
+	}
+
+	auth := func(*http.Request) {}
+	if e.cfg.ClientID != "" {
+		auth = clientAuth(form, e.cfg.AuthStyle, e.cfg.ClientID, e.cfg.ClientSecret)
+	}
+	token, expiresIn, err := fetchToken(ctx, e.client, e.cfg.TokenURL, form, auth)
+	if err != nil {
+		return "", err
+	}
+
+	if key != "" {
+		expires := now.Add(defaultOutboundTokenLifetime)
+		if expiresIn > 0 {
+			expires = now.Add(expiresIn)
+		}
+		if exp, ok := numericClaim(claims["exp"]); ok && time.Unix(exp, 0).Before(expires) {
+			expires = time.Unix(exp, 0)
+		}
+		e.cache.add(key, token, expires)
+	}
+	return token, nil
+}
+
+// Propagate replaces the token header of the request with the exchanged token. The requests without
+// token (as the ones authenticated by the fallbacks) are not modified.
+func (e *TokenExchanger) Propagate(r *http.Request, claims map[string]interface{}) error {
+	if e == nil {
+		return nil
+	}
+	t, ok := TokenFromContext(r.Context())
+	if !ok || t.Raw == "" {
+		return nil
+	}
+	token, err := e.Exchange(r.Context(), t.Raw, claims)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(e.header, "Authorization") {
+		token = "Bearer " + token
+	}
+	r.Header.Set(e.header, token)
+	return nil
+}
+
+type exchangeCacheEntry struct {
+	key     string
+	token   string
+	expires time.Time
+}
+
+// exchangeCache is a LRU cache of the exchanged tokens
+type exchangeCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newExchangeCache(size int) *exchangeCache {
+	if size <= 0 {
+		size = defaultTokenExchangeCacheSize
+	}
+	return &exchangeCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns the token of the key if it is still valid at the given time
+func (c *exchangeCache) get(key string, at time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*exchangeCacheEntry)
+	if !at.Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return e.token, true
+}
+
+func (c *exchangeCache) add(key, token string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&exchangeCacheEntry{key: key, token: token, expires: expires})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*exchangeCacheEntry).key)
+	}
+}
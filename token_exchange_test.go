@@ -0,0 +1,98 @@
+package jose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenExchanger(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+			r.FormValue("subject_token_type") != TokenTypeAccessToken || r.FormValue("audience") != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		if r.FormValue("subject_token") == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"%s-%d","issued_token_type":"%s","token_type":"Bearer","expires_in":300}`,
+			r.FormValue("subject_token"), n, TokenTypeAccessToken)
+	}))
+	defer server.Close()
+
+	e, err := NewTokenExchanger(&TokenExchangeConfig{
+		TokenURL:                server.URL,
+		ClientID:                "gateway",
+		ClientSecret:            "s3cr3t",
+		Audience:                "orders",
+		DisableTokenURLSecurity: true,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	exp := func(d time.Duration) json.Number { return json.Number(strconv.FormatInt(now.Add(d).Unix(), 10)) }
+
+	for i, tc := range []struct {
+		token    string
+		claims   map[string]interface{}
+		expected string
+	}{
+		{"alice-1", map[string]interface{}{"sub": "alice", "exp": exp(time.Hour)}, "alice-1-1"},
+		{"alice-2", map[string]interface{}{"sub": "alice", "exp": exp(time.Hour)}, "alice-1-1"},
+		{"bob-1", map[string]interface{}{"sub": "bob", "exp": exp(10 * time.Second)}, "bob-1-2"},
+		{"bob-2", map[string]interface{}{"sub": "bob", "exp": exp(time.Hour)}, "bob-2-3"},
+		{"anon-1", map[string]interface{}{}, "anon-1-4"},
+		{"anon-2", map[string]interface{}{}, "anon-2-5"},
+	} {
+		token, err := e.Exchange(context.Background(), tc.token, tc.claims)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if token != tc.expected {
+			t.Errorf("#%d: unexpected token %q", i, token)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer carol-1")
+	req = WithRequestToken(req, "")
+	if err := e.Propagate(req, map[string]interface{}{"sub": "carol"}); err != nil {
+		t.Error(err)
+	}
+	if h := req.Header.Get("Authorization"); h != "Bearer carol-1-6" {
+		t.Errorf("unexpected header: %q", h)
+	}
+
+	_, err = e.Exchange(context.Background(), "revoked", map[string]interface{}{"sub": "mallory"})
+	if !errors.Is(err, ErrTokenEndpoint) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if authErr := NewTokenExchangeError(err); authErr.Status != http.StatusUnauthorized || authErr.Reason != ReasonTokenExchange {
+		t.Errorf("unexpected auth error: %+v", authErr)
+	}
+	if authErr := NewTokenExchangeError(context.DeadlineExceeded); authErr.Status != http.StatusBadGateway {
+		t.Errorf("unexpected auth error: %+v", authErr)
+	}
+
+	if _, err := NewTokenExchanger(&TokenExchangeConfig{TokenURL: "http://idp.example.com/token"}); !errors.Is(err, ErrInvalidTokenExchange) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TokenProfileRFC9068 enforces the JWT profile for the OAuth 2.0 access tokens (RFC 9068)
+const TokenProfileRFC9068 = "rfc9068"
+
+var (
+	ErrInvalidTokenProfile = errors.New("invalid token profile config")
+	ErrTokenProfile        = errors.New("the token does not comply with the access token profile")
+	rfc9068RequiredClaims  = []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"}
+)
+
+// checkTokenProfileConfig verifies the signature config can enforce its token profile. The RFC 9068
+// profile requires the JWT tokens, and the issuer and the audience to be checked.
+func checkTokenProfileConfig(scfg *SignatureConfig) error {
+	switch scfg.TokenProfile {
+	case "":
+		return nil
+	case TokenProfileRFC9068:
+	default:
+		return fmt.Errorf("%w: unknown token_profile %q. Supported values: rfc9068", ErrInvalidTokenProfile, scfg.TokenProfile)
+	}
+	if scfg.TokenFormat != "" {
+		return fmt.Errorf("%w: the token format %q is not allowed", ErrInvalidTokenProfile, scfg.TokenFormat)
+	}
+	if scfg.Issuer == "" {
+		return fmt.Errorf("%w: the issuer is required", ErrInvalidTokenProfile)
+	}
+	if len(scfg.Audience) == 0 {
+		return fmt.Errorf("%w: the audience is required", ErrInvalidTokenProfile)
+	}
+	return nil
+}
+
+// tokenProfileClaimsValidator adds the claim checks of the token profile to the validator. The typ of the
+// profile is checked with the token headers.
+func tokenProfileClaimsValidator(scfg *SignatureConfig, v ClaimsValidator) (ClaimsValidator, error) {
+	if scfg.TokenProfile == "" {
+		return v, nil
+	}
+	if err := checkTokenProfileConfig(scfg); err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) (map[string]interface{}, error) {
+		claims, err := v(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRFC9068Claims(claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}, nil
+}
+
+// checkRFC9068Claims verifies the claims required by RFC 9068 (section 2.2) are present and the scope
+// claim is a space-delimited string
+func checkRFC9068Claims(claims map[string]interface{}) error {
+	var missing []string
+	for _, c := range rfc9068RequiredClaims {
+		v, ok := claims[c]
+		switch c {
+		case "exp", "iat":
+			_, ok = numericClaim(v)
+		case "aud":
+			aud := audienceValues(v)
+			ok = len(aud) > 0 && aud[0] != ""
+		default:
+			s, isString := v.(string)
+			ok = ok && isString && s != ""
+		}
+		if !ok {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing the %s claims", ErrTokenProfile, strings.Join(missing, ", "))
+	}
+
+	if v, ok := claims["scope"]; ok {
+		s, isString := v.(string)
+		if !isString || s == "" {
+			return fmt.Errorf("%w: the scope claim is not a space-delimited string", ErrTokenProfile)
+		}
+		for _, scope := range strings.Split(s, " ") {
+			if scope == "" {
+				return fmt.Errorf("%w: the scope claim has empty scopes", ErrTokenProfile)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestCheckRFC9068Claims(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":       "https://idp.example.com",
+			"exp":       float64(1700000000),
+			"aud":       []interface{}{"https://api.example.com"},
+			"sub":       "alice",
+			"client_id": "s6BhdRkqt3",
+			"iat":       float64(1699996400),
+			"jti":       "dBjftJeZ4CVP",
+			"scope":     "read write",
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		update  func(map[string]interface{})
+		message string
+	}{
+		{
+			name:   "valid",
+			update: func(map[string]interface{}) {},
+		},
+		{
+			name:   "without scope",
+			update: func(c map[string]interface{}) { delete(c, "scope") },
+		},
+		{
+			name: "missing claims",
+			update: func(c map[string]interface{}) {
+				delete(c, "client_id")
+				delete(c, "jti")
+			},
+			message: "missing the client_id, jti claims",
+		},
+		{
+			name:    "empty audience",
+			update:  func(c map[string]interface{}) { c["aud"] = "" },
+			message: "missing the aud claims",
+		},
+		{
+			name:    "numeric subject",
+			update:  func(c map[string]interface{}) { c["sub"] = 42.0 },
+			message: "missing the sub claims",
+		},
+		{
+			name:    "scope array",
+			update:  func(c map[string]interface{}) { c["scope"] = []interface{}{"read"} },
+			message: "the scope claim is not a space-delimited string",
+		},
+		{
+			name:    "empty scopes",
+			update:  func(c map[string]interface{}) { c["scope"] = "read  write" },
+			message: "the scope claim has empty scopes",
+		},
+	} {
+		claims := valid()
+		tc.update(claims)
+		err := checkRFC9068Claims(claims)
+		if tc.message == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrTokenProfile) || !strings.HasSuffix(err.Error(), tc.message) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if res := NewTokenError(err); res.Reason != ReasonInvalidProfile || res.Status != http.StatusUnauthorized {
+			t.Errorf("%s: unexpected error: %+v", tc.name, res)
+		}
+	}
+}
+
+func TestCheckTokenType_rfc9068(t *testing.T) {
+	cfg := &SignatureConfig{TokenProfile: TokenProfileRFC9068}
+	for typ, expected := range map[string]bool{
+		"at+jwt":             true,
+		"application/at+jwt": true,
+		"JWT":                false,
+		"":                   false,
+	} {
+		h := jose.Header{ExtraHeaders: map[jose.HeaderKey]interface{}{jose.HeaderType: typ}}
+		if err := checkTokenType(cfg, h); (err == nil) != expected {
+			t.Errorf("%q: unexpected error: %v", typ, err)
+		}
+	}
+}
+
+func TestCheckTokenProfileConfig(t *testing.T) {
+	for _, tc := range []struct {
+		cfg   SignatureConfig
+		valid bool
+	}{
+		{cfg: SignatureConfig{}, valid: true},
+		{cfg: SignatureConfig{TokenProfile: TokenProfileRFC9068, Issuer: "https://idp.example.com", Audience: []string{"api"}}, valid: true},
+		{cfg: SignatureConfig{TokenProfile: TokenProfileRFC9068, Audience: []string{"api"}}},
+		{cfg: SignatureConfig{TokenProfile: TokenProfileRFC9068, Issuer: "https://idp.example.com"}},
+		{cfg: SignatureConfig{TokenProfile: TokenProfileRFC9068, Issuer: "https://idp.example.com", Audience: []string{"api"}, TokenFormat: TokenFormatPaseto}},
+		{cfg: SignatureConfig{TokenProfile: "rfc7519"}},
+	} {
+		err := checkTokenProfileConfig(&tc.cfg)
+		if tc.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidTokenProfile)) {
+			t.Errorf("%+v: unexpected error: %v", tc.cfg, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package jose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// TokenTypeAccessTokenJWT is the typ of the JWT access tokens (RFC 9068)
+const TokenTypeAccessTokenJWT = "at+jwt"
+
+var ErrInvalidTokenType = errors.New("invalid token type")
+
+// checkTokenType verifies the typ and cty headers of the token are in the allowed ones, so the ID tokens
+// or the refresh tokens sent to the API endpoints are rejected. The missing headers are only accepted if
+// the empty value is allowed. The RFC 9068 profile requires the at+jwt typ.
+func checkTokenType(scfg *SignatureConfig, header jose.Header) error {
+	typ, _ := header.ExtraHeaders[jose.HeaderType].(string)
+	if scfg.TokenProfile == TokenProfileRFC9068 && normalizeMediaType(typ) != TokenTypeAccessTokenJWT {
+		return fmt.Errorf("%w: the typ %q is not at+jwt", ErrInvalidTokenType, typ)
+	}
+	if len(scfg.AllowedTypes) > 0 {
+		if !mediaTypeInSlice(typ, scfg.AllowedTypes) {
+			return fmt.Errorf("%w: the typ %q is not allowed", ErrInvalidTokenType, typ)
+		}
+	}
+	if len(scfg.AllowedContentTypes) > 0 {
+		cty, _ := header.ExtraHeaders[jose.HeaderContentType].(string)
+		if !mediaTypeInSlice(cty, scfg.AllowedContentTypes) {
+			return fmt.Errorf("%w: the cty %q is not allowed", ErrInvalidTokenType, cty)
+		}
+	}
+	return nil
+}
+
+// mediaTypeInSlice compares the media types as RFC 7515 (section 4.1.9) does: ignoring the case and the
+// application/ prefix of the values without any other slash
+func mediaTypeInSlice(v string, allowed []string) bool {
+	v = normalizeMediaType(v)
+	for _, a := range allowed {
+		if normalizeMediaType(a) == v {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeMediaType(v string) string {
+	v = strings.ToLower(v)
+	if strings.HasPrefix(v, "application/") && strings.Count(v, "/") == 1 {
+		return v[len("application/"):]
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package jose
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestCheckTokenType(t *testing.T) {
+	header := func(typ, cty string) jose.Header {
+		h := jose.Header{ExtraHeaders: map[jose.HeaderKey]interface{}{}}
+		if typ != "" {
+			h.ExtraHeaders[jose.HeaderType] = typ
+		}
+		if cty != "" {
+			h.ExtraHeaders[jose.HeaderContentType] = cty
+		}
+		return h
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      SignatureConfig
+		header   jose.Header
+		expected bool
+	}{
+		{
+			name:     "no restriction",
+			header:   header("JWT", ""),
+			expected: true,
+		},
+		{
+			name:     "access token",
+			cfg:      SignatureConfig{AllowedTypes: []string{TokenTypeAccessTokenJWT}},
+			header:   header("at+jwt", ""),
+			expected: true,
+		},
+		{
+			name:     "access token with the media type prefix",
+			cfg:      SignatureConfig{AllowedTypes: []string{TokenTypeAccessTokenJWT}},
+			header:   header("application/AT+JWT", ""),
+			expected: true,
+		},
+		{
+			name:   "id token",
+			cfg:    SignatureConfig{AllowedTypes: []string{TokenTypeAccessTokenJWT}},
+			header: header("JWT", ""),
+		},
+		{
+			name:   "missing typ",
+			cfg:    SignatureConfig{AllowedTypes: []string{TokenTypeAccessTokenJWT}},
+			header: header("", ""),
+		},
+		{
+			name:     "missing typ allowed",
+			cfg:      SignatureConfig{AllowedTypes: []string{TokenTypeAccessTokenJWT, ""}},
+			header:   header("", ""),
+			expected: true,
+		},
+		{
+			name:   "cty",
+			cfg:    SignatureConfig{AllowedContentTypes: []string{"JWT"}},
+			header: header("at+jwt", "json"),
+		},
+	} {
+		err := checkTokenType(&tc.cfg, tc.header)
+		if tc.expected {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidTokenType) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if res := NewTokenError(err); res.Reason != ReasonInvalidTokenType || res.Status != http.StatusUnauthorized {
+			t.Errorf("%s: unexpected error: %+v", tc.name, res)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package jose
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer of the module
+const TracerName = "github.com/DKolibar/krakend-jose"
+
+const (
+	SpanTokenExtraction       = "jose.token_extraction"
+	SpanSignatureVerification = "jose.signature_verification"
+	SpanKeyFetch              = "jose.key_fetch"
+	SpanPolicyEvaluation      = "jose.policy_evaluation"
+	SpanClaimPropagation      = "jose.claim_propagation"
+
+	AttributeKeyID    = "jose.kid"
+	AttributeAlg      = "jose.alg"
+	AttributeIssuer   = "jose.issuer"
+	AttributeCacheHit = "jose.cache_hit"
+
+	// AttributeHTTPURL is the URL of the key set fetched, named after the OpenTelemetry semantic conventions
+	AttributeHTTPURL = "http.url"
+)
+
+// Attribute is a key-value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a span of the tracing hook. Its methods are the subset of the OpenTelemetry span used by the
+// validators.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer is the tracing hook of the validation pipeline. The spans are emitted through the global
+// OpenTelemetry TracerProvider by default, so they are exported as soon as the host application registers
+// its provider.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// NewOTelTracer returns the Tracer emitting the spans with the TracerName tracer of the OpenTelemetry
+// TracerProvider. A nil provider uses the global one.
+func NewOTelTracer(tp trace.TracerProvider) Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return otelTracer{tp.Tracer(TracerName)}
+}
+
+var (
+	tracer   = NewOTelTracer(nil)
+	tracerMu sync.RWMutex
+)
+
+// SetTracer sets the Tracer used by the validators, the JWK clients and the signers. A nil Tracer restores
+// the default one, emitting the spans through the global OpenTelemetry TracerProvider.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = NewOTelTracer(nil)
+	}
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+}
+
+// StartSpan starts a span as a child of the one in the context, if any
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+	return t.Start(ctx, name)
+}
+
+// tracedClaimsValidator adds the extraction and the verification spans to the ClaimsValidator. The request
+// passed to the validator carries the verification span, so the key fetch is traced as its child.
+func tracedClaimsValidator(v ClaimsValidator, cookieKey string) ClaimsValidator {
+	return func(r *http.Request) (map[string]interface{}, error) {
+		_, span := StartSpan(r.Context(), SpanTokenExtraction)
+		r, token := requestToken(r, cookieKey)
+		span.SetAttributes(Attribute{AttributeKeyID, token.KeyID}, Attribute{AttributeAlg, token.Alg})
+		span.End()
+
+		ctx, span := StartSpan(r.Context(), SpanSignatureVerification)
+		defer span.End()
+		span.SetAttributes(Attribute{AttributeKeyID, token.KeyID}, Attribute{AttributeAlg, token.Alg})
+
+		claims, err := v(r.WithContext(ctx))
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if iss, ok := claims["iss"].(string); ok {
+			span.SetAttributes(Attribute{AttributeIssuer, iss})
+		}
+		token.Claims = claims
+		return claims, nil
+	}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttributes(attrs ...Attribute) {
+	if !s.span.IsRecording() {
+		return
+	}
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, otelAttribute(a))
+	}
+	s.span.SetAttributes(kvs...)
+}
+
+// RecordError records the error as an exception event and flags the span as failed
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() { s.span.End() }
+
+func otelAttribute(a Attribute) attribute.KeyValue {
+	key := attribute.Key(a.Key)
+	switch v := a.Value.(type) {
+	case string:
+		return key.String(v)
+	case bool:
+		return key.Bool(v)
+	case int:
+		return key.Int(v)
+	case int64:
+		return key.Int64(v)
+	case float64:
+		return key.Float64(v)
+	case []string:
+		return key.StringSlice(v)
+	default:
+		return key.String(fmt.Sprint(v))
+	}
+}
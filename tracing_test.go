@@ -0,0 +1,306 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/auth0-community/go-auth0"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestNewClaimsValidator_tracing(t *testing.T) {
+	tr := &recordingTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: "", AllowInsecure: true, LocalPath: "./fixtures/symmetric.json"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, err := sp.GetKey("sim2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: key.Key},
+		(&jose.SignerOptions{}).WithHeader("kid", "sim2"),
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	token, err := jwt.Signed(signer).Claims(map[string]interface{}{"iss": "http://example.com", "sub": "1234"}).CompactSerialize()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	validator, err := NewClaimsValidator(&SignatureConfig{
+		Alg:                "HS256",
+		URI:                "",
+		LocalPath:          "./fixtures/symmetric.json",
+		DisableJWKSecurity: true,
+	}, func(string) func(r *http.Request) (*jwt.JSONWebToken, error) {
+		return func(r *http.Request) (*jwt.JSONWebToken, error) { return nil, errors.New("no cookie") }
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = WithRequestToken(req, "")
+	if _, err := validator(req); err != nil {
+		t.Error(err)
+		return
+	}
+	if tok, _ := TokenFromContext(req.Context()); tok.Claims["sub"] != "1234" {
+		t.Errorf("unexpected claims in the context: %v", tok.Claims)
+	}
+
+	extraction := tr.span(SpanTokenExtraction)
+	if extraction == nil || extraction.attrs[AttributeKeyID] != "sim2" || extraction.attrs[AttributeAlg] != "HS256" {
+		t.Errorf("unexpected extraction span: %+v", extraction)
+	}
+	verification := tr.span(SpanSignatureVerification)
+	if verification == nil || verification.attrs[AttributeIssuer] != "http://example.com" || !verification.ended {
+		t.Errorf("unexpected verification span: %+v", verification)
+	}
+	keyFetch := tr.span(SpanKeyFetch)
+	if keyFetch == nil || keyFetch.parent != verification {
+		t.Errorf("unexpected key fetch span: %+v", keyFetch)
+		return
+	}
+	if keyFetch.attrs[AttributeKeyID] != "sim2" || keyFetch.attrs[AttributeCacheHit] != true {
+		t.Errorf("unexpected key fetch attributes: %v", keyFetch.attrs)
+	}
+}
+
+func TestNewOTelTracer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	SetTracer(NewOTelTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))))
+	defer SetTracer(nil)
+
+	server := httptest.NewServer(jwkEndpointWithCounter("symmetric", new(uint32)))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := sp.GetKey("sim2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: key.Key},
+		(&jose.SignerOptions{}).WithHeader("kid", "sim2"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(map[string]interface{}{"iss": "http://example.com", "sub": "1234"}).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := NewClaimsValidator(&SignatureConfig{
+		Alg:                "HS256",
+		URI:                server.URL,
+		DisableJWKSecurity: true,
+		CacheEnabled:       true,
+	}, func(string) func(r *http.Request) (*jwt.JSONWebToken, error) {
+		return func(r *http.Request) (*jwt.JSONWebToken, error) { return nil, errors.New("no cookie") }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if _, err := validator(WithRequestToken(req, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+token[:len(token)-4])
+	if _, err := validator(WithRequestToken(req, "")); err == nil {
+		t.Fatal("the token with an invalid signature has been accepted")
+	}
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		if s.InstrumentationLibrary().Name != TracerName {
+			t.Errorf("unexpected tracer %s", s.InstrumentationLibrary().Name)
+		}
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	for _, name := range []string{SpanTokenExtraction, SpanSignatureVerification, SpanKeyFetch} {
+		if len(spans[name]) < 3 {
+			t.Fatalf("unexpected %s spans: %v", name, spans[name])
+		}
+	}
+
+	// the key set is cached by the first request
+	verification := spans[SpanSignatureVerification][1]
+	var keyFetch sdktrace.ReadOnlySpan
+	for _, s := range spans[SpanKeyFetch] {
+		if s.Parent().SpanID() == verification.SpanContext().SpanID() {
+			keyFetch = s
+		}
+	}
+	if keyFetch == nil {
+		t.Fatal("the key fetch span is not a child of the verification span")
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range append(verification.Attributes(), keyFetch.Attributes()...) {
+		attrs[kv.Key] = kv.Value
+	}
+	for k, want := range map[attribute.Key]attribute.Value{
+		AttributeKeyID:    attribute.StringValue("sim2"),
+		AttributeAlg:      attribute.StringValue("HS256"),
+		AttributeIssuer:   attribute.StringValue("http://example.com"),
+		AttributeCacheHit: attribute.BoolValue(true),
+		AttributeHTTPURL:  attribute.StringValue(server.URL),
+	} {
+		if attrs[k] != want {
+			t.Errorf("unexpected %s attribute: %v", k, attrs[k].Emit())
+		}
+	}
+	if verification.Status().Code != codes.Unset {
+		t.Errorf("unexpected status of the valid token: %v", verification.Status())
+	}
+
+	failed := spans[SpanSignatureVerification][2]
+	if failed.Status().Code != codes.Error {
+		t.Errorf("unexpected status of the invalid token: %v", failed.Status())
+	}
+	if events := failed.Events(); len(events) != 1 || events[0].Name != semconv.ExceptionEventName {
+		t.Errorf("unexpected events of the invalid token: %v", events)
+	}
+}
+
+func TestJWKClient_GetSecret_concurrentCacheHits(t *testing.T) {
+	tr := &recordingTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	server := httptest.NewServer(jwkEndpointWithCounter("symmetric", new(uint32)))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, AllowInsecure: true, CacheEnabled: true, Prefetch: true}, auth0.RequestTokenExtractorFunc(auth0.FromHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		kid := []string{"sim1", "sim2", "unknown"}[i%3]
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.HS256, Key: []byte("secret")},
+			(&jose.SignerOptions{}).WithHeader("kid", kid),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Signed(signer).Claims(map[string]interface{}{"sub": "1234"}).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+token)
+			_, err := sp.GetSecret(req)
+			if (kid == "unknown") != (err != nil) {
+				t.Errorf("%s: unexpected error %v", kid, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, s := range tr.spans {
+		if hit := s.attrs[AttributeCacheHit]; hit != (s.attrs[AttributeKeyID] != "unknown") {
+			t.Errorf("unexpected cache hit of the key %v: %v", s.attrs[AttributeKeyID], hit)
+		}
+	}
+}
+
+func TestTracedClaimsValidator_error(t *testing.T) {
+	tr := &recordingTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	expectedErr := errors.New("invalid token")
+	v := tracedClaimsValidator(func(_ *http.Request) (map[string]interface{}, error) { return nil, expectedErr }, "")
+	if _, err := v(httptest.NewRequest(http.MethodGet, "/", http.NoBody)); err != expectedErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	verification := tr.span(SpanSignatureVerification)
+	if verification == nil || verification.err != expectedErr || !verification.ended {
+		t.Errorf("unexpected verification span: %+v", verification)
+	}
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: map[string]interface{}{}}
+	s.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (r *recordingTracer) span(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+
+func (s *recordedSpan) End() { s.ended = true }
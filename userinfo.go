@@ -0,0 +1,165 @@
+package jose
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const defaultUserInfoCacheTTL = 5 * time.Minute
+
+var (
+	ErrInvalidUserInfo  = errors.New("invalid userinfo config")
+	ErrUserInfoEndpoint = errors.New("userinfo endpoint error")
+	ErrUserInfoRejected = errors.New("the userinfo endpoint rejected the token")
+	ErrUserInfoSubject  = errors.New("the subject of the userinfo does not match the token")
+)
+
+// UserInfoConfig merges the claims returned by the userinfo endpoint of the IdP into the claims of the
+// validated access tokens, so the profile data is available to the policy and propagated to the backends
+// when the access tokens are thin. The responses are cached per token.
+type UserInfoConfig struct {
+	// URL is the userinfo endpoint of the IdP
+	URL string `json:"url"`
+	// Claim is the claim receiving the userinfo, as "userinfo". Without claim, the userinfo is merged into
+	// the claims, and the claims of the token win over the userinfo.
+	Claim string `json:"claim,omitempty"`
+	// Claims restricts the userinfo claims merged into the claims of the token. All of them by default
+	Claims []string `json:"claims,omitempty"`
+	// CacheTTL is the time the responses are cached, in seconds or as "5m", and never beyond the
+	// expiration of the token. Defaults to 5m
+	CacheTTL Seconds `json:"cache_ttl,omitempty"`
+	// CacheSize is the max number of cached responses. Defaults to 1000
+	CacheSize int `json:"cache_size,omitempty"`
+	// Timeout limits the userinfo requests, in seconds or as "2s". Defaults to 10s
+	Timeout Seconds `json:"timeout,omitempty"`
+	// FailOpen accepts the requests with the claims of the token when the userinfo endpoint is not
+	// available, instead of rejecting them. The tokens rejected by the endpoint are always rejected.
+	FailOpen           bool     `json:"fail_open,omitempty"`
+	CipherSuites       []uint16 `json:"cipher_suites,omitempty"`
+	LocalCA            string   `json:"local_ca,omitempty"`
+	DisableURLSecurity bool     `json:"disable_url_security,omitempty"`
+}
+
+// UserInfo fetches the userinfo of the inbound access tokens. A nil UserInfo does nothing.
+type UserInfo struct {
+	cfg    *UserInfoConfig
+	client *http.Client
+	target *ClaimPath
+	ttl    time.Duration
+	cache  *exchangeCache
+	now    func() time.Time
+}
+
+// NewUserInfo returns the UserInfo of the config, or nil if there is no config
+func NewUserInfo(cfg *UserInfoConfig) (*UserInfo, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: the url is required", ErrInvalidUserInfo)
+	}
+	if !validJWKSource(cfg.URL, cfg.DisableURLSecurity) {
+		return nil, fmt.Errorf("%w: %q is not an https URL and disable_url_security is not set", ErrInvalidUserInfo, cfg.URL)
+	}
+	client, err := newOutboundClient(cfg.CipherSuites, cfg.LocalCA, cfg.Timeout.Duration())
+	if err != nil {
+		return nil, err
+	}
+
+	u := &UserInfo{
+		cfg:    cfg,
+		client: client,
+		ttl:    cfg.CacheTTL.Duration(),
+		cache:  newExchangeCache(cfg.CacheSize),
+		now:    time.Now,
+	}
+	if u.ttl == 0 {
+		u.ttl = defaultUserInfoCacheTTL
+	}
+	if cfg.Claim != "" {
+		target := NewClaimPath(cfg.Claim, true)
+		u.target = &target
+	}
+	return u, nil
+}
+
+// Merge returns a copy of the claims with the userinfo of the token carried by the context. The requests
+// without token (as the ones authenticated by the fallbacks) are returned as they are.
+func (u *UserInfo) Merge(ctx context.Context, claims map[string]interface{}) (map[string]interface{}, error) {
+	if u == nil {
+		return claims, nil
+	}
+	t, ok := TokenFromContext(ctx)
+	if !ok || t.Raw == "" {
+		return claims, nil
+	}
+
+	now := u.now()
+	sum := sha256.Sum256([]byte(t.Raw))
+	key := hex.EncodeToString(sum[:])
+	info := map[string]interface{}{}
+	cached, ok := u.cache.get(key, now)
+	if !ok || json.Unmarshal([]byte(cached), &info) != nil {
+		var err error
+		info, err = u.fetch(ctx, t.Raw)
+		if err != nil {
+			if u.cfg.FailOpen && !errors.Is(err, ErrUserInfoRejected) {
+				return claims, nil
+			}
+			return nil, err
+		}
+		// the subject of the userinfo must be the one of the token (OpenID Connect Core, section 5.3.2)
+		if sub, ok := info["sub"]; ok && claims["sub"] != nil && sub != claims["sub"] {
+			return nil, ErrUserInfoSubject
+		}
+		info = filterAttributes(info, u.cfg.Claims)
+
+		expires := now.Add(u.ttl)
+		if exp, ok := numericClaim(claims["exp"]); ok && time.Unix(exp, 0).Before(expires) {
+			expires = time.Unix(exp, 0)
+		}
+		if b, err := json.Marshal(info); err == nil {
+			u.cache.add(key, string(b), expires)
+		}
+	}
+	return mergeAttributes(claims, u.target, info), nil
+}
+
+func (u *UserInfo) fetch(ctx context.Context, token string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUserInfoEndpoint, err.Error())
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrUserInfoRejected
+	default:
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrUserInfoEndpoint, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUserInfoEndpoint, err.Error())
+	}
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("%w: the response is not a JSON object", ErrUserInfoEndpoint)
+	}
+	return info, nil
+}
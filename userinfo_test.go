@@ -0,0 +1,113 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUserInfo_Merge(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.Header.Get("Authorization") {
+		case "Bearer alice-token":
+			fmt.Fprint(w, `{"sub":"alice","email":"alice@example.com","name":"Alice","exp":1}`)
+		case "Bearer stolen-token":
+			fmt.Fprint(w, `{"sub":"mallory","email":"mallory@example.com"}`)
+		case "Bearer down-token":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	u, err := NewUserInfo(&UserInfoConfig{URL: server.URL, DisableURLSecurity: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := func(raw string) context.Context {
+		return context.WithValue(context.Background(), tokenContextKey{}, &Token{Raw: raw})
+	}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	claims, err := u.Merge(ctx("alice-token"), map[string]interface{}{"sub": "alice", "exp": exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["email"] != "alice@example.com" || claims["exp"] != exp {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if _, err := u.Merge(ctx("alice-token"), map[string]interface{}{"sub": "alice", "exp": exp}); err != nil || calls != 1 {
+		t.Errorf("the userinfo should be cached: %v %d", err, calls)
+	}
+
+	if _, err := u.Merge(ctx("stolen-token"), map[string]interface{}{"sub": "alice"}); !errors.Is(err, ErrUserInfoSubject) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = u.Merge(ctx("revoked-token"), map[string]interface{}{"sub": "alice"})
+	if !errors.Is(err, ErrUserInfoRejected) || NewUserInfoError(err).Status != http.StatusUnauthorized {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = u.Merge(ctx("down-token"), map[string]interface{}{"sub": "alice"})
+	if !errors.Is(err, ErrUserInfoEndpoint) || NewUserInfoError(err).Status != http.StatusBadGateway {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if claims, err := u.Merge(context.Background(), map[string]interface{}{"sub": "alice"}); err != nil || len(claims) != 1 {
+		t.Errorf("the requests without token should not be modified: %v %v", claims, err)
+	}
+}
+
+func TestUserInfo_Merge_claim(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer down-token" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"sub":"alice","email":"alice@example.com","phone_number":"+1 555"}`)
+	}))
+	defer server.Close()
+
+	u, err := NewUserInfo(&UserInfoConfig{
+		URL:                server.URL,
+		Claim:              "profile",
+		Claims:             []string{"email"},
+		FailOpen:           true,
+		DisableURLSecurity: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), tokenContextKey{}, &Token{Raw: "alice-token"})
+	claims, err := u.Merge(ctx, map[string]interface{}{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := claims["profile"].(map[string]interface{}); len(profile) != 1 || profile["email"] != "alice@example.com" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	ctx = context.WithValue(context.Background(), tokenContextKey{}, &Token{Raw: "down-token"})
+	if claims, err := u.Merge(ctx, map[string]interface{}{"sub": "alice"}); err != nil || len(claims) != 1 {
+		t.Errorf("the userinfo should fail open: %v %v", claims, err)
+	}
+}
+
+func TestNewUserInfo(t *testing.T) {
+	if u, err := NewUserInfo(nil); u != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", u, err)
+	}
+	for _, cfg := range []UserInfoConfig{
+		{},
+		{URL: "http://idp.example.com/userinfo"},
+	} {
+		if _, err := NewUserInfo(&cfg); !errors.Is(err, ErrInvalidUserInfo) {
+			t.Errorf("%+v: unexpected error: %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package jose
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const defaultWebSocketProtocolMarker = "access_token"
+
+// WebSocketConfig enables the extraction of the tokens sent by the browsers in the Sec-WebSocket-Protocol
+// header of the WebSocket upgrade requests, as the browsers can not set the Authorization header. The
+// claims propagated to headers reach the backend handshake as in any other request.
+type WebSocketConfig struct {
+	// ProtocolMarker is the subprotocol followed by the token, as in "access_token, <token>". The marker is
+	// kept in the header, so the backend can select it. Defaults to access_token
+	ProtocolMarker string `json:"protocol_marker,omitempty"`
+	// ProtocolPrefix enables the subprotocols carrying the token base64url encoded after the prefix, as
+	// "base64url.bearer.authorization.k8s.io.<token>"
+	ProtocolPrefix string `json:"protocol_prefix,omitempty"`
+}
+
+// WebSocketAuth moves the token of the WebSocket upgrade requests from the subprotocols to the
+// Authorization header, so it is validated as any other token and it does not reach the backend handshake
+// as a subprotocol. A nil WebSocketAuth does nothing.
+type WebSocketAuth struct {
+	marker string
+	prefix string
+}
+
+// NewWebSocketAuth returns the WebSocketAuth of the config, or nil if there is no config
+func NewWebSocketAuth(cfg *WebSocketConfig) *WebSocketAuth {
+	if cfg == nil {
+		return nil
+	}
+	w := &WebSocketAuth{marker: cfg.ProtocolMarker, prefix: cfg.ProtocolPrefix}
+	if w.marker == "" {
+		w.marker = defaultWebSocketProtocolMarker
+	}
+	return w
+}
+
+// IsWebSocketUpgrade returns true if the request asks for a WebSocket upgrade
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") && headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// Prepare moves the token of the subprotocols to the Authorization header of the WebSocket upgrade
+// requests without one. It returns true if a token has been moved.
+func (w *WebSocketAuth) Prepare(r *http.Request) bool {
+	if w == nil || !IsWebSocketUpgrade(r) || r.Header.Get("Authorization") != "" {
+		return false
+	}
+
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+
+	token := ""
+	kept := make([]string, 0, len(protocols))
+	for i := 0; i < len(protocols); i++ {
+		p := protocols[i]
+		switch {
+		case token != "":
+		case p == w.marker && i+1 < len(protocols):
+			kept = append(kept, p)
+			i++
+			token = protocols[i]
+			continue
+		case w.prefix != "" && strings.HasPrefix(p, w.prefix):
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p[len(w.prefix):], "="))
+			if err != nil {
+				continue
+			}
+			token = string(b)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if token == "" {
+		return false
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	if len(kept) == 0 {
+		r.Header.Del("Sec-WebSocket-Protocol")
+	} else {
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(kept, ", "))
+	}
+	return true
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
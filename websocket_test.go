@@ -0,0 +1,97 @@
+package jose
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSocketAuth_Prepare(t *testing.T) {
+	w := NewWebSocketAuth(&WebSocketConfig{ProtocolPrefix: "base64url.bearer.authorization.k8s.io."})
+	encoded := base64.RawURLEncoding.EncodeToString([]byte("encoded.token.value"))
+
+	for i, tc := range []struct {
+		upgrade       bool
+		authorization string
+		protocols     []string
+		prepared      bool
+		expectedAuth  string
+		expectedProto string
+	}{
+		{
+			upgrade:       true,
+			protocols:     []string{"chat, access_token, the.token.value"},
+			prepared:      true,
+			expectedAuth:  "Bearer the.token.value",
+			expectedProto: "chat, access_token",
+		},
+		{
+			upgrade:       true,
+			protocols:     []string{"base64url.bearer.authorization.k8s.io." + encoded, "chat"},
+			prepared:      true,
+			expectedAuth:  "Bearer encoded.token.value",
+			expectedProto: "chat",
+		},
+		{
+			upgrade:      true,
+			protocols:    []string{"base64url.bearer.authorization.k8s.io." + encoded},
+			prepared:     true,
+			expectedAuth: "Bearer encoded.token.value",
+		},
+		{
+			upgrade:       true,
+			protocols:     []string{"chat", "access_token"},
+			expectedProto: "chat",
+		},
+		{
+			upgrade:       true,
+			authorization: "Bearer other",
+			protocols:     []string{"access_token, the.token.value"},
+			expectedAuth:  "Bearer other",
+			expectedProto: "access_token, the.token.value",
+		},
+		{
+			protocols:     []string{"access_token, the.token.value"},
+			expectedProto: "access_token, the.token.value",
+		},
+	} {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if tc.upgrade {
+			req.Header.Set("Connection", "keep-alive, Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		for _, p := range tc.protocols {
+			req.Header.Add("Sec-WebSocket-Protocol", p)
+		}
+
+		if prepared := w.Prepare(req); prepared != tc.prepared {
+			t.Errorf("#%d: unexpected result: %v", i, prepared)
+		}
+		if auth := req.Header.Get("Authorization"); auth != tc.expectedAuth {
+			t.Errorf("#%d: unexpected authorization header: %q", i, auth)
+		}
+		if tc.prepared || len(tc.protocols) == 1 {
+			if proto := req.Header.Get("Sec-WebSocket-Protocol"); proto != tc.expectedProto {
+				t.Errorf("#%d: unexpected protocol header: %q", i, proto)
+			}
+		}
+	}
+}
+
+func TestWebSocketAuth_nil(t *testing.T) {
+	w := NewWebSocketAuth(nil)
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Protocol", "access_token, the.token.value")
+
+	if w.Prepare(req) {
+		t.Error("unexpected result")
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("unexpected authorization header: %q", auth)
+	}
+}
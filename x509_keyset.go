@@ -0,0 +1,85 @@
+package jose
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// Formats of the key sets
+const (
+	KeySetFormatJWK  = "jwk"
+	KeySetFormatX509 = "x509"
+)
+
+var ErrInvalidX509KeySet = errors.New("invalid x509 key set")
+
+// x509KeySet converts a key set published as a JSON object of PEM encoded certificates indexed by their
+// key ID, as the Google and Firebase ones, into a JWK set
+func x509KeySet(data []byte) ([]byte, error) {
+	certs := map[string]string{}
+	if err := json.Unmarshal(data, &certs); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidX509KeySet, err.Error())
+	}
+	kids := make([]string, 0, len(certs))
+	for kid := range certs {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keySet := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(kids))}
+	for _, kid := range kids {
+		block, _ := pem.Decode([]byte(certs[kid]))
+		if block == nil {
+			return nil, fmt.Errorf("%w: %s is not PEM encoded", ErrInvalidX509KeySet, kid)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidX509KeySet, kid, err.Error())
+		}
+		keySet.Keys = append(keySet.Keys, jose.JSONWebKey{
+			Key:          cert.PublicKey,
+			KeyID:        kid,
+			Use:          "sig",
+			Certificates: []*x509.Certificate{cert},
+		})
+	}
+	return json.Marshal(keySet)
+}
+
+// x509KeySetTransport converts the x509 key sets downloaded by the JWK clients, so they are processed as
+// any other JWK set
+type x509KeySetTransport struct {
+	next http.RoundTripper
+}
+
+func (t x509KeySetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := x509KeySet(body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(keySet))
+	resp.ContentLength = int64(len(keySet))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(keySet)))
+	return resp, nil
+}
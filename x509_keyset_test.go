@@ -0,0 +1,72 @@
+package jose
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestX509KeySet(t *testing.T) {
+	cert, err := os.ReadFile("cert.pem")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := json.Marshal(map[string]string{"kid-2": string(cert), "kid-1": string(cert)})
+
+	res, err := x509KeySet(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(res, &keySet); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(keySet.Keys) != 2 || keySet.Keys[0].KeyID != "kid-1" || keySet.Keys[1].KeyID != "kid-2" {
+		t.Errorf("unexpected key set: %s", res)
+		return
+	}
+	if !keySet.Keys[0].Valid() || !keySet.Keys[0].IsPublic() || len(keySet.Keys[0].Certificates) != 1 {
+		t.Errorf("unexpected key: %+v", keySet.Keys[0])
+	}
+
+	for _, data := range []string{`{"keys":[]}`, `{"kid":"not a certificate"}`} {
+		if _, err := x509KeySet([]byte(data)); !errors.Is(err, ErrInvalidX509KeySet) {
+			t.Errorf("%s: unexpected error: %v", data, err)
+		}
+	}
+}
+
+func TestSecretProvider_x509(t *testing.T) {
+	cert, err := os.ReadFile("cert.pem")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(map[string]string{"google-kid": string(cert)})
+	}))
+	defer server.Close()
+
+	sp, err := SecretProvider(SecretProviderConfig{URI: server.URL, KeySetFormat: KeySetFormatX509}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, err := sp.GetKey("google-kid")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if key.KeyID != "google-kid" || !key.IsPublic() {
+		t.Errorf("unexpected key: %+v", key)
+	}
+}